@@ -13,6 +13,8 @@ import (
 	"errors"
 	"sync"
 
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+
 	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/crypto.v0/cosi"
 	"gopkg.in/dedis/onet.v1"
@@ -216,25 +218,28 @@ func (bft *ProtocolBFTCoSi) Dispatch() error {
 // Signature will generate the final signature, the output of the BFTCoSi
 // protocol.
 // The signature contains the commit round signature, with the message.
-// If the prepare phase failed, the signature will be nil and the Exceptions
-// will contain the exception from the prepare phase. It can be useful to see
-// which cosigners refused to sign (each exceptions contains the index of a
-// refusing-to-sign signer).
+// If the prepare phase failed, the signature will be nil and the mask will
+// leave out the cosigners that refused to sign in the prepare phase.
 // Expect this function to have an undefined behavior when called from a
 // non-root Node.
 func (bft *ProtocolBFTCoSi) Signature() *BFTSignature {
 	bftSig := &BFTSignature{
-		Sig:        bft.commit.Signature(),
-		Msg:        bft.Msg,
-		Exceptions: nil,
+		Sig: bft.commit.Signature(),
+		Msg: bft.Msg,
 	}
 	if bft.signRefusal {
 		bftSig.Sig = nil
-		bftSig.Exceptions = bft.tempExceptions
+		bftSig.Mask, bftSig.ExceptionCommit = bft.maskExceptions()
 	}
 	return bftSig
 }
 
+// maskExceptions returns the mask of the cosigners but the exceptions of the
+// prepare phase, and the sum of their commitments.
+func (bft *ProtocolBFTCoSi) maskExceptions() (crypto.SignerMask, abstract.Point) {
+	return maskExceptions(bft.Suite(), len(bft.Roster().List), bft.tempExceptions)
+}
+
 // RegisterOnDone registers a callback to call when the bftcosi protocols has
 // really finished
 func (bft *ProtocolBFTCoSi) RegisterOnDone(fn func()) {
@@ -387,9 +392,10 @@ func (bft *ProtocolBFTCoSi) handleChallengeCommit(msg challengeCommitChan) error
 	// verify if the signature is correct
 	data := sha512.Sum512(ch.Signature.Msg)
 	bftPrepareSig := &BFTSignature{
-		Sig:        ch.Signature.Sig,
-		Msg:        data[:],
-		Exceptions: ch.Signature.Exceptions,
+		Sig:             ch.Signature.Sig,
+		Msg:             data[:],
+		Mask:            ch.Signature.Mask,
+		ExceptionCommit: ch.Signature.ExceptionCommit,
 	}
 	if err := bftPrepareSig.Verify(bft.Suite(), bft.Roster().Publics()); err != nil {
 		log.Lvl3(bft.Name(), "Verification of the signature failed:", err)
@@ -397,15 +403,13 @@ func (bft *ProtocolBFTCoSi) handleChallengeCommit(msg challengeCommitChan) error
 	}
 
 	// Check if we have no more than threshold failed nodes
-	if len(ch.Signature.Exceptions) >= int(bft.threshold) {
+	n := len(bft.Roster().List)
+	if refused := ch.Signature.Refused(n); refused >= int(bft.threshold) {
 		log.Lvlf3("%s: More than threshold (%d/%d) refused to sign - aborting.",
-			bft.Roster(), len(ch.Signature.Exceptions), len(bft.Roster().List))
+			bft.Roster(), refused, n)
 		bft.signRefusal = true
 	}
 
-	// store the exceptions for later usage
-	bft.tempExceptions = ch.Signature.Exceptions
-
 	if bft.IsLeaf() {
 		return bft.handleResponseCommit(nil)
 	}
@@ -476,13 +480,14 @@ func (bft *ProtocolBFTCoSi) startChallenge(t RoundType) error {
 		}
 
 		// send challenge + signature
+		sig := &BFTSignature{
+			Msg: bft.Msg,
+			Sig: bft.prepareSignature,
+		}
+		sig.Mask, sig.ExceptionCommit = bft.maskExceptions()
 		cc := &ChallengeCommit{
 			Challenge: ch,
-			Signature: &BFTSignature{
-				Msg:        bft.Msg,
-				Sig:        bft.prepareSignature,
-				Exceptions: bft.tempExceptions,
-			},
+			Signature: sig,
 		}
 		bft.challengeCommitChan <- challengeCommitChan{ChallengeCommit: *cc}
 	}
@@ -528,16 +533,16 @@ func (bft *ProtocolBFTCoSi) handleResponsePrepare(r *Response) error {
 		return err
 	}
 	// replace the old one with the corrected one
-	copy(cosiSig[32:64], correctResponseBuff)
+	copy(cosiSig[bft.Suite().PointLen():], correctResponseBuff)
 	bft.prepareSignature = cosiSig
 
 	// Verify the signature is correct
 	data := sha512.Sum512(bft.Msg)
 	sig := &BFTSignature{
-		Msg:        data[:],
-		Sig:        cosiSig,
-		Exceptions: bft.tempExceptions,
+		Msg: data[:],
+		Sig: cosiSig,
 	}
+	sig.Mask, sig.ExceptionCommit = bft.maskExceptions()
 
 	aggCommit := bft.Suite().Point().Null()
 	for _, c := range bft.tempPrepareCommit {
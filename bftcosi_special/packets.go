@@ -4,6 +4,8 @@ import (
 	"crypto/sha512"
 	"errors"
 
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+
 	"gopkg.in/dedis/onet.v1/network"
 
 	"gopkg.in/dedis/crypto.v0/abstract"
@@ -22,13 +24,17 @@ const (
 )
 
 // BFTSignature is what a bftcosi protocol outputs. It contains the signature,
-// the message and some possible exceptions.
+// the message and the mask of the peers that signed, so that its size
+// doesn't depend on how many refused.
 type BFTSignature struct {
 	// cosi signature
 	Sig []byte
 	Msg []byte
-	// List of peers that did not want to sign.
-	Exceptions []Exception
+	// Mask has the peers that signed, all of them if it is empty.
+	Mask crypto.SignerMask
+	// ExceptionCommit is the sum of the commitments of the peers out of
+	// the mask, none if it is nil.
+	ExceptionCommit abstract.Point
 }
 
 func init() {
@@ -46,7 +52,7 @@ func init() {
 }
 
 // Verify returns whether the verification of the signature succeeds or not.
-// Specifically, it adjusts the signature according to the exception in the
+// Specifically, it adjusts the signature according to the mask of the
 // signature, so it can be verified by dedis/crypto/cosi.
 // publics is a slice of all public signatures, and the msg is the msg
 // being signed.
@@ -62,15 +68,28 @@ func (bs *BFTSignature) Verify(s abstract.Suite, publics []abstract.Point) error
 	// compute the reduced public aggregate key (all - exception)
 	aggReducedPublic := s.Point().Null().Add(s.Point().Null(), aggPublic)
 
-	// compute the aggregate commit of exception
+	if len(bs.Mask) != 0 {
+		if err := crypto.NewSignaturePolicy(s, publics, 0).CheckMask(bs.Mask); err != nil {
+			return err
+		}
+		for i := range publics {
+			if !bs.Mask.Signed(i) {
+				aggReducedPublic.Sub(aggReducedPublic, publics[i])
+			}
+		}
+	}
+	// the aggregate commit of the exceptions
 	aggExCommit := s.Point().Null()
-	for _, ex := range bs.Exceptions {
-		aggExCommit = aggExCommit.Add(aggExCommit, ex.Commitment)
-		aggReducedPublic.Sub(aggReducedPublic, publics[ex.Index])
+	if bs.ExceptionCommit != nil {
+		aggExCommit.Add(aggExCommit, bs.ExceptionCommit)
 	}
 	// get back the commit to recreate  the challenge
+	pointLen, scalarLen := s.PointLen(), s.ScalarLen()
+	if len(bs.Sig) < pointLen+scalarLen {
+		return errors.New("Invalid signature")
+	}
 	origCommit := s.Point()
-	if err := origCommit.UnmarshalBinary(bs.Sig[0:32]); err != nil {
+	if err := origCommit.UnmarshalBinary(bs.Sig[:pointLen]); err != nil {
 		return err
 	}
 
@@ -91,7 +110,7 @@ func (bs *BFTSignature) Verify(s abstract.Suite, publics []abstract.Point) error
 	k := s.Scalar().SetBytes(h.Sum(nil))
 	minusPublic := s.Point().Neg(aggReducedPublic)
 	ka := s.Point().Mul(minusPublic, k)
-	r := s.Scalar().SetBytes(bs.Sig[32:64])
+	r := s.Scalar().SetBytes(bs.Sig[pointLen : pointLen+scalarLen])
 	rb := s.Point().Mul(nil, r)
 	left := s.Point().Add(rb, ka)
 
@@ -103,6 +122,36 @@ func (bs *BFTSignature) Verify(s abstract.Suite, publics []abstract.Point) error
 	return nil
 }
 
+// Refused returns how many of the n peers did not sign.
+func (bs *BFTSignature) Refused(n int) int {
+	if len(bs.Mask) == 0 {
+		return 0
+	}
+	return n - bs.Mask.Count()
+}
+
+// maskExceptions returns the mask of the n peers but the exceptions, and the
+// sum of the commitments of the exceptions.
+func maskExceptions(s abstract.Suite, n int, exceptions []Exception) (crypto.SignerMask, abstract.Point) {
+	excepted := make([]bool, n)
+	commit := s.Point().Null()
+	for _, ex := range exceptions {
+		if ex.Index >= 0 && ex.Index < n {
+			excepted[ex.Index] = true
+		}
+		if ex.Commitment != nil {
+			commit.Add(commit, ex.Commitment)
+		}
+	}
+	mask := crypto.NewSignerMask(n)
+	for i := range excepted {
+		if !excepted[i] {
+			mask.Set(i)
+		}
+	}
+	return mask, commit
+}
+
 // Announce is the struct used during the announcement phase (of both
 // rounds)
 type Announce struct {
@@ -0,0 +1,156 @@
+package byzcoin
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxClientBuckets is how many clients the server keeps the rate of before
+// it forgets the ones that have been idle long enough to be back to their
+// burst.
+const maxClientBuckets = 1024
+
+// AdmissionConfig are the limits on the transactions a Server admits into
+// its pool, see UseAdmission.
+type AdmissionConfig struct {
+	// GlobalTPS is the rate of transactions admitted from all the clients
+	// together, unlimited if 0
+	GlobalTPS float64
+	// ClientTPS is the rate of transactions admitted from every client,
+	// unlimited if 0
+	ClientTPS float64
+	// Burst is how many transactions can be sent at once above the rates,
+	// one second worth if 0
+	Burst int
+}
+
+// AdmissionStats count the transactions offered to a Server.
+type AdmissionStats struct {
+	// Admitted are the transactions added to the pool
+	Admitted int
+	// RateLimited are the transactions rejected by the rate limits
+	RateLimited int
+	// PoolFull are the transactions rejected because the pool was full
+	PoolFull int
+	// Invalid are the transactions dropped because they don't verify
+	// against the state of the server
+	Invalid int
+	// Duplicate are the transactions dropped because they were pending or
+	// in a block already
+	Duplicate int
+	// Queued is the number of transactions pending in the pool
+	Queued int
+}
+
+// BackpressureError is returned for the transactions a server rejected
+// because of its rate limits or because its pool is full. They can be sent
+// again after RetryAfter.
+type BackpressureError struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+// Error implements error.
+func (e *BackpressureError) Error() string {
+	return fmt.Sprintf("%s, retry after %v", e.Reason, e.RetryAfter)
+}
+
+// backpressure returns the BackpressureError of err, nil if it is not one.
+func backpressure(err error) *BackpressureError {
+	var bp *BackpressureError
+	if errors.As(err, &bp) {
+		return bp
+	}
+	return nil
+}
+
+// tokenBucket admits rate transactions per second, and up to burst at once.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket, or nil for no limit if rate is 0.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(burst)
+	if b <= 0 {
+		b = rate
+	}
+	if b < 1 {
+		b = 1
+	}
+	return &tokenBucket{rate: rate, burst: b, tokens: b, last: time.Now()}
+}
+
+// refill adds the tokens earned since the last call and returns how long
+// until there is a token, 0 if there is one already.
+func (b *tokenBucket) refill(now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	// a bucket created after now, e.g. by admit, earned nothing yet
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take takes a token, which must be there.
+func (b *tokenBucket) take() {
+	if b != nil {
+		b.tokens--
+	}
+}
+
+// full returns whether the bucket is back to its burst.
+func (b *tokenBucket) full() bool {
+	return b.tokens >= b.burst
+}
+
+// admit takes a token of the global bucket and of the bucket of the client
+// if both have one, or returns the error telling when to retry. The caller
+// must hold s.enough.L.
+func (s *Server) admit(client string) error {
+	if s.admission.GlobalTPS <= 0 && s.admission.ClientTPS <= 0 {
+		return nil
+	}
+	now := time.Now()
+	var bucket *tokenBucket
+	if s.admission.ClientTPS > 0 {
+		bucket = s.clientBuckets[client]
+		if bucket == nil {
+			if len(s.clientBuckets) >= maxClientBuckets {
+				for c, b := range s.clientBuckets {
+					b.refill(now)
+					if b.full() {
+						delete(s.clientBuckets, c)
+					}
+				}
+			}
+			bucket = newTokenBucket(s.admission.ClientTPS, s.admission.Burst)
+			s.clientBuckets[client] = bucket
+		}
+	}
+	if wait := bucket.refill(now); wait > 0 {
+		return &BackpressureError{Reason: "client rate limit", RetryAfter: wait}
+	}
+	if wait := s.globalBucket.refill(now); wait > 0 {
+		return &BackpressureError{Reason: "global rate limit", RetryAfter: wait}
+	}
+	bucket.take()
+	s.globalBucket.take()
+	return nil
+}
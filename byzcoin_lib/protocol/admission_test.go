@@ -0,0 +1,114 @@
+package byzcoin
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+)
+
+func TestTokenBucket(t *testing.T) {
+	var none *tokenBucket
+	assert.Nil(t, newTokenBucket(0, 10))
+	assert.Equal(t, time.Duration(0), none.refill(time.Now()))
+	none.take()
+
+	// a second worth of burst by default
+	assert.Equal(t, 5.0, newTokenBucket(5, 0).burst)
+	assert.Equal(t, 1.0, newTokenBucket(0.5, 0).burst)
+
+	b := newTokenBucket(10, 2)
+	now := b.last
+	for i := 0; i < 2; i++ {
+		require.Equal(t, time.Duration(0), b.refill(now))
+		b.take()
+	}
+	assert.Equal(t, 100*time.Millisecond, b.refill(now))
+	assert.Equal(t, 50*time.Millisecond, b.refill(now.Add(50*time.Millisecond)))
+	assert.Equal(t, time.Duration(0), b.refill(now.Add(100*time.Millisecond)))
+	assert.False(t, b.full())
+	// the tokens don't pile up above the burst
+	b.refill(now.Add(time.Hour))
+	assert.True(t, b.full())
+	assert.Equal(t, 2.0, b.tokens)
+	// nor do they go away for a time before the last refill
+	b.refill(now)
+	assert.Equal(t, 2.0, b.tokens)
+}
+
+func TestAdmission(t *testing.T) {
+	s := NewByzCoinServer(10, 0, 0)
+	s.UseAdmission(AdmissionConfig{ClientTPS: 5, Burst: 2})
+	txs := NativeTransactions(6)
+	require.Nil(t, s.AddTransactionFrom("a", txs[0]))
+	require.Nil(t, s.AddTransactionFrom("a", txs[1]))
+	bp := backpressure(s.AddTransactionFrom("a", txs[2]))
+	require.NotNil(t, bp)
+	assert.True(t, bp.RetryAfter > 0 && bp.RetryAfter <= 200*time.Millisecond)
+	// the other clients have their own rate
+	require.Nil(t, s.AddTransactionFrom("b", txs[2]))
+	assert.Equal(t, ErrDuplicate, s.AddTransactionFrom("a", txs[0]))
+	time.Sleep(bp.RetryAfter)
+	require.Nil(t, s.AddTransactionFrom("a", txs[3]))
+	assert.Equal(t, AdmissionStats{Admitted: 4, RateLimited: 1, Duplicate: 1,
+		Queued: 4}, s.AdmissionStats())
+
+	// the global rate holds for all the clients together
+	s.UseAdmission(AdmissionConfig{GlobalTPS: 5, Burst: 1})
+	require.Nil(t, s.AddTransactionFrom("a", txs[4]))
+	assert.NotNil(t, backpressure(s.AddTransactionFrom("b", txs[5])))
+
+	// a full pool pushes back too
+	s = NewByzCoinServer(1, 0, 0)
+	for _, tx := range txs[:maxPendingBlocks] {
+		require.Nil(t, s.AddTransaction(tx))
+	}
+	bp = backpressure(s.AddTransaction(txs[maxPendingBlocks]))
+	require.NotNil(t, bp)
+	assert.Equal(t, poolFullRetry, bp.RetryAfter)
+	assert.Equal(t, 1, s.AdmissionStats().PoolFull)
+}
+
+func TestAdmissionForgetsClients(t *testing.T) {
+	s := NewByzCoinServer(10, 0, 0)
+	s.UseAdmission(AdmissionConfig{ClientTPS: 1e6, Burst: 1})
+	s.enough.L.Lock()
+	defer s.enough.L.Unlock()
+	for i := 0; i < maxClientBuckets; i++ {
+		require.Nil(t, s.admit(fmt.Sprint(i)))
+	}
+	assert.Equal(t, maxClientBuckets, len(s.clientBuckets))
+	// the idle clients are back to their burst, and forgotten
+	time.Sleep(time.Millisecond)
+	require.Nil(t, s.admit("new"))
+	assert.Equal(t, 1, len(s.clientBuckets))
+}
+
+func TestClientRetry(t *testing.T) {
+	s := NewByzCoinServer(10, 0, 0)
+	s.UseAdmission(AdmissionConfig{GlobalTPS: 20, Burst: 1})
+	start := time.Now()
+	require.Nil(t, NewClient(s).SubmitTransactions(NativeTransactions(5)))
+	// the client waited for the tokens of the last 4 transactions
+	assert.True(t, time.Since(start) >= 4*40*time.Millisecond)
+	assert.Equal(t, 5, s.Mempool().Len())
+
+	// and so does a network client
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	servers, roster, _ := local.GenTree(2, true)
+	s = NewByzCoinServer(10, 0, 0)
+	s.ListenClientTransactions(servers[0])
+	s.UseAdmission(AdmissionConfig{ClientTPS: 20, Burst: 3})
+	c := NewNetClient(roster, roster.List[1])
+	require.Nil(t, c.SubmitTransactions(NativeTransactions(6)))
+	assert.Equal(t, 6, len(c.submitted))
+	assert.Equal(t, 6, s.Mempool().Len())
+	// a single request is rejected as it is
+	bp := backpressure(c.submitOne(NativeBatch(2, 1)[1], false))
+	require.NotNil(t, bp)
+	assert.True(t, bp.RetryAfter > 0)
+}
@@ -9,7 +9,11 @@ import (
 )
 
 type Tx struct {
-	Hash     string
+	// Hash is the txid, which doesn't cover the witness data
+	Hash string
+	// WitnessHash is the wtxid of a SegWit transaction, empty otherwise
+	WitnessHash string
+	// Size is the size of the serialization, witness data included
 	Size     uint32
 	LockTime uint32
 	Version  uint32
@@ -24,6 +28,8 @@ type TxIn struct {
 	InputVout uint32
 	ScriptSig []byte
 	Sequence  uint32
+	// Witness is the witness stack of a SegWit input
+	Witness [][]byte
 }
 
 type TxOut struct {
@@ -43,7 +49,6 @@ func ParseTxs(txsraw []byte) (txs []*Tx, err error) {
 	txoffset := int(0)
 	for i := range txs {
 		txs[i], txoffset = NewTx(txsraw[offset:])
-		txs[i].Hash = GetShaString(txsraw[offset : offset+txoffset])
 		txs[i].Size = uint32(txoffset)
 		offset += txoffset
 	}
@@ -51,11 +56,21 @@ func ParseTxs(txsraw []byte) (txs []*Tx, err error) {
 	return
 }
 
+// NewTx parses the transaction and sets its hashes. SegWit transactions
+// (BIP 144) have a 0x00 marker and a non-zero flag after the version, and
+// the witness stacks of the inputs before the locktime.
 func NewTx(rawtx []byte) (tx *Tx, offset int) {
 	tx = new(Tx)
 	tx.Version = binary.LittleEndian.Uint32(rawtx[0:4])
 	offset = 4
 
+	segwit := rawtx[offset] == 0 && rawtx[offset+1] != 0
+	if segwit {
+		offset += 2
+	}
+	// the txid is computed without the marker, the flag and the witnesses
+	start := offset
+
 	txincnt, txincntsize := DecodeVariableLengthInteger(rawtx[offset:])
 	offset += txincntsize
 
@@ -79,10 +94,28 @@ func NewTx(rawtx []byte) (tx *Tx, offset int) {
 		tx.TxOuts[i], txoffset = NewTxOut(rawtx[offset:])
 		offset += txoffset
 	}
+	end := offset
+
+	if segwit {
+		for i := range tx.TxIns {
+			tx.TxIns[i].Witness, txoffset = newWitness(rawtx[offset:])
+			offset += txoffset
+		}
+	}
 
 	tx.LockTime = binary.LittleEndian.Uint32(rawtx[offset : offset+4])
 	offset += 4
 
+	if segwit {
+		stripped := make([]byte, 0, 4+end-start+4)
+		stripped = append(stripped, rawtx[0:4]...)
+		stripped = append(stripped, rawtx[start:end]...)
+		stripped = append(stripped, rawtx[offset-4:offset]...)
+		tx.Hash = GetShaString(stripped)
+		tx.WitnessHash = GetShaString(rawtx[:offset])
+	} else {
+		tx.Hash = GetShaString(rawtx[:offset])
+	}
 	return
 }
 
@@ -103,6 +136,21 @@ func NewTxIn(txinraw []byte) (txin *TxIn, offset int) {
 	return
 }
 
+// newWitness parses the witness stack of an input: the number of items,
+// then each item prefixed by its length.
+func newWitness(raw []byte) (witness [][]byte, offset int) {
+	cnt, cntsize := DecodeVariableLengthInteger(raw)
+	offset = cntsize
+	witness = make([][]byte, cnt)
+	for i := range witness {
+		size, sizesize := DecodeVariableLengthInteger(raw[offset:])
+		offset += sizesize
+		witness[i] = raw[offset : offset+size]
+		offset += size
+	}
+	return
+}
+
 func NewTxOut(txoutraw []byte) (txout *TxOut, offset int) {
 	txout = new(TxOut)
 	txout.Value = binary.LittleEndian.Uint64(txoutraw[0:8])
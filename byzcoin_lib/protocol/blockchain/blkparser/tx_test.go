@@ -0,0 +1,74 @@
+package blkparser
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testMsgTx returns a transaction of two inputs, with witness stacks if
+// segwit, and an output.
+func testMsgTx(segwit bool) *wire.MsgTx {
+	msg := wire.NewMsgTx(2)
+	for i := 0; i < 2; i++ {
+		in := wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{byte(i + 1)}, uint32(i)),
+			[]byte{0x51}, nil)
+		if segwit {
+			in.Witness = wire.TxWitness{[]byte{1, 2, 3}, bytes.Repeat([]byte{byte(i)}, 300)}
+		}
+		msg.AddTxIn(in)
+	}
+	msg.AddTxOut(wire.NewTxOut(1000, []byte{0x76, 0xa9}))
+	msg.LockTime = 42
+	return msg
+}
+
+func TestNewTx(t *testing.T) {
+	for _, segwit := range []bool{false, true} {
+		msg := testMsgTx(segwit)
+		var buf bytes.Buffer
+		require.Nil(t, msg.Serialize(&buf))
+		// followed by another transaction in a block
+		raw := append(buf.Bytes(), 0xff, 0xff)
+		tx, size := NewTx(raw)
+		assert.Equal(t, buf.Len(), size)
+		assert.Equal(t, msg.TxHash().String(), tx.Hash)
+		assert.Equal(t, uint32(2), tx.Version)
+		assert.Equal(t, uint32(42), tx.LockTime)
+		require.Equal(t, 2, len(tx.TxIns))
+		assert.Equal(t, uint32(1), tx.TxIns[1].InputVout)
+		assert.Equal(t, msg.TxIn[1].PreviousOutPoint.Hash.String(), tx.TxIns[1].InputHash)
+		require.Equal(t, 1, len(tx.TxOuts))
+		assert.Equal(t, uint64(1000), tx.TxOuts[0].Value)
+		if !segwit {
+			assert.Equal(t, "", tx.WitnessHash)
+			assert.Nil(t, tx.TxIns[0].Witness)
+			continue
+		}
+		assert.Equal(t, msg.WitnessHash().String(), tx.WitnessHash)
+		assert.NotEqual(t, tx.Hash, tx.WitnessHash)
+		assert.Equal(t, [][]byte(msg.TxIn[1].Witness), tx.TxIns[1].Witness)
+	}
+}
+
+func TestParseTxs(t *testing.T) {
+	var raw bytes.Buffer
+	raw.WriteByte(2)
+	var msgs []*wire.MsgTx
+	for _, segwit := range []bool{true, false} {
+		msg := testMsgTx(segwit)
+		require.Nil(t, msg.Serialize(&raw))
+		msgs = append(msgs, msg)
+	}
+	txs, err := ParseTxs(raw.Bytes())
+	require.Nil(t, err)
+	require.Equal(t, 2, len(txs))
+	for i, tx := range txs {
+		assert.Equal(t, msgs[i].TxHash().String(), tx.Hash)
+		assert.Equal(t, uint32(msgs[i].SerializeSize()), tx.Size)
+	}
+}
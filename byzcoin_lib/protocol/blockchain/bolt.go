@@ -0,0 +1,230 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"errors"
+
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+var (
+	// blocksBucket maps the header hashes to the encoded blocks
+	blocksBucket = []byte("blocks")
+	// indexBucket maps the big endian indexes to the header hashes, so
+	// that the last key is the latest block
+	indexBucket = []byte("index")
+	// chainsBucket holds a bucket by genesis ID, with the blocks and the
+	// index buckets of that chain
+	chainsBucket = []byte("chains")
+)
+
+// BoltStore is a Store keeping the blocks in a BoltDB file.
+type BoltStore struct {
+	db *bolt.DB
+	// chain is the genesis ID of the chain of the store, nil for a store
+	// opened by NewBoltStore
+	chain []byte
+}
+
+// BoltDB is a BoltDB file holding the blocks of several chains, each in its
+// own namespace keyed by the ID of the chain, the hash of its genesis block.
+type BoltDB struct {
+	db *bolt.DB
+}
+
+// OpenBoltDB opens the BoltDB file at path, creating it if needed.
+func OpenBoltDB(path string) (*BoltDB, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(chainsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltDB{db: db}, nil
+}
+
+// Chain returns the store of the chain with the genesis ID, creating its
+// namespace if needed. The first block put in a new chain must be its
+// genesis block. Closing the store doesn't close the file.
+func (d *BoltDB) Chain(genesisID string) (*BoltStore, error) {
+	if genesisID == "" {
+		return nil, errors.New("empty genesis ID")
+	}
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		chain, err := tx.Bucket(chainsBucket).CreateBucketIfNotExists([]byte(genesisID))
+		if err != nil {
+			return err
+		}
+		for _, name := range [][]byte{blocksBucket, indexBucket} {
+			if _, err := chain.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStore{db: d.db, chain: []byte(genesisID)}, nil
+}
+
+// Chains returns the genesis IDs of the chains in the file.
+func (d *BoltDB) Chains() ([]string, error) {
+	var ids []string
+	err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(chainsBucket).ForEach(func(k, _ []byte) error {
+			ids = append(ids, string(k))
+			return nil
+		})
+	})
+	return ids, err
+}
+
+// Close closes the file, and so all the stores of its chains.
+func (d *BoltDB) Close() error {
+	return d.db.Close()
+}
+
+// NewBoltStore opens the BoltDB file at path, creating it if needed.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{blocksBucket, indexBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Put implements Store.
+func (s *BoltStore) Put(b *TrBlock) error {
+	buf, err := network.Marshal(b)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		blocks, index := s.buckets(tx)
+		if blocks.Get([]byte(b.HeaderHash)) != nil {
+			return errors.New("block " + b.HeaderHash + " already stored")
+		}
+		next := uint64(0)
+		if k, _ := index.Cursor().Last(); k != nil {
+			next = binary.BigEndian.Uint64(k) + 1
+		}
+		if next == 0 && s.chain != nil && b.HeaderHash != string(s.chain) {
+			return errors.New("block " + b.HeaderHash + " is not the genesis block of the chain")
+		}
+		if err := blocks.Put([]byte(b.HeaderHash), buf); err != nil {
+			return err
+		}
+		return index.Put(indexKey(next), []byte(b.HeaderHash))
+	})
+}
+
+// GetByHash implements Store.
+func (s *BoltStore) GetByHash(hash string) (*TrBlock, error) {
+	var b *TrBlock
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		b, err = s.getBlock(tx, []byte(hash))
+		return err
+	})
+	return b, err
+}
+
+// GetByIndex implements Store.
+func (s *BoltStore) GetByIndex(index int) (*TrBlock, error) {
+	if index < 0 {
+		return nil, ErrBlockNotFound
+	}
+	var b *TrBlock
+	err := s.db.View(func(tx *bolt.Tx) error {
+		_, idx := s.buckets(tx)
+		hash := idx.Get(indexKey(uint64(index)))
+		if hash == nil {
+			return ErrBlockNotFound
+		}
+		var err error
+		b, err = s.getBlock(tx, hash)
+		return err
+	})
+	return b, err
+}
+
+// LatestBlock implements Store.
+func (s *BoltStore) LatestBlock() (*TrBlock, int, error) {
+	var b *TrBlock
+	index := -1
+	err := s.db.View(func(tx *bolt.Tx) error {
+		_, idx := s.buckets(tx)
+		k, hash := idx.Cursor().Last()
+		if k == nil {
+			return ErrBlockNotFound
+		}
+		index = int(binary.BigEndian.Uint64(k))
+		var err error
+		b, err = s.getBlock(tx, hash)
+		return err
+	})
+	return b, index, err
+}
+
+// Close implements Store. The store of a chain of a BoltDB leaves the file
+// open, see BoltDB.Close.
+func (s *BoltStore) Close() error {
+	if s.chain != nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// buckets returns the blocks and the index buckets of the chain of the store.
+func (s *BoltStore) buckets(tx *bolt.Tx) (blocks, index *bolt.Bucket) {
+	if s.chain == nil {
+		return tx.Bucket(blocksBucket), tx.Bucket(indexBucket)
+	}
+	chain := tx.Bucket(chainsBucket).Bucket(s.chain)
+	return chain.Bucket(blocksBucket), chain.Bucket(indexBucket)
+}
+
+// getBlock decodes the block with the hash.
+func (s *BoltStore) getBlock(tx *bolt.Tx, hash []byte) (*TrBlock, error) {
+	blocks, _ := s.buckets(tx)
+	buf := blocks.Get(hash)
+	if buf == nil {
+		return nil, ErrBlockNotFound
+	}
+	_, msg, err := network.Unmarshal(buf)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := msg.(*TrBlock)
+	if !ok {
+		return nil, errors.New("stored data is not a block")
+	}
+	return b, nil
+}
+
+// indexKey returns the key of the index in indexBucket.
+func indexKey(index uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, index)
+	return key
+}
@@ -0,0 +1,128 @@
+package blockchain
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/edwards"
+)
+
+func TestBoltStorePutGet(t *testing.T) {
+	s, err := NewBoltStore(filepath.Join(t.TempDir(), "blocks.db"))
+	require.Nil(t, err)
+	defer s.Close()
+
+	_, _, err = s.LatestBlock()
+	require.Equal(t, ErrBlockNotFound, err)
+	blocks := GenerateChain(GeneratorConfig{Seed: 1, MinTxs: 1, MaxTxs: 5}, 3)
+	for i, b := range blocks {
+		require.Nil(t, s.Put(b))
+		latest, index, err := s.LatestBlock()
+		require.Nil(t, err)
+		require.Equal(t, i, index)
+		require.Equal(t, b.HeaderHash, latest.HeaderHash)
+	}
+	require.NotNil(t, s.Put(blocks[1]), "stored a block twice")
+
+	for i, b := range blocks {
+		got, err := s.GetByHash(b.HeaderHash)
+		require.Nil(t, err)
+		require.Equal(t, b.HeaderHash, got.HeaderHash)
+		require.Equal(t, len(b.Txs), len(got.Txs))
+		got, err = s.GetByIndex(i)
+		require.Nil(t, err)
+		require.Equal(t, b.HeaderHash, got.HeaderHash)
+	}
+	_, err = s.GetByHash("unknown")
+	require.Equal(t, ErrBlockNotFound, err)
+	_, err = s.GetByIndex(len(blocks))
+	require.Equal(t, ErrBlockNotFound, err)
+	_, err = s.GetByIndex(-1)
+	require.Equal(t, ErrBlockNotFound, err)
+}
+
+func TestBoltStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocks.db")
+	blocks := GenerateChain(GeneratorConfig{Seed: 2, MinTxs: 1, MaxTxs: 5}, 4)
+	s, err := NewBoltStore(path)
+	require.Nil(t, err)
+	for _, b := range blocks[:2] {
+		require.Nil(t, s.Put(b))
+	}
+	require.Nil(t, s.Close())
+
+	// the blocks are still there, and the next ones go on the index
+	s, err = NewBoltStore(path)
+	require.Nil(t, err)
+	latest, index, err := s.LatestBlock()
+	require.Nil(t, err)
+	require.Equal(t, 1, index)
+	require.Equal(t, blocks[1].HeaderHash, latest.HeaderHash)
+	for _, b := range blocks[2:] {
+		require.Nil(t, s.Put(b))
+	}
+	require.Nil(t, s.Close())
+
+	s, err = NewBoltStore(path)
+	require.Nil(t, err)
+	defer s.Close()
+	it := NewIterator(s, 0)
+	for i := range blocks {
+		require.True(t, it.Next())
+		require.Equal(t, i, it.Index())
+		require.Equal(t, blocks[i].HeaderHash, it.Block().HeaderHash)
+	}
+	require.False(t, it.Next())
+	require.Nil(t, it.Err())
+}
+
+func TestBoltDBChains(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chains.db")
+	suite := edwards.NewAES128SHA256Ed25519(false)
+	publics := []abstract.Point{suite.Point().Base()}
+	genesis := []*TrBlock{
+		NewGenesisBlock(publics, 0, []byte{1}, ChainPolicy{}),
+		NewGenesisBlock(publics, 1, []byte{1}, ChainPolicy{}),
+	}
+	blocks := GenerateChain(GeneratorConfig{Seed: 3, MinTxs: 1, MaxTxs: 5}, 2)
+
+	db, err := OpenBoltDB(path)
+	require.Nil(t, err)
+	for i, g := range genesis {
+		s, err := db.Chain(g.HeaderHash)
+		require.Nil(t, err)
+		require.NotNil(t, s.Put(blocks[i]), "the first block isn't the genesis block")
+		require.NotNil(t, s.Put(genesis[1-i]), "the genesis block of another chain")
+		require.Nil(t, s.Put(g))
+		require.Nil(t, s.Put(blocks[i]))
+		require.Nil(t, s.Close())
+	}
+	_, err = db.Chain("")
+	require.NotNil(t, err)
+	require.Nil(t, db.Close())
+
+	db, err = OpenBoltDB(path)
+	require.Nil(t, err)
+	defer db.Close()
+	ids, err := db.Chains()
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{genesis[0].HeaderHash, genesis[1].HeaderHash}, ids)
+	for i, g := range genesis {
+		s, err := db.Chain(g.HeaderHash)
+		require.Nil(t, err)
+		latest, index, err := s.LatestBlock()
+		require.Nil(t, err)
+		require.Equal(t, 1, index)
+		require.Equal(t, blocks[i].HeaderHash, latest.HeaderHash)
+		// every chain only holds its own blocks
+		_, err = s.GetByHash(blocks[1-i].HeaderHash)
+		require.Equal(t, ErrBlockNotFound, err)
+		// and keeps its configuration
+		first, err := s.GetByIndex(0)
+		require.Nil(t, err)
+		require.Equal(t, g.HeaderHash, first.HeaderHash)
+		require.Equal(t, g.Genesis, first.Genesis)
+	}
+}
@@ -0,0 +1,189 @@
+package blockchain
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// Check verifies the transactions of a block on top of CheckBlock, and
+// returns an error for the first invalid one, a TxError naming it.
+type Check func(b *TrBlock) error
+
+// TxError is the error of a check for an invalid transaction of a block, so
+// that the leader can drop it from the block.
+type TxError struct {
+	// Hash is the hash of the invalid transaction
+	Hash string
+	// Reason tells why it is invalid
+	Reason string
+}
+
+func (e *TxError) Error() string {
+	return fmt.Sprintf("transaction %s: %s", e.Hash, e.Reason)
+}
+
+// txError returns the TxError of the transaction of the hash.
+func txError(hash string, format string, args ...interface{}) error {
+	return &TxError{Hash: hash, Reason: fmt.Sprintf(format, args...)}
+}
+
+// State tells which outputs the transactions can spend, e.g. an UTXOSet.
+type State interface {
+	Unspent(id string) bool
+}
+
+// checks are the registered checks, by name.
+var checks = struct {
+	sync.Mutex
+	m map[string]Check
+}{m: make(map[string]Check)}
+
+func init() {
+	RegisterCheck("scripts", CheckScripts)
+	RegisterCheck("signatures", CheckSignatures)
+	RegisterCheck("doublespend", DoubleSpendCheck(nil))
+}
+
+// RegisterCheck makes the check available under the name, replacing the
+// check registered under the same name, if any.
+func RegisterCheck(name string, c Check) {
+	checks.Lock()
+	defer checks.Unlock()
+	checks.m[name] = c
+}
+
+// GetCheck returns the check registered under the name.
+func GetCheck(name string) (Check, error) {
+	checks.Lock()
+	defer checks.Unlock()
+	c, ok := checks.m[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown check %q", name)
+	}
+	return c, nil
+}
+
+// Checks returns the names of the registered checks, sorted.
+func Checks() []string {
+	checks.Lock()
+	defer checks.Unlock()
+	names := make([]string, 0, len(checks.m))
+	for name := range checks.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckScripts checks that the output scripts can be parsed, and that the
+// input scripts only push data, as required for standard transactions. The
+// script of a coinbase input is free.
+func CheckScripts(b *TrBlock) error {
+	for _, tx := range b.Txs {
+		for i, in := range tx.TxIns {
+			if in.InputVout == coinbaseVout {
+				continue
+			}
+			if !txscript.IsPushOnlyScript(in.ScriptSig) {
+				return txError(tx.Hash, "input %d doesn't only push data", i)
+			}
+		}
+		for i, out := range tx.TxOuts {
+			if _, err := txscript.DisasmString(out.Pkscript); err != nil {
+				return txError(tx.Hash, "output %d: %v", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// CheckSignatures checks the encoding of the signatures and of the public
+// keys of the inputs spending a public key or its hash, with the signature
+// and the key in the input script or in the witness. Since the scripts of
+// the spent outputs are not known, the signatures themselves can't be
+// verified, and the other inputs are not checked.
+func CheckSignatures(b *TrBlock) error {
+	for _, tx := range b.Txs {
+		for i, in := range tx.TxIns {
+			if in.InputVout == coinbaseVout {
+				continue
+			}
+			pushes := in.Witness
+			if len(pushes) == 0 {
+				var err error
+				if pushes, err = txscript.PushedData(in.ScriptSig); err != nil {
+					return txError(tx.Hash, "input %d: %v", i, err)
+				}
+			}
+			if err := checkSignature(pushes); err != nil {
+				return txError(tx.Hash, "input %d: %v", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkSignature checks the signature of a single push that looks like one,
+// or the signature and the public key of two pushes whose second one looks
+// like a key.
+func checkSignature(pushes [][]byte) error {
+	switch {
+	case len(pushes) == 1 && len(pushes[0]) > 0 && pushes[0][0] == 0x30:
+	case len(pushes) == 2 && isPubKey(pushes[1]):
+		if _, err := btcec.ParsePubKey(pushes[1]); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	sig := pushes[0]
+	if len(sig) == 0 {
+		return fmt.Errorf("empty signature")
+	}
+	// the last byte is the type of the signature hash
+	_, err := ecdsa.ParseSignature(sig[:len(sig)-1])
+	return err
+}
+
+// isPubKey tells whether the data has the size and the prefix of a public
+// key, compressed or not.
+func isPubKey(data []byte) bool {
+	switch len(data) {
+	case 33:
+		return data[0] == 0x02 || data[0] == 0x03
+	case 65:
+		return data[0] == 0x04 || data[0] == 0x06 || data[0] == 0x07
+	}
+	return false
+}
+
+// DoubleSpendCheck returns a check that the transactions don't spend an
+// output twice, and, if state isn't nil, that they only spend unspent
+// outputs of the state or outputs of earlier transactions of the block.
+func DoubleSpendCheck(state State) Check {
+	return func(b *TrBlock) error {
+		created := make(map[string]bool)
+		used := make(map[string]bool)
+		for _, tx := range b.Txs {
+			for _, o := range NewBitcoinTx(tx).Spends() {
+				id := o.ID()
+				if used[id] {
+					return txError(tx.Hash, "double spends %s in the block", id)
+				}
+				used[id] = true
+				if state != nil && !created[id] && !state.Unspent(id) {
+					return txError(tx.Hash, "spends unknown or spent output %s", id)
+				}
+			}
+			for i := range tx.TxOuts {
+				created[UTXOID(tx.Hash, uint32(i))] = true
+			}
+		}
+		return nil
+	}
+}
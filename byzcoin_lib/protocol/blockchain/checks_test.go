@@ -0,0 +1,114 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBlock returns a block of the transactions.
+func testBlock(txs ...blkparser.Tx) *TrBlock {
+	tl := NewTransactionList(txs, len(txs))
+	return NewTrBlock(tl, NewHeader(tl, "", ""))
+}
+
+// invalidTx returns the hash of the transaction a check rejected.
+func invalidTx(t *testing.T, err error) string {
+	require.NotNil(t, err)
+	e, ok := err.(*TxError)
+	require.True(t, ok, "not a TxError: %v", err)
+	return e.Hash
+}
+
+func TestRegisterCheck(t *testing.T) {
+	assert.Equal(t, []string{"doublespend", "scripts", "signatures"}, Checks())
+	_, err := GetCheck("none")
+	assert.NotNil(t, err)
+
+	RegisterCheck("fails", func(*TrBlock) error { return txError("x", "always") })
+	defer func() {
+		checks.Lock()
+		delete(checks.m, "fails")
+		checks.Unlock()
+	}()
+	c, err := GetCheck("fails")
+	require.Nil(t, err)
+	assert.Equal(t, "x", invalidTx(t, c(testBlock())))
+	assert.Contains(t, Checks(), "fails")
+}
+
+func TestCheckScripts(t *testing.T) {
+	push, err := txscript.NewScriptBuilder().AddData([]byte{1, 2, 3}).Script()
+	require.Nil(t, err)
+	valid := testTx("a", 1, Outpoint{"x", 0})
+	valid.TxIns[0].ScriptSig = push
+	valid.TxOuts[0].Pkscript = []byte{txscript.OP_DUP, txscript.OP_HASH160}
+	// the script of a coinbase input isn't checked
+	coinbase := testTx("c", 1, Outpoint{"", coinbaseVout})
+	coinbase.TxIns[0].ScriptSig = []byte{txscript.OP_DUP}
+	assert.Nil(t, CheckScripts(testBlock(valid, coinbase)))
+
+	notPush := testTx("b", 1, Outpoint{"x", 0})
+	notPush.TxIns[0].ScriptSig = []byte{txscript.OP_DUP}
+	assert.Equal(t, "b", invalidTx(t, CheckScripts(testBlock(valid, notPush))))
+	// a push of more bytes than the script has
+	truncated := testTx("d", 1)
+	truncated.TxOuts[0].Pkscript = []byte{txscript.OP_DATA_5, 1}
+	assert.Equal(t, "d", invalidTx(t, CheckScripts(testBlock(truncated))))
+}
+
+func TestCheckSignatures(t *testing.T) {
+	priv, err := btcec.NewPrivateKey()
+	require.Nil(t, err)
+	hash := sha256.Sum256([]byte("tx"))
+	sig := append(ecdsa.Sign(priv, hash[:]).Serialize(), byte(txscript.SigHashAll))
+	pub := priv.PubKey().SerializeCompressed()
+	input := func(hash string, pushes ...[]byte) blkparser.Tx {
+		tx := testTx(hash, 1, Outpoint{"x", 0})
+		b := txscript.NewScriptBuilder()
+		for _, p := range pushes {
+			b.AddData(p)
+		}
+		script, err := b.Script()
+		require.Nil(t, err)
+		tx.TxIns[0].ScriptSig = script
+		return tx
+	}
+	witness := testTx("w", 1, Outpoint{"x", 0})
+	witness.TxIns[0].Witness = [][]byte{sig, pub}
+	// the other inputs can't be checked
+	other := input("o", []byte{1, 2}, []byte{3})
+	assert.Nil(t, CheckSignatures(testBlock(input("a", sig, pub), input("b", sig),
+		witness, other)))
+
+	badSig := append([]byte{0x30, 1, 2}, byte(txscript.SigHashAll))
+	assert.Equal(t, "c", invalidTx(t, CheckSignatures(testBlock(input("c", badSig)))))
+	assert.Equal(t, "d", invalidTx(t, CheckSignatures(testBlock(input("d", badSig, pub)))))
+	badKey := append([]byte{0x02}, make([]byte, 32)...)
+	for i := range badKey[1:] {
+		badKey[i+1] = 0xff
+	}
+	assert.Equal(t, "e", invalidTx(t, CheckSignatures(testBlock(input("e", sig, badKey)))))
+	witness.TxIns[0].Witness = [][]byte{badSig, pub}
+	assert.Equal(t, "w", invalidTx(t, CheckSignatures(testBlock(witness))))
+}
+
+func TestDoubleSpendCheck(t *testing.T) {
+	spendA := testTx("x", 10, Outpoint{"a", 0})
+	twice := testTx("y", 10, Outpoint{"a", 0})
+	assert.Nil(t, DoubleSpendCheck(nil)(testBlock(spendA)))
+	assert.Equal(t, "y", invalidTx(t, DoubleSpendCheck(nil)(testBlock(spendA, twice))))
+
+	check := DoubleSpendCheck(testSet())
+	// an output of an earlier transaction of the block can be spent
+	assert.Nil(t, check(testBlock(spendA, testTx("z", 10, Outpoint{"x", 0}))))
+	assert.Equal(t, "u", invalidTx(t, check(testBlock(testTx("u", 1, Outpoint{"c", 0})))))
+	// but not of a later one
+	assert.Equal(t, "z", invalidTx(t, check(testBlock(testTx("z", 10, Outpoint{"x", 0}), spendA))))
+}
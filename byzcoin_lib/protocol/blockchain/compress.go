@@ -0,0 +1,118 @@
+package blockchain
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/golang/snappy"
+	"gopkg.in/dedis/onet.v1/network"
+	"gopkg.in/dedis/onet.v1/simul/monitor"
+)
+
+// The codecs of Compression.
+const (
+	// NoCompression sends the blocks as they are
+	NoCompression = ""
+	// Snappy is fast, at the cost of larger blocks
+	Snappy = "snappy"
+	// Flate compresses at the Level of the Compression
+	Flate = "flate"
+)
+
+// Compression is how the protocols compress the blocks they announce. The
+// receivers decompress them whatever the codec, so only the nodes sending
+// the blocks need it.
+type Compression struct {
+	// Codec is Snappy, Flate or NoCompression
+	Codec string
+	// Level is the flate level, from flate.BestSpeed to
+	// flate.BestCompression, flate.DefaultCompression if 0
+	Level int
+}
+
+// PackedBlock is a block as sent by the protocols: either the block itself,
+// or its compressed encoding.
+type PackedBlock struct {
+	Block *TrBlock
+	// Codec is the codec Data is compressed with
+	Codec string
+	Data  []byte
+}
+
+// Pack returns the block compressed with the codec, and records the sizes
+// of the block before and after compression in the "block_bytes" and
+// "block_bytes_compressed" measures.
+func (c Compression) Pack(b *TrBlock) (*PackedBlock, error) {
+	if c.Codec == NoCompression {
+		// the network can't encode nil slices
+		return &PackedBlock{Block: b, Data: []byte{}}, nil
+	}
+	raw, err := network.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	switch c.Codec {
+	case Snappy:
+		data = snappy.Encode(nil, raw)
+	case Flate:
+		level := c.Level
+		if level == 0 {
+			level = flate.DefaultCompression
+		}
+		var buf bytes.Buffer
+		w, err := flate.NewWriter(&buf, level)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(raw); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		data = buf.Bytes()
+	default:
+		return nil, fmt.Errorf("unknown compression %q", c.Codec)
+	}
+	monitor.RecordSingleMeasure("block_bytes", float64(len(raw)))
+	monitor.RecordSingleMeasure("block_bytes_compressed", float64(len(data)))
+	return &PackedBlock{Codec: c.Codec, Data: data}, nil
+}
+
+// Unpack returns the block, decompressing it if needed.
+func (pb *PackedBlock) Unpack() (*TrBlock, error) {
+	if pb.Codec == NoCompression {
+		if pb.Block == nil {
+			return nil, errors.New("packed block without block")
+		}
+		return pb.Block, nil
+	}
+	var raw []byte
+	var err error
+	switch pb.Codec {
+	case Snappy:
+		raw, err = snappy.Decode(nil, pb.Data)
+	case Flate:
+		r := flate.NewReader(bytes.NewReader(pb.Data))
+		raw, err = ioutil.ReadAll(r)
+		r.Close()
+	default:
+		err = fmt.Errorf("unknown compression %q", pb.Codec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, msg, err := network.Unmarshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := msg.(*TrBlock)
+	if !ok {
+		return nil, errors.New("packed data is not a block")
+	}
+	return b, nil
+}
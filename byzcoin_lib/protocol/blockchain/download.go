@@ -0,0 +1,201 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// BlockFileSHA256 is the hex encoded SHA-256 of the benchmark block file.
+const BlockFileSHA256 = "fe4b764e0ce523cfce6d9e4f326e0d0977ee317a51b4a129cda707cfa4699dd7"
+
+// blockFileName is the name of the benchmark block file.
+const blockFileName = "blk00000.dat"
+
+// DefaultMirrors are the URLs the benchmark block file is downloaded from.
+var DefaultMirrors = []string{"https://pop.dedis.ch/" + blockFileName}
+
+// Downloader fetches the benchmark block file from a list of mirrors. An
+// interrupted download is kept next to the file, with the ".part" suffix,
+// and resumed from where it stopped, by the same or another mirror.
+type Downloader struct {
+	// Mirrors are the URLs of the file, tried in order until one gives a
+	// file with the right checksum
+	Mirrors []string
+	// SHA256 is the hex encoded checksum of the file, not checked if empty
+	SHA256 string
+	Client *http.Client
+}
+
+// NewDownloader returns a downloader of the benchmark block file from the
+// mirrors, or from DefaultMirrors if there are none.
+func NewDownloader(mirrors ...string) *Downloader {
+	if len(mirrors) == 0 {
+		mirrors = DefaultMirrors
+	}
+	return &Downloader{
+		Mirrors: mirrors,
+		SHA256:  BlockFileSHA256,
+		// the checksum authenticates the file, not the certificate of the
+		// mirror
+		Client: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}},
+	}
+}
+
+// EnsureBlockIsAvailable gets the benchmark block file from DefaultMirrors
+// in the 'simul'-provided directory, see Downloader.Ensure.
+func EnsureBlockIsAvailable(dir string) error {
+	return NewDownloader().Ensure(dir)
+}
+
+// Ensure makes sure the block file is in the blocks directory of the module,
+// downloading it if it is missing or has a wrong checksum. Finally the block
+// file will be copied to the blocks directory of the 'simul'-provided
+// directory for simulation.
+func (d *Downloader) Ensure(dir string) error {
+	cache := getModDir() + "/blocks"
+	block := GetBlockName(cache)
+	if block != "" {
+		if err := d.verify(block); err != nil {
+			log.Warn("Downloading the block file again:", err)
+			block = ""
+		}
+	}
+	if block == "" {
+		if err := os.MkdirAll(cache, 0777); err != nil {
+			return err
+		}
+		block = cache + "/" + blockFileName
+		if err := d.Download(block); err != nil {
+			return err
+		}
+	}
+	destDir := dir + "/blocks"
+	os.RemoveAll(destDir)
+	if err := os.Mkdir(destDir, 0777); err != nil {
+		return err
+	}
+	return copyFile(block, destDir+"/"+blockFileName)
+}
+
+// Download downloads the file to path from the first mirror that gives it
+// with the right checksum.
+func (d *Downloader) Download(path string) error {
+	if len(d.Mirrors) == 0 {
+		return errors.New("no mirror to download the block file from")
+	}
+	var errs []string
+	for _, url := range d.Mirrors {
+		log.Info("Downloading block-file from", url)
+		err := d.fetch(url, path)
+		if err == nil {
+			return nil
+		}
+		log.Warn("Couldn't download", url, ":", err)
+		errs = append(errs, url+": "+err.Error())
+	}
+	return errors.New("couldn't download the block file: " + strings.Join(errs, ", "))
+}
+
+// fetch downloads url to path, resuming the download in path.part.
+func (d *Downloader) fetch(url, path string) error {
+	part := path + ".part"
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPartialContent &&
+		strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)):
+		log.Lvl2("Resuming the download at", offset, "bytes")
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// the partial download is complete
+		resp.Body = http.NoBody
+	case resp.StatusCode == http.StatusOK:
+		// the mirror doesn't resume downloads
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	default:
+		return errors.New(resp.Status)
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := d.verify(part); err != nil {
+		os.Remove(part)
+		return err
+	}
+	return os.Rename(part, path)
+}
+
+// verify checks the SHA-256 of the file at path.
+func (d *Downloader) verify(path string) error {
+	if d.SHA256 == "" {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != d.SHA256 {
+		return fmt.Errorf("sha256 of %s is %s instead of %s", path, sum, d.SHA256)
+	}
+	return nil
+}
+
+// copyFile copies the file src to dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,93 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testMirror returns a mirror serving the content with ranges, and the
+// channel of the Range headers of the requests.
+func testMirror(t *testing.T, content []byte) (*httptest.Server, chan string) {
+	ranges := make(chan string, 10)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges <- r.Header.Get("Range")
+		http.ServeContent(w, r, blockFileName, time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(s.Close)
+	return s, ranges
+}
+
+// testDownloader returns a downloader of the content from the mirrors.
+func testDownloader(content []byte, mirrors ...string) *Downloader {
+	sum := sha256.Sum256(content)
+	d := NewDownloader(mirrors...)
+	d.SHA256 = hex.EncodeToString(sum[:])
+	d.Client = http.DefaultClient
+	return d
+}
+
+func TestDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("block"), 1000)
+	good, _ := testMirror(t, content)
+	bad, _ := testMirror(t, content[1:])
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	// the mirrors are tried in order
+	path := filepath.Join(t.TempDir(), blockFileName)
+	d := testDownloader(content, missing.URL, bad.URL, good.URL)
+	require.Nil(t, d.Download(path))
+	got, err := os.ReadFile(path)
+	require.Nil(t, err)
+	assert.Equal(t, content, got)
+	_, err = os.Stat(path + ".part")
+	assert.True(t, os.IsNotExist(err))
+
+	// none of them gives the file
+	path = filepath.Join(t.TempDir(), blockFileName)
+	d = testDownloader(content, missing.URL, bad.URL)
+	err = d.Download(path)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "sha256")
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	assert.NotNil(t, (&Downloader{}).Download(path))
+}
+
+func TestDownloadResume(t *testing.T) {
+	content := bytes.Repeat([]byte("block"), 1000)
+	mirror, ranges := testMirror(t, content)
+	d := testDownloader(content, mirror.URL)
+	for _, part := range []int{1234, len(content)} {
+		path := filepath.Join(t.TempDir(), blockFileName)
+		require.Nil(t, os.WriteFile(path+".part", content[:part], 0644))
+		require.Nil(t, d.Download(path))
+		assert.Equal(t, fmt.Sprintf("bytes=%d-", part), <-ranges)
+		got, err := os.ReadFile(path)
+		require.Nil(t, err)
+		assert.Equal(t, content, got)
+	}
+
+	// a mirror that doesn't resume sends the whole file
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer plain.Close()
+	path := filepath.Join(t.TempDir(), blockFileName)
+	require.Nil(t, os.WriteFile(path+".part", []byte("garbage"), 0644))
+	require.Nil(t, testDownloader(content, plain.URL).Download(path))
+	got, err := os.ReadFile(path)
+	require.Nil(t, err)
+	assert.Equal(t, content, got)
+}
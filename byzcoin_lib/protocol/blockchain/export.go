@@ -0,0 +1,139 @@
+package blockchain
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+)
+
+// ChainRecord is a committed block as exported by a ChainExporter.
+type ChainRecord struct {
+	Height     int       `json:"height"`
+	Hash       string    `json:"hash"`
+	Parent     string    `json:"parent"`
+	MerkleRoot string    `json:"merkle_root"`
+	TxCount    int       `json:"tx_count"`
+	Size       int       `json:"size"`
+	Committed  time.Time `json:"committed"`
+	// Signers has a '1' for every member that signed the block and a '0'
+	// for the others, in the order of the roster
+	Signers string `json:"signers"`
+}
+
+// chainColumns are the columns of the CSV export.
+var chainColumns = []string{"height", "hash", "parent", "merkle_root",
+	"tx_count", "size", "committed", "signers"}
+
+// ChainExporter records the blocks committed during a run, so that they can
+// be written to JSON and CSV files for the plotting scripts.
+type ChainExporter struct {
+	mutex   sync.Mutex
+	records []ChainRecord
+}
+
+// NewChainExporter returns an exporter without blocks.
+func NewChainExporter() *ChainExporter {
+	return &ChainExporter{}
+}
+
+// Add records the block committed at the time, as the next block of the
+// chain. signers tells which members of the roster signed it.
+func (e *ChainExporter) Add(b *TrBlock, committed time.Time, signers []bool) {
+	bitmap := make([]byte, len(signers))
+	for i, s := range signers {
+		bitmap[i] = '0'
+		if s {
+			bitmap[i] = '1'
+		}
+	}
+	r := ChainRecord{
+		Hash:      b.HeaderHash,
+		TxCount:   len(b.Txs),
+		Size:      int(b.BlockSize),
+		Committed: committed,
+		Signers:   string(bitmap),
+	}
+	if b.Header != nil {
+		r.Parent = b.Parent
+		r.MerkleRoot = b.MerkleRoot
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	r.Height = len(e.records)
+	e.records = append(e.records, r)
+}
+
+// Records returns the blocks recorded so far.
+func (e *ChainExporter) Records() []ChainRecord {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]ChainRecord(nil), e.records...)
+}
+
+// WriteJSON writes the blocks as a JSON array of ChainRecord.
+func (e *ChainExporter) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(e.Records())
+}
+
+// WriteCSV writes the blocks as CSV, with a header line.
+func (e *ChainExporter) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(chainColumns); err != nil {
+		return err
+	}
+	for _, r := range e.Records() {
+		err := cw.Write([]string{
+			strconv.Itoa(r.Height),
+			r.Hash,
+			r.Parent,
+			r.MerkleRoot,
+			strconv.Itoa(r.TxCount),
+			strconv.Itoa(r.Size),
+			r.Committed.Format(time.RFC3339Nano),
+			r.Signers,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Export writes the blocks to prefix.json and prefix.csv.
+func (e *ChainExporter) Export(prefix string) error {
+	if err := e.writeFile(prefix+".json", e.WriteJSON); err != nil {
+		return err
+	}
+	return e.writeFile(prefix+".csv", e.WriteCSV)
+}
+
+func (e *ChainExporter) writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Signers returns which of the n members of the roster signed, given the
+// mask of the signature.
+func Signers(mask crypto.SignerMask, n int) []bool {
+	signers := make([]bool, n)
+	for i := range signers {
+		signers[i] = mask.Signed(i)
+	}
+	return signers
+}
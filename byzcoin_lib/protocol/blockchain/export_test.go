@@ -0,0 +1,85 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainExporter(t *testing.T) {
+	blocks := GenerateChain(GeneratorConfig{Seed: 1, MinTxs: 1, MaxTxs: 5}, 3)
+	start := time.Date(2017, 10, 28, 12, 0, 0, 0, time.UTC)
+	e := NewChainExporter()
+	for i, b := range blocks {
+		e.Add(b, start.Add(time.Duration(i)*time.Second), []bool{true, i != 1, false})
+	}
+	// a block without header
+	e.Add(&TrBlock{Block: Block{HeaderHash: "nil"}}, start, nil)
+	records := e.Records()
+	require.Equal(t, 4, len(records))
+	for i, b := range blocks {
+		r := records[i]
+		assert.Equal(t, i, r.Height)
+		assert.Equal(t, b.HeaderHash, r.Hash)
+		assert.Equal(t, b.Parent, r.Parent)
+		assert.Equal(t, b.MerkleRoot, r.MerkleRoot)
+		assert.Equal(t, len(b.Txs), r.TxCount)
+		assert.Equal(t, int(b.BlockSize), r.Size)
+	}
+	assert.Equal(t, "110", records[0].Signers)
+	assert.Equal(t, "100", records[1].Signers)
+	assert.Equal(t, "", records[3].Parent)
+	// the records are copies
+	records[0].Hash = "changed"
+	assert.Equal(t, blocks[0].HeaderHash, e.Records()[0].Hash)
+
+	var buf bytes.Buffer
+	require.Nil(t, e.WriteJSON(&buf))
+	var decoded []ChainRecord
+	require.Nil(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, 4, len(decoded))
+	assert.Equal(t, e.Records()[2].Hash, decoded[2].Hash)
+	assert.True(t, decoded[2].Committed.Equal(start.Add(2*time.Second)))
+
+	buf.Reset()
+	require.Nil(t, e.WriteCSV(&buf))
+	lines, err := csv.NewReader(&buf).ReadAll()
+	require.Nil(t, err)
+	require.Equal(t, 5, len(lines))
+	assert.Equal(t, chainColumns, lines[0])
+	assert.Equal(t, []string{"1", blocks[1].HeaderHash, blocks[1].Parent,
+		blocks[1].MerkleRoot, strconv.Itoa(len(blocks[1].Txs)),
+		strconv.Itoa(int(blocks[1].BlockSize)),
+		start.Add(time.Second).Format(time.RFC3339Nano), "100"}, lines[2])
+
+	prefix := filepath.Join(t.TempDir(), "chain")
+	require.Nil(t, e.Export(prefix))
+	for _, ext := range []string{".json", ".csv"} {
+		data, err := os.ReadFile(prefix + ext)
+		require.Nil(t, err)
+		assert.True(t, strings.Contains(string(data), blocks[2].HeaderHash))
+	}
+	assert.NotNil(t, e.Export(filepath.Join(prefix, "missing", "chain")))
+}
+
+func TestSigners(t *testing.T) {
+	mask := crypto.NewSignerMask(10)
+	require.Nil(t, mask.Set(0))
+	require.Nil(t, mask.Set(9))
+	assert.Equal(t, []bool{true, false, false, false, false, false, false,
+		false, false, true}, Signers(mask, 10))
+	// the members out of the mask didn't sign
+	assert.Equal(t, []bool{true, false}, Signers(mask, 2))
+	assert.Equal(t, 20, len(Signers(mask, 20)))
+	assert.False(t, Signers(mask, 20)[19])
+}
@@ -0,0 +1,58 @@
+package blockchain
+
+// FeePayer is implemented by the transactions offering a fee to the leader
+// that puts them in a block. The other transactions offer none.
+type FeePayer interface {
+	Fee() uint64
+}
+
+// PaidTx is a transaction with the fee it offers, see WithFee.
+type PaidTx struct {
+	Transaction
+	fee uint64
+}
+
+// WithFee returns the transaction offering the fee, or the transaction
+// itself if the fee is 0.
+func WithFee(tx Transaction, fee uint64) Transaction {
+	if fee == 0 {
+		return tx
+	}
+	if p, ok := tx.(*PaidTx); ok {
+		tx = p.Transaction
+	}
+	return &PaidTx{Transaction: tx, fee: fee}
+}
+
+// Fee implements FeePayer.
+func (p *PaidTx) Fee() uint64 {
+	return p.fee
+}
+
+// Fee returns the fee the transaction offers, 0 if it isn't a FeePayer.
+func Fee(tx Transaction) uint64 {
+	if f, ok := tx.(FeePayer); ok {
+		return f.Fee()
+	}
+	return 0
+}
+
+// Size returns the bytes the transaction takes in a block: its Bitcoin size
+// if it is one, else the length of its encoding.
+func Size(tx Transaction) int {
+	return int(ToTx(tx).Size)
+}
+
+// FeeDensity returns the fee the transaction offers per byte it takes in a
+// block, the priority a leader packs blocks by, see Mempool.Select.
+func FeeDensity(tx Transaction) float64 {
+	fee := Fee(tx)
+	if fee == 0 {
+		return 0
+	}
+	size := Size(tx)
+	if size <= 0 {
+		size = 1
+	}
+	return float64(fee) / float64(size)
+}
@@ -0,0 +1,30 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFee(t *testing.T) {
+	b := testTx("a", 1)
+	b.Size = 200
+	tx := NewBitcoinTx(b)
+	assert.Equal(t, Transaction(tx), WithFee(tx, 0))
+	assert.Equal(t, uint64(0), Fee(tx))
+	assert.Equal(t, 0.0, FeeDensity(tx))
+
+	paid := WithFee(tx, 100)
+	assert.Equal(t, uint64(100), Fee(paid))
+	assert.Equal(t, 0.5, FeeDensity(paid))
+	assert.Equal(t, tx.Hash(), paid.Hash())
+	assert.Equal(t, 200, Size(paid))
+	assert.Equal(t, b, ToTx(paid))
+	// a new fee replaces the one offered
+	assert.Equal(t, uint64(300), Fee(WithFee(paid, 300)))
+	assert.Equal(t, tx, WithFee(paid, 300).(*PaidTx).Transaction)
+
+	// the size of a transaction without one is the length of its encoding
+	native := &NativeTx{Coinbase: true, Outputs: []uint64{1}}
+	assert.Equal(t, len(native.Bytes()), Size(native))
+}
@@ -0,0 +1,283 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+)
+
+// defaultReward is the value of the coinbase transactions if the config has
+// none, 50 BTC in satoshis.
+const defaultReward = 50 * 100000000
+
+// GeneratorConfig describes the chains made by a Generator.
+type GeneratorConfig struct {
+	// Seed of the PRNG: the same config always gives the same chain
+	Seed int64
+	// MinTxs and MaxTxs bound the number of transactions of a block,
+	// coinbase included
+	MinTxs int
+	MaxTxs int
+	// Inputs and Outputs are the maximum numbers of inputs and outputs of a
+	// transaction, 1 if 0
+	Inputs  int
+	Outputs int
+	// ScriptSize is the size of the input scripts, to make larger
+	// transactions
+	ScriptSize int
+	// Local is the probability for an input to spend an output of the same
+	// block, instead of one of the previous blocks
+	Local float64
+	// Reward is the value created by the coinbase transactions
+	Reward uint64
+	// Wallets makes the transactions payments between that many wallets
+	// with keys derived from Seed, with signed inputs and change outputs,
+	// instead of transactions with random scripts. The coinbase
+	// transactions pay the wallets in turn, and Inputs, Outputs, ScriptSize
+	// and Local are not used.
+	Wallets int
+}
+
+// Generator makes a chain of blocks of Bitcoin transactions from a PRNG, so
+// that no .dat file is needed. Every block starts with a coinbase
+// transaction, and the other transactions spend unspent outputs of the chain
+// once, so that the blocks can be applied in order to an UTXOSet.
+type Generator struct {
+	config GeneratorConfig
+	rand   *rand.Rand
+	// unspent are the outputs of the previous blocks not spent yet
+	unspent []genOutput
+	wallets []*Wallet
+	parent  string
+	height  uint32
+}
+
+// genOutput is an output created by the generator.
+type genOutput struct {
+	hash  string
+	vout  uint32
+	value uint64
+}
+
+// NewGenerator returns a generator of the chain of the config.
+func NewGenerator(config GeneratorConfig) *Generator {
+	if config.MinTxs < 1 {
+		config.MinTxs = 1
+	}
+	if config.MaxTxs < config.MinTxs {
+		config.MaxTxs = config.MinTxs
+	}
+	if config.Inputs < 1 {
+		config.Inputs = 1
+	}
+	if config.Outputs < 1 {
+		config.Outputs = 1
+	}
+	if config.Reward == 0 {
+		config.Reward = defaultReward
+	}
+	return &Generator{
+		config:  config,
+		rand:    rand.New(rand.NewSource(config.Seed)),
+		wallets: NewWallets(config.Wallets, config.Seed),
+	}
+}
+
+// Wallets returns the wallets paying each other in the chain, if any.
+func (g *Generator) Wallets() []*Wallet {
+	return g.wallets
+}
+
+// GenerateChain returns the first n blocks of the chain of the config.
+func GenerateChain(config GeneratorConfig, n int) []*TrBlock {
+	g := NewGenerator(config)
+	blocks := make([]*TrBlock, n)
+	for i := range blocks {
+		blocks[i] = g.Block()
+	}
+	return blocks
+}
+
+// Block returns the next block of the chain, whose parent is the previous
+// block.
+func (g *Generator) Block() *TrBlock {
+	n := g.config.MinTxs + g.rand.Intn(g.config.MaxTxs-g.config.MinTxs+1)
+	if len(g.wallets) > 0 {
+		return g.finishBlock(g.payments(n))
+	}
+	coinbase := g.coinbase(nil)
+	txs := []blkparser.Tx{coinbase.tx}
+	local := coinbase.outputs
+	for len(txs) < n {
+		var ins []genOutput
+		for i := 1 + g.rand.Intn(g.config.Inputs); i > 0; i-- {
+			pool := &g.unspent
+			if len(g.unspent) == 0 || g.rand.Float64() < g.config.Local {
+				pool = &local
+			}
+			if len(*pool) == 0 {
+				break
+			}
+			ins = append(ins, take(g.rand, pool))
+		}
+		if len(ins) == 0 {
+			break
+		}
+		var value uint64
+		for _, in := range ins {
+			value += in.value
+		}
+		t := g.transaction(ins, value)
+		txs = append(txs, t.tx)
+		local = append(local, t.outputs...)
+	}
+	g.unspent = append(g.unspent, local...)
+	return g.finishBlock(txs)
+}
+
+// payments returns the coinbase transaction paying the next wallet followed
+// by n-1 payments between random wallets, fewer if they run out of funds.
+func (g *Generator) payments(n int) []blkparser.Tx {
+	miner := g.wallets[int(g.height)%len(g.wallets)]
+	coinbase := g.coinbase(miner.PkScript())
+	txs := []blkparser.Tx{coinbase.tx}
+	miner.Receive(NewBitcoinTx(coinbase.tx))
+	for len(txs) < n {
+		from := g.wallets[g.rand.Intn(len(g.wallets))]
+		to := g.wallets[g.rand.Intn(len(g.wallets))]
+		balance := from.Balance()
+		if balance == 0 {
+			continue
+		}
+		pay, err := from.Pay(Payment{PkScript: to.PkScript(),
+			Value: 1 + uint64(g.rand.Int63n(int64(balance)))})
+		if err != nil {
+			break
+		}
+		to.Receive(pay)
+		txs = append(txs, ToTx(pay))
+	}
+	return txs
+}
+
+// finishBlock returns the block of the transactions following the previous
+// block.
+func (g *Generator) finishBlock(txs []blkparser.Tx) *TrBlock {
+	g.height++
+	list := NewTransactionList(txs, len(txs))
+	b := NewTrBlock(list, NewHeader(list, g.parent, ""))
+	g.parent = b.HeaderHash
+	return b
+}
+
+// genTx is a transaction and the outputs it creates.
+type genTx struct {
+	tx      blkparser.Tx
+	outputs []genOutput
+}
+
+// coinbase returns the coinbase transaction of the block, unique thanks to
+// the height in its script, paying to pkScript or to random scripts if nil.
+func (g *Generator) coinbase(pkScript []byte) genTx {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(1))
+	writeVarInt(&buf, 1)
+	buf.Write(make([]byte, 32))
+	binary.Write(&buf, binary.LittleEndian, uint32(coinbaseVout))
+	script := make([]byte, 4+g.config.ScriptSize)
+	binary.LittleEndian.PutUint32(script, g.height)
+	g.rand.Read(script[4:])
+	writeVarInt(&buf, len(script))
+	buf.Write(script)
+	binary.Write(&buf, binary.LittleEndian, uint32(0xffffffff))
+	return g.finish(&buf, g.config.Reward, pkScript)
+}
+
+// transaction returns a transaction spending the inputs and splitting their
+// value among its outputs.
+func (g *Generator) transaction(ins []genOutput, value uint64) genTx {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(1))
+	writeVarInt(&buf, len(ins))
+	for _, in := range ins {
+		// the hashes are shown byte-reversed, see blkparser.HashString
+		hash, _ := hex.DecodeString(in.hash)
+		for i := len(hash) - 1; i >= 0; i-- {
+			buf.WriteByte(hash[i])
+		}
+		binary.Write(&buf, binary.LittleEndian, in.vout)
+		script := make([]byte, g.config.ScriptSize)
+		g.rand.Read(script)
+		writeVarInt(&buf, len(script))
+		buf.Write(script)
+		binary.Write(&buf, binary.LittleEndian, uint32(0xffffffff))
+	}
+	return g.finish(&buf, value, nil)
+}
+
+// finish writes the outputs, splitting the value among them and paying to
+// pkScript or to the hashes of random public keys if nil, and the locktime of
+// the transaction in buf, and parses it.
+func (g *Generator) finish(buf *bytes.Buffer, value uint64, pkScript []byte) genTx {
+	n := 1 + g.rand.Intn(g.config.Outputs)
+	if uint64(n) > value {
+		n = int(value)
+	}
+	writeVarInt(buf, n)
+	for i := 0; i < n; i++ {
+		v := value / uint64(n)
+		if i == n-1 {
+			v = value - v*uint64(n-1)
+		}
+		binary.Write(buf, binary.LittleEndian, v)
+		script := pkScript
+		if script == nil {
+			script = make([]byte, 25)
+			copy(script, []byte{0x76, 0xa9, 0x14})
+			g.rand.Read(script[3:23])
+			copy(script[23:], []byte{0x88, 0xac})
+		}
+		writeVarInt(buf, len(script))
+		buf.Write(script)
+	}
+	binary.Write(buf, binary.LittleEndian, uint32(0))
+
+	tx, size := blkparser.NewTx(buf.Bytes())
+	tx.Size = uint32(size)
+	ret := genTx{tx: *tx}
+	for i, out := range tx.TxOuts {
+		ret.outputs = append(ret.outputs,
+			genOutput{hash: tx.Hash, vout: uint32(i), value: out.Value})
+	}
+	return ret
+}
+
+// take removes a random output of the pool and returns it.
+func take(r *rand.Rand, pool *[]genOutput) genOutput {
+	outs := *pool
+	i := r.Intn(len(outs))
+	o := outs[i]
+	outs[i] = outs[len(outs)-1]
+	*pool = outs[:len(outs)-1]
+	return o
+}
+
+// writeVarInt writes the Bitcoin variable length encoding of n.
+func writeVarInt(buf *bytes.Buffer, n int) {
+	switch {
+	case n < 0xfd:
+		buf.WriteByte(byte(n))
+	case n <= 0xffff:
+		buf.WriteByte(0xfd)
+		binary.Write(buf, binary.LittleEndian, uint16(n))
+	case n <= 0xffffffff:
+		buf.WriteByte(0xfe)
+		binary.Write(buf, binary.LittleEndian, uint32(n))
+	default:
+		buf.WriteByte(0xff)
+		binary.Write(buf, binary.LittleEndian, uint64(n))
+	}
+}
@@ -0,0 +1,76 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateChain(t *testing.T) {
+	config := GeneratorConfig{Seed: 1, MinTxs: 5, MaxTxs: 20, Inputs: 3,
+		Outputs: 3, ScriptSize: 50, Local: 0.3}
+	blocks := GenerateChain(config, 20)
+	// the same config gives the same chain, another seed another one
+	again := GenerateChain(config, 20)
+	for i := range blocks {
+		assert.Equal(t, blocks[i].HeaderHash, again[i].HeaderHash)
+	}
+	config.Seed = 2
+	assert.NotEqual(t, blocks[0].HeaderHash, GenerateChain(config, 1)[0].HeaderHash)
+
+	// the blocks extend each other and spend unspent outputs once
+	v := NewChainValidator("", -1)
+	s := NewUTXOSet(0, 1)
+	for _, b := range blocks {
+		assert.True(t, len(b.Txs) >= 1 && len(b.Txs) <= 20, "%d", len(b.Txs))
+		require.Nil(t, v.Append(b))
+		require.Nil(t, s.Apply(b.HeaderHash, b.Txs))
+		coinbase := b.Txs[0]
+		require.Equal(t, 1, len(coinbase.TxIns))
+		var reward uint64
+		for _, out := range coinbase.TxOuts {
+			reward += out.Value
+		}
+		assert.Equal(t, uint64(defaultReward), reward)
+		for _, tx := range b.Txs[1:] {
+			assert.True(t, len(tx.TxIns) >= 1 && len(tx.TxIns) <= 3)
+			assert.True(t, len(tx.TxOuts) >= 1 && len(tx.TxOuts) <= 3)
+			assert.Equal(t, 50, len(tx.TxIns[0].ScriptSig))
+		}
+	}
+	_, height := v.Tip()
+	assert.Equal(t, 19, height)
+}
+
+func TestGenerateWallets(t *testing.T) {
+	config := GeneratorConfig{Seed: 1, MinTxs: 3, MaxTxs: 8, Reward: 1000, Wallets: 4}
+	g := NewGenerator(config)
+	wallets := g.Wallets()
+	require.Equal(t, 4, len(wallets))
+	var txs []Transaction
+	s := NewUTXOSet(0, 1)
+	for i := 0; i < 10; i++ {
+		b := g.Block()
+		require.Nil(t, s.Apply(b.HeaderHash, b.Txs))
+		// the coinbase transactions pay the wallets in turn
+		assert.Equal(t, wallets[i%4].PkScript(), b.Txs[0].TxOuts[0].Pkscript)
+		txs = append(txs, NewBitcoinTx(b.Txs[0]))
+		// and the payments spend their outputs with their signatures
+		for _, tx := range b.Txs[1:] {
+			require.Nil(t, VerifySpend(NewBitcoinTx(tx), outputs(txs...)))
+			txs = append(txs, NewBitcoinTx(tx))
+		}
+	}
+	assert.True(t, len(txs) > 10, "no payments")
+	// without fees, the wallets own all the rewards
+	var balance, unspent uint64
+	for _, w := range wallets {
+		balance += w.Balance()
+	}
+	for _, v := range s.Outputs {
+		unspent += v
+	}
+	assert.Equal(t, uint64(10*1000), balance)
+	assert.Equal(t, balance, unspent)
+}
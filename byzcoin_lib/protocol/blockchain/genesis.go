@@ -0,0 +1,72 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// Genesis is the configuration of a chain, held by the header of its first
+// block. The hash of that block is the ID of the chain, which the stores of
+// several chains are keyed by, see BoltDB.Chain and SnapshotDir.Chain.
+type Genesis struct {
+	// RosterHash is the hex encoded hash of the public keys of the roster
+	// running the chain, see RosterHash
+	RosterHash string
+	// Shard is the shard of the chain
+	Shard int
+	// Randomness is the hex encoded randomness of epoch 0
+	Randomness string
+	// Policy are the rules of the blocks of the chain
+	Policy ChainPolicy
+}
+
+// NewGenesisBlock returns the first block of the chain of the shard run by
+// the members with the public keys, whose blocks follow the policy. It has no
+// transactions nor parent, and its header holds the configuration of the
+// chain.
+func NewGenesisBlock(publics []abstract.Point, shard int, randomness []byte,
+	policy ChainPolicy) *TrBlock {
+	var list TransactionList
+	header := NewHeader(list, "", "")
+	header.Genesis = Genesis{
+		RosterHash: RosterHash(publics),
+		Shard:      shard,
+		Randomness: hex.EncodeToString(randomness),
+		Policy:     policy,
+	}
+	return NewTrBlock(list, header)
+}
+
+// IsGenesis tells whether the block is the first block of a chain.
+func (tr *TrBlock) IsGenesis() bool {
+	return tr.Header != nil && tr.Header.Genesis != (Genesis{})
+}
+
+// RosterHash returns the hex encoded hash of the public keys, in order.
+func RosterHash(publics []abstract.Point) string {
+	h := sha256.New()
+	for _, p := range publics {
+		if _, err := p.MarshalTo(h); err != nil {
+			log.Error("Couldn't hash public key:", err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashSum returns the hash of the configuration, part of the hash of the
+// header.
+func (g Genesis) hashSum() []byte {
+	h := sha256.New()
+	h.Write([]byte(g.RosterHash))
+	binary.Write(h, binary.LittleEndian, int64(g.Shard))
+	h.Write([]byte(g.Randomness))
+	// the chains without a policy keep their ID
+	if g.Policy != (ChainPolicy{}) {
+		h.Write(g.Policy.hashSum())
+	}
+	return h.Sum(nil)
+}
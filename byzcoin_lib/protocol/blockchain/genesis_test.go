@@ -0,0 +1,62 @@
+package blockchain
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func TestGenesisBlock(t *testing.T) {
+	var publics []abstract.Point
+	for i := 0; i < 3; i++ {
+		publics = append(publics, config.NewKeyPair(network.Suite).Public)
+	}
+	g := NewGenesisBlock(publics, 1, []byte{1, 2}, ChainPolicy{})
+	assert.True(t, g.IsGenesis())
+	assert.Equal(t, "", g.Parent)
+	assert.Empty(t, g.Txs)
+	assert.Equal(t, RosterHash(publics), g.Genesis.RosterHash)
+	assert.Equal(t, 1, g.Genesis.Shard)
+	assert.Equal(t, "0102", g.Genesis.Randomness)
+	require.Nil(t, NewChainValidator("", -1).Check(g))
+
+	// every part of the configuration changes the ID of the chain
+	var p ChainPolicy
+	p.AllowVersions(2)
+	for name, other := range map[string]*TrBlock{
+		"shard":      NewGenesisBlock(publics, 0, []byte{1, 2}, ChainPolicy{}),
+		"roster":     NewGenesisBlock(publics[1:], 1, []byte{1, 2}, ChainPolicy{}),
+		"randomness": NewGenesisBlock(publics, 1, []byte{1, 3}, ChainPolicy{}),
+		"policy":     NewGenesisBlock(publics, 1, []byte{1, 2}, p),
+	} {
+		assert.NotEqual(t, g.HeaderHash, other.HeaderHash, name)
+	}
+	assert.Equal(t, g.HeaderHash,
+		NewGenesisBlock(publics, 1, []byte{1, 2}, ChainPolicy{}).HeaderHash)
+
+	// the next blocks aren't
+	var list TransactionList
+	next := NewTrBlock(list, NewHeader(list, g.HeaderHash, ""))
+	assert.False(t, next.IsGenesis())
+	assert.False(t, (&TrBlock{}).IsGenesis())
+	assert.NotEqual(t, RosterHash(publics), RosterHash(publics[1:]))
+
+	// a validator catches up with the chain from its genesis block
+	db, err := OpenBoltDB(filepath.Join(t.TempDir(), "chains.db"))
+	require.Nil(t, err)
+	defer db.Close()
+	s, err := db.Chain(g.HeaderHash)
+	require.Nil(t, err)
+	require.Nil(t, s.Put(g))
+	require.Nil(t, s.Put(next))
+	v := NewChainValidator("", -1)
+	require.Nil(t, v.CatchUp(s))
+	tip, height := v.Tip()
+	assert.Equal(t, next.HeaderHash, tip)
+	assert.Equal(t, 1, height)
+}
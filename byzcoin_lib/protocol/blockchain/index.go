@@ -0,0 +1,143 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	bolt "go.etcd.io/bbolt"
+)
+
+// txBucket maps the txids to their encoded TxLocation
+var txBucket = []byte("txs")
+
+// ErrTxNotFound is returned by the index for unknown transactions.
+var ErrTxNotFound = errors.New("transaction not found")
+
+// TxLocation is where a transaction is in the .dat files.
+type TxLocation struct {
+	// File is the id of the .dat file, as in blk<File>.dat
+	File int
+	// Offset is the offset of the transaction in the file
+	Offset int64
+	// Size is the size of the transaction, witness data included
+	Size uint32
+}
+
+// TxIndex maps the txids to their location in the .dat files of a
+// directory. It is kept in a BoltDB file and filled by the parsers having
+// it as Index, so that the transactions can be read again without
+// rescanning the files.
+type TxIndex struct {
+	db *bolt.DB
+	// dir is the directory of the .dat files
+	dir string
+}
+
+// OpenTxIndex opens the BoltDB file at path, creating it if needed, as the
+// index of the .dat files of dir.
+func OpenTxIndex(path, dir string) (*TxIndex, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(txBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &TxIndex{db: db, dir: dir}, nil
+}
+
+// Location returns where the transaction is.
+func (ti *TxIndex) Location(txid string) (TxLocation, error) {
+	var loc TxLocation
+	err := ti.db.View(func(tx *bolt.Tx) error {
+		buf := tx.Bucket(txBucket).Get([]byte(txid))
+		if buf == nil {
+			return ErrTxNotFound
+		}
+		loc = decodeLocation(buf)
+		return nil
+	})
+	return loc, err
+}
+
+// Lookup reads the transaction from its .dat file.
+func (ti *TxIndex) Lookup(txid string) (*blkparser.Tx, error) {
+	loc, err := ti.Location(txid)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(blockFile(ti.dir, loc.File))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	raw := make([]byte, loc.Size)
+	if _, err := f.ReadAt(raw, loc.Offset); err != nil {
+		return nil, err
+	}
+	tx, size := blkparser.NewTx(raw)
+	if tx.Hash != txid {
+		return nil, fmt.Errorf("index of %s is out of date: found %s", txid, tx.Hash)
+	}
+	tx.Size = uint32(size)
+	return tx, nil
+}
+
+// Len returns the number of transactions in the index.
+func (ti *TxIndex) Len() int {
+	n := 0
+	ti.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(txBucket).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// Close closes the BoltDB file.
+func (ti *TxIndex) Close() error {
+	return ti.db.Close()
+}
+
+// addBlock indexes the transactions of the block, which starts at offset in
+// the .dat file.
+func (ti *TxIndex) addBlock(file int, offset int64, b *blkparser.Block) error {
+	// the transactions follow the header and their count
+	_, cntsize := blkparser.DecodeVariableLengthInteger(b.Raw[80:])
+	offset += int64(80 + cntsize)
+	return ti.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(txBucket)
+		for _, t := range b.Txs {
+			loc := TxLocation{File: file, Offset: offset, Size: t.Size}
+			if err := bucket.Put([]byte(t.Hash), encodeLocation(loc)); err != nil {
+				return err
+			}
+			offset += int64(t.Size)
+		}
+		return nil
+	})
+}
+
+// encodeLocation returns the big endian file, offset and size.
+func encodeLocation(loc TxLocation) []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(loc.File))
+	binary.BigEndian.PutUint64(buf[4:12], uint64(loc.Offset))
+	binary.BigEndian.PutUint32(buf[12:16], loc.Size)
+	return buf
+}
+
+func decodeLocation(buf []byte) TxLocation {
+	return TxLocation{
+		File:   int(binary.BigEndian.Uint32(buf[0:4])),
+		Offset: int64(binary.BigEndian.Uint64(buf[4:12])),
+		Size:   binary.BigEndian.Uint32(buf[12:16]),
+	}
+}
@@ -0,0 +1,51 @@
+package blockchain
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxIndex(t *testing.T) {
+	for _, workers := range []int{0, 3} {
+		dir := t.TempDir()
+		blocks := writeBlocks(t, dir, 3, 2, 3)
+		path := filepath.Join(t.TempDir(), "index.db")
+		index, err := OpenTxIndex(path, dir)
+		require.Nil(t, err)
+		p, err := NewParser(dir, testMagic)
+		require.Nil(t, err)
+		p.Workers = workers
+		p.Index = index
+		txs, err := p.Parse(0, -1)
+		require.Nil(t, err)
+		assert.Equal(t, 18, index.Len())
+
+		// the transactions are read back from the files
+		loc, err := index.Location(blocks[3][2])
+		require.Nil(t, err)
+		assert.Equal(t, 1, loc.File)
+		assert.Equal(t, txs[11].Size, loc.Size)
+		for _, want := range []int{0, 5, 11, 17} {
+			tx, err := index.Lookup(txs[want].Hash)
+			require.Nil(t, err)
+			assert.Equal(t, txs[want], *tx)
+		}
+		_, err = index.Lookup("unknown")
+		assert.Equal(t, ErrTxNotFound, err)
+		require.Nil(t, index.Close())
+
+		// the index is kept, but goes out of date with the files
+		index, err = OpenTxIndex(path, dir)
+		require.Nil(t, err)
+		assert.Equal(t, 18, index.Len())
+		writeBlocks(t, dir, 1, 1, 1)
+		_, err = index.Lookup(blocks[0][1])
+		assert.NotNil(t, err)
+		require.Nil(t, index.Close())
+	}
+	assert.Equal(t, TxLocation{File: 3, Offset: 1 << 40, Size: 7},
+		decodeLocation(encodeLocation(TxLocation{File: 3, Offset: 1 << 40, Size: 7})))
+}
@@ -0,0 +1,219 @@
+package blockchain
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Mempool holds the pending transactions of a shard until the leader puts
+// them in a block. A transaction is only held once, the transactions older
+// than MaxAge are dropped, and if the pool is full the transaction of the
+// lowest priority is evicted.
+type Mempool struct {
+	// MaxSize is the most transactions held, 0 for no limit
+	MaxSize int
+	// MaxAge is how long a transaction is held, 0 for ever
+	MaxAge time.Duration
+	// Priority returns the priority of a transaction, the highest being
+	// taken first. Transactions of the same priority are taken in the
+	// order they arrived; if nil, all transactions are of the same
+	// priority.
+	Priority func(Transaction) float64
+
+	mutex sync.Mutex
+	txs   map[string]*poolEntry
+	// seq is the arrival number of the next transaction
+	seq uint64
+}
+
+// poolEntry is a transaction with its arrival and priority.
+type poolEntry struct {
+	tx       Transaction
+	arrival  time.Time
+	seq      uint64
+	priority float64
+}
+
+// NewMempool returns an empty pool holding at most maxSize transactions for
+// at most maxAge, 0 meaning no limit.
+func NewMempool(maxSize int, maxAge time.Duration) *Mempool {
+	return &Mempool{
+		MaxSize: maxSize,
+		MaxAge:  maxAge,
+		txs:     make(map[string]*poolEntry),
+	}
+}
+
+// AddTransaction adds the transaction to the pool. It returns false if the
+// transaction is already pending, or if the pool is full of transactions of
+// at least the same priority.
+func (m *Mempool) AddTransaction(tx Transaction) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.expire()
+	if _, ok := m.txs[tx.Hash()]; ok {
+		return false
+	}
+	e := &poolEntry{tx: tx, arrival: time.Now(), seq: m.seq}
+	if m.Priority != nil {
+		e.priority = m.Priority(tx)
+	}
+	if m.MaxSize > 0 && len(m.txs) >= m.MaxSize {
+		sorted := m.sorted()
+		worst := sorted[len(sorted)-1]
+		if e.priority <= worst.priority {
+			return false
+		}
+		delete(m.txs, worst.tx.Hash())
+	}
+	m.seq++
+	m.txs[tx.Hash()] = e
+	return true
+}
+
+// Pending returns the n pending transactions to put first in a block, or
+// all of them if n is 0. They stay in the pool until removed.
+func (m *Mempool) Pending(n int) []Transaction {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.expire()
+	sorted := m.sorted()
+	if n <= 0 || n > len(sorted) {
+		n = len(sorted)
+	}
+	txs := make([]Transaction, n)
+	for i := range txs {
+		txs[i] = sorted[i].tx
+	}
+	return txs
+}
+
+// Select returns the pending transactions to put in a block of at most
+// maxTxs transactions and maxBytes bytes, 0 meaning no limit, as a leader
+// packs it: by priority, passing over the transactions that don't fit in
+// the bytes left for the next ones, and with a transaction spending the
+// output of a pending one after it. A transaction larger than maxBytes gets
+// a block of its own. It returns whether the block is full: of maxTxs
+// transactions, of maxBytes bytes, or with a transaction passed over. The
+// transactions stay in the pool until removed.
+func (m *Mempool) Select(maxTxs, maxBytes int) ([]Transaction, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.expire()
+	var txs []Transaction
+	size := 0
+	full := false
+	chosen := make(map[string]bool)
+	// waiting are the transactions waiting for a pending parent, under
+	// its hash
+	waiting := make(map[string][]*poolEntry)
+	// add takes the transaction and the ones waiting for it, and returns
+	// false once the block has maxTxs transactions or a transaction larger
+	// than maxBytes
+	var add func(e *poolEntry) bool
+	add = func(e *poolEntry) bool {
+		for _, o := range e.tx.Spends() {
+			if _, ok := m.txs[o.Hash]; ok && !chosen[o.Hash] {
+				waiting[o.Hash] = append(waiting[o.Hash], e)
+				return true
+			}
+		}
+		n := Size(e.tx)
+		if maxBytes > 0 && size+n > maxBytes {
+			if len(txs) > 0 {
+				full = true
+				return true
+			}
+			txs, full = []Transaction{e.tx}, true
+			return false
+		}
+		txs = append(txs, e.tx)
+		size += n
+		chosen[e.tx.Hash()] = true
+		if maxTxs > 0 && len(txs) >= maxTxs {
+			full = true
+			return false
+		}
+		children := waiting[e.tx.Hash()]
+		delete(waiting, e.tx.Hash())
+		for _, c := range children {
+			if !add(c) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, e := range m.sorted() {
+		if !add(e) {
+			break
+		}
+	}
+	if maxBytes > 0 && size >= maxBytes {
+		full = true
+	}
+	return txs, full
+}
+
+// Get returns the pending transaction with the hash, if any.
+func (m *Mempool) Get(txid string) (Transaction, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e, ok := m.txs[txid]
+	if !ok {
+		return nil, false
+	}
+	return e.tx, true
+}
+
+// Remove drops the transactions with the hashes, e.g. once they are in a
+// block, and returns how many were pending.
+func (m *Mempool) Remove(txids []string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	removed := 0
+	for _, id := range txids {
+		if _, ok := m.txs[id]; ok {
+			delete(m.txs, id)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Len returns the number of pending transactions.
+func (m *Mempool) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.expire()
+	return len(m.txs)
+}
+
+// expire drops the transactions older than MaxAge. The caller must hold
+// the mutex.
+func (m *Mempool) expire() {
+	if m.MaxAge <= 0 {
+		return
+	}
+	for id, e := range m.txs {
+		if time.Since(e.arrival) > m.MaxAge {
+			delete(m.txs, id)
+		}
+	}
+}
+
+// sorted returns the pending transactions, the first to take first. The
+// caller must hold the mutex.
+func (m *Mempool) sorted() []*poolEntry {
+	entries := make([]*poolEntry, 0, len(m.txs))
+	for _, e := range m.txs {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].priority != entries[j].priority {
+			return entries[i].priority > entries[j].priority
+		}
+		return entries[i].seq < entries[j].seq
+	})
+	return entries
+}
@@ -0,0 +1,125 @@
+package blockchain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hashes returns the hashes of the transactions.
+func hashes(txs []Transaction) []string {
+	var ret []string
+	for _, tx := range txs {
+		ret = append(ret, tx.Hash())
+	}
+	return ret
+}
+
+func TestMempool(t *testing.T) {
+	m := NewMempool(0, 0)
+	for _, h := range []string{"a", "b", "c"} {
+		require.True(t, m.AddTransaction(NewBitcoinTx(testTx(h, 1))))
+	}
+	// a transaction is only held once
+	assert.False(t, m.AddTransaction(NewBitcoinTx(testTx("b", 2))))
+	assert.Equal(t, 3, m.Len())
+	// the transactions are taken in the order they arrived
+	assert.Equal(t, []string{"a", "b", "c"}, hashes(m.Pending(0)))
+	assert.Equal(t, []string{"a", "b"}, hashes(m.Pending(2)))
+	assert.Equal(t, 3, m.Len())
+
+	tx, ok := m.Get("b")
+	require.True(t, ok)
+	assert.Equal(t, uint64(1), tx.Creates()[0])
+	assert.Equal(t, 2, m.Remove([]string{"a", "b", "x"}))
+	_, ok = m.Get("b")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"c"}, hashes(m.Pending(0)))
+	// a removed transaction can be added again
+	assert.True(t, m.AddTransaction(NewBitcoinTx(testTx("a", 1))))
+	assert.Equal(t, []string{"c", "a"}, hashes(m.Pending(0)))
+}
+
+func TestMempoolMaxSize(t *testing.T) {
+	m := NewMempool(2, 0)
+	require.True(t, m.AddTransaction(NewBitcoinTx(testTx("a", 1))))
+	require.True(t, m.AddTransaction(NewBitcoinTx(testTx("b", 1))))
+	assert.False(t, m.AddTransaction(NewBitcoinTx(testTx("c", 1))), "pool full")
+
+	// a transaction of a higher priority evicts the lowest one, the last
+	// arrived of the lowest priority
+	m = NewMempool(2, 0)
+	m.Priority = func(tx Transaction) float64 { return float64(tx.Creates()[0]) }
+	require.True(t, m.AddTransaction(NewBitcoinTx(testTx("a", 2))))
+	require.True(t, m.AddTransaction(NewBitcoinTx(testTx("b", 1))))
+	assert.False(t, m.AddTransaction(NewBitcoinTx(testTx("c", 1))))
+	require.True(t, m.AddTransaction(NewBitcoinTx(testTx("d", 3))))
+	assert.Equal(t, []string{"d", "a"}, hashes(m.Pending(0)))
+}
+
+func TestMempoolMaxAge(t *testing.T) {
+	m := NewMempool(0, 200*time.Millisecond)
+	require.True(t, m.AddTransaction(NewBitcoinTx(testTx("a", 1))))
+	time.Sleep(120 * time.Millisecond)
+	require.True(t, m.AddTransaction(NewBitcoinTx(testTx("b", 1))))
+	assert.Equal(t, 2, m.Len())
+	time.Sleep(120 * time.Millisecond)
+	assert.Equal(t, []string{"b"}, hashes(m.Pending(0)))
+	// an expired transaction can be sent again
+	assert.True(t, m.AddTransaction(NewBitcoinTx(testTx("a", 1))))
+}
+
+// paidTx returns a transaction of the size offering the fee, spending the
+// outputs.
+func paidTx(hash string, size uint32, fee uint64, spends ...Outpoint) Transaction {
+	tx := testTx(hash, 1, spends...)
+	tx.Size = size
+	return WithFee(NewBitcoinTx(tx), fee)
+}
+
+func TestMempoolSelect(t *testing.T) {
+	m := NewMempool(0, 0)
+	m.Priority = FeeDensity
+	// the child offers the most, but has to come after its parent
+	require.True(t, m.AddTransaction(paidTx("child", 100, 1000, Outpoint{"parent", 0})))
+	require.True(t, m.AddTransaction(paidTx("parent", 100, 100)))
+	require.True(t, m.AddTransaction(paidTx("other", 100, 500)))
+	txs, full := m.Select(0, 0)
+	assert.False(t, full)
+	assert.Equal(t, []string{"other", "parent", "child"}, hashes(txs))
+	txs, full = m.Select(2, 0)
+	assert.True(t, full)
+	assert.Equal(t, []string{"other", "parent"}, hashes(txs))
+	// they stay in the pool
+	assert.Equal(t, 3, m.Len())
+
+	// the transactions that don't fit in the bytes left are passed over
+	m = NewMempool(0, 0)
+	m.Priority = FeeDensity
+	require.True(t, m.AddTransaction(paidTx("a", 100, 500)))
+	require.True(t, m.AddTransaction(paidTx("b", 200, 800)))
+	require.True(t, m.AddTransaction(paidTx("c", 50, 100)))
+	txs, full = m.Select(0, 160)
+	assert.True(t, full)
+	assert.Equal(t, []string{"a", "c"}, hashes(txs))
+	txs, full = m.Select(0, 1000)
+	assert.False(t, full)
+	assert.Equal(t, []string{"a", "b", "c"}, hashes(txs))
+	txs, full = m.Select(0, 350)
+	assert.True(t, full, "no bytes left")
+	assert.Equal(t, []string{"a", "b", "c"}, hashes(txs))
+
+	// a transaction larger than a block gets one of its own
+	m = NewMempool(0, 0)
+	m.Priority = FeeDensity
+	require.True(t, m.AddTransaction(paidTx("big", 300, 3000)))
+	require.True(t, m.AddTransaction(paidTx("small", 100, 100)))
+	txs, full = m.Select(0, 200)
+	assert.True(t, full)
+	assert.Equal(t, []string{"big"}, hashes(txs))
+	m.Remove([]string{"big"})
+	txs, _ = m.Select(0, 200)
+	assert.Equal(t, []string{"small"}, hashes(txs))
+}
@@ -0,0 +1,67 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+)
+
+// TxProof is the proof that a transaction is in a block, to be checked
+// against the Merkle root of the header alone, e.g. by a light client or by
+// the shards checking a proof-of-acceptance.
+type TxProof struct {
+	// TxHash is the hash of the transaction
+	TxHash string
+	// Path is the Merkle path from the transaction to the root
+	Path crypto.Proof
+}
+
+// txLeaf returns the hash of the transaction in the Merkle tree,
+// sha256("tx/" + hash). The hash is hashed again so that transactions of any
+// format, even with hashes that aren't hex encoded, are committed to.
+//
+// The leaves used to be the hex decoded hashes themselves: the headers of the
+// blocks saved or exported with those roots don't verify anymore.
+func txLeaf(hash string) crypto.HashID {
+	h := sha256.New()
+	fmt.Fprintf(h, "tx/%s", hash)
+	return h.Sum(nil)
+}
+
+// txTree returns the Merkle root of the transactions and the path of every
+// transaction.
+func txTree(tl *TransactionList) ([]byte, []crypto.Proof) {
+	leaves := make([]crypto.HashID, len(tl.Txs))
+	for i := range tl.Txs {
+		leaves[i] = txLeaf(tl.Txs[i].Hash)
+	}
+	root, paths := crypto.ProofTree(sha256.New, leaves)
+	return []byte(root), paths
+}
+
+// GetProof returns the proof that the transaction txid is in the list.
+func (tl *TransactionList) GetProof(txid string) (*TxProof, error) {
+	_, paths := txTree(tl)
+	for i := range tl.Txs {
+		if tl.Txs[i].Hash == txid {
+			return &TxProof{TxHash: txid, Path: paths[i]}, nil
+		}
+	}
+	return nil, errors.New("unknown transaction " + txid)
+}
+
+// VerifyProof checks that the transaction of the proof is committed to by
+// root, the hex encoded Merkle root of a header.
+func VerifyProof(root string, proof *TxProof) error {
+	r, err := hex.DecodeString(root)
+	if err != nil {
+		return err
+	}
+	if proof == nil || !proof.Path.Check(sha256.New, r, txLeaf(proof.TxHash)) {
+		return errors.New("transaction not in the block")
+	}
+	return nil
+}
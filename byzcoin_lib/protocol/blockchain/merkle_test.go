@@ -0,0 +1,84 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"github.com/stretchr/testify/require"
+)
+
+// testList returns a list of n transactions with distinct hashes.
+func testList(n int) *TransactionList {
+	tl := &TransactionList{TxCnt: uint32(n)}
+	for i := 0; i < n; i++ {
+		h := sha256.Sum256([]byte(fmt.Sprint("tx", i)))
+		tl.Txs = append(tl.Txs, blkparser.Tx{Hash: hex.EncodeToString(h[:])})
+	}
+	return tl
+}
+
+func TestTxProofs(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		tl := testList(n)
+		root := HashRootTransactions(*tl)
+		r, _ := txTree(tl)
+		require.Equal(t, hex.EncodeToString(r), root)
+		for i := range tl.Txs {
+			proof, err := tl.GetProof(tl.Txs[i].Hash)
+			require.Nil(t, err)
+			require.Nil(t, VerifyProof(root, proof), "%d of %d", i, n)
+			// the root of another list doesn't commit to it
+			require.NotNil(t, VerifyProof(HashRootTransactions(*testList(n + 1)), proof))
+		}
+	}
+	_, err := testList(3).GetProof("unknown")
+	require.NotNil(t, err)
+	require.NotNil(t, VerifyProof(HashRootTransactions(*testList(3)), nil))
+}
+
+func TestTxProofTampered(t *testing.T) {
+	for _, n := range []int{4, 7} {
+		tl := testList(n)
+		root := HashRootTransactions(*tl)
+		for i := range tl.Txs {
+			proof, err := tl.GetProof(tl.Txs[i].Hash)
+			require.Nil(t, err)
+			for s := range proof.Path {
+				sibling := append([]byte{}, proof.Path[s]...)
+				proof.Path[s][0] ^= 1
+				require.NotNil(t, VerifyProof(root, proof), "%d of %d, sibling %d", i, n, s)
+				proof.Path[s] = sibling
+			}
+			require.Nil(t, VerifyProof(root, proof))
+		}
+	}
+}
+
+func TestTxProofWrongIndex(t *testing.T) {
+	for _, n := range []int{2, 5, 8} {
+		tl := testList(n)
+		root := HashRootTransactions(*tl)
+		_, paths := txTree(tl)
+		for i := range tl.Txs {
+			for j := range tl.Txs {
+				proof := &TxProof{TxHash: tl.Txs[j].Hash, Path: paths[i]}
+				if i == j {
+					require.Nil(t, VerifyProof(root, proof))
+				} else {
+					require.NotNil(t, VerifyProof(root, proof), "path %d for %d of %d", i, j, n)
+				}
+			}
+		}
+	}
+}
+
+func TestTxLeaf(t *testing.T) {
+	h := sha256.Sum256([]byte("tx/abcd"))
+	require.Equal(t, h[:], []byte(txLeaf("abcd")))
+	// the root isn't the one of the decoded hashes of the transactions
+	tl := testList(1)
+	require.NotEqual(t, tl.Txs[0].Hash, HashRootTransactions(*tl))
+}
@@ -0,0 +1,160 @@
+package blockchain
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+)
+
+// blockSource gives the blocks of the .dat files in order.
+type blockSource interface {
+	// Next returns the next block, or io.EOF once there are no more blocks.
+	Next() (*blkparser.Block, error)
+	Close() error
+}
+
+// open returns the blocks from first up to last, excluded, decoded with
+// Workers files at a time.
+func (p *Parser) open(first, last int) (blockSource, error) {
+	if p.Workers > 1 {
+		return p.parallelBlocks(first, last)
+	}
+	return p.Blocks(first, last)
+}
+
+// fileBlocks are the blocks of a file, and the error that stopped the
+// decoding of the file, if any.
+type fileBlocks struct {
+	blocks []*blkparser.Block
+	err    error
+}
+
+// parallelBlocks decodes whole files with a pool of workers and gives their
+// blocks in order. At most Workers files are decoded ahead of the current
+// one.
+type parallelBlocks struct {
+	// files gets, in the order of the files, the channel every file is
+	// decoded to
+	files chan chan fileBlocks
+	quit  chan struct{}
+	once  sync.Once
+	// current holds the blocks left of the current file, and err the error
+	// to return after them
+	current []*blkparser.Block
+	err     error
+	// index is the index of the next block
+	index int
+	first int
+	last  int
+}
+
+// parallelBlocks returns the blocks from first up to last, excluded, or up
+// to the end of the files if last is negative.
+func (p *Parser) parallelBlocks(first, last int) (*parallelBlocks, error) {
+	if _, err := os.Stat(blockFile(p.Path, 0)); err != nil {
+		return nil, err
+	}
+	pb := &parallelBlocks{
+		files: make(chan chan fileBlocks, p.Workers-1),
+		quit:  make(chan struct{}),
+		first: first,
+		last:  last,
+	}
+	go func() {
+		defer close(pb.files)
+		for id := 0; ; id++ {
+			id := id
+			path := blockFile(p.Path, id)
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				return
+			}
+			res := make(chan fileBlocks, 1)
+			select {
+			case pb.files <- res:
+			case <-pb.quit:
+				return
+			}
+			go func() {
+				res <- decodeFile(id, path, p.Magic, p.Index)
+			}()
+		}
+	}()
+	return pb, nil
+}
+
+// Next implements blockSource.
+func (pb *parallelBlocks) Next() (*blkparser.Block, error) {
+	for {
+		if pb.last >= 0 && pb.index >= pb.last {
+			return nil, io.EOF
+		}
+		if len(pb.current) == 0 {
+			if pb.err != nil {
+				return nil, pb.err
+			}
+			res, ok := <-pb.files
+			if !ok {
+				return nil, io.EOF
+			}
+			fb := <-res
+			pb.current, pb.err = fb.blocks, fb.err
+			continue
+		}
+		b := pb.current[0]
+		pb.current = pb.current[1:]
+		pb.index++
+		if pb.index > pb.first {
+			return b, nil
+		}
+	}
+}
+
+// Close implements blockSource. It stops the decoding of the next files.
+func (pb *parallelBlocks) Close() error {
+	pb.once.Do(func() {
+		close(pb.quit)
+	})
+	return nil
+}
+
+// decodeFile returns the blocks of the .dat file id and adds them to the
+// index, if not nil.
+func decodeFile(id int, path string, magic [4]byte, index *TxIndex) fileBlocks {
+	f, err := os.Open(path)
+	if err != nil {
+		return fileBlocks{err: err}
+	}
+	defer f.Close()
+	chain := &blkparser.Blockchain{Path: path, Magic: magic, CurrentFile: f}
+	var fb fileBlocks
+	for {
+		raw, offset, err := fetchBlock(chain)
+		if err == io.EOF {
+			return fb
+		}
+		if err != nil {
+			fb.err = err
+			return fb
+		}
+		b, err := blkparser.NewBlock(raw)
+		if err != nil {
+			fb.err = err
+			return fb
+		}
+		if index != nil {
+			if err := index.addBlock(id, offset, b); err != nil {
+				fb.err = err
+				return fb
+			}
+		}
+		fb.blocks = append(fb.blocks, b)
+	}
+}
+
+// blockFile returns the name of the .dat file id of the directory.
+func blockFile(dir string, id int) string {
+	return fmt.Sprintf("%s/blk%05d.dat", dir, id)
+}
@@ -0,0 +1,53 @@
+package blockchain
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWorkers(t *testing.T) {
+	dir := t.TempDir()
+	blocks := writeBlocks(t, dir, 5, 3, 2)
+	p, err := NewParser(dir, testMagic)
+	require.Nil(t, err)
+	p.Workers = 3
+	// the blocks come in the order of the files
+	for _, r := range [][2]int{{0, 15}, {2, 11}, {14, 15}} {
+		txs, err := p.Parse(r[0], r[1])
+		require.Nil(t, err)
+		assert.Equal(t, flatten(blocks, r[0], r[1]), txHashes(txs), "%v", r)
+	}
+	txs, err := p.Parse(0, -1)
+	require.Nil(t, err)
+	assert.Equal(t, flatten(blocks, 0, 15), txHashes(txs))
+
+	// closing stops the decoding of the next files
+	src, err := p.open(0, -1)
+	require.Nil(t, err)
+	_, err = src.Next()
+	require.Nil(t, err)
+	require.Nil(t, src.Close())
+
+	// the blocks before a bad file are still given
+	require.Nil(t, os.WriteFile(blockFile(dir, 2), []byte("not a block"), 0600))
+	src, err = p.open(0, -1)
+	require.Nil(t, err)
+	defer src.Close()
+	for i := 0; i < 6; i++ {
+		_, err := src.Next()
+		require.Nil(t, err)
+	}
+	_, err = src.Next()
+	assert.NotNil(t, err)
+	assert.NotEqual(t, io.EOF, err)
+
+	p, err = NewParser(t.TempDir(), testMagic)
+	require.Nil(t, err)
+	p.Workers = 3
+	_, err = p.Parse(0, 1)
+	assert.NotNil(t, err, "no .dat file")
+}
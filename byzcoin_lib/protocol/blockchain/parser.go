@@ -1,19 +1,10 @@
 package blockchain
 
 import (
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 
-	"crypto/tls"
-	"net/http"
-
-	"io"
-
-	"crypto/sha256"
-
-	"encoding/hex"
-
 	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
 	"gopkg.in/dedis/onet.v1/log"
 )
@@ -22,6 +13,11 @@ type Parser struct {
 	Path      string
 	Magic     [4]byte
 	CurrentId uint32
+	// Workers is the number of .dat files Parse and Stream decode
+	// concurrently, one at a time if 0 or 1
+	Workers int
+	// Index, if not nil, gets the transactions of the blocks decoded
+	Index *TxIndex
 }
 
 func NewParser(path string, magic [4]byte) (parser *Parser, err error) {
@@ -32,37 +28,161 @@ func NewParser(path string, magic [4]byte) (parser *Parser, err error) {
 	return
 }
 
+// Parse returns the transactions of the blocks from first_block up to
+// last_block, excluded. It holds all of them in memory, see Blocks and
+// Stream for large block directories.
 func (p *Parser) Parse(first_block, last_block int) ([]blkparser.Tx, error) {
-
-	Chain, _ := blkparser.NewBlockchain(p.Path, p.Magic)
-
+	it, err := p.open(first_block, last_block)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
 	var transactions []blkparser.Tx
-
-	for i := 0; i < last_block; i++ {
-		raw, err := Chain.FetchNextBlock()
-
-		if raw == nil || err != nil {
-			if err != nil {
-				return transactions, err
-			}
+	for {
+		bl, err := it.Next()
+		if err == io.EOF {
+			return transactions, nil
 		}
-
-		bl, err := blkparser.NewBlock(raw[:])
 		if err != nil {
 			return transactions, err
 		}
+		for _, tx := range bl.Txs {
+			transactions = append(transactions, *tx)
+		}
+	}
+}
+
+// BlockIterator decodes the blocks of the .dat files one at a time, so
+// only the current block is held in memory.
+type BlockIterator struct {
+	chain *blkparser.Blockchain
+	// index is the index of the next block, last the index to stop at, or
+	// -1 to read all blocks
+	index int
+	last  int
+	// txs is the index the decoded blocks are added to, if not nil
+	txs *TxIndex
+}
 
-		// Read block till we reach start_block
-		if i < first_block {
-			continue
+// Blocks returns an iterator over the blocks from first up to last,
+// excluded, or up to the end of the files if last is negative.
+func (p *Parser) Blocks(first, last int) (*BlockIterator, error) {
+	chain, err := blkparser.NewBlockchain(p.Path, p.Magic)
+	if err != nil {
+		return nil, err
+	}
+	it := &BlockIterator{chain: chain, last: last, txs: p.Index}
+	for it.index < first {
+		if _, _, err := it.fetch(); err != nil {
+			it.Close()
+			return nil, err
 		}
+	}
+	return it, nil
+}
 
-		for _, tx := range bl.Txs {
-			transactions = append(transactions, *tx)
+// Next returns the next block, or io.EOF once there are no more blocks.
+func (it *BlockIterator) Next() (*blkparser.Block, error) {
+	raw, offset, err := it.fetch()
+	if err != nil {
+		return nil, err
+	}
+	b, err := blkparser.NewBlock(raw)
+	if err != nil {
+		return nil, err
+	}
+	if it.txs != nil {
+		if err := it.txs.addBlock(int(it.chain.CurrentId), offset, b); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Close closes the current file.
+func (it *BlockIterator) Close() error {
+	return it.chain.CurrentFile.Close()
+}
+
+// fetch returns the next raw block and its offset in the current file,
+// opening the next file at the end of the current one.
+func (it *BlockIterator) fetch() ([]byte, int64, error) {
+	if it.last >= 0 && it.index >= it.last {
+		return nil, 0, io.EOF
+	}
+	raw, offset, err := fetchBlock(it.chain)
+	if err == io.EOF {
+		f, errOpen := os.Open(blockFile(it.chain.Path, int(it.chain.CurrentId)+1))
+		if os.IsNotExist(errOpen) {
+			return nil, 0, io.EOF
 		}
+		if errOpen != nil {
+			return nil, 0, errOpen
+		}
+		it.chain.CurrentFile.Close()
+		it.chain.CurrentFile = f
+		it.chain.CurrentId++
+		raw, offset, err = fetchBlock(it.chain)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	it.index++
+	return raw, offset, nil
+}
 
+// fetchBlock returns the next raw block of the current file and its offset,
+// after the magic and the size.
+func fetchBlock(chain *blkparser.Blockchain) ([]byte, int64, error) {
+	pos, err := chain.CurrentFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, err
 	}
-	return transactions, nil
+	raw, err := chain.FetchNextBlock()
+	return raw, pos + 8, err
+}
+
+// Stream decodes the blocks from first up to last, excluded, in the
+// background, with Workers files at a time, and sends their transactions on the returned channel, which
+// holds at most buffer transactions: the decoding waits for the consumer.
+// Closing stop ends the decoding early. The error channel gets the error
+// that stopped the decoding, if any, and is closed with the transactions.
+func (p *Parser) Stream(first, last, buffer int, stop <-chan struct{}) (<-chan blkparser.Tx, <-chan error) {
+	txs := make(chan blkparser.Tx, buffer)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(txs)
+		it, err := p.open(first, last)
+		if err != nil {
+			if err != io.EOF {
+				errs <- err
+			}
+			return
+		}
+		defer it.Close()
+		for {
+			bl, err := it.Next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+			for _, tx := range bl.Txs {
+				select {
+				case txs <- *tx:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+	return txs, errs
 }
 
 // CheckBlockAvailable looks if the directory with the block exists or not.
@@ -86,44 +206,6 @@ func GetBlockDir() string {
 	return dir + "/blocks"
 }
 
-// DownloadBlock takes 'dir' as the directory where to download the block.
-// It returns the downloaded file
-func DownloadBlock(dir string) (string, error) {
-	log.Info("Downloading block-file")
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-	client := &http.Client{Transport: tr}
-	resp, err := client.Get("https://pop.dedis.ch/blk00000.dat")
-	if err != nil {
-		return "", err
-	}
-
-	os.RemoveAll(dir)
-	if err := os.MkdirAll(dir, 0777); err != nil {
-		return "", err
-	}
-	out, err := os.Create(dir + "/blk00000.dat")
-	defer out.Close()
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	// Calculate SHA-256 to make sure we downloaded the correct file
-	out.Seek(0, 0)
-	hash := sha256.New()
-	if _, err := io.Copy(hash, out); err != nil {
-		log.Fatal(err)
-	}
-	sha256 := hex.EncodeToString(hash.Sum(nil))
-	if sha256 != "fe4b764e0ce523cfce6d9e4f326e0d0977ee317a51b4a129cda707cfa4699dd7" {
-		log.Fatal("sha256 of downloaded file is wrong: ", sha256)
-	}
-
-	return GetBlockName(dir), nil
-}
-
 func getModDir() string {
 	ex, err := os.Executable()
 	if err != nil {
@@ -144,28 +226,3 @@ func getModDir() string {
 	}
 	panic("Didn't find mod dir")
 }
-
-// EnsureBlockIsAvailable tests if the block is already downloaded, else it will
-// download it. Finally the block will be copied to the 'simul'-provided
-// directory for simulation.
-func EnsureBlockIsAvailable(dir string) error {
-	tmpdir := getModDir() + "/blocks"
-	block := GetBlockName(tmpdir)
-	if block == "" {
-		var err error
-		block, err = DownloadBlock(tmpdir)
-		if err != nil || block == "" {
-			return err
-		}
-	}
-	destDir := dir + "/blocks"
-	os.RemoveAll(destDir)
-	if err := os.Mkdir(destDir, 0777); err != nil {
-		return err
-	}
-	cmd := exec.Command("cp", block, destDir)
-	if err := cmd.Start(); err != nil {
-		return err
-	}
-	return nil
-}
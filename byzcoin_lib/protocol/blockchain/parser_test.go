@@ -0,0 +1,148 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testMagic is the magic of the .dat files of the tests.
+var testMagic = [4]byte{0xF9, 0xBE, 0xB4, 0xD9}
+
+// writeBlocks writes files .dat files of perFile blocks of n transactions
+// each to dir, and returns the hashes of the transactions of every block.
+func writeBlocks(t *testing.T, dir string, files, perFile, n int) [][]string {
+	var hashes [][]string
+	for id := 0; id < files; id++ {
+		var file bytes.Buffer
+		for b := 0; b < perFile; b++ {
+			var raw bytes.Buffer
+			header := make([]byte, 80)
+			binary.LittleEndian.PutUint32(header[76:], uint32(len(hashes)))
+			raw.Write(header)
+			writeVarInt(&raw, n)
+			var block []string
+			for i := 0; i < n; i++ {
+				in := Outpoint{Hash: fmt.Sprintf("%064x", len(hashes)), Index: uint32(i)}
+				rawTx := serializeTx([]Outpoint{in}, []*blkparser.TxOut{{Value: 1}})
+				tx, _ := blkparser.NewTx(rawTx)
+				block = append(block, tx.Hash)
+				raw.Write(rawTx)
+			}
+			hashes = append(hashes, block)
+			file.Write(testMagic[:])
+			binary.Write(&file, binary.LittleEndian, uint32(raw.Len()))
+			file.Write(raw.Bytes())
+		}
+		require.Nil(t, os.WriteFile(blockFile(dir, id), file.Bytes(), 0600))
+	}
+	return hashes
+}
+
+// flatten returns the hashes of the blocks from first up to last, excluded.
+func flatten(blocks [][]string, first, last int) []string {
+	var ret []string
+	for _, b := range blocks[first:last] {
+		ret = append(ret, b...)
+	}
+	return ret
+}
+
+// txHashes returns the hashes of the transactions.
+func txHashes(txs []blkparser.Tx) []string {
+	var ret []string
+	for _, tx := range txs {
+		ret = append(ret, tx.Hash)
+	}
+	return ret
+}
+
+func TestParse(t *testing.T) {
+	dir := t.TempDir()
+	blocks := writeBlocks(t, dir, 3, 4, 3)
+	p, err := NewParser(dir, testMagic)
+	require.Nil(t, err)
+	for _, r := range [][2]int{{0, 12}, {2, 7}, {5, 6}, {10, 20}} {
+		txs, err := p.Parse(r[0], r[1])
+		require.Nil(t, err)
+		last := r[1]
+		if last > len(blocks) {
+			last = len(blocks)
+		}
+		assert.Equal(t, flatten(blocks, r[0], last), txHashes(txs), "%v", r)
+	}
+	txs, err := p.Parse(12, 20)
+	require.Nil(t, err)
+	assert.Empty(t, txs)
+
+	p, err = NewParser(t.TempDir(), testMagic)
+	require.Nil(t, err)
+	_, err = p.Parse(0, 1)
+	assert.NotNil(t, err, "no .dat file")
+}
+
+func TestBlocks(t *testing.T) {
+	dir := t.TempDir()
+	blocks := writeBlocks(t, dir, 2, 3, 2)
+	p, err := NewParser(dir, testMagic)
+	require.Nil(t, err)
+	// all of them, across the files
+	it, err := p.Blocks(1, -1)
+	require.Nil(t, err)
+	for i := 1; i < len(blocks); i++ {
+		b, err := it.Next()
+		require.Nil(t, err)
+		assert.Equal(t, uint32(i), b.Nonce)
+		require.Equal(t, 2, len(b.Txs))
+		assert.Equal(t, blocks[i][1], b.Txs[1].Hash)
+	}
+	_, err = it.Next()
+	assert.Equal(t, io.EOF, err)
+	require.Nil(t, it.Close())
+
+	it, err = p.Blocks(0, 2)
+	require.Nil(t, err)
+	defer it.Close()
+	for i := 0; i < 2; i++ {
+		_, err := it.Next()
+		require.Nil(t, err)
+	}
+	_, err = it.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestStream(t *testing.T) {
+	dir := t.TempDir()
+	blocks := writeBlocks(t, dir, 2, 3, 4)
+	p, err := NewParser(dir, testMagic)
+	require.Nil(t, err)
+	txs, errs := p.Stream(1, 5, 2, nil)
+	var hashes []string
+	for tx := range txs {
+		hashes = append(hashes, tx.Hash)
+	}
+	assert.Nil(t, <-errs)
+	assert.Equal(t, flatten(blocks, 1, 5), hashes)
+
+	// closing stop ends the decoding
+	stop := make(chan struct{})
+	txs, errs = p.Stream(0, -1, 0, stop)
+	<-txs
+	close(stop)
+	for range txs {
+	}
+	assert.Nil(t, <-errs)
+
+	p.Magic = [4]byte{}
+	txs, errs = p.Stream(0, -1, 0, nil)
+	for range txs {
+	}
+	assert.NotNil(t, <-errs, "bad magic")
+}
@@ -0,0 +1,87 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// ChainPolicy are the rules every block of a chain follows, held by its
+// genesis block, see Genesis. The leader checks its block against them
+// before it proposes it, and the members refuse a block that breaks them.
+// The zero value has no limits and needs the default threshold of signers.
+type ChainPolicy struct {
+	// MaxBlockBytes is the most bytes of the transactions of a block, 0
+	// for no limit
+	MaxBlockBytes int
+	// MaxTxCount is the most transactions of a block, 0 for no limit
+	MaxTxCount int
+	// TxVersions is the bitmap of the versions of the transactions the
+	// blocks can hold: version v is bit v. All versions are allowed if it
+	// is 0, see AllowVersions.
+	TxVersions uint64
+	// Threshold is how many members have to sign a block, n minus
+	// MaxExceptions(n) of n members if it is 0, see Signers.
+	Threshold int
+}
+
+// AllowVersions adds the versions to the ones the blocks can hold, up to
+// version 63.
+func (p *ChainPolicy) AllowVersions(versions ...uint32) {
+	for _, v := range versions {
+		if v < 64 {
+			p.TxVersions |= 1 << v
+		}
+	}
+}
+
+// Allows tells whether the blocks can hold transactions of the version.
+func (p ChainPolicy) Allows(version uint32) bool {
+	return p.TxVersions == 0 || version < 64 && p.TxVersions&(1<<version) != 0
+}
+
+// Signers returns how many of n members have to sign a block. A threshold
+// above n can't be met, so it falls back to the default.
+func (p ChainPolicy) Signers(n int) int {
+	if p.Threshold > 0 && p.Threshold <= n {
+		return p.Threshold
+	}
+	return n - MaxExceptions(n)
+}
+
+// Check returns an error if the block breaks the rules of the policy, a
+// TxError for a transaction of a version it doesn't allow, so that the
+// leader can drop it from the block.
+func (p ChainPolicy) Check(b *TrBlock) error {
+	for _, tx := range b.Txs {
+		if !p.Allows(tx.Version) {
+			return txError(tx.Hash, "version %d not allowed", tx.Version)
+		}
+	}
+	if p.MaxTxCount > 0 && len(b.Txs) > p.MaxTxCount {
+		return fmt.Errorf("block %s has %d transactions, more than %d",
+			b.HeaderHash, len(b.Txs), p.MaxTxCount)
+	}
+	if p.MaxBlockBytes > 0 {
+		size := 0
+		for _, tx := range b.Txs {
+			size += int(tx.Size)
+		}
+		if size > p.MaxBlockBytes {
+			return fmt.Errorf("block %s has %d bytes of transactions, more than %d",
+				b.HeaderHash, size, p.MaxBlockBytes)
+		}
+	}
+	return nil
+}
+
+// hashSum returns the hash of the policy, part of the hash of the genesis
+// block.
+func (p ChainPolicy) hashSum() []byte {
+	h := sha256.New()
+	binary.Write(h, binary.LittleEndian, int64(p.MaxBlockBytes))
+	binary.Write(h, binary.LittleEndian, int64(p.MaxTxCount))
+	binary.Write(h, binary.LittleEndian, p.TxVersions)
+	binary.Write(h, binary.LittleEndian, int64(p.Threshold))
+	return h.Sum(nil)
+}
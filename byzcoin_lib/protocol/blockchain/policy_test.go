@@ -0,0 +1,59 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainPolicy(t *testing.T) {
+	block := testBlock(blkparser.Tx{Hash: "a", Version: 1, Size: 100},
+		blkparser.Tx{Hash: "b", Version: 3, Size: 100})
+	assert.Nil(t, ChainPolicy{}.Check(block))
+
+	var p ChainPolicy
+	p.AllowVersions(1, 2, 64)
+	assert.True(t, p.Allows(2))
+	assert.False(t, p.Allows(3))
+	assert.False(t, p.Allows(64))
+	assert.Equal(t, "b", invalidTx(t, p.Check(block)))
+	p.AllowVersions(3)
+	assert.Nil(t, p.Check(block))
+
+	assert.NotNil(t, ChainPolicy{MaxTxCount: 1}.Check(block))
+	assert.Nil(t, ChainPolicy{MaxTxCount: 2}.Check(block))
+	assert.NotNil(t, ChainPolicy{MaxBlockBytes: 150}.Check(block))
+	assert.Nil(t, ChainPolicy{MaxBlockBytes: 200}.Check(block))
+}
+
+func TestChainPolicySigners(t *testing.T) {
+	assert.Equal(t, 10-MaxExceptions(10), ChainPolicy{}.Signers(10))
+	assert.Equal(t, 10, ChainPolicy{Threshold: 10}.Signers(10))
+	// a threshold that can't be met falls back to the default
+	assert.Equal(t, ChainPolicy{}.Signers(10), ChainPolicy{Threshold: 11}.Signers(10))
+}
+
+func TestGenesisPolicy(t *testing.T) {
+	policy := ChainPolicy{MaxBlockBytes: 150}
+	genesis := NewGenesisBlock(nil, 0, nil, policy)
+	require.True(t, genesis.IsGenesis())
+	assert.Equal(t, policy, genesis.Header.Genesis.Policy)
+	// the policy is part of the ID of the chain
+	assert.NotEqual(t, NewGenesisBlock(nil, 0, nil, ChainPolicy{}).HeaderHash,
+		genesis.HeaderHash)
+	assert.NotEqual(t, NewGenesisBlock(nil, 0, nil, ChainPolicy{MaxBlockBytes: 100}).HeaderHash,
+		genesis.HeaderHash)
+
+	// the next blocks of the chain follow it
+	v := NewChainValidator("", -1)
+	require.Nil(t, v.Append(genesis))
+	next := func(txs ...blkparser.Tx) *TrBlock {
+		tl := NewTransactionList(txs, len(txs))
+		return NewTrBlock(tl, NewHeader(tl, genesis.HeaderHash, ""))
+	}
+	assert.NotNil(t, v.Check(next(blkparser.Tx{Hash: "a", Size: 100},
+		blkparser.Tx{Hash: "b", Size: 100})))
+	assert.Nil(t, v.Check(next(blkparser.Tx{Hash: "a", Size: 100})))
+}
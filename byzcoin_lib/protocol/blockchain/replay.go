@@ -0,0 +1,165 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"sync"
+)
+
+// SlidingBloom is a bloom filter over the last items added. It is made of
+// generations of capacity items each: the latest one takes the items, and
+// once it is full the oldest one is dropped for a new one, so that it
+// remembers at least the last (generations-1)*capacity items. It can tell
+// an item has been added when it hasn't, at the false positive rate of
+// NewSlidingBloom for every generation.
+type SlidingBloom struct {
+	capacity int
+	// bits is the size of a generation, hashes how many of its bits an
+	// item sets
+	bits   uint64
+	hashes int
+	// generations are the bits of the generations, the latest last, and
+	// count the items of the latest
+	generations [][]uint64
+	count       int
+}
+
+// NewSlidingBloom returns an empty filter of the generations of capacity
+// items, sized for the false positive rate.
+func NewSlidingBloom(generations, capacity int, falsePositive float64) *SlidingBloom {
+	if generations < 1 {
+		generations = 1
+	}
+	if capacity < 1 {
+		capacity = 1
+	}
+	bits := math.Ceil(-float64(capacity) * math.Log(falsePositive) /
+		(math.Ln2 * math.Ln2))
+	hashes := int(math.Round(bits / float64(capacity) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	b := &SlidingBloom{
+		capacity: capacity,
+		bits:     uint64(bits),
+		hashes:   hashes,
+	}
+	for i := 0; i < generations; i++ {
+		b.generations = append(b.generations, b.generation())
+	}
+	return b
+}
+
+// generation returns the empty bits of a generation.
+func (b *SlidingBloom) generation() []uint64 {
+	return make([]uint64, (b.bits+63)/64)
+}
+
+// Add adds the item to the latest generation.
+func (b *SlidingBloom) Add(item string) {
+	if b.count == b.capacity {
+		copy(b.generations, b.generations[1:])
+		b.generations[len(b.generations)-1] = b.generation()
+		b.count = 0
+	}
+	latest := b.generations[len(b.generations)-1]
+	for _, bit := range b.positions(item) {
+		latest[bit/64] |= 1 << (bit % 64)
+	}
+	b.count++
+}
+
+// Has returns whether the item may have been added to one of the
+// generations.
+func (b *SlidingBloom) Has(item string) bool {
+	positions := b.positions(item)
+	for _, g := range b.generations {
+		found := true
+		for _, bit := range positions {
+			if g[bit/64]&(1<<(bit%64)) == 0 {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// positions returns the bits the item sets in a generation, read from a
+// chain of hashes of the item.
+func (b *SlidingBloom) positions(item string) []uint64 {
+	positions := make([]uint64, b.hashes)
+	h := sha256.Sum256([]byte(item))
+	for i := range positions {
+		word := i % (sha256.Size / 8)
+		if i > 0 && word == 0 {
+			h = sha256.Sum256(h[:])
+		}
+		positions[i] = binary.LittleEndian.Uint64(h[word*8:]) % b.bits
+	}
+	return positions
+}
+
+// ReplayFilter remembers the hashes of the transactions put in blocks, so
+// that a transaction sent again is committed at most once: exactly for the
+// last blocks, and in a SlidingBloom for the older ones, which may take a
+// new transaction for a replayed one at its false positive rate.
+type ReplayFilter struct {
+	mutex sync.Mutex
+	// recent are the hashes of the last blocks, the oldest first, and
+	// exact counts the blocks holding each of them
+	recent    [][]string
+	maxRecent int
+	exact     map[string]int
+	// older holds the hashes of the blocks before, nil to forget them
+	older *SlidingBloom
+}
+
+// NewReplayFilter returns a filter remembering exactly the transactions of
+// the last recentBlocks blocks, and the older ones in the bloom filter if
+// it isn't nil.
+func NewReplayFilter(recentBlocks int, older *SlidingBloom) *ReplayFilter {
+	return &ReplayFilter{
+		maxRecent: recentBlocks,
+		exact:     make(map[string]int),
+		older:     older,
+	}
+}
+
+// AddBlock remembers the hashes of the transactions of a block, moving the
+// ones of the oldest recent block to the bloom filter if there are too many
+// recent blocks.
+func (f *ReplayFilter) AddBlock(hashes []string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.recent = append(f.recent, hashes)
+	for _, h := range hashes {
+		f.exact[h]++
+	}
+	for len(f.recent) > f.maxRecent {
+		for _, h := range f.recent[0] {
+			if f.exact[h]--; f.exact[h] <= 0 {
+				delete(f.exact, h)
+			}
+			if f.older != nil {
+				f.older.Add(h)
+			}
+		}
+		f.recent = f.recent[1:]
+	}
+}
+
+// Committed returns whether the transaction of the hash is in one of the
+// blocks added, possibly wrongly if it is not one of the recent ones.
+func (f *ReplayFilter) Committed(hash string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.exact[hash] > 0 {
+		return true
+	}
+	return f.older != nil && f.older.Has(hash)
+}
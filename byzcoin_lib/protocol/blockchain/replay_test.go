@@ -0,0 +1,74 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// items returns the n items of the prefix.
+func items(prefix string, n int) []string {
+	var ret []string
+	for i := 0; i < n; i++ {
+		ret = append(ret, fmt.Sprint(prefix, i))
+	}
+	return ret
+}
+
+func TestSlidingBloom(t *testing.T) {
+	b := NewSlidingBloom(2, 1000, 1e-3)
+	// about 14.4 bits and 10 hashes per item
+	assert.Equal(t, uint64(14378), b.bits)
+	assert.Equal(t, 10, b.hashes)
+	for _, item := range items("a", 1000) {
+		b.Add(item)
+	}
+	for _, item := range items("a", 1000) {
+		assert.True(t, b.Has(item))
+	}
+	fp := 0
+	for _, item := range items("x", 10000) {
+		if b.Has(item) {
+			fp++
+		}
+	}
+	// 10 expected in the full generation
+	assert.True(t, fp < 40, "%d false positives", fp)
+
+	// the oldest generation is dropped once the latest is full
+	b = NewSlidingBloom(2, 10, 1e-6)
+	for _, item := range items("a", 30) {
+		b.Add(item)
+	}
+	for i, item := range items("a", 30) {
+		assert.Equal(t, i >= 10, b.Has(item), item)
+	}
+	assert.Equal(t, 1, NewSlidingBloom(0, 0, 0.5).hashes)
+}
+
+func TestReplayFilter(t *testing.T) {
+	f := NewReplayFilter(2, NewSlidingBloom(2, 100, 1e-6))
+	for i := 0; i < 4; i++ {
+		f.AddBlock(items(fmt.Sprint(i, "-"), 50))
+	}
+	assert.Equal(t, 2, len(f.recent))
+	assert.Equal(t, 100, len(f.exact))
+	// the older blocks are in the bloom filter
+	for i := 0; i < 4; i++ {
+		assert.True(t, f.Committed(fmt.Sprint(i, "-", 7)))
+	}
+	assert.False(t, f.Committed("x"))
+
+	// without one, they are forgotten
+	f = NewReplayFilter(2, nil)
+	f.AddBlock([]string{"a"})
+	f.AddBlock([]string{"a", "b"})
+	f.AddBlock([]string{"c"})
+	// a is still in a recent block
+	assert.True(t, f.Committed("a"))
+	f.AddBlock([]string{"d"})
+	assert.False(t, f.Committed("a"))
+	assert.False(t, f.Committed("b"))
+	assert.True(t, f.Committed("c"))
+}
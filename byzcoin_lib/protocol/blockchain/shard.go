@@ -0,0 +1,106 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+)
+
+// coinbaseVout is the output index of the input of a coinbase transaction,
+// which doesn't spend anything.
+const coinbaseVout = 4294967295
+
+// UTXOID returns the identifier of the output vout of the transaction hash.
+func UTXOID(hash string, vout uint32) string {
+	return fmt.Sprintf("%s:%d", hash, vout)
+}
+
+// ShardOf returns the shard holding the unspent output, given by the prefix
+// of the hash of its identifier.
+func ShardOf(utxo string, shards int) int {
+	if shards <= 1 {
+		return 0
+	}
+	h := sha256.Sum256([]byte(utxo))
+	return int(binary.BigEndian.Uint32(h[:4]) % uint32(shards))
+}
+
+// ShardedTx is a transaction declaring the shards holding its inputs and the
+// shards receiving its outputs.
+type ShardedTx struct {
+	blkparser.Tx
+	InputShards  []int
+	OutputShards []int
+}
+
+// NewShardedTx returns the transaction with the shards of its inputs and
+// outputs if the unspent outputs are split among the given number of shards.
+// The input of a coinbase transaction doesn't belong to any shard.
+func NewShardedTx(tx blkparser.Tx, shards int) *ShardedTx {
+	st := &ShardedTx{Tx: tx}
+	for _, in := range tx.TxIns {
+		if in.InputVout == coinbaseVout {
+			continue
+		}
+		st.InputShards = append(st.InputShards,
+			ShardOf(UTXOID(in.InputHash, in.InputVout), shards))
+	}
+	for i := range tx.TxOuts {
+		st.OutputShards = append(st.OutputShards,
+			ShardOf(UTXOID(tx.Hash, uint32(i)), shards))
+	}
+	st.InputShards = uniqueShards(st.InputShards)
+	st.OutputShards = uniqueShards(st.OutputShards)
+	return st
+}
+
+// Shards returns the sorted list of all shards the transaction touches.
+func (st *ShardedTx) Shards() []int {
+	var shards []int
+	shards = append(shards, st.InputShards...)
+	shards = append(shards, st.OutputShards...)
+	return uniqueShards(shards)
+}
+
+// CrossShard returns true if the transaction touches more than one shard.
+func (st *ShardedTx) CrossShard() bool {
+	return len(st.Shards()) > 1
+}
+
+// Part returns the part of the transaction handled by the shard: its inputs
+// and outputs in that shard. The hash stays the one of the whole transaction,
+// so that the shards can tell which parts belong together.
+func (st *ShardedTx) Part(shard, shards int) blkparser.Tx {
+	part := st.Tx
+	part.TxIns = nil
+	part.TxOuts = nil
+	for _, in := range st.TxIns {
+		if in.InputVout != coinbaseVout &&
+			ShardOf(UTXOID(in.InputHash, in.InputVout), shards) == shard {
+			part.TxIns = append(part.TxIns, in)
+		}
+	}
+	for i, out := range st.TxOuts {
+		if ShardOf(UTXOID(st.Hash, uint32(i)), shards) == shard {
+			part.TxOuts = append(part.TxOuts, out)
+		}
+	}
+	part.TxInCnt = uint32(len(part.TxIns))
+	part.TxOutCnt = uint32(len(part.TxOuts))
+	return part
+}
+
+// uniqueShards sorts the shards and removes the duplicates.
+func uniqueShards(shards []int) []int {
+	sort.Ints(shards)
+	var ret []int
+	for i, s := range shards {
+		if i == 0 || s != shards[i-1] {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,80 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// spendingTx returns the transaction hash spending the outputs 0 of the
+// transactions in0, in0+1, ... with the outputs of the values.
+func spendingTx(hash string, in0, ins int, values ...uint64) blkparser.Tx {
+	tx := blkparser.Tx{Hash: hash}
+	for i := 0; i < ins; i++ {
+		tx.TxIns = append(tx.TxIns, &blkparser.TxIn{InputHash: fmt.Sprint(in0 + i)})
+	}
+	for _, v := range values {
+		tx.TxOuts = append(tx.TxOuts, &blkparser.TxOut{Value: v})
+	}
+	return tx
+}
+
+func TestShardOf(t *testing.T) {
+	counts := make([]int, 4)
+	for i := 0; i < 400; i++ {
+		id := UTXOID(fmt.Sprint(i), 0)
+		shard := ShardOf(id, 4)
+		require.True(t, shard >= 0 && shard < 4)
+		assert.Equal(t, shard, ShardOf(id, 4))
+		assert.Equal(t, 0, ShardOf(id, 1))
+		assert.Equal(t, 0, ShardOf(id, 0))
+		counts[shard]++
+	}
+	// the outputs are spread among the shards
+	for _, c := range counts {
+		assert.True(t, c > 50, "%v", counts)
+	}
+}
+
+func TestShardedTx(t *testing.T) {
+	tx := spendingTx("tx", 0, 8, 1, 2, 3, 4)
+	st := NewShardedTx(tx, 3)
+	require.True(t, st.CrossShard())
+	for _, in := range tx.TxIns {
+		assert.Contains(t, st.InputShards, ShardOf(UTXOID(in.InputHash, 0), 3))
+	}
+	for i := range tx.TxOuts {
+		assert.Contains(t, st.OutputShards, ShardOf(UTXOID("tx", uint32(i)), 3))
+	}
+	assert.Equal(t, []int{0, 1, 2}, st.Shards())
+
+	// the parts of the shards split the inputs and the outputs
+	var ins, outs int
+	for _, shard := range st.Shards() {
+		part := st.Part(shard, 3)
+		assert.Equal(t, "tx", part.Hash)
+		assert.Equal(t, len(part.TxIns), int(part.TxInCnt))
+		assert.Equal(t, len(part.TxOuts), int(part.TxOutCnt))
+		for _, in := range part.TxIns {
+			assert.Equal(t, shard, ShardOf(UTXOID(in.InputHash, 0), 3))
+		}
+		ins += len(part.TxIns)
+		outs += len(part.TxOuts)
+	}
+	assert.Equal(t, 8, ins)
+	assert.Equal(t, 4, outs)
+	assert.Equal(t, 8, len(tx.TxIns), "the transaction changed")
+
+	// a single shard or a coinbase transaction
+	assert.False(t, NewShardedTx(tx, 1).CrossShard())
+	coinbase := blkparser.Tx{Hash: "coinbase",
+		TxIns:  []*blkparser.TxIn{{InputVout: coinbaseVout}},
+		TxOuts: []*blkparser.TxOut{{Value: 1}}}
+	st = NewShardedTx(coinbase, 3)
+	assert.Empty(t, st.InputShards)
+	assert.False(t, st.CrossShard())
+	assert.Empty(t, st.Part(st.Shards()[0], 3).TxIns)
+}
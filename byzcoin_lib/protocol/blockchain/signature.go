@@ -0,0 +1,42 @@
+package blockchain
+
+import (
+	"errors"
+	"math"
+
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+	"gopkg.in/dedis/crypto.v0/abstract"
+)
+
+// HeaderSignature is the collective signature of the commit round of
+// ByzCoin on the hash of the header of a block. As the header holds the
+// merkle root of the transactions, it is a compact proof that the shard
+// committed the block, which light clients and other shards can check
+// with the public keys of the shard only. The members that did not sign are
+// the ones out of its mask.
+type HeaderSignature struct {
+	crypto.MaskedSignature
+}
+
+// MaxExceptions returns how many of n members can refuse to sign a block
+// for the signature to be valid.
+func MaxExceptions(n int) int {
+	return int(math.Ceil(float64(n) / 3.0))
+}
+
+// VerifySignature checks that the block carries a collective signature on
+// its header by the members with the given public keys, signed by as many
+// as the policy of the chain needs, see ChainPolicy.Signers.
+func (tr *TrBlock) VerifySignature(suite abstract.Suite, publics []abstract.Point,
+	policy ChainPolicy) error {
+	if tr.Header == nil {
+		return errors.New("block without header")
+	}
+	sig := tr.Signature
+	if sig == nil || sig.Challenge == nil || sig.Response == nil {
+		return errors.New("block is not signed")
+	}
+	signers := policy.Signers(len(publics))
+	return crypto.NewSignaturePolicy(suite, publics, signers).
+		VerifyMasked(tr.Header.HashSum(), &sig.MaskedSignature)
+}
@@ -0,0 +1,50 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/dedis/paper_17_sosp_omniledger/cosi"
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func TestMaxExceptions(t *testing.T) {
+	for n, max := range map[int]int{1: 1, 3: 1, 4: 2, 7: 3, 9: 3, 10: 4} {
+		assert.Equal(t, max, MaxExceptions(n), "%d members", n)
+	}
+}
+
+func TestHeaderSignatureMarshal(t *testing.T) {
+	suite := network.Suite
+	b := testBlock()
+	b.Signature = &HeaderSignature{crypto.MaskedSignature{
+		Signature: cosi.Signature{Challenge: suite.Scalar().One(),
+			Response: suite.Scalar().One()},
+		Mask:            crypto.SignerMask{5, 1},
+		ExceptionCommit: suite.Point().Base(),
+	}}
+	buf, err := network.Marshal(b)
+	require.Nil(t, err)
+	_, msg, err := network.Unmarshal(buf)
+	require.Nil(t, err)
+	sig := msg.(*TrBlock).Signature
+	require.NotNil(t, sig)
+	assert.Equal(t, b.Signature.Mask, sig.Mask)
+	assert.True(t, sig.ExceptionCommit.Equal(suite.Point().Base()))
+	assert.True(t, sig.Challenge.Equal(suite.Scalar().One()))
+	assert.True(t, sig.Response.Equal(suite.Scalar().One()))
+}
+
+func TestVerifyUnsigned(t *testing.T) {
+	publics := []abstract.Point{config.NewKeyPair(network.Suite).Public}
+	b := testBlock(testTx("a", 1))
+	assert.NotNil(t, b.VerifySignature(network.Suite, publics, ChainPolicy{}))
+	b.Signature = &HeaderSignature{}
+	assert.NotNil(t, b.VerifySignature(network.Suite, publics, ChainPolicy{}))
+	b.Header = nil
+	assert.NotNil(t, b.VerifySignature(network.Suite, publics, ChainPolicy{}))
+}
@@ -0,0 +1,441 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// snapshotVersion is the version of the encodings of the sets, the diffs and
+// the snapshots.
+const snapshotVersion = 1
+
+// The encodings of the hashes.
+const (
+	// rawHash is followed by the length of the hash and the hash
+	rawHash = 0
+	// binaryHash is followed by the 32 bytes of a hex encoded hash
+	binaryHash = 1
+)
+
+// errTruncated is returned when the data ends too early.
+var errTruncated = errors.New("truncated data")
+
+// Marshal returns the compact encoding of the unspent outputs of the set,
+// without the reservations: the outputs are grouped by transaction, and the
+// hex encoded hashes take 32 bytes.
+func (s *UTXOSet) Marshal() ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var buf bytes.Buffer
+	buf.WriteByte(snapshotVersion)
+	writeUvarint(&buf, uint64(s.Shard))
+	writeUvarint(&buf, uint64(s.Shards))
+	ids := make([]string, 0, len(s.Outputs))
+	for id := range s.Outputs {
+		ids = append(ids, id)
+	}
+	if err := writeOutputs(&buf, ids, s.Outputs); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalUTXOSet returns the set encoded by UTXOSet.Marshal.
+func UnmarshalUTXOSet(data []byte) (*UTXOSet, error) {
+	r := &snapReader{data: data}
+	if v := r.byte(); r.err == nil && v != snapshotVersion {
+		return nil, fmt.Errorf("unknown version %d", v)
+	}
+	shard := int(r.uvarint())
+	s := NewUTXOSet(shard, int(r.uvarint()))
+	r.outputs(func(id string, v uint64) { s.Outputs[id] = v }, true)
+	if err := r.end(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Marshal returns the compact encoding of the diff, see UTXOSet.Marshal.
+func (d *UTXODiff) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(snapshotVersion)
+	writeHash(&buf, d.Block)
+	if err := writeOutputs(&buf, d.Spent, nil); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(d.Created))
+	for id := range d.Created {
+		ids = append(ids, id)
+	}
+	if err := writeOutputs(&buf, ids, d.Created); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalUTXODiff returns the diff encoded by UTXODiff.Marshal.
+func UnmarshalUTXODiff(data []byte) (*UTXODiff, error) {
+	r := &snapReader{data: data}
+	if v := r.byte(); r.err == nil && v != snapshotVersion {
+		return nil, fmt.Errorf("unknown version %d", v)
+	}
+	d := &UTXODiff{Block: r.hash(), Created: make(map[string]uint64)}
+	r.outputs(func(id string, _ uint64) { d.Spent = append(d.Spent, id) }, false)
+	r.outputs(func(id string, v uint64) { d.Created[id] = v }, true)
+	if err := r.end(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// SnapshotDir keeps the unspent outputs of shards in a directory, so that a
+// long simulation can restart from where it stopped: for each shard, the
+// latest snapshot of its set and the diffs of the blocks applied since.
+type SnapshotDir struct {
+	Dir string
+}
+
+// NewSnapshotDir returns the snapshots of dir, creating it if needed.
+func NewSnapshotDir(dir string) (*SnapshotDir, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	return &SnapshotDir{Dir: dir}, nil
+}
+
+// Chain returns the snapshots of the chain with the genesis ID, kept in its
+// own subdirectory so that the shards of several chains don't collide.
+func (sd *SnapshotDir) Chain(genesisID string) (*SnapshotDir, error) {
+	if genesisID == "" {
+		return nil, errors.New("empty genesis ID")
+	}
+	return NewSnapshotDir(filepath.Join(sd.Dir, genesisID))
+}
+
+// Save writes the snapshot of the set, whose latest block is tip at height,
+// and drops the diffs it includes.
+func (sd *SnapshotDir) Save(s *UTXOSet, tip string, height int) error {
+	set, err := s.Marshal()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(snapshotVersion)
+	writeHash(&buf, tip)
+	writeVarint(&buf, int64(height))
+	buf.Write(set)
+
+	path := sd.snapshotPath(s.Shard)
+	if err := os.WriteFile(path+".tmp", buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	// the diffs are skipped by Load anyway, this only saves space
+	err = os.Remove(sd.diffsPath(s.Shard))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// AppendDiff appends the diff of the block at height to the diffs of the
+// shard.
+func (sd *SnapshotDir) AppendDiff(shard, height int, d *UTXODiff) error {
+	data, err := d.Marshal()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	writeUvarint(&buf, uint64(len(data)))
+	writeVarint(&buf, int64(height))
+	buf.Write(data)
+	f, err := os.OpenFile(sd.diffsPath(shard), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load returns the set of the shard out of its snapshot and the diffs
+// following it, with the hash and the height of its latest block. Without
+// snapshot, the set is empty and the height is -1. A diff cut by a crash
+// while it was appended is ignored.
+func (sd *SnapshotDir) Load(shard, shards int) (*UTXOSet, string, int, error) {
+	s := NewUTXOSet(shard, shards)
+	tip, height := "", -1
+	data, err := os.ReadFile(sd.snapshotPath(shard))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, "", 0, err
+	default:
+		r := &snapReader{data: data}
+		if v := r.byte(); r.err == nil && v != snapshotVersion {
+			return nil, "", 0, fmt.Errorf("unknown version %d", v)
+		}
+		tip = r.hash()
+		height = int(r.varint())
+		if r.err != nil {
+			return nil, "", 0, r.err
+		}
+		if s, err = UnmarshalUTXOSet(r.data); err != nil {
+			return nil, "", 0, err
+		}
+		if s.Shard != shard || s.Shards != shards {
+			return nil, "", 0, fmt.Errorf("snapshot of shard %d/%d instead of %d/%d",
+				s.Shard, s.Shards, shard, shards)
+		}
+	}
+
+	f, err := os.Open(sd.diffsPath(shard))
+	if os.IsNotExist(err) {
+		return s, tip, height, nil
+	} else if err != nil {
+		return nil, "", 0, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	for {
+		d, h, err := readDiff(br)
+		if err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			log.Warn("Ignoring the truncated diff after block", height)
+			break
+		} else if err != nil {
+			return nil, "", 0, err
+		}
+		if h <= height {
+			// already in the snapshot
+			continue
+		}
+		if h != height+1 {
+			return nil, "", 0, fmt.Errorf("diff of block %d follows block %d", h, height)
+		}
+		if err := s.ApplyDiff(d); err != nil {
+			return nil, "", 0, err
+		}
+		tip, height = d.Block, h
+	}
+	return s, tip, height, nil
+}
+
+func (sd *SnapshotDir) snapshotPath(shard int) string {
+	return filepath.Join(sd.Dir, fmt.Sprintf("utxo-%d.snap", shard))
+}
+
+func (sd *SnapshotDir) diffsPath(shard int) string {
+	return filepath.Join(sd.Dir, fmt.Sprintf("utxo-%d.diffs", shard))
+}
+
+// readDiff reads the next diff appended by AppendDiff, and its height.
+func readDiff(r *bufio.Reader) (*UTXODiff, int, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	height, err := binary.ReadVarint(r)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	d, err := UnmarshalUTXODiff(data)
+	return d, int(height), err
+}
+
+// writeOutputs writes the number of transactions of the outputs, then for
+// each transaction its hash and the number of its outputs, and for each
+// output its index and, if values isn't nil, its value.
+func writeOutputs(buf *bytes.Buffer, ids []string, values map[string]uint64) error {
+	type output struct {
+		hash string
+		vout uint32
+		id   string
+	}
+	outs := make([]output, len(ids))
+	for i, id := range ids {
+		sep := strings.LastIndexByte(id, ':')
+		vout, err := strconv.ParseUint(id[sep+1:], 10, 32)
+		if sep < 0 || err != nil {
+			return fmt.Errorf("invalid output %q", id)
+		}
+		outs[i] = output{id[:sep], uint32(vout), id}
+	}
+	sort.Slice(outs, func(i, j int) bool {
+		if outs[i].hash != outs[j].hash {
+			return outs[i].hash < outs[j].hash
+		}
+		return outs[i].vout < outs[j].vout
+	})
+	var txs int
+	for i := range outs {
+		if i == 0 || outs[i].hash != outs[i-1].hash {
+			txs++
+		}
+	}
+	writeUvarint(buf, uint64(txs))
+	for i := 0; i < len(outs); {
+		j := i
+		for j < len(outs) && outs[j].hash == outs[i].hash {
+			j++
+		}
+		writeHash(buf, outs[i].hash)
+		writeUvarint(buf, uint64(j-i))
+		for _, o := range outs[i:j] {
+			writeUvarint(buf, uint64(o.vout))
+			if values != nil {
+				writeUvarint(buf, values[o.id])
+			}
+		}
+		i = j
+	}
+	return nil
+}
+
+// writeHash writes the hash in 32 bytes if it is hex encoded, as is
+// otherwise.
+func writeHash(buf *bytes.Buffer, h string) {
+	if b, err := hex.DecodeString(h); err == nil && len(b) == 32 &&
+		hex.EncodeToString(b) == h {
+		buf.WriteByte(binaryHash)
+		buf.Write(b)
+		return
+	}
+	buf.WriteByte(rawHash)
+	writeUvarint(buf, uint64(len(h)))
+	buf.WriteString(h)
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func writeVarint(buf *bytes.Buffer, v int64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+// snapReader reads the encodings of this file, keeping the first error so
+// that it is only checked at the end.
+type snapReader struct {
+	data []byte
+	err  error
+}
+
+func (r *snapReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.data) == 0 {
+		r.err = errTruncated
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *snapReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if uint64(len(r.data)) < n {
+		r.err = errTruncated
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *snapReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = errTruncated
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *snapReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = errTruncated
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *snapReader) hash() string {
+	switch r.byte() {
+	case binaryHash:
+		return hex.EncodeToString(r.bytes(32))
+	case rawHash:
+		return string(r.bytes(r.uvarint()))
+	}
+	if r.err == nil {
+		r.err = errors.New("invalid hash encoding")
+	}
+	return ""
+}
+
+// outputs reads the outputs written by writeOutputs and gives them to fn.
+func (r *snapReader) outputs(fn func(id string, value uint64), values bool) {
+	for txs := r.uvarint(); txs > 0 && r.err == nil; txs-- {
+		hash := r.hash()
+		for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+			id := UTXOID(hash, uint32(r.uvarint()))
+			var v uint64
+			if values {
+				v = r.uvarint()
+			}
+			if r.err == nil {
+				fn(id, v)
+			}
+		}
+	}
+}
+
+// end returns the first error, or an error if there is data left.
+func (r *snapReader) end() error {
+	if r.err == nil && len(r.data) > 0 {
+		r.err = errors.New("trailing data")
+	}
+	return r.err
+}
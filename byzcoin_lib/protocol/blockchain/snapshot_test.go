@@ -0,0 +1,131 @@
+package blockchain
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hexHash returns the i-th hex encoded hash, encoded in 32 bytes.
+func hexHash(i int) string {
+	return fmt.Sprintf("%064x", i)
+}
+
+func TestUTXOSetMarshal(t *testing.T) {
+	s := NewUTXOSet(1, 3)
+	s.Seed(map[string]uint64{UTXOID("a", 0): 10, UTXOID("a", 7): 3,
+		UTXOID(hexHash(1), 0): 5, UTXOID(hexHash(1), 1): 0})
+	require.Nil(t, s.Reserve("block", []blkparser.Tx{testTx("x", 10, Outpoint{"a", 0})}))
+	data, err := s.Marshal()
+	require.Nil(t, err)
+	c, err := UnmarshalUTXOSet(data)
+	require.Nil(t, err)
+	assert.Equal(t, 1, c.Shard)
+	assert.Equal(t, 3, c.Shards)
+	assert.Equal(t, s.Outputs, c.Outputs)
+	// the reservations aren't kept
+	assert.Empty(t, c.reserved)
+
+	_, err = UnmarshalUTXOSet(data[:len(data)-1])
+	assert.NotNil(t, err, "truncated")
+	_, err = UnmarshalUTXOSet(append(data, 0))
+	assert.NotNil(t, err, "trailing data")
+	data[0]++
+	_, err = UnmarshalUTXOSet(data)
+	assert.NotNil(t, err, "unknown version")
+	s = NewUTXOSet(0, 1)
+	s.Seed(map[string]uint64{"invalid": 1})
+	_, err = s.Marshal()
+	assert.NotNil(t, err)
+}
+
+func TestUTXODiffMarshal(t *testing.T) {
+	for _, d := range []*UTXODiff{
+		{Block: hexHash(1), Spent: []string{UTXOID("a", 0), UTXOID(hexHash(2), 1)},
+			Created: map[string]uint64{UTXOID(hexHash(3), 0): 7, UTXOID(hexHash(3), 2): 1}},
+		{Block: "block", Created: map[string]uint64{}},
+	} {
+		data, err := d.Marshal()
+		require.Nil(t, err)
+		c, err := UnmarshalUTXODiff(data)
+		require.Nil(t, err)
+		assert.Equal(t, d.Block, c.Block)
+		assert.ElementsMatch(t, d.Spent, c.Spent)
+		assert.Equal(t, d.Created, c.Created)
+		_, err = UnmarshalUTXODiff(data[:len(data)-1])
+		assert.NotNil(t, err)
+	}
+}
+
+func TestSnapshotDir(t *testing.T) {
+	sd, err := NewSnapshotDir(filepath.Join(t.TempDir(), "snapshots"))
+	require.Nil(t, err)
+	// without snapshot, the set is empty
+	s, tip, height, err := sd.Load(0, 1)
+	require.Nil(t, err)
+	assert.Empty(t, s.Outputs)
+	assert.Equal(t, "", tip)
+	assert.Equal(t, -1, height)
+
+	s = testSet()
+	require.Nil(t, sd.Save(s, hexHash(1), 1))
+	blocks := [][]blkparser.Tx{
+		{testTx("x", 10, Outpoint{"a", 0})},
+		{testTx("y", 15, Outpoint{"x", 0}, Outpoint{"b", 0})},
+	}
+	for i, txs := range blocks {
+		d, err := s.Diff(hexHash(i+2), txs)
+		require.Nil(t, err)
+		require.Nil(t, s.ApplyDiff(d))
+		require.Nil(t, sd.AppendDiff(0, i+2, d))
+	}
+	loaded, tip, height, err := sd.Load(0, 1)
+	require.Nil(t, err)
+	assert.Equal(t, s.Outputs, loaded.Outputs)
+	assert.Equal(t, hexHash(3), tip)
+	assert.Equal(t, 3, height)
+	_, _, _, err = sd.Load(0, 2)
+	assert.NotNil(t, err, "another number of shards")
+
+	// a diff cut while it was appended is ignored
+	f, err := os.OpenFile(sd.diffsPath(0), os.O_WRONLY|os.O_APPEND, 0600)
+	require.Nil(t, err)
+	_, err = f.Write([]byte{40, 8, 1})
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+	_, tip, height, err = sd.Load(0, 1)
+	require.Nil(t, err)
+	assert.Equal(t, hexHash(3), tip)
+	assert.Equal(t, 3, height)
+
+	// a new snapshot drops the diffs, and the diffs it includes are skipped
+	require.Nil(t, sd.Save(s, hexHash(3), 3))
+	_, err = os.Stat(sd.diffsPath(0))
+	assert.True(t, os.IsNotExist(err))
+	d, err := s.Diff(hexHash(4), nil)
+	require.Nil(t, err)
+	require.Nil(t, sd.AppendDiff(0, 3, d))
+	_, tip, height, err = sd.Load(0, 1)
+	require.Nil(t, err)
+	assert.Equal(t, hexHash(3), tip)
+	assert.Equal(t, 3, height)
+	// but not a gap in the heights
+	require.Nil(t, sd.AppendDiff(0, 5, d))
+	_, _, _, err = sd.Load(0, 1)
+	assert.NotNil(t, err)
+
+	// the shards of the chains don't collide
+	_, err = sd.Chain("")
+	assert.NotNil(t, err)
+	chain, err := sd.Chain("genesis")
+	require.Nil(t, err)
+	assert.NotEqual(t, sd.Dir, chain.Dir)
+	_, _, height, err = chain.Load(0, 1)
+	require.Nil(t, err)
+	assert.Equal(t, -1, height)
+}
@@ -0,0 +1,141 @@
+package blockchain
+
+import (
+	"errors"
+	"sync"
+
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func init() {
+	network.RegisterMessage(TrBlock{})
+}
+
+// ErrBlockNotFound is returned by the stores for unknown blocks.
+var ErrBlockNotFound = errors.New("block not found")
+
+// Store keeps the finalized blocks of a chain, indexed by their hash and by
+// the order they were finalized in, starting at 0.
+type Store interface {
+	// Put appends the block, with its signature, to the chain.
+	Put(b *TrBlock) error
+	// GetByHash returns the block with the header hash.
+	GetByHash(hash string) (*TrBlock, error)
+	// GetByIndex returns the block of the index.
+	GetByIndex(index int) (*TrBlock, error)
+	// LatestBlock returns the last block put and its index.
+	LatestBlock() (*TrBlock, int, error)
+	// Close releases the resources of the store.
+	Close() error
+}
+
+// MemoryStore is a Store keeping the blocks in memory, e.g. for tests.
+type MemoryStore struct {
+	mutex  sync.Mutex
+	blocks []*TrBlock
+	index  map[string]int
+}
+
+// NewMemoryStore returns an empty store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{index: make(map[string]int)}
+}
+
+// Put implements Store.
+func (m *MemoryStore) Put(b *TrBlock) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.index[b.HeaderHash]; ok {
+		return errors.New("block " + b.HeaderHash + " already stored")
+	}
+	m.index[b.HeaderHash] = len(m.blocks)
+	m.blocks = append(m.blocks, b)
+	return nil
+}
+
+// GetByHash implements Store.
+func (m *MemoryStore) GetByHash(hash string) (*TrBlock, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i, ok := m.index[hash]
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+	return m.blocks[i], nil
+}
+
+// GetByIndex implements Store.
+func (m *MemoryStore) GetByIndex(index int) (*TrBlock, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if index < 0 || index >= len(m.blocks) {
+		return nil, ErrBlockNotFound
+	}
+	return m.blocks[index], nil
+}
+
+// LatestBlock implements Store.
+func (m *MemoryStore) LatestBlock() (*TrBlock, int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.blocks) == 0 {
+		return nil, -1, ErrBlockNotFound
+	}
+	return m.blocks[len(m.blocks)-1], len(m.blocks) - 1, nil
+}
+
+// Close implements Store.
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// Iterator walks the blocks of a store in order. It stops at the latest
+// block of the store when Next is called.
+//
+//	for it := NewIterator(store, 0); it.Next(); {
+//		block := it.Block()
+//	}
+type Iterator struct {
+	store Store
+	index int
+	block *TrBlock
+	err   error
+}
+
+// NewIterator returns an iterator starting at the block of the index.
+func NewIterator(store Store, from int) *Iterator {
+	return &Iterator{store: store, index: from - 1}
+}
+
+// Next moves to the next block and returns false if there is none, or if
+// the store failed.
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	b, err := it.store.GetByIndex(it.index + 1)
+	if err != nil {
+		if err != ErrBlockNotFound {
+			it.err = err
+		}
+		return false
+	}
+	it.index++
+	it.block = b
+	return true
+}
+
+// Block returns the current block.
+func (it *Iterator) Block() *TrBlock {
+	return it.block
+}
+
+// Index returns the index of the current block.
+func (it *Iterator) Index() int {
+	return it.index
+}
+
+// Err returns the error of the store that stopped the iterator, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
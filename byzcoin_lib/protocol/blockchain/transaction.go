@@ -0,0 +1,254 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+)
+
+// Transaction is a transaction of any format spending and creating unspent
+// outputs, so that the servers can run workloads other than the replayed
+// Bitcoin history. The blocks carry the transactions in the Bitcoin format,
+// see ToTx.
+type Transaction interface {
+	// Hash identifies the transaction. The output i it creates is
+	// identified by UTXOID(Hash(), i).
+	Hash() string
+	// Bytes returns the encoding of the transaction.
+	Bytes() []byte
+	// Verify checks the transaction against the unspent outputs of the
+	// shard.
+	Verify(state *UTXOSet) error
+	// Spends returns the outputs the transaction spends.
+	Spends() []Outpoint
+	// Creates returns the values of the outputs the transaction creates.
+	Creates() []uint64
+}
+
+// Outpoint is the output Index of the transaction Hash.
+type Outpoint struct {
+	Hash  string
+	Index uint32
+}
+
+// ID returns the identifier of the output, as given by UTXOID.
+func (o Outpoint) ID() string {
+	return UTXOID(o.Hash, o.Index)
+}
+
+// ToTx returns the transaction in the Bitcoin format of the blocks, with
+// empty scripts if the transaction has none.
+func ToTx(tx Transaction) blkparser.Tx {
+	if p, ok := tx.(*PaidTx); ok {
+		tx = p.Transaction
+	}
+	if b, ok := tx.(*BitcoinTx); ok {
+		return b.Tx
+	}
+	ret := blkparser.Tx{
+		Hash:    tx.Hash(),
+		Size:    uint32(len(tx.Bytes())),
+		Version: 1,
+	}
+	for _, o := range tx.Spends() {
+		ret.TxIns = append(ret.TxIns, &blkparser.TxIn{
+			InputHash: o.Hash,
+			InputVout: o.Index,
+			ScriptSig: []byte{},
+		})
+	}
+	for _, v := range tx.Creates() {
+		ret.TxOuts = append(ret.TxOuts, &blkparser.TxOut{
+			Value:    v,
+			Pkscript: []byte{},
+		})
+	}
+	ret.TxInCnt = uint32(len(ret.TxIns))
+	ret.TxOutCnt = uint32(len(ret.TxOuts))
+	return ret
+}
+
+// BitcoinTx is a transaction parsed from the Bitcoin blocks.
+type BitcoinTx struct {
+	Tx blkparser.Tx
+}
+
+// NewBitcoinTx returns the transaction parsed by blkparser.
+func NewBitcoinTx(tx blkparser.Tx) *BitcoinTx {
+	return &BitcoinTx{Tx: tx}
+}
+
+// BitcoinTxs returns the transactions parsed by blkparser.
+func BitcoinTxs(txs []blkparser.Tx) []Transaction {
+	ret := make([]Transaction, len(txs))
+	for i := range txs {
+		ret[i] = NewBitcoinTx(txs[i])
+	}
+	return ret
+}
+
+// Hash implements Transaction.
+func (b *BitcoinTx) Hash() string {
+	return b.Tx.Hash
+}
+
+// Bytes implements Transaction. The scripts are kept, but the encoding is
+// not the one of Bitcoin.
+func (b *BitcoinTx) Bytes() []byte {
+	var buf bytes.Buffer
+	writeString(&buf, b.Tx.Hash)
+	binary.Write(&buf, binary.LittleEndian, b.Tx.Version)
+	binary.Write(&buf, binary.LittleEndian, b.Tx.LockTime)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(b.Tx.TxIns)))
+	for _, in := range b.Tx.TxIns {
+		writeString(&buf, in.InputHash)
+		binary.Write(&buf, binary.LittleEndian, in.InputVout)
+		writeString(&buf, string(in.ScriptSig))
+		binary.Write(&buf, binary.LittleEndian, in.Sequence)
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(len(b.Tx.TxOuts)))
+	for _, out := range b.Tx.TxOuts {
+		binary.Write(&buf, binary.LittleEndian, out.Value)
+		writeString(&buf, string(out.Pkscript))
+	}
+	return buf.Bytes()
+}
+
+// Verify implements Transaction. The scripts are not checked.
+func (b *BitcoinTx) Verify(state *UTXOSet) error {
+	if len(b.Tx.TxOuts) == 0 {
+		return fmt.Errorf("transaction %s has no outputs", b.Tx.Hash)
+	}
+	return state.VerifyTx(b)
+}
+
+// Spends implements Transaction. The input of a coinbase transaction
+// doesn't spend anything.
+func (b *BitcoinTx) Spends() []Outpoint {
+	var ret []Outpoint
+	for _, in := range b.Tx.TxIns {
+		if in.InputVout == coinbaseVout {
+			continue
+		}
+		ret = append(ret, Outpoint{Hash: in.InputHash, Index: in.InputVout})
+	}
+	return ret
+}
+
+// Creates implements Transaction.
+func (b *BitcoinTx) Creates() []uint64 {
+	ret := make([]uint64, len(b.Tx.TxOuts))
+	for i, out := range b.Tx.TxOuts {
+		ret[i] = out.Value
+	}
+	return ret
+}
+
+// NativeTx is a transaction of a simple UTXO format, without scripts. Only
+// a coinbase transaction, e.g. the one of a genesis block, mints its outputs
+// without inputs.
+type NativeTx struct {
+	Coinbase bool
+	Inputs   []Outpoint
+	Outputs  []uint64
+}
+
+// Hash implements Transaction. It is the hex encoded hash of Bytes.
+func (n *NativeTx) Hash() string {
+	h := sha256.Sum256(n.Bytes())
+	return hex.EncodeToString(h[:])
+}
+
+// Bytes implements Transaction.
+func (n *NativeTx) Bytes() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, n.Coinbase)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(n.Inputs)))
+	for _, in := range n.Inputs {
+		writeString(&buf, in.Hash)
+		binary.Write(&buf, binary.LittleEndian, in.Index)
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(len(n.Outputs)))
+	for _, v := range n.Outputs {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	return buf.Bytes()
+}
+
+// Verify implements Transaction: the transaction must create outputs of
+// some value and spend every input once. Unless it is a coinbase, it must
+// spend some inputs, and its outputs can't be worth more than them. The set
+// only holds the values of the outputs of its shard, so the values of a
+// transaction spending outputs of other shards are left to the shards of
+// the inputs.
+func (n *NativeTx) Verify(state *UTXOSet) error {
+	if len(n.Outputs) == 0 {
+		return errors.New("no outputs")
+	}
+	if n.Coinbase {
+		if len(n.Inputs) > 0 {
+			return errors.New("coinbase with inputs")
+		}
+	} else if len(n.Inputs) == 0 {
+		return errors.New("no inputs")
+	}
+	var out uint64
+	for _, v := range n.Outputs {
+		if v == 0 {
+			return errors.New("output without value")
+		}
+		if out+v < out {
+			return errors.New("outputs overflow")
+		}
+		out += v
+	}
+	seen := make(map[Outpoint]bool)
+	for _, in := range n.Inputs {
+		if seen[in] {
+			return fmt.Errorf("spends %s twice", in.ID())
+		}
+		seen[in] = true
+	}
+	if err := state.VerifyTx(n); err != nil {
+		return err
+	}
+	if n.Coinbase {
+		return nil
+	}
+	var in uint64
+	for _, o := range n.Inputs {
+		if ShardOf(o.ID(), state.Shards) != state.Shard {
+			return nil
+		}
+		v, ok := state.Value(o.ID())
+		if !ok {
+			return fmt.Errorf("spends unknown or spent output %s", o.ID())
+		}
+		in += v
+	}
+	if out > in {
+		return fmt.Errorf("outputs worth %d, more than the %d of the inputs", out, in)
+	}
+	return nil
+}
+
+// Spends implements Transaction.
+func (n *NativeTx) Spends() []Outpoint {
+	return n.Inputs
+}
+
+// Creates implements Transaction.
+func (n *NativeTx) Creates() []uint64 {
+	return n.Outputs
+}
+
+// writeString writes the length of s followed by s.
+func writeString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(s)))
+	buf.WriteString(s)
+}
@@ -0,0 +1,43 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNativeTxVerify(t *testing.T) {
+	s := testSet()
+	a, b := Outpoint{"a", 0}, Outpoint{"b", 0}
+	require.Nil(t, (&NativeTx{Inputs: []Outpoint{a}, Outputs: []uint64{4, 6}}).Verify(s))
+	require.Nil(t, (&NativeTx{Inputs: []Outpoint{a, b}, Outputs: []uint64{12}}).Verify(s))
+	// a fee is allowed
+	require.Nil(t, (&NativeTx{Inputs: []Outpoint{b}, Outputs: []uint64{1}}).Verify(s))
+	require.NotNil(t, (&NativeTx{Inputs: []Outpoint{a}, Outputs: []uint64{0}}).Verify(s))
+	require.NotNil(t, (&NativeTx{Inputs: []Outpoint{a}}).Verify(s))
+	require.NotNil(t, (&NativeTx{Inputs: []Outpoint{a, a}, Outputs: []uint64{10}}).Verify(s))
+	require.NotNil(t, (&NativeTx{Inputs: []Outpoint{{"c", 0}}, Outputs: []uint64{1}}).Verify(s))
+}
+
+func TestNativeTxOverspend(t *testing.T) {
+	s := testSet()
+	a, b := Outpoint{"a", 0}, Outpoint{"b", 0}
+	require.NotNil(t, (&NativeTx{Inputs: []Outpoint{a}, Outputs: []uint64{11}}).Verify(s))
+	require.NotNil(t, (&NativeTx{Inputs: []Outpoint{a}, Outputs: []uint64{6, 5}}).Verify(s))
+	require.NotNil(t, (&NativeTx{Inputs: []Outpoint{a, b}, Outputs: []uint64{16}}).Verify(s))
+	// the sum of the outputs can't wrap around
+	require.NotNil(t, (&NativeTx{Inputs: []Outpoint{a},
+		Outputs: []uint64{^uint64(0), 2}}).Verify(s))
+}
+
+func TestNativeTxNoInputs(t *testing.T) {
+	s := testSet()
+	require.NotNil(t, (&NativeTx{Outputs: []uint64{10}}).Verify(s))
+	// only a coinbase mints its outputs
+	require.Nil(t, (&NativeTx{Coinbase: true, Outputs: []uint64{10}}).Verify(s))
+	require.NotNil(t, (&NativeTx{Coinbase: true, Inputs: []Outpoint{{"a", 0}},
+		Outputs: []uint64{10}}).Verify(s))
+	// and it isn't the same transaction as the one without inputs
+	require.NotEqual(t, (&NativeTx{Outputs: []uint64{10}}).Hash(),
+		(&NativeTx{Coinbase: true, Outputs: []uint64{10}}).Hash())
+}
@@ -14,6 +14,32 @@ type TransactionList struct {
 	Fees  float64        `json:"-"`
 }
 
+// headerSize is the size of a block header in the Bitcoin format.
+const headerSize = 80
+
+// RawSize returns the size of a block of the transactions in the Bitcoin
+// format: the header, the number of transactions and the transactions.
+func (tl *TransactionList) RawSize() int {
+	size := headerSize + varIntSize(len(tl.Txs))
+	for _, tx := range tl.Txs {
+		size += int(tx.Size)
+	}
+	return size
+}
+
+// varIntSize returns the size of the Bitcoin variable length encoding of n.
+func varIntSize(n int) int {
+	switch {
+	case n < 0xfd:
+		return 1
+	case n <= 0xffff:
+		return 3
+	case n <= 0xffffffff:
+		return 5
+	}
+	return 9
+}
+
 func (tl *TransactionList) HashSum() []byte {
 	h := sha256.New()
 	for _, tx := range tl.Txs {
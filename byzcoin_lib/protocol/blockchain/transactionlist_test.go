@@ -0,0 +1,22 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawSize(t *testing.T) {
+	tl := testList(2)
+	tl.Txs[0].Size = 100
+	tl.Txs[1].Size = 150
+	// the header, a byte for the number of transactions and the transactions
+	assert.Equal(t, 80+1+250, tl.RawSize())
+	assert.Equal(t, 80+1, (&TransactionList{}).RawSize())
+	assert.Equal(t, 80+3, testList(0xfd).RawSize())
+
+	for n, size := range map[int]int{0: 1, 0xfc: 1, 0xfd: 3, 0xffff: 3,
+		0x10000: 5, 0xffffffff: 5, 0x100000000: 9} {
+		assert.Equal(t, size, varIntSize(n), "%x", n)
+	}
+}
@@ -9,7 +9,6 @@ import (
 	"fmt"
 	"net"
 
-	"github.com/dedis/paper_17_sosp_omniledger/crypto"
 	"gopkg.in/dedis/onet.v1/log"
 )
 
@@ -23,6 +22,10 @@ type Block struct {
 
 type TrBlock struct {
 	Block
+	// Signature is the collective signature of the shard on the header, set
+	// once the block is committed. It is not part of what is hashed or
+	// signed in the prepare round.
+	Signature *HeaderSignature `json:"-"`
 }
 
 func (tr *TrBlock) MarshalBinary() ([]byte, error) {
@@ -56,6 +59,9 @@ type Header struct {
 	ParentKey  string
 	PublicKey  string
 	LeaderId   net.IP
+	// Genesis is the configuration of the chain, only set in its genesis
+	// block, see NewGenesisBlock
+	Genesis Genesis
 }
 
 // HashSum returns a hash representation of the header
@@ -73,6 +79,11 @@ func (h *Header) HashSum() []byte {
 	if _, err := ha.Write([]byte(h.PublicKey)); err != nil {
 		log.Error("Couldn't hash header", err)
 	}
+	if h.Genesis != (Genesis{}) {
+		if _, err := ha.Write(h.Genesis.hashSum()); err != nil {
+			log.Error("Couldn't hash header", err)
+		}
+	}
 	return ha.Sum(nil)
 }
 
@@ -94,7 +105,7 @@ func NewTrBlock(transactions TransactionList, header *Header) *TrBlock {
 	trb.Magic = [4]byte{0xF9, 0xBE, 0xB4, 0xD9}
 	trb.HeaderHash = trb.Hash(header)
 	trb.TransactionList = transactions
-	trb.BlockSize = 0
+	trb.BlockSize = uint32(transactions.RawSize())
 	trb.Header = header
 	return trb
 }
@@ -106,15 +117,13 @@ func NewHeader(transactions TransactionList, parent, parentKey string) *Header {
 	hdr.MerkleRoot = HashRootTransactions(transactions)
 	return hdr
 }
-func HashRootTransactions(transactions TransactionList) string {
-	var hashes []crypto.HashID
 
-	for _, t := range transactions.Txs {
-		temp, _ := hex.DecodeString(t.Hash)
-		hashes = append(hashes, temp)
-	}
-	out, _ := crypto.ProofTree(sha256.New, hashes)
-	return hex.EncodeToString(out)
+// HashRootTransactions returns the hex encoded Merkle root of the
+// transactions, whose leaves are given by txLeaf, see GetProof for the proofs
+// of inclusion. It is not the Merkle root of Bitcoin.
+func HashRootTransactions(transactions TransactionList) string {
+	root, _ := txTree(&transactions)
+	return hex.EncodeToString(root)
 }
 
 func (trb *Block) Hash(h *Header) (res string) {
@@ -0,0 +1,266 @@
+package blockchain
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+)
+
+// UTXOSet holds the unspent outputs of a shard, so that blocks spending
+// outputs that are already spent, or spending the same output twice, can be
+// rejected.
+//
+// A block being signed reserves the outputs it spends: a concurrent block
+// spending one of them is rejected until the first block is either applied
+// or released.
+type UTXOSet struct {
+	// Shard is the shard of the set, out of Shards. Inputs and outputs of
+	// other shards are ignored.
+	Shard  int
+	Shards int
+	// Outputs maps the identifier of the unspent outputs, as given by UTXOID,
+	// to their value.
+	Outputs map[string]uint64
+
+	// reserved maps the outputs spent by the blocks being signed to the
+	// hash of the block.
+	reserved map[string]string
+	mutex    sync.Mutex
+}
+
+// NewUTXOSet returns an empty set for the shard.
+func NewUTXOSet(shard, shards int) *UTXOSet {
+	return &UTXOSet{
+		Shard:    shard,
+		Shards:   shards,
+		Outputs:  make(map[string]uint64),
+		reserved: make(map[string]string),
+	}
+}
+
+// Value returns the value of the output, and whether it is unspent.
+func (s *UTXOSet) Value(id string) (uint64, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	v, ok := s.Outputs[id]
+	return v, ok
+}
+
+// Unspent implements State: it tells whether the output is unspent. The
+// outputs of the other shards are not tracked, so they are taken as unspent.
+func (s *UTXOSet) Unspent(id string) bool {
+	if ShardOf(id, s.Shards) != s.Shard {
+		return true
+	}
+	_, ok := s.Value(id)
+	return ok
+}
+
+// Seed adds the outputs of the shard to the set as unspent, without
+// checking anything: they are the outputs the chain starts with, e.g. the
+// ones of GenesisOutputs.
+func (s *UTXOSet) Seed(outputs map[string]uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.Outputs == nil {
+		s.Outputs = make(map[string]uint64)
+	}
+	for id, v := range outputs {
+		if ShardOf(id, s.Shards) == s.Shard {
+			s.Outputs[id] = v
+		}
+	}
+}
+
+// GenesisOutputs returns the outputs the transactions spend without creating
+// them earlier, e.g. because they were read from the middle of a chain. A
+// chain made of the transactions needs them unspent at its genesis, see Seed.
+// Their values are not known, so they are 0.
+func GenesisOutputs(txs []Transaction) map[string]uint64 {
+	created := make(map[string]bool)
+	outputs := make(map[string]uint64)
+	for _, tx := range txs {
+		for _, o := range tx.Spends() {
+			if id := o.ID(); !created[id] {
+				outputs[id] = 0
+			}
+		}
+		for i := range tx.Creates() {
+			created[UTXOID(tx.Hash(), uint32(i))] = true
+		}
+	}
+	return outputs
+}
+
+// Reserve checks that the transactions of the block only spend unspent
+// outputs not reserved by another block, and reserves them for the block.
+// Reserving a block twice is allowed.
+func (s *UTXOSet) Reserve(hash string, txs []blkparser.Tx) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	spent, _, err := s.verify(hash, BitcoinTxs(txs), true)
+	if err != nil {
+		return err
+	}
+	if s.reserved == nil {
+		// the set has been loaded from disk
+		s.reserved = make(map[string]string)
+	}
+	for _, id := range spent {
+		s.reserved[id] = hash
+	}
+	return nil
+}
+
+// Release frees the outputs reserved by the block, e.g. because it didn't
+// get signed.
+func (s *UTXOSet) Release(hash string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.release(map[string]bool{hash: true})
+}
+
+// UTXODiff is what a block changes in the unspent outputs of a shard, so
+// that the state can be sent or saved block by block, see SnapshotDir.
+type UTXODiff struct {
+	// Block is the hash of the block
+	Block string
+	// Spent are the outputs of the set spent by the block
+	Spent []string
+	// Created are the outputs created by the block that are still unspent at
+	// its end, with their values
+	Created map[string]uint64
+}
+
+// Apply spends the inputs and adds the outputs of the transactions of a
+// signed block. The blocks that reserved one of the spent outputs can't be
+// applied anymore, so their reservations are released.
+func (s *UTXOSet) Apply(hash string, txs []blkparser.Tx) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	spent, created, err := s.verify(hash, BitcoinTxs(txs), false)
+	if err != nil {
+		return err
+	}
+	s.apply(&UTXODiff{Block: hash, Spent: spent, Created: created})
+	return nil
+}
+
+// Diff returns what applying the transactions of the block would change,
+// without applying them.
+func (s *UTXOSet) Diff(hash string, txs []blkparser.Tx) (*UTXODiff, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	spent, created, err := s.verify(hash, BitcoinTxs(txs), false)
+	if err != nil {
+		return nil, err
+	}
+	return &UTXODiff{Block: hash, Spent: spent, Created: created}, nil
+}
+
+// ApplyDiff applies the diff of a block like Apply applies its
+// transactions. The outputs it spends must be unspent.
+func (s *UTXOSet) ApplyDiff(d *UTXODiff) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, id := range d.Spent {
+		if _, ok := s.Outputs[id]; !ok {
+			return fmt.Errorf("block %s spends unknown or spent output %s",
+				d.Block, id)
+		}
+	}
+	s.apply(d)
+	return nil
+}
+
+// apply applies the diff and releases the reservations of the blocks that
+// can't be applied anymore.
+func (s *UTXOSet) apply(d *UTXODiff) {
+	released := map[string]bool{d.Block: true}
+	for _, id := range d.Spent {
+		if owner, ok := s.reserved[id]; ok {
+			released[owner] = true
+		}
+		delete(s.Outputs, id)
+	}
+	if s.Outputs == nil {
+		s.Outputs = make(map[string]uint64)
+	}
+	for id, v := range d.Created {
+		s.Outputs[id] = v
+	}
+	s.release(released)
+}
+
+// VerifyTx checks that the transaction only spends unspent outputs of the
+// shard that are not reserved by a block.
+func (s *UTXOSet) VerifyTx(tx Transaction) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, _, err := s.verify("", []Transaction{tx}, true)
+	return err
+}
+
+// Copy returns a copy of the set without the reservations, e.g. to save it.
+func (s *UTXOSet) Copy() *UTXOSet {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c := NewUTXOSet(s.Shard, s.Shards)
+	for id, v := range s.Outputs {
+		c.Outputs[id] = v
+	}
+	return c
+}
+
+// verify checks the transactions in order, so that a transaction can spend
+// the output of an earlier transaction of the same block. It returns the
+// outputs of the set spent by the block, and the outputs it creates that are
+// still unspent at the end of the block.
+func (s *UTXOSet) verify(hash string, txs []Transaction, reservations bool) ([]string, map[string]uint64, error) {
+	var spent []string
+	created := make(map[string]uint64)
+	used := make(map[string]bool)
+	for _, tx := range txs {
+		for _, o := range tx.Spends() {
+			id := o.ID()
+			if ShardOf(id, s.Shards) != s.Shard {
+				continue
+			}
+			if used[id] {
+				return nil, nil, fmt.Errorf("transaction %s double spends %s in the block",
+					tx.Hash(), id)
+			}
+			used[id] = true
+			if _, ok := created[id]; ok {
+				delete(created, id)
+				continue
+			}
+			if _, ok := s.Outputs[id]; !ok {
+				return nil, nil, fmt.Errorf("transaction %s spends unknown or spent output %s",
+					tx.Hash(), id)
+			}
+			if owner, ok := s.reserved[id]; reservations && ok && owner != hash {
+				return nil, nil, fmt.Errorf("transaction %s spends %s, reserved by block %s",
+					tx.Hash(), id, owner)
+			}
+			spent = append(spent, id)
+		}
+		for i, v := range tx.Creates() {
+			id := UTXOID(tx.Hash(), uint32(i))
+			if ShardOf(id, s.Shards) == s.Shard {
+				created[id] = v
+			}
+		}
+	}
+	return spent, created, nil
+}
+
+// release drops the reservations of the blocks.
+func (s *UTXOSet) release(blocks map[string]bool) {
+	for id, owner := range s.reserved {
+		if blocks[owner] {
+			delete(s.reserved, id)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"github.com/stretchr/testify/require"
+)
+
+// testTx returns a transaction spending the outputs, given as hash and
+// index, with one output of the value.
+func testTx(hash string, value uint64, spends ...Outpoint) blkparser.Tx {
+	tx := blkparser.Tx{Hash: hash, TxOuts: []*blkparser.TxOut{{Value: value}}}
+	for _, o := range spends {
+		tx.TxIns = append(tx.TxIns, &blkparser.TxIn{InputHash: o.Hash, InputVout: o.Index})
+	}
+	return tx
+}
+
+// testSet returns a set of a single shard holding the outputs a:0 and b:0.
+func testSet() *UTXOSet {
+	s := NewUTXOSet(0, 1)
+	s.Seed(map[string]uint64{UTXOID("a", 0): 10, UTXOID("b", 0): 5})
+	return s
+}
+
+func TestUTXOSetReserve(t *testing.T) {
+	s := testSet()
+	spendA := []blkparser.Tx{testTx("x", 10, Outpoint{"a", 0})}
+	require.Nil(t, s.Reserve("block1", spendA))
+	// reserving the same block again is allowed
+	require.Nil(t, s.Reserve("block1", spendA))
+	// a concurrent block can't spend the reserved output
+	require.NotNil(t, s.Reserve("block2", []blkparser.Tx{testTx("y", 10, Outpoint{"a", 0})}))
+	require.NotNil(t, s.VerifyTx(NewBitcoinTx(testTx("y", 10, Outpoint{"a", 0}))))
+	// but it can spend another one
+	require.Nil(t, s.Reserve("block3", []blkparser.Tx{testTx("z", 5, Outpoint{"b", 0})}))
+	// nor can a block spend an output twice, or an unknown one
+	require.NotNil(t, s.Reserve("block4", []blkparser.Tx{
+		testTx("u", 5, Outpoint{"b", 0}), testTx("v", 5, Outpoint{"b", 0})}))
+	require.NotNil(t, s.Reserve("block4", []blkparser.Tx{testTx("u", 5, Outpoint{"c", 0})}))
+}
+
+func TestUTXOSetRelease(t *testing.T) {
+	s := testSet()
+	require.Nil(t, s.Reserve("block1", []blkparser.Tx{testTx("x", 10, Outpoint{"a", 0})}))
+	require.NotNil(t, s.Reserve("block2", []blkparser.Tx{testTx("y", 10, Outpoint{"a", 0})}))
+	s.Release("block1")
+	require.Nil(t, s.Reserve("block2", []blkparser.Tx{testTx("y", 10, Outpoint{"a", 0})}))
+	_, ok := s.Value(UTXOID("a", 0))
+	require.True(t, ok, "reserving spent the output")
+}
+
+func TestUTXOSetApply(t *testing.T) {
+	s := testSet()
+	spendA := []blkparser.Tx{testTx("x", 10, Outpoint{"a", 0})}
+	spendB := []blkparser.Tx{testTx("z", 5, Outpoint{"b", 0})}
+	require.Nil(t, s.Reserve("block1", spendA))
+	require.Nil(t, s.Reserve("block2", spendB))
+
+	require.Nil(t, s.Apply("block1", spendA))
+	require.False(t, s.Unspent(UTXOID("a", 0)))
+	v, ok := s.Value(UTXOID("x", 0))
+	require.True(t, ok)
+	require.Equal(t, uint64(10), v)
+	// a spent output can't be spent again
+	require.NotNil(t, s.Apply("block3", spendA))
+	require.NotNil(t, s.Reserve("block3", spendA))
+	// the reservation of block2 is still there
+	require.NotNil(t, s.Reserve("block4", []blkparser.Tx{testTx("w", 5, Outpoint{"b", 0})}))
+
+	// a block signed concurrently that spends b:0 releases the reservation
+	// of block2, which can't be applied anymore
+	require.Nil(t, s.Apply("block4", []blkparser.Tx{testTx("w", 5, Outpoint{"b", 0})}))
+	require.Empty(t, s.reserved)
+	require.NotNil(t, s.Apply("block2", spendB))
+	// the outputs created by the blocks can be spent by the next ones
+	require.Nil(t, s.Reserve("block5", []blkparser.Tx{testTx("y", 15,
+		Outpoint{"x", 0}, Outpoint{"w", 0})}))
+}
+
+func TestGenesisOutputs(t *testing.T) {
+	chain := []blkparser.Tx{
+		testTx("x", 10, Outpoint{"a", 0}),
+		testTx("y", 10, Outpoint{"x", 0}, Outpoint{"b", 1}),
+		testTx("z", 10, Outpoint{"y", 0}, Outpoint{"a", 0}),
+	}
+	genesis := GenesisOutputs(BitcoinTxs(chain))
+	require.Equal(t, map[string]uint64{UTXOID("a", 0): 0, UTXOID("b", 1): 0}, genesis)
+
+	// the blocks of the chain apply to the seeded set, up to the double
+	// spend of a:0
+	s := NewUTXOSet(0, 1)
+	s.Seed(genesis)
+	require.Nil(t, s.Apply("block1", chain[:1]))
+	require.Nil(t, s.Apply("block2", chain[1:2]))
+	require.NotNil(t, s.Apply("block3", chain[2:]))
+
+	// the shards only hold their own outputs
+	shards := 4
+	for i := 0; i < shards; i++ {
+		s := NewUTXOSet(i, shards)
+		s.Seed(genesis)
+		for id := range genesis {
+			_, ok := s.Value(id)
+			require.Equal(t, ShardOf(id, shards) == i, ok)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ChainValidator checks that blocks extend a chain: a block must have the
+// latest block of the chain, the tip, as parent, and the next height. The
+// heights start at 0 with the first block, whose parent is empty. If the
+// first block is a genesis block, the next ones must follow its policy.
+type ChainValidator struct {
+	mutex sync.Mutex
+	// tip is the header hash of the latest block, height its height, -1 if
+	// the chain is empty
+	tip    string
+	height int
+	// policy is the policy of the genesis block of the chain
+	policy ChainPolicy
+}
+
+// NewChainValidator returns a validator of the chain whose latest block has
+// the hash tip and the height, -1 and an empty tip for an empty chain.
+func NewChainValidator(tip string, height int) *ChainValidator {
+	return &ChainValidator{tip: tip, height: height}
+}
+
+// Tip returns the hash and the height of the latest block of the chain.
+func (v *ChainValidator) Tip() (string, int) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.tip, v.height
+}
+
+// Check returns an error if the block doesn't extend the chain or is not
+// consistent, see CheckBlock.
+func (v *ChainValidator) Check(b *TrBlock) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.check(b, v.height+1)
+}
+
+// Append checks the block and makes it the tip of the chain.
+func (v *ChainValidator) Append(b *TrBlock) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.append(b, v.height+1)
+}
+
+// CatchUp checks the blocks of the store following the tip, which must be
+// stored at the index of their height, and appends them.
+func (v *ChainValidator) CatchUp(store Store) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	it := NewIterator(store, v.height+1)
+	for it.Next() {
+		if err := v.append(it.Block(), it.Index()); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+func (v *ChainValidator) append(b *TrBlock, height int) error {
+	if err := v.check(b, height); err != nil {
+		return err
+	}
+	v.tip = b.HeaderHash
+	v.height = height
+	if height == 0 && b.IsGenesis() {
+		v.policy = b.Header.Genesis.Policy
+	}
+	return nil
+}
+
+func (v *ChainValidator) check(b *TrBlock, height int) error {
+	if height != v.height+1 {
+		return fmt.Errorf("block %s at height %d doesn't follow height %d",
+			b.HeaderHash, height, v.height)
+	}
+	if b.Header == nil {
+		return fmt.Errorf("block %s has no header", b.HeaderHash)
+	}
+	if b.Parent != v.tip {
+		return fmt.Errorf("block %s has parent %q instead of %q",
+			b.HeaderHash, b.Parent, v.tip)
+	}
+	if err := CheckBlock(b); err != nil {
+		return err
+	}
+	return v.policy.Check(b)
+}
+
+// CheckBlock returns an error if the header hash or the Merkle root of the
+// block don't match its header and its transactions.
+func CheckBlock(b *TrBlock) error {
+	if b.Header == nil {
+		return fmt.Errorf("block %s has no header", b.HeaderHash)
+	}
+	if h := HashHeader(b.Header); b.HeaderHash != h {
+		return fmt.Errorf("block %s has header hash %s", b.HeaderHash, h)
+	}
+	if int(b.TxCnt) != len(b.Txs) {
+		return fmt.Errorf("block %s counts %d transactions but has %d",
+			b.HeaderHash, b.TxCnt, len(b.Txs))
+	}
+	if root := HashRootTransactions(b.TransactionList); b.MerkleRoot != root {
+		return fmt.Errorf("block %s has Merkle root %s instead of %s",
+			b.HeaderHash, b.MerkleRoot, root)
+	}
+	return nil
+}
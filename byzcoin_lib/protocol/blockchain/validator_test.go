@@ -0,0 +1,56 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainValidator(t *testing.T) {
+	blocks := GenerateChain(GeneratorConfig{Seed: 1, MinTxs: 2, MaxTxs: 5}, 4)
+	v := NewChainValidator("", -1)
+	assert.NotNil(t, v.Check(blocks[1]), "not the first block")
+	require.Nil(t, v.Append(blocks[0]))
+	assert.NotNil(t, v.Append(blocks[0]), "appended twice")
+	require.Nil(t, v.Check(blocks[1]))
+
+	// a copy of the next block with its fields changed
+	tamper := func(change func(b *TrBlock)) *TrBlock {
+		b := *blocks[1]
+		header := *b.Header
+		b.Header = &header
+		b.Txs = append([]blkparser.Tx{}, b.Txs...)
+		change(&b)
+		return &b
+	}
+	for name, change := range map[string]func(b *TrBlock){
+		"header hash": func(b *TrBlock) { b.HeaderHash = blocks[2].HeaderHash },
+		"parent": func(b *TrBlock) {
+			b.Header = NewHeader(b.TransactionList, blocks[2].HeaderHash, "")
+			b.HeaderHash = HashHeader(b.Header)
+		},
+		"no header":   func(b *TrBlock) { b.Header = nil },
+		"transaction": func(b *TrBlock) { b.Txs[1] = blocks[2].Txs[1] },
+		"missing one": func(b *TrBlock) { b.Txs = b.Txs[1:] },
+		"count":       func(b *TrBlock) { b.TxCnt++ },
+	} {
+		assert.NotNil(t, v.Check(tamper(change)), name)
+	}
+
+	// the validator of a chain catches up with its stored blocks
+	store := NewMemoryStore()
+	for _, b := range blocks {
+		require.Nil(t, store.Put(b))
+	}
+	require.Nil(t, v.CatchUp(store))
+	tip, height := v.Tip()
+	assert.Equal(t, blocks[3].HeaderHash, tip)
+	assert.Equal(t, 3, height)
+	require.Nil(t, v.CatchUp(store))
+
+	v = NewChainValidator(blocks[2].HeaderHash, 2)
+	require.Nil(t, v.Append(blocks[3]))
+	assert.NotNil(t, NewChainValidator("other", 2).CatchUp(store))
+}
@@ -0,0 +1,310 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+)
+
+// maxWalletOutputs is the number of unspent outputs above which a payment
+// also spends the smallest outputs of the wallet, so that the wallets don't
+// split their value into dust.
+const maxWalletOutputs = 8
+
+// ErrInsufficientFunds is returned by Wallet.Pay if the wallet doesn't own
+// enough value.
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// Wallet is the wallet of a simulated client: a key pair and the unspent
+// outputs paying to the hash of its public key. It works on Transaction, so
+// it tracks the outputs of transactions of any format paying to its script,
+// but its payments are Bitcoin transactions whose inputs it signs, so that
+// the transactions depend on each other as the ones of real users do.
+type Wallet struct {
+	key      *btcec.PrivateKey
+	pkScript []byte
+
+	mutex   sync.Mutex
+	unspent []walletOutput
+}
+
+// walletOutput is an unspent output owned by a wallet.
+type walletOutput struct {
+	Outpoint
+	value uint64
+}
+
+// Payment is an output of a transaction made by a wallet.
+type Payment struct {
+	PkScript []byte
+	Value    uint64
+}
+
+// NewWallet returns an empty wallet of the key.
+func NewWallet(key *btcec.PrivateKey) *Wallet {
+	hash := btcutil.Hash160(key.PubKey().SerializeCompressed())
+	script := []byte{txscript.OP_DUP, txscript.OP_HASH160, txscript.OP_DATA_20}
+	script = append(script, hash...)
+	script = append(script, txscript.OP_EQUALVERIFY, txscript.OP_CHECKSIG)
+	return &Wallet{key: key, pkScript: script}
+}
+
+// NewWallets returns n empty wallets whose keys are derived from the seed:
+// the same seed always gives the same wallets.
+func NewWallets(n int, seed int64) []*Wallet {
+	wallets := make([]*Wallet, n)
+	for i := range wallets {
+		var buf [16]byte
+		binary.LittleEndian.PutUint64(buf[:8], uint64(seed))
+		binary.LittleEndian.PutUint64(buf[8:], uint64(i))
+		secret := sha256.Sum256(buf[:])
+		key, _ := btcec.PrivKeyFromBytes(secret[:])
+		wallets[i] = NewWallet(key)
+	}
+	return wallets
+}
+
+// GenesisAllocation returns the coinbase transaction creating outputs
+// outputs of the value for every wallet, and gives them to the wallets.
+func GenesisAllocation(wallets []*Wallet, outputs int, value uint64) Transaction {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(1))
+	writeVarInt(&buf, 1)
+	buf.Write(make([]byte, 32))
+	binary.Write(&buf, binary.LittleEndian, uint32(coinbaseVout))
+	script := []byte("genesis allocation")
+	writeVarInt(&buf, len(script))
+	buf.Write(script)
+	binary.Write(&buf, binary.LittleEndian, uint32(0xffffffff))
+	writeVarInt(&buf, len(wallets)*outputs)
+	for _, w := range wallets {
+		for i := 0; i < outputs; i++ {
+			binary.Write(&buf, binary.LittleEndian, value)
+			writeVarInt(&buf, len(w.pkScript))
+			buf.Write(w.pkScript)
+		}
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+
+	tx, size := blkparser.NewTx(buf.Bytes())
+	tx.Size = uint32(size)
+	genesis := NewBitcoinTx(*tx)
+	for _, w := range wallets {
+		w.Receive(genesis)
+	}
+	return genesis
+}
+
+// PkScript returns the output script paying to the wallet.
+func (w *Wallet) PkScript() []byte {
+	return w.pkScript
+}
+
+// Balance returns the value of the unspent outputs of the wallet.
+func (w *Wallet) Balance() uint64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var balance uint64
+	for _, o := range w.unspent {
+		balance += o.value
+	}
+	return balance
+}
+
+// Outpoints returns the unspent outputs of the wallet.
+func (w *Wallet) Outpoints() []Outpoint {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	ret := make([]Outpoint, len(w.unspent))
+	for i, o := range w.unspent {
+		ret[i] = o.Outpoint
+	}
+	return ret
+}
+
+// Receive adds the outputs of the transaction paying to the wallet, but not
+// the change of its own payments, which it already has. Only the Bitcoin
+// transactions have scripts, see ToTx.
+func (w *Wallet) Receive(tx Transaction) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	owned := make(map[Outpoint]bool)
+	for _, o := range w.unspent {
+		owned[o.Outpoint] = true
+	}
+	values := tx.Creates()
+	for i, out := range ToTx(tx).TxOuts {
+		o := Outpoint{Hash: tx.Hash(), Index: uint32(i)}
+		if bytes.Equal(out.Pkscript, w.pkScript) && !owned[o] {
+			w.unspent = append(w.unspent, walletOutput{Outpoint: o,
+				value: values[i]})
+		}
+	}
+}
+
+// Pay returns a signed transaction making the payments, spending the
+// largest outputs of the wallet and the smallest ones too if it has many,
+// with the change back to the wallet as the last output. The spent outputs
+// are removed from the wallet and the change added, so the next payment may
+// depend on this one; the recipients have to Receive the transaction.
+func (w *Wallet) Pay(payments ...Payment) (Transaction, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var value uint64
+	for _, p := range payments {
+		if p.Value == 0 {
+			return nil, errors.New("payment without value")
+		}
+		value += p.Value
+	}
+	if value == 0 {
+		return nil, errors.New("nothing to pay")
+	}
+	sort.Slice(w.unspent, func(i, j int) bool {
+		return w.unspent[i].value > w.unspent[j].value
+	})
+	var ins []Outpoint
+	var total uint64
+	for len(ins) < len(w.unspent) && total < value {
+		total += w.unspent[len(ins)].value
+		ins = append(ins, w.unspent[len(ins)].Outpoint)
+	}
+	if total < value {
+		return nil, ErrInsufficientFunds
+	}
+	rest := w.unspent[len(ins):]
+	for len(rest) > maxWalletOutputs-1 && len(ins) < maxWalletOutputs {
+		total += rest[len(rest)-1].value
+		ins = append(ins, rest[len(rest)-1].Outpoint)
+		rest = rest[:len(rest)-1]
+	}
+
+	var outs []*blkparser.TxOut
+	for _, p := range payments {
+		outs = append(outs, &blkparser.TxOut{Value: p.Value, Pkscript: p.PkScript})
+	}
+	if total > value {
+		outs = append(outs,
+			&blkparser.TxOut{Value: total - value, Pkscript: w.pkScript})
+	}
+	msg := wire.NewMsgTx(1)
+	if err := msg.Deserialize(bytes.NewReader(serializeTx(ins, outs))); err != nil {
+		return nil, err
+	}
+	if err := w.sign(msg); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := msg.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	tx, size := blkparser.NewTx(buf.Bytes())
+	tx.Size = uint32(size)
+
+	w.unspent = append([]walletOutput{}, rest...)
+	if total > value {
+		w.unspent = append(w.unspent, walletOutput{
+			Outpoint: Outpoint{Hash: tx.Hash, Index: uint32(len(payments))},
+			value:    total - value,
+		})
+	}
+	return NewBitcoinTx(*tx), nil
+}
+
+// sign sets the scripts of the inputs of the transaction, which all spend
+// outputs of the wallet.
+func (w *Wallet) sign(msg *wire.MsgTx) error {
+	for i := range msg.TxIn {
+		script, err := txscript.SignatureScript(msg, i, w.pkScript,
+			txscript.SigHashAll, w.key, true)
+		if err != nil {
+			return err
+		}
+		msg.TxIn[i].SignatureScript = script
+	}
+	return nil
+}
+
+// PrevOutput returns the output script and the value of an output, and
+// false if it is unknown.
+type PrevOutput func(hash string, vout uint32) ([]byte, uint64, bool)
+
+// VerifySpend runs the scripts of the inputs of the transaction against the
+// outputs they spend, as a Bitcoin node does, so that it fails if an input
+// isn't signed by the owner of its output.
+func VerifySpend(transaction Transaction, prev PrevOutput) error {
+	tx := ToTx(transaction)
+	var ins []Outpoint
+	for _, in := range tx.TxIns {
+		ins = append(ins, Outpoint{Hash: in.InputHash, Index: in.InputVout})
+	}
+	msg := wire.NewMsgTx(1)
+	if err := msg.Deserialize(bytes.NewReader(serializeTx(ins, tx.TxOuts))); err != nil {
+		return err
+	}
+	msg.Version = int32(tx.Version)
+	msg.LockTime = tx.LockTime
+	fetcher := txscript.NewMultiPrevOutFetcher(nil)
+	scripts := make([][]byte, len(tx.TxIns))
+	values := make([]int64, len(tx.TxIns))
+	for i, in := range tx.TxIns {
+		script, value, ok := prev(in.InputHash, in.InputVout)
+		if !ok {
+			return fmt.Errorf("unknown output %s", UTXOID(in.InputHash, in.InputVout))
+		}
+		scripts[i], values[i] = script, int64(value)
+		msg.TxIn[i].SignatureScript = in.ScriptSig
+		msg.TxIn[i].Sequence = in.Sequence
+		fetcher.AddPrevOut(msg.TxIn[i].PreviousOutPoint,
+			wire.NewTxOut(values[i], script))
+	}
+	hashes := txscript.NewTxSigHashes(msg, fetcher)
+	for i := range msg.TxIn {
+		engine, err := txscript.NewEngine(scripts[i], msg, i,
+			txscript.StandardVerifyFlags, nil, hashes, values[i], fetcher)
+		if err == nil {
+			err = engine.Execute()
+		}
+		if err != nil {
+			return fmt.Errorf("input %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// serializeTx returns the raw transaction of version 1 spending the inputs,
+// with empty scripts, to the outputs.
+func serializeTx(ins []Outpoint, outs []*blkparser.TxOut) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(1))
+	writeVarInt(&buf, len(ins))
+	for _, in := range ins {
+		// the hashes are shown byte-reversed, see blkparser.HashString
+		hash, _ := hex.DecodeString(in.Hash)
+		for i := len(hash) - 1; i >= 0; i-- {
+			buf.WriteByte(hash[i])
+		}
+		binary.Write(&buf, binary.LittleEndian, in.Index)
+		writeVarInt(&buf, 0)
+		binary.Write(&buf, binary.LittleEndian, uint32(0xffffffff))
+	}
+	writeVarInt(&buf, len(outs))
+	for _, out := range outs {
+		binary.Write(&buf, binary.LittleEndian, out.Value)
+		writeVarInt(&buf, len(out.Pkscript))
+		buf.Write(out.Pkscript)
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+	return buf.Bytes()
+}
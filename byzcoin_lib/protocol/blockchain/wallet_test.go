@@ -0,0 +1,82 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// outputs returns a PrevOutput looking up the outputs of the transactions.
+func outputs(txs ...Transaction) PrevOutput {
+	return func(hash string, vout uint32) ([]byte, uint64, bool) {
+		for _, tx := range txs {
+			if tx.Hash() == hash && int(vout) < len(tx.Creates()) {
+				return ToTx(tx).TxOuts[vout].Pkscript, tx.Creates()[vout], true
+			}
+		}
+		return nil, 0, false
+	}
+}
+
+func TestWalletPay(t *testing.T) {
+	wallets := NewWallets(2, 1)
+	assert.Equal(t, wallets[0].PkScript(), NewWallets(1, 1)[0].PkScript())
+	assert.NotEqual(t, wallets[0].PkScript(), wallets[1].PkScript())
+	genesis := GenesisAllocation(wallets, 3, 10)
+	assert.Equal(t, uint64(30), wallets[0].Balance())
+	assert.Equal(t, uint64(30), wallets[1].Balance())
+
+	tx, err := wallets[0].Pay(Payment{PkScript: wallets[1].PkScript(), Value: 15})
+	require.Nil(t, err)
+	// two outputs of 10 pay 15, and 5 come back
+	assert.Equal(t, 2, len(tx.Spends()))
+	assert.Equal(t, []uint64{15, 5}, tx.Creates())
+	assert.Equal(t, uint64(15), wallets[0].Balance())
+	assert.Equal(t, uint64(30), wallets[1].Balance())
+	wallets[1].Receive(tx)
+	wallets[1].Receive(tx)
+	assert.Equal(t, uint64(45), wallets[1].Balance())
+	// the change is already in the wallet
+	wallets[0].Receive(tx)
+	assert.Equal(t, uint64(15), wallets[0].Balance())
+	require.Nil(t, VerifySpend(tx, outputs(genesis)))
+
+	// the next payment spends the change
+	next, err := wallets[0].Pay(Payment{PkScript: wallets[1].PkScript(), Value: 15})
+	require.Nil(t, err)
+	assert.Contains(t, next.Spends(), Outpoint{tx.Hash(), 1})
+	require.Nil(t, VerifySpend(next, outputs(genesis, tx)))
+	assert.NotNil(t, VerifySpend(next, outputs(genesis)), "unknown output")
+
+	_, err = wallets[0].Pay(Payment{PkScript: wallets[1].PkScript(), Value: 1})
+	assert.Equal(t, ErrInsufficientFunds, err)
+	_, err = wallets[1].Pay(Payment{PkScript: wallets[0].PkScript()})
+	assert.NotNil(t, err)
+	_, err = wallets[1].Pay()
+	assert.NotNil(t, err)
+}
+
+func TestWalletForeignOutput(t *testing.T) {
+	wallets := NewWallets(2, 1)
+	genesis := GenesisAllocation(wallets, 1, 10)
+	tx, err := wallets[0].Pay(Payment{PkScript: wallets[1].PkScript(), Value: 10})
+	require.Nil(t, err)
+	// the output spent pays to another key than the one signing
+	forged := func(hash string, vout uint32) ([]byte, uint64, bool) {
+		_, value, ok := outputs(genesis)(hash, vout)
+		return wallets[1].PkScript(), value, ok
+	}
+	assert.NotNil(t, VerifySpend(tx, forged))
+}
+
+func TestWalletConsolidates(t *testing.T) {
+	wallets := NewWallets(2, 1)
+	GenesisAllocation(wallets, 10, 1)
+	_, err := wallets[0].Pay(Payment{PkScript: wallets[1].PkScript(), Value: 1})
+	require.Nil(t, err)
+	// the payment also spends the smallest outputs, down to 7 left and the
+	// change
+	assert.Equal(t, maxWalletOutputs, len(wallets[0].Outpoints()))
+	assert.Equal(t, uint64(9), wallets[0].Balance())
+}
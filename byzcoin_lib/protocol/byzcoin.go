@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"math"
 	"sync"
 	"time"
@@ -13,6 +14,8 @@ import (
 	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
 	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
 	"github.com/dedis/paper_17_sosp_omniledger/cosi"
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+	"github.com/dedis/paper_17_sosp_omniledger/faults"
 	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/log"
@@ -25,8 +28,8 @@ type ByzCoin struct {
 	*onet.TreeNodeInstance
 	// the suite we use
 	suite abstract.Suite
-	// aggregated public key of the peers
-	aggregatedPublic abstract.Point
+	// policy is how many peers have to sign a block
+	policy *crypto.SignaturePolicy
 	// prepare-round cosi
 	prepare *cosi.Cosi
 	// commit-round cosi
@@ -44,15 +47,20 @@ type ByzCoin struct {
 	challengeCommitChan chan challengeCommitChan
 	// channel for response
 	responseChan chan responseChan
+	// channel for the re-assignment of a group to a new leader
+	reassignChan chan reassignChan
 	// channel to notify when we are done
 	done chan bool
 	// channel to notify when the prepare round is finished
 	prepareFinishedChan chan bool
-	// channel used to wait for the verification of the block
+	// channel used to wait for the verification of the block, buffered so
+	// that it doesn't block if the instance ends before it is read
 	verifyBlockChan chan bool
 
 	//  block to pass up between the two rounds (prepare + commits)
 	tempBlock *blockchain.TrBlock
+	// proposal of the block by the root, nil if it isn't valid
+	proposal *Proposal
 	// exceptions given during the rounds that is used in the signature
 	tempExceptions []cosi.Exception
 
@@ -63,19 +71,32 @@ type ByzCoin struct {
 	lastBlock string
 	// last key block computed
 	lastKeyBlock string
-	// temporary buffer of "prepare" commitments
-	tempPrepareCommit []*cosi.Commitment
+	// temporary buffer of "prepare" commitments, by child
+	tempPrepareCommit map[onet.TreeNodeID]*cosi.Commitment
 	tpcMut            sync.Mutex
-	// temporary buffer of "commit" commitments
-	tempCommitCommit []*cosi.Commitment
+	// temporary buffer of "commit" commitments, by child
+	tempCommitCommit map[onet.TreeNodeID]*cosi.Commitment
 	tccMut           sync.Mutex
-	// temporary buffer of "prepare" responses
-	tempPrepareResponse []*cosi.Response
+	// temporary buffer of "prepare" responses, by child
+	tempPrepareResponse map[onet.TreeNodeID]*Response
 	tprMut              sync.Mutex
-	// temporary buffer of "commit" responses
-	tempCommitResponse []*cosi.Response
+	// temporary buffer of "commit" responses, by child
+	tempCommitResponse map[onet.TreeNodeID]*Response
 	tcrMut             sync.Mutex
 
+	// parent and children are the nodes we talk to. They start as our parent
+	// and children in the tree, and change when a group is re-assigned or a
+	// subtree re-parented because a node failed, see handleFailover.
+	parent   *onet.TreeNode
+	children []*onet.TreeNode
+	// commitments we sent up, sent again to a new parent
+	sentCommitments map[RoundType]*Commitment
+	// the rounds whose announcement we handled
+	announced map[RoundType]bool
+	// leaders are the children we made the leader of the members after them
+	// in their group
+	leaders map[onet.TreeNodeID]bool
+
 	// refusal to sign for the commit phase or not. This flag is set during the
 	// Challenge of the commit phase and will be used during the response of the
 	// commit phase to put an exception or to sign.
@@ -104,6 +125,70 @@ type ByzCoin struct {
 
 	// root fails:
 	rootFailMode uint
+	// subLeaderTimeout is how long we wait for the commitments of our
+	// children before re-parenting the subtrees of the failed ones, 0 to
+	// never re-parent.
+	subLeaderTimeout uint64
+	// failing are the roster indices of the nodes simulating a crash
+	failing []int32
+	// the announcements we sent, sent again to the new parents
+	prepareAnnounce *Announce
+	commitAnnounce  *Announce
+	// failoverChan is notified when the failover timeout expires
+	failoverChan chan bool
+	// prepareTimeout is how long the root waits for the responses of the
+	// prepare round, 0 to wait for all of them, see SetPrepareTimeout
+	prepareTimeout     uint64
+	prepareTimeoutChan chan bool
+	// prepareDone is set when the root responded in the prepare round
+	prepareDone bool
+	// how many children the root left out at the prepare timeout
+	timedOut int
+	// exceptions of the subtrees left out at the prepare timeout, for both
+	// rounds
+	latePrepareExceptions []cosi.Exception
+	lateCommitExceptions  []cosi.Exception
+	// aborted is set when the root aborts the round at the prepare timeout
+	aborted bool
+	// onAbort is called with the transactions of an aborted round, see
+	// RegisterOnAbort
+	onAbort func([]blkparser.Tx)
+	// onPrepareDone is called by the root at the end of the prepare round,
+	// see RegisterOnPrepareDone
+	onPrepareDone func(*blockchain.TrBlock)
+	// previous is closed when the instance of the block this one extends is
+	// done, see Pipeline
+	previous <-chan struct{}
+	// finished is closed when the instance is done
+	finished     chan struct{}
+	finishedOnce sync.Once
+	// closed is closed when onet shuts the instance down, see Shutdown
+	closed     chan struct{}
+	closedOnce sync.Once
+	// store is where the root persists the finalized blocks, if any
+	store blockchain.Store
+	// chain is the chain the root extends with the finalized blocks, if any
+	chain *blockchain.ChainValidator
+	// compression is how the root compresses the block it announces
+	compression blockchain.Compression
+	// exceptions of the children that failed
+	failedExceptions []cosi.Exception
+	// exceptions of the nodes that refused to sign the prepare round
+	prepareExceptions []cosi.Exception
+	// how many groups have been re-assigned
+	failovers int
+	// how many times the root assembled the block again without an invalid
+	// transaction
+	retries int
+	// reportTxs makes the nodes report the invalid transactions, see
+	// SetTxReports
+	reportTxs bool
+	// invalidTxs are the transactions of the block we found invalid
+	invalidTxs TxMask
+	// stripped are the transactions the root dropped from its block
+	stripped TxMask
+	// challengeStarted is set when the root stops waiting for commitments
+	challengeStarted bool
 	// Call back when we start the announcement of the prepare phase
 	onAnnouncementPrepare func()
 	// callback when we finished the response of the prepare phase
@@ -115,17 +200,15 @@ type ByzCoin struct {
 	// view change setup and measurement
 	viewchangeChan chan struct {
 		*onet.TreeNode
-		viewChange
+		ViewChange
 	}
 	vcMeasure *monitor.TimeMeasure
 	// bool set to true when the final signature is produced
 	doneSigning chan bool
 	// lock associated
 	doneLock sync.Mutex
-	// threshold for how much exception
-	threshold int
 	// threshold for how much view change acceptance we need
-	// basically n - threshold
+	// basically n - MaxExceptions
 	viewChangeThreshold int
 	// how many view change request have we received
 	vcCounter int
@@ -144,14 +227,28 @@ func NewByzCoinProtocol(n *onet.TreeNodeInstance) (*ByzCoin, error) {
 	bz.suite = n.Suite()
 	bz.prepare = cosi.NewCosi(n.Suite(), n.Private())
 	bz.commit = cosi.NewCosi(n.Suite(), n.Private())
-	bz.verifyBlockChan = make(chan bool)
+	bz.verifyBlockChan = make(chan bool, 1)
 	bz.doneProcessing = make(chan bool, 2)
 	bz.doneSigning = make(chan bool, 1)
 	bz.timeoutChan = make(chan uint64, 1)
+	bz.failoverChan = make(chan bool, 1)
+	bz.prepareTimeoutChan = make(chan bool, 1)
+	bz.finished = make(chan struct{})
+	bz.closed = make(chan struct{})
+	bz.tempPrepareCommit = make(map[onet.TreeNodeID]*cosi.Commitment)
+	bz.tempCommitCommit = make(map[onet.TreeNodeID]*cosi.Commitment)
+	bz.tempPrepareResponse = make(map[onet.TreeNodeID]*Response)
+	bz.tempCommitResponse = make(map[onet.TreeNodeID]*Response)
+	bz.sentCommitments = make(map[RoundType]*Commitment)
+	bz.announced = make(map[RoundType]bool)
+	bz.leaders = make(map[onet.TreeNodeID]bool)
+	bz.parent = n.Parent()
+	bz.children = n.Children()
 
 	//bz.endProto, _ = end.NewEndProtocol(n)
-	bz.aggregatedPublic = n.Roster().Aggregate
-	bz.threshold = int(math.Ceil(float64(len(bz.Tree().List())) / 3.0))
+	nodes := len(bz.Tree().List())
+	bz.policy = crypto.NewSignaturePolicy(bz.suite, n.Roster().Publics(),
+		chainPolicy().Signers(nodes))
 	bz.viewChangeThreshold = int(math.Ceil(float64(len(bz.Tree().List())) * 2.0 / 3.0))
 
 	// register channels
@@ -173,6 +270,9 @@ func NewByzCoinProtocol(n *onet.TreeNodeInstance) (*ByzCoin, error) {
 	if err := n.RegisterChannel(&bz.viewchangeChan); err != nil {
 		return bz, err
 	}
+	if err := n.RegisterChannel(&bz.reassignChan); err != nil {
+		return bz, err
+	}
 
 	n.OnDoneCallback(bz.nodeDone)
 
@@ -201,7 +301,20 @@ func (bz *ByzCoin) Start() error {
 		return err
 	}
 	log.Lvl3(bz.Name(), "finished announcment prepare")
-	return bz.startAnnouncementPrepare()
+	if err := bz.startAnnouncementPrepare(); err != nil {
+		return err
+	}
+	if bz.subLeaderTimeout > 0 {
+		go bz.startFailoverTimer()
+	}
+	return nil
+}
+
+// SendTo shadows the one of the TreeNodeInstance to send the message
+// through the emulated network of the simulation, if any, see netem, with
+// the faults of the simulation.
+func (bz *ByzCoin) SendTo(to *onet.TreeNode, msg interface{}) error {
+	return faults.SendTo(bz.TreeNodeInstance, to, msg)
 }
 
 // Dispatch listen on the different channels
@@ -217,11 +330,11 @@ func (bz *ByzCoin) listen() {
 		select {
 		case msg := <-bz.announceChan:
 			// Announcement
-			err = bz.handleAnnouncement(msg.Announce)
+			err = bz.handleAnnouncement(msg.TreeNode, msg.Announce)
 		case msg := <-bz.commitChan:
 			// Commitment
 			if !fail {
-				err = bz.handleCommit(msg.Commitment)
+				err = bz.handleCommit(msg.TreeNode, msg.Commitment)
 			}
 		case msg := <-bz.challengePrepareChan:
 			// Challenge
@@ -237,11 +350,21 @@ func (bz *ByzCoin) listen() {
 			if !fail {
 				switch msg.Response.TYPE {
 				case RoundPrepare:
-					err = bz.handleResponsePrepare(&msg.Response)
+					err = bz.handleResponsePrepare(msg.TreeNode, &msg.Response)
 				case RoundCommit:
-					err = bz.handleResponseCommit(&msg.Response)
+					err = bz.handleResponseCommit(msg.TreeNode, &msg.Response)
 				}
 			}
+		case msg := <-bz.reassignChan:
+			err = bz.handleReassign(msg.TreeNode, &msg.Reassign)
+		case <-bz.failoverChan:
+			if !fail {
+				err = bz.handleFailover()
+			}
+		case <-bz.prepareTimeoutChan:
+			if !fail {
+				err = bz.handlePrepareTimeout()
+			}
 		case timeout := <-bz.timeoutChan:
 			// start the timer
 			if timeoutStarted {
@@ -251,12 +374,15 @@ func (bz *ByzCoin) listen() {
 			go bz.startTimer(timeout)
 		case msg := <-bz.viewchangeChan:
 			// receive view change
-			err = bz.handleViewChange(msg.TreeNode, &msg.viewChange)
+			err = bz.handleViewChange(msg.TreeNode, &msg.ViewChange)
 		case <-bz.doneProcessing:
 			// we are done
 			log.Lvl2(bz.Name(), "ByzCoin Dispatches stop.")
 			bz.tempBlock = nil
 			return
+		case <-bz.closed:
+			log.Lvl2(bz.Name(), "ByzCoin shut down before it was done.")
+			return
 		}
 		if err != nil {
 			log.Error(bz.Name(), "Error handling messages:", err)
@@ -273,10 +399,13 @@ func (bz *ByzCoin) startAnnouncementPrepare() error {
 
 	ann := bz.prepare.CreateAnnouncement()
 	bza := &Announce{
-		TYPE:         RoundPrepare,
-		Announcement: ann,
-		Timeout:      bz.rootTimeout,
+		TYPE:            RoundPrepare,
+		Announcement:    ann,
+		Timeout:         bz.rootTimeout,
+		Failing:         bz.failing,
+		FailoverTimeout: bz.subLeaderTimeout,
 	}
+	bz.prepareAnnounce = bza
 	log.Lvl3("ByzCoin Start Announcement (PREPARE)")
 	return bz.sendAnnouncement(bza)
 }
@@ -288,21 +417,43 @@ func (bz *ByzCoin) startAnnouncementCommit() error {
 	bza := &Announce{
 		TYPE:         RoundCommit,
 		Announcement: ann,
+		Failing:      bz.failing,
 	}
+	bz.commitAnnounce = bza
 	log.Lvl3(bz.Name(), "ByzCoin Start Announcement (COMMIT)")
 	return bz.sendAnnouncement(bza)
 }
 
 func (bz *ByzCoin) sendAnnouncement(bza *Announce) error {
 	var err error
-	for _, tn := range bz.Children() {
+	for _, tn := range bz.children {
 		err = bz.SendTo(tn, bza)
 	}
 	return err
 }
 
 // handleAnnouncement pass the announcement to the right CoSi struct.
-func (bz *ByzCoin) handleAnnouncement(ann Announce) error {
+func (bz *ByzCoin) handleAnnouncement(from *onet.TreeNode, ann Announce) error {
+	for _, idx := range ann.Failing {
+		if int(idx) == bz.TreeNode().RosterIndex {
+			log.Lvl2(bz.Name(), "simulates a crash")
+			bz.Done()
+			return nil
+		}
+	}
+	if sent, ok := bz.sentCommitments[ann.TYPE]; ok {
+		if !ann.Failover {
+			return nil
+		}
+		// a new leader took over our group
+		bz.parent = from
+		return bz.SendTo(bz.parent, sent)
+	}
+	if bz.announced[ann.TYPE] {
+		return nil
+	}
+	bz.announced[ann.TYPE] = true
+	bz.parent = from
 	var announcement = new(Announce)
 
 	switch ann.TYPE {
@@ -311,29 +462,46 @@ func (bz *ByzCoin) handleAnnouncement(ann Announce) error {
 			TYPE:         RoundPrepare,
 			Announcement: bz.prepare.Announce(ann.Announcement),
 			Timeout:      ann.Timeout,
+			Failover:     ann.Failover,
+			Failing:      ann.Failing,
 		}
+		bz.prepareAnnounce = announcement
 
-		bz.timeoutChan <- ann.Timeout
-		// give the timeout
+		// give the timeout, unless we already did before taking over a
+		// group
+		select {
+		case bz.timeoutChan <- ann.Timeout:
+		default:
+		}
 		log.Lvl3(bz.Name(), "ByzCoin Handle Announcement PREPARE")
 
-		if bz.IsLeaf() {
+		if bz.isLeaf() {
 			return bz.startCommitmentPrepare()
 		}
+		// the deeper, the sooner we give up on a child, so that our parent
+		// still gets the commitments of the subtree we re-parent
+		if ann.FailoverTimeout > 0 {
+			bz.subLeaderTimeout = ann.FailoverTimeout / 2
+			announcement.FailoverTimeout = bz.subLeaderTimeout
+			go bz.startFailoverTimer()
+		}
 	case RoundCommit:
 		announcement = &Announce{
 			TYPE:         RoundCommit,
 			Announcement: bz.commit.Announce(ann.Announcement),
+			Failover:     ann.Failover,
+			Failing:      ann.Failing,
 		}
+		bz.commitAnnounce = announcement
 		log.Lvl3(bz.Name(), "ByzCoin Handle Announcement COMMIT")
 
-		if bz.IsLeaf() {
+		if bz.isLeaf() {
 			return bz.startCommitmentCommit()
 		}
 	}
 
 	var err error
-	for _, tn := range bz.Children() {
+	for _, tn := range bz.children {
 		err = bz.SendTo(tn, announcement)
 	}
 	return err
@@ -342,8 +510,9 @@ func (bz *ByzCoin) handleAnnouncement(ann Announce) error {
 // startPrepareCommitment send the first commitment up the tree for the prepare
 // round.
 func (bz *ByzCoin) startCommitmentPrepare() error {
-	cm := bz.prepare.CreateCommitment()
-	err := bz.SendTo(bz.Parent(), &Commitment{TYPE: RoundPrepare, Commitment: cm})
+	cm := &Commitment{TYPE: RoundPrepare, Commitment: bz.prepare.CreateCommitment()}
+	bz.sentCommitments[RoundPrepare] = cm
+	err := bz.SendTo(bz.parent, cm)
 	log.Lvl3(bz.Name(), "ByzCoin Start Commitment PREPARE")
 	return err
 }
@@ -351,115 +520,233 @@ func (bz *ByzCoin) startCommitmentPrepare() error {
 // startCommitCommitment send the first commitment up the tree for the
 // commitment round.
 func (bz *ByzCoin) startCommitmentCommit() error {
-	cm := bz.commit.CreateCommitment()
-
-	err := bz.SendTo(bz.Parent(), &Commitment{TYPE: RoundCommit, Commitment: cm})
+	cm := &Commitment{TYPE: RoundCommit, Commitment: bz.commit.CreateCommitment()}
+	bz.sentCommitments[RoundCommit] = cm
+	err := bz.SendTo(bz.parent, cm)
 	log.Lvl3(bz.Name(), "ByzCoin Start Commitment COMMIT", err)
 	return err
 }
 
 // handle the arrival of a commitment
-func (bz *ByzCoin) handleCommit(ann Commitment) error {
-	var commitment *Commitment
+func (bz *ByzCoin) handleCommit(from *onet.TreeNode, ann Commitment) error {
+	if !bz.isChild(from) {
+		log.Lvl2(bz.Name(), "ignores commitment of", from.Name())
+		return nil
+	}
 	// store it and check if we have enough commitments
 	switch ann.TYPE {
 	case RoundPrepare:
 		log.Lvl3(bz.Name(), "ByzCoin handle Commit PREPARE")
 		bz.tpcMut.Lock()
-		bz.tempPrepareCommit = append(bz.tempPrepareCommit, ann.Commitment)
-		if len(bz.tempPrepareCommit) < len(bz.Children()) {
+		bz.tempPrepareCommit[from.ID] = ann.Commitment
+		bz.tpcMut.Unlock()
+	case RoundCommit:
+		log.Lvl3(bz.Name(), "ByzCoin handle Commit COMMIT")
+		bz.tccMut.Lock()
+		bz.tempCommitCommit[from.ID] = ann.Commitment
+		bz.tccMut.Unlock()
+	}
+	return bz.committed(ann.TYPE)
+}
+
+// committed sends our commitment of the round up once we have the ones of
+// all our children. The root starts the challenge once it has them for both
+// rounds.
+func (bz *ByzCoin) committed(round RoundType) error {
+	if bz.IsRoot() {
+		return bz.rootCommitted()
+	}
+	if _, ok := bz.sentCommitments[round]; ok || !bz.announced[round] {
+		return nil
+	}
+	var commit *cosi.Commitment
+	switch round {
+	case RoundPrepare:
+		bz.tpcMut.Lock()
+		if len(bz.tempPrepareCommit) < len(bz.children) {
 			bz.tpcMut.Unlock()
 			return nil
 		}
-		commit := bz.prepare.Commit(bz.tempPrepareCommit)
+		commit = bz.prepare.Commit(commitments(bz.tempPrepareCommit))
 		bz.tpcMut.Unlock()
-		if bz.IsRoot() {
-			return bz.startChallengePrepare()
-		}
-		commitment = &Commitment{
-			TYPE:       RoundPrepare,
-			Commitment: commit,
-		}
 	case RoundCommit:
 		bz.tccMut.Lock()
-		bz.tempCommitCommit = append(bz.tempCommitCommit, ann.Commitment)
-		if len(bz.tempCommitCommit) < len(bz.Children()) {
+		if len(bz.tempCommitCommit) < len(bz.children) {
 			bz.tccMut.Unlock()
 			return nil
 		}
-		commit := bz.commit.Commit(bz.tempCommitCommit)
+		commit = bz.commit.Commit(commitments(bz.tempCommitCommit))
 		bz.tccMut.Unlock()
-		if bz.IsRoot() {
-			// do nothing
-			//	bz.startChallengeCommit()
-			// stop the processing of the round, wait the end of the "prepare"
-			// round. startChallengeCOmmit will be called then.
-			return nil
-		}
-		commitment = &Commitment{
-			TYPE:       RoundCommit,
-			Commitment: commit,
-		}
-		log.Lvl3(bz.Name(), "ByzCoin handle Commit COMMIT")
 	}
-	err := bz.SendTo(bz.Parent(), commitment)
-	return err
+	cm := &Commitment{TYPE: round, Commitment: commit}
+	bz.sentCommitments[round] = cm
+	return bz.SendTo(bz.parent, cm)
+}
+
+// rootCommitted starts the challenge of the "prepare" round once the root has
+// the commitments of all its children for both rounds. The challenge of the
+// "commit" round waits the end of the "prepare" round.
+func (bz *ByzCoin) rootCommitted() error {
+	if !bz.IsRoot() || bz.challengeStarted {
+		return nil
+	}
+	bz.tpcMut.Lock()
+	bz.tccMut.Lock()
+	if len(bz.tempPrepareCommit) < len(bz.children) ||
+		len(bz.tempCommitCommit) < len(bz.children) {
+		bz.tccMut.Unlock()
+		bz.tpcMut.Unlock()
+		return nil
+	}
+	bz.challengeStarted = true
+	bz.prepare.Commit(commitments(bz.tempPrepareCommit))
+	bz.commit.Commit(commitments(bz.tempCommitCommit))
+	bz.tccMut.Unlock()
+	bz.tpcMut.Unlock()
+	return bz.startChallengePrepare()
 }
 
 // startPrepareChallenge create the challenge and send its down the tree
 func (bz *ByzCoin) startChallengePrepare() error {
 	// make the challenge out of it
 	var err error
-	bz.tempBlock, err = GetBlock(bz.transactions, bz.lastBlock, bz.lastKeyBlock)
+	var valid bool
+	bz.tempBlock, valid, err = bz.assembleBlock()
 	if err != nil {
 		return err
 	}
 	trblock := bz.tempBlock
-	marshalled, err := json.Marshal(trblock)
+	bz.proposal, err = newProposal(bz.suite, bz.Private(), bz.Token().RoundID,
+		bz.lastBlock, trblock)
 	if err != nil {
 		return err
 	}
-	ch, err := bz.prepare.CreateChallenge(marshalled)
+	ch, err := bz.prepare.CreateChallenge(bz.proposal.Hash())
+	if err != nil {
+		return err
+	}
+	packed, err := bz.compression.Pack(trblock)
 	if err != nil {
 		return err
 	}
 	bizChal := &ChallengePrepare{
 		TYPE:      RoundPrepare,
 		Challenge: ch,
-		TrBlock:   trblock,
+		Block:     packed,
+		LastBlock: bz.lastBlock,
+		Proposal:  bz.proposal,
+		ReportTxs: bz.reportTxs,
 	}
 
-	go VerifyBlock(bz.tempBlock, bz.lastBlock, bz.lastKeyBlock, bz.verifyBlockChan)
+	if bz.reportTxs {
+		go bz.verifyTransactions(trblock, bz.lastBlock, bz.lastKeyBlock)
+	} else {
+		// the root already checked the block, only its cost is left
+		go func() {
+			simulateVerification(trblock)
+			bz.verifyBlockChan <- valid
+		}()
+	}
 	log.Lvl3(bz.Name(), "ByzCoin Start Challenge PREPARE")
+	if bz.prepareTimeout > 0 {
+		go bz.startPrepareTimer()
+	}
 	// send to children
-	for _, tn := range bz.Children() {
+	for _, tn := range bz.children {
 		err = bz.SendTo(tn, bizChal)
 	}
 	return err
 }
 
+// assembleBlock makes the block of the transactions of the root and checks
+// it. As long as the checks find an invalid transaction, the root drops it
+// and starts the round again from a smaller block: the commitments of the
+// nodes don't depend on the block, so no challenge was given for them yet.
+// It returns the block and whether it is valid, i.e. not if it was invalid
+// for another reason or all its transactions were. With the reports of the
+// transactions, see SetTxReports, it only checks the rules of UsePolicy.
+func (bz *ByzCoin) assembleBlock() (*blockchain.TrBlock, bool, error) {
+	if len(bz.transactions) < 1 {
+		return nil, false, errors.New("no transaction available")
+	}
+	check := checkBlock
+	if bz.reportTxs {
+		// the nodes check the transactions and report the invalid ones,
+		// only the rules of the chain are left to the root
+		check = func(block *blockchain.TrBlock, _, _ string) error {
+			return chainPolicy().Check(block)
+		}
+	}
+	for {
+		block := newBlock(bz.transactions, bz.lastBlock, bz.lastKeyBlock)
+		err := check(block, bz.lastBlock, bz.lastKeyBlock)
+		var txErr *blockchain.TxError
+		if err == nil || !errors.As(err, &txErr) || len(bz.transactions) == 1 {
+			if err != nil {
+				log.Lvl2(bz.Name(), "Invalid block:", err)
+			}
+			monitor.RecordSingleMeasure("block_size", float64(block.BlockSize))
+			return block, err == nil, nil
+		}
+		txs := dropTransaction(bz.transactions, txErr.Hash)
+		if len(txs) == len(bz.transactions) {
+			log.Lvl2(bz.Name(), "Invalid block:", err)
+			return block, false, nil
+		}
+		log.Lvl2(bz.Name(), "Dropping an invalid transaction:", err)
+		bz.transactions = txs
+		bz.retries++
+	}
+}
+
+// dropTransaction returns the transactions without the one of the hash.
+func dropTransaction(transactions []blkparser.Tx, hash string) []blkparser.Tx {
+	txs := make([]blkparser.Tx, 0, len(transactions))
+	for _, tx := range transactions {
+		if tx.Hash != hash {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
+// Retries returns how many times the root assembled the block again without
+// an invalid transaction.
+func (bz *ByzCoin) Retries() int {
+	return bz.retries
+}
+
 // startCommitChallenge waits the end of the "prepare" round.
 // Then it creates the challenge and sends it along with the
 // "prepare" signature down the tree.
 func (bz *ByzCoin) startChallengeCommit() error {
+	if bz.previous != nil {
+		<-bz.previous
+	}
 	if bz.onChallengeCommit != nil {
 		bz.onChallengeCommit()
 	}
-	// create the challenge out of it
-	marshalled := bz.tempBlock.HashSum()
-	chal, err := bz.commit.CreateChallenge(marshalled)
+	// create the challenge out of the header, so that the committed block
+	// carries a signature light clients can check without the transactions
+	chal, err := bz.commit.CreateChallenge(bz.tempBlock.Header.HashSum())
 	if err != nil {
 		return err
 	}
 
-	// send challenge + signature
+	// send challenge + signature, with the group leaders that failed and
+	// the nodes that refused to sign the prepare round as exceptions
+	bz.tempExceptions = append(append([]cosi.Exception{}, bz.failedExceptions...),
+		bz.lateCommitExceptions...)
 	bzc := &ChallengeCommit{
 		TYPE:      RoundCommit,
 		Challenge: chal,
 		Signature: bz.prepare.Signature(),
+		Exceptions: append(append([]cosi.Exception{}, bz.failedExceptions...),
+			bz.prepareExceptions...),
+		Invalid: bz.stripped,
 	}
 	log.Lvl3("ByzCoin Start Challenge COMMIT")
-	for _, tn := range bz.Children() {
+	for _, tn := range bz.children {
 		err = bz.SendTo(tn, bzc)
 	}
 	return err
@@ -468,20 +755,36 @@ func (bz *ByzCoin) startChallengeCommit() error {
 // handlePrepareChallenge receive the challenge messages for the "prepare"
 // round.
 func (bz *ByzCoin) handleChallengePrepare(ch *ChallengePrepare) error {
-	bz.tempBlock = ch.TrBlock
-	// start the verification of the block
-	go VerifyBlock(bz.tempBlock, bz.lastBlock, bz.lastKeyBlock, bz.verifyBlockChan)
+	block, err := ch.Block.Unpack()
+	if err != nil {
+		return err
+	}
+	bz.tempBlock = block
+	bz.lastBlock = ch.LastBlock
+	bz.reportTxs = ch.ReportTxs
+	// only verify the block the root proposed
+	if err := bz.checkProposal(ch.Proposal, block, ch.LastBlock); err != nil {
+		log.Lvl2(bz.Name(), "Invalid proposal:", err)
+		go func() { bz.verifyBlockChan <- false }()
+	} else {
+		bz.proposal = ch.Proposal
+		// start the verification of the block
+		if bz.reportTxs {
+			go bz.verifyTransactions(block, bz.lastBlock, bz.lastKeyBlock)
+		} else {
+			go VerifyBlock(bz.tempBlock, bz.lastBlock, bz.lastKeyBlock, bz.verifyBlockChan)
+		}
+	}
 	// acknowledge the challenge and send its down
 	chal := bz.prepare.Challenge(ch.Challenge)
 	ch.Challenge = chal
 
 	log.Lvl3(bz.Name(), "ByzCoin handle Challenge PREPARE")
 	// go to response if leaf
-	if bz.IsLeaf() {
+	if bz.isLeaf() {
 		return bz.startResponsePrepare()
 	}
-	var err error
-	for _, tn := range bz.Children() {
+	for _, tn := range bz.children {
 		err = bz.SendTo(tn, ch)
 	}
 	return err
@@ -490,114 +793,89 @@ func (bz *ByzCoin) handleChallengePrepare(ch *ChallengePrepare) error {
 // handleCommitChallenge will verify the signature + check if no more than 1/3
 // of participants refused to sign.
 func (bz *ByzCoin) handleChallengeCommit(ch *ChallengeCommit) error {
-	// marshal the block
-	marshalled, err := json.Marshal(bz.tempBlock)
-	if err != nil {
-		return err
-	}
 	ch.Challenge = bz.commit.Challenge(ch.Challenge)
 
-	// verify if the signature is correct
-	if err := cosi.VerifyCosiSignatureWithException(bz.suite, bz.aggregatedPublic, marshalled, ch.Signature, ch.Exceptions); err != nil {
+	// verify if the signature is on the proposal of the root and no more
+	// than 1/3 failed nodes
+	if bz.proposal == nil {
+		log.Lvl2(bz.Name(), "No valid proposal to sign")
+		bz.signRefusal = true
+	} else if err := bz.policy.VerifyWithExceptions(bz.proposal.Hash(),
+		ch.Signature, ch.Exceptions); err != nil {
 		log.Error(bz.Name(), "Verification of the signature failed:", err)
 		bz.signRefusal = true
 	}
-
-	// Verify if we have no more than 1/3 failed nodes
-
-	if len(ch.Exceptions) > int(bz.threshold) {
-		log.Errorf("More than 1/3 (%d/%d) refused to sign ! ABORT", len(ch.Exceptions), len(bz.Roster().List))
+	// the root dropped the transactions reported invalid, if any
+	if !bz.IsRoot() && !bz.applyReports(ch.Invalid) {
+		log.Lvl2(bz.Name(), "The block still holds invalid transactions")
 		bz.signRefusal = true
 	}
 
 	// store the exceptions for later usage
 	bz.tempExceptions = ch.Exceptions
 	log.Lvl3(bz.Name(), "ByzCoin handle Challenge COMMIT")
-	if bz.IsLeaf() {
+	if bz.isLeaf() {
 		return bz.startResponseCommit()
 	}
 
 	// send it down
-	for _, tn := range bz.Children() {
+	var err error
+	for _, tn := range bz.children {
 		err = bz.SendTo(tn, ch)
 	}
-	return nil
+	return err
 }
 
 // startPrepareResponse wait the verification of the block and then start the
 // challenge process
 func (bz *ByzCoin) startResponsePrepare() error {
-	// create response
-	resp, err := bz.prepare.CreateResponse()
+	// wait the verification, and only sign if OK
+	ok := bz.waitResponseVerification()
+	bzr, err := bz.respond(RoundPrepare, bz.prepare, nil, !ok)
 	if err != nil {
 		return err
 	}
-	// wait the verification
-	bzr, ok := bz.waitResponseVerification()
-	if ok {
-		log.Lvl3(bz.Name(), "ok")
-		// apend response only if OK
-		bzr.Response = resp
-	}
 	log.Lvl3(bz.Name(), "ByzCoin Start Response PREPARE")
 	// send to parent
-	return bz.SendTo(bz.Parent(), bzr)
+	return bz.SendTo(bz.parent, bzr)
 }
 
 // startCommitResponse will create the response for the commit phase and send it
 // up. It will not create the response if it decided the signature is wrong from
 // the prepare phase.
 func (bz *ByzCoin) startResponseCommit() error {
-	bzr := &Response{
-		TYPE: RoundCommit,
-	}
-	// if i dont want to sign
-	if bz.signRefusal {
-		bzr.Exceptions = append(bzr.Exceptions, cosi.Exception{
-			Public:     bz.Public(),
-			Commitment: bz.commit.GetCommitment(),
-		})
-	} else {
-		// otherwise i create the response
-		resp, err := bz.commit.CreateResponse()
-		if err != nil {
-			return err
-		}
-		bzr.Response = resp
+	bzr, err := bz.respond(RoundCommit, bz.commit, nil, bz.signRefusal)
+	if err != nil {
+		return err
 	}
 	log.Lvl3(bz.Name(), "ByzCoin Start Response COMMIT")
 	// send to parent
-	err := bz.SendTo(bz.Parent(), bzr)
+	err = bz.SendTo(bz.parent, bzr)
 	bz.Done()
 	return err
 }
 
 // handleResponseCommit handles the responses for the commit round during the
 // response phase.
-func (bz *ByzCoin) handleResponseCommit(bzr *Response) error {
+func (bz *ByzCoin) handleResponseCommit(from *onet.TreeNode, bzr *Response) error {
+	if !bz.isChild(from) {
+		return nil
+	}
 	// check if we have enough
 	// FIXME possible data race
 	bz.tcrMut.Lock()
-	bz.tempCommitResponse = append(bz.tempCommitResponse, bzr.Response)
+	bz.tempCommitResponse[from.ID] = bzr
 
-	if len(bz.tempCommitResponse) < len(bz.Children()) {
+	if len(bz.tempCommitResponse) < len(bz.children) {
 		bz.tcrMut.Unlock()
 		return nil
 	}
 
-	if bz.signRefusal {
-		bzr.Exceptions = append(bzr.Exceptions, cosi.Exception{
-			Public:     bz.Public(),
-			Commitment: bz.commit.GetCommitment(),
-		})
-		bz.tcrMut.Unlock()
-	} else {
-		resp, err := bz.commit.Response(bz.tempCommitResponse)
-		bz.tcrMut.Unlock()
-		if err != nil {
-			return err
-		}
-		bzr.Response = resp
+	bzr, err := bz.respond(RoundCommit, bz.commit, bz.tempCommitResponse,
+		bz.signRefusal)
+	bz.tcrMut.Unlock()
+	if err != nil {
+		return err
 	}
 
 	// notify we have finished to participate in this signature
@@ -605,7 +883,23 @@ func (bz *ByzCoin) handleResponseCommit(bzr *Response) error {
 	log.Lvl3(bz.Name(), "ByzCoin handle Response COMMIT (refusal=", bz.signRefusal, ")")
 	// if root we have finished
 	if bz.IsRoot() {
+		bz.tempExceptions = append(bz.tempExceptions, bzr.Exceptions...)
 		sig := bz.Signature()
+		if sig.Sig != nil {
+			bz.tempBlock.Signature = &blockchain.HeaderSignature{
+				MaskedSignature: *sig.Sig,
+			}
+		}
+		if bz.chain != nil {
+			if err := bz.chain.Append(bz.tempBlock); err != nil {
+				log.Error(bz.Name(), "couldn't append block:", err)
+			}
+		}
+		if bz.store != nil {
+			if err := bz.store.Put(bz.tempBlock); err != nil {
+				log.Error(bz.Name(), "couldn't store block:", err)
+			}
+		}
 		if bz.onResponseCommitDone != nil {
 			bz.onResponseCommitDone()
 		}
@@ -617,72 +911,161 @@ func (bz *ByzCoin) handleResponseCommit(bzr *Response) error {
 	}
 
 	// otherwise , send the response up
-	err := bz.SendTo(bz.Parent(), bzr)
+	err = bz.SendTo(bz.parent, bzr)
 	bz.Done()
 	return err
 }
 
-func (bz *ByzCoin) handleResponsePrepare(bzr *Response) error {
+func (bz *ByzCoin) handleResponsePrepare(from *onet.TreeNode, bzr *Response) error {
+	if !bz.isChild(from) {
+		return nil
+	}
 	// check if we have enough
 	bz.tprMut.Lock()
-	bz.tempPrepareResponse = append(bz.tempPrepareResponse, bzr.Response)
-	if len(bz.tempPrepareResponse) < len(bz.Children()) {
+	bz.tempPrepareResponse[from.ID] = bzr
+	if len(bz.tempPrepareResponse) < len(bz.children) {
 		bz.tprMut.Unlock()
 		return nil
 	}
+	bz.tprMut.Unlock()
+	return bz.respondPrepare()
+}
 
-	// wait for verification
-	bzrReturn, ok := bz.waitResponseVerification()
-	if ok {
-		// append response
-		resp, err := bz.prepare.Response(bz.tempPrepareResponse)
-		bz.tprMut.Unlock()
-		if err != nil {
-			return err
+// respondPrepare responds in the prepare round with the responses of the
+// children, once the block is verified. The root starts the commit round.
+func (bz *ByzCoin) respondPrepare() error {
+	if bz.IsRoot() {
+		if bz.prepareDone {
+			return nil
 		}
-		bzrReturn.Response = resp
-	} else {
-		bz.tprMut.Unlock()
+		bz.prepareDone = true
+	}
+	// wait for verification, and only sign if OK
+	ok := bz.waitResponseVerification()
+	bz.tprMut.Lock()
+	bzrReturn, err := bz.respond(RoundPrepare, bz.prepare,
+		bz.tempPrepareResponse, !ok)
+	bz.tprMut.Unlock()
+	if err != nil {
+		return err
 	}
 
 	log.Lvl3(bz.Name(), "ByzCoin Handle Response PREPARE")
 	// if I'm root, we are finished, let's notify the "commit" round
 	if bz.IsRoot() {
+		bz.prepareExceptions = append(bzrReturn.Exceptions,
+			bz.latePrepareExceptions...)
+		bz.stripInvalid(bzrReturn.Invalid)
 		// notify listeners (simulation) we finished
 		if bz.onResponsePrepareDone != nil {
 			bz.onResponsePrepareDone()
 		}
+		if bz.timedOut > 0 && bz.preparedBlock() == nil {
+			return bz.abort()
+		}
+		if bz.onPrepareDone != nil {
+			bz.onPrepareDone(bz.preparedBlock())
+		}
 		return bz.startChallengeCommit()
 	}
 	// send up
-	return bz.SendTo(bz.Parent(), bzrReturn)
+	return bz.SendTo(bz.parent, bzrReturn)
 }
 
-// computePrepareResponse wait the end of the verification and returns the
-// ByzCoinResponse along with the flag:
-// true => no exception, the verification is correct
-// false => exception, the verification is NOT correct
-func (bz *ByzCoin) waitResponseVerification() (*Response, bool) {
-	bzr := &Response{
-		TYPE: RoundPrepare,
-	}
+// waitResponseVerification waits for the end of the verification of the
+// block and returns whether it is correct. If it isn't, we ask for a view
+// change, and refuse to sign the prepare round.
+func (bz *ByzCoin) waitResponseVerification() bool {
 	// wait the verification
 	verified := <-bz.verifyBlockChan
 	if !verified {
-		// append our exception
-		bzr.Exceptions = append(bzr.Exceptions, cosi.Exception{
-			Public:     bz.Public(),
-			Commitment: bz.prepare.GetCommitment(),
-		})
 		bz.sendAndMeasureViewchange()
-		return bzr, false
 	}
+	return verified
+}
+
+// checks are the checks VerifyBlock runs on the transactions, see UseChecks.
+var checks = struct {
+	sync.Mutex
+	names []string
+	fns   []blockchain.Check
+}{}
+
+// policy is the policy of the chain the nodes run, see UsePolicy.
+var policy = struct {
+	sync.Mutex
+	blockchain.ChainPolicy
+}{}
+
+// UsePolicy makes the nodes enforce the policy of the genesis block of their
+// chain: the root checks its block against it before it proposes it,
+// VerifyBlock refuses the blocks that break it, and the signatures of both
+// rounds need as many signers as it does. It has to be called before the
+// instances are created.
+func UsePolicy(p blockchain.ChainPolicy) {
+	policy.Lock()
+	defer policy.Unlock()
+	policy.ChainPolicy = p
+}
 
-	return bzr, true
+// chainPolicy returns the policy of UsePolicy.
+func chainPolicy() blockchain.ChainPolicy {
+	policy.Lock()
+	defer policy.Unlock()
+	return policy.ChainPolicy
+}
+
+// UseChecks makes VerifyBlock run the checks registered under the names, see
+// blockchain.RegisterCheck, in order. The time each check takes is recorded
+// in the "verify_<name>" measure.
+func UseChecks(names ...string) error {
+	fns := make([]blockchain.Check, len(names))
+	for i, name := range names {
+		c, err := blockchain.GetCheck(name)
+		if err != nil {
+			return err
+		}
+		fns[i] = c
+	}
+	checks.Lock()
+	defer checks.Unlock()
+	checks.names = names
+	checks.fns = fns
+	return nil
+}
+
+// runChecks runs the checks of UseChecks on the block, stopping at the first
+// error.
+func runChecks(block *blockchain.TrBlock) error {
+	checks.Lock()
+	names, fns := checks.names, checks.fns
+	checks.Unlock()
+	for i, c := range fns {
+		m := monitor.NewTimeMeasure("verify_" + names[i])
+		err := c(block)
+		m.Record()
+		if err != nil {
+			return fmt.Errorf("%s check: %w", names[i], err)
+		}
+	}
+	return nil
 }
 
 // VerifyBlock is a simulation of a real verification block algorithm
 func VerifyBlock(block *blockchain.TrBlock, lastBlock, lastKeyBlock string, done chan bool) {
+	simulateVerification(block)
+	err := checkBlock(block, lastBlock, lastKeyBlock)
+	if err != nil {
+		log.Lvl2("Invalid block:", err)
+	}
+	verified := err == nil
+	// notify it
+	log.Lvl3("Verification of the block done =", verified)
+	done <- verified
+}
+
+// simulateVerification waits as long as the verification of the block takes.
+func simulateVerification(block *blockchain.TrBlock) {
 	//We measure the average block verification delays is 174ms for an average
 	//block of 500kB.
 	//To simulate the verification cost of bigger blocks we multiply 174ms
@@ -692,37 +1075,78 @@ func VerifyBlock(block *blockchain.TrBlock, lastBlock, lastKeyBlock string, done
 	var n time.Duration
 	n = time.Duration(s / (500 * 1024))
 	time.Sleep(150 * time.Millisecond * n) //verification of 174ms per 500KB simulated
-	// verification of the header
-	verified := block.Header.Parent == lastBlock && block.Header.ParentKey == lastKeyBlock
-	verified = verified && block.Header.MerkleRoot == blockchain.HashRootTransactions(block.TransactionList)
-	verified = verified && block.HeaderHash == blockchain.HashHeader(block.Header)
-	// notify it
-	log.Lvl3("Verification of the block done =", verified)
-	done <- verified
 }
 
-// GetBlock returns the next block available from the transaction pool.
+// checkBlock checks the header of the block and its link to the last block,
+// whose height doesn't matter here, the rules of UsePolicy, and its
+// transactions with the checks of UseChecks.
+func checkBlock(block *blockchain.TrBlock, lastBlock, lastKeyBlock string) error {
+	if err := blockchain.NewChainValidator(lastBlock, -1).Check(block); err != nil {
+		return err
+	}
+	if err := chainPolicy().Check(block); err != nil {
+		return err
+	}
+	if err := runChecks(block); err != nil {
+		return err
+	}
+	if block.Header.ParentKey != lastKeyBlock {
+		return errors.New("block doesn't extend the last key block")
+	}
+	return nil
+}
+
+// GetBlock returns the next block available from the transaction pool, and
+// records its size in bytes in the "block_size" measure.
 func GetBlock(transactions []blkparser.Tx, lastBlock, lastKeyBlock string) (*blockchain.TrBlock, error) {
 	if len(transactions) < 1 {
 		return nil, errors.New("no transaction available")
 	}
+	trblock := newBlock(transactions, lastBlock, lastKeyBlock)
+	monitor.RecordSingleMeasure("block_size", float64(trblock.BlockSize))
+	return trblock, nil
+}
 
+// newBlock returns the block of the transactions.
+func newBlock(transactions []blkparser.Tx, lastBlock, lastKeyBlock string) *blockchain.TrBlock {
 	trlist := blockchain.NewTransactionList(transactions, len(transactions))
 	header := blockchain.NewHeader(trlist, lastBlock, lastKeyBlock)
-	trblock := blockchain.NewTrBlock(trlist, header)
-	return trblock, nil
+	return blockchain.NewTrBlock(trlist, header)
 }
 
 // Signature will generate the final signature, the output of the ByzCoin
 // protocol.
 func (bz *ByzCoin) Signature() *BlockSignature {
+	sig, err := bz.policy.NewMaskedSignature(bz.commit.Signature(),
+		bz.tempExceptions)
+	if err != nil {
+		log.Error(bz.Name(), "Invalid exceptions:", err)
+	}
 	return &BlockSignature{
-		Sig:        bz.commit.Signature(),
-		Block:      bz.tempBlock,
-		Exceptions: bz.tempExceptions,
+		Sig:   sig,
+		Block: bz.tempBlock,
 	}
 }
 
+// SetStore makes the root persist the finalized block in the store. It has
+// to be called before Start.
+func (bz *ByzCoin) SetStore(store blockchain.Store) {
+	bz.store = store
+}
+
+// SetCompression makes the root compress the block it announces. It has to
+// be called before Start.
+func (bz *ByzCoin) SetCompression(c blockchain.Compression) {
+	bz.compression = c
+}
+
+// SetChain makes the root propose a block extending the chain, and append
+// it once finalized. It has to be called before Start.
+func (bz *ByzCoin) SetChain(chain *blockchain.ChainValidator) {
+	bz.chain = chain
+	bz.lastBlock, _ = chain.Tip()
+}
+
 // RegisterOnDone registers a callback to call when the byzcoin protocols has
 // really finished (after a view change maybe)
 func (bz *ByzCoin) RegisterOnDone(fn func()) {
@@ -769,14 +1193,15 @@ func (bz *ByzCoin) sendAndMeasureViewchange() {
 	}
 }
 
-// viewChange is simply the last hash / id of the previous leader.
-type viewChange struct {
+// ViewChange is simply the last hash / id of the previous leader. It is
+// exported, so that onet can set it in the channel of the view changes.
+type ViewChange struct {
 	LastBlock [sha256.Size]byte
 }
 
 // newViewChange creates a new view change.
-func newViewChange() *viewChange {
-	res := &viewChange{}
+func newViewChange() *ViewChange {
+	res := &ViewChange{}
 	for i := 0; i < sha256.Size; i++ {
 		res.LastBlock[i] = 0
 	}
@@ -785,7 +1210,7 @@ func newViewChange() *viewChange {
 
 // handleViewChange receives a view change request and if received more than
 // 2/3, accept the view change.
-func (bz *ByzCoin) handleViewChange(tn *onet.TreeNode, vc *viewChange) error {
+func (bz *ByzCoin) handleViewChange(tn *onet.TreeNode, vc *ViewChange) error {
 	bz.vcCounter++
 	// only do it once
 	if bz.vcCounter == bz.viewChangeThreshold {
@@ -802,12 +1227,21 @@ func (bz *ByzCoin) handleViewChange(tn *onet.TreeNode, vc *viewChange) error {
 	return nil
 }
 
+// Shutdown stops the instance when onet closes it. It only matters if it
+// isn't done yet, e.g. on a node the root left out at the timeout of the
+// prepare round, which doesn't get the commit round.
+func (bz *ByzCoin) Shutdown() error {
+	bz.closedOnce.Do(func() { close(bz.closed) })
+	return nil
+}
+
 // nodeDone is either called by the end of EndProtocol or by the end of the
 // response phase of the commit round.
 func (bz *ByzCoin) nodeDone() bool {
 	log.Lvl3(bz.Name(), "nodeDone()      ----- ")
 	bz.doneProcessing <- true
 	log.Lvl3(bz.Name(), "nodeDone()      +++++  ", bz.onDoneCallback)
+	bz.finishedOnce.Do(func() { close(bz.finished) })
 	if bz.onDoneCallback != nil {
 		bz.onDoneCallback()
 	}
@@ -1,12 +1,23 @@
 package byzcoin
 
 import (
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
-	"github.com/dedis/paper_17_sosp_omniledger/cosi"
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+	"github.com/dedis/paper_17_sosp_omniledger/faults"
+	"github.com/dedis/paper_17_sosp_omniledger/metrics"
+	"github.com/dedis/paper_17_sosp_omniledger/netem"
+	"github.com/dedis/paper_17_sosp_omniledger/profile"
+	"github.com/dedis/paper_17_sosp_omniledger/seed"
+	"github.com/dedis/paper_17_sosp_omniledger/soak"
+	"github.com/dedis/paper_17_sosp_omniledger/suites"
 	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/log"
@@ -32,6 +43,17 @@ type Simulation struct {
 type SimulationConfig struct {
 	// Blocksize is the number of transactions in one block:
 	Blocksize int
+	// MaxBlockBytes, MaxTxCount, TxVersions and SignerThreshold are the
+	// rules of the blocks held by the genesis block, see
+	// blockchain.ChainPolicy: the most bytes of transactions in one block
+	// and the most transactions, on top of Blocksize, if not 0, the versions
+	// of the transactions the blocks can hold, all if empty, and how many
+	// nodes have to sign a block, n-ceil(n/3) if 0. The client still sends
+	// Blocksize transactions per round.
+	MaxBlockBytes   int
+	MaxTxCount      int
+	TxVersions      []uint32
+	SignerThreshold int
 	// timeout the leader after TimeoutMs milliseconds
 	TimeoutMs uint64
 	// Fail:
@@ -39,8 +61,194 @@ type SimulationConfig struct {
 	// 1 fail by doing nothing
 	// 2 fail by sending wrong blocks
 	Fail uint
+	// GroupSize switches to the ByzCoinX communication pattern with groups
+	// of GroupSize members, instead of the tree given by BF and Depth.
+	GroupSize int
+	// SubLeaderTimeoutMs is how long the root waits for a group leader
+	// before re-assigning its group, 0 to never re-assign. The nodes with
+	// children of a deeper tree wait half as long as their parent before
+	// re-parenting the subtrees of the failed ones, recorded as
+	// "reparented".
+	SubLeaderTimeoutMs uint64
+	// PrepareTimeoutMs is how long the root waits for the responses of the
+	// prepare round before it goes on without the missing subtrees, or
+	// aborts the round and proposes its block again in the next one if
+	// too few signed, 0 to wait for all, see SetPrepareTimeout. The
+	// "prepare_timeout" measure counts the children left out, "aborted"
+	// the aborted rounds.
+	PrepareTimeoutMs uint64
+	// FailingSubLeaders is the number of group leaders that crash.
+	FailingSubLeaders int
+	// FailingInterior is the number of interior nodes of the tree given by
+	// BF and Depth that crash, without GroupSize.
+	FailingInterior int
+	// Native makes the client send generated transactions of the native
+	// format instead of the ones of the Bitcoin blocks.
+	Native bool
+	// StorePath is the BoltDB file the root persists the finalized blocks
+	// to, none if empty. The blocks are kept under the ID of the genesis
+	// block of the roster, so that several chains can share the file.
+	StorePath string
+	// Randomness is the hex encoded randomness of epoch 0 held by the
+	// genesis block.
+	Randomness string
+	// IndexPath is the BoltDB file the client indexes the transactions of
+	// the blocks it parses in, none if empty.
+	IndexPath string
+	// Mirrors are the URLs the block file is downloaded from if missing,
+	// blockchain.DefaultMirrors if empty.
+	Mirrors []string
+	// Compression is the codec the blocks are announced with, "snappy" or
+	// "flate" at CompressionLevel, uncompressed if empty.
+	Compression      string
+	CompressionLevel int
+	// Checks are the names of the checks the nodes run on the transactions
+	// of the blocks, see UseChecks, e.g. "scripts", "signatures" and
+	// "doublespend". The root drops the transactions that fail them from
+	// its block, the "round_retries" measure counts how many per round.
+	Checks []string
+	// TxReports makes the nodes report the transactions that fail the
+	// Checks instead of refusing the block, see SetTxReports: the root
+	// drops them in the same round instead of checking its block first, the
+	// "stripped_txs" measure counts how many per round.
+	TxReports bool
+	// ChainExport is the prefix of the JSON and CSV files the committed
+	// blocks are written to at the end of the run, none if empty.
+	ChainExport string
+	// NetClient makes the client send the transactions over the network to
+	// the last node of the roster, which passes them on to the root, and
+	// measure the "client_submit" and "client_confirm" latencies.
+	NetClient bool
+	// Load runs a load generator for the whole simulation instead of
+	// sending Blocksize transactions before every round: "open" for
+	// Poisson arrivals of LoadTPS transactions per second, "closed" for
+	// LoadConcurrency senders waiting for each of their transactions. The
+	// requests are measured after LoadWarmupMs during LoadDurationMs, and
+	// the rounds run until then instead of Rounds times. LoadConfirm makes
+	// the requests of the network client wait for their transactions to be
+	// in a signed block.
+	Load            string
+	LoadTPS         float64
+	LoadConcurrency int
+	LoadWarmupMs    uint64
+	LoadDurationMs  uint64
+	LoadConfirm     bool
+	// Clients makes that many simulated users with their own wallets pay
+	// each other, instead of sending the transactions of the Bitcoin
+	// blocks. The genesis allocation gives ClientOutputs outputs, 1 if 0,
+	// to every wallet.
+	Clients       int
+	ClientOutputs int
+	// AdmissionGlobalTPS and AdmissionClientTPS limit the rate of the
+	// transactions the root admits from all clients and from every client,
+	// with bursts of AdmissionBurst, see UseAdmission. The rejected
+	// transactions are sent again when the root says so, but count as
+	// rejected for the load generator.
+	AdmissionGlobalTPS float64
+	AdmissionClientTPS float64
+	AdmissionBurst     int
+	// Fees is how the clients draw the fee per byte of their transactions,
+	// see FeeDistribution, the root packing the blocks by fee per byte.
+	// With a load generator, the latencies of the quarters of the requests
+	// of increasing fees are recorded apart.
+	Fees         string
+	FeeMean      float64
+	FeeHigh      float64
+	FeeHighShare float64
+	// Topology is the TOML file of the links the messages of the ByzCoin
+	// instances are held back as on, see netem.Topology, none if empty.
+	Topology string
+	// FaultyHosts is the number of byzantine nodes, the last ones of the
+	// roster, showing FaultType: "withhold" refuses to sign and
+	// "equivocate" signs a conflicting block, see UseFaulty. The root is
+	// never faulty.
+	FaultyHosts int
+	FaultType   string
+	// MetricsPort serves the metrics of every node on its /metrics
+	// endpoint for Prometheus, at MetricsPort plus the index of the node in
+	// the roster, none if 0, see metrics.ServeHost.
+	MetricsPort int
+	// PhaseBandwidth records the bytes of the announce, prepare and commit
+	// phases of every round in the monitor, see metrics.EndRound.
+	PhaseBandwidth bool
+	// GlobalSeed seeds the random streams of the simulation, so that a run
+	// can be reproduced, see package seed. The streams are seeded from the
+	// clock if it is 0.
+	GlobalSeed int64
+	// Suite is the cipher suite of the keys and the signatures, "ed25519"
+	// if empty, see package suites.
+	Suite string
+	// Profile writes a CPU profile of every round and a heap profile after
+	// it to the profiles directory of the simulation, see package profile.
+	Profile bool
+	// Pipeline starts the round of the next block at the end of the prepare
+	// round of the current one, so that its prepare round overlaps the
+	// commit round of the current one, as ByzCoinX does. The
+	// "blocks_per_second" measure compares it to the sequential rounds.
+	Pipeline bool
+	// Soak samples the memory and the goroutines every soak.Every rounds
+	// and fails the run if they grow without bound, see package soak. Rounds
+	// should then be in the thousands.
+	Soak bool
+	// Config are the crashes, lost and delayed messages injected in the
+	// protocol, see faults.Config.
+	faults.Config
 }
 
+// feeQuarters is how many groups of increasing fees the latencies of the
+// load generator are recorded for.
+const feeQuarters = 4
+
+// genesisPolicy returns the policy of the genesis block.
+func (c *SimulationConfig) genesisPolicy() blockchain.ChainPolicy {
+	p := blockchain.ChainPolicy{
+		MaxBlockBytes: c.MaxBlockBytes,
+		MaxTxCount:    c.MaxTxCount,
+		Threshold:     c.SignerThreshold,
+	}
+	p.AllowVersions(c.TxVersions...)
+	return p
+}
+
+// loadConfig returns the configuration of the load generator.
+func (c *SimulationConfig) loadConfig() (LoadConfig, error) {
+	config := LoadConfig{
+		TPS:         c.LoadTPS,
+		Concurrency: c.LoadConcurrency,
+		Warmup:      time.Duration(c.LoadWarmupMs) * time.Millisecond,
+		Duration:    time.Duration(c.LoadDurationMs) * time.Millisecond,
+		Confirm:     c.LoadConfirm,
+		Seed:        seed.Int64("load"),
+	}
+	switch c.Load {
+	case "open":
+		config.Mode = OpenLoop
+	case "closed":
+		config.Mode = ClosedLoop
+	default:
+		return config, errors.New("unknown load " + c.Load)
+	}
+	if c.Native || c.Clients > 0 {
+		return config, errors.New("the load generator only sends the " +
+			"transactions of the Bitcoin blocks")
+	}
+	return config, nil
+}
+
+// The help texts of the metrics of the root.
+const (
+	commitHelp  = "Time from the start of a round to the signature of its block."
+	mempoolHelp = "Transactions waiting in the mempool of the root."
+)
+
+// clientFunds is the value of every output of the genesis allocation of the
+// simulated users.
+const clientFunds = 100000000
+
+// confirmTimeout is how long the network client waits for the transactions
+// of a round to be in a signed block.
+const confirmTimeout = 5 * time.Minute
+
 // NewSimulation returns a fresh byzcoin simulation out of the toml config
 func NewSimulation(config string) (onet.Simulation, error) {
 	es := &Simulation{}
@@ -52,15 +260,25 @@ func NewSimulation(config string) (onet.Simulation, error) {
 }
 
 // Setup implements onet.Simulation interface. It checks on the availability
-// of the block-file and downloads it if missing. Then the block-file will be
-// copied to the simulation-directory
+// of the block-file and downloads it from the mirrors if missing. Then the
+// block-file will be copied to the simulation-directory
 func (e *Simulation) Setup(dir string, hosts []string) (*onet.SimulationConfig, error) {
-	err := blockchain.EnsureBlockIsAvailable(dir)
+	err := blockchain.NewDownloader(e.Mirrors...).Ensure(dir)
 	if err != nil {
-		log.Fatal("Couldn't get block:", err)
+		return nil, err
+	}
+	if e.Topology != "" {
+		if err := netem.Deploy(dir, e.Topology); err != nil {
+			return nil, err
+		}
+	}
+	if err := suites.Setup(dir, e.Suite); err != nil {
+		return nil, err
 	}
 	sc := &onet.SimulationConfig{}
 	e.CreateRoster(sc, hosts, 2000)
+	seed.Use(e.GlobalSeed)
+	seed.Keys(sc)
 	err = e.CreateTree(sc)
 	if err != nil {
 		return nil, err
@@ -68,6 +286,36 @@ func (e *Simulation) Setup(dir string, hosts []string) (*onet.SimulationConfig,
 	return sc, nil
 }
 
+// Node implements onet.Simulation interface. It seeds the random streams and
+// makes the nodes run the checks of the config on the blocks, enforce the
+// policy of the genesis block, emulate the links of the topology, inject the faults, show the byzantine behaviour of
+// the faulty nodes, serve their metrics and account the bytes of the phases.
+func (e *Simulation) Node(sc *onet.SimulationConfig) error {
+	seed.Use(e.GlobalSeed)
+	if err := UseChecks(e.Checks...); err != nil {
+		return err
+	}
+	UsePolicy(e.genesisPolicy())
+	if err := faults.Use(e.Config); err != nil {
+		return err
+	}
+	if err := UseFaulty(e.FaultyHosts, e.FaultType); err != nil {
+		return err
+	}
+	if e.Topology != "" {
+		if err := netem.UseTopology(filepath.Base(e.Topology)); err != nil {
+			return err
+		}
+	}
+	metrics.AccountPhases(e.PhaseBandwidth)
+	if e.MetricsPort > 0 {
+		if err := metrics.ServeHost(sc, e.MetricsPort); err != nil {
+			return err
+		}
+	}
+	return e.SimulationBFTree.Node(sc)
+}
+
 type monitorMut struct {
 	*monitor.TimeMeasure
 	sync.Mutex
@@ -88,7 +336,79 @@ func (m *monitorMut) Record() {
 // Run implements onet.Simulation interface
 func (e *Simulation) Run(sdaConf *onet.SimulationConfig) error {
 	log.Lvl2("Simulation starting with: Rounds=", e.Rounds)
+	profile.Use(e.Profile)
+	defer profile.EndRound()
+	soak.Use(e.Soak)
 	server := NewByzCoinServer(e.Blocksize, e.TimeoutMs, e.Fail)
+	server.UsePolicy(e.genesisPolicy())
+	server.UseAdmission(AdmissionConfig{
+		GlobalTPS: e.AdmissionGlobalTPS,
+		ClientTPS: e.AdmissionClientTPS,
+		Burst:     e.AdmissionBurst,
+	})
+	server.UseCompression(blockchain.Compression{
+		Codec: e.Compression,
+		Level: e.CompressionLevel,
+	})
+	if e.StorePath != "" {
+		randomness, err := hex.DecodeString(e.Randomness)
+		if err != nil {
+			return err
+		}
+		db, err := blockchain.OpenBoltDB(e.StorePath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		genesis := blockchain.NewGenesisBlock(sdaConf.Roster.Publics(), 0, randomness,
+			e.genesisPolicy())
+		store, err := db.Chain(genesis.HeaderHash)
+		if err != nil {
+			return err
+		}
+		if _, _, err := store.LatestBlock(); err == blockchain.ErrBlockNotFound {
+			if err := store.Put(genesis); err != nil {
+				return err
+			}
+		}
+		if err := server.UseStore(store); err != nil {
+			return err
+		}
+	}
+	var index *blockchain.TxIndex
+	if e.IndexPath != "" {
+		var err error
+		index, err = blockchain.OpenTxIndex(e.IndexPath, blockchain.GetBlockDir())
+		if err != nil {
+			return err
+		}
+		defer index.Close()
+	}
+	exporter := blockchain.NewChainExporter()
+	tree := sdaConf.Tree
+	var failing []int32
+	if e.GroupSize > 0 {
+		tree = NewByzCoinXTree(sdaConf.Roster, e.GroupSize)
+		sdaConf.Overlay.RegisterTree(tree)
+		for i, leader := range tree.Root.Children {
+			if i >= e.FailingSubLeaders {
+				break
+			}
+			failing = append(failing, int32(leader.RosterIndex))
+		}
+		log.Lvl1("ByzCoinX with", len(tree.Root.Children), "groups and",
+			len(failing), "failing group leaders")
+	} else if e.FailingInterior > 0 {
+		for _, tn := range tree.List() {
+			if len(failing) == e.FailingInterior {
+				break
+			}
+			if !tn.IsRoot() && !tn.IsLeaf() {
+				failing = append(failing, int32(tn.RosterIndex))
+			}
+		}
+		log.Lvl1(len(failing), "failing interior nodes")
+	}
 	//pi, err := sdaConf.Overlay.CreateProtocol("Broadcast", sdaConf.Tree)
 	//if err != nil {
 	//	return err
@@ -104,22 +424,77 @@ func (e *Simulation) Run(sdaConf *onet.SimulationConfig) error {
 	//// wait
 	//<-broadDone
 
-	for round := 0; round < e.Rounds; round++ {
+	if e.NetClient {
+		server.ListenClientTransactions(sdaConf.Server)
+	}
+	fees := FeeDistribution{Kind: e.Fees, Mean: e.FeeMean, High: e.FeeHigh,
+		HighShare: e.FeeHighShare}
+	if err := fees.check(); err != nil {
+		return err
+	}
+	newClient := func() *Client {
 		client := NewClient(server)
-		err := client.StartClientSimulation(blockchain.GetBlockDir(), e.Blocksize)
+		if e.NetClient {
+			list := sdaConf.Roster.List
+			client = NewNetClient(sdaConf.Roster, list[len(list)-1])
+		}
+		client.UseIndex(index)
+		if e.Fees != "" {
+			client.UseFees(fees, seed.Int64("fees"))
+		}
+		return client
+	}
+	var clients *WalletClients
+	if e.Clients > 0 {
+		outputs := e.ClientOutputs
+		if outputs == 0 {
+			outputs = 1
+		}
+		clients = NewWalletClients(e.Clients, outputs, clientFunds,
+			seed.Int64("wallets"))
+	}
+	var load *LoadGenerator
+	if e.Load != "" {
+		config, err := e.loadConfig()
 		if err != nil {
-			log.Error("Error in ClientSimulation:", err)
 			return err
 		}
-
-		log.Lvl1("Starting round", round)
-		// create an empty node
-		tni := sdaConf.Overlay.NewTreeNodeInstanceFromProtoName(sdaConf.Tree, "ByzCoin")
+		load, err = newClient().StartLoad(blockchain.GetBlockDir(), config)
 		if err != nil {
 			return err
 		}
+		log.Lvl1("Running", e.Load, "loop load")
+	}
+	// the rounds share a client, which goes on with the transactions of
+	// the blocks where the previous round stopped
+	client := newClient()
+	defer client.Close()
+	host := metrics.Host(sdaConf.Server.ServerIdentity)
+	// previous is the last round, whose commit round the prepare round of
+	// the next one overlaps with Pipeline
+	var previous *inflight
+	runStart := time.Now()
+	rounds := 0
+	for round := 0; e.moreRounds(round, load); round++ {
+		// the callbacks of the round may run after the next one started
+		round := round
+		rounds++
+		confirmed := make(chan error, 1)
+		if load != nil {
+			confirmed <- nil
+		} else if err := e.submitRound(round, client, clients, confirmed); err != nil {
+			return err
+		}
+
+		log.Lvl1("Starting round", round)
+		faults.StartRound(round)
+		metrics.StartRound(round)
+		profile.StartRound(sdaConf.Server.ServerIdentity, round)
+		// create an empty node
+		tni := sdaConf.Overlay.NewTreeNodeInstanceFromProtoName(tree, "ByzCoin")
 		// instantiate a byzcoin protocol
 		rComplete := monitor.NewTimeMeasure("round")
+		start := time.Now()
 		pi, err := server.Instantiate(tni)
 		if err != nil {
 			return err
@@ -127,18 +502,51 @@ func (e *Simulation) Run(sdaConf *onet.SimulationConfig) error {
 		sdaConf.Overlay.RegisterProtocolInstance(pi)
 
 		bz := pi.(*ByzCoin)
+		bz.SetSubLeaderTimeout(e.SubLeaderTimeoutMs)
+		bz.SetPrepareTimeout(e.PrepareTimeoutMs)
+		bz.SimulateFailures(failing)
+		bz.SetTxReports(e.TxReports)
+		var prepared chan *blockchain.TrBlock
+		if e.Pipeline {
+			if previous != nil {
+				bz.Pipeline(previous.block, previous.bz.Finished())
+			}
+			prepared = make(chan *blockchain.TrBlock, 1)
+			bz.RegisterOnPrepareDone(func(block *blockchain.TrBlock) {
+				prepared <- block
+			})
+		}
 		// Register callback for the generation of the signature !
 		bz.RegisterOnSignatureDone(func(sig *BlockSignature) {
-			if err := verifyBlockSignature(tni.Suite(), tni.Roster().Aggregate, sig); err != nil {
+			rejected := 0.0
+			if err := verifyBlockSignature(tni.Suite(), tni.Roster().Publics(), sig); err != nil {
 				log.Error("Round", round, "failed:", err)
+				rejected = 1
 			} else {
 				log.Lvl2("Round", round, "success")
+				server.Confirm(sig.Block)
+				host.Observe("block_commit_seconds", commitHelp,
+					time.Since(start).Seconds())
 			}
+			monitor.RecordSingleMeasure("rejected", rejected)
+			// without a signature, no member signed
+			var mask crypto.SignerMask
+			if sig.Sig != nil {
+				mask = sig.Sig.Mask
+			}
+			n := len(tni.Roster().List)
+			if sig.Block != nil {
+				exporter.Add(sig.Block, time.Now(), blockchain.Signers(mask, n))
+			}
+			monitor.RecordSingleMeasure("failovers", float64(bz.Failovers()))
+			monitor.RecordSingleMeasure("round_retries", float64(bz.Retries()))
+			monitor.RecordSingleMeasure("stripped_txs", float64(bz.Stripped()))
+			monitor.RecordSingleMeasure("exceptions", float64(n-mask.Count()))
 
 		})
 
 		// Register when the protocol is finished (all the nodes have finished)
-		done := make(chan bool)
+		done := make(chan bool, 1)
 		bz.RegisterOnDone(func() {
 			done <- true
 		})
@@ -159,19 +567,156 @@ func (e *Simulation) Run(sdaConf *onet.SimulationConfig) error {
 				}
 			}()
 		}
-		// wait for the end
-		<-done
-		log.Lvl3("Round", round, "finished")
-		rComplete.Record()
+		current := &inflight{round: round, bz: bz, done: done,
+			confirmed: confirmed, rComplete: rComplete}
+		if e.Pipeline {
+			// go on with the next round at the end of the prepare round
+			select {
+			case current.block = <-prepared:
+			case <-bz.Finished():
+			}
+			if previous != nil {
+				previous.wait()
+			}
+			previous = current
+			if current.block == nil {
+				// the next round has no block to extend
+				current.wait()
+				previous = nil
+			}
+		} else {
+			current.wait()
+		}
+		faults.EndRound(len(sdaConf.Roster.List))
+		metrics.EndRound(sdaConf.Server.ServerIdentity)
+		profile.EndRound()
+		host.Set("mempool_depth", mempoolHelp, float64(server.Mempool().Len()))
+		soak.EndRound(round)
+	}
+	if previous != nil {
+		previous.wait()
+	}
+	monitor.RecordSingleMeasure("blocks_per_second",
+		float64(rounds)/time.Since(runStart).Seconds())
+	if equivocations := Equivocations(); len(equivocations) > 0 {
+		log.Error("The nodes found", len(equivocations), "equivocations of the root")
+	}
+	monitor.RecordSingleMeasure("equivocations", float64(len(Equivocations())))
+	if load != nil {
+		stats := load.Stop()
+		log.Lvl1("Load of", stats.Throughput(), "transactions per second,",
+			stats.Failed, "failed and", stats.Rejected, "rejected out of",
+			stats.Sent)
+		monitor.RecordSingleMeasure("load_tps", stats.Throughput())
+		monitor.RecordSingleMeasure("load_failed", float64(stats.Failed))
+		monitor.RecordSingleMeasure("load_rejected", float64(stats.Rejected))
+		for _, p := range []float64{50, 90, 99} {
+			monitor.RecordSingleMeasure(fmt.Sprintf("load_latency_p%.0f", p),
+				stats.Percentile(p).Seconds())
+		}
+		if e.Fees != "" {
+			for i, quarter := range stats.ByFee(feeQuarters) {
+				for _, p := range []float64{50, 99} {
+					monitor.RecordSingleMeasure(
+						fmt.Sprintf("load_latency_fee%d_p%.0f", i+1, p),
+						quarter.Percentile(p).Seconds())
+				}
+			}
+		}
+	}
+	admission := server.AdmissionStats()
+	log.Lvl1("Admitted", admission.Admitted, "transactions, rejected",
+		admission.RateLimited, "over the rate limits and", admission.PoolFull,
+		"with a full pool, dropped", admission.Invalid, "invalid and",
+		admission.Duplicate, "duplicate ones,",
+		admission.Queued, "still queued")
+	monitor.RecordSingleMeasure("admission_rate_limited", float64(admission.RateLimited))
+	monitor.RecordSingleMeasure("admission_pool_full", float64(admission.PoolFull))
+	monitor.RecordSingleMeasure("admission_invalid", float64(admission.Invalid))
+	monitor.RecordSingleMeasure("admission_duplicate", float64(admission.Duplicate))
+	monitor.RecordSingleMeasure("admission_queued", float64(admission.Queued))
+	if err := soak.Check(); err != nil {
+		return err
+	}
+	if e.ChainExport != "" {
+		return exporter.Export(e.ChainExport)
+	}
+	return nil
+}
 
+// inflight is a round whose commit round may still run, see Pipeline.
+type inflight struct {
+	round     int
+	bz        *ByzCoin
+	done      chan bool
+	confirmed chan error
+	rComplete *monitor.TimeMeasure
+	// block is the block of the round once its prepare round is signed
+	block *blockchain.TrBlock
+}
+
+// wait waits for the end of the round, records how long it took and for
+// the confirmation of its transactions.
+func (r *inflight) wait() {
+	<-r.done
+	log.Lvl3("Round", r.round, "finished")
+	r.rComplete.Record()
+	aborted := 0.0
+	if r.bz.Aborted() {
+		log.Lvl1("Round", r.round, "aborted, its block is proposed again")
+		aborted = 1
+	}
+	monitor.RecordSingleMeasure("aborted", aborted)
+	if err := <-r.confirmed; err != nil {
+		log.Error("Round", r.round, "not confirmed:", err)
+	}
+}
+
+// moreRounds returns whether to run the round: until Rounds without load,
+// else until the end of the measurement of the load.
+func (e *Simulation) moreRounds(round int, load *LoadGenerator) bool {
+	if load == nil {
+		return round < e.Rounds
+	}
+	select {
+	case <-load.Measured():
+		return false
+	default:
+		return true
+	}
+}
+
+// submitRound makes the client, or the simulated users if there are any,
+// send the transactions of a round. A network client waits for their
+// confirmation in the background and sends the outcome on confirmed, the
+// others send nil right away.
+func (e *Simulation) submitRound(round int, client *Client,
+	clients *WalletClients, confirmed chan<- error) error {
+	var err error
+	if clients != nil {
+		err = clients.Submit(client, e.Blocksize)
+	} else if e.Native {
+		err = client.SubmitTransactions(NativeBatch(e.Blocksize, uint64(round)))
+	} else {
+		err = client.StartClientSimulation(blockchain.GetBlockDir(), e.Blocksize)
+	}
+	if err != nil {
+		log.Error("Error in ClientSimulation:", err)
+		return err
+	}
+	if e.NetClient {
+		go func() {
+			confirmed <- client.WaitConfirmations(confirmTimeout)
+		}()
+	} else {
+		confirmed <- nil
 	}
 	return nil
 }
 
-func verifyBlockSignature(suite abstract.Suite, aggregate abstract.Point, sig *BlockSignature) error {
+func verifyBlockSignature(suite abstract.Suite, publics []abstract.Point, sig *BlockSignature) error {
 	if sig == nil || sig.Sig == nil || sig.Block == nil {
 		return errors.New("Empty block signature")
 	}
-	marshalled := sig.Block.HashSum()
-	return cosi.VerifySignatureWithException(suite, aggregate, marshalled, sig.Sig.Challenge, sig.Sig.Response, sig.Exceptions)
+	return sig.Block.VerifySignature(suite, publics, chainPolicy())
 }
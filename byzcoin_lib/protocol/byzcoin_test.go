@@ -0,0 +1,194 @@
+package byzcoin
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+// testTxs returns n transactions, the i-th with the hash of first+i.
+func testTxs(first, n int) []blkparser.Tx {
+	var txs []blkparser.Tx
+	for i := 0; i < n; i++ {
+		txs = append(txs, blkparser.Tx{Hash: fmt.Sprintf("%064x", first+i)})
+	}
+	return txs
+}
+
+// runRound runs an instance of ByzCoin on the tree whose root proposes the
+// block of the transactions. It returns the instance of the root and the
+// signature of the block, nil if the root got none. The setup functions are
+// called on the root before it starts.
+func runRound(t *testing.T, local *onet.LocalTest, tree *onet.Tree,
+	txs []blkparser.Tx, setup ...func(*ByzCoin)) (*ByzCoin, *BlockSignature) {
+	pi, err := local.CreateProtocol("ByzCoin", tree)
+	require.Nil(t, err)
+	bz := pi.(*ByzCoin)
+	bz.transactions = txs
+	for _, s := range setup {
+		s(bz)
+	}
+	done := make(chan *BlockSignature, 2)
+	bz.RegisterOnSignatureDone(func(sig *BlockSignature) { done <- sig })
+	bz.RegisterOnDone(func() { done <- nil })
+	require.Nil(t, bz.Start())
+	select {
+	case sig := <-done:
+		return bz, sig
+	case <-time.After(10 * time.Second):
+		t.Fatal("round didn't finish")
+	}
+	return nil, nil
+}
+
+// service returns the TxService of the server of the tree node.
+func service(local *onet.LocalTest, tn *onet.TreeNode) *TxService {
+	return local.Servers[tn.ServerIdentity.ID].Service(TxServiceName).(*TxService)
+}
+
+// useBadCheck makes the nodes only check that the transactions of the blocks
+// aren't the bad ones, and returns their hashes.
+func useBadCheck(t *testing.T, txs ...blkparser.Tx) map[string]bool {
+	bad := make(map[string]bool)
+	for _, tx := range txs {
+		bad[tx.Hash] = true
+	}
+	blockchain.RegisterCheck("bad", func(b *blockchain.TrBlock) error {
+		for _, tx := range b.Txs {
+			if bad[tx.Hash] {
+				return &blockchain.TxError{Hash: tx.Hash, Reason: "bad"}
+			}
+		}
+		return nil
+	})
+	require.Nil(t, UseChecks("bad"))
+	return bad
+}
+
+func TestUseChecks(t *testing.T) {
+	defer UseChecks()
+	assert.NotNil(t, UseChecks("doublespend", "none"))
+	require.Nil(t, UseChecks("scripts", "doublespend"))
+	txs := testTxs(0, 2)
+	for i := range txs {
+		txs[i].TxIns = []*blkparser.TxIn{{InputHash: "a"}}
+	}
+	block := newBlock(txs, "last", "")
+	err := runChecks(block)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "doublespend check")
+	var txErr *blockchain.TxError
+	require.True(t, errors.As(err, &txErr))
+	assert.Equal(t, txs[1].Hash, txErr.Hash)
+	done := make(chan bool, 1)
+	VerifyBlock(block, "last", "", done)
+	assert.False(t, <-done)
+
+	require.Nil(t, UseChecks())
+	assert.Nil(t, runChecks(block))
+	VerifyBlock(block, "last", "", done)
+	assert.True(t, <-done)
+}
+
+func TestRetry(t *testing.T) {
+	txs := testTxs(0, 5)
+	bad := useBadCheck(t, txs[1], txs[3])
+	defer UseChecks()
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, _, tree := local.GenBigTree(7, 7, 2, true)
+
+	// the root drops the invalid transactions before it proposes the block
+	bz, sig := runRound(t, local, tree, txs)
+	require.NotNil(t, sig)
+	assert.Nil(t, verifyBlockSignature(bz.Suite(), bz.Roster().Publics(), sig))
+	assert.Equal(t, 2, bz.Retries())
+	require.Equal(t, 3, len(sig.Block.Txs))
+	for _, tx := range sig.Block.Txs {
+		assert.False(t, bad[tx.Hash])
+	}
+
+	// but keeps the last one, even if it is invalid
+	pi, err := local.CreateProtocol("ByzCoin", tree)
+	require.Nil(t, err)
+	bz = pi.(*ByzCoin)
+	defer bz.Done()
+	bz.transactions = []blkparser.Tx{txs[1], txs[3]}
+	block, valid, err := bz.assembleBlock()
+	require.Nil(t, err)
+	assert.False(t, valid)
+	assert.Equal(t, 1, len(block.Txs))
+	assert.Equal(t, 1, bz.Retries())
+}
+
+func TestDropTransaction(t *testing.T) {
+	txs := testTxs(0, 3)
+	assert.Equal(t, []blkparser.Tx{txs[0], txs[2]}, dropTransaction(txs, txs[1].Hash))
+	assert.Equal(t, txs, dropTransaction(txs, "other"))
+	assert.Equal(t, 3, len(txs))
+}
+
+func TestUsePolicy(t *testing.T) {
+	var p blockchain.ChainPolicy
+	p.AllowVersions(2)
+	UsePolicy(p)
+	defer UsePolicy(blockchain.ChainPolicy{})
+	txs := testTxs(0, 4)
+	for i := range txs {
+		txs[i].Version = uint32(1 + i%2)
+	}
+	// the root drops the transactions of the other versions before it
+	// proposes the block, with the reports of the transactions or not
+	for _, reports := range []bool{false, true} {
+		local := onet.NewLocalTest()
+		_, _, tree := local.GenBigTree(7, 7, 2, true)
+		bz, sig := runRound(t, local, tree, txs, func(bz *ByzCoin) {
+			bz.SetTxReports(reports)
+		})
+		require.NotNil(t, sig)
+		assert.Nil(t, verifyBlockSignature(bz.Suite(), bz.Roster().Publics(), sig))
+		assert.Equal(t, 2, bz.Retries())
+		require.Equal(t, 2, len(sig.Block.Txs))
+		for _, tx := range sig.Block.Txs {
+			assert.True(t, p.Allows(tx.Version))
+		}
+		local.CloseAll()
+	}
+}
+
+func TestHeaderSignature(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, tree := local.GenBigTree(7, 7, 2, true)
+	bz, sig := runRound(t, local, tree, testTxs(0, 3))
+	require.NotNil(t, sig)
+	block := sig.Block
+	require.NotNil(t, block.Signature)
+	publics := roster.Publics()
+	require.Nil(t, block.VerifySignature(bz.Suite(), publics, blockchain.ChainPolicy{}))
+	// the signature is of the members of the roster
+	assert.NotNil(t, block.VerifySignature(bz.Suite(), publics[1:], blockchain.ChainPolicy{}))
+	other := append([]abstract.Point{config.NewKeyPair(bz.Suite()).Public}, publics[1:]...)
+	assert.NotNil(t, block.VerifySignature(bz.Suite(), other, blockchain.ChainPolicy{}))
+	// on the header of the block
+	header := *block.Header
+	block.Header.MerkleRoot = testTxs(10, 1)[0].Hash
+	assert.NotNil(t, block.VerifySignature(bz.Suite(), publics, blockchain.ChainPolicy{}))
+	*block.Header = header
+	block.Header.Parent = "other"
+	assert.NotNil(t, block.VerifySignature(bz.Suite(), publics, blockchain.ChainPolicy{}))
+}
@@ -0,0 +1,325 @@
+package byzcoin
+
+import (
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/dedis/paper_17_sosp_omniledger/cosi"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/simul/monitor"
+)
+
+// ByzCoinX replaces the deep tree of ByzCoin with a tree of depth two: the
+// root talks to a small number of group leaders, and each group leader fans
+// out to the members of its group. A slow or failed node only stalls its own
+// group, and if a group leader doesn't send its commitments in time, the root
+// re-assigns the group to the next member, keeping the failed leader as an
+// exception of the signature.
+//
+// The nodes with children of a deeper tree do the same for their children,
+// waiting half as long as their parent: if an interior node fails, the
+// first of its children takes over the others if they are leaves, else its
+// parent adopts them for the rest of the round. A crash of an interior node
+// then only costs its own signature instead of the ones of its subtree.
+//
+// ByzCoinX also pipelines the blocks: the prepare round of the next block
+// doesn't wait for the commit round of the current one, see Pipeline.
+
+// NewByzCoinXTree returns the tree of the ByzCoinX communication pattern. The
+// first member of the roster is the root, and the other members are split, in
+// order, into groups of groupSize members. The first member of a group is its
+// leader and the parent of the other members.
+func NewByzCoinXTree(roster *onet.Roster, groupSize int) *onet.Tree {
+	if groupSize < 1 {
+		groupSize = 1
+	}
+	root := onet.NewTreeNode(0, roster.List[0])
+	var leader *onet.TreeNode
+	for i := 1; i < len(roster.List); i++ {
+		tn := onet.NewTreeNode(i, roster.List[i])
+		if (i-1)%groupSize == 0 {
+			leader = tn
+			root.AddChild(leader)
+		} else {
+			leader.AddChild(tn)
+		}
+	}
+	return onet.NewTree(roster, root)
+}
+
+// SetSubLeaderTimeout makes the root re-assign the group of a leader that
+// didn't send its commitments after timeOutMs milliseconds, and the nodes
+// with children re-parent the subtrees of their failed children after half
+// the timeout of their parent. It has to be called before Start.
+func (bz *ByzCoin) SetSubLeaderTimeout(timeOutMs uint64) {
+	bz.subLeaderTimeout = timeOutMs
+}
+
+// SimulateFailures makes the nodes at the given roster indices crash as soon
+// as they get the announcement of the root. It has to be called before Start.
+func (bz *ByzCoin) SimulateFailures(indices []int32) {
+	bz.failing = indices
+}
+
+// Failovers returns how many groups the root re-assigned to a new leader.
+// Every node records how many nodes it re-parented as "reparented".
+func (bz *ByzCoin) Failovers() int {
+	return bz.failovers
+}
+
+// RegisterOnPrepareDone registers a callback the root calls at the end of
+// the prepare round, with the block if the signature of the round holds for
+// it, nil otherwise.
+func (bz *ByzCoin) RegisterOnPrepareDone(fn func(*blockchain.TrBlock)) {
+	bz.onPrepareDone = fn
+}
+
+// Pipeline makes the block of the root extend the block of another
+// instance, whose prepare round is signed but whose commit round may still
+// run, so that both rounds overlap. The commit round of this instance waits
+// until the other one is done, which closes done, so that the blocks are
+// committed in order. It has to be called before Start.
+func (bz *ByzCoin) Pipeline(parent *blockchain.TrBlock, done <-chan struct{}) {
+	bz.lastBlock = parent.HeaderHash
+	bz.previous = done
+}
+
+// Finished returns a channel closed when the instance is done.
+func (bz *ByzCoin) Finished() <-chan struct{} {
+	return bz.finished
+}
+
+// preparedBlock returns the block of the root if the signature of the
+// prepare round holds for it, with the exceptions the commit round sends.
+func (bz *ByzCoin) preparedBlock() *blockchain.TrBlock {
+	exceptions := append(append([]cosi.Exception{}, bz.failedExceptions...),
+		bz.prepareExceptions...)
+	if err := bz.policy.VerifyWithExceptions(bz.proposal.Hash(), bz.prepare.Signature(),
+		exceptions); err != nil {
+		log.Lvl2(bz.Name(), "Prepare round failed:", err)
+		return nil
+	}
+	return bz.tempBlock
+}
+
+// startFailoverTimer notifies us when the failover timeout expires, unless
+// the instance ends before.
+func (bz *ByzCoin) startFailoverTimer() {
+	select {
+	case <-bz.finished:
+	case <-bz.closed:
+	case <-time.After(time.Millisecond * time.Duration(bz.subLeaderTimeout)):
+		bz.failoverChan <- true
+	}
+}
+
+// handleFailover is called when the failover timeout expires. Every child
+// that didn't send its commitments for both rounds is added to the
+// exceptions, and the nodes under it are re-parented for the rest of the
+// round, see reparent.
+func (bz *ByzCoin) handleFailover() error {
+	if bz.challengeStarted || len(bz.sentCommitments) == 2 ||
+		bz.prepareAnnounce == nil || bz.commitAnnounce == nil {
+		return nil
+	}
+	bz.tpcMut.Lock()
+	bz.tccMut.Lock()
+	_, prepareSent := bz.sentCommitments[RoundPrepare]
+	_, commitSent := bz.sentCommitments[RoundCommit]
+	var failed []int
+	for i, tn := range bz.children {
+		_, prepared := bz.tempPrepareCommit[tn.ID]
+		_, committed := bz.tempCommitCommit[tn.ID]
+		if prepared && committed {
+			continue
+		}
+		if prepared && prepareSent || committed && commitSent {
+			// its commitment is part of the one we sent up already
+			log.Lvl2(bz.Name(), "child", tn.Name(), "is too late to fail over")
+			continue
+		}
+		failed = append(failed, i)
+		// the commitment of a single round is of no use
+		delete(bz.tempPrepareCommit, tn.ID)
+		delete(bz.tempCommitCommit, tn.ID)
+	}
+	bz.tccMut.Unlock()
+	bz.tpcMut.Unlock()
+	if len(failed) == 0 {
+		return nil
+	}
+	measure := monitor.NewTimeMeasure("subleader_failover")
+	defer measure.Record()
+
+	var err error
+	var children []*onet.TreeNode
+	reparented := 0
+	for i, tn := range bz.children {
+		if len(failed) == 0 || failed[0] != i {
+			children = append(children, tn)
+			continue
+		}
+		failed = failed[1:]
+		log.Lvl2(bz.Name(), "child", tn.Name(), "failed")
+		bz.failedExceptions = append(bz.failedExceptions, cosi.Exception{
+			Public:     tn.ServerIdentity.Public,
+			Commitment: bz.suite.Point().Null(),
+		})
+		next, orphans := bz.reparent(tn)
+		if next != nil {
+			children = append(children, next)
+			bz.leaders[next.ID] = true
+			bz.failovers++
+			if e := bz.SendTo(next, &Reassign{
+				Prepare: *bz.prepareAnnounce,
+				Commit:  *bz.commitAnnounce,
+			}); e != nil {
+				err = e
+			}
+		}
+		for _, o := range orphans {
+			children = append(children, o)
+			if e := bz.SendTo(o, &Reassign{
+				Prepare: *bz.prepareAnnounce,
+				Commit:  *bz.commitAnnounce,
+				Adopt:   true,
+			}); e != nil {
+				err = e
+			}
+		}
+		reparented += len(orphans)
+		if next != nil {
+			reparented++
+		}
+	}
+	bz.children = children
+	if reparented > 0 {
+		monitor.RecordSingleMeasure("reparented", float64(reparented))
+		go bz.startFailoverTimer()
+	}
+	if e := bz.committed(RoundPrepare); e != nil {
+		return e
+	}
+	if e := bz.committed(RoundCommit); e != nil {
+		return e
+	}
+	return err
+}
+
+// reparent returns who takes over the nodes under the failed child tn for
+// the rest of the round. If its children are leaves, as the members of a
+// group of ByzCoinX, the first one becomes their leader. If it leads
+// subtrees of its own, we adopt its children. If tn took over its group
+// itself, the next member of the group takes over from it.
+func (bz *ByzCoin) reparent(tn *onet.TreeNode) (*onet.TreeNode, []*onet.TreeNode) {
+	if bz.leaders[tn.ID] {
+		group := tn.Parent.Children
+		for i := range group {
+			if group[i].ID.Equal(tn.ID) && i+1 < len(group) {
+				return group[i+1], nil
+			}
+		}
+		return nil, nil
+	}
+	if len(tn.Children) == 0 {
+		return nil, nil
+	}
+	for _, c := range tn.Children {
+		if !c.IsLeaf() {
+			return nil, tn.Children
+		}
+	}
+	return tn.Children[0], nil
+}
+
+// handleReassign makes this node the leader of the members after it in its
+// group, and announces both rounds to them. If the sender adopts us
+// instead, see handleAdoption, we keep our children.
+func (bz *ByzCoin) handleReassign(from *onet.TreeNode, r *Reassign) error {
+	if r.Adopt {
+		return bz.handleAdoption(from, r)
+	}
+	log.Lvl2(bz.Name(), "takes over its group")
+	bz.children = nil
+	group := bz.TreeNode().Parent.Children
+	for i := range group {
+		if group[i].ID.Equal(bz.TreeNode().ID) {
+			bz.children = group[i+1:]
+		}
+	}
+	// our commitments as a member are of no use anymore
+	bz.sentCommitments = make(map[RoundType]*Commitment)
+	bz.announced = make(map[RoundType]bool)
+	r.Commit.Failover = true
+	r.Prepare.Failover = true
+	if err := bz.handleAnnouncement(from, r.Commit); err != nil {
+		return err
+	}
+	return bz.handleAnnouncement(from, r.Prepare)
+}
+
+// handleAdoption makes from our parent for the rest of the round, as our
+// parent failed. We send it the commitments we sent to our parent, the
+// others go to it once our children sent theirs. If our parent failed
+// before it announced a round to us, we take the announcement of from.
+func (bz *ByzCoin) handleAdoption(from *onet.TreeNode, r *Reassign) error {
+	log.Lvl2(bz.Name(), "is adopted by", from.Name())
+	bz.parent = from
+	var err error
+	for _, ann := range []Announce{r.Commit, r.Prepare} {
+		if sent, ok := bz.sentCommitments[ann.TYPE]; ok {
+			err = bz.SendTo(bz.parent, sent)
+		} else if !bz.announced[ann.TYPE] {
+			err = bz.handleAnnouncement(from, ann)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isLeaf returns true if we currently don't have any children.
+func (bz *ByzCoin) isLeaf() bool {
+	return len(bz.children) == 0
+}
+
+// isChild returns true if tn is currently one of our children.
+func (bz *ByzCoin) isChild(tn *onet.TreeNode) bool {
+	for _, c := range bz.children {
+		if c.ID.Equal(tn.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+// commitments returns the commitments of the children.
+func commitments(m map[onet.TreeNodeID]*cosi.Commitment) []*cosi.Commitment {
+	var list []*cosi.Commitment
+	for _, c := range m {
+		list = append(list, c)
+	}
+	return list
+}
+
+// responses returns the responses of the children.
+func responses(m map[onet.TreeNodeID]*Response) []*cosi.Response {
+	var list []*cosi.Response
+	for _, r := range m {
+		if r.Response != nil {
+			list = append(list, r.Response)
+		}
+	}
+	return list
+}
+
+// exceptions returns the exceptions of the children and their subtrees.
+func exceptions(m map[onet.TreeNodeID]*Response) []cosi.Exception {
+	var list []cosi.Exception
+	for _, r := range m {
+		list = append(list, r.Exceptions...)
+	}
+	return list
+}
@@ -0,0 +1,115 @@
+package byzcoin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+)
+
+func TestPipeline(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, _, tree := local.GenBigTree(7, 7, 3, true)
+	chain := blockchain.NewChainValidator("", -1)
+	rounds := 4
+	sigs := make(chan *BlockSignature, rounds)
+	var dones []chan bool
+	var prev *ByzCoin
+	var prevBlock *blockchain.TrBlock
+	for r := 0; r < rounds; r++ {
+		pi, err := local.CreateProtocol("ByzCoin", tree)
+		require.Nil(t, err)
+		bz := pi.(*ByzCoin)
+		bz.transactions = testTxs(10*r, 10)
+		bz.SetChain(chain)
+		if prev != nil {
+			bz.Pipeline(prevBlock, prev.Finished())
+		}
+		prepared := make(chan *blockchain.TrBlock, 1)
+		bz.RegisterOnPrepareDone(func(b *blockchain.TrBlock) { prepared <- b })
+		done := make(chan bool, 1)
+		bz.RegisterOnDone(func() { done <- true })
+		bz.RegisterOnSignatureDone(func(s *BlockSignature) { sigs <- s })
+		require.Nil(t, bz.Start())
+		// the next instance starts once the prepare round of this one is done
+		select {
+		case b := <-prepared:
+			require.NotNil(t, b)
+			if prevBlock != nil {
+				assert.Equal(t, prevBlock.HeaderHash, b.Header.Parent)
+			}
+			prevBlock = b
+		case <-time.After(5 * time.Second):
+			t.Fatal("prepare round didn't finish")
+		}
+		prev = bz
+		dones = append(dones, done)
+	}
+	for _, done := range dones {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("round didn't finish")
+		}
+	}
+
+	// the blocks are committed in order
+	parent := ""
+	for r := 0; r < rounds; r++ {
+		sig := <-sigs
+		assert.Nil(t, verifyBlockSignature(prev.Suite(), tree.Roster.Publics(), sig))
+		assert.Equal(t, parent, sig.Block.Header.Parent)
+		parent = sig.Block.HeaderHash
+	}
+	hash, height := chain.Tip()
+	assert.Equal(t, parent, hash)
+	assert.Equal(t, rounds-1, height)
+}
+
+// interior returns the roster indices of the first count nodes with children
+// at the depth of the tree.
+func interior(tree *onet.Tree, depth, count int) []int32 {
+	var failing []int32
+	tree.Root.Visit(0, func(d int, tn *onet.TreeNode) {
+		if d == depth && !tn.IsLeaf() && len(failing) < count {
+			failing = append(failing, int32(tn.RosterIndex))
+		}
+	})
+	return failing
+}
+
+func TestReparent(t *testing.T) {
+	for _, c := range []struct {
+		nodes, bf, groupSize, depth, failing, failovers int
+	}{
+		// the root adopts the children of a failed child
+		{15, 2, 0, 1, 1, 0},
+		// the first child of a failed node takes over its leaves
+		{15, 2, 0, 2, 2, 0},
+		// the first member of a group takes over from its leader
+		{10, 2, 3, 1, 2, 2},
+	} {
+		local := onet.NewLocalTest()
+		_, roster, tree := local.GenBigTree(c.nodes, c.nodes, c.bf, true)
+		if c.groupSize > 0 {
+			tree = NewByzCoinXTree(roster, c.groupSize)
+			local.Overlays[roster.List[0].ID].RegisterTree(tree)
+		}
+		failing := interior(tree, c.depth, c.failing)
+		require.Equal(t, c.failing, len(failing))
+		bz, sig := runRound(t, local, tree, testTxs(0, 3), func(bz *ByzCoin) {
+			bz.SetSubLeaderTimeout(400)
+			bz.SimulateFailures(failing)
+		})
+		// only the failed nodes are missing from the signature
+		require.NotNil(t, sig)
+		assert.Nil(t, verifyBlockSignature(bz.Suite(), bz.Roster().Publics(), sig))
+		assert.Equal(t, c.nodes-c.failing, sig.Sig.Mask.Count())
+		assert.Equal(t, c.failovers, bz.Failovers())
+		local.CloseAll()
+	}
+}
@@ -2,9 +2,19 @@ package byzcoin
 
 import (
 	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+	"gopkg.in/dedis/onet.v1/simul/monitor"
 )
 
 var magicNum = [4]byte{0xF9, 0xBE, 0xB4, 0xD9}
@@ -13,12 +23,46 @@ var magicNum = [4]byte{0xF9, 0xBE, 0xB4, 0xD9}
 // (so you only have to copy the first blocks to deterLab)
 const ReadFirstNBlocks = 66000
 
-// Client is a client simulation. At the moment we do not measure the
-// communication between client and server. Hence, we do not even open a real
-// network connection
+// streamBuffer is how many parsed transactions the client holds in memory
+// ahead of sending them.
+const streamBuffer = 1000
+
+// sendRetry is how long a client keeps sending again the transactions the
+// admission control of the servers rejected.
+const sendRetry = time.Minute
+
+// Client is a client simulation. The clients of NewClient and
+// NewShardedClient hold the servers and skip the network, the one of
+// NewNetClient sends the transactions over a real network connection and
+// measures the latencies it observes.
 type Client struct {
 	// holds the sever as a struct
 	srv BlockServer
+	// router splits the transactions among the shards, nil if there is only
+	// one shard
+	router *Router
+	// index gets the transactions of the parsed blocks, if not nil
+	index *blockchain.TxIndex
+	// stream are the transactions of the blocks StartClientSimulation has
+	// not sent yet, until Close closes streamStop
+	stream     <-chan blkparser.Tx
+	streamErrs <-chan error
+	streamStop chan struct{}
+	// net sends the transactions to the node si of the roster, if not nil
+	net    *onet.Client
+	roster *onet.Roster
+	si     *network.ServerIdentity
+	// id identifies the client for the rate limits of the leader
+	id string
+	// submitted are the hashes of the transactions sent since the last
+	// WaitConfirmations, the first of them at the start of confirm
+	mutex     sync.Mutex
+	submitted []string
+	confirm   *monitor.TimeMeasure
+	// fees draws with feeRand the fees of the transactions, see UseFees
+	feeMutex sync.Mutex
+	fees     FeeDistribution
+	feeRand  *rand.Rand
 }
 
 // NewClient returns a fresh new client out of a blockserver
@@ -26,43 +70,341 @@ func NewClient(s BlockServer) *Client {
 	return &Client{srv: s}
 }
 
+// NewShardedClient returns a client sending the transactions to the servers
+// of the shards, one server per shard.
+func NewShardedClient(servers []BlockServer) *Client {
+	return &Client{router: NewRouter(servers)}
+}
+
+// NewNetClient returns a client sending the transactions over the network
+// to the node si of the roster, which passes them on to the leader of the
+// roster, the first node. It measures the latencies it observes as
+// "client_submit", until the transactions it sent are in the pool of the
+// leader, and "client_confirm", from the first transaction it sent until
+// they are all in signed blocks, see WaitConfirmations.
+func NewNetClient(roster *onet.Roster, si *network.ServerIdentity) *Client {
+	return &Client{net: onet.NewClient(TxServiceName), roster: roster, si: si,
+		id: strconv.FormatUint(rand.Uint64(), 16)}
+}
+
+// UseIndex makes the client add the transactions of the blocks it parses to
+// the index, so they can be looked up later without parsing the blocks.
+func (c *Client) UseIndex(index *blockchain.TxIndex) {
+	c.index = index
+}
+
 // StartClientSimulation can be called from outside (from an simulation
 // implementation) to simulate a client. Parameters:
 // blocksDir is the directory where to find the transaction blocks (.dat files)
 // numTxs is the number of transactions the client will create
+// Every call sends the transactions following the ones of the previous
+// call, so that the servers don't reject them as replayed.
 func (c *Client) StartClientSimulation(blocksDir string, numTxs int) error {
 	return c.triggerTransactions(blocksDir, numTxs)
 }
 
+// Close stops the decoding of the blocks of StartClientSimulation.
+func (c *Client) Close() {
+	if c.streamStop != nil {
+		close(c.streamStop)
+		c.streamStop = nil
+	}
+}
+
 func (c *Client) triggerTransactions(blocksPath string, nTxs int) error {
 	log.Lvl2("ByzCoin Client will trigger up to", nTxs, "transactions")
+	if c.stream == nil {
+		stop := make(chan struct{})
+		transactions, errs, err := c.streamBlocks(blocksPath, stop)
+		if err != nil {
+			return err
+		}
+		c.stream, c.streamErrs, c.streamStop = transactions, errs, stop
+	}
+	consumed := 0
+	var batch []blkparser.Tx
+	for consumed < nTxs {
+		tr, ok := <-c.stream
+		if !ok {
+			break
+		}
+		batch = append(batch, tr)
+		if len(batch) == streamBuffer {
+			if err := c.send(blockchain.BitcoinTxs(batch)); err != nil {
+				return err
+			}
+			batch = nil
+		}
+		consumed++
+	}
+	if err := c.send(blockchain.BitcoinTxs(batch)); err != nil {
+		return err
+	}
+	if consumed == 0 {
+		if err := <-c.streamErrs; err != nil {
+			log.Error("Error: Couldn't parse blocks in", blocksPath,
+				".\nPlease download bitcoin blocks as .dat files first and place them in",
+				blocksPath, "Either run a bitcoin node (recommended) or using a torrent.")
+			return err
+		}
+		return errors.New("Couldn't read any transactions.")
+	}
+	if consumed < nTxs {
+		log.Errorf("Read only %v but caller wanted %v", consumed, nTxs)
+	}
+	return nil
+}
+
+// StartLoad starts a load generator sending the transactions of the blocks
+// of blocksDir, see GenerateLoad.
+func (c *Client) StartLoad(blocksDir string, config LoadConfig) (*LoadGenerator, error) {
+	stop := make(chan struct{})
+	transactions, _, err := c.streamBlocks(blocksDir, stop)
+	if err != nil {
+		return nil, err
+	}
+	g, err := c.GenerateLoad(transactions, config)
+	if err != nil {
+		close(stop)
+		return nil, err
+	}
+	go func() {
+		<-g.done
+		close(stop)
+	}()
+	return g, nil
+}
+
+// streamBlocks decodes the blocks of blocksPath in the background as their
+// transactions are consumed, so only streamBuffer transactions are held in
+// memory, see blockchain.Parser.Stream.
+func (c *Client) streamBlocks(blocksPath string, stop <-chan struct{}) (<-chan blkparser.Tx, <-chan error, error) {
 	parser, err := blockchain.NewParser(blocksPath, magicNum)
+	if err != nil {
+		return nil, nil, err
+	}
+	parser.Workers = runtime.NumCPU()
+	parser.Index = c.index
+	transactions, errs := parser.Stream(0, ReadFirstNBlocks, streamBuffer, stop)
+	return transactions, errs, nil
+}
 
-	transactions, err := parser.Parse(0, ReadFirstNBlocks)
-	if len(transactions) == 0 {
-		return errors.New("Couldn't read any transactions.")
+// SubmitTransactions sends the transactions, of any format, to the servers.
+func (c *Client) SubmitTransactions(txs []blockchain.Transaction) error {
+	return c.send(txs)
+}
+
+// send gives the transactions to the servers, through the network for a
+// client of NewNetClient, with the fees of UseFees. The transactions
+// rejected by the admission control of the servers are sent again when they
+// say so.
+func (c *Client) send(txs []blockchain.Transaction) error {
+	if len(txs) == 0 {
+		return nil
+	}
+	paid := make([]blockchain.Transaction, len(txs))
+	for i, tx := range txs {
+		paid[i] = c.withFee(tx)
 	}
+	txs = paid
+	if c.net == nil {
+		return retry(len(txs), func(from int) (int, error) {
+			return c.addAll(txs[from:])
+		})
+	}
+	submit := monitor.NewTimeMeasure("client_submit")
+	if c.confirm == nil {
+		c.confirm = monitor.NewTimeMeasure("client_confirm")
+	}
+	err := retry(len(txs), func(from int) (int, error) {
+		return c.sendNet(c.net, txs[from:])
+	})
 	if err != nil {
-		log.Error("Error: Couldn't parse blocks in", blocksPath,
-			".\nPlease download bitcoin blocks as .dat files first and place them in",
-			blocksPath, "Either run a bitcoin node (recommended) or using a torrent.")
 		return err
 	}
-	if len(transactions) < nTxs {
-		log.Errorf("Read only %v but caller wanted %v", len(transactions), nTxs)
+	submit.Record()
+	return nil
+}
+
+// sendNet sends the transactions to the leader with the onet client, and
+// returns how many of them it accepted. The accepted ones are waited for by
+// WaitConfirmations.
+func (c *Client) sendNet(client *onet.Client, txs []blockchain.Transaction) (int, error) {
+	req := &SubmitTransactions{Roster: c.roster, Client: c.id}
+	for _, tx := range txs {
+		req.Txs = append(req.Txs, blockchain.ToTx(tx))
+		req.Fees = append(req.Fees, blockchain.Fee(tx))
+	}
+	reply := &SubmitTransactionsReply{}
+	if cerr := client.SendProtobuf(c.si, req, reply); cerr != nil {
+		return 0, cerr
 	}
-	consumed := 0
-	if len(transactions) < nTxs {
-		consumed = len(transactions)
-	} else {
-		consumed = nTxs
-	}
-	for consumed > 0 {
-		for _, tr := range transactions {
-			// "send" transaction to server (we skip tcp connection on purpose here)
-			c.srv.AddTransaction(tr)
+	c.mutex.Lock()
+	for _, tr := range txs[:reply.Accepted] {
+		c.submitted = append(c.submitted, tr.Hash())
+	}
+	c.mutex.Unlock()
+	if reply.Accepted < len(txs) {
+		return reply.Accepted, &BackpressureError{
+			Reason:     "rejected by the leader",
+			RetryAfter: time.Duration(reply.RetryAfterMs) * time.Millisecond,
+		}
+	}
+	return reply.Accepted, nil
+}
+
+// addAll gives the transactions to the servers of a client without network
+// until one is rejected by their admission control, and returns how many
+// have been given. The invalid transactions are dropped by the servers.
+func (c *Client) addAll(txs []blockchain.Transaction) (int, error) {
+	for i, tx := range txs {
+		var err error
+		if c.router != nil {
+			err = c.router.Route(blockchain.ToTx(tx), blockchain.Fee(tx))
+		} else {
+			err = c.srv.AddTransaction(tx)
+		}
+		if backpressure(err) != nil {
+			return i, err
+		}
+	}
+	return len(txs), nil
+}
+
+// retry calls submit with the index of the first of the n transactions not
+// accepted yet until they all are, waiting as long as the backpressure
+// errors say, for at most sendRetry.
+func retry(n int, submit func(from int) (int, error)) error {
+	deadline := time.Now().Add(sendRetry)
+	for from := 0; from < n; {
+		accepted, err := submit(from)
+		from += accepted
+		bp := backpressure(err)
+		if bp == nil {
+			return err
+		}
+		if time.Now().Add(bp.RetryAfter).After(deadline) {
+			return err
 		}
-		consumed--
+		log.Lvl3("Sending again after", bp)
+		time.Sleep(bp.RetryAfter)
 	}
 	return nil
 }
+
+// WaitConfirmations waits until the transactions sent by a client of
+// NewNetClient since the last call are in signed blocks, or until the
+// timeout, and records the "client_confirm" latency once they are.
+func (c *Client) WaitConfirmations(timeout time.Duration) error {
+	if c.net == nil {
+		return errors.New("only a network client waits for confirmations")
+	}
+	if len(c.submitted) == 0 {
+		return nil
+	}
+	req := &WaitConfirmations{Roster: c.roster, Hashes: c.submitted,
+		TimeoutMs: uint64(timeout / time.Millisecond)}
+	reply := &WaitConfirmationsReply{}
+	if cerr := c.net.SendProtobuf(c.si, req, reply); cerr != nil {
+		return cerr
+	}
+	if reply.Confirmed < len(c.submitted) {
+		return fmt.Errorf("only %d out of %d transactions confirmed",
+			reply.Confirmed, len(c.submitted))
+	}
+	c.confirm.Record()
+	c.submitted, c.confirm = nil, nil
+	return nil
+}
+
+// NativeTransactions returns n transactions of the native format: the first
+// one is a coinbase minting n-1 outputs, which the others spend one each.
+func NativeTransactions(n int) []blockchain.Transaction {
+	return NativeBatch(n, 0)
+}
+
+// NativeBatch returns the batch-th of distinct sets of NativeTransactions,
+// the mint of a batch other than 0 having an output of value batch that
+// nobody spends, so that the batches don't replay each other.
+func NativeBatch(n int, batch uint64) []blockchain.Transaction {
+	if n <= 0 {
+		return nil
+	}
+	// the mint needs an output even if nobody spends it
+	outputs := n - 1
+	if outputs == 0 {
+		outputs = 1
+	}
+	mint := &blockchain.NativeTx{Coinbase: true, Outputs: make([]uint64, outputs)}
+	for i := range mint.Outputs {
+		mint.Outputs[i] = uint64(i + 1)
+	}
+	if batch > 0 {
+		mint.Outputs = append(mint.Outputs, batch)
+	}
+	hash := mint.Hash()
+	txs := []blockchain.Transaction{mint}
+	for i := 0; i < n-1; i++ {
+		txs = append(txs, &blockchain.NativeTx{
+			Inputs:  []blockchain.Outpoint{{Hash: hash, Index: uint32(i)}},
+			Outputs: []uint64{mint.Outputs[i]},
+		})
+	}
+	return txs
+}
+
+// SubRequest is the part of a cross-shard transaction sent to one shard.
+type SubRequest struct {
+	Shard int
+	Tx    blkparser.Tx
+}
+
+// Router sends the transactions to the shards holding their unspent outputs.
+type Router struct {
+	// servers holds the server of every shard
+	servers []BlockServer
+}
+
+// NewRouter returns a router for the shards of the servers.
+func NewRouter(servers []BlockServer) *Router {
+	return &Router{servers: servers}
+}
+
+// Route sends a transaction touching only one shard as it is to the server
+// of that shard, offering the fee. A cross-shard transaction is split into
+// one sub-request per shard, each offering the fee. It returns the first
+// BackpressureError of the servers, else their first error.
+func (r *Router) Route(tx blkparser.Tx, fee uint64) error {
+	st := blockchain.NewShardedTx(tx, len(r.servers))
+	if !st.CrossShard() {
+		shard := 0
+		if shards := st.Shards(); len(shards) == 1 {
+			shard = shards[0]
+		}
+		return r.servers[shard].AddTransaction(
+			blockchain.WithFee(blockchain.NewBitcoinTx(tx), fee))
+	}
+	var first error
+	for _, sub := range r.Split(st) {
+		err := r.servers[sub.Shard].AddTransaction(
+			blockchain.WithFee(blockchain.NewBitcoinTx(sub.Tx), fee))
+		if first == nil || (backpressure(first) == nil && backpressure(err) != nil) {
+			first = err
+		}
+	}
+	return first
+}
+
+// Split returns the sub-requests of the transaction, one for every shard it
+// touches.
+func (r *Router) Split(st *blockchain.ShardedTx) []SubRequest {
+	var subs []SubRequest
+	for _, shard := range st.Shards() {
+		subs = append(subs, SubRequest{
+			Shard: shard,
+			Tx:    st.Part(shard, len(r.servers)),
+		})
+	}
+	log.Lvl4("Split transaction", st.Hash, "into", len(subs), "sub-requests")
+	return subs
+}
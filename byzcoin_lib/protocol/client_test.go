@@ -0,0 +1,101 @@
+package byzcoin
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+)
+
+// blockTxs returns the transactions in the format of the blocks.
+func blockTxs(txs []blockchain.Transaction) []blkparser.Tx {
+	ret := make([]blkparser.Tx, len(txs))
+	for i, tx := range txs {
+		ret[i] = blockchain.ToTx(tx)
+	}
+	return ret
+}
+
+func TestNetClient(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	servers, roster, _ := local.GenTree(3, true)
+	txs := NativeTransactions(3)
+	// the client sends to a node that isn't the leader
+	c := NewNetClient(roster, roster.List[1])
+	err := c.SubmitTransactions(txs)
+	require.NotNil(t, err, "the leader doesn't take transactions")
+	assert.Equal(t, ErrorNoServer, err.(onet.ClientError).ErrorCode())
+
+	s := NewByzCoinServer(2, 0, 0)
+	s.ListenClientTransactions(servers[0])
+	require.Nil(t, c.SubmitTransactions(txs))
+	assert.Equal(t, 3, s.Mempool().Len())
+	assert.NotNil(t, c.WaitConfirmations(50*time.Millisecond))
+
+	// the transactions are confirmed once they are in a signed block
+	var block []string
+	for _, tx := range txs[:2] {
+		block = append(block, tx.Hash())
+	}
+	s.Confirm(newBlock(blockTxs(txs[:2]), "", ""))
+	assert.Equal(t, 2, s.WaitConfirmed(block, 0))
+	assert.NotNil(t, c.WaitConfirmations(50*time.Millisecond))
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.Confirm(newBlock(blockTxs(txs[2:]), "", ""))
+	}()
+	require.Nil(t, c.WaitConfirmations(time.Second))
+	// nothing left to wait for
+	assert.Nil(t, c.WaitConfirmations(0))
+
+	assert.NotNil(t, NewClient(s).WaitConfirmations(0))
+	_, cerr := servers[0].Service(TxServiceName).(*TxService).SubmitTransactions(
+		&SubmitTransactions{})
+	require.NotNil(t, cerr)
+	assert.Equal(t, ErrorParameterWrong, cerr.ErrorCode())
+}
+
+func TestRouter(t *testing.T) {
+	servers := []*testServer{{}, {}, {}}
+	r := NewRouter([]BlockServer{servers[0], servers[1], servers[2]})
+	// a transaction spending outputs of all the shards
+	tx := blkparser.Tx{Hash: "tx", TxOuts: []*blkparser.TxOut{{Value: 1}}}
+	for i := 0; i < 8; i++ {
+		tx.TxIns = append(tx.TxIns, &blkparser.TxIn{InputHash: fmt.Sprint(i)})
+	}
+	st := blockchain.NewShardedTx(tx, 3)
+	require.Equal(t, []int{0, 1, 2}, st.Shards())
+	subs := r.Split(st)
+	require.Equal(t, len(st.Shards()), len(subs))
+
+	// every shard gets its part, with the fee
+	require.Nil(t, r.Route(tx, 5))
+	for _, sub := range subs {
+		s := servers[sub.Shard]
+		require.Equal(t, 1, len(s.txs))
+		assert.Equal(t, sub.Tx, blockchain.ToTx(s.txs[0]))
+		assert.Equal(t, uint64(5), blockchain.Fee(s.txs[0]))
+	}
+
+	// a transaction of a single shard is sent as it is
+	single := blkparser.Tx{Hash: "single", TxIns: tx.TxIns[:1]}
+	shard := blockchain.ShardOf(blockchain.UTXOID(tx.TxIns[0].InputHash, 0), 3)
+	require.Nil(t, r.Route(single, 0))
+	s := servers[shard]
+	assert.Equal(t, single, blockchain.ToTx(s.txs[len(s.txs)-1]))
+
+	// the backpressure of a shard is returned first
+	servers[0].err = errors.New("rejected")
+	servers[2].err = &BackpressureError{Reason: "full"}
+	err := r.Route(tx, 0)
+	require.NotNil(t, backpressure(err))
+	servers[2].err = nil
+	assert.Equal(t, servers[0].err, r.Route(tx, 0))
+}
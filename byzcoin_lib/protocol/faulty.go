@@ -0,0 +1,113 @@
+package byzcoin
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/dedis/paper_17_sosp_omniledger/cosi"
+	"gopkg.in/dedis/onet.v1"
+)
+
+// The byzantine behaviours a faulty node can show, see UseFaulty.
+const (
+	// FaultWithhold takes part in both rounds but never signs: it sends an
+	// exception instead of its response, as a node refusing the block
+	// would. The signature holds as long as the policy allows for the
+	// exceptions.
+	FaultWithhold = "withhold"
+	// FaultEquivocate signs another block than the one of the root in both
+	// rounds: its response is for another challenge, so the collective
+	// signature doesn't verify and the honest nodes refuse to sign the
+	// commit round.
+	FaultEquivocate = "equivocate"
+)
+
+// faulty are the byzantine nodes of the simulation, see UseFaulty.
+var faulty = struct {
+	sync.Mutex
+	hosts int
+	kind  string
+}{}
+
+// UseFaulty makes the last hosts nodes of the roster show the byzantine
+// behaviour kind, FaultWithhold or FaultEquivocate, none if hosts is 0. The
+// root is never faulty. The simulations call it on every node.
+func UseFaulty(hosts int, kind string) error {
+	if hosts < 0 {
+		return errors.New("negative number of faulty hosts")
+	}
+	switch kind {
+	case FaultWithhold, FaultEquivocate:
+	case "":
+		if hosts > 0 {
+			return errors.New("faulty hosts need a fault type")
+		}
+	default:
+		return errors.New("unknown fault type " + kind)
+	}
+	faulty.Lock()
+	defer faulty.Unlock()
+	faulty.hosts, faulty.kind = hosts, kind
+	return nil
+}
+
+// fault returns the byzantine behaviour of this node, or "" if it is honest.
+func (bz *ByzCoin) fault() string {
+	faulty.Lock()
+	defer faulty.Unlock()
+	if bz.IsRoot() || bz.TreeNode().RosterIndex < len(bz.Roster().List)-faulty.hosts {
+		return ""
+	}
+	return faulty.kind
+}
+
+// respond returns the response of the round to send up, made of the
+// responses of the children and of ours, with the exceptions of the
+// children and of the ones that failed. If we refuse to sign, our response is left out and we add our
+// exception, so that the signature of the others still verifies.
+func (bz *ByzCoin) respond(round RoundType, c *cosi.Cosi,
+	children map[onet.TreeNodeID]*Response, refuse bool) (*Response, error) {
+	switch bz.fault() {
+	case FaultWithhold:
+		refuse = true
+	case FaultEquivocate:
+		c.Challenge(bz.conflictingChallenge(round))
+	}
+	resp, err := c.Response(responses(children))
+	if err != nil {
+		return nil, err
+	}
+	bzr := &Response{
+		Response:   resp,
+		Exceptions: exceptions(children),
+		TYPE:       round,
+	}
+	if !bz.IsRoot() {
+		// the root sends its own with the challenge of the commit round
+		bzr.Exceptions = append(bzr.Exceptions, bz.failedExceptions...)
+	}
+	if round == RoundPrepare && bz.reportTxs {
+		bzr.Invalid = bz.reportedTxs(children)
+	}
+	if refuse {
+		resp.Response = bz.suite.Scalar().Zero()
+		bzr.Exceptions = append(bzr.Exceptions, cosi.Exception{
+			Public:     bz.Public(),
+			Commitment: c.GetCommitment(),
+		})
+	}
+	return bzr, nil
+}
+
+// conflictingChallenge returns the challenge of a conflicting block signed by
+// an equivocating node.
+func (bz *ByzCoin) conflictingChallenge(round RoundType) *cosi.Challenge {
+	msg := []byte("equivocate")
+	if bz.tempBlock != nil && bz.tempBlock.Header != nil {
+		msg = append(msg, bz.tempBlock.Header.HashSum()...)
+	}
+	msg = append(msg, byte(round))
+	return &cosi.Challenge{
+		Challenge: bz.suite.Scalar().Pick(bz.suite.Cipher(msg)),
+	}
+}
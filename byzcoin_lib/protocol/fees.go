@@ -0,0 +1,73 @@
+package byzcoin
+
+import (
+	"errors"
+	"math/rand"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+)
+
+// FeeDistribution is how a client draws the fee per byte its transactions
+// offer, see Client.UseFees.
+type FeeDistribution struct {
+	// Kind is "constant" for Mean, "uniform" between 0 and twice Mean,
+	// "exponential" of mean Mean, or "bimodal" for a share HighShare of
+	// the transactions offering High and the others Mean. No fees if
+	// empty.
+	Kind      string
+	Mean      float64
+	High      float64
+	HighShare float64
+}
+
+// check returns an error if the kind of the distribution is unknown.
+func (d FeeDistribution) check() error {
+	switch d.Kind {
+	case "", "constant", "uniform", "exponential", "bimodal":
+		return nil
+	}
+	return errors.New("unknown fee distribution " + d.Kind)
+}
+
+// draw returns a fee per byte.
+func (d FeeDistribution) draw(rng *rand.Rand) float64 {
+	switch d.Kind {
+	case "constant":
+		return d.Mean
+	case "uniform":
+		return rng.Float64() * 2 * d.Mean
+	case "exponential":
+		return rng.ExpFloat64() * d.Mean
+	case "bimodal":
+		if rng.Float64() < d.HighShare {
+			return d.High
+		}
+		return d.Mean
+	}
+	return 0
+}
+
+// UseFees makes the client offer with every transaction that doesn't offer
+// one yet a fee per byte drawn from the distribution, with the seed.
+func (c *Client) UseFees(fees FeeDistribution, seed int64) error {
+	if err := fees.check(); err != nil {
+		return err
+	}
+	c.feeMutex.Lock()
+	defer c.feeMutex.Unlock()
+	c.fees = fees
+	c.feeRand = rand.New(rand.NewSource(seed))
+	return nil
+}
+
+// withFee returns the transaction offering a fee drawn from the
+// distribution of UseFees, unless it offers one already.
+func (c *Client) withFee(tx blockchain.Transaction) blockchain.Transaction {
+	c.feeMutex.Lock()
+	defer c.feeMutex.Unlock()
+	if c.feeRand == nil || blockchain.Fee(tx) > 0 {
+		return tx
+	}
+	fee := c.fees.draw(c.feeRand) * float64(blockchain.Size(tx))
+	return blockchain.WithFee(tx, uint64(fee+0.5))
+}
@@ -0,0 +1,78 @@
+package byzcoin
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeeDistribution(t *testing.T) {
+	assert.NotNil(t, FeeDistribution{Kind: "gaussian"}.check())
+	rng := rand.New(rand.NewSource(1))
+	assert.Equal(t, 0.0, FeeDistribution{Mean: 5}.draw(rng))
+	assert.Equal(t, 5.0, FeeDistribution{Kind: "constant", Mean: 5}.draw(rng))
+	n := 10000
+	var uniform, exponential float64
+	high := 0
+	for i := 0; i < n; i++ {
+		u := FeeDistribution{Kind: "uniform", Mean: 5}.draw(rng)
+		require.True(t, u >= 0 && u < 10)
+		uniform += u
+		exponential += FeeDistribution{Kind: "exponential", Mean: 5}.draw(rng)
+		b := FeeDistribution{Kind: "bimodal", Mean: 1, High: 10, HighShare: 0.2}.draw(rng)
+		require.True(t, b == 1 || b == 10)
+		if b == 10 {
+			high++
+		}
+	}
+	assert.InDelta(t, 5, uniform/float64(n), 0.2)
+	assert.InDelta(t, 5, exponential/float64(n), 0.3)
+	assert.InDelta(t, 0.2, float64(high)/float64(n), 0.02)
+}
+
+func TestClientFees(t *testing.T) {
+	srv := &testServer{}
+	c := NewClient(srv)
+	assert.NotNil(t, c.UseFees(FeeDistribution{Kind: "gaussian"}, 1))
+	require.Nil(t, c.UseFees(FeeDistribution{Kind: "constant", Mean: 2}, 1))
+	txs := NativeTransactions(3)
+	// a transaction offering a fee already keeps it
+	txs[2] = blockchain.WithFee(txs[2], 1)
+	require.Nil(t, c.SubmitTransactions(txs))
+	for i, tx := range srv.txs[:2] {
+		assert.Equal(t, uint64(2*blockchain.Size(txs[i])), blockchain.Fee(tx))
+		assert.Equal(t, txs[i].Hash(), tx.Hash())
+	}
+	assert.Equal(t, uint64(1), blockchain.Fee(srv.txs[2]))
+}
+
+func TestServerFees(t *testing.T) {
+	s := NewByzCoinServer(2, 0, 0)
+	txs := NativeBatch(4, 1)[1:]
+	for i, density := range []int{1, 3, 2} {
+		fee := uint64(density * blockchain.Size(txs[i]))
+		require.Nil(t, s.AddTransaction(blockchain.WithFee(txs[i], fee)))
+	}
+	// the block holds the transactions offering the most per byte
+	assert.Equal(t, []string{txs[1].Hash(), txs[2].Hash()}, waitBlock(s))
+}
+
+func TestLoadStatsByFee(t *testing.T) {
+	s := &LoadStats{}
+	for _, i := range rand.New(rand.NewSource(1)).Perm(8) {
+		s.samples = append(s.samples, loadSample{feeDensity: float64(i),
+			latency: time.Duration(8 - i)})
+	}
+	groups := s.ByFee(4)
+	require.Equal(t, 4, len(groups))
+	for i, g := range groups {
+		// the transactions offering more waited less
+		assert.Equal(t, []time.Duration{time.Duration(7 - 2*i), time.Duration(8 - 2*i)},
+			g.Latencies)
+		assert.Equal(t, 2, g.Sent)
+	}
+}
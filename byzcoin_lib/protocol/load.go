@@ -0,0 +1,354 @@
+package byzcoin
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// LoadMode is how a LoadGenerator paces the requests.
+type LoadMode int
+
+const (
+	// OpenLoop starts the requests at the target rate with Poisson
+	// arrivals, whether the previous ones completed or not, as independent
+	// users would.
+	OpenLoop LoadMode = iota
+	// ClosedLoop keeps a fixed number of requests in flight, every sender
+	// starting its next request once its previous one completed.
+	ClosedLoop
+)
+
+// LoadConfig configures a LoadGenerator. A request submits one transaction.
+type LoadConfig struct {
+	Mode LoadMode
+	// TPS is the rate of the requests of the open loop
+	TPS float64
+	// Concurrency is the number of senders of the closed loop
+	Concurrency int
+	// Warmup is how long the requests run before they are measured
+	Warmup time.Duration
+	// Duration is how long the requests are measured
+	Duration time.Duration
+	// Confirm makes a request of a network client complete once its
+	// transaction is in a signed block, instead of in the pool of the
+	// leader.
+	Confirm bool
+	// Seed seeds the arrivals of the open loop
+	Seed int64
+}
+
+// LoadStats are the requests started during the measurement of a
+// LoadGenerator.
+type LoadStats struct {
+	// Sent is the number of requests started
+	Sent int
+	// Failed is the number of them that returned an error
+	Failed int
+	// Rejected is the number of them the servers rejected because of their
+	// rate limits or their full pool
+	Rejected int
+	// Latencies are the latencies of the ones that completed, sorted
+	Latencies []time.Duration
+	// Duration is how long the requests were measured
+	Duration time.Duration
+	// samples are the ones that completed with the fee per byte they
+	// offered, see ByFee
+	samples []loadSample
+}
+
+// loadSample is a completed request.
+type loadSample struct {
+	feeDensity float64
+	latency    time.Duration
+}
+
+// Throughput returns the requests completed per second.
+func (s *LoadStats) Throughput() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(len(s.Latencies)) / s.Duration.Seconds()
+}
+
+// Percentile returns the latency below which are p percent of the completed
+// requests, or 0 if none completed.
+func (s *LoadStats) Percentile(p float64) time.Duration {
+	if len(s.Latencies) == 0 {
+		return 0
+	}
+	i := int(p / 100 * float64(len(s.Latencies)))
+	if i >= len(s.Latencies) {
+		i = len(s.Latencies) - 1
+	}
+	return s.Latencies[i]
+}
+
+// ByFee splits the completed requests into n groups of the same size, of
+// increasing fee per byte, and returns their statistics, e.g. to compare the
+// latencies of the transactions offering the lowest and the highest fees.
+func (s *LoadStats) ByFee(n int) []*LoadStats {
+	samples := append([]loadSample{}, s.samples...)
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].feeDensity < samples[j].feeDensity
+	})
+	groups := make([]*LoadStats, n)
+	for i := range groups {
+		part := samples[i*len(samples)/n : (i+1)*len(samples)/n]
+		g := &LoadStats{Sent: len(part), Duration: s.Duration, samples: part}
+		for _, sample := range part {
+			g.Latencies = append(g.Latencies, sample.latency)
+		}
+		sort.Slice(g.Latencies, func(i, j int) bool {
+			return g.Latencies[i] < g.Latencies[j]
+		})
+		groups[i] = g
+	}
+	return groups
+}
+
+// LoadGenerator sends transactions through a client, in an open or a closed
+// loop, following the usual benchmarking methodology: the requests of a
+// warm-up phase are not measured, then the ones started during the
+// measurement phase are, and the load keeps on until Stop so that the
+// system is not drained while the last measured requests complete.
+type LoadGenerator struct {
+	client *Client
+	config LoadConfig
+	txs    <-chan blkparser.Tx
+
+	start    time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+	// done is closed once the loop returned
+	done chan struct{}
+
+	mutex sync.Mutex
+	stats LoadStats
+	// over is set at the end of the measurement phase, and measured is
+	// closed once the measured requests in flight completed too
+	over     bool
+	inFlight int
+	measured chan struct{}
+}
+
+// GenerateLoad starts a load generator sending the transactions of txs with
+// the client, until Stop or until there are no transactions anymore.
+func (c *Client) GenerateLoad(txs <-chan blkparser.Tx, config LoadConfig) (*LoadGenerator, error) {
+	switch {
+	case config.Mode == OpenLoop && config.TPS <= 0:
+		return nil, errors.New("need a positive rate for the open loop")
+	case config.Mode == ClosedLoop && config.Concurrency <= 0:
+		return nil, errors.New("need senders for the closed loop")
+	case config.Duration <= 0:
+		return nil, errors.New("need a measurement phase")
+	}
+	g := &LoadGenerator{
+		client:   c,
+		config:   config,
+		txs:      txs,
+		start:    time.Now(),
+		stop:     make(chan struct{}),
+		measured: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	g.stats.Duration = config.Duration
+	time.AfterFunc(config.Warmup+config.Duration, func() {
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+		g.over = true
+		if g.inFlight == 0 {
+			close(g.measured)
+		}
+	})
+	go func() {
+		defer close(g.done)
+		if config.Mode == OpenLoop {
+			g.openLoop()
+		} else {
+			g.closedLoop()
+		}
+	}()
+	return g, nil
+}
+
+// Measured is closed once the measurement phase is over and its requests
+// completed.
+func (g *LoadGenerator) Measured() <-chan struct{} {
+	return g.measured
+}
+
+// Stop ends the load and returns the statistics of the measured requests,
+// waiting for the end of the measurement if need be. The requests started
+// afterwards are left to complete on their own.
+func (g *LoadGenerator) Stop() *LoadStats {
+	g.stopOnce.Do(func() { close(g.stop) })
+	<-g.done
+	<-g.measured
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	stats := g.stats
+	stats.Latencies = append([]time.Duration{}, g.stats.Latencies...)
+	stats.samples = append([]loadSample{}, g.stats.samples...)
+	sort.Slice(stats.Latencies, func(i, j int) bool {
+		return stats.Latencies[i] < stats.Latencies[j]
+	})
+	return &stats
+}
+
+// openLoop starts a request at every arrival, the time between two arrivals
+// following an exponential distribution of mean 1/TPS.
+func (g *LoadGenerator) openLoop() {
+	rng := rand.New(rand.NewSource(g.config.Seed))
+	next := g.start
+	for {
+		gap := rng.ExpFloat64() / g.config.TPS
+		next = next.Add(time.Duration(gap * float64(time.Second)))
+		select {
+		case <-time.After(time.Until(next)):
+		case <-g.stop:
+			return
+		}
+		tx, ok := g.nextTx()
+		if !ok {
+			return
+		}
+		go g.request(tx, next)
+	}
+}
+
+// closedLoop runs Concurrency senders one request after the other. A sender
+// whose request the servers rejected waits as long as they asked before
+// the next one.
+func (g *LoadGenerator) closedLoop() {
+	var senders sync.WaitGroup
+	for i := 0; i < g.config.Concurrency; i++ {
+		senders.Add(1)
+		go func() {
+			defer senders.Done()
+			for {
+				tx, ok := g.nextTx()
+				if !ok {
+					return
+				}
+				wait := g.request(tx, time.Now())
+				if wait > 0 {
+					select {
+					case <-time.After(wait):
+					case <-g.stop:
+						return
+					}
+				}
+			}
+		}()
+	}
+	senders.Wait()
+}
+
+// nextTx returns the next transaction, or false once stopped or out of
+// transactions.
+func (g *LoadGenerator) nextTx() (blkparser.Tx, bool) {
+	select {
+	case <-g.stop:
+		return blkparser.Tx{}, false
+	default:
+	}
+	select {
+	case tx, ok := <-g.txs:
+		if !ok {
+			log.Lvl2("Load generator ran out of transactions")
+		}
+		return tx, ok
+	case <-g.stop:
+		return blkparser.Tx{}, false
+	}
+}
+
+// request submits the transaction and measures it if it started during the
+// measurement phase. The latency counts from the time the request was
+// scheduled, so that an open loop falling behind doesn't hide the delay. It
+// returns how long the servers asked to wait if they rejected the request.
+func (g *LoadGenerator) request(tx blkparser.Tx, started time.Time) time.Duration {
+	paid := g.client.withFee(blockchain.NewBitcoinTx(tx))
+	g.mutex.Lock()
+	measured := !g.over && started.Sub(g.start) >= g.config.Warmup
+	if measured {
+		g.stats.Sent++
+		g.inFlight++
+	}
+	g.mutex.Unlock()
+
+	err := g.client.submitOne(paid, g.config.Confirm)
+	latency := time.Since(started)
+	bp := backpressure(err)
+	if err != nil && bp == nil {
+		log.Lvl2("Load generator request failed:", err)
+	}
+	var wait time.Duration
+	if bp != nil {
+		wait = bp.RetryAfter
+	}
+	if !measured {
+		return wait
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if bp != nil {
+		g.stats.Rejected++
+	} else if err != nil {
+		g.stats.Failed++
+	} else {
+		g.stats.Latencies = append(g.stats.Latencies, latency)
+		g.stats.samples = append(g.stats.samples, loadSample{
+			feeDensity: blockchain.FeeDensity(paid), latency: latency})
+	}
+	g.inFlight--
+	if g.over && g.inFlight == 0 {
+		close(g.measured)
+	}
+	return wait
+}
+
+// submitOne gives the transaction to the servers, waiting for it to be in a
+// signed block if confirm is set and the client sends over the network. It
+// can be called concurrently: a network client uses a connection per
+// request.
+func (c *Client) submitOne(tx blockchain.Transaction, confirm bool) error {
+	if c.net == nil {
+		_, err := c.addAll([]blockchain.Transaction{tx})
+		return err
+	}
+	client := onet.NewClient(TxServiceName)
+	req := &SubmitTransactions{Roster: c.roster,
+		Txs: []blkparser.Tx{blockchain.ToTx(tx)}, Client: c.id,
+		Fees: []uint64{blockchain.Fee(tx)}}
+	accepted := &SubmitTransactionsReply{}
+	if cerr := client.SendProtobuf(c.si, req, accepted); cerr != nil {
+		return cerr
+	}
+	if accepted.Accepted == 0 {
+		return &BackpressureError{Reason: "rejected by the leader",
+			RetryAfter: time.Duration(accepted.RetryAfterMs) * time.Millisecond}
+	}
+	if !confirm {
+		return nil
+	}
+	reply := &WaitConfirmationsReply{}
+	cerr := client.SendProtobuf(c.si, &WaitConfirmations{Roster: c.roster,
+		Hashes: []string{tx.Hash()}, TimeoutMs: uint64(confirmTimeout /
+			time.Millisecond)}, reply)
+	if cerr != nil {
+		return cerr
+	}
+	if reply.Confirmed != 1 {
+		return errors.New("transaction not confirmed")
+	}
+	return nil
+}
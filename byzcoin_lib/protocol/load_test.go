@@ -0,0 +1,123 @@
+package byzcoin
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+)
+
+// testServer is a BlockServer taking every transaction after delay, or
+// rejecting it with err.
+type testServer struct {
+	delay time.Duration
+	err   error
+	mutex sync.Mutex
+	txs   []blockchain.Transaction
+}
+
+func (s *testServer) AddTransaction(tx blockchain.Transaction) error {
+	time.Sleep(s.delay)
+	if s.err != nil {
+		return s.err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.txs = append(s.txs, tx)
+	return nil
+}
+
+func (s *testServer) Instantiate(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	return nil, nil
+}
+
+// txChan returns a channel of n transactions.
+func txChan(n int) <-chan blkparser.Tx {
+	c := make(chan blkparser.Tx, n)
+	for _, tx := range testTxs(0, n) {
+		c <- tx
+	}
+	close(c)
+	return c
+}
+
+func TestLoadConfig(t *testing.T) {
+	c := NewClient(&testServer{})
+	for _, config := range []LoadConfig{
+		{Mode: OpenLoop, Duration: time.Second},
+		{Mode: ClosedLoop, Duration: time.Second},
+		{Mode: OpenLoop, TPS: 1},
+	} {
+		_, err := c.GenerateLoad(txChan(0), config)
+		assert.NotNil(t, err, "%+v", config)
+	}
+}
+
+func TestLoadStats(t *testing.T) {
+	s := &LoadStats{Duration: 2 * time.Second}
+	assert.Equal(t, time.Duration(0), s.Percentile(50))
+	for i := 1; i <= 10; i++ {
+		s.Latencies = append(s.Latencies, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 5.0, s.Throughput())
+	assert.Equal(t, time.Millisecond, s.Percentile(0))
+	assert.Equal(t, 6*time.Millisecond, s.Percentile(50))
+	assert.Equal(t, 10*time.Millisecond, s.Percentile(100))
+	assert.Equal(t, 0.0, (&LoadStats{}).Throughput())
+}
+
+func TestOpenLoop(t *testing.T) {
+	srv := &testServer{}
+	g, err := NewClient(srv).GenerateLoad(txChan(1000), LoadConfig{
+		Mode: OpenLoop, TPS: 200, Warmup: 100 * time.Millisecond,
+		Duration: 300 * time.Millisecond})
+	require.Nil(t, err)
+	<-g.Measured()
+	stats := g.Stop()
+	// about 60 requests are measured, after about 20 of the warm-up
+	assert.True(t, stats.Sent > 20 && stats.Sent < 150, "sent %d", stats.Sent)
+	assert.Equal(t, stats.Sent, len(stats.Latencies))
+	assert.Equal(t, 0, stats.Failed+stats.Rejected)
+	srv.mutex.Lock()
+	assert.True(t, len(srv.txs) > stats.Sent)
+	srv.mutex.Unlock()
+}
+
+func TestClosedLoop(t *testing.T) {
+	srv := &testServer{delay: 10 * time.Millisecond}
+	g, err := NewClient(srv).GenerateLoad(txChan(1000), LoadConfig{
+		Mode: ClosedLoop, Concurrency: 2, Duration: 200 * time.Millisecond})
+	require.Nil(t, err)
+	<-g.Measured()
+	stats := g.Stop()
+	// the two senders wait for their requests
+	assert.True(t, stats.Sent > 10 && stats.Sent <= 42, "sent %d", stats.Sent)
+	assert.Equal(t, stats.Sent, len(stats.Latencies))
+	for _, l := range stats.Latencies {
+		assert.True(t, l >= srv.delay)
+	}
+
+	// the requests the servers reject are counted apart
+	srv = &testServer{err: &BackpressureError{Reason: "full",
+		RetryAfter: 10 * time.Millisecond}}
+	g, err = NewClient(srv).GenerateLoad(txChan(1000), LoadConfig{
+		Mode: ClosedLoop, Concurrency: 1, Duration: 100 * time.Millisecond})
+	require.Nil(t, err)
+	<-g.Measured()
+	stats = g.Stop()
+	assert.True(t, stats.Rejected > 0 && stats.Rejected <= 11, "rejected %d", stats.Rejected)
+	assert.Equal(t, stats.Sent, stats.Rejected)
+	assert.Empty(t, stats.Latencies)
+
+	// the load ends with the transactions
+	g, err = NewClient(&testServer{}).GenerateLoad(txChan(5), LoadConfig{
+		Mode: ClosedLoop, Concurrency: 1, Duration: 100 * time.Millisecond})
+	require.Nil(t, err)
+	<-g.done
+	assert.Equal(t, 5, g.Stop().Sent)
+}
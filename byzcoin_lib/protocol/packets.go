@@ -3,6 +3,8 @@ package byzcoin
 import (
 	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
 	"github.com/dedis/paper_17_sosp_omniledger/cosi"
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+	"github.com/dedis/paper_17_sosp_omniledger/metrics"
 	"gopkg.in/dedis/onet.v1"
 )
 
@@ -17,15 +19,45 @@ const (
 	RoundCommit
 )
 
+// The messages of ByzCoin are accounted to the phases of metrics: the
+// announcements and the commitments of both rounds to PhaseAnnounce, the
+// challenges and the responses to the phase of their round.
+
+// Phase implements metrics.Phased.
+func (Announce) MetricsPhase() string { return metrics.PhaseAnnounce }
+
+// Phase implements metrics.Phased.
+func (Commitment) MetricsPhase() string { return metrics.PhaseAnnounce }
+
+// Phase implements metrics.Phased.
+func (ChallengePrepare) MetricsPhase() string { return metrics.PhasePrepare }
+
+// Phase implements metrics.Phased.
+func (ChallengeCommit) MetricsPhase() string { return metrics.PhaseCommit }
+
+// Phase implements metrics.Phased.
+func (r Response) MetricsPhase() string { return r.TYPE.phase() }
+
+// Phase implements metrics.Phased.
+func (Reassign) MetricsPhase() string { return metrics.PhaseAnnounce }
+
+// phase returns the phase of metrics of the round.
+func (t RoundType) phase() string {
+	if t == RoundCommit {
+		return metrics.PhaseCommit
+	}
+	return metrics.PhasePrepare
+}
+
 // BlockSignature is what a byzcoin protocol outputs. It contains the signature,
 // the block and some possible exceptions.
 type BlockSignature struct {
-	// cosi signature of the commit round.
-	Sig *cosi.Signature
+	// cosi signature of the commit round on the header of the block, with
+	// the mask of the peers that signed, also attached to the block. Nil if
+	// the exceptions of the round were invalid.
+	Sig *crypto.MaskedSignature
 	// the block signed.
 	Block *blockchain.TrBlock
-	// List of peers that did not want to sign.
-	Exceptions []cosi.Exception
 }
 
 // Announce is the struct used during the announcement phase (of both
@@ -34,6 +66,17 @@ type Announce struct {
 	*cosi.Announcement
 	TYPE    RoundType
 	Timeout uint64
+	// Failover is set by a member that took over the group of a failed
+	// leader, so that the members who already committed send their commitment
+	// again.
+	Failover bool
+	// Failing are the roster indices of the nodes that simulate a crash as
+	// soon as they get the announcement.
+	Failing []int32
+	// FailoverTimeout is how long the sender of the prepare announcement
+	// waits for the commitments of its children, see SetSubLeaderTimeout.
+	// The nodes with children wait half of it for theirs.
+	FailoverTimeout uint64
 }
 
 // announceChan is the type of the channel that will be used to catch
@@ -61,7 +104,17 @@ type commitChan struct {
 type ChallengePrepare struct {
 	TYPE RoundType
 	*cosi.Challenge
-	*blockchain.TrBlock
+	// Block is the block of the challenge, compressed as configured by the
+	// root
+	Block *blockchain.PackedBlock
+	// LastBlock is the hash of the block the root extends, as the members
+	// don't keep the chain in the simulation
+	LastBlock string
+	// Proposal is the signature of the root on the block and LastBlock
+	Proposal *Proposal
+	// ReportTxs makes the nodes report the invalid transactions of the
+	// block, see SetTxReports
+	ReportTxs bool
 }
 
 // ChallengeCommit  is the challenge used by ByzCoin during the "commit"
@@ -81,6 +134,9 @@ type ChallengeCommit struct {
 	// verifying the signature. It can not be spoofed otherwise the signature
 	// would be wrong.
 	Exceptions []cosi.Exception
+	// Invalid are the transactions the root dropped from the block of the
+	// prepare round, see SetTxReports
+	Invalid TxMask
 }
 
 // challengeChan is the type of the channel that will be used to dcatch the
@@ -101,6 +157,9 @@ type Response struct {
 	*cosi.Response
 	Exceptions []cosi.Exception
 	TYPE       RoundType
+	// Invalid are the transactions of the block of the prepare round the
+	// node and its subtree found invalid, see SetTxReports
+	Invalid TxMask
 }
 
 // responseChan is the type of the channel used to catch the response messages.
@@ -108,3 +167,22 @@ type responseChan struct {
 	*onet.TreeNode
 	Response
 }
+
+// Reassign is sent by the root to a member of a group whose leader didn't send
+// its commitments in time. The member becomes the leader of the members after
+// it in the group, and announces both rounds to them. Any node with children
+// sends it for a failed child of its own, see handleFailover.
+type Reassign struct {
+	Prepare Announce
+	Commit  Announce
+	// Adopt makes the sender the parent of the receiver for the rest of the
+	// round, instead of the failed parent of the receiver, without taking
+	// over its group.
+	Adopt bool
+}
+
+// reassignChan is the type of the channel used to catch the reassign messages.
+type reassignChan struct {
+	*onet.TreeNode
+	Reassign
+}
@@ -0,0 +1,207 @@
+package byzcoin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/crypto"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// The block of the challenge of the prepare round is proposed by the root:
+// nothing else would keep a malicious root from giving different blocks to
+// different subtrees. The root signs its proposal, every node checks the
+// signature before it verifies the block, and the prepare round signs the
+// hash of the proposal instead of the block, so that the signature of the
+// round only holds for a proposal the root signed.
+//
+// Every node also keeps the proposals it sees, see TxService.Proposals: two
+// proposals of different blocks by the same root for the same last block,
+// in the same round or in two rounds, are an equivocation, proven by the pair
+// of signatures. A node refuses to sign the second one. A root proposing the
+// block of an aborted round again, see Server.Instantiate, proposes the
+// same block, which is no equivocation.
+
+// maxProposals is how many proposals the detector keeps before it forgets
+// the oldest ones.
+const maxProposals = 1024
+
+// Proposal is the block the root proposes in a round, signed by the root.
+type Proposal struct {
+	// Round is the round of the protocol instance of the proposal
+	Round onet.RoundID
+	// LastBlock is the hash of the block the proposed block extends
+	LastBlock string
+	// Block is the hash of the proposed block
+	Block []byte
+	// Signature is the Schnorr signature of the root on the Hash
+	Signature crypto.SchnorrSig
+}
+
+// newProposal returns the proposal of the block, signed with private.
+func newProposal(suite abstract.Suite, private abstract.Scalar, round onet.RoundID,
+	lastBlock string, block *blockchain.TrBlock) (*Proposal, error) {
+	hash, err := blockHash(block)
+	if err != nil {
+		return nil, err
+	}
+	p := &Proposal{Round: round, LastBlock: lastBlock, Block: hash}
+	p.Signature, err = crypto.SignSchnorr(suite, private, p.Hash())
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// blockHash returns the hash of the block, as the nodes marshal it.
+func blockHash(block *blockchain.TrBlock) ([]byte, error) {
+	marshalled, err := json.Marshal(block)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(marshalled)
+	return h[:], nil
+}
+
+// Hash returns the hash of the proposal, signed by the root and by the
+// prepare round.
+func (p *Proposal) Hash() []byte {
+	h := sha256.New()
+	h.Write(p.Round[:])
+	h.Write([]byte(p.LastBlock))
+	h.Write(p.Block)
+	return h.Sum(nil)
+}
+
+// Verify returns nil if the proposal is the one of the block, signed by
+// the root.
+func (p *Proposal) Verify(suite abstract.Suite, root abstract.Point,
+	block *blockchain.TrBlock) error {
+	if p == nil {
+		return errors.New("unsigned proposal")
+	}
+	hash, err := blockHash(block)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash, p.Block) {
+		return errors.New("proposal of another block")
+	}
+	return crypto.VerifySchnorr(suite, root, p.Hash(), p.Signature)
+}
+
+// Equivocation is the proof that a root signed two proposals of different
+// blocks for the same last block.
+type Equivocation struct {
+	Root          abstract.Point
+	First, Second *Proposal
+}
+
+// EquivocationDetector keeps the proposals of the roots to find their
+// equivocations.
+type EquivocationDetector struct {
+	sync.Mutex
+	// proposals are the first proposals seen, by root and last block, see
+	// proposalKey
+	proposals map[string]*Proposal
+	// order are the keys of the proposals, oldest first
+	order         []string
+	equivocations []*Equivocation
+}
+
+// NewEquivocationDetector returns a detector that saw no proposal yet.
+func NewEquivocationDetector() *EquivocationDetector {
+	d := &EquivocationDetector{proposals: make(map[string]*Proposal)}
+	detectors.Lock()
+	detectors.list = append(detectors.list, d)
+	detectors.Unlock()
+	return d
+}
+
+// Observe adds the proposal of the root, whose signature is checked
+// already, and returns the equivocation it makes with a proposal seen
+// before, if any.
+func (d *EquivocationDetector) Observe(root abstract.Point, p *Proposal) *Equivocation {
+	key := proposalKey(root, p)
+	d.Lock()
+	defer d.Unlock()
+	first, ok := d.proposals[key]
+	if !ok {
+		d.proposals[key] = p
+		d.order = append(d.order, key)
+		if len(d.order) > maxProposals {
+			delete(d.proposals, d.order[0])
+			d.order = d.order[1:]
+		}
+		return nil
+	}
+	if bytes.Equal(first.Block, p.Block) {
+		return nil
+	}
+	e := &Equivocation{Root: root, First: first, Second: p}
+	d.equivocations = append(d.equivocations, e)
+	return e
+}
+
+// Equivocations returns the equivocations found.
+func (d *EquivocationDetector) Equivocations() []*Equivocation {
+	d.Lock()
+	defer d.Unlock()
+	return append([]*Equivocation(nil), d.equivocations...)
+}
+
+// proposalKey returns the key of the proposals of the root that must not
+// conflict: the root can't propose two blocks extending the same block, not
+// even in two rounds.
+func proposalKey(root abstract.Point, p *Proposal) string {
+	return root.String() + "/" + p.LastBlock
+}
+
+// detectors are the detectors of the nodes of this process, to measure the
+// equivocations they found.
+var detectors struct {
+	sync.Mutex
+	list []*EquivocationDetector
+}
+
+// Equivocations returns the equivocations the nodes of this process found,
+// each node with its own detector.
+func Equivocations() []*Equivocation {
+	detectors.Lock()
+	defer detectors.Unlock()
+	var equivocations []*Equivocation
+	for _, d := range detectors.list {
+		equivocations = append(equivocations, d.Equivocations()...)
+	}
+	return equivocations
+}
+
+// proposals returns the detector of the node, the one of its TxService.
+func (bz *ByzCoin) proposals() *EquivocationDetector {
+	return bz.Host().Service(TxServiceName).(*TxService).Proposals()
+}
+
+// checkProposal checks the proposal of the block the root sent, and that
+// it doesn't conflict with another one we saw.
+func (bz *ByzCoin) checkProposal(p *Proposal, block *blockchain.TrBlock,
+	lastBlock string) error {
+	root := bz.Root().ServerIdentity.Public
+	if err := p.Verify(bz.suite, root, block); err != nil {
+		return err
+	}
+	if p.Round != bz.Token().RoundID || p.LastBlock != lastBlock {
+		return errors.New("proposal of another round")
+	}
+	if e := bz.proposals().Observe(root, p); e != nil {
+		log.Error(bz.Name(), "Root", bz.Root().Name(), "equivocates on the block after",
+			lastBlock)
+		return errors.New("conflicting proposals of the root")
+	}
+	return nil
+}
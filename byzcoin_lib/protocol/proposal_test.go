@@ -0,0 +1,90 @@
+package byzcoin
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func TestProposal(t *testing.T) {
+	suite := network.Suite
+	kp := config.NewKeyPair(suite)
+	block := newBlock(testTxs(1, 1), "last", "")
+	other := newBlock(testTxs(2, 1), "last", "")
+	p, err := newProposal(suite, kp.Secret, onet.RoundID{1}, "last", block)
+	require.Nil(t, err)
+	assert.Nil(t, p.Verify(suite, kp.Public, block))
+	assert.NotNil(t, p.Verify(suite, kp.Public, other), "another block")
+	assert.NotNil(t, p.Verify(suite, config.NewKeyPair(suite).Public, block), "another root")
+	var unsigned *Proposal
+	assert.NotNil(t, unsigned.Verify(suite, kp.Public, block))
+}
+
+func TestEquivocationDetector(t *testing.T) {
+	suite := network.Suite
+	root := config.NewKeyPair(suite)
+	block := newBlock(testTxs(1, 1), "last", "")
+	other := newBlock(testTxs(2, 1), "last", "")
+	propose := func(round byte, lastBlock string, b *blockchain.TrBlock) *Proposal {
+		p, err := newProposal(suite, root.Secret, onet.RoundID{round}, lastBlock, b)
+		require.Nil(t, err)
+		return p
+	}
+
+	d := NewEquivocationDetector()
+	first := propose(1, "last", block)
+	assert.Nil(t, d.Observe(root.Public, first))
+	assert.Nil(t, d.Observe(root.Public, first))
+	// the same block proposed again in another round, as after an abort
+	assert.Nil(t, d.Observe(root.Public, propose(2, "last", block)))
+	// another block extending another one, or of another root
+	assert.Nil(t, d.Observe(root.Public, propose(2, "other", other)))
+	assert.Nil(t, d.Observe(config.NewKeyPair(suite).Public, propose(1, "last", other)))
+	// another block extending the same one, in the same round or not
+	for _, round := range []byte{1, 3} {
+		e := d.Observe(root.Public, propose(round, "last", other))
+		require.NotNil(t, e)
+		assert.Equal(t, first, e.First)
+	}
+	assert.Equal(t, 2, len(d.Equivocations()))
+
+	// the oldest proposals are forgotten
+	for i := 0; i < maxProposals; i++ {
+		d.Observe(root.Public, propose(1, fmt.Sprint(i), block))
+	}
+	assert.Equal(t, maxProposals, len(d.proposals))
+	assert.Nil(t, d.Observe(root.Public, propose(1, "last", other)))
+}
+
+func TestEquivocatingRoot(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, _, tree := local.GenBigTree(4, 4, 3, true)
+
+	bz, sig := runRound(t, local, tree, testTxs(0, 2))
+	require.NotNil(t, sig)
+	assert.Nil(t, verifyBlockSignature(bz.Suite(), bz.Roster().Publics(), sig))
+	// the same block in another instance, as an aborted one proposed again
+	_, sig = runRound(t, local, tree, testTxs(0, 2))
+	require.NotNil(t, sig)
+	for _, tn := range tree.List() {
+		assert.Empty(t, service(local, tn).Proposals().Equivocations())
+	}
+
+	// another block extending the same last block in another instance
+	bz, sig = runRound(t, local, tree, testTxs(10, 2))
+	if sig != nil {
+		assert.NotNil(t, verifyBlockSignature(bz.Suite(), bz.Roster().Publics(), sig))
+	}
+	// every node found it with its own detector
+	for _, tn := range tree.List()[1:] {
+		assert.Equal(t, 1, len(service(local, tn).Proposals().Equivocations()))
+	}
+	assert.Empty(t, service(local, tree.Root).Proposals().Equivocations())
+}
@@ -1,8 +1,11 @@
 package byzcoin
 
 import (
+	"errors"
 	"sync"
+	"time"
 
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
 	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
 	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/log"
@@ -11,69 +14,294 @@ import (
 // BlockServer is a struct where Client can connect and that instantiate ByzCoin
 // protocols when needed.
 type BlockServer interface {
-	AddTransaction(blkparser.Tx)
+	// AddTransaction adds the transaction to the pending ones, or returns
+	// why it didn't, a BackpressureError if it can be sent again later.
+	AddTransaction(blockchain.Transaction) error
 	Instantiate(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error)
 }
 
+// maxPendingBlocks is how many blocks worth of transactions the server
+// keeps pending.
+const maxPendingBlocks = 4
+
+// poolFullRetry is how long a client is told to wait when the pool is full.
+const poolFullRetry = 100 * time.Millisecond
+
+// The transactions of the last replayBlocks blocks are remembered exactly,
+// and the ones of the replayGenerations*replayBlocks blocks before in a
+// bloom filter of false positive rate replayFalsePositive.
+const (
+	replayBlocks        = 64
+	replayGenerations   = 4
+	replayFalsePositive = 1e-6
+)
+
+// ErrDuplicate is returned for a transaction already pending or already put
+// in a block.
+var ErrDuplicate = errors.New("duplicate transaction")
+
 // Server is the long-term control service that listens for transactions and
 // dispatch them to a new ByzCoin for each new signing that we want to do.
 // It creates the ByzCoin protocols and run them. only used by the root since
 // only the root participates to the creation of the block.
 type Server struct {
-	// transactions pool where all the incoming transactions are stored
-	transactions []blkparser.Tx
-	// lock associated
-	transactionLock sync.Mutex
+	// pool holds the incoming transactions until they are put in a block
+	pool *blockchain.Mempool
+	// enough is signalled whenever a transaction is added to the pool
+	enough *sync.Cond
+	// state holds the unspent outputs the transactions are verified against,
+	// nil to take them unverified
+	state *blockchain.UTXOSet
+	// store is where the ByzCoin instances persist the finalized blocks,
+	// nil to keep them in memory only
+	store blockchain.Store
+	// chain checks that the finalized blocks form a chain, and gives the
+	// block the next instance extends
+	chain *blockchain.ChainValidator
+	// compression is how the instances compress the blocks they announce
+	compression blockchain.Compression
 	// how many transactions should we give to an instance
 	blockSize int
-	timeOutMs uint64
-	fail      uint
+	// maxBlockBytes is the most bytes of transactions we give to an
+	// instance, 0 for no limit
+	maxBlockBytes int
+	timeOutMs     uint64
+	fail          uint
 	// blockSignatureChan is the channel used to pass out the signatures that
 	// ByzCoin's instances have made
 	blockSignatureChan chan BlockSignature
-	// enoughBlock signals the server we have enough
-	// no comments..
-	transactionChan chan blkparser.Tx
-	requestChan     chan bool
-	responseChan    chan []blkparser.Tx
+	// confirmed holds the hashes of the transactions of the signed blocks,
+	// confirmedCond is signalled whenever a block is confirmed
+	confirmed     map[string]bool
+	confirmedCond *sync.Cond
+	// admission limits the rate of the transactions, with a bucket for all
+	// the clients and one for every client, and stats counts them
+	admission     AdmissionConfig
+	globalBucket  *tokenBucket
+	clientBuckets map[string]*tokenBucket
+	stats         AdmissionStats
+	// replay remembers the transactions put in blocks
+	replay *blockchain.ReplayFilter
+	// aborted are the transactions of the aborted instances, proposed again
+	// before the ones of the pool, under the lock of enough
+	aborted [][]blkparser.Tx
 }
 
 // NewByzCoinServer returns a new fresh ByzCoinServer. It must be given the blockSize in order
 // to efficiently give the transactions to the ByzCoin instances.
 func NewByzCoinServer(blockSize int, timeOutMs uint64, fail uint) *Server {
-	s := &Server{
+	pool := blockchain.NewMempool(maxPendingBlocks*blockSize, 0)
+	pool.Priority = blockchain.FeeDensity
+	return &Server{
+		pool:               pool,
+		enough:             sync.NewCond(&sync.Mutex{}),
+		chain:              blockchain.NewChainValidator("", -1),
 		blockSize:          blockSize,
 		timeOutMs:          timeOutMs,
 		fail:               fail,
 		blockSignatureChan: make(chan BlockSignature),
-		transactionChan:    make(chan blkparser.Tx),
-		requestChan:        make(chan bool),
-		responseChan:       make(chan []blkparser.Tx),
+		confirmed:          make(map[string]bool),
+		confirmedCond:      sync.NewCond(&sync.Mutex{}),
+		clientBuckets:      make(map[string]*tokenBucket),
+		replay: blockchain.NewReplayFilter(replayBlocks,
+			blockchain.NewSlidingBloom(replayGenerations,
+				replayBlocks*blockSize, replayFalsePositive)),
 	}
-	go s.listenEnoughBlocks()
-	return s
 }
 
 // AddTransaction add a new transactions to the list of transactions to commit
-func (s *Server) AddTransaction(tr blkparser.Tx) {
-	s.transactionChan <- tr
+func (s *Server) AddTransaction(tr blockchain.Transaction) error {
+	return s.AddTransactionFrom("", tr)
+}
+
+// AddTransactionFrom adds the transaction of the client to the pool if the
+// rate limits admit it, the pool has room and it verifies against the state
+// of the server. A transaction already pending or already put in a block is
+// rejected with ErrDuplicate.
+func (s *Server) AddTransactionFrom(client string, tr blockchain.Transaction) error {
+	s.enough.L.Lock()
+	defer s.enough.L.Unlock()
+	if _, ok := s.pool.Get(tr.Hash()); ok || s.replay.Committed(tr.Hash()) {
+		log.Lvl3("Dropping duplicate transaction", tr.Hash())
+		s.stats.Duplicate++
+		return ErrDuplicate
+	}
+	if err := s.admit(client); err != nil {
+		s.stats.RateLimited++
+		return err
+	}
+	if s.state != nil {
+		if err := tr.Verify(s.state); err != nil {
+			log.Lvl2("Dropping transaction", tr.Hash(), ":", err)
+			s.stats.Invalid++
+			return err
+		}
+	}
+	if !s.pool.AddTransaction(tr) {
+		s.stats.PoolFull++
+		return &BackpressureError{Reason: "pool full", RetryAfter: poolFullRetry}
+	}
+	s.stats.Admitted++
+	s.enough.Broadcast()
+	return nil
+}
+
+// UseAdmission makes the server limit the rate of the transactions it
+// admits, from all clients and from every client. The transactions of the
+// clients of NewClient and NewShardedClient all come from the same client.
+func (s *Server) UseAdmission(config AdmissionConfig) {
+	s.enough.L.Lock()
+	defer s.enough.L.Unlock()
+	s.admission = config
+	s.globalBucket = newTokenBucket(config.GlobalTPS, config.Burst)
+	s.clientBuckets = make(map[string]*tokenBucket)
 }
 
-// ListenClientTransactions will bind to a port a listen for incoming connection
-// from clients. These client will be able to pass the transactions to the
+// AdmissionStats returns the counts of the transactions offered to the
 // server.
-func (s *Server) ListenClientTransactions() {
-	panic("not implemented yet")
+func (s *Server) AdmissionStats() AdmissionStats {
+	s.enough.L.Lock()
+	defer s.enough.L.Unlock()
+	stats := s.stats
+	stats.Queued = s.pool.Len()
+	return stats
+}
+
+// UseState makes the server verify the incoming transactions against the
+// unspent outputs of its shard.
+func (s *Server) UseState(state *blockchain.UTXOSet) {
+	s.enough.L.Lock()
+	defer s.enough.L.Unlock()
+	s.state = state
+}
+
+// UseStore makes the ByzCoin instances persist the finalized blocks in the
+// store. The blocks already in the store are checked, and the next instance
+// extends the latest one.
+func (s *Server) UseStore(store blockchain.Store) error {
+	if err := s.chain.CatchUp(store); err != nil {
+		return err
+	}
+	s.store = store
+	return nil
+}
+
+// UseCompression makes the ByzCoin instances compress the blocks they
+// announce.
+func (s *Server) UseCompression(c blockchain.Compression) {
+	s.compression = c
+}
+
+// UseMaxBlockBytes makes the server give the instances transactions of at
+// most maxBytes bytes, on top of at most blockSize transactions if it isn't
+// 0. A block then waits until the next pending transaction doesn't fit.
+func (s *Server) UseMaxBlockBytes(maxBytes int) {
+	s.enough.L.Lock()
+	defer s.enough.L.Unlock()
+	s.maxBlockBytes = maxBytes
+}
+
+// UsePolicy makes the server give the instances blocks that follow the
+// rules of the chain: at most the MaxTxCount transactions of the policy if
+// it is less than the blockSize, and the MaxBlockBytes of the policy, see
+// UseMaxBlockBytes.
+func (s *Server) UsePolicy(p blockchain.ChainPolicy) {
+	s.enough.L.Lock()
+	defer s.enough.L.Unlock()
+	if p.MaxTxCount > 0 && p.MaxTxCount < s.blockSize {
+		s.blockSize = p.MaxTxCount
+	}
+	s.maxBlockBytes = p.MaxBlockBytes
+}
+
+// Mempool returns the pool of the pending transactions.
+func (s *Server) Mempool() *blockchain.Mempool {
+	return s.pool
+}
+
+// ListenClientTransactions makes the server take the transactions the
+// clients send over the network to the TxService of the onet server, see
+// NetClient.
+func (s *Server) ListenClientTransactions(srv *onet.Server) {
+	srv.Service(TxServiceName).(*TxService).SetServer(s)
+}
+
+// Confirm marks the transactions of the signed block as confirmed, for the
+// clients waiting on them with WaitConfirmed.
+func (s *Server) Confirm(block *blockchain.TrBlock) {
+	s.confirmedCond.L.Lock()
+	defer s.confirmedCond.L.Unlock()
+	for _, tx := range block.Txs {
+		s.confirmed[tx.Hash] = true
+	}
+	s.confirmedCond.Broadcast()
+}
+
+// WaitConfirmed waits until the transactions of the hashes are all in
+// blocks given to Confirm, or until the timeout, and returns how many of
+// them are.
+func (s *Server) WaitConfirmed(hashes []string, timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, func() {
+		s.confirmedCond.L.Lock()
+		defer s.confirmedCond.L.Unlock()
+		s.confirmedCond.Broadcast()
+	})
+	defer timer.Stop()
+	s.confirmedCond.L.Lock()
+	defer s.confirmedCond.L.Unlock()
+	for {
+		n := 0
+		for _, h := range hashes {
+			if s.confirmed[h] {
+				n++
+			}
+		}
+		if n == len(hashes) || !time.Now().Before(deadline) {
+			return n
+		}
+		s.confirmedCond.Wait()
+	}
 }
 
 // Instantiate takes blockSize transactions and create the byzcoin instances.
 func (s *Server) Instantiate(node *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
-	// wait until we have enough blocks
-	currTransactions := s.WaitEnoughBlocks()
+	// propose the transactions of an aborted instance again, else wait
+	// until we have enough blocks
+	currTransactions := s.takeAborted()
+	if currTransactions == nil {
+		currTransactions = s.WaitEnoughBlocks()
+	}
 	log.Lvl2("Instantiate ByzCoin Round with", len(currTransactions), "transactions")
 	pi, err := NewByzCoinRootProtocol(node, currTransactions, s.timeOutMs, s.fail)
+	if err != nil {
+		return nil, err
+	}
+	pi.SetStore(s.store)
+	pi.SetChain(s.chain)
+	pi.SetCompression(s.compression)
+	pi.RegisterOnAbort(s.repropose)
+	return pi, nil
+}
 
-	return pi, err
+// repropose keeps the transactions of an aborted instance for the next one.
+func (s *Server) repropose(transactions []blkparser.Tx) {
+	s.enough.L.Lock()
+	defer s.enough.L.Unlock()
+	s.aborted = append(s.aborted, transactions)
+}
+
+// takeAborted returns the transactions of the oldest aborted instance, nil
+// if there is none.
+func (s *Server) takeAborted() []blkparser.Tx {
+	s.enough.L.Lock()
+	defer s.enough.L.Unlock()
+	if len(s.aborted) == 0 {
+		return nil
+	}
+	transactions := s.aborted[0]
+	s.aborted = s.aborted[1:]
+	return transactions
 }
 
 // BlockSignaturesChan returns a channel that is given each new block signature as
@@ -87,38 +315,31 @@ func (s *Server) onDoneSign(blk BlockSignature) {
 }
 
 // WaitEnoughBlocks is called to wait on the server until it has enough
-// transactions to make a block
+// transactions to make a block. It returns the blockSize pending
+// transactions offering the most fee per byte, or less if they don't fit in
+// the bytes of UseMaxBlockBytes, and removes them from the pool for good.
 func (s *Server) WaitEnoughBlocks() []blkparser.Tx {
-	s.requestChan <- true
-	transactions := <-s.responseChan
+	s.enough.L.Lock()
+	defer s.enough.L.Unlock()
+	pending, full := s.nextBlock()
+	for !full {
+		s.enough.Wait()
+		pending, full = s.nextBlock()
+	}
+	transactions := make([]blkparser.Tx, len(pending))
+	ids := make([]string, len(pending))
+	for i, tr := range pending {
+		transactions[i] = blockchain.ToTx(tr)
+		ids[i] = tr.Hash()
+	}
+	s.pool.Remove(ids)
+	s.replay.AddBlock(ids)
 	return transactions
 }
 
-func (s *Server) listenEnoughBlocks() {
-	// TODO the server should have a transaction pool instead:
-	var transactions []blkparser.Tx
-	var want bool
-	for {
-		select {
-		case tr := <-s.transactionChan:
-			// FIXME this will lead to a very large slice if the client sends many
-			if len(transactions) < s.blockSize {
-				transactions = append(transactions, tr)
-			}
-			if want {
-				if len(transactions) >= s.blockSize {
-					s.responseChan <- transactions[:s.blockSize]
-					transactions = transactions[s.blockSize:]
-					want = false
-				}
-			}
-		case <-s.requestChan:
-			want = true
-			if len(transactions) >= s.blockSize {
-				s.responseChan <- transactions[:s.blockSize]
-				transactions = transactions[s.blockSize:]
-				want = false
-			}
-		}
-	}
+// nextBlock returns the pending transactions of the next block, the ones
+// offering the most fee per byte first, and whether the block is full. The
+// caller must hold s.enough.L.
+func (s *Server) nextBlock() ([]blockchain.Transaction, bool) {
+	return s.pool.Select(s.blockSize, s.maxBlockBytes)
 }
@@ -0,0 +1,94 @@
+package byzcoin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitBlock returns the transactions of the next block of the server, nil
+// if it has none after a while.
+func waitBlock(s *Server) []string {
+	done := make(chan []string, 1)
+	go func() {
+		var ids []string
+		for _, tx := range s.WaitEnoughBlocks() {
+			ids = append(ids, tx.Hash)
+		}
+		done <- ids
+	}()
+	select {
+	case ids := <-done:
+		return ids
+	case <-time.After(100 * time.Millisecond):
+		return nil
+	}
+}
+
+func TestServerPool(t *testing.T) {
+	s := NewByzCoinServer(2, 0, 0)
+	txs := blockchain.BitcoinTxs(testTxs(0, 3))
+	require.Nil(t, s.AddTransaction(txs[0]))
+	assert.Equal(t, ErrDuplicate, s.AddTransaction(txs[0]))
+	require.Nil(t, s.AddTransaction(txs[1]))
+	require.Nil(t, s.AddTransaction(txs[2]))
+	assert.Equal(t, []string{txs[0].Hash(), txs[1].Hash()}, waitBlock(s))
+	assert.Equal(t, 1, s.Mempool().Len())
+
+	// the last transaction waits for another one to fill a block
+	block := make(chan []string, 1)
+	go func() { block <- waitBlock(s) }()
+	time.Sleep(20 * time.Millisecond)
+	require.Nil(t, s.AddTransaction(blockchain.NewBitcoinTx(testTxs(3, 1)[0])))
+	assert.Equal(t, []string{txs[2].Hash(), testTxs(3, 1)[0].Hash}, <-block)
+	assert.Equal(t, 0, s.Mempool().Len())
+}
+
+func TestServerMaxBlockBytes(t *testing.T) {
+	s := NewByzCoinServer(10, 0, 0)
+	s.UseMaxBlockBytes(250)
+	txs := testTxs(0, 5)
+	for i, size := range []uint32{100, 100, 100, 300, 200} {
+		txs[i].Size = size
+		require.Nil(t, s.AddTransaction(blockchain.NewBitcoinTx(txs[i])))
+	}
+	// the third transaction doesn't fit, the fourth is larger than a block
+	assert.Equal(t, []string{txs[0].Hash, txs[1].Hash}, waitBlock(s))
+	assert.Equal(t, []string{txs[2].Hash}, waitBlock(s))
+	assert.Equal(t, []string{txs[3].Hash}, waitBlock(s))
+	// the last one waits for the block to be full, until the next
+	// transaction doesn't fit
+	s.enough.L.Lock()
+	_, full := s.nextBlock()
+	s.enough.L.Unlock()
+	assert.False(t, full)
+	next := testTxs(5, 1)[0]
+	next.Size = 100
+	require.Nil(t, s.AddTransaction(blockchain.NewBitcoinTx(next)))
+	assert.Equal(t, []string{txs[4].Hash}, waitBlock(s))
+}
+
+func TestServerReplay(t *testing.T) {
+	s := NewByzCoinServer(3, 0, 0)
+	txs := NativeTransactions(4)
+	for _, tx := range txs[:3] {
+		require.Nil(t, s.AddTransaction(tx))
+	}
+	assert.Equal(t, ErrDuplicate, s.AddTransaction(txs[0]), "pending")
+	assert.Equal(t, 3, len(waitBlock(s)))
+	assert.Equal(t, ErrDuplicate, s.AddTransaction(txs[1]), "in a block")
+	require.Nil(t, s.AddTransaction(txs[3]))
+	stats := s.AdmissionStats()
+	assert.Equal(t, 2, stats.Duplicate)
+	assert.Equal(t, 4, stats.Admitted)
+
+	// the batches of transactions are all different
+	next := NativeBatch(4, 1)
+	for i := range next {
+		assert.NotEqual(t, txs[i].Hash(), next[i].Hash())
+		require.Nil(t, s.AddTransaction(next[i]))
+	}
+}
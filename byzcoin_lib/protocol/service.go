@@ -0,0 +1,179 @@
+package byzcoin
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// TxServiceName is the name of the service taking the transactions of the
+// clients over the network.
+const TxServiceName = "ByzCoinTx"
+
+const (
+	// ErrorParameterWrong indicates that a given parameter is out of bounds.
+	ErrorParameterWrong = 4300 + iota
+	// ErrorNoServer indicates that the leader of the roster doesn't take
+	// the transactions of the clients.
+	ErrorNoServer
+)
+
+func init() {
+	onet.RegisterNewService(TxServiceName, newTxService)
+	for _, m := range []interface{}{
+		&SubmitTransactions{},
+		&SubmitTransactionsReply{},
+		&WaitConfirmations{},
+		&WaitConfirmationsReply{},
+	} {
+		network.RegisterMessage(m)
+	}
+}
+
+// SubmitTransactions - sent by a client to any node of the roster, which
+// passes the transactions on to the leader.
+type SubmitTransactions struct {
+	// Roster is the roster whose first node is the leader
+	Roster *onet.Roster
+	Txs    []blkparser.Tx
+	// Client identifies the client for the rate limits of the leader
+	Client string
+	// Fees are the fees offered by the transactions, none if missing
+	Fees []uint64
+}
+
+// SubmitTransactionsReply - the first Accepted transactions are in the pool
+// of the leader, or have been dropped as invalid. The others have been
+// rejected by the admission control of the leader, and can be sent again
+// after RetryAfterMs.
+type SubmitTransactionsReply struct {
+	Accepted     int
+	RetryAfterMs uint64
+}
+
+// WaitConfirmations - sent by a client to wait until its transactions are
+// in signed blocks.
+type WaitConfirmations struct {
+	Roster    *onet.Roster
+	Hashes    []string
+	TimeoutMs uint64
+}
+
+// WaitConfirmationsReply holds how many of the transactions are confirmed,
+// which is less than asked for if the timeout expired.
+type WaitConfirmationsReply struct {
+	Confirmed int
+}
+
+// TxService takes the transactions of the clients for the Server of the
+// leader. The other nodes pass the requests on to the leader. It also keeps
+// the proposals of the roots the node saw, see Proposals.
+type TxService struct {
+	*onet.ServiceProcessor
+
+	mutex sync.Mutex
+	// server is the Server of the leader, nil on the other nodes
+	server *Server
+	// proposals finds the equivocations of the roots
+	proposals *EquivocationDetector
+}
+
+// SetServer makes the service give the transactions to the server.
+func (s *TxService) SetServer(server *Server) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.server = server
+}
+
+// Proposals returns the detector of the equivocations of the roots of the
+// node, which keeps the proposals of all the instances it runs.
+func (s *TxService) Proposals() *EquivocationDetector {
+	return s.proposals
+}
+
+// SubmitTransactions adds the transactions to the pool of the leader.
+func (s *TxService) SubmitTransactions(req *SubmitTransactions) (*SubmitTransactionsReply, onet.ClientError) {
+	server, cerr := s.leader(req.Roster)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if server == nil {
+		reply := &SubmitTransactionsReply{}
+		cerr := onet.NewClient(TxServiceName).SendProtobuf(req.Roster.List[0],
+			req, reply)
+		return reply, cerr
+	}
+	reply := &SubmitTransactionsReply{}
+	for i, tx := range req.Txs {
+		var fee uint64
+		if i < len(req.Fees) {
+			fee = req.Fees[i]
+		}
+		err := server.AddTransactionFrom(req.Client,
+			blockchain.WithFee(blockchain.NewBitcoinTx(tx), fee))
+		if bp := backpressure(err); bp != nil {
+			log.Lvl3(s.ServerIdentity(), "rejects", len(req.Txs)-reply.Accepted,
+				"transactions of", req.Client, ":", bp)
+			reply.RetryAfterMs = uint64(bp.RetryAfter / time.Millisecond)
+			if reply.RetryAfterMs == 0 {
+				reply.RetryAfterMs = 1
+			}
+			break
+		}
+		reply.Accepted++
+	}
+	log.Lvl3(s.ServerIdentity(), "got", reply.Accepted, "transactions")
+	return reply, nil
+}
+
+// WaitConfirmations waits until the transactions are in signed blocks of
+// the leader, or until the timeout.
+func (s *TxService) WaitConfirmations(req *WaitConfirmations) (*WaitConfirmationsReply, onet.ClientError) {
+	server, cerr := s.leader(req.Roster)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if server == nil {
+		reply := &WaitConfirmationsReply{}
+		cerr := onet.NewClient(TxServiceName).SendProtobuf(req.Roster.List[0],
+			req, reply)
+		return reply, cerr
+	}
+	timeout := time.Duration(req.TimeoutMs) * time.Millisecond
+	return &WaitConfirmationsReply{
+		Confirmed: server.WaitConfirmed(req.Hashes, timeout),
+	}, nil
+}
+
+// leader returns the server if we are the leader of the roster, nil if
+// another node is.
+func (s *TxService) leader(roster *onet.Roster) (*Server, onet.ClientError) {
+	if roster == nil || len(roster.List) == 0 {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong, "no roster")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !roster.List[0].ID.Equal(s.ServerIdentity().ID) {
+		return nil, nil
+	}
+	if s.server == nil {
+		return nil, onet.NewClientErrorCode(ErrorNoServer,
+			"the leader doesn't take transactions")
+	}
+	return s.server, nil
+}
+
+func newTxService(c *onet.Context) onet.Service {
+	s := &TxService{
+		ServiceProcessor: onet.NewServiceProcessor(c),
+		proposals:        NewEquivocationDetector(),
+	}
+	log.ErrFatal(s.RegisterHandlers(s.SubmitTransactions,
+		s.WaitConfirmations))
+	return s
+}
@@ -0,0 +1,154 @@
+package byzcoin
+
+import (
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"github.com/dedis/paper_17_sosp_omniledger/cosi"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/simul/monitor"
+)
+
+// The root doesn't wait indefinitely for the responses of the prepare round,
+// see SetPrepareTimeout. At the timeout, the children that didn't respond
+// are left out of the round with their subtrees: their commitments of both
+// rounds become exceptions, and the commit round only goes to the others.
+// If the signature of the prepare round still holds for the block, the
+// round goes on with it, else the root aborts the round and hands its
+// transactions to the callback of RegisterOnAbort, which proposes them
+// again in a new round.
+
+// SetPrepareTimeout makes the root go on without the children that didn't
+// respond in the prepare round after timeOutMs milliseconds, 0 to wait for
+// all of them. It has to be called before Start.
+func (bz *ByzCoin) SetPrepareTimeout(timeOutMs uint64) {
+	bz.prepareTimeout = timeOutMs
+}
+
+// RegisterOnAbort registers a callback the root calls with its transactions
+// if it aborts the round at the timeout of the prepare round.
+func (bz *ByzCoin) RegisterOnAbort(fn func([]blkparser.Tx)) {
+	bz.onAbort = fn
+}
+
+// Aborted returns whether the root aborted the round at the timeout of the
+// prepare round.
+func (bz *ByzCoin) Aborted() bool {
+	return bz.aborted
+}
+
+// TimedOut returns how many children the root left out at the timeout of
+// the prepare round.
+func (bz *ByzCoin) TimedOut() int {
+	return bz.timedOut
+}
+
+// startPrepareTimer notifies the root when the prepare timeout expires.
+func (bz *ByzCoin) startPrepareTimer() {
+	time.Sleep(time.Millisecond * time.Duration(bz.prepareTimeout))
+	bz.prepareTimeoutChan <- true
+}
+
+// handlePrepareTimeout is called on the root when the prepare timeout
+// expires. The children that didn't respond yet are left out with their
+// subtrees, and the root responds with the responses it has.
+func (bz *ByzCoin) handlePrepareTimeout() error {
+	if bz.prepareDone {
+		return nil
+	}
+	bz.tprMut.Lock()
+	var children []*onet.TreeNode
+	for _, tn := range bz.children {
+		if _, ok := bz.tempPrepareResponse[tn.ID]; ok {
+			children = append(children, tn)
+			continue
+		}
+		log.Lvl2(bz.Name(), "child", tn.Name(), "didn't respond in time")
+		bz.timedOut++
+		bz.lateExceptions(tn)
+	}
+	bz.children = children
+	bz.tprMut.Unlock()
+	if bz.timedOut == 0 {
+		return nil
+	}
+	monitor.RecordSingleMeasure("prepare_timeout", float64(bz.timedOut))
+	return bz.respondPrepare()
+}
+
+// lateExceptions adds the nodes of the subtree of the child tn as
+// exceptions of both rounds, with the commitments of the subtree.
+func (bz *ByzCoin) lateExceptions(tn *onet.TreeNode) {
+	bz.tpcMut.Lock()
+	prepare := bz.subtreeCommit(bz.tempPrepareCommit[tn.ID])
+	bz.tpcMut.Unlock()
+	bz.tccMut.Lock()
+	commit := bz.subtreeCommit(bz.tempCommitCommit[tn.ID])
+	bz.tccMut.Unlock()
+	for _, n := range bz.subtree(tn) {
+		bz.latePrepareExceptions = append(bz.latePrepareExceptions,
+			cosi.Exception{Public: n.ServerIdentity.Public, Commitment: prepare})
+		bz.lateCommitExceptions = append(bz.lateCommitExceptions,
+			cosi.Exception{Public: n.ServerIdentity.Public, Commitment: commit})
+		// the commitment of the child is the one of its whole subtree
+		prepare = bz.suite.Point().Null()
+		commit = bz.suite.Point().Null()
+	}
+}
+
+// subtreeCommit returns the commitment of a child and of its subtree, the
+// null point if it didn't commit.
+func (bz *ByzCoin) subtreeCommit(c *cosi.Commitment) abstract.Point {
+	sum := bz.suite.Point().Null()
+	if c == nil {
+		return sum
+	}
+	if c.Commitment != nil {
+		sum.Add(sum, c.Commitment)
+	}
+	if c.ChildrenCommit != nil {
+		sum.Add(sum, c.ChildrenCommit)
+	}
+	return sum
+}
+
+// subtree returns tn and the nodes that respond through it: its descendants
+// in the tree, and the members of its group after it if it took over the
+// group of a failed leader, see handleReassign.
+func (bz *ByzCoin) subtree(tn *onet.TreeNode) []*onet.TreeNode {
+	var nodes []*onet.TreeNode
+	var add func(*onet.TreeNode)
+	add = func(n *onet.TreeNode) {
+		nodes = append(nodes, n)
+		for _, c := range n.Children {
+			add(c)
+		}
+	}
+	if !bz.leaders[tn.ID] {
+		add(tn)
+		return nodes
+	}
+	group := tn.Parent.Children
+	for i := range group {
+		if group[i].ID.Equal(tn.ID) {
+			for _, m := range group[i:] {
+				add(m)
+			}
+		}
+	}
+	return nodes
+}
+
+// abort ends the round of the root without a signature, and hands its
+// transactions to the callback of RegisterOnAbort.
+func (bz *ByzCoin) abort() error {
+	log.Lvl2(bz.Name(), "Not enough responses in time, aborting the round")
+	bz.aborted = true
+	if bz.onAbort != nil {
+		bz.onAbort(bz.transactions)
+	}
+	bz.Done()
+	return nil
+}
@@ -0,0 +1,66 @@
+package byzcoin
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+)
+
+// useSlowCheck makes the first node to verify the block after the root wait
+// until release is closed.
+func useSlowCheck(t *testing.T, release chan bool) {
+	var mutex sync.Mutex
+	calls := 0
+	blockchain.RegisterCheck("slow", func(b *blockchain.TrBlock) error {
+		mutex.Lock()
+		calls++
+		slow := calls == 2
+		mutex.Unlock()
+		if slow {
+			<-release
+		}
+		return nil
+	})
+	require.Nil(t, UseChecks("slow"))
+}
+
+func TestPrepareTimeout(t *testing.T) {
+	defer UseChecks()
+	for _, groupSize := range []int{0, 3} {
+		release := make(chan bool)
+		useSlowCheck(t, release)
+		local := onet.NewLocalTest()
+		_, roster, tree := local.GenBigTree(10, 10, 2, true)
+		if groupSize > 0 {
+			tree = NewByzCoinXTree(roster, groupSize)
+			local.Overlays[roster.List[0].ID].RegisterTree(tree)
+		}
+		var aborted []blkparser.Tx
+		txs := testTxs(0, 3)
+		bz, sig := runRound(t, local, tree, txs, func(bz *ByzCoin) {
+			bz.SetPrepareTimeout(200)
+			bz.RegisterOnAbort(func(txs []blkparser.Tx) { aborted = txs })
+		})
+		assert.Equal(t, 1, bz.TimedOut())
+		if groupSize == 0 {
+			// half of the tree is left out, so the root aborts the round
+			assert.Nil(t, sig)
+			assert.True(t, bz.Aborted())
+			assert.Equal(t, txs, aborted)
+		} else {
+			// only the group of the slow node is left out
+			require.NotNil(t, sig)
+			assert.Nil(t, verifyBlockSignature(bz.Suite(), bz.Roster().Publics(), sig))
+			assert.Equal(t, 7, sig.Sig.Mask.Count())
+			assert.False(t, bz.Aborted())
+			assert.Nil(t, aborted)
+		}
+		close(release)
+		local.CloseAll()
+	}
+}
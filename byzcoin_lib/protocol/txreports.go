@@ -0,0 +1,204 @@
+package byzcoin
+
+import (
+	"errors"
+	"math/bits"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// With the reports of the transactions, see SetTxReports, the root only
+// checks the rules of the chain on its block before it proposes it, see
+// UsePolicy. The nodes don't refuse a block because of some invalid
+// transactions: they report them in a mask in the response of the prepare
+// round, the masks of the children adding up on the way to the root. The root then drops the transactions the nodes reported
+// from the block, and the commit round signs the block of the others: the
+// challenge of the commit round holds the mask, so that the nodes drop the
+// same transactions, and they refuse to sign if the root kept one they
+// found invalid. A node only refuses the whole block if its header or all
+// its transactions are invalid.
+
+// TxMask is the bitmap of the transactions of a block: transaction i is bit
+// i%8 of byte i/8.
+type TxMask []byte
+
+// newTxMask returns the mask of a block of n transactions without any.
+func newTxMask(n int) TxMask {
+	return make(TxMask, (n+7)/8)
+}
+
+// set adds the i-th transaction.
+func (m TxMask) set(i int) {
+	if i >= 0 && i < 8*len(m) {
+		m[i/8] |= 1 << uint(i%8)
+	}
+}
+
+// Has tells whether the i-th transaction is in the mask.
+func (m TxMask) Has(i int) bool {
+	return i >= 0 && i < 8*len(m) && m[i/8]&(1<<uint(i%8)) != 0
+}
+
+// Count returns the number of transactions in the mask.
+func (m TxMask) Count() int {
+	n := 0
+	for _, b := range m {
+		n += bits.OnesCount8(b)
+	}
+	return n
+}
+
+// add adds the transactions of o, of a block of the same size.
+func (m TxMask) add(o TxMask) {
+	for i := 0; i < len(m) && i < len(o); i++ {
+		m[i] |= o[i]
+	}
+}
+
+// covers tells whether all the transactions of o are in the mask.
+func (m TxMask) covers(o TxMask) bool {
+	for i := range o {
+		if i >= len(m) {
+			if o[i] != 0 {
+				return false
+			}
+			continue
+		}
+		if o[i]&^m[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// SetTxReports makes the nodes report the invalid transactions of the block
+// of the root instead of refusing it, so that the root drops them and
+// commits the others in the same round. It has to be called on the root
+// before Start, the challenge of the prepare round tells the other nodes.
+func (bz *ByzCoin) SetTxReports(on bool) {
+	bz.reportTxs = on
+}
+
+// Stripped returns how many transactions the root dropped from its block
+// because the nodes reported them invalid.
+func (bz *ByzCoin) Stripped() int {
+	return bz.stripped.Count()
+}
+
+// verifyTransactions verifies the block as VerifyBlock does, but goes on
+// after the invalid transactions and keeps their mask for the response of
+// the prepare round.
+func (bz *ByzCoin) verifyTransactions(block *blockchain.TrBlock, lastBlock, lastKeyBlock string) {
+	simulateVerification(block)
+	invalid, err := invalidTransactions(block, lastBlock, lastKeyBlock)
+	if err == nil && invalid.Count() == len(block.Txs) {
+		err = errors.New("no valid transaction")
+	}
+	if err != nil {
+		log.Lvl2(bz.Name(), "Invalid block:", err)
+		bz.verifyBlockChan <- false
+		return
+	}
+	if n := invalid.Count(); n > 0 {
+		log.Lvl2(bz.Name(), "Reporting", n, "invalid transactions")
+	}
+	bz.invalidTxs = invalid
+	bz.verifyBlockChan <- true
+}
+
+// invalidTransactions returns the mask of the transactions of the block the
+// checks of UseChecks find invalid, or an error if the block is invalid for
+// another reason. As the checks stop at the first invalid transaction, it
+// checks the block again without it until they pass.
+func invalidTransactions(block *blockchain.TrBlock, lastBlock, lastKeyBlock string) (TxMask, error) {
+	invalid := newTxMask(len(block.Txs))
+	checked := block
+	for {
+		err := checkBlock(checked, lastBlock, lastKeyBlock)
+		var txErr *blockchain.TxError
+		if err == nil {
+			return invalid, nil
+		}
+		if !errors.As(err, &txErr) {
+			return nil, err
+		}
+		i := txIndex(block.Txs, invalid, txErr.Hash)
+		if i < 0 {
+			return nil, err
+		}
+		invalid.set(i)
+		if invalid.Count() == len(block.Txs) {
+			return invalid, nil
+		}
+		checked = stripTransactions(block, invalid)
+	}
+}
+
+// txIndex returns the index of the first transaction of the hash out of the
+// mask, -1 if there is none.
+func txIndex(txs []blkparser.Tx, mask TxMask, hash string) int {
+	for i, tx := range txs {
+		if tx.Hash == hash && !mask.Has(i) {
+			return i
+		}
+	}
+	return -1
+}
+
+// stripTransactions returns the block without the transactions of the
+// mask, extending the same blocks.
+func stripTransactions(block *blockchain.TrBlock, mask TxMask) *blockchain.TrBlock {
+	txs := make([]blkparser.Tx, 0, len(block.Txs))
+	for i, tx := range block.Txs {
+		if !mask.Has(i) {
+			txs = append(txs, tx)
+		}
+	}
+	return newBlock(txs, block.Header.Parent, block.Header.ParentKey)
+}
+
+// reportedTxs returns the mask of the invalid transactions we and the
+// children reported.
+func (bz *ByzCoin) reportedTxs(children map[onet.TreeNodeID]*Response) TxMask {
+	if bz.tempBlock == nil {
+		return nil
+	}
+	reported := newTxMask(len(bz.tempBlock.Txs))
+	reported.add(bz.invalidTxs)
+	for _, r := range children {
+		reported.add(r.Invalid)
+	}
+	return reported
+}
+
+// stripInvalid drops the transactions the nodes reported from the block of
+// the root, unless none would be left: the nodes then refuse to commit it.
+func (bz *ByzCoin) stripInvalid(reported TxMask) {
+	n := reported.Count()
+	if n == 0 || n >= len(bz.tempBlock.Txs) {
+		return
+	}
+	log.Lvl2(bz.Name(), "Dropping", n, "reported transactions")
+	bz.stripped = reported
+	bz.tempBlock = stripTransactions(bz.tempBlock, reported)
+}
+
+// applyReports drops the transactions the root stripped from our block, and
+// tells whether it dropped all the ones we found invalid.
+func (bz *ByzCoin) applyReports(stripped TxMask) bool {
+	if !stripped.covers(bz.invalidTxs) {
+		return false
+	}
+	if bz.tempBlock == nil || stripped.Count() == 0 {
+		return true
+	}
+	if len(stripped) != (len(bz.tempBlock.Txs)+7)/8 ||
+		stripped.Count() >= len(bz.tempBlock.Txs) {
+		return false
+	}
+	bz.tempBlock = stripTransactions(bz.tempBlock, stripped)
+	return true
+}
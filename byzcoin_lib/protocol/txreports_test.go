@@ -0,0 +1,86 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+)
+
+func TestTxMask(t *testing.T) {
+	m := newTxMask(10)
+	assert.Equal(t, 2, len(m))
+	for _, i := range []int{0, 3, 9, 16, -1} {
+		m.set(i)
+	}
+	assert.Equal(t, 3, m.Count())
+	assert.True(t, m.Has(9))
+	assert.False(t, m.Has(1))
+	assert.False(t, m.Has(10))
+
+	o := newTxMask(10)
+	o.set(1)
+	assert.False(t, m.covers(o))
+	m.add(o)
+	assert.True(t, m.covers(o))
+	assert.Equal(t, 4, m.Count())
+	assert.True(t, m.covers(nil))
+	assert.False(t, TxMask{1}.covers(TxMask{1, 1}))
+}
+
+func TestInvalidTransactions(t *testing.T) {
+	defer UseChecks()
+	txs := testTxs(0, 5)
+	useBadCheck(t, txs[1], txs[3])
+	block := newBlock(txs, "last", "")
+	invalid, err := invalidTransactions(block, "last", "")
+	require.Nil(t, err)
+	assert.Equal(t, TxMask{0x0a}, invalid)
+	stripped := stripTransactions(block, invalid)
+	require.Equal(t, 3, len(stripped.Txs))
+	for i, tx := range stripped.Txs {
+		assert.Equal(t, txs[2*i].Hash, tx.Hash)
+	}
+	assert.Equal(t, "last", stripped.Header.Parent)
+	assert.Nil(t, checkBlock(stripped, "last", ""))
+
+	// all of them
+	invalid, err = invalidTransactions(newBlock(txs[3:4], "last", ""), "last", "")
+	require.Nil(t, err)
+	assert.Equal(t, 1, invalid.Count())
+	// a block extending another one
+	_, err = invalidTransactions(block, "other", "")
+	assert.NotNil(t, err)
+}
+
+func TestTxReports(t *testing.T) {
+	defer UseChecks()
+	txs := testTxs(0, 5)
+	bad := useBadCheck(t, txs[1], txs[3])
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, _, tree := local.GenBigTree(7, 7, 2, true)
+	reports := func(bz *ByzCoin) { bz.SetTxReports(true) }
+
+	// the nodes report the invalid transactions, and the root drops them in
+	// the same round
+	bz, sig := runRound(t, local, tree, txs, reports)
+	require.NotNil(t, sig)
+	assert.Nil(t, verifyBlockSignature(bz.Suite(), bz.Roster().Publics(), sig))
+	assert.Equal(t, 0, bz.Retries())
+	assert.Equal(t, 2, bz.Stripped())
+	require.Equal(t, 3, len(sig.Block.Txs))
+	for _, tx := range sig.Block.Txs {
+		assert.False(t, bad[tx.Hash])
+	}
+
+	// without any invalid one, the next block is signed as it is
+	last := sig.Block.HeaderHash
+	bz, sig = runRound(t, local, tree, testTxs(10, 3), reports,
+		func(bz *ByzCoin) { bz.lastBlock = last })
+	require.NotNil(t, sig)
+	assert.Nil(t, verifyBlockSignature(bz.Suite(), bz.Roster().Publics(), sig))
+	assert.Equal(t, 0, bz.Stripped())
+	assert.Equal(t, 3, len(sig.Block.Txs))
+}
@@ -0,0 +1,170 @@
+package byzcoin
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/simul/monitor"
+)
+
+// walletRetry is how long a simulated client with an empty wallet waits for
+// a payment before trying again.
+const walletRetry = time.Millisecond
+
+// WalletClients are simulated users, each with a wallet funded by a genesis
+// allocation, paying each other. Every transaction spends outputs its sender
+// owns, often the change or the payments of the previous ones, so that the
+// blocks hold chains of dependent transactions like the ones of real users,
+// instead of unrelated transactions of the Bitcoin blocks.
+type WalletClients struct {
+	wallets []*blockchain.Wallet
+	genesis blockchain.Transaction
+	// sent is whether the genesis allocation has been sent
+	sent   bool
+	seed   int64
+	rounds int64
+}
+
+// NewWalletClients returns n clients whose wallets get outputs outputs of
+// the value from the genesis allocation. The seed derives their keys and
+// their payments.
+func NewWalletClients(n, outputs int, value uint64, seed int64) *WalletClients {
+	wallets := blockchain.NewWallets(n, seed)
+	return &WalletClients{
+		wallets: wallets,
+		genesis: blockchain.GenesisAllocation(wallets, outputs, value),
+		seed:    seed,
+	}
+}
+
+// Wallets returns the wallets of the clients.
+func (w *WalletClients) Wallets() []*blockchain.Wallet {
+	return w.wallets
+}
+
+// Submit makes the clients send n transactions through c concurrently, the
+// genesis allocation first if it has not been sent yet. Every client pays a
+// random value to a random other client, which can spend the payment once it
+// has been submitted. The transactions of a network client are waited for by
+// the next WaitConfirmations, as the ones of StartClientSimulation.
+func (w *WalletClients) Submit(c *Client, n int) error {
+	if len(w.wallets) < 2 {
+		return errors.New("need at least two clients to pay each other")
+	}
+	if n <= 0 {
+		return nil
+	}
+	var submit *monitor.TimeMeasure
+	if c.net != nil {
+		submit = monitor.NewTimeMeasure("client_submit")
+		if c.confirm == nil {
+			c.confirm = monitor.NewTimeMeasure("client_confirm")
+		}
+	}
+	var hashes []string
+	if !w.sent {
+		if err := submitRetry(c, w.genesis); err != nil {
+			return err
+		}
+		w.sent = true
+		hashes = append(hashes, w.genesis.Hash())
+		n--
+	}
+
+	var mutex sync.Mutex
+	var firstErr error
+	remaining := n
+	// claim takes one of the remaining transactions, false if there are
+	// none left or a client failed
+	claim := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if remaining == 0 || firstErr != nil {
+			return false
+		}
+		remaining--
+		return true
+	}
+	var clients sync.WaitGroup
+	for i := range w.wallets {
+		clients.Add(1)
+		rng := rand.New(rand.NewSource(w.seed + w.rounds*int64(len(w.wallets)) +
+			int64(i)))
+		go func(i int) {
+			defer clients.Done()
+			for claim() {
+				tx, to, err := w.pay(rng, i)
+				if err == blockchain.ErrInsufficientFunds {
+					mutex.Lock()
+					remaining++
+					mutex.Unlock()
+					time.Sleep(walletRetry)
+					continue
+				}
+				if err == nil {
+					err = submitRetry(c, tx)
+				}
+				mutex.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+				} else {
+					hashes = append(hashes, tx.Hash())
+				}
+				mutex.Unlock()
+				if err != nil {
+					return
+				}
+				w.wallets[to].Receive(tx)
+			}
+		}(i)
+	}
+	clients.Wait()
+	w.rounds++
+	if firstErr != nil {
+		return firstErr
+	}
+	log.Lvl3("Clients sent", len(hashes), "transactions")
+	if submit != nil {
+		submit.Record()
+		c.mutex.Lock()
+		c.submitted = append(c.submitted, hashes...)
+		c.mutex.Unlock()
+	}
+	return nil
+}
+
+// submitRetry submits the transaction with c and the fees of UseFees, again
+// as long as the servers ask for it, since the next payments of the client
+// may spend its outputs.
+func submitRetry(c *Client, tx blockchain.Transaction) error {
+	tx = c.withFee(tx)
+	return retry(1, func(int) (int, error) {
+		if err := c.submitOne(tx, false); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	})
+}
+
+// pay returns a payment of the wallet i to a random other wallet, of up to
+// half of its balance, and the index of the recipient.
+func (w *WalletClients) pay(rng *rand.Rand, i int) (blockchain.Transaction, int, error) {
+	to := rng.Intn(len(w.wallets) - 1)
+	if to >= i {
+		to++
+	}
+	balance := w.wallets[i].Balance()
+	if balance == 0 {
+		return nil, to, blockchain.ErrInsufficientFunds
+	}
+	value := 1 + uint64(rng.Int63n(int64(balance/2)+1))
+	tx, err := w.wallets[i].Pay(blockchain.Payment{
+		PkScript: w.wallets[to].PkScript(), Value: value})
+	return tx, to, err
+}
@@ -0,0 +1,39 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalletClients(t *testing.T) {
+	assert.NotNil(t, NewWalletClients(1, 1, 10, 1).Submit(NewClient(&testServer{}), 1))
+	w := NewWalletClients(4, 2, 100, 1)
+	srv := &testServer{}
+	c := NewClient(srv)
+	require.Nil(t, w.Submit(c, 20))
+	require.Nil(t, w.Submit(c, 10))
+	require.Equal(t, 30, len(srv.txs))
+	// the genesis allocation is sent once, and every payment spends outputs
+	// of transactions sent before it, signed by their owner
+	assert.Empty(t, srv.txs[0].Spends())
+	for i := 1; i < len(srv.txs); i++ {
+		assert.Nil(t, blockchain.VerifySpend(srv.txs[i], func(hash string, vout uint32) ([]byte, uint64, bool) {
+			for _, prev := range srv.txs[:i] {
+				if prev.Hash() == hash {
+					return blockchain.ToTx(prev).TxOuts[vout].Pkscript,
+						prev.Creates()[vout], true
+				}
+			}
+			return nil, 0, false
+		}), "transaction %d", i)
+	}
+	// the clients pay each other without creating value
+	var total uint64
+	for _, wallet := range w.Wallets() {
+		total += wallet.Balance()
+	}
+	assert.Equal(t, uint64(4*2*100), total)
+}
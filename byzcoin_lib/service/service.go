@@ -29,10 +29,14 @@ const BNGBFT2 = "Byzcoin_NG_BFT_AUDIT"
 
 const ReadFirstNBlocks = 66000
 
+// utxosID is the key of the unspent outputs in the storage of the service.
+const utxosID = "UTXOs"
+
 func init() {
 	onet.RegisterNewService(ServiceName, newByzcoinNGService)
 	network.RegisterMessage(&bftcosi_special.MicroBlock{})
 	network.RegisterMessage(&Audit{})
+	network.RegisterMessage(&blockchain.UTXOSet{})
 	onet.GlobalProtocolRegister(BNGBFT, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
 		return bftcosi_special.NewBFTCoSiProtocol(n, nil)
 	})
@@ -69,6 +73,11 @@ type Service struct {
 	expectedpriority int
 
 	Transaction *[]blkparser.Tx
+
+	// UTXOs are the unspent outputs of the shard, nil if the blocks are not
+	// checked for double spends
+	UTXOs        *blockchain.UTXOSet
+	persistUTXOs bool
 }
 
 var magicNum = [4]byte{0xF9, 0xBE, 0xB4, 0xD9}
@@ -109,6 +118,11 @@ func (s *Service) StartEpoch(priority int, size int) (*bftcosi_special.MicroBloc
 		return nil, err
 	}
 	s.currentpriority = priority
+	if s.UTXOs != nil {
+		// the transactions can only be spent once, so the next block takes
+		// the next ones
+		*s.Transaction = (*s.Transaction)[len(block.Txs):]
+	}
 	s.TRMutex.Unlock()
 
 	block.Roster = s.Roster
@@ -131,6 +145,49 @@ func (s *Service) StartEpoch(priority int, size int) (*bftcosi_special.MicroBloc
 
 }
 
+// CheckUTXOs makes the service keep the unspent outputs of the shard and
+// reject blocks spending outputs that are already spent, or reserved by a
+// concurrent block. If persist is set, the unspent outputs are saved after
+// every block and loaded back when the service starts again.
+func (s *Service) CheckUTXOs(shard, shards int, persist bool) error {
+	s.UTXOs = blockchain.NewUTXOSet(shard, shards)
+	s.persistUTXOs = persist
+	if !persist || !s.DataAvailable(utxosID) {
+		return nil
+	}
+	msg, err := s.Load(utxosID)
+	if err != nil {
+		return err
+	}
+	utxos, ok := msg.(*blockchain.UTXOSet)
+	if !ok {
+		return errors.New("Data of wrong type")
+	}
+	if utxos.Shard != shard || utxos.Shards != shards {
+		return errors.New("saved unspent outputs are of another shard")
+	}
+	s.UTXOs = utxos
+	return nil
+}
+
+// SeedUTXOs makes the outputs the transactions read by StartSimul spend
+// without creating them unspent, as the genesis of the chain of the blocks
+// made of them, see blockchain.GenesisOutputs. Every node has to call it after
+// StartSimul and CheckUTXOs.
+func (s *Service) SeedUTXOs() error {
+	if s.UTXOs == nil {
+		return errors.New("unspent outputs are not checked")
+	}
+	if s.Transaction == nil {
+		return errors.New("no transactions read")
+	}
+	s.TRMutex.Lock()
+	txs := blockchain.BitcoinTxs(*s.Transaction)
+	s.TRMutex.Unlock()
+	s.UTXOs.Seed(blockchain.GenesisOutputs(txs))
+	return nil
+}
+
 // signNewBlock should start a BFT-signature on the newest block
 // it is invoked by the leader of the epoch
 func (s *Service) signNewBlock(block *bftcosi_special.MicroBlock) (*bftcosi_special.MicroBlock, error) {
@@ -143,15 +200,23 @@ func (s *Service) signNewBlock(block *bftcosi_special.MicroBlock) (*bftcosi_spec
 
 		// Sign it
 		err := s.StartBFTSignature(block)
-		if err != nil {
-			return nil, err
+		if err == nil {
+			// Verify it
+			err = block.BlockSig.Verify(network.Suite, s.Roster.Publics())
 		}
-		// Verify it
-		err = block.BlockSig.Verify(network.Suite, s.Roster.Publics())
 		if err != nil {
+			if s.UTXOs != nil {
+				s.UTXOs.Release(block.HeaderHash)
+			}
 			return nil, err
 		}
-		//s.startPropagation(block)
+		if s.UTXOs != nil {
+			// all validators need the block to update their unspent
+			// outputs
+			if err := s.startPropagation(block); err != nil {
+				return nil, err
+			}
+		}
 		s.lastBlock = block.HeaderHash
 
 		return block, nil
@@ -198,7 +263,7 @@ func (s *Service) StartAuditSignature(aud *Audit) error {
 	select {
 	case <-done:
 		aud.Sig = root.Signature()
-		if len(aud.Sig.Exceptions) != 0 {
+		if aud.Sig.Refused(len(el.List)) != 0 {
 			return errors.New("Not everybody signed off the new block")
 		}
 		if err := aud.Sig.Verify(network.Suite, el.Publics()); err != nil {
@@ -247,7 +312,7 @@ func (s *Service) StartBFTSignature(block *bftcosi_special.MicroBlock) error {
 	select {
 	case <-done:
 		block.BlockSig = root.Signature()
-		if len(block.BlockSig.Exceptions) != 0 {
+		if block.BlockSig.Refused(len(el.List)) != 0 {
 			return errors.New("Not everybody signed off the new block")
 		}
 		if err := block.BlockSig.Verify(network.Suite, el.Publics()); err != nil {
@@ -385,6 +450,12 @@ func (s *Service) bftVerify(msg []byte, data []byte) bool {
 	//verified := block.Header.Parent == s.lastBlock //&& block.Header.ParentKey == s.lastKeyBlock
 	verified = verified && block.Header.MerkleRoot == blockchain.HashRootTransactions(block.TransactionList)
 	verified = verified && block.HeaderHash == blockchain.HashHeader(block.Header)
+	if verified && s.UTXOs != nil {
+		if err := s.UTXOs.Reserve(block.HeaderHash, block.Txs); err != nil {
+			log.Lvl2(s.ServerIdentity(), "refuses block:", err)
+			verified = false
+		}
+	}
 	// notify it
 	log.Lvl3("Verification of the block done =", verified)
 	if !verified {
@@ -423,6 +494,17 @@ func (s *Service) PropagateBZBlock(msg network.Message) {
 		return
 	}
 	s.lastBlock = sb.HeaderHash
+	if s.UTXOs != nil {
+		if err := s.UTXOs.Apply(sb.HeaderHash, sb.Txs); err != nil {
+			log.Error(s.ServerIdentity(), "couldn't apply block:", err)
+			return
+		}
+		if s.persistUTXOs {
+			if err := s.Save(utxosID, s.UTXOs.Copy()); err != nil {
+				log.Error("Couldn't save unspent outputs:", err)
+			}
+		}
+	}
 	//TODO: Handle Key blocks
 	log.Lvlf3("Stored skip block %+v in %x", *sb, s.Context.ServerIdentity().ID[0:8])
 }
@@ -28,6 +28,11 @@ type simulation struct {
 	Blocksize int
 	lock      sync.Mutex
 	Threads   int
+	// CheckUTXOs makes the nodes reject the blocks spending outputs that
+	// are spent or reserved by a concurrent block, starting from the
+	// outputs the transactions of the simulation spend without creating
+	// them. Every block then takes new transactions.
+	CheckUTXOs bool
 }
 
 // NewSimulation returns the new simulation, where all fields are
@@ -58,6 +63,27 @@ func (e *simulation) Setup(dir string, hosts []string) (
 	return sc, nil
 }
 
+// Node prepares the service of every node to check the unspent outputs of
+// the blocks, if CheckUTXOs is set.
+func (e *simulation) Node(config *onet.SimulationConfig) error {
+	if e.CheckUTXOs {
+		service, ok := config.GetService(byzcoin_ng.ServiceName).(*byzcoin_ng.Service)
+		if service == nil || !ok {
+			log.Fatal("Didn't find service", byzcoin_ng.ServiceName)
+		}
+		if err := service.StartSimul(blockchain.GetBlockDir(), e.Blocksize, config.Roster); err != nil {
+			return err
+		}
+		if err := service.CheckUTXOs(0, 1, false); err != nil {
+			return err
+		}
+		if err := service.SeedUTXOs(); err != nil {
+			return err
+		}
+	}
+	return e.SimulationBFTree.Node(config)
+}
+
 // Run is used on the destination machines and runs a number of
 // rounds
 func (e *simulation) Run(config *onet.SimulationConfig) error {
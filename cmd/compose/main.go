@@ -0,0 +1,524 @@
+// Compose deploys the OmniLedger service on a laptop with docker-compose,
+// one container per conode, without the localhost simulation of onet or
+// DeterLab:
+//
+//	compose generate -hosts 8 -shards 2 -o deploy
+//	cd deploy && docker compose up
+//
+// Generate writes to the output directory the keys of every conode, the
+// group file of every shard, the signed first state blocks of the shards,
+// some transactions to submit with olcli, and the docker-compose.yml
+// running the conodes, a one-shot container starting their ledgers and the
+// HTTP gateway on port 8080. The containers are built from the image of
+// cmd/compose/Dockerfile, and the block data of the repository is mounted
+// in the blocks directory of every conode.
+//
+// Setup is run by the one-shot container: it waits for the conodes and
+// sends them the rosters and the first state blocks of the shards.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/service"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/state"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/workload"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	crypconf "gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/app"
+	"gopkg.in/dedis/onet.v1/crypto"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// The files of the output directory.
+const (
+	composeFile = "docker-compose.yml"
+	genesisFile = "genesis.json"
+	privateFile = "private.toml"
+	txsDir      = "txs"
+)
+
+func main() {
+	cliApp := cli.NewApp()
+	cliApp.Name = "compose"
+	cliApp.Usage = "Deploy the OmniLedger service with docker-compose"
+	cliApp.Flags = []cli.Flag{
+		cli.IntFlag{
+			Name:  "debug, d",
+			Value: 0,
+			Usage: "debug level",
+		},
+	}
+	cliApp.Commands = []cli.Command{
+		{
+			Name:   "generate",
+			Usage:  "write the configuration and the docker-compose.yml of the conodes",
+			Action: generate,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output, o",
+					Value: "deploy",
+					Usage: "directory to write the deployment to",
+				},
+				cli.StringFlag{
+					Name:  "root",
+					Value: ".",
+					Usage: "root of the repository, to build the image from",
+				},
+				cli.IntFlag{
+					Name:  "hosts",
+					Value: 8,
+					Usage: "number of conodes",
+				},
+				cli.IntFlag{
+					Name:  "shards",
+					Value: 2,
+					Usage: "number of shards the conodes are split into",
+				},
+				cli.IntFlag{
+					Name:  "port",
+					Value: 7002,
+					Usage: "port of the conodes in their container",
+				},
+				cli.IntFlag{
+					Name:  "blocksize",
+					Value: 1,
+					Usage: "number of transactions of a block",
+				},
+				cli.IntFlag{
+					Name:  "outputs",
+					Value: 100,
+					Usage: "number of unspent outputs of every shard at the start",
+				},
+				cli.IntFlag{
+					Name:  "txs",
+					Value: 10,
+					Usage: "number of transactions written for olcli",
+				},
+				cli.Int64Flag{
+					Name:  "seed",
+					Value: 1,
+					Usage: "seed of the outputs and the transactions",
+				},
+				cli.IntFlag{
+					Name:  "conode-debug",
+					Value: 1,
+					Usage: "debug level of the conodes",
+				},
+			},
+		},
+		{
+			Name:      "setup",
+			Usage:     "start the ledgers of the shards of running conodes",
+			ArgsUsage: "shard0.toml shard1.toml ...",
+			Action:    setup,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "genesis",
+					Value: genesisFile,
+					Usage: "first state blocks of the shards",
+				},
+				cli.IntFlag{
+					Name:  "blocksize",
+					Value: 1,
+					Usage: "number of transactions of a block",
+				},
+				cli.DurationFlag{
+					Name:  "wait",
+					Value: time.Minute,
+					Usage: "how long to wait for the conodes to start",
+				},
+			},
+		},
+	}
+	cliApp.Before = func(c *cli.Context) error {
+		log.SetDebugVisible(c.Int("debug"))
+		return nil
+	}
+	log.ErrFatal(cliApp.Run(os.Args))
+}
+
+// deployment are the conodes of a deployment split into shards.
+type deployment struct {
+	// Shards are the names of the conodes of every shard
+	Shards [][]string
+	// Rosters are the rosters of the shards
+	Rosters []*onet.Roster
+	// Privates are the private keys of the conodes, by address
+	Privates map[network.Address]abstract.Scalar
+}
+
+// newDeployment returns hosts conodes named conode-<i>, listening on port
+// and split round-robin into shards, with new key pairs.
+func newDeployment(hosts, shards, port int) (*deployment, error) {
+	if shards <= 0 || hosts < shards {
+		return nil, errors.New("need at least one conode per shard")
+	}
+	d := &deployment{
+		Shards:   make([][]string, shards),
+		Privates: make(map[network.Address]abstract.Scalar),
+	}
+	lists := make([][]*network.ServerIdentity, shards)
+	for i := 0; i < hosts; i++ {
+		name := fmt.Sprintf("conode-%d", i)
+		kp := crypconf.NewKeyPair(network.Suite)
+		address := network.NewTCPAddress(fmt.Sprintf("%s:%d", name, port))
+		si := network.NewServerIdentity(kp.Public, address)
+		si.Description = name
+		lists[i%shards] = append(lists[i%shards], si)
+		d.Shards[i%shards] = append(d.Shards[i%shards], name)
+		d.Privates[address] = kp.Secret
+	}
+	for _, list := range lists {
+		d.Rosters = append(d.Rosters, onet.NewRoster(list))
+	}
+	return d, nil
+}
+
+// genesisBlocks returns the first state block of every shard holding its
+// outputs, signed by all its members.
+func genesisBlocks(rosters []*onet.Roster, privates map[network.Address]abstract.Scalar,
+	utxos func(shard int) map[string]int64) ([]*state.Block, error) {
+	var blocks []*state.Block
+	for shard, roster := range rosters {
+		b, err := state.NewBlock(shard, 0, nil, utxos(shard))
+		if err != nil {
+			return nil, err
+		}
+		for i, si := range roster.List {
+			private := privates[si.Address]
+			if private == nil {
+				return nil, fmt.Errorf("no private key of %s", si.Address)
+			}
+			if err := b.Sign(network.Suite, i, private); err != nil {
+				return nil, err
+			}
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
+
+// config describes the deployment to generate.
+type config struct {
+	// Output is the directory the deployment is written to
+	Output string
+	// Root is the root of the repository
+	Root          string
+	Hosts, Shards int
+	// Port is the port of the conodes in their container
+	Port int
+	// Blocksize is the number of transactions of a block
+	Blocksize int
+	// Outputs is the number of unspent outputs of every shard at the start
+	Outputs int
+	// Txs is the number of transactions written for olcli
+	Txs  int
+	Seed int64
+	// Debug is the debug level of the conodes
+	Debug int
+}
+
+// generate writes the deployment given by the flags.
+func generate(c *cli.Context) error {
+	return writeDeployment(config{
+		Output:    c.String("output"),
+		Root:      c.String("root"),
+		Hosts:     c.Int("hosts"),
+		Shards:    c.Int("shards"),
+		Port:      c.Int("port"),
+		Blocksize: c.Int("blocksize"),
+		Outputs:   c.Int("outputs"),
+		Txs:       c.Int("txs"),
+		Seed:      c.Int64("seed"),
+		Debug:     c.Int("conode-debug"),
+	})
+}
+
+// writeDeployment writes the configuration of the conodes, the group files
+// of the shards, their first state blocks, the transactions and the
+// docker-compose.yml to the output directory.
+func writeDeployment(conf config) error {
+	out := conf.Output
+	d, err := newDeployment(conf.Hosts, conf.Shards, conf.Port)
+	if err != nil {
+		return err
+	}
+	gen, err := workload.New(workload.Config{
+		Shards:      conf.Shards,
+		Outputs:     conf.Outputs,
+		CrossShard:  crossShard(conf.Shards),
+		InputShards: 2,
+		Seed:        conf.Seed,
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(out, txsDir), 0755); err != nil {
+		return err
+	}
+
+	var groups []string
+	for shard, roster := range d.Rosters {
+		var servers []*app.ServerToml
+		for _, si := range roster.List {
+			err := writeConfig(filepath.Join(out, si.Description), si,
+				d.Privates[si.Address])
+			if err != nil {
+				return err
+			}
+			servers = append(servers, app.NewServerToml(network.Suite,
+				si.Public, si.Address, si.Description))
+		}
+		group := fmt.Sprintf("shard%d.toml", shard)
+		if err := app.NewGroupToml(servers...).Save(filepath.Join(out, group)); err != nil {
+			return err
+		}
+		groups = append(groups, group)
+	}
+
+	genesis, err := genesisBlocks(d.Rosters, d.Privates, gen.Genesis)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(filepath.Join(out, genesisFile), genesis); err != nil {
+		return err
+	}
+	txs, err := gen.Block(conf.Txs)
+	if err != nil {
+		return err
+	}
+	for i := range txs {
+		file := filepath.Join(out, txsDir, fmt.Sprintf("tx%d.json", i))
+		if err := writeJSON(file, &txs[i]); err != nil {
+			return err
+		}
+	}
+
+	root, err := relativeRoot(out, conf.Root)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(out, composeFile))
+	if err != nil {
+		return err
+	}
+	err = composeTemplate.Execute(f, composeConfig{
+		Root:      root,
+		Conodes:   len(d.Privates),
+		Shards:    d.Shards,
+		Groups:    strings.Join(groups, " "),
+		Blocksize: conf.Blocksize,
+		Debug:     conf.Debug,
+	})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Info("Wrote the deployment of", len(d.Privates), "conodes in",
+		len(d.Rosters), "shards to", out)
+	return nil
+}
+
+// crossShard returns the share of the cross-shard transactions, half of
+// them if there are several shards.
+func crossShard(shards int) float64 {
+	if shards < 2 {
+		return 0
+	}
+	return 0.5
+}
+
+// writeConfig writes the configuration of the conode to its directory.
+func writeConfig(dir string, si *network.ServerIdentity, private abstract.Scalar) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	privateHex, err := crypto.ScalarToStringHex(network.Suite, private)
+	if err != nil {
+		return err
+	}
+	publicHex, err := crypto.PointToStringHex(network.Suite, si.Public)
+	if err != nil {
+		return err
+	}
+	config := &app.CothorityConfig{
+		Public:      publicHex,
+		Private:     privateHex,
+		Address:     si.Address,
+		Description: si.Description,
+	}
+	return config.Save(filepath.Join(dir, privateFile))
+}
+
+// writeJSON writes v indented to the file.
+func writeJSON(file string, v interface{}) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(buf, '\n'), 0644)
+}
+
+// relativeRoot returns the root of the repository relative to the output
+// directory, as the paths of the docker-compose.yml are.
+func relativeRoot(out, root string) (string, error) {
+	absOut, err := filepath.Abs(out)
+	if err != nil {
+		return "", err
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(absRoot, "go.mod")); err != nil {
+		return "", fmt.Errorf("%s is not the root of the repository", root)
+	}
+	return filepath.Rel(absOut, absRoot)
+}
+
+// composeConfig fills in composeTemplate.
+type composeConfig struct {
+	// Root is the root of the repository relative to the
+	// docker-compose.yml
+	Root    string
+	Conodes int
+	// Shards are the names of the conodes of every shard
+	Shards [][]string
+	// Groups are the group files of the shards, separated by spaces
+	Groups    string
+	Blocksize int
+	Debug     int
+}
+
+var composeTemplate = template.Must(template.New("compose").Parse(
+	`# Generated by "compose generate": {{.Conodes}} conodes of the OmniLedger service
+# in {{len .Shards}} shards.{{range $i, $names := .Shards}}
+#   shard {{$i}}:{{range $names}} {{.}}{{end}}{{end}}
+# Start it with "docker compose up", the gateway listens on localhost:8080.
+x-omniledger: &omniledger
+  image: omniledger
+  build:
+    context: {{.Root}}
+    dockerfile: cmd/compose/Dockerfile
+
+services:
+{{- range $names := .Shards}}{{range $names}}
+  {{.}}:
+    <<: *omniledger
+    command: conode -d {{$.Debug}} server -c /config/private.toml
+    working_dir: /conode
+    volumes:
+      - ./{{.}}:/config:ro
+      - {{$.Root}}/blocks:/conode/blocks:ro
+{{- end}}{{end}}
+  setup:
+    <<: *omniledger
+    command: compose setup -blocksize {{.Blocksize}} {{.Groups}}
+    working_dir: /deploy
+    volumes:
+      - .:/deploy:ro
+    depends_on:
+{{- range $names := .Shards}}{{range $names}}
+      - {{.}}
+{{- end}}{{end}}
+  gateway:
+    <<: *omniledger
+    command: gateway -listen 0.0.0.0:8080 {{.Groups}}
+    working_dir: /deploy
+    volumes:
+      - .:/deploy:ro
+    ports:
+      - "8080:8080"
+    depends_on:
+      setup:
+        condition: service_completed_successfully
+`))
+
+// setup reads the group files of the shards and their first state blocks
+// and starts the ledgers of the conodes.
+func setup(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return errors.New("need the group files of the shards")
+	}
+	var rosters []*onet.Roster
+	for _, file := range c.Args() {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		roster, err := app.ReadGroupToml(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("couldn't read %s: %v", file, err)
+		}
+		rosters = append(rosters, roster)
+	}
+	buf, err := ioutil.ReadFile(c.String("genesis"))
+	if err != nil {
+		return err
+	}
+	var genesis []*state.Block
+	if err := json.Unmarshal(buf, &genesis); err != nil {
+		return err
+	}
+	if err := setupShards(rosters, genesis, c.Int("blocksize"), c.Duration("wait")); err != nil {
+		return err
+	}
+	log.Info("Started the ledgers of", len(rosters), "shards")
+	return nil
+}
+
+// setupShards sends the rosters and the first state blocks of the shards to
+// every conode, retrying until it is reachable or wait passed. The conodes
+// that are already set up are skipped, so that the deployment can be
+// restarted.
+func setupShards(rosters []*onet.Roster, genesis []*state.Block, blockSize int,
+	wait time.Duration) error {
+	if len(rosters) != len(genesis) {
+		return errors.New("need the first state block of every shard")
+	}
+	for shard, b := range genesis {
+		if err := b.VerifySignatures(rosters[shard]); err != nil {
+			return fmt.Errorf("first state block of shard %d: %v", shard, err)
+		}
+	}
+	client := service.NewClient()
+	req := &service.Setup{Rosters: rosters, Genesis: genesis, BlockSize: blockSize}
+	deadline := time.Now().Add(wait)
+	for _, roster := range rosters {
+		for _, si := range roster.List {
+			for {
+				cerr := client.SendProtobuf(si, req, &service.SetupReply{})
+				if cerr == nil || cerr.ErrorCode() == service.ErrorSetup {
+					break
+				}
+				// the errors of the service are final, the ones of
+				// the network are retried
+				if cerr.ErrorCode() >= service.ErrorParameterWrong {
+					return fmt.Errorf("couldn't set up %s: %v", si.Address, cerr)
+				}
+				if time.Now().After(deadline) {
+					return fmt.Errorf("couldn't set up %s: %v", si.Address, cerr)
+				}
+				log.Lvl2("Waiting for", si.Address, ":", cerr)
+				time.Sleep(time.Second)
+			}
+		}
+	}
+	return nil
+}
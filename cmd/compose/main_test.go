@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/service"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/app"
+	"gopkg.in/dedis/onet.v1/crypto"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+func TestGenerate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compose")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	require.Nil(t, writeDeployment(config{
+		Output:    dir,
+		Root:      "../..",
+		Hosts:     4,
+		Shards:    2,
+		Port:      7002,
+		Blocksize: 2,
+		Outputs:   10,
+		Txs:       3,
+		Seed:      1,
+		Debug:     1,
+	}))
+
+	var rosters []*onet.Roster
+	for shard := 0; shard < 2; shard++ {
+		f, err := os.Open(filepath.Join(dir, fmt.Sprintf("shard%d.toml", shard)))
+		require.Nil(t, err)
+		roster, err := app.ReadGroupToml(f)
+		f.Close()
+		require.Nil(t, err)
+		require.Equal(t, 2, len(roster.List))
+		rosters = append(rosters, roster)
+	}
+	// the conodes are split round-robin and hold the keys of the group files
+	for i, name := range []string{"conode-0", "conode-2", "conode-1", "conode-3"} {
+		si := rosters[i/2].List[i%2]
+		assert.Equal(t, network.NewTCPAddress(name+":7002"), si.Address)
+		conf := &app.CothorityConfig{}
+		_, err := toml.DecodeFile(filepath.Join(dir, name, privateFile), conf)
+		require.Nil(t, err)
+		assert.Equal(t, si.Address, conf.Address)
+		private, err := crypto.StringHexToScalar(network.Suite, conf.Private)
+		require.Nil(t, err)
+		assert.True(t, network.Suite.Point().Mul(nil, private).Equal(si.Public))
+	}
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, genesisFile))
+	require.Nil(t, err)
+	var genesis []*state.Block
+	require.Nil(t, json.Unmarshal(buf, &genesis))
+	require.Equal(t, 2, len(genesis))
+	for shard, b := range genesis {
+		assert.Equal(t, shard, b.Shard)
+		assert.Equal(t, 10, len(b.UTXOs))
+		assert.Nil(t, b.VerifySignatures(rosters[shard]))
+	}
+	txs, err := filepath.Glob(filepath.Join(dir, txsDir, "*.json"))
+	require.Nil(t, err)
+	assert.Equal(t, 3, len(txs))
+
+	buf, err = ioutil.ReadFile(filepath.Join(dir, composeFile))
+	require.Nil(t, err)
+	compose := string(buf)
+	root, err := relativeRoot(dir, "../..")
+	require.Nil(t, err)
+	for _, s := range []string{
+		"context: " + root + "\n",
+		root + "/blocks:/conode/blocks:ro",
+		"  conode-0:\n", "  conode-3:\n", "  setup:\n", "  gateway:\n",
+		"compose setup -blocksize 2 shard0.toml shard1.toml",
+		"gateway -listen 0.0.0.0:8080 shard0.toml shard1.toml",
+	} {
+		assert.Contains(t, compose, s)
+	}
+
+	// not the root of the repository
+	assert.NotNil(t, writeDeployment(config{Output: dir, Root: ".",
+		Hosts: 4, Shards: 2}))
+	// more shards than conodes
+	_, err = newDeployment(1, 2, 7002)
+	assert.NotNil(t, err)
+}
+
+func TestSetup(t *testing.T) {
+	l := onet.NewTCPTest()
+	defer l.CloseAll()
+	servers := l.GenServers(8)
+	privates := make(map[network.Address]abstract.Scalar)
+	for _, server := range servers {
+		privates[server.ServerIdentity.Address] = l.GetPrivate(server)
+	}
+	rosters := []*onet.Roster{
+		l.GenRosterFromHost(servers[:4]...),
+		l.GenRosterFromHost(servers[4:]...),
+	}
+	utxos := []map[string]int64{{"a": 10}, {"b": 20}}
+	genesis, err := genesisBlocks(rosters, privates,
+		func(shard int) map[string]int64 { return utxos[shard] })
+	require.Nil(t, err)
+
+	require.Nil(t, setupShards(rosters, genesis, 1, time.Second))
+	// already set up
+	require.Nil(t, setupShards(rosters, genesis, 1, time.Second))
+	header, cerr := service.NewClient().GetLatestStateBlock(rosters[1].List[2], 1)
+	require.Nil(t, cerr)
+	assert.Equal(t, genesis[1].Hash(), header.Hash())
+
+	// signed by the wrong shard
+	assert.NotNil(t, setupShards(rosters, []*state.Block{genesis[1], genesis[0]},
+		1, time.Second))
+	assert.NotNil(t, setupShards(rosters, genesis[:1], 1, time.Second))
+}
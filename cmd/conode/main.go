@@ -0,0 +1,17 @@
+// Conode runs a server of the OmniLedger service outside of the simulations,
+// e.g. in the containers of a deployment generated by cmd/compose:
+//
+//	conode -d 1 server -c private.toml
+//
+// The server waits for the rosters and the first state blocks of the shards,
+// see service.Client.Setup.
+package main
+
+import (
+	_ "github.com/dedis/paper_17_sosp_omniledger/omniledger/service"
+	"gopkg.in/dedis/onet.v1/app"
+)
+
+func main() {
+	app.Server()
+}
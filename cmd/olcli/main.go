@@ -0,0 +1,330 @@
+// Olcli talks to a running deployment of the OmniLedger service, for demos
+// and debugging outside of the simulations. It takes the group files of the
+// shards, in the order of the shards, and asks the first member of a shard:
+//
+//	olcli -g shard0.toml -g shard1.toml submit tx.json
+//	olcli -g shard0.toml -g shard1.toml block 5f0c...
+//	olcli -g shard0.toml -g shard1.toml proof tx.json
+//	olcli -g shard0.toml -g shard1.toml utxo 1 0 d
+//	olcli -g shard0.toml -g shard1.toml shard tcp://10.0.0.3:7002
+//	olcli -g shard0.toml -g shard1.toml epoch
+//
+// A transaction file holds the transaction in JSON, or its protobuf encoding
+// in hex.
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/service"
+	"github.com/dedis/protobuf"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/app"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func main() {
+	cliApp := cli.NewApp()
+	cliApp.Name = "olcli"
+	cliApp.Usage = "Interact with a running OmniLedger deployment"
+	cliApp.Flags = []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "group, g",
+			Usage: "group file of a shard, once per shard in order",
+		},
+		cli.IntFlag{
+			Name:  "debug, d",
+			Value: 0,
+			Usage: "debug level",
+		},
+	}
+	cliApp.Commands = []cli.Command{
+		{
+			Name:      "submit",
+			Usage:     "submit a transaction and check its receipt",
+			ArgsUsage: "tx-file",
+			Action:    submit,
+		},
+		{
+			Name:      "block",
+			Usage:     "show the transaction block with the hash",
+			ArgsUsage: "hash",
+			Action:    block,
+		},
+		{
+			Name:      "proof",
+			Usage:     "fetch and check the receipt of a committed transaction",
+			ArgsUsage: "tx-file",
+			Action:    proof,
+		},
+		{
+			Name:      "utxo",
+			Usage:     "fetch and check the proof of an output of a state block",
+			ArgsUsage: "shard epoch id",
+			Action:    utxo,
+		},
+		{
+			Name:      "shard",
+			Usage:     "show the shard of the server with the address",
+			ArgsUsage: "address",
+			Action:    shard,
+		},
+		{
+			Name:   "epoch",
+			Usage:  "show the latest state block of every shard",
+			Action: epoch,
+		},
+	}
+	cliApp.Before = func(c *cli.Context) error {
+		log.SetDebugVisible(c.Int("debug"))
+		return nil
+	}
+	log.ErrFatal(cliApp.Run(os.Args))
+}
+
+// submit sends the transaction to the first member of its first input shard
+// and checks the receipt if it is committed.
+func submit(c *cli.Context) error {
+	rosters, tx, err := rostersAndTx(c)
+	if err != nil {
+		return err
+	}
+	si := rosters[tx.InputShards()[0]].List[0]
+	receipt, cerr := service.NewClient().SubmitTransaction(si, tx)
+	if cerr != nil {
+		return cerr
+	}
+	fmt.Printf("transaction %x\n", tx.Hash())
+	if receipt == nil {
+		fmt.Println("aborted")
+		return nil
+	}
+	if err := service.VerifyReceipt(rosters, tx, receipt); err != nil {
+		return fmt.Errorf("committed with an invalid receipt: %v", err)
+	}
+	fmt.Println("committed, receipt verified")
+	return nil
+}
+
+// block asks every shard for the block until one knows it.
+func block(c *cli.Context) error {
+	rosters, err := readRosters(c)
+	if err != nil {
+		return err
+	}
+	hash, err := hex.DecodeString(c.Args().First())
+	if err != nil || len(hash) == 0 {
+		return errors.New("need the hex hash of the block")
+	}
+	client := service.NewClient()
+	for s, roster := range rosters {
+		b, index, cerr := client.FindBlock(roster.List[0], s, hash)
+		if cerr != nil {
+			log.Lvl2("shard", s, ":", cerr)
+			continue
+		}
+		fmt.Printf("block %d of epoch %d of shard %d\n", index, b.Epoch,
+			b.Shard)
+		return printJSON(b.Txs)
+	}
+	return errors.New("no shard knows the block")
+}
+
+// proof fetches the receipt of the transaction from the first of its shards
+// that has it, and checks it.
+func proof(c *cli.Context) error {
+	rosters, tx, err := rostersAndTx(c)
+	if err != nil {
+		return err
+	}
+	client := service.NewClient()
+	for _, s := range tx.InputShards() {
+		receipt, cerr := client.GetReceipt(rosters[s].List[0], s, tx.Hash())
+		if cerr != nil {
+			log.Lvl2("shard", s, ":", cerr)
+			continue
+		}
+		if err := service.VerifyReceipt(rosters, tx, receipt); err != nil {
+			return fmt.Errorf("invalid receipt: %v", err)
+		}
+		for _, p := range receipt.Proofs {
+			fmt.Printf("shard %d: block %x, index %d, %d signatures\n",
+				p.Shard, p.Header.Hash(), p.Index, len(p.Signatures))
+		}
+		fmt.Println("receipt verified")
+		return nil
+	}
+	return errors.New("no receipt of the transaction")
+}
+
+// utxo fetches the proof that the output is in the state block of the
+// epoch, and checks the signatures of the block against the group of the
+// shard.
+func utxo(c *cli.Context) error {
+	rosters, err := readRosters(c)
+	if err != nil {
+		return err
+	}
+	if c.NArg() != 3 {
+		return errors.New("need the shard, the epoch and the id of the output")
+	}
+	s, err := shardArg(rosters, c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	e, err := strconv.Atoi(c.Args().Get(1))
+	if err != nil {
+		return fmt.Errorf("wrong epoch: %v", err)
+	}
+	reply, cerr := service.NewClient().GetProof(rosters[s].List[0], s, e,
+		c.Args().Get(2))
+	if cerr != nil {
+		return cerr
+	}
+	if err := reply.Header.VerifySignatures(rosters[s]); err != nil {
+		return fmt.Errorf("invalid state block: %v", err)
+	}
+	fmt.Printf("output %s of value %d in state block %x, verified\n",
+		reply.UTXO.ID, reply.UTXO.Value, reply.Header.Hash())
+	return nil
+}
+
+// shard prints the shard of the server and its index in the shard.
+func shard(c *cli.Context) error {
+	rosters, err := readRosters(c)
+	if err != nil {
+		return err
+	}
+	s, index := findServer(rosters, c.Args().First())
+	if s < 0 {
+		return errors.New("the server is in no shard")
+	}
+	fmt.Printf("shard %d, member %d of %d\n", s, index, len(rosters[s].List))
+	return nil
+}
+
+// epoch prints the latest state block of every shard. The service doesn't
+// keep the randomness the shards have been assigned with, so it can't be
+// shown.
+func epoch(c *cli.Context) error {
+	rosters, err := readRosters(c)
+	if err != nil {
+		return err
+	}
+	client := service.NewClient()
+	for s, roster := range rosters {
+		header, cerr := client.GetLatestStateBlock(roster.List[0], s)
+		if cerr != nil {
+			fmt.Printf("shard %d: %v\n", s, cerr)
+			continue
+		}
+		fmt.Printf("shard %d: %d members, epoch %d, state block %x, "+
+			"previous %x, %d signatures\n", s, len(roster.List),
+			header.Epoch, header.Hash(), header.Previous,
+			len(header.Signatures))
+	}
+	return nil
+}
+
+// readRosters reads the group files of the shards.
+func readRosters(c *cli.Context) ([]*onet.Roster, error) {
+	files := c.GlobalStringSlice("group")
+	if len(files) == 0 {
+		return nil, errors.New("need the group file of every shard")
+	}
+	var rosters []*onet.Roster
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		roster, err := app.ReadGroupToml(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		rosters = append(rosters, roster)
+	}
+	return rosters, nil
+}
+
+// rostersAndTx reads the group files and the transaction file of the
+// arguments, checking that the transaction is of these shards.
+func rostersAndTx(c *cli.Context) ([]*onet.Roster, *atomix.Transaction, error) {
+	rosters, err := readRosters(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	tx, err := readTx(c.Args().First())
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, s := range append(tx.InputShards(), tx.OutputShards()...) {
+		if s < 0 || s >= len(rosters) {
+			return nil, nil, fmt.Errorf("unknown shard %d", s)
+		}
+	}
+	return rosters, tx, nil
+}
+
+// readTx reads the transaction of the file, in JSON or protobuf in hex.
+func readTx(file string) (*atomix.Transaction, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	tx := &atomix.Transaction{}
+	if raw, err := hex.DecodeString(string(bytes.TrimSpace(buf))); err == nil {
+		err = protobuf.Decode(raw, tx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+	} else if err := json.Unmarshal(buf, tx); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	if err := tx.Verify(); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return tx, nil
+}
+
+// findServer returns the shard of the server with the address, given with
+// or without its connection type, and its index in the shard, or -1.
+func findServer(rosters []*onet.Roster, address string) (int, int) {
+	for s, roster := range rosters {
+		for i, si := range roster.List {
+			if string(si.Address) == address ||
+				si.Address.NetworkAddress() == address {
+				return s, i
+			}
+		}
+	}
+	return -1, -1
+}
+
+// shardArg parses the index of a shard.
+func shardArg(rosters []*onet.Roster, arg string) (int, error) {
+	s, err := strconv.Atoi(arg)
+	if err != nil || s < 0 || s >= len(rosters) {
+		return 0, fmt.Errorf("unknown shard %s", arg)
+	}
+	return s, nil
+}
+
+func printJSON(v interface{}) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(buf))
+	return nil
+}
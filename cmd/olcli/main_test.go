@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+	"github.com/dedis/protobuf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+func TestReadTx(t *testing.T) {
+	dir, err := ioutil.TempDir("", "olcli")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	tx := &atomix.Transaction{
+		Inputs:  []atomix.Input{{Shard: 0, ID: "a", Value: 10}},
+		Outputs: []atomix.Output{{Shard: 1, ID: "b", Value: 10}},
+	}
+
+	asJSON, err := json.Marshal(tx)
+	require.Nil(t, err)
+	asProtobuf, err := protobuf.Encode(tx)
+	require.Nil(t, err)
+	for name, content := range map[string][]byte{
+		"tx.json": asJSON,
+		"tx.hex":  []byte(hex.EncodeToString(asProtobuf) + "\n"),
+	} {
+		file := filepath.Join(dir, name)
+		require.Nil(t, ioutil.WriteFile(file, content, 0600))
+		read, err := readTx(file)
+		require.Nil(t, err, name)
+		assert.Equal(t, tx.Hash(), read.Hash(), name)
+	}
+
+	// outputs bigger than inputs
+	tx.Outputs[0].Value = 20
+	asJSON, err = json.Marshal(tx)
+	require.Nil(t, err)
+	file := filepath.Join(dir, "invalid.json")
+	require.Nil(t, ioutil.WriteFile(file, asJSON, 0600))
+	_, err = readTx(file)
+	assert.NotNil(t, err)
+	_, err = readTx(filepath.Join(dir, "missing.json"))
+	assert.NotNil(t, err)
+}
+
+func TestFindServer(t *testing.T) {
+	l := onet.NewTCPTest()
+	defer l.CloseAll()
+	_, roster, _ := l.GenTree(6, true)
+	rosters := []*onet.Roster{
+		onet.NewRoster(roster.List[:3]),
+		onet.NewRoster(roster.List[3:]),
+	}
+
+	si := roster.List[4]
+	s, i := findServer(rosters, string(si.Address))
+	assert.Equal(t, 1, s)
+	assert.Equal(t, 1, i)
+	s, i = findServer(rosters, si.Address.NetworkAddress())
+	assert.Equal(t, 1, s)
+	assert.Equal(t, 1, i)
+	s, _ = findServer(rosters, "tcp://192.0.2.1:2000")
+	assert.Equal(t, -1, s)
+}
@@ -0,0 +1,428 @@
+// Scenarios runs the simulations of a list of scenarios one after the other,
+// to compare the protocols without editing and re-running every simulation
+// binary by hand, and collects their measures in a single CSV file:
+//
+//	scenarios -o comparison.csv comparison.toml
+//
+// The scenario file gives the parameters shared by all scenarios, as in the
+// first part of a simulation file, and the protocol, hosts, block size and
+// shards of every scenario, with its own parameters:
+//
+//	[Defaults]
+//	Servers = 32
+//	Rounds = 10
+//	CloseWait = 6000
+//
+//	[[Scenario]]
+//	Label = "byzcoin"
+//	Protocol = "ByzCoin"
+//	Hosts = 60
+//	Blocksize = 1000
+//	  [Scenario.Params]
+//	  GroupSize = 10
+//
+//	[[Scenario]]
+//	Protocol = "OmniShards"
+//	Hosts = 17
+//	Blocksize = 1000
+//	Shards = 4
+//
+// The protocol is the name of the simulation. The binary of its directory
+// is built once, and every scenario is run on localhost in that directory,
+// from a generated simulation file. The CSV has the label, protocol, hosts,
+// blocksize and shards of every scenario, followed by the measures of all
+// of them, empty for the ones a scenario didn't record. It must be run from
+// the root of the repository, or be given it with -root.
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// simulations are the directories of the simulations, by their name.
+var simulations = map[string]string{
+	"ByzCoin":      "ntree",
+	"ByzCoinNtree": "ntree",
+	"ByzCoinPBFT":  "pbft",
+	"OmniState":    "omniledger",
+	"OmniAtomix":   "omniledger",
+	"OmniEpoch":    "omniledger",
+	"OmniShards":   "omniledger",
+	"ServiceBNG":   "byzcoin_ng",
+	"Service2BNG":  "byzcoin_ng2",
+	"Gossip":       "gossip/simulation",
+}
+
+// labels are the columns of the CSV describing a scenario, before its
+// measures.
+var labels = []string{"label", "protocol", "hosts", "blocksize", "shards"}
+
+// scenarioFile is the list of scenarios to run.
+type scenarioFile struct {
+	// Defaults are the parameters of all scenarios
+	Defaults map[string]interface{}
+	Scenario []scenario
+}
+
+// scenario is a simulation to run with its parameters.
+type scenario struct {
+	// Label names the scenario in the CSV, the protocol and its index if
+	// empty
+	Label string
+	// Protocol is the name of the simulation
+	Protocol string
+	Hosts    int
+	// Blocksize is the number of transactions of a block, not given to
+	// the simulation if 0
+	Blocksize int
+	// Shards is the number of shards, not given to the simulation if 0
+	Shards int
+	// Params are the parameters of the scenario, overriding the defaults
+	Params map[string]interface{}
+}
+
+// result are the measures of a run of a scenario.
+type result struct {
+	scenario *scenario
+	// columns are the measures in the order of onet
+	columns  []string
+	measures map[string]string
+}
+
+func main() {
+	cliApp := cli.NewApp()
+	cliApp.Name = "scenarios"
+	cliApp.Usage = "Run the simulations of the scenarios and collect their measures"
+	cliApp.ArgsUsage = "scenario-file"
+	cliApp.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "output, o",
+			Value: "scenarios.csv",
+			Usage: "CSV file of the measures of all scenarios",
+		},
+		cli.StringFlag{
+			Name:  "root",
+			Value: ".",
+			Usage: "root of the repository with the simulations",
+		},
+		cli.IntFlag{
+			Name:  "debug, d",
+			Value: 0,
+			Usage: "debug level, also of the simulations",
+		},
+	}
+	cliApp.Before = func(c *cli.Context) error {
+		log.SetDebugVisible(c.Int("debug"))
+		return nil
+	}
+	cliApp.Action = run
+	log.ErrFatal(cliApp.Run(os.Args))
+}
+
+// run builds the simulations of the scenarios, runs them one after the
+// other and writes the measures of all of them. A failing scenario doesn't
+// stop the others, it is reported at the end.
+func run(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("need the scenario file")
+	}
+	sf, err := readScenarios(c.Args().First())
+	if err != nil {
+		return err
+	}
+	root, err := filepath.Abs(c.String("root"))
+	if err != nil {
+		return err
+	}
+	// the simulations look for the blocks next to the go.mod above their
+	// binary
+	bin, err := ioutil.TempDir(root, ".scenarios")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(bin)
+	binaries := make(map[string]string)
+	for _, s := range sf.Scenario {
+		dir := simulations[s.Protocol]
+		if binaries[dir] != "" {
+			continue
+		}
+		binary := filepath.Join(bin, strings.Replace(dir, "/", "_", -1))
+		log.Lvl1("Building", dir)
+		if err := goBuild(root, dir, binary); err != nil {
+			return err
+		}
+		binaries[dir] = binary
+	}
+
+	var results []result
+	var failed []string
+	for i := range sf.Scenario {
+		s := &sf.Scenario[i]
+		log.Lvlf1("Running scenario %d/%d: %s", i+1, len(sf.Scenario), s.Label)
+		dir := filepath.Join(root, simulations[s.Protocol])
+		columns, measures, err := runScenario(dir,
+			binaries[simulations[s.Protocol]], i, s, sf.Defaults, c.Int("debug"))
+		if err != nil {
+			log.Error("Scenario", s.Label, "failed:", err)
+			failed = append(failed, s.Label)
+		}
+		for _, m := range measures {
+			results = append(results, result{scenario: s, columns: columns,
+				measures: m})
+		}
+	}
+
+	out, err := os.Create(c.String("output"))
+	if err != nil {
+		return err
+	}
+	if err := writeResults(out, results); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	log.Lvl1("Wrote the measures of", len(results), "runs to", c.String("output"))
+	if len(failed) > 0 {
+		return fmt.Errorf("failed scenarios: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// readScenarios reads and checks the scenario file, and labels the
+// scenarios without a label.
+func readScenarios(file string) (*scenarioFile, error) {
+	sf := &scenarioFile{}
+	if _, err := toml.DecodeFile(file, sf); err != nil {
+		return nil, err
+	}
+	if len(sf.Scenario) == 0 {
+		return nil, errors.New("no scenario in " + file)
+	}
+	for i := range sf.Scenario {
+		s := &sf.Scenario[i]
+		if s.Label == "" {
+			s.Label = fmt.Sprintf("%s-%d", s.Protocol, i)
+		}
+		if _, ok := simulations[s.Protocol]; !ok {
+			return nil, fmt.Errorf("scenario %s: unknown protocol %q",
+				s.Label, s.Protocol)
+		}
+		if s.Hosts <= 0 {
+			return nil, fmt.Errorf("scenario %s: need the hosts", s.Label)
+		}
+		if s.Blocksize < 0 || s.Shards < 0 {
+			return nil, fmt.Errorf("scenario %s: negative blocksize or shards",
+				s.Label)
+		}
+	}
+	return sf, nil
+}
+
+// goBuild builds the simulation of the directory into binary.
+func goBuild(root, dir, binary string) error {
+	cmd := exec.Command("go", "build", "-o", binary, "./"+dir)
+	cmd.Dir = root
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("couldn't build %s: %v", dir, err)
+	}
+	return nil
+}
+
+// runScenario runs the simulation of the scenario from a simulation file
+// in its directory, and returns the measures of its runs. It removes the
+// simulation file and the measures of onet once read.
+func runScenario(dir, binary string, index int, s *scenario,
+	defaults map[string]interface{}, debug int) ([]string, []map[string]string, error) {
+	content, err := runFile(s, defaults)
+	if err != nil {
+		return nil, nil, err
+	}
+	name := fmt.Sprintf("scenario_%d", index)
+	file := filepath.Join(dir, name+".toml")
+	if err := ioutil.WriteFile(file, content, 0644); err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(file)
+	cmd := exec.Command(binary, "-debug", strconv.Itoa(debug), name+".toml")
+	cmd.Dir = dir
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, nil, err
+	}
+	csvFile := filepath.Join(dir, "test_data", name+".csv")
+	defer os.Remove(csvFile)
+	f, err := os.Open(csvFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	return readMeasures(f)
+}
+
+// runFile returns the simulation file of the scenario: the simulation and
+// the defaults overridden by the parameters of the scenario, then a run
+// with its hosts, and its blocksize and shards if they are given.
+func runFile(s *scenario, defaults map[string]interface{}) ([]byte, error) {
+	params := make(map[string]interface{})
+	for _, m := range []map[string]interface{}{defaults, s.Params} {
+		for k, v := range m {
+			for _, column := range labels {
+				if strings.EqualFold(k, column) {
+					return nil, fmt.Errorf("scenario %s: %s is a field of "+
+						"the scenario, not a parameter", s.Label, k)
+				}
+			}
+			for old := range params {
+				if strings.EqualFold(k, old) {
+					delete(params, old)
+				}
+			}
+			params[k] = v
+		}
+	}
+	for k := range params {
+		if strings.EqualFold(k, "Simulation") {
+			delete(params, k)
+		}
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "Simulation = %q\n", s.Protocol)
+	for _, k := range keys {
+		v, err := tomlValue(params[k])
+		if err != nil {
+			return nil, fmt.Errorf("scenario %s: %s: %v", s.Label, k, err)
+		}
+		fmt.Fprintf(&buf, "%s = %s\n", k, v)
+	}
+	columns := []string{"Hosts"}
+	values := []string{strconv.Itoa(s.Hosts)}
+	if s.Blocksize > 0 {
+		columns = append(columns, "Blocksize")
+		values = append(values, strconv.Itoa(s.Blocksize))
+	}
+	if s.Shards > 0 {
+		columns = append(columns, "Shards")
+		values = append(values, strconv.Itoa(s.Shards))
+	}
+	fmt.Fprintf(&buf, "\n%s\n%s\n", strings.Join(columns, ", "),
+		strings.Join(values, ", "))
+	return []byte(buf.String()), nil
+}
+
+// tomlValue returns the parameter as a value of a simulation file, which
+// holds strings without '=', numbers and booleans.
+func tomlValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		if strings.Contains(v, "=") {
+			return "", errors.New("a string can't hold '='")
+		}
+		return strconv.Quote(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		f := strconv.FormatFloat(v, 'f', -1, 64)
+		if !strings.Contains(f, ".") {
+			f += ".0"
+		}
+		return f, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+// readMeasures reads the measures of onet, a header and a line per run,
+// and returns the columns and the measures of every run by column.
+func readMeasures(r io.Reader) ([]string, []map[string]string, error) {
+	lines := csv.NewReader(bufio.NewReader(r))
+	lines.FieldsPerRecord = -1
+	records, err := lines.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, errors.New("no measures")
+	}
+	var columns []string
+	for _, column := range records[0] {
+		columns = append(columns, strings.TrimSpace(column))
+	}
+	var measures []map[string]string
+	for _, record := range records[1:] {
+		if len(record) != len(columns) {
+			return nil, nil, fmt.Errorf("%d values for %d columns",
+				len(record), len(columns))
+		}
+		m := make(map[string]string)
+		for i, column := range columns {
+			m[column] = strings.TrimSpace(record[i])
+		}
+		measures = append(measures, m)
+	}
+	return columns, measures, nil
+}
+
+// writeResults writes the results as CSV: the labels of the scenarios, then
+// the measures of all of them in the order they first appear. The measures
+// onet copies from the labels, e.g. hosts, are only written once.
+func writeResults(w io.Writer, results []result) error {
+	header := append([]string{}, labels...)
+	seen := make(map[string]bool)
+	for _, column := range labels {
+		seen[column] = true
+	}
+	for _, r := range results {
+		for _, column := range r.columns {
+			if !seen[strings.ToLower(column)] {
+				seen[strings.ToLower(column)] = true
+				header = append(header, column)
+			}
+		}
+	}
+
+	out := csv.NewWriter(w)
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	for _, r := range results {
+		s := r.scenario
+		record := []string{s.Label, s.Protocol, strconv.Itoa(s.Hosts),
+			strconv.Itoa(s.Blocksize), strconv.Itoa(s.Shards)}
+		for _, column := range header[len(labels):] {
+			record = append(record, r.measures[column])
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+func TestReadScenarios(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scenarios")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "comparison.toml")
+	require.Nil(t, ioutil.WriteFile(file, []byte(`
+[Defaults]
+Servers = 32
+Rounds = 10
+
+[[Scenario]]
+Label = "byzcoin"
+Protocol = "ByzCoin"
+Hosts = 60
+Blocksize = 1000
+  [Scenario.Params]
+  GroupSize = 10
+
+[[Scenario]]
+Protocol = "OmniShards"
+Hosts = 17
+Shards = 4
+`), 0644))
+
+	sf, err := readScenarios(file)
+	require.Nil(t, err)
+	require.Equal(t, int64(32), sf.Defaults["Servers"])
+	require.Equal(t, 2, len(sf.Scenario))
+	require.Equal(t, "byzcoin", sf.Scenario[0].Label)
+	require.Equal(t, 1000, sf.Scenario[0].Blocksize)
+	require.Equal(t, int64(10), sf.Scenario[0].Params["GroupSize"])
+	require.Equal(t, "OmniShards-1", sf.Scenario[1].Label)
+	require.Equal(t, 4, sf.Scenario[1].Shards)
+
+	for _, bad := range []string{
+		``,
+		"[[Scenario]]\nProtocol = \"Unknown\"\nHosts = 4\n",
+		"[[Scenario]]\nProtocol = \"ByzCoin\"\n",
+	} {
+		require.Nil(t, ioutil.WriteFile(file, []byte(bad), 0644))
+		_, err := readScenarios(file)
+		require.NotNil(t, err)
+	}
+}
+
+func TestRunFile(t *testing.T) {
+	s := &scenario{
+		Label:     "byzcoin",
+		Protocol:  "ByzCoin",
+		Hosts:     60,
+		Blocksize: 1000,
+		Params: map[string]interface{}{
+			"rounds":     int64(5),
+			"CrossShard": 0.5,
+			"Ratio":      float64(1),
+			"Delay":      "exponential",
+			"Prefetch":   true,
+		},
+	}
+	defaults := map[string]interface{}{
+		"Servers":    int64(32),
+		"Rounds":     int64(10),
+		"Simulation": "Other",
+	}
+	content, err := runFile(s, defaults)
+	require.Nil(t, err)
+	require.Equal(t, `Simulation = "ByzCoin"
+CrossShard = 0.5
+Delay = "exponential"
+Prefetch = true
+Ratio = 1.0
+Servers = 32
+rounds = 5
+
+Hosts, Blocksize
+60, 1000
+`, string(content))
+
+	s.Blocksize = 0
+	s.Shards = 4
+	content, err = runFile(s, nil)
+	require.Nil(t, err)
+	require.True(t, strings.HasSuffix(string(content), "\nHosts, Shards\n60, 4\n"))
+
+	s.Params = map[string]interface{}{"Hosts": int64(5)}
+	_, err = runFile(s, nil)
+	require.NotNil(t, err)
+	s.Params = map[string]interface{}{"Topology": "a=b"}
+	_, err = runFile(s, nil)
+	require.NotNil(t, err)
+	s.Params = map[string]interface{}{"List": []interface{}{int64(1)}}
+	_, err = runFile(s, nil)
+	require.NotNil(t, err)
+}
+
+func TestWriteResults(t *testing.T) {
+	byzcoin := &scenario{Label: "byzcoin", Protocol: "ByzCoin", Hosts: 60,
+		Blocksize: 1000}
+	shards := &scenario{Label: "shards", Protocol: "OmniShards", Hosts: 17,
+		Shards: 4}
+	columns, measures, err := readMeasures(strings.NewReader(
+		"hosts, blocksize, round_wall_avg, bw_commit_avg\n60, 1000, 1.5, 624\n"))
+	require.Nil(t, err)
+	require.Equal(t, []string{"hosts", "blocksize", "round_wall_avg",
+		"bw_commit_avg"}, columns)
+	results := []result{{byzcoin, columns, measures[0]}}
+	columns, measures, err = readMeasures(strings.NewReader(
+		"hosts,shards,round_wall_avg,tps_avg\n17,4,0.8,1200\n17,4,0.9,1100\n"))
+	require.Nil(t, err)
+	for _, m := range measures {
+		results = append(results, result{shards, columns, m})
+	}
+	_, _, err = readMeasures(strings.NewReader("hosts,tps_avg\n17\n"))
+	require.NotNil(t, err)
+
+	var buf bytes.Buffer
+	require.Nil(t, writeResults(&buf, results))
+	require.Equal(t, `label,protocol,hosts,blocksize,shards,round_wall_avg,bw_commit_avg,tps_avg
+byzcoin,ByzCoin,60,1000,0,1.5,624,
+shards,OmniShards,17,0,4,0.8,,1200
+shards,OmniShards,17,0,4,0.9,,1100
+`, buf.String())
+}
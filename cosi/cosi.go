@@ -315,8 +315,8 @@ func verifyCommitment(suite abstract.Suite, msg []byte, commitment abstract.Poin
 
 // VerifySignatureWithException will verify the signature taking into account
 // the exceptions given. An exception is the pubilc key + commitment of a peer that did not
-// sign.
-// NOTE: No exception mechanism for "before" commitment has been yet coded.
+// sign. A peer that never committed, e.g. because it was down, gives the null
+// point as commitment.
 func VerifySignatureWithException(suite abstract.Suite, public abstract.Point, msg []byte, challenge, secret abstract.Scalar, exceptions []Exception) error {
 	// first reduce the aggregate public key
 	subPublic := suite.Point().Add(suite.Point().Null(), public)
@@ -325,12 +325,19 @@ func VerifySignatureWithException(suite abstract.Suite, public abstract.Point, m
 		subPublic = subPublic.Sub(subPublic, ex.Public)
 		aggExCommit = aggExCommit.Add(aggExCommit, ex.Commitment)
 	}
+	return VerifySignatureWithExceptionCommit(suite, subPublic, msg, challenge,
+		secret, aggExCommit)
+}
 
+// VerifySignatureWithExceptionCommit verifies the signature of the signers
+// of aggregate public key signers, the peers that did not sign having
+// committed exCommit all together.
+func VerifySignatureWithExceptionCommit(suite abstract.Suite, signers abstract.Point, msg []byte, challenge, secret abstract.Scalar, exCommit abstract.Point) error {
 	// recompute the challenge and check if it is the same
 	commitment := suite.Point()
-	commitment = commitment.Add(commitment.Mul(nil, secret), suite.Point().Mul(public, challenge))
+	commitment = commitment.Add(commitment.Mul(nil, secret), suite.Point().Mul(signers, challenge))
 	// ADD the exceptions commitment here
-	commitment = commitment.Add(commitment, aggExCommit)
+	commitment = commitment.Add(commitment, exCommit)
 	// check if it is ok
 	return verifyCommitment(suite, msg, commitment, challenge)
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"testing"
 
+	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/crypto.v0/config"
 	"gopkg.in/dedis/crypto.v0/ed25519"
 )
@@ -194,3 +195,48 @@ func genFinalCosi(nb int, msg []byte) (*Cosi, []*Cosi, error) {
 	}
 	return root, children, nil
 }
+
+// TestCosiVerifyWithException checks signatures where a peer committed but
+// refused to respond, and where a peer never committed at all.
+func TestCosiVerifyWithException(t *testing.T) {
+	msg := []byte("Hello World Cosi")
+	root := genCosi()
+	children := genCosis(4)
+	publics := make([]abstract.Point, len(children))
+	aggregatedPublic := testSuite.Point().Mul(nil, root.private)
+	for i, ch := range children {
+		publics[i] = testSuite.Point().Mul(nil, ch.private)
+		aggregatedPublic = aggregatedPublic.Add(aggregatedPublic, publics[i])
+	}
+
+	// children[0] never commits, children[1] commits but doesn't respond
+	commitments := genCommitments(children[1:])
+	root.Commit(commitments)
+	chal, err := root.CreateChallenge(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var responses []*Response
+	for _, ch := range children[2:] {
+		ch.Challenge(chal)
+		r, err := ch.CreateResponse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, r)
+	}
+	if _, err := root.Response(responses); err != nil {
+		t.Fatal(err)
+	}
+	sig := root.Signature()
+	exceptions := []Exception{
+		{Public: publics[0], Commitment: testSuite.Point().Null()},
+		{Public: publics[1], Commitment: commitments[0].Commitment},
+	}
+	if err := VerifyCosiSignatureWithException(testSuite, aggregatedPublic, msg, sig, exceptions); err != nil {
+		t.Fatal("Error verifying with exceptions:", err)
+	}
+	if err := VerifyCosiSignatureWithException(testSuite, aggregatedPublic, msg, sig, exceptions[:1]); err == nil {
+		t.Fatal("Signature verified with a missing exception")
+	}
+}
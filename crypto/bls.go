@@ -0,0 +1,228 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/bn256"
+)
+
+// bn256P is the prime of the base field of the BN256 curve.
+var bn256P, _ = new(big.Int).SetString("65000549695646603732796438742359905742825358107623003571877145026864184071783", 10)
+
+// BLSPrivate is the private key of a BLS signer, a scalar x.
+type BLSPrivate struct {
+	x *big.Int
+}
+
+// BLSPublic is the public key of a BLS signer, x*G2 on the BN256 curve. The
+// public keys of several signers of the same message add up to the key
+// verifying their aggregated signature.
+type BLSPublic struct {
+	p *bn256.G2
+}
+
+// NewBLSKeyPair returns a new key pair, picking the private key from rand.
+func NewBLSKeyPair(rand io.Reader) (*BLSPrivate, *BLSPublic, error) {
+	x, p, err := bn256.RandomG2(rand)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &BLSPrivate{x}, &BLSPublic{p}, nil
+}
+
+// Public returns the public key of the private key.
+func (k *BLSPrivate) Public() *BLSPublic {
+	return &BLSPublic{new(bn256.G2).ScalarBaseMult(k.x)}
+}
+
+// MarshalBinary returns the encoding of the public key.
+func (p *BLSPublic) MarshalBinary() ([]byte, error) {
+	return p.p.Marshal(), nil
+}
+
+// UnmarshalBinary sets the public key to the encoded one.
+func (p *BLSPublic) UnmarshalBinary(buf []byte) error {
+	g, ok := new(bn256.G2).Unmarshal(buf)
+	if !ok {
+		return errors.New("invalid BLS public key")
+	}
+	p.p = g
+	return nil
+}
+
+// BLSSign returns the BLS signature of the message, x*H(msg) in G1 encoded.
+func BLSSign(private *BLSPrivate, msg []byte) []byte {
+	return new(bn256.G1).ScalarMult(hashToG1(msg), private.x).Marshal()
+}
+
+// BLSVerify returns nil iff the signature is the signature of the message by
+// the public key, that is iff e(sig, G2) = e(H(msg), public).
+func BLSVerify(public *BLSPublic, msg, sig []byte) error {
+	s, ok := new(bn256.G1).Unmarshal(sig)
+	if !ok {
+		return errors.New("invalid BLS signature encoding")
+	}
+	g2 := new(bn256.G2).ScalarBaseMult(big.NewInt(1))
+	lhs := bn256.Pair(s, g2).Marshal()
+	rhs := bn256.Pair(hashToG1(msg), public.p).Marshal()
+	if !bytes.Equal(lhs, rhs) {
+		return errors.New("invalid BLS signature")
+	}
+	return nil
+}
+
+// BLSAggregate adds the signatures up into a single signature of the same
+// size, verified by BLSAggregateVerify.
+func BLSAggregate(sigs ...[]byte) ([]byte, error) {
+	if len(sigs) == 0 {
+		return nil, errors.New("no signature to aggregate")
+	}
+	agg := new(bn256.G1)
+	for i, sig := range sigs {
+		s, ok := new(bn256.G1).Unmarshal(sig)
+		if !ok {
+			return nil, fmt.Errorf("invalid encoding of signature %d", i)
+		}
+		if i == 0 {
+			agg = s
+			continue
+		}
+		agg.Add(agg, s)
+	}
+	return agg.Marshal(), nil
+}
+
+// BLSAggregatePublics adds the public keys up into the key of their
+// aggregated signatures.
+func BLSAggregatePublics(publics ...*BLSPublic) (*BLSPublic, error) {
+	if len(publics) == 0 {
+		return nil, errors.New("no public key to aggregate")
+	}
+	// copy the first key, not to add the others to it
+	agg, _ := new(bn256.G2).Unmarshal(publics[0].p.Marshal())
+	for _, p := range publics[1:] {
+		agg.Add(agg, p.p)
+	}
+	return &BLSPublic{agg}, nil
+}
+
+// BLSAggregateVerify returns nil iff the signature is the aggregation of the
+// signatures of the message by all the public keys. The keys have to be
+// known to belong to their signers, like the keys of a roster, else a signer
+// can choose its key to cancel the others.
+func BLSAggregateVerify(publics []*BLSPublic, msg, sig []byte) error {
+	agg, err := BLSAggregatePublics(publics...)
+	if err != nil {
+		return err
+	}
+	return BLSVerify(agg, msg, sig)
+}
+
+// BLSCertificate is the aggregated signature of a message by some members of
+// a roster, and the bitmap of the members that signed, the i-th bit of the
+// map standing for the i-th member.
+type BLSCertificate struct {
+	Signature []byte
+	Signers   []byte
+}
+
+// NewBLSCertificate returns a certificate without signature for a roster of
+// n members.
+func NewBLSCertificate(n int) *BLSCertificate {
+	return &BLSCertificate{Signers: make([]byte, (n+7)/8)}
+}
+
+// Add adds the signature of the i-th member to the certificate.
+func (c *BLSCertificate) Add(i int, sig []byte) error {
+	if i < 0 || i >= 8*len(c.Signers) {
+		return fmt.Errorf("unknown member %d", i)
+	}
+	if c.Signed(i) {
+		return fmt.Errorf("member %d already signed", i)
+	}
+	if c.Signature == nil {
+		if _, ok := new(bn256.G1).Unmarshal(sig); !ok {
+			return errors.New("invalid BLS signature encoding")
+		}
+		c.Signature = sig
+	} else {
+		agg, err := BLSAggregate(c.Signature, sig)
+		if err != nil {
+			return err
+		}
+		c.Signature = agg
+	}
+	c.Signers[i/8] |= 1 << uint(i%8)
+	return nil
+}
+
+// Signed tells whether the i-th member signed.
+func (c *BLSCertificate) Signed(i int) bool {
+	return i >= 0 && i < 8*len(c.Signers) && c.Signers[i/8]&(1<<uint(i%8)) != 0
+}
+
+// Count returns the number of members that signed.
+func (c *BLSCertificate) Count() int {
+	n := 0
+	for i := 0; i < 8*len(c.Signers); i++ {
+		if c.Signed(i) {
+			n++
+		}
+	}
+	return n
+}
+
+// Verify returns nil iff at least threshold members of the roster with the
+// public keys signed the message.
+func (c *BLSCertificate) Verify(publics []*BLSPublic, msg []byte, threshold int) error {
+	if len(c.Signers) != (len(publics)+7)/8 {
+		return errors.New("signers map doesn't match the roster")
+	}
+	var signers []*BLSPublic
+	for i := 0; i < 8*len(c.Signers); i++ {
+		if !c.Signed(i) {
+			continue
+		}
+		if i >= len(publics) {
+			return fmt.Errorf("unknown member %d", i)
+		}
+		signers = append(signers, publics[i])
+	}
+	if len(signers) < threshold {
+		return fmt.Errorf("only %d signers out of %d required", len(signers), threshold)
+	}
+	return BLSAggregateVerify(signers, msg, c.Signature)
+}
+
+// hashToG1 maps the message to a point of G1 whose discrete logarithm is
+// unknown, by trying x = H(i || msg) for i = 0, 1, ... until x^3 + 3 is a
+// square. BN256 has a cofactor of 1, so every point of the curve is in G1.
+func hashToG1(msg []byte) *bn256.G1 {
+	var ctr [4]byte
+	buf := make([]byte, 64)
+	for i := uint32(0); ; i++ {
+		binary.BigEndian.PutUint32(ctr[:], i)
+		h := sha256.New()
+		h.Write(ctr[:])
+		h.Write(msg)
+		x := new(big.Int).SetBytes(h.Sum(nil))
+		x.Mod(x, bn256P)
+		y2 := new(big.Int).Exp(x, big.NewInt(3), bn256P)
+		y2.Add(y2, big.NewInt(3)).Mod(y2, bn256P)
+		y := new(big.Int).ModSqrt(y2, bn256P)
+		if y == nil || x.Sign() == 0 {
+			continue
+		}
+		x.FillBytes(buf[:32])
+		y.FillBytes(buf[32:])
+		if g, ok := new(bn256.G1).Unmarshal(buf); ok {
+			return g
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"testing"
+)
+
+func newBLSKeys(t *testing.T, n int) ([]*BLSPrivate, []*BLSPublic) {
+	privates := make([]*BLSPrivate, n)
+	publics := make([]*BLSPublic, n)
+	for i := range privates {
+		var err error
+		privates[i], publics[i], err = NewBLSKeyPair(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return privates, publics
+}
+
+func TestBLSSignature(t *testing.T) {
+	privates, publics := newBLSKeys(t, 2)
+	msg := []byte("Hello BLS")
+	sig := BLSSign(privates[0], msg)
+	if err := BLSVerify(publics[0], msg, sig); err != nil {
+		t.Fatal(err)
+	}
+	if BLSVerify(publics[1], msg, sig) == nil {
+		t.Fatal("signature verified with the wrong key")
+	}
+	if BLSVerify(publics[0], []byte("Bye BLS"), sig) == nil {
+		t.Fatal("signature verified for the wrong message")
+	}
+
+	buf, _ := privates[0].Public().MarshalBinary()
+	var public BLSPublic
+	if err := public.UnmarshalBinary(buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := BLSVerify(&public, msg, sig); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBLSAggregate(t *testing.T) {
+	privates, publics := newBLSKeys(t, 4)
+	msg := []byte("Hello BLS")
+	sigs := make([][]byte, len(privates))
+	for i, p := range privates {
+		sigs[i] = BLSSign(p, msg)
+	}
+	agg, err := BLSAggregate(sigs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agg) != len(sigs[0]) {
+		t.Fatal("aggregated signature of", len(agg), "bytes")
+	}
+	if err := BLSAggregateVerify(publics, msg, agg); err != nil {
+		t.Fatal(err)
+	}
+	if BLSAggregateVerify(publics[1:], msg, agg) == nil {
+		t.Fatal("signature verified without a signer")
+	}
+	// the keys are still the ones of the signers
+	if err := BLSVerify(publics[0], msg, sigs[0]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBLSCertificate(t *testing.T) {
+	privates, publics := newBLSKeys(t, 10)
+	msg := []byte("block")
+	cert := NewBLSCertificate(len(publics))
+	for _, i := range []int{0, 2, 3, 5, 6, 7, 9} {
+		if err := cert.Add(i, BLSSign(privates[i], msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cert.Add(2, BLSSign(privates[2], msg)) == nil {
+		t.Fatal("member signed twice")
+	}
+	if cert.Count() != 7 || !cert.Signed(9) || cert.Signed(1) {
+		t.Fatal("wrong signers", cert.Signers)
+	}
+	if err := cert.Verify(publics, msg, 7); err != nil {
+		t.Fatal(err)
+	}
+	if cert.Verify(publics, msg, 8) == nil {
+		t.Fatal("certificate verified with too few signers")
+	}
+	if cert.Verify(publics, []byte("other block"), 7) == nil {
+		t.Fatal("certificate verified for the wrong message")
+	}
+	cert.Signers[0] ^= 2
+	if cert.Verify(publics, msg, 7) == nil {
+		t.Fatal("certificate verified with the wrong signers")
+	}
+}
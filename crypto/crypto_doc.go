@@ -9,5 +9,34 @@
 // See https://en.wikipedia.org/wiki/Schnorr_signature
 //
 // It provides a way to sign a message using a private key and to verify the
-// signature using the public counter part.
+// signature using the public counter part. CountedOps tells how many
+// signatures have been created and verified so far. SignSchnorrReader and
+// VerifySchnorrReader do the same for a message read from a stream, signing
+// its hash so that large messages never need to be held in memory. With the
+// DeterministicNonce option, the secret of a signature is derived from the
+// private key and the message as in RFC 6979 instead of being random.
+//
+// vrf.go provides a verifiable random function: VRFProve computes a
+// pseudo-random output of a message that only the owner of the private key
+// can compute, and a proof that everybody can check with VRFVerify.
+//
+// bls.go provides BLS signatures on the BN256 curve, whose signatures of the
+// same message add up with BLSAggregate into a single signature checked by
+// BLSAggregateVerify. A BLSCertificate holds such a signature together with
+// the bitmap of the members of the roster that signed.
+//
+// pvss.go provides publicly verifiable secret sharing: a dealer shares a
+// secret among trustees with NewPVSSDeal, everybody can check the encrypted
+// shares with VerifyPVSSDeal and the shares decrypted by the trustees with
+// VerifyPVSSDecShare, and RecoverPVSSSecret recovers the secret from enough
+// of them. It is the building block of the RandHound randomness beacon.
+//
+// ristretto.go provides the Ristretto group: the points of Curve25519 with an
+// encoding that hides the cofactor, so that the group has a prime order and
+// no point needs to be multiplied by the cofactor or checked for a small
+// order after it is decoded.
+//
+// policy.go provides the SignaturePolicy the protocols check their collective
+// signatures with: how many members of the roster have to sign, given by the
+// SignerMask of the signers or by the exceptions of a CoSi signature.
 package crypto
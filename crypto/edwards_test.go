@@ -0,0 +1,321 @@
+package crypto
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/ed25519"
+	"gopkg.in/dedis/crypto.v0/edwards"
+	"gopkg.in/dedis/crypto.v0/nist"
+	"gopkg.in/dedis/crypto.v0/random"
+)
+
+func newExtendedCurve() abstract.Group {
+	return new(edwards.ExtendedCurve).Init(edwards.Param25519(), false)
+}
+
+// newVartimeCurve returns the curve whose multiplications take a time
+// depending on the scalars.
+func newVartimeCurve() abstract.Group {
+	return new(edwards.ExtendedCurve).Init(edwards.Param25519(), false, edwards.Vartime)
+}
+
+func TestExtendedBaseMul(t *testing.T) {
+	c := newVartimeCurve()
+	for i := 0; i < 10; i++ {
+		s := c.Scalar().Pick(random.Stream)
+		exp := c.Point().Mul(c.Point().Base(), s)
+		if !exp.Equal(c.Point().Mul(nil, s)) {
+			t.Fatal("wrong multiplication of the base point")
+		}
+	}
+	zero := c.Scalar().Zero()
+	if !c.Point().Mul(nil, zero).Equal(c.Point().Null()) {
+		t.Fatal("wrong multiplication of the base point by 0")
+	}
+}
+
+// TestConstantTimeMul checks that the constant-time multiplications, the
+// default of every curve of Ed25519, match the double and add of Vartime.
+func TestConstantTimeMul(t *testing.T) {
+	vartime := newVartimeCurve()
+	// the groups to check and their vartime curves, the full group having
+	// another base point
+	groups := map[string][2]abstract.Group{
+		"extended": {newExtendedCurve(), vartime},
+		"full": {new(edwards.ExtendedCurve).Init(edwards.Param25519(), true),
+			new(edwards.ExtendedCurve).Init(edwards.Param25519(), true, edwards.Vartime)},
+		"proj":    {new(edwards.ProjectiveCurve).Init(edwards.Param25519(), false), vartime},
+		"ed25519": {ed25519.NewAES128SHA256Ed25519(false), vartime},
+	}
+	order := &edwards.Param25519().Q
+	scalars := []abstract.Scalar{vartime.Scalar().Zero(), vartime.Scalar().One(),
+		vartime.Scalar().SetInt64(0x1234),
+		nist.NewInt(new(big.Int).Sub(order, big.NewInt(1)), order)}
+	for i := 0; i < 10; i++ {
+		scalars = append(scalars, vartime.Scalar().Pick(random.Stream))
+	}
+	for _, s := range scalars {
+		P, _ := vartime.Point().Pick(nil, random.Stream)
+		buf, _ := P.MarshalBinary()
+		for name, g := range groups {
+			ct, vt := g[0], g[1]
+			Pct, Pvt := ct.Point(), vt.Point()
+			if err := Pct.UnmarshalBinary(buf); err != nil {
+				t.Fatal(err)
+			}
+			if err := Pvt.UnmarshalBinary(buf); err != nil {
+				t.Fatal(err)
+			}
+			sct, svt := ct.Scalar(), vt.Scalar()
+			sct.(*nist.Int).V.Set(&s.(*nist.Int).V)
+			svt.(*nist.Int).V.Set(&s.(*nist.Int).V)
+			exp, _ := vt.Point().Mul(Pvt, svt).MarshalBinary()
+			got, _ := ct.Point().Mul(Pct, sct).MarshalBinary()
+			if !bytes.Equal(exp, got) {
+				t.Fatal(name, "wrong constant time multiplication by", s)
+			}
+			exp, _ = vt.Point().Mul(nil, svt).MarshalBinary()
+			got, _ = ct.Point().Mul(nil, sct).MarshalBinary()
+			if !bytes.Equal(exp, got) {
+				t.Fatal(name, "wrong constant time multiplication of the base point by", s)
+			}
+		}
+	}
+}
+
+// TestConstantTimeMulBigInt checks the Montgomery ladder on the curves over
+// another field than 2^255-19, which computes with big.Ints.
+func TestConstantTimeMulBigInt(t *testing.T) {
+	vartime := new(edwards.ExtendedCurve).Init(edwards.Param1174(), false, edwards.Vartime)
+	c := new(edwards.ExtendedCurve).Init(edwards.Param1174(), false)
+	for i := 0; i < 10; i++ {
+		s := c.Scalar().Pick(random.Stream)
+		P, _ := vartime.Point().Pick(nil, random.Stream)
+		buf, _ := P.MarshalBinary()
+		Pc := c.Point()
+		if err := Pc.UnmarshalBinary(buf); err != nil {
+			t.Fatal(err)
+		}
+		exp, _ := vartime.Point().Mul(P, s).MarshalBinary()
+		got, _ := c.Point().Mul(Pc, s).MarshalBinary()
+		if !bytes.Equal(exp, got) {
+			t.Fatal("wrong constant time multiplication")
+		}
+		if !c.Point().Mul(nil, s).Equal(c.Point().Mul(c.Point().Base(), s)) {
+			t.Fatal("wrong constant time multiplication of the base point")
+		}
+	}
+	if !c.Point().Mul(nil, c.Scalar().Zero()).Equal(c.Point().Null()) {
+		t.Fatal("wrong constant time multiplication by 0")
+	}
+}
+
+func TestExtendedMulDoubleVartime(t *testing.T) {
+	for _, c := range []abstract.Group{newExtendedCurve(), newVartimeCurve()} {
+		p, ok := c.Point().(doubleMuler)
+		if !ok {
+			t.Fatal("extended points don't compute double multiplications")
+		}
+		for i := 0; i < 10; i++ {
+			a := c.Scalar().Pick(random.Stream)
+			b := c.Scalar().Pick(random.Stream)
+			A, _ := c.Point().Pick(nil, random.Stream)
+			exp := c.Point().Add(c.Point().Mul(A, a), c.Point().Mul(nil, b))
+			if !exp.Equal(p.MulDoubleVartime(a, A, b)) {
+				t.Fatal("wrong double multiplication")
+			}
+		}
+	}
+}
+
+func TestFieldElement(t *testing.T) {
+	p := &edwards.Param25519().P
+	max := new(big.Int).Sub(p, big.NewInt(1))
+	values := []*nist.Int{nist.NewInt64(0, p), nist.NewInt64(1, p),
+		nist.NewInt64(19, p), nist.NewInt(max, p)}
+	for i := 0; i < 20; i++ {
+		values = append(values, nist.NewInt(random.Int(p, random.Stream), p))
+	}
+	check := func(op string, exp abstract.Scalar, got *edwards.FieldElement) {
+		if !got.Int(nist.NewInt64(0, p)).Equal(exp) {
+			t.Fatalf("%s: got %s, expected %s", op,
+				got.Int(nist.NewInt64(0, p)), exp)
+		}
+	}
+	for _, a := range values {
+		var fa, r edwards.FieldElement
+		fa.SetInt(a)
+		check("set", a, &fa)
+		check("neg", nist.NewInt64(0, p).Neg(a), r.Neg(&fa))
+		check("square", nist.NewInt64(0, p).Mul(a, a), r.Square(&fa))
+		for _, b := range values {
+			var fb edwards.FieldElement
+			fb.SetInt(b)
+			check("add", nist.NewInt64(0, p).Add(a, b), r.Add(&fa, &fb))
+			check("sub", nist.NewInt64(0, p).Sub(a, b), r.Sub(&fa, &fb))
+			check("mul", nist.NewInt64(0, p).Mul(a, b), r.Mul(&fa, &fb))
+			if fb.Equal(&fa) != a.Equal(b) {
+				t.Fatal("wrong equality")
+			}
+		}
+	}
+
+	// chains of operations without reduction in between
+	var acc edwards.FieldElement
+	exp := nist.NewInt64(1, p)
+	acc.One()
+	for _, a := range values {
+		var fa edwards.FieldElement
+		fa.SetInt(a)
+		acc.Mul(&acc, &fa).Add(&acc, &acc).Sub(&acc, &fa).Neg(&acc)
+		exp.Mul(exp, a).Add(exp, exp).Sub(exp, a).Neg(exp)
+		check("chain", exp, &acc)
+	}
+
+	// non-canonical encodings reduce modulo p
+	var f edwards.FieldElement
+	buf := make([]byte, 32)
+	for i := range buf {
+		buf[i] = 0xff
+	}
+	check("2^255-1", nist.NewInt64(18, p), f.SetBytes(buf))
+	buf[31] = 0x7f
+	check("2^255-1", nist.NewInt64(18, p), f.SetBytes(buf))
+	var g edwards.FieldElement
+	g.Zero().Sub(&g, f.SetInt(nist.NewInt64(1, p)))
+	if !bytes.Equal(g.Bytes(), f.SetInt(values[3]).Bytes()) {
+		t.Fatal("wrong encoding of p-1")
+	}
+
+	a, b := *f.SetInt(values[5]), *g.SetInt(values[6])
+	a.Swap(&b, 0)
+	if !a.Equal(&f) || !b.Equal(&g) {
+		t.Fatal("swapped without the bit")
+	}
+	a.Swap(&b, 1)
+	if !a.Equal(&g) || !b.Equal(&f) {
+		t.Fatal("didn't swap")
+	}
+}
+
+func TestHashToPoint(t *testing.T) {
+	groups := []abstract.Group{
+		newExtendedCurve(),
+		new(edwards.ExtendedCurve).Init(edwards.Param25519(), true),
+		new(edwards.ProjectiveCurve).Init(edwards.Param25519(), false),
+		ed25519.NewAES128SHA256Ed25519(false),
+	}
+	for _, msg := range []string{"", "hello", "epoch 42"} {
+		var exp []byte
+		for _, g := range groups {
+			h := g.Point().(PointHasher).HashToPoint([]byte(msg))
+			buf, _ := h.MarshalBinary()
+			if exp == nil {
+				exp = buf
+			} else if !bytes.Equal(exp, buf) {
+				t.Fatal("curves hash to different points")
+			}
+			again := g.Point().(PointHasher).HashToPoint([]byte(msg))
+			if !h.Equal(again) {
+				t.Fatal("hash to point isn't deterministic")
+			}
+			if h.Equal(g.Point().Null()) {
+				t.Fatal("hashed to the identity")
+			}
+		}
+		// in the prime-order subgroup: q*P is the identity
+		c := new(edwards.ExtendedCurve).Init(edwards.Param25519(), true)
+		P := c.Point()
+		if err := P.UnmarshalBinary(exp); err != nil {
+			t.Fatal(err)
+		}
+		q := c.Scalar().(*nist.Int)
+		q.V.Set(&edwards.Param25519().Q)
+		if !c.Point().Mul(P, q).Equal(c.Point().Null()) {
+			t.Fatal("hashed point not in the prime-order subgroup")
+		}
+	}
+	suite := ed25519.NewAES128SHA256Ed25519(false)
+	if HashToPoint(suite, []byte("a")).Equal(HashToPoint(suite, []byte("b"))) {
+		t.Fatal("different messages hash to the same point")
+	}
+	// curves without a hash fall back to picking a point
+	p256 := nist.NewAES128SHA256P256()
+	if !HashToPoint(p256, []byte("a")).Equal(HashToPoint(p256, []byte("a"))) {
+		t.Fatal("hash to point isn't deterministic")
+	}
+}
+
+// BenchmarkExtendedAdd adds two points.
+func BenchmarkExtendedAdd(b *testing.B) {
+	c := newExtendedCurve()
+	P, _ := c.Point().Pick(nil, random.Stream)
+	Q, _ := c.Point().Pick(nil, random.Stream)
+	R := c.Point()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		R.Add(P, Q)
+	}
+}
+
+// BenchmarkExtendedBaseMul multiplies the base point with the precomputed
+// table of Vartime.
+func BenchmarkExtendedBaseMul(b *testing.B) {
+	c := newVartimeCurve()
+	s := c.Scalar().Pick(random.Stream)
+	c.Point().Mul(nil, s)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Point().Mul(nil, s)
+	}
+}
+
+// BenchmarkExtendedMul multiplies the base point bit by bit, by double and
+// add.
+func BenchmarkExtendedMul(b *testing.B) {
+	c := newVartimeCurve()
+	s := c.Scalar().Pick(random.Stream)
+	base := c.Point().Base()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Point().Mul(base, s)
+	}
+}
+
+// BenchmarkExtendedConstantTimeMul multiplies the base point with the
+// Montgomery ladder on field elements.
+func BenchmarkExtendedConstantTimeMul(b *testing.B) {
+	c := newExtendedCurve()
+	s := c.Scalar().Pick(random.Stream)
+	base := c.Point().Base()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Point().Mul(base, s)
+	}
+}
+
+func TestEd25519Options(t *testing.T) {
+	suite := edwards.NewAES128SHA256Ed25519(false)
+	vartime := edwards.NewAES128SHA256Ed25519Options(false, edwards.Vartime)
+	for i := 0; i < 10; i++ {
+		s := suite.Scalar().Pick(random.Stream)
+		P, _ := suite.Point().Pick(nil, random.Stream)
+		buf, _ := P.MarshalBinary()
+		Pv := vartime.Point()
+		if err := Pv.UnmarshalBinary(buf); err != nil {
+			t.Fatal(err)
+		}
+		exp, _ := vartime.Point().Mul(Pv, s).MarshalBinary()
+		got, _ := suite.Point().Mul(P, s).MarshalBinary()
+		if !bytes.Equal(exp, got) {
+			t.Fatal("wrong multiplication of the suite with options")
+		}
+	}
+}
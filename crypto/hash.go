@@ -112,6 +112,25 @@ func HashArgs(hash h.Hash, args ...interface{}) ([]byte, error) {
 	return res, nil
 }
 
+// PointHasher is implemented by the points of the curves that can hash a
+// message to a point directly, as the Edwards and Ed25519 curves do with the
+// Elligator 2 map.
+type PointHasher interface {
+	HashToPoint(msg []byte) abstract.Point
+}
+
+// HashToPoint returns the point of the suite that msg hashes to, of which
+// nobody knows the discrete logarithm. It uses the hash of the curve if it
+// has one, and otherwise picks a point with a cipher seeded by msg.
+func HashToPoint(suite abstract.Suite, msg []byte) abstract.Point {
+	p := suite.Point()
+	if h, ok := p.(PointHasher); ok {
+		return h.HashToPoint(msg)
+	}
+	p, _ = p.Pick(nil, suite.Cipher(msg))
+	return p
+}
+
 // HashArgsSuite makes a new hash from the suite and calls HashArgs
 func HashArgsSuite(suite abstract.Suite, args ...interface{}) ([]byte, error) {
 	return HashArgs(suite.Hash(), args...)
@@ -0,0 +1,32 @@
+package crypto
+
+import "sync/atomic"
+
+// SignatureOps are how many signatures the functions of this package created
+// and verified since the start of the process: the Schnorr signatures, one
+// per signature of a batch, and the collective signatures checked with a
+// SignaturePolicy.
+type SignatureOps struct {
+	Signed   uint64
+	Verified uint64
+}
+
+// signatureOps are the operations counted so far.
+var signatureOps SignatureOps
+
+// CountedOps returns the signature operations counted so far.
+func CountedOps() SignatureOps {
+	return SignatureOps{
+		Signed:   atomic.LoadUint64(&signatureOps.Signed),
+		Verified: atomic.LoadUint64(&signatureOps.Verified),
+	}
+}
+
+// countSigned and countVerified count n signatures created and verified.
+func countSigned(n int) {
+	atomic.AddUint64(&signatureOps.Signed, uint64(n))
+}
+
+func countVerified(n int) {
+	atomic.AddUint64(&signatureOps.Verified, uint64(n))
+}
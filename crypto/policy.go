@@ -0,0 +1,221 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+
+	"github.com/dedis/paper_17_sosp_omniledger/cosi"
+	"gopkg.in/dedis/crypto.v0/abstract"
+)
+
+// SignerMask is the bitmap of the members of a roster that signed: member i
+// is bit i%8 of byte i/8.
+type SignerMask []byte
+
+// NewSignerMask returns the mask of a roster of n members without signer.
+func NewSignerMask(n int) SignerMask {
+	return make(SignerMask, (n+7)/8)
+}
+
+// Set marks the i-th member as a signer.
+func (m SignerMask) Set(i int) error {
+	if i < 0 || i >= 8*len(m) {
+		return fmt.Errorf("unknown member %d", i)
+	}
+	m[i/8] |= 1 << uint(i%8)
+	return nil
+}
+
+// Signed tells whether the i-th member signed.
+func (m SignerMask) Signed(i int) bool {
+	return i >= 0 && i < 8*len(m) && m[i/8]&(1<<uint(i%8)) != 0
+}
+
+// Count returns the number of signers, in a time that only depends on the
+// size of the roster.
+func (m SignerMask) Count() int {
+	n := 0
+	for _, b := range m {
+		n += bits.OnesCount8(b)
+	}
+	return n
+}
+
+// MaskedSignature is a CoSi signature of the members of a roster that are
+// in its mask. The members out of the mask may have committed without
+// signing, so it also holds the sum of their commitments, which keeps its
+// size the same whatever the number of members that refused to sign.
+type MaskedSignature struct {
+	cosi.Signature
+	Mask SignerMask
+	// ExceptionCommit is the sum of the commitments of the members out of
+	// the mask, the null point if none committed
+	ExceptionCommit abstract.Point
+}
+
+// SignaturePolicy is the rule a collective signature of a roster has to
+// follow: at least Threshold of the members with the public keys have to
+// sign. The protocols use it to check their signatures instead of counting
+// the signers each on their own.
+type SignaturePolicy struct {
+	Suite     abstract.Suite
+	Publics   []abstract.Point
+	Threshold int
+}
+
+// NewSignaturePolicy returns the policy of threshold signers among the
+// members with the public keys.
+func NewSignaturePolicy(suite abstract.Suite, publics []abstract.Point,
+	threshold int) *SignaturePolicy {
+	return &SignaturePolicy{Suite: suite, Publics: publics, Threshold: threshold}
+}
+
+// Enough returns nil iff count signers are enough.
+func (p *SignaturePolicy) Enough(count int) error {
+	if count < p.Threshold {
+		return fmt.Errorf("only %d signers out of %d required", count,
+			p.Threshold)
+	}
+	return nil
+}
+
+// CheckMask returns nil iff the mask is one of the roster with enough
+// signers.
+func (p *SignaturePolicy) CheckMask(mask SignerMask) error {
+	if len(mask) != (len(p.Publics)+7)/8 {
+		return errors.New("signers mask doesn't match the roster")
+	}
+	for i := len(p.Publics); i < 8*len(mask); i++ {
+		if mask.Signed(i) {
+			return fmt.Errorf("unknown member %d", i)
+		}
+	}
+	return p.Enough(mask.Count())
+}
+
+// Signers returns the public keys of the signers of the mask.
+func (p *SignaturePolicy) Signers(mask SignerMask) []abstract.Point {
+	var signers []abstract.Point
+	for i, pub := range p.Publics {
+		if mask.Signed(i) {
+			signers = append(signers, pub)
+		}
+	}
+	return signers
+}
+
+// VerifyCollective returns nil iff sig is a CoSi signature on msg of the
+// members of the mask, and they are enough.
+func (p *SignaturePolicy) VerifyCollective(msg []byte, sig *cosi.Signature,
+	mask SignerMask) error {
+	if err := p.CheckMask(mask); err != nil {
+		return err
+	}
+	if sig == nil || sig.Challenge == nil || sig.Response == nil {
+		return errors.New("no signature")
+	}
+	countVerified(1)
+	aggregate := p.Suite.Point().Null()
+	for _, pub := range p.Signers(mask) {
+		aggregate.Add(aggregate, pub)
+	}
+	return cosi.VerifySignature(p.Suite, msg, aggregate, sig.Challenge,
+		sig.Response)
+}
+
+// ExceptionMask returns the mask of the members that are not among the
+// exceptions of a CoSi signature. Every exception has to be a different
+// member, or it could cancel any key out of the aggregate.
+func (p *SignaturePolicy) ExceptionMask(exceptions []cosi.Exception) (SignerMask, error) {
+	excepted := make([]bool, len(p.Publics))
+	for _, ex := range exceptions {
+		i := p.index(ex.Public)
+		if i < 0 || excepted[i] {
+			return nil, errors.New("exception of an unknown member")
+		}
+		excepted[i] = true
+	}
+	mask := NewSignerMask(len(p.Publics))
+	for i := range p.Publics {
+		if !excepted[i] {
+			mask.Set(i)
+		}
+	}
+	return mask, nil
+}
+
+// VerifyWithExceptions returns nil iff sig is a CoSi signature on msg of
+// all members but the exceptions, and they are enough.
+func (p *SignaturePolicy) VerifyWithExceptions(msg []byte, sig *cosi.Signature,
+	exceptions []cosi.Exception) error {
+	mask, err := p.ExceptionMask(exceptions)
+	if err != nil {
+		return err
+	}
+	if err := p.CheckMask(mask); err != nil {
+		return err
+	}
+	if sig == nil || sig.Challenge == nil || sig.Response == nil {
+		return errors.New("no signature")
+	}
+	countVerified(1)
+	aggregate := p.Suite.Point().Null()
+	for _, pub := range p.Publics {
+		aggregate.Add(aggregate, pub)
+	}
+	return cosi.VerifyCosiSignatureWithException(p.Suite, aggregate, msg, sig,
+		exceptions)
+}
+
+// NewMaskedSignature returns the signature of all members but the
+// exceptions, with their mask and the sum of their commitments.
+func (p *SignaturePolicy) NewMaskedSignature(sig *cosi.Signature,
+	exceptions []cosi.Exception) (*MaskedSignature, error) {
+	mask, err := p.ExceptionMask(exceptions)
+	if err != nil {
+		return nil, err
+	}
+	commit := p.Suite.Point().Null()
+	for _, ex := range exceptions {
+		if ex.Commitment != nil {
+			commit.Add(commit, ex.Commitment)
+		}
+	}
+	return &MaskedSignature{Signature: *sig, Mask: mask,
+		ExceptionCommit: commit}, nil
+}
+
+// VerifyMasked returns nil iff sig is a CoSi signature on msg of the
+// members of its mask, and they are enough.
+func (p *SignaturePolicy) VerifyMasked(msg []byte, sig *MaskedSignature) error {
+	if sig == nil {
+		return errors.New("no signature")
+	}
+	if err := p.CheckMask(sig.Mask); err != nil {
+		return err
+	}
+	if sig.Challenge == nil || sig.Response == nil || sig.ExceptionCommit == nil {
+		return errors.New("no signature")
+	}
+	countVerified(1)
+	aggregate := p.Suite.Point().Null()
+	for _, pub := range p.Signers(sig.Mask) {
+		aggregate.Add(aggregate, pub)
+	}
+	return cosi.VerifySignatureWithExceptionCommit(p.Suite, aggregate, msg,
+		sig.Challenge, sig.Response, sig.ExceptionCommit)
+}
+
+// index returns the position of the public key in the roster, or -1.
+func (p *SignaturePolicy) index(public abstract.Point) int {
+	if public == nil {
+		return -1
+	}
+	for i := range p.Publics {
+		if p.Publics[i].Equal(public) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,194 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/dedis/paper_17_sosp_omniledger/cosi"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/crypto.v0/ed25519"
+)
+
+func TestSignerMask(t *testing.T) {
+	m := NewSignerMask(10)
+	if len(m) != 2 || m.Count() != 0 {
+		t.Fatal("Wrong empty mask")
+	}
+	for _, i := range []int{0, 3, 9} {
+		if err := m.Set(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m.Set(16) == nil || m.Set(-1) == nil {
+		t.Fatal("Set an unknown member")
+	}
+	if !m.Signed(9) || m.Signed(1) || m.Count() != 3 {
+		t.Fatal("Wrong signers")
+	}
+}
+
+// cosiSign returns the publics of n members and the CoSi signature on msg
+// of the ones of the mask, the others never committing.
+func cosiSign(t *testing.T, suite abstract.Suite, n int, msg []byte,
+	mask SignerMask) ([]abstract.Point, *cosi.Signature) {
+	var publics []abstract.Point
+	var signers []*cosi.Cosi
+	for i := 0; i < n; i++ {
+		kp := config.NewKeyPair(suite)
+		publics = append(publics, kp.Public)
+		if mask.Signed(i) {
+			signers = append(signers, cosi.NewCosi(suite, kp.Secret))
+		}
+	}
+	root, children := signers[0], signers[1:]
+	var commitments []*cosi.Commitment
+	for _, c := range children {
+		commitments = append(commitments, c.CreateCommitment())
+	}
+	root.Commit(commitments)
+	chal, err := root.CreateChallenge(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var responses []*cosi.Response
+	for _, c := range children {
+		c.Challenge(chal)
+		r, err := c.CreateResponse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, r)
+	}
+	if _, err := root.Response(responses); err != nil {
+		t.Fatal(err)
+	}
+	return publics, root.Signature()
+}
+
+func TestSignaturePolicy(t *testing.T) {
+	suite := ed25519.NewAES128SHA256Ed25519(false)
+	msg := []byte("block")
+	mask := NewSignerMask(7)
+	for _, i := range []int{0, 1, 2, 4, 6} {
+		mask.Set(i)
+	}
+	publics, sig := cosiSign(t, suite, 7, msg, mask)
+
+	policy := NewSignaturePolicy(suite, publics, 5)
+	if err := policy.VerifyCollective(msg, sig, mask); err != nil {
+		t.Fatal("Couldn't verify collective signature:", err)
+	}
+	if policy.VerifyCollective([]byte("other"), sig, mask) == nil {
+		t.Fatal("Verified signature of another message")
+	}
+	// claiming another signer doesn't verify
+	other := append(SignerMask{}, mask...)
+	other.Set(3)
+	if policy.VerifyCollective(msg, sig, other) == nil {
+		t.Fatal("Verified signature with a wrong mask")
+	}
+	// members beyond the roster
+	other = append(SignerMask{}, mask...)
+	other.Set(7)
+	if policy.CheckMask(other) == nil {
+		t.Fatal("Accepted a mask with an unknown member")
+	}
+	if policy.CheckMask(NewSignerMask(9)) == nil {
+		t.Fatal("Accepted a mask of another roster")
+	}
+	// too few signers for a stricter policy
+	strict := NewSignaturePolicy(suite, publics, 6)
+	if strict.VerifyCollective(msg, sig, mask) == nil {
+		t.Fatal("Verified signature of too few signers")
+	}
+	if strict.Enough(6) != nil || strict.Enough(5) == nil {
+		t.Fatal("Wrong threshold")
+	}
+	if len(policy.Signers(mask)) != 5 || !policy.Signers(mask)[3].Equal(publics[4]) {
+		t.Fatal("Wrong signers")
+	}
+}
+
+func TestSignaturePolicyExceptions(t *testing.T) {
+	suite := ed25519.NewAES128SHA256Ed25519(false)
+	msg := []byte("block")
+	mask := NewSignerMask(4)
+	for _, i := range []int{0, 2, 3} {
+		mask.Set(i)
+	}
+	publics, sig := cosiSign(t, suite, 4, msg, mask)
+	exceptions := []cosi.Exception{{Public: publics[1],
+		Commitment: suite.Point().Null()}}
+
+	policy := NewSignaturePolicy(suite, publics, 3)
+	if err := policy.VerifyWithExceptions(msg, sig, exceptions); err != nil {
+		t.Fatal("Couldn't verify signature with exceptions:", err)
+	}
+	em, err := policy.ExceptionMask(exceptions)
+	if err != nil || string(em) != string(mask) {
+		t.Fatal("Wrong mask of the exceptions")
+	}
+	if NewSignaturePolicy(suite, publics, 4).VerifyWithExceptions(msg, sig,
+		exceptions) == nil {
+		t.Fatal("Verified signature with too many exceptions")
+	}
+	twice := append(exceptions, exceptions[0])
+	if policy.VerifyWithExceptions(msg, sig, twice) == nil {
+		t.Fatal("Verified signature with the same exception twice")
+	}
+	unknown := []cosi.Exception{{Public: suite.Point().Base(),
+		Commitment: suite.Point().Null()}}
+	if policy.VerifyWithExceptions(msg, sig, unknown) == nil {
+		t.Fatal("Verified signature with the exception of a stranger")
+	}
+}
+
+func TestMaskedSignature(t *testing.T) {
+	suite := ed25519.NewAES128SHA256Ed25519(false)
+	msg := []byte("block")
+	mask := NewSignerMask(4)
+	for _, i := range []int{0, 2, 3} {
+		mask.Set(i)
+	}
+	publics, sig := cosiSign(t, suite, 4, msg, mask)
+	exceptions := []cosi.Exception{{Public: publics[1],
+		Commitment: suite.Point().Null()}}
+
+	policy := NewSignaturePolicy(suite, publics, 3)
+	masked, err := policy.NewMaskedSignature(sig, exceptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(masked.Mask) != string(mask) {
+		t.Fatal("Wrong mask of the exceptions")
+	}
+	if err := policy.VerifyMasked(msg, masked); err != nil {
+		t.Fatal("Couldn't verify masked signature:", err)
+	}
+	if policy.VerifyMasked([]byte("other"), masked) == nil {
+		t.Fatal("Verified signature of another message")
+	}
+	if NewSignaturePolicy(suite, publics, 4).VerifyMasked(msg, masked) == nil {
+		t.Fatal("Verified signature of too few signers")
+	}
+	// claiming another signer or other commitments doesn't verify
+	other := *masked
+	other.Mask = append(SignerMask{}, mask...)
+	other.Mask.Set(1)
+	if policy.VerifyMasked(msg, &other) == nil {
+		t.Fatal("Verified signature with a wrong mask")
+	}
+	other = *masked
+	other.ExceptionCommit = suite.Point().Base()
+	if policy.VerifyMasked(msg, &other) == nil {
+		t.Fatal("Verified signature with wrong exception commitments")
+	}
+	other.ExceptionCommit = nil
+	if policy.VerifyMasked(msg, &other) == nil || policy.VerifyMasked(msg, nil) == nil {
+		t.Fatal("Verified an incomplete signature")
+	}
+	if _, err := policy.NewMaskedSignature(sig, append(exceptions,
+		exceptions[0])); err == nil {
+		t.Fatal("Masked the same exception twice")
+	}
+}
@@ -0,0 +1,52 @@
+package crypto
+
+import (
+	"sync"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+)
+
+// pools holds the suitePool of every suite used with AcquireScalar or
+// AcquirePoint.
+var pools sync.Map
+
+// suitePool are the scalars and points of a suite given back to be reused.
+type suitePool struct {
+	scalars, points sync.Pool
+}
+
+func poolOf(suite abstract.Suite) *suitePool {
+	if p, ok := pools.Load(suite); ok {
+		return p.(*suitePool)
+	}
+	p := &suitePool{}
+	p.scalars.New = func() interface{} { return suite.Scalar() }
+	p.points.New = func() interface{} { return suite.Point() }
+	actual, _ := pools.LoadOrStore(suite, p)
+	return actual.(*suitePool)
+}
+
+// AcquireScalar returns a scalar of the suite of any value, reusing one given
+// back with ReleaseScalar if there is, for the temporaries of the
+// computations done over and over.
+func AcquireScalar(suite abstract.Suite) abstract.Scalar {
+	return poolOf(suite).scalars.Get().(abstract.Scalar)
+}
+
+// ReleaseScalar gives back a scalar of AcquireScalar, which musn't be used
+// anymore. It is set to zero first, so that no secret stays in the pool.
+func ReleaseScalar(suite abstract.Suite, s abstract.Scalar) {
+	poolOf(suite).scalars.Put(s.Zero())
+}
+
+// AcquirePoint returns a point of the suite of any value, reusing one given
+// back with ReleasePoint if there is.
+func AcquirePoint(suite abstract.Suite) abstract.Point {
+	return poolOf(suite).points.Get().(abstract.Point)
+}
+
+// ReleasePoint gives back a point of AcquirePoint, which musn't be used
+// anymore.
+func ReleasePoint(suite abstract.Suite, p abstract.Point) {
+	poolOf(suite).points.Put(p)
+}
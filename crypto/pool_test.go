@@ -0,0 +1,42 @@
+package crypto
+
+import (
+	"testing"
+
+	"gopkg.in/dedis/crypto.v0/ed25519"
+	"gopkg.in/dedis/crypto.v0/nist"
+	"gopkg.in/dedis/crypto.v0/random"
+)
+
+func TestPool(t *testing.T) {
+	ed := ed25519.NewAES128SHA256Ed25519(false)
+	p256 := nist.NewAES128SHA256P256()
+
+	s := AcquireScalar(ed).Pick(random.Stream)
+	secret := s.Clone()
+	ReleaseScalar(ed, s)
+	if !s.Equal(ed.Scalar().Zero()) {
+		t.Fatal("Released scalar isn't zero")
+	}
+	for i := 0; i < 10; i++ {
+		if AcquireScalar(ed).Equal(secret) {
+			t.Fatal("Secret left in the pool")
+		}
+	}
+
+	// every suite has its own pool
+	P := AcquirePoint(ed).Base()
+	ReleasePoint(ed, P)
+	for i := 0; i < 10; i++ {
+		Q := AcquirePoint(p256).Base()
+		if !Q.Equal(p256.Point().Base()) {
+			t.Fatal("Wrong point of the pool")
+		}
+		ReleasePoint(p256, Q)
+		s := AcquireScalar(p256).One()
+		if s.MarshalSize() != p256.Scalar().MarshalSize() {
+			t.Fatal("Wrong scalar of the pool")
+		}
+		ReleaseScalar(p256, s)
+	}
+}
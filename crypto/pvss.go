@@ -0,0 +1,199 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dedis/paper_17_sosp_omniledger/gossip"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/random"
+)
+
+// This file implements the publicly verifiable secret sharing (PVSS) of
+// Schoenmakers. The dealer shares the secret s*B, where B is the base point,
+// among the trustees: the share of trustee i is encrypted to its public key
+// x_i*B and only trustee i can decrypt it, but anybody can check that the
+// encrypted shares are consistent with the commitments of the dealer, and
+// that the decrypted shares match the encrypted ones.
+
+// PVSSDeal is the output of a dealer: the commitments to its secret
+// polynomial and the encrypted shares for all trustees, with the proofs that
+// they match.
+type PVSSDeal struct {
+	// Commits are the coefficients of the polynomial times H
+	Commits []abstract.Point
+	// EncShares are the shares encrypted to the public keys of the trustees
+	EncShares []abstract.Point
+	// Proofs show that log_H(share commit) == log_X(encrypted share)
+	Proofs []gossip.DLEQProof
+}
+
+// PVSSDecShare is a share decrypted by a trustee, with the proof that it
+// matches the encrypted share.
+type PVSSDecShare struct {
+	// Trustee is the index of the trustee that decrypted the share
+	Trustee int
+	Share   abstract.Point
+	Proof   gossip.DLEQProof
+}
+
+// PVSSBase returns the point H used for the commitments, of which nobody
+// knows the discrete logarithm with respect to the base point.
+func PVSSBase(suite abstract.Suite) abstract.Point {
+	return HashToPoint(suite, []byte("randhound/H"))
+}
+
+// NewPVSSDeal shares secret*B among the trustees with the public keys, of
+// which threshold are needed to recover it.
+func NewPVSSDeal(suite abstract.Suite, secret abstract.Scalar,
+	publics []abstract.Point, threshold int) (*PVSSDeal, error) {
+	if threshold < 1 || threshold > len(publics) {
+		return nil, fmt.Errorf("invalid threshold %d for %d trustees",
+			threshold, len(publics))
+	}
+	H := PVSSBase(suite)
+	coeffs := make([]abstract.Scalar, threshold)
+	d := &PVSSDeal{}
+	for i := range coeffs {
+		if i == 0 {
+			coeffs[i] = secret
+		} else {
+			coeffs[i] = suite.Scalar().Pick(random.Stream)
+		}
+		d.Commits = append(d.Commits, suite.Point().Mul(H, coeffs[i]))
+	}
+	var shares []abstract.Scalar
+	var bases []abstract.Point
+	for i := range publics {
+		shares = append(shares, evaluate(suite, coeffs, i+1))
+		bases = append(bases, H)
+	}
+	proofs, _, enc, err := gossip.NewDLEQProofBatch(suite, bases, publics, shares)
+	if err != nil {
+		return nil, err
+	}
+	d.EncShares = enc
+	for _, p := range proofs {
+		d.Proofs = append(d.Proofs, *p)
+	}
+	return d, nil
+}
+
+// VerifyPVSSDeal checks that the deal holds an encrypted share for every
+// public key, consistent with the commitments.
+func VerifyPVSSDeal(suite abstract.Suite, publics []abstract.Point, threshold int,
+	d *PVSSDeal) error {
+	if len(d.Commits) != threshold {
+		return errors.New("wrong number of commitments")
+	}
+	if len(d.EncShares) != len(publics) || len(d.Proofs) != len(publics) {
+		return errors.New("wrong number of shares")
+	}
+	for i := range publics {
+		if err := VerifyPVSSEncShare(suite, publics[i], i, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyPVSSEncShare checks that the encrypted share of trustee index, with
+// the public key, is consistent with the commitments of the deal.
+func VerifyPVSSEncShare(suite abstract.Suite, public abstract.Point, index int,
+	d *PVSSDeal) error {
+	if index < 0 || index >= len(d.EncShares) || index >= len(d.Proofs) {
+		return errors.New("no share for the trustee")
+	}
+	commit := pvssCommitment(suite, d.Commits, index+1)
+	if err := d.Proofs[index].Verify(suite, PVSSBase(suite), public, commit,
+		d.EncShares[index]); err != nil {
+		return errors.New("invalid encrypted share")
+	}
+	return nil
+}
+
+// PVSSDecrypt returns the share of trustee index with the private key, and
+// the proof that it matches the encrypted share.
+func PVSSDecrypt(suite abstract.Suite, private abstract.Scalar, index int,
+	d *PVSSDeal) (*PVSSDecShare, error) {
+	if index < 0 || index >= len(d.EncShares) {
+		return nil, errors.New("no share for the trustee")
+	}
+	// x*B = X and x*S = Y, with S = Y/x
+	share := suite.Point().Mul(d.EncShares[index], suite.Scalar().Inv(private))
+	proof, _, _, err := gossip.NewDLEQProof(suite, suite.Point().Base(), share,
+		private)
+	if err != nil {
+		return nil, err
+	}
+	return &PVSSDecShare{Trustee: index, Share: share, Proof: *proof}, nil
+}
+
+// VerifyPVSSDecShare checks that the decrypted share of the trustee with the
+// public key matches its encrypted share in the deal.
+func VerifyPVSSDecShare(suite abstract.Suite, public abstract.Point, d *PVSSDeal,
+	ds *PVSSDecShare) error {
+	if ds.Trustee < 0 || ds.Trustee >= len(d.EncShares) {
+		return errors.New("no share for the trustee")
+	}
+	return ds.Proof.Verify(suite, suite.Point().Base(), ds.Share, public,
+		d.EncShares[ds.Trustee])
+}
+
+// RecoverPVSSSecret returns secret*B from the decrypted shares of distinct
+// trustees, by Lagrange interpolation in the exponent. It needs threshold
+// shares and only uses the first ones.
+func RecoverPVSSSecret(suite abstract.Suite, shares []PVSSDecShare,
+	threshold int) (abstract.Point, error) {
+	if len(shares) < threshold {
+		return nil, errors.New("not enough shares")
+	}
+	shares = shares[:threshold]
+	seen := make(map[int]bool)
+	for _, s := range shares {
+		if seen[s.Trustee] {
+			return nil, fmt.Errorf("two shares of trustee %d", s.Trustee)
+		}
+		seen[s.Trustee] = true
+	}
+	secret := suite.Point().Null()
+	for i := range shares {
+		xi := suite.Scalar().SetInt64(int64(shares[i].Trustee + 1))
+		num := suite.Scalar().One()
+		den := suite.Scalar().One()
+		for j := range shares {
+			if i == j {
+				continue
+			}
+			xj := suite.Scalar().SetInt64(int64(shares[j].Trustee + 1))
+			num.Mul(num, xj)
+			den.Mul(den, suite.Scalar().Sub(xj, xi))
+		}
+		lambda := suite.Scalar().Div(num, den)
+		secret.Add(secret, suite.Point().Mul(shares[i].Share, lambda))
+	}
+	return secret, nil
+}
+
+// evaluate returns the polynomial with the coefficients at x.
+func evaluate(suite abstract.Suite, coeffs []abstract.Scalar, x int) abstract.Scalar {
+	xs := suite.Scalar().SetInt64(int64(x))
+	v := suite.Scalar().Zero()
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		v.Mul(v, xs)
+		v.Add(v, coeffs[i])
+	}
+	return v
+}
+
+// pvssCommitment returns the commitment to the share at x, p(x)*H, computed
+// from the commitments to the coefficients.
+func pvssCommitment(suite abstract.Suite, commits []abstract.Point, x int) abstract.Point {
+	xs := suite.Scalar().SetInt64(int64(x))
+	v := suite.Point().Null()
+	for i := len(commits) - 1; i >= 0; i-- {
+		v.Mul(v, xs)
+		v.Add(v, commits[i])
+	}
+	return v
+}
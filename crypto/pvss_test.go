@@ -0,0 +1,109 @@
+package crypto
+
+import (
+	"testing"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/crypto.v0/ed25519"
+	"gopkg.in/dedis/crypto.v0/random"
+)
+
+func TestPVSS(t *testing.T) {
+	suite := ed25519.NewAES128SHA256Ed25519(false)
+	n, threshold := 7, 3
+	var keys []*config.KeyPair
+	var publics []abstract.Point
+	for i := 0; i < n; i++ {
+		kp := config.NewKeyPair(suite)
+		keys = append(keys, kp)
+		publics = append(publics, kp.Public)
+	}
+	secret := suite.Scalar().Pick(random.Stream)
+	d, err := NewPVSSDeal(suite, secret, publics, threshold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyPVSSDeal(suite, publics, threshold, d); err != nil {
+		t.Fatal("Couldn't verify deal:", err)
+	}
+
+	var shares []PVSSDecShare
+	for i := n - 1; i >= 0; i-- {
+		ds, err := PVSSDecrypt(suite, keys[i].Secret, i, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyPVSSDecShare(suite, publics[i], d, ds); err != nil {
+			t.Fatal("Couldn't verify decrypted share:", err)
+		}
+		shares = append(shares, *ds)
+	}
+	if _, err := RecoverPVSSSecret(suite, shares[:threshold-1], threshold); err == nil {
+		t.Fatal("Recovered the secret from too few shares")
+	}
+	dup := []PVSSDecShare{shares[0], shares[0], shares[1]}
+	if _, err := RecoverPVSSSecret(suite, dup, threshold); err == nil {
+		t.Fatal("Recovered the secret from the same share twice")
+	}
+	// any threshold shares recover the secret
+	for _, subset := range [][]PVSSDecShare{shares[:threshold], shares[n-threshold:]} {
+		recovered, err := RecoverPVSSSecret(suite, subset, threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !recovered.Equal(suite.Point().Mul(nil, secret)) {
+			t.Fatal("Recovered the wrong secret")
+		}
+	}
+}
+
+func TestPVSSInvalid(t *testing.T) {
+	suite := ed25519.NewAES128SHA256Ed25519(false)
+	var keys []*config.KeyPair
+	var publics []abstract.Point
+	for i := 0; i < 4; i++ {
+		kp := config.NewKeyPair(suite)
+		keys = append(keys, kp)
+		publics = append(publics, kp.Public)
+	}
+	if _, err := NewPVSSDeal(suite, suite.Scalar().One(), publics, 5); err == nil {
+		t.Fatal("Dealt with a threshold above the number of trustees")
+	}
+	d, err := NewPVSSDeal(suite, suite.Scalar().Pick(random.Stream), publics, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if VerifyPVSSDeal(suite, publics, 3, d) == nil {
+		t.Fatal("Verified a deal with the wrong threshold")
+	}
+	if VerifyPVSSEncShare(suite, publics[1], 0, d) == nil {
+		t.Fatal("Verified the share of another trustee")
+	}
+
+	// a share encrypted to the wrong key
+	forged := *d
+	forged.EncShares = append([]abstract.Point(nil), d.EncShares...)
+	forged.EncShares[2] = suite.Point().Add(publics[2], suite.Point().Base())
+	if VerifyPVSSDeal(suite, publics, 2, &forged) == nil {
+		t.Fatal("Verified a deal with a forged share")
+	}
+
+	// a decrypted share that doesn't match
+	ds, err := PVSSDecrypt(suite, keys[0].Secret, 0, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds.Share = suite.Point().Base()
+	if VerifyPVSSDecShare(suite, publics[0], d, ds) == nil {
+		t.Fatal("Verified a forged decrypted share")
+	}
+	// decrypting with the wrong key
+	ds, err = PVSSDecrypt(suite, keys[1].Secret, 0, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if VerifyPVSSDecShare(suite, publics[0], d, ds) == nil {
+		t.Fatal("Verified a share decrypted with the wrong key")
+	}
+}
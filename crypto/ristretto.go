@@ -0,0 +1,314 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/big"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/edwards"
+	"gopkg.in/dedis/crypto.v0/group"
+)
+
+// Ristretto is a group of prime order over the points of Curve25519 of an
+// edwards.ExtendedCurve. The curve has a cofactor of 8, so an encoded point
+// can hide a small torsion component, and protocols on the raw curve have to
+// multiply the points they get by the cofactor or check their order. The
+// Ristretto encoding (RFC 9496) instead gives the same encoding to all the
+// points differing by a torsion point of order 4, and only decodes the ones
+// it could encode, so that the points of this group behave as a group of
+// prime order. The arithmetic is done by the underlying curve.
+type Ristretto struct {
+	curve edwards.ExtendedCurve
+}
+
+// NewRistretto returns the Ristretto group over a new ExtendedCurve with the
+// options.
+func NewRistretto(options ...edwards.Option) *Ristretto {
+	r := &Ristretto{}
+	r.curve.Init(edwards.Param25519(), false, options...)
+	return r
+}
+
+// String returns the name of the group.
+func (r *Ristretto) String() string {
+	return "Ristretto255"
+}
+
+// ScalarLen returns the length of an encoded scalar.
+func (r *Ristretto) ScalarLen() int {
+	return r.curve.ScalarLen()
+}
+
+// Scalar returns a new scalar modulo the prime order of the group.
+func (r *Ristretto) Scalar() abstract.Scalar {
+	return r.curve.Scalar()
+}
+
+// PointLen returns the length of an encoded point.
+func (r *Ristretto) PointLen() int {
+	return 32
+}
+
+// Point returns a new point.
+func (r *Ristretto) Point() abstract.Point {
+	return &ristrettoPoint{p: r.curve.Point(), g: r}
+}
+
+// PrimeOrder returns true: the group has a prime order.
+func (r *Ristretto) PrimeOrder() bool {
+	return true
+}
+
+// ristrettoPoint is a point of the underlying curve, standing for all the
+// points that only differ from it by a torsion point of order 4.
+type ristrettoPoint struct {
+	p abstract.Point
+	g *Ristretto
+}
+
+// raw returns the point of the underlying curve of the point.
+func raw(p abstract.Point) abstract.Point {
+	return p.(*ristrettoPoint).p
+}
+
+func (p *ristrettoPoint) Equal(p2 abstract.Point) bool {
+	x1, y1 := affine(p.p)
+	x2, y2 := affine(raw(p2))
+	return feMul(x1, y2).Cmp(feMul(y1, x2)) == 0 ||
+		feMul(y1, y2).Cmp(feMul(x1, x2)) == 0
+}
+
+func (p *ristrettoPoint) Null() abstract.Point {
+	p.p.Null()
+	return p
+}
+
+func (p *ristrettoPoint) Base() abstract.Point {
+	p.p.Base()
+	return p
+}
+
+// Pick picks a random point. The encoding doesn't keep the points of the
+// underlying curve, so no data can be embedded: it is returned as is.
+func (p *ristrettoPoint) Pick(data []byte, rand cipher.Stream) (abstract.Point, []byte) {
+	p.p.Mul(nil, p.g.Scalar().Pick(rand))
+	return p, data
+}
+
+func (p *ristrettoPoint) PickLen() int {
+	return 0
+}
+
+func (p *ristrettoPoint) Set(p2 abstract.Point) abstract.Point {
+	p.p.Set(raw(p2))
+	return p
+}
+
+func (p *ristrettoPoint) Clone() abstract.Point {
+	return &ristrettoPoint{p: p.p.Clone(), g: p.g}
+}
+
+func (p *ristrettoPoint) Data() ([]byte, error) {
+	return nil, errors.New("no data embedded in a Ristretto point")
+}
+
+func (p *ristrettoPoint) Add(a, b abstract.Point) abstract.Point {
+	p.p.Add(raw(a), raw(b))
+	return p
+}
+
+func (p *ristrettoPoint) Sub(a, b abstract.Point) abstract.Point {
+	p.p.Sub(raw(a), raw(b))
+	return p
+}
+
+func (p *ristrettoPoint) Neg(a abstract.Point) abstract.Point {
+	p.p.Neg(raw(a))
+	return p
+}
+
+// Mul multiplies the point a by the scalar, or the base point if a is nil.
+func (p *ristrettoPoint) Mul(a abstract.Point, s abstract.Scalar) abstract.Point {
+	if a == nil {
+		p.p.Mul(nil, s)
+	} else {
+		p.p.Mul(raw(a), s)
+	}
+	return p
+}
+
+func (p *ristrettoPoint) String() string {
+	buf, _ := p.MarshalBinary()
+	return hex.EncodeToString(buf)
+}
+
+func (p *ristrettoPoint) MarshalSize() int {
+	return 32
+}
+
+// MarshalBinary returns the Ristretto encoding of the point, following
+// section 4.3.2 of RFC 9496 with Z = 1.
+func (p *ristrettoPoint) MarshalBinary() ([]byte, error) {
+	x0, y0 := affine(p.p)
+	t0 := feMul(x0, y0)
+	u1 := feMul(feAdd(feOne, y0), feSub(feOne, y0))
+	u2 := t0
+	_, invsqrt := sqrtRatioM1(feOne, feMul(u1, feMul(u2, u2)))
+	den1 := feMul(invsqrt, u1)
+	den2 := feMul(invsqrt, u2)
+	zInv := feMul(feMul(den1, den2), t0)
+	x, y, denInv := x0, y0, den2
+	if feNegative(feMul(t0, zInv)) {
+		x = feMul(y0, sqrtM1)
+		y = feMul(x0, sqrtM1)
+		denInv = feMul(den1, invsqrtAMinusD)
+	}
+	if feNegative(feMul(x, zInv)) {
+		y = feNeg(y)
+	}
+	s := feAbs(feMul(denInv, feSub(feOne, y)))
+	return feBytes(s), nil
+}
+
+// UnmarshalBinary sets the point to the encoded one, following section 4.3.1
+// of RFC 9496. It returns an error for the encodings of no point, including
+// the non-canonical ones.
+func (p *ristrettoPoint) UnmarshalBinary(buf []byte) error {
+	if len(buf) != 32 {
+		return errors.New("wrong length of a Ristretto point")
+	}
+	s := feFromBytes(buf)
+	if s.Cmp(fieldP) >= 0 || feNegative(s) {
+		return errors.New("non-canonical Ristretto point")
+	}
+	ss := feMul(s, s)
+	u1 := feSub(feOne, ss)
+	u2 := feAdd(feOne, ss)
+	u2u2 := feMul(u2, u2)
+	v := feSub(feNeg(feMul(curveD, feMul(u1, u1))), u2u2)
+	square, invsqrt := sqrtRatioM1(feOne, feMul(v, u2u2))
+	denX := feMul(invsqrt, u2)
+	denY := feMul(feMul(invsqrt, denX), v)
+	x := feAbs(feMul(feAdd(s, s), denX))
+	y := feMul(u1, denY)
+	if !square || feNegative(feMul(x, y)) || y.Sign() == 0 {
+		return errors.New("invalid Ristretto point")
+	}
+	return p.p.UnmarshalBinary(edwardsBytes(x, y))
+}
+
+func (p *ristrettoPoint) MarshalTo(w io.Writer) (int, error) {
+	return group.PointMarshalTo(p, w)
+}
+
+func (p *ristrettoPoint) UnmarshalFrom(r io.Reader) (int, error) {
+	return group.PointUnmarshalFrom(p, r)
+}
+
+// The field arithmetic of the encoding, modulo 2^255 - 19, on values in
+// [0, p).
+var (
+	fieldP, _         = new(big.Int).SetString("57896044618658097711785492504343953926634992332820282019728792003956564819949", 10)
+	feOne             = big.NewInt(1)
+	curveD            = &edwards.Param25519().D
+	sqrtM1            = new(big.Int).Exp(big.NewInt(2), new(big.Int).Rsh(new(big.Int).Sub(fieldP, feOne), 2), fieldP)
+	_, invsqrtAMinusD = sqrtRatioM1(feOne, feSub(feNeg(feOne), curveD))
+)
+
+func feAdd(a, b *big.Int) *big.Int {
+	r := new(big.Int).Add(a, b)
+	return r.Mod(r, fieldP)
+}
+
+func feSub(a, b *big.Int) *big.Int {
+	r := new(big.Int).Sub(a, b)
+	return r.Mod(r, fieldP)
+}
+
+func feMul(a, b *big.Int) *big.Int {
+	r := new(big.Int).Mul(a, b)
+	return r.Mod(r, fieldP)
+}
+
+func feNeg(a *big.Int) *big.Int {
+	r := new(big.Int).Neg(a)
+	return r.Mod(r, fieldP)
+}
+
+// feNegative tells whether the value is negative, that is odd.
+func feNegative(a *big.Int) bool {
+	return a.Bit(0) == 1
+}
+
+// feAbs returns the non-negative one of a and -a.
+func feAbs(a *big.Int) *big.Int {
+	if feNegative(a) {
+		return feNeg(a)
+	}
+	return a
+}
+
+// feBytes returns the 32 bytes little-endian encoding of the value.
+func feBytes(a *big.Int) []byte {
+	buf := a.FillBytes(make([]byte, 32))
+	for i, j := 0, len(buf)-1; i < j; i, j = i+1, j-1 {
+		buf[i], buf[j] = buf[j], buf[i]
+	}
+	return buf
+}
+
+// feFromBytes returns the value of the little-endian encoding.
+func feFromBytes(buf []byte) *big.Int {
+	be := make([]byte, len(buf))
+	for i := range buf {
+		be[len(buf)-1-i] = buf[i]
+	}
+	return new(big.Int).SetBytes(be)
+}
+
+// sqrtRatioM1 returns whether u/v is a square, and the non-negative square
+// root of u/v if it is, or of sqrt(-1)*u/v if it isn't. It returns 0 if v is
+// 0.
+func sqrtRatioM1(u, v *big.Int) (bool, *big.Int) {
+	v3 := feMul(feMul(v, v), v)
+	v7 := feMul(feMul(v3, v3), v)
+	e := new(big.Int).Rsh(new(big.Int).Sub(fieldP, big.NewInt(5)), 3)
+	r := feMul(feMul(u, v3), new(big.Int).Exp(feMul(u, v7), e, fieldP))
+	check := feMul(v, feMul(r, r))
+	correct := check.Cmp(u) == 0
+	flipped := check.Cmp(feNeg(u)) == 0
+	flippedI := check.Cmp(feMul(feNeg(u), sqrtM1)) == 0
+	if flipped || flippedI {
+		r = feMul(r, sqrtM1)
+	}
+	return correct || flipped, feAbs(r)
+}
+
+// affine returns the affine coordinates of the point of Curve25519, from
+// its Ed25519 encoding.
+func affine(p abstract.Point) (x, y *big.Int) {
+	buf, _ := p.MarshalBinary()
+	sign := buf[31] >> 7
+	buf[31] &^= 0x80
+	y = feFromBytes(buf)
+	// -x^2 + y^2 = 1 + d*x^2*y^2, so x^2 = (y^2 - 1) / (d*y^2 + 1)
+	yy := feMul(y, y)
+	_, x = sqrtRatioM1(feSub(yy, feOne), feAdd(feMul(curveD, yy), feOne))
+	if uint(x.Bit(0)) != uint(sign) {
+		x = feNeg(x)
+	}
+	return x, y
+}
+
+// edwardsBytes returns the Ed25519 encoding of the point with the affine
+// coordinates.
+func edwardsBytes(x, y *big.Int) []byte {
+	buf := feBytes(y)
+	if feNegative(x) {
+		buf[31] |= 0x80
+	}
+	return buf
+}
@@ -0,0 +1,169 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/edwards"
+	"gopkg.in/dedis/crypto.v0/random"
+)
+
+func TestRistrettoVectors(t *testing.T) {
+	// multiples of the base point from section A.1 of RFC 9496
+	vectors := []string{
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"e2f2ae0a6abc4e71a884a961c500515f58e30b6aa582dd8db6a65945e08d2d76",
+		"6a493210f7499cd17fecb510ae0cea23a110e8d5b901f8acadd3095c73a3b919",
+	}
+	r := NewRistretto()
+	for i, v := range vectors {
+		p := r.Point().Mul(nil, r.Scalar().SetInt64(int64(i)))
+		buf, err := p.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(buf) != v {
+			t.Fatalf("Wrong encoding of %d*B: %x", i, buf)
+		}
+		dec := r.Point()
+		if err := dec.UnmarshalBinary(buf); err != nil {
+			t.Fatal(err)
+		}
+		if !dec.Equal(p) {
+			t.Fatalf("Decoded %d*B to another point", i)
+		}
+	}
+}
+
+func TestRistrettoMarshal(t *testing.T) {
+	r := NewRistretto()
+	for i := 0; i < 50; i++ {
+		p, _ := r.Point().Pick(nil, random.Stream)
+		buf, err := p.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec := r.Point()
+		if err := dec.UnmarshalBinary(buf); err != nil {
+			t.Fatal(err)
+		}
+		if !dec.Equal(p) {
+			t.Fatal("Point changed by a round trip")
+		}
+		buf2, _ := dec.MarshalBinary()
+		if !bytes.Equal(buf, buf2) {
+			t.Fatal("Encoding changed by a round trip")
+		}
+
+		var w bytes.Buffer
+		if _, err := p.MarshalTo(&w); err != nil {
+			t.Fatal(err)
+		}
+		dec = r.Point()
+		if _, err := dec.UnmarshalFrom(&w); err != nil {
+			t.Fatal(err)
+		}
+		if !dec.Equal(p) {
+			t.Fatal("Point changed by a round trip through a stream")
+		}
+	}
+}
+
+func TestRistrettoCurve(t *testing.T) {
+	r := NewRistretto()
+	var curve edwards.ExtendedCurve
+	curve.Init(edwards.Param25519(), false)
+
+	// the group computes the same points as the raw curve
+	a := r.Scalar().Pick(random.Stream)
+	b := r.Scalar().Pick(random.Stream)
+	pa := r.Point().Mul(nil, a)
+	pb := r.Point().Mul(nil, b)
+	ca := curve.Point().Mul(nil, a)
+	cb := curve.Point().Mul(nil, b)
+	check := func(p, c abstract.Point) {
+		if !raw(p).Equal(c) {
+			t.Fatal("Group and curve disagree")
+		}
+		enc, _ := p.MarshalBinary()
+		dec := r.Point()
+		if err := dec.UnmarshalBinary(enc); err != nil {
+			t.Fatal(err)
+		}
+		if !dec.Equal(r.Point().Set(&ristrettoPoint{p: c, g: r})) {
+			t.Fatal("Encoding doesn't match the curve point")
+		}
+	}
+	check(pa, ca)
+	check(r.Point().Add(pa, pb), curve.Point().Add(ca, cb))
+	check(r.Point().Sub(pa, pb), curve.Point().Sub(ca, cb))
+	check(r.Point().Neg(pa), curve.Point().Neg(ca))
+	check(r.Point().Mul(pa, b), curve.Point().Mul(ca, b))
+	check(r.Point().Base(), curve.Point().Base())
+	check(r.Point().Null(), curve.Point().Null())
+
+	if !r.Point().Mul(pa, b).Equal(r.Point().Mul(pb, a)) {
+		t.Fatal("Diffie-Hellman keys differ")
+	}
+	if pa.Equal(pb) {
+		t.Fatal("Different points are equal")
+	}
+}
+
+func TestRistrettoTorsion(t *testing.T) {
+	r := NewRistretto()
+	var curve edwards.ExtendedCurve
+	curve.Init(edwards.Param25519(), true)
+
+	// (sqrt(-1), 0) is a point of order 4 of the curve
+	t4 := curve.Point()
+	if err := t4.UnmarshalBinary(edwardsBytes(sqrtM1, new(big.Int))); err != nil {
+		t.Fatal(err)
+	}
+	p, _ := r.Point().Pick(nil, random.Stream)
+	enc, _ := p.MarshalBinary()
+	q := p.Clone()
+	for i := 1; i < 4; i++ {
+		q = &ristrettoPoint{p: curve.Point().Add(raw(q), t4), g: r}
+		if raw(q).Equal(raw(p)) {
+			t.Fatal("Torsion point of the wrong order")
+		}
+		if !q.Equal(p) {
+			t.Fatal("Point plus a torsion point isn't equal to the point")
+		}
+		encq, _ := q.MarshalBinary()
+		if !bytes.Equal(enc, encq) {
+			t.Fatal("Point plus a torsion point has another encoding")
+		}
+	}
+}
+
+func TestRistrettoInvalid(t *testing.T) {
+	r := NewRistretto()
+	for _, v := range []string{
+		// non-canonical field elements, from section A.2 of RFC 9496
+		"00ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+		"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+		"f3ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+		"edffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+		// negative field elements
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"01ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+		// non-square x^2
+		"26948d35ca62e643e26a83177332e6b6afeb9d08e4268b650f1f5bbd8d81d371",
+		"4eac077a713c57b4f4397629a4145982c661f48044dd3f96427d40b147d9742f",
+		// s = -1 maps to the identity with y = 0
+		"ecffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+	} {
+		buf, _ := hex.DecodeString(v)
+		if r.Point().UnmarshalBinary(buf) == nil {
+			t.Fatal("Decoded an invalid encoding", v)
+		}
+	}
+	if r.Point().UnmarshalBinary(make([]byte, 31)) == nil {
+		t.Fatal("Decoded a short encoding")
+	}
+}
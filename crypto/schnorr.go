@@ -1,7 +1,10 @@
 package crypto
 
 import (
+	"bytes"
+	"crypto/hmac"
 	"errors"
+	"io"
 
 	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/crypto.v0/random"
@@ -13,6 +16,16 @@ type SchnorrSig struct {
 	Response  abstract.Scalar
 }
 
+// BatchSchnorrSig is a SchnorrSig with the commitment of the signer, needed
+// to verify signatures in batch, see VerifySchnorrBatch. The commitment is
+// given by the challenge and the response, but computing it costs as much as
+// verifying the signature, so the signer sends it along: a point more than
+// the SchnorrSig.
+type BatchSchnorrSig struct {
+	Sig        SchnorrSig
+	Commitment abstract.Point
+}
+
 // MarshalBinary is used for example in hashing
 func (ss *SchnorrSig) MarshalBinary() ([]byte, error) {
 	cbuf, err := ss.Challenge.MarshalBinary()
@@ -23,38 +36,84 @@ func (ss *SchnorrSig) MarshalBinary() ([]byte, error) {
 	return append(cbuf, rbuf...), err
 }
 
+// SignOption is an option of SignSchnorr.
+type SignOption int
+
+const (
+	// DeterministicNonce derives the secret k of the signature from the
+	// private key and the hash of the message as in RFC 6979, instead of
+	// picking it from random.Stream. Signing the same message twice gives the
+	// same signature, and nodes whose random streams are seeded alike, as in
+	// simulations, can't sign different messages with the same k, which would
+	// reveal their private key.
+	DeterministicNonce SignOption = iota + 1
+)
+
 // SignSchnorr creates a Schnorr signature from a msg and a private key
-func SignSchnorr(suite abstract.Suite, private abstract.Scalar, msg []byte) (SchnorrSig, error) {
+func SignSchnorr(suite abstract.Suite, private abstract.Scalar, msg []byte,
+	options ...SignOption) (SchnorrSig, error) {
+	sig, err := SignSchnorrBatch(suite, private, msg, options...)
+	return sig.Sig, err
+}
+
+// SignSchnorrBatch is SignSchnorr keeping the commitment, for the signature
+// to be verified in batch.
+func SignSchnorrBatch(suite abstract.Suite, private abstract.Scalar, msg []byte,
+	options ...SignOption) (BatchSchnorrSig, error) {
+	countSigned(1)
 	// using notation from https://en.wikipedia.org/wiki/Schnorr_signature
 	// create random secret k and public point commitment r
-	k := suite.Scalar().Pick(random.Stream)
+	var k abstract.Scalar
+	for _, o := range options {
+		if o == DeterministicNonce {
+			var err error
+			if k, err = deterministicNonce(suite, private, msg); err != nil {
+				return BatchSchnorrSig{}, err
+			}
+		}
+	}
+	if k == nil {
+		k = AcquireScalar(suite).Pick(random.Stream)
+	}
+	defer ReleaseScalar(suite, k)
 	r := suite.Point().Mul(nil, k)
 
 	// create challenge e based on message and r
 	e, err := hash(suite, r, msg)
 	if err != nil {
-		return SchnorrSig{}, err
+		return BatchSchnorrSig{}, err
 	}
 
 	// compute response s = k - x*e
-	xe := suite.Scalar().Mul(private, e)
+	xe := AcquireScalar(suite).Mul(private, e)
+	defer ReleaseScalar(suite, xe)
 	s := suite.Scalar().Sub(k, xe)
 
-	return SchnorrSig{Challenge: e, Response: s}, nil
+	return BatchSchnorrSig{Sig: SchnorrSig{Challenge: e, Response: s}, Commitment: r}, nil
 }
 
 // VerifySchnorr verifies a given Schnorr signature. It returns nil iff the given signature is valid.
 func VerifySchnorr(suite abstract.Suite, public abstract.Point, msg []byte, sig SchnorrSig) error {
+	countVerified(1)
 	// compute rv = g^s * y^e (where y = g^x)
-	gs := suite.Point().Mul(nil, sig.Response)
-	ye := suite.Point().Mul(public, sig.Challenge)
-	rv := suite.Point().Add(gs, ye)
+	rv := AcquirePoint(suite)
+	defer ReleasePoint(suite, rv)
+	if p, ok := rv.(doubleMuler); ok {
+		p.MulDoubleVartime(sig.Challenge, public, sig.Response)
+	} else {
+		gs := AcquirePoint(suite).Mul(nil, sig.Response)
+		ye := AcquirePoint(suite).Mul(public, sig.Challenge)
+		rv.Add(gs, ye)
+		ReleasePoint(suite, gs)
+		ReleasePoint(suite, ye)
+	}
 
 	// recompute challenge (e) from rv
 	e, err := hash(suite, rv, msg)
 	if err != nil {
 		return err
 	}
+	defer ReleaseScalar(suite, e)
 
 	if !e.Equal(sig.Challenge) {
 		return errors.New("Signature not valid: Reconstructed challenge isn't equal to challenge in signature")
@@ -63,6 +122,109 @@ func VerifySchnorr(suite abstract.Suite, public abstract.Point, msg []byte, sig
 	return nil
 }
 
+// SignSchnorrReader signs the message read from r, without holding it in
+// memory: it signs the hash of the message with the hash function of the
+// suite, so the signature can also be checked with VerifySchnorr on that
+// hash, as returned by SchnorrDigest.
+func SignSchnorrReader(suite abstract.Suite, private abstract.Scalar, r io.Reader,
+	options ...SignOption) (SchnorrSig, error) {
+	digest, err := SchnorrDigest(suite, r)
+	if err != nil {
+		return SchnorrSig{}, err
+	}
+	return SignSchnorr(suite, private, digest, options...)
+}
+
+// VerifySchnorrReader verifies the signature of the message read from r made
+// with SignSchnorrReader.
+func VerifySchnorrReader(suite abstract.Suite, public abstract.Point, r io.Reader, sig SchnorrSig) error {
+	digest, err := SchnorrDigest(suite, r)
+	if err != nil {
+		return err
+	}
+	return VerifySchnorr(suite, public, digest, sig)
+}
+
+// SchnorrDigest returns the hash of the message read from r that
+// SignSchnorrReader signs, reading r until its end.
+func SchnorrDigest(suite abstract.Suite, r io.Reader) ([]byte, error) {
+	h := suite.Hash()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// deterministicNonce returns the secret k of the signature of msg for
+// DeterministicNonce.
+func deterministicNonce(suite abstract.Suite, private abstract.Scalar, msg []byte) (abstract.Scalar, error) {
+	x, err := private.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	h := suite.Hash()
+	h.Write(msg)
+	h1, err := suite.Scalar().SetBytes(h.Sum(nil)).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return suite.Scalar().Pick(newHMACDRBG(suite, x, h1)), nil
+}
+
+// hmacDRBG is the HMAC_DRBG generating k in section 3.2 of RFC 6979, as a
+// stream to pick the scalar from. Each call of XORKeyStream generates a
+// candidate, the ones after the first updating the state as for a candidate
+// out of range.
+type hmacDRBG struct {
+	suite     abstract.Suite
+	k, v      []byte
+	generated bool
+}
+
+// newHMACDRBG returns the generator, on the hash function of the suite, of
+// the private key x and the hash h1 of the message, both encoded as scalars.
+func newHMACDRBG(suite abstract.Suite, x, h1 []byte) *hmacDRBG {
+	d := &hmacDRBG{suite: suite}
+	size := suite.Hash().Size()
+	d.v = bytes.Repeat([]byte{1}, size)
+	d.k = make([]byte, size)
+	d.k = d.mac(d.v, []byte{0}, x, h1)
+	d.v = d.mac(d.v)
+	d.k = d.mac(d.v, []byte{1}, x, h1)
+	d.v = d.mac(d.v)
+	return d
+}
+
+func (d *hmacDRBG) mac(data ...[]byte) []byte {
+	m := hmac.New(d.suite.Hash, d.k)
+	for _, b := range data {
+		m.Write(b)
+	}
+	return m.Sum(nil)
+}
+
+func (d *hmacDRBG) XORKeyStream(dst, src []byte) {
+	if d.generated {
+		d.k = d.mac(d.v, []byte{0})
+		d.v = d.mac(d.v)
+	}
+	d.generated = true
+	var t []byte
+	for len(t) < len(src) {
+		d.v = d.mac(d.v)
+		t = append(t, d.v...)
+	}
+	for i := range src {
+		dst[i] = src[i] ^ t[i]
+	}
+}
+
+// doubleMuler is implemented by the points computing a*A + b*B, B being the
+// base point, faster than with two multiplications, e.g. on Ed25519.
+type doubleMuler interface {
+	MulDoubleVartime(a abstract.Scalar, A abstract.Point, b abstract.Scalar) abstract.Point
+}
+
 func hash(suite abstract.Suite, r abstract.Point, msg []byte) (abstract.Scalar, error) {
 	rBuf, err := r.MarshalBinary()
 	if err != nil {
@@ -0,0 +1,208 @@
+package crypto
+
+import (
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/random"
+)
+
+// VerifySchnorrBatch verifies the signatures of SignSchnorrBatch of the
+// messages by the public keys, the i-th signature being of the i-th message
+// by the i-th key. It returns nil iff all the signatures are valid. Instead
+// of one verification per signature, it checks a random linear combination
+// of them with a single multi-exponentiation, so a wrong signature is only
+// detected as such with overwhelming probability, and to know which
+// signatures are wrong, they have to be verified one by one, each in a
+// batch of its own. Signatures without Commitment are verified one by one
+// with VerifySchnorr.
+//
+// The batch is checked with the cofactor, see verifyBatch: a commitment or a
+// public key off the prime-order subgroup, by a point of small order, is
+// accepted, in a batch of any size, as long as the signature is valid in the
+// prime-order subgroup.
+func VerifySchnorrBatch(suite abstract.Suite, pubs []abstract.Point, msgs [][]byte, sigs []BatchSchnorrSig) error {
+	if len(pubs) != len(sigs) || len(msgs) != len(sigs) {
+		return errors.New("not as many public keys, messages and signatures")
+	}
+	entries := make([]batchEntry, 0, len(sigs))
+	for i, sig := range sigs {
+		if sig.Commitment == nil {
+			if err := VerifySchnorr(suite, pubs[i], msgs[i], sig.Sig); err != nil {
+				return fmt.Errorf("signature %d: %v", i, err)
+			}
+			continue
+		}
+		// the challenge must be the hash of the commitment, then
+		// g^s * y^e = r has to hold
+		e, err := hash(suite, sig.Commitment, msgs[i])
+		if err != nil {
+			return err
+		}
+		if !e.Equal(sig.Sig.Challenge) {
+			return fmt.Errorf("signature %d: challenge isn't the hash of the commitment", i)
+		}
+		entries = append(entries, batchEntry{
+			public:    pubs[i],
+			commit:    sig.Commitment,
+			response:  sig.Sig.Response,
+			challenge: sig.Sig.Challenge,
+		})
+	}
+	return verifyBatch(suite, entries)
+}
+
+// VerifySignSchnorrBatch is VerifySchnorrBatch for the signatures of
+// gopkg.in/dedis/crypto.v0/sign.Schnorr, i.e. the encoded commitment
+// followed by the encoded response.
+func VerifySignSchnorrBatch(suite abstract.Suite, pubs []abstract.Point, msgs [][]byte, sigs [][]byte) error {
+	if len(pubs) != len(sigs) || len(msgs) != len(sigs) {
+		return errors.New("not as many public keys, messages and signatures")
+	}
+	pointSize := suite.Point().MarshalSize()
+	size := pointSize + suite.Scalar().MarshalSize()
+	entries := make([]batchEntry, len(sigs))
+	for i, sig := range sigs {
+		if len(sig) != size {
+			return fmt.Errorf("signature %d: invalid length %d instead of %d", i, len(sig), size)
+		}
+		r := suite.Point()
+		if err := r.UnmarshalBinary(sig[:pointSize]); err != nil {
+			return fmt.Errorf("signature %d: %v", i, err)
+		}
+		s := suite.Scalar()
+		if err := s.UnmarshalBinary(sig[pointSize:]); err != nil {
+			return fmt.Errorf("signature %d: %v", i, err)
+		}
+		// g^s = r * y^h, i.e. g^s * y^-h = r
+		h := sha512.New()
+		if _, err := r.MarshalTo(h); err != nil {
+			return err
+		}
+		if _, err := pubs[i].MarshalTo(h); err != nil {
+			return err
+		}
+		h.Write(msgs[i])
+		c := suite.Scalar().SetBytes(h.Sum(nil))
+		entries[i] = batchEntry{
+			public:    pubs[i],
+			commit:    r,
+			response:  s,
+			challenge: suite.Scalar().Neg(c),
+		}
+	}
+	return verifyBatch(suite, entries)
+}
+
+// batchEntry is a signature for which g^response * public^challenge = commit
+// has to hold.
+type batchEntry struct {
+	public    abstract.Point
+	commit    abstract.Point
+	response  abstract.Scalar
+	challenge abstract.Scalar
+}
+
+// verifyBatch checks that the sum of z * (response*G + challenge*public -
+// commit) over the entries, multiplied by the cofactor 8 of the Edwards
+// curves, is null, with a random z for each entry. Without the cofactor, a
+// component of small order in a commitment would only cancel out for some z,
+// and the batch would pass or fail at random. The doublings are a bijection
+// in the groups of odd order, e.g. P-256, so the check holds for them, too.
+func verifyBatch(suite abstract.Suite, entries []batchEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	countVerified(len(entries))
+	base := suite.Scalar().Zero()
+	scalars := make([]abstract.Scalar, 0, 2*len(entries)+1)
+	points := make([]abstract.Point, 0, 2*len(entries)+1)
+	for _, e := range entries {
+		z := suite.Scalar().Pick(random.Stream)
+		base.Add(base, suite.Scalar().Mul(z, e.response))
+		scalars = append(scalars, suite.Scalar().Mul(z, e.challenge), suite.Scalar().Neg(z))
+		points = append(points, e.public, e.commit)
+	}
+	scalars = append(scalars, base)
+	points = append(points, suite.Point().Base())
+	sum := multiExp(suite, scalars, points)
+	for i := 0; i < 3; i++ {
+		sum.Add(sum, sum)
+	}
+	if !sum.Equal(suite.Point().Null()) {
+		return errors.New("Signatures not valid: batch verification failed")
+	}
+	return nil
+}
+
+// multiExp returns the sum of the points multiplied by the scalars, using
+// the bucket method of Pippenger: the doublings are shared by all the
+// points, and each window of the scalars costs about one addition per point.
+func multiExp(suite abstract.Suite, scalars []abstract.Scalar, points []abstract.Point) abstract.Point {
+	// the scalars as little endian bytes
+	one, _ := suite.Scalar().One().MarshalBinary()
+	bigEndian := len(one) > 1 && one[0] != 1
+	digits := make([][]byte, len(scalars))
+	nbits := 0
+	for i, s := range scalars {
+		b, _ := s.MarshalBinary()
+		if bigEndian {
+			for l, r := 0, len(b)-1; l < r; l, r = l+1, r-1 {
+				b[l], b[r] = b[r], b[l]
+			}
+		}
+		digits[i] = b
+		if 8*len(b) > nbits {
+			nbits = 8 * len(b)
+		}
+	}
+
+	c := int(math.Log(float64(len(points)))) + 1
+	if c > 16 {
+		c = 16
+	}
+	result := suite.Point().Null()
+	for w := (nbits+c-1)/c - 1; w >= 0; w-- {
+		for i := 0; i < c; i++ {
+			result = suite.Point().Add(result, result)
+		}
+		buckets := make([]abstract.Point, 1<<uint(c))
+		for i, b := range digits {
+			d := window(b, w*c, c)
+			if d == 0 {
+				continue
+			}
+			if buckets[d] == nil {
+				buckets[d] = suite.Point().Null()
+			}
+			buckets[d] = suite.Point().Add(buckets[d], points[i])
+		}
+		// sum of d * buckets[d]
+		running := suite.Point().Null()
+		sum := suite.Point().Null()
+		for d := len(buckets) - 1; d > 0; d-- {
+			if buckets[d] != nil {
+				running = suite.Point().Add(running, buckets[d])
+			}
+			sum = suite.Point().Add(sum, running)
+		}
+		result = suite.Point().Add(result, sum)
+	}
+	return result
+}
+
+// window returns the c bits of the little endian b starting at bit.
+func window(b []byte, bit, c int) int {
+	d := 0
+	for i := 0; i < c; i++ {
+		j := bit + i
+		if j/8 >= len(b) {
+			break
+		}
+		d |= int(b[j/8]>>uint(j%8)&1) << uint(i)
+	}
+	return d
+}
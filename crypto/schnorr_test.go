@@ -1,10 +1,20 @@
 package crypto
 
 import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"testing"
+	"testing/iotest"
 
+	"github.com/dedis/protobuf"
+	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/crypto.v0/config"
 	"gopkg.in/dedis/crypto.v0/ed25519"
+	"gopkg.in/dedis/crypto.v0/nist"
+	"gopkg.in/dedis/crypto.v0/random"
+	"gopkg.in/dedis/crypto.v0/sign"
 )
 
 func TestSchnorrSignature(t *testing.T) {
@@ -21,3 +31,319 @@ func TestSchnorrSignature(t *testing.T) {
 		t.Fatalf("Couldn't verify signature: \n%+v\nfor msg:'%s'. Error:\n%v", s, msg, err)
 	}
 }
+
+func TestSchnorrSigSize(t *testing.T) {
+	suite := ed25519.NewAES128SHA256Ed25519(false)
+	kp := config.NewKeyPair(suite)
+	sig, err := SignSchnorr(suite, kp.Secret, []byte("Hello Schnorr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the commitment of the batch verification isn't part of the signature,
+	// neither in its binary encoding nor in its messages
+	buf, err := sig.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf) != 2*suite.ScalarLen() {
+		t.Fatalf("signature of %d bytes", len(buf))
+	}
+	buf, err = protobuf.Encode(&sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a tag and a length per scalar
+	if len(buf) != 2*suite.ScalarLen()+4 {
+		t.Fatalf("encoded signature of %d bytes", len(buf))
+	}
+}
+
+func TestCountedOps(t *testing.T) {
+	suite := ed25519.NewAES128SHA256Ed25519(false)
+	kp := config.NewKeyPair(suite)
+	msgs := [][]byte{[]byte("Hello"), []byte("Schnorr")}
+	before := CountedOps()
+	sigs := make([]BatchSchnorrSig, len(msgs))
+	for i, msg := range msgs {
+		var err error
+		if sigs[i], err = SignSchnorrBatch(suite, kp.Secret, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := VerifySchnorr(suite, kp.Public, msgs[0], sigs[0].Sig); err != nil {
+		t.Fatal(err)
+	}
+	pubs := []abstract.Point{kp.Public, kp.Public}
+	if err := VerifySchnorrBatch(suite, pubs, msgs, sigs); err != nil {
+		t.Fatal(err)
+	}
+	after := CountedOps()
+	if after.Signed-before.Signed != 2 || after.Verified-before.Verified != 3 {
+		t.Fatalf("counted %+v after %+v", after, before)
+	}
+}
+
+func TestSchnorrDeterministic(t *testing.T) {
+	suite := ed25519.NewAES128SHA256Ed25519(false)
+	kp := config.NewKeyPair(suite)
+	msg := []byte("Hello Schnorr")
+
+	s1, err := SignSchnorrBatch(suite, kp.Secret, msg, DeterministicNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySchnorr(suite, kp.Public, msg, s1.Sig); err != nil {
+		t.Fatal("Couldn't verify signature:", err)
+	}
+	s2, _ := SignSchnorrBatch(suite, kp.Secret, msg, DeterministicNonce)
+	if !s1.Commitment.Equal(s2.Commitment) || !s1.Sig.Response.Equal(s2.Sig.Response) {
+		t.Fatal("Different signatures of the same message")
+	}
+	s3, _ := SignSchnorrBatch(suite, kp.Secret, []byte("other"), DeterministicNonce)
+	if s1.Commitment.Equal(s3.Commitment) {
+		t.Fatal("Same nonce for different messages")
+	}
+	other := config.NewKeyPair(suite)
+	s4, _ := SignSchnorrBatch(suite, other.Secret, msg, DeterministicNonce)
+	if s1.Commitment.Equal(s4.Commitment) {
+		t.Fatal("Same nonce for different keys")
+	}
+	s5, _ := SignSchnorrBatch(suite, kp.Secret, msg)
+	if s1.Commitment.Equal(s5.Commitment) {
+		t.Fatal("Random nonce is the deterministic one")
+	}
+
+	// the nonces of RFC 6979, A.2.5, with P-256 and SHA-256
+	p256 := nist.NewAES128SHA256P256()
+	x := p256.Scalar()
+	x.UnmarshalBinary(fromHex(t, "C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721"))
+	for msg, k := range map[string]string{
+		"sample": "A6E3C57DD01ABE90086538398355DD4C3B17AA873382B0F24D6129493D8AAD60",
+		"test":   "D16B6AE827F17175E040871A1C7EC3500192C4C92677336EC2537ACAEE0008E0",
+	} {
+		nonce, err := deterministicNonce(p256, x, []byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, _ := nonce.MarshalBinary()
+		if !bytes.Equal(buf, fromHex(t, k)) {
+			t.Fatalf("Wrong nonce for %q: %x", msg, buf)
+		}
+	}
+}
+
+func fromHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestSchnorrReader(t *testing.T) {
+	suite := ed25519.NewAES128SHA256Ed25519(false)
+	kp := config.NewKeyPair(suite)
+	msg := bytes.Repeat([]byte("a large block "), 100000)
+
+	s, err := SignSchnorrReader(suite, kp.Secret, bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal("Couldn't sign stream:", err)
+	}
+	if err := VerifySchnorrReader(suite, kp.Public, bytes.NewReader(msg), s); err != nil {
+		t.Fatal("Couldn't verify signature of stream:", err)
+	}
+	digest, err := SchnorrDigest(suite, bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySchnorr(suite, kp.Public, digest, s); err != nil {
+		t.Fatal("Couldn't verify signature of the digest:", err)
+	}
+	if VerifySchnorrReader(suite, kp.Public, bytes.NewReader(msg[1:]), s) == nil {
+		t.Fatal("Verified signature of another stream")
+	}
+	fail := errors.New("read failed")
+	if _, err := SignSchnorrReader(suite, kp.Secret, iotest.ErrReader(fail)); err != fail {
+		t.Fatal("Signed a failing stream")
+	}
+}
+
+func TestVerifySchnorrBatch(t *testing.T) {
+	suite := ed25519.NewAES128SHA256Ed25519(false)
+	n := 20
+	pubs := make([]abstract.Point, n)
+	msgs := make([][]byte, n)
+	sigs := make([]BatchSchnorrSig, n)
+	for i := range sigs {
+		kp := config.NewKeyPair(suite)
+		pubs[i] = kp.Public
+		msgs[i] = []byte(fmt.Sprintf("Hello Schnorr %d", i))
+		var err error
+		sigs[i], err = SignSchnorrBatch(suite, kp.Secret, msgs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := VerifySchnorrBatch(suite, pubs, msgs, sigs); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySchnorrBatch(suite, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if VerifySchnorrBatch(suite, pubs[1:], msgs, sigs) == nil {
+		t.Fatal("wrong signatures verified")
+	}
+
+	// without commitment, the signature is verified alone
+	sigs[0].Commitment = nil
+	if err := VerifySchnorrBatch(suite, pubs, msgs, sigs); err != nil {
+		t.Fatal(err)
+	}
+
+	wrong := append([][]byte{}, msgs...)
+	wrong[5] = []byte("Bye Schnorr")
+	if VerifySchnorrBatch(suite, pubs, wrong, sigs) == nil {
+		t.Fatal("wrong signatures verified")
+	}
+
+	// a response not matching the commitment
+	sigs[7].Sig.Response = suite.Scalar().Add(sigs[7].Sig.Response, suite.Scalar().One())
+	if VerifySchnorrBatch(suite, pubs, msgs, sigs) == nil {
+		t.Fatal("wrong signatures verified")
+	}
+}
+
+func TestVerifySchnorrBatchTorsion(t *testing.T) {
+	suite := ed25519.NewAES128SHA256Ed25519(false)
+	// (0, -1), of order 2
+	torsion := suite.Point()
+	if err := torsion.UnmarshalBinary(fromHex(t,
+		"ecffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f")); err != nil {
+		t.Fatal(err)
+	}
+	n := 50
+	pubs := make([]abstract.Point, n)
+	msgs := make([][]byte, n)
+	sigs := make([]BatchSchnorrSig, n)
+	for i := range sigs {
+		kp := config.NewKeyPair(suite)
+		pubs[i] = kp.Public
+		msgs[i] = []byte(fmt.Sprintf("Hello Schnorr %d", i))
+		// a valid signature, but for the torsion in the commitment
+		k := suite.Scalar().Pick(random.Stream)
+		r := suite.Point().Mul(nil, k)
+		r.Add(r, torsion)
+		e, err := hash(suite, r, msgs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := suite.Scalar().Sub(k, suite.Scalar().Mul(kp.Secret, e))
+		sigs[i] = BatchSchnorrSig{Sig: SchnorrSig{Challenge: e, Response: s}, Commitment: r}
+	}
+	// the batches don't depend on the random combination
+	for round := 0; round < 10; round++ {
+		if err := VerifySchnorrBatch(suite, pubs, msgs, sigs); err != nil {
+			t.Fatal(round, err)
+		}
+		for i := range sigs {
+			if err := VerifySchnorrBatch(suite, pubs[i:i+1], msgs[i:i+1], sigs[i:i+1]); err != nil {
+				t.Fatal(round, i, err)
+			}
+		}
+	}
+	sigs[3].Sig.Response = suite.Scalar().Add(sigs[3].Sig.Response, suite.Scalar().One())
+	for round := 0; round < 10; round++ {
+		if VerifySchnorrBatch(suite, pubs, msgs, sigs) == nil {
+			t.Fatal("wrong signatures verified")
+		}
+	}
+}
+
+func TestVerifySignSchnorrBatch(t *testing.T) {
+	suite := ed25519.NewAES128SHA256Ed25519(false)
+	n := 20
+	pubs := make([]abstract.Point, n)
+	msgs := make([][]byte, n)
+	sigs := make([][]byte, n)
+	for i := range sigs {
+		kp := config.NewKeyPair(suite)
+		pubs[i] = kp.Public
+		msgs[i] = []byte(fmt.Sprintf("Hello Schnorr %d", i))
+		var err error
+		sigs[i], err = sign.Schnorr(suite, kp.Secret, msgs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := VerifySignSchnorrBatch(suite, pubs, msgs, sigs); err != nil {
+		t.Fatal(err)
+	}
+
+	pubs[3], pubs[4] = pubs[4], pubs[3]
+	if VerifySignSchnorrBatch(suite, pubs, msgs, sigs) == nil {
+		t.Fatal("wrong signatures verified")
+	}
+	pubs[3], pubs[4] = pubs[4], pubs[3]
+
+	sigs[2] = sigs[2][1:]
+	if VerifySignSchnorrBatch(suite, pubs, msgs, sigs) == nil {
+		t.Fatal("wrong signatures verified")
+	}
+}
+
+func TestMultiExp(t *testing.T) {
+	suite := ed25519.NewAES128SHA256Ed25519(false)
+	for _, n := range []int{1, 2, 10, 100} {
+		scalars := make([]abstract.Scalar, n)
+		points := make([]abstract.Point, n)
+		sum := suite.Point().Null()
+		for i := range points {
+			scalars[i] = suite.Scalar().Pick(random.Stream)
+			points[i], _ = suite.Point().Pick(nil, random.Stream)
+			sum.Add(sum, suite.Point().Mul(points[i], scalars[i]))
+		}
+		if !sum.Equal(multiExp(suite, scalars, points)) {
+			t.Fatal("wrong multi-exponentiation of", n, "points")
+		}
+	}
+}
+
+func TestMulDoubleVartime(t *testing.T) {
+	suite := ed25519.NewAES128SHA256Ed25519(false)
+	p, ok := suite.Point().(doubleMuler)
+	if !ok {
+		t.Fatal("Ed25519 points don't compute double multiplications")
+	}
+	for i := 0; i < 10; i++ {
+		a := suite.Scalar().Pick(random.Stream)
+		b := suite.Scalar().Pick(random.Stream)
+		A, _ := suite.Point().Pick(nil, random.Stream)
+		exp := suite.Point().Add(suite.Point().Mul(A, a), suite.Point().Mul(nil, b))
+		if !exp.Equal(p.MulDoubleVartime(a, A, b)) {
+			t.Fatal("wrong double multiplication")
+		}
+	}
+}
+
+func BenchmarkSignSchnorr(b *testing.B) {
+	msg := []byte("Hello Schnorr")
+	suite := ed25519.NewAES128SHA256Ed25519(false)
+	kp := config.NewKeyPair(suite)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		SignSchnorr(suite, kp.Secret, msg)
+	}
+}
+
+func BenchmarkVerifySchnorr(b *testing.B) {
+	msg := []byte("Hello Schnorr")
+	suite := ed25519.NewAES128SHA256Ed25519(false)
+	kp := config.NewKeyPair(suite)
+	s, _ := SignSchnorr(suite, kp.Secret, msg)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		VerifySchnorr(suite, kp.Public, msg, s)
+	}
+}
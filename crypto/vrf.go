@@ -0,0 +1,105 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/random"
+)
+
+// VRFProof proves that the output of the verifiable random function has
+// been computed with the private key of a given public key. Gamma is x*H(msg),
+// and (Challenge, Response) show that log_B(X) == log_H(msg)(Gamma).
+type VRFProof struct {
+	Gamma     abstract.Point
+	Challenge abstract.Scalar
+	Response  abstract.Scalar
+}
+
+// VRFProve computes the output of the verifiable random function of the
+// private key on msg, and the proof for it. Only the owner of the private
+// key can compute the output, but everybody can check it with VRFVerify.
+func VRFProve(suite abstract.Suite, private abstract.Scalar, msg []byte) ([]byte, *VRFProof, error) {
+	h := hashToPoint(suite, msg)
+	gamma := suite.Point().Mul(h, private)
+
+	// same notation as SignSchnorr: commit to k for both bases
+	k := suite.Scalar().Pick(random.Stream)
+	kB := suite.Point().Mul(nil, k)
+	kH := suite.Point().Mul(h, k)
+	public := suite.Point().Mul(nil, private)
+	c, err := vrfChallenge(suite, public, h, gamma, kB, kH)
+	if err != nil {
+		return nil, nil, err
+	}
+	// s = k - x*c
+	s := suite.Scalar().Sub(k, suite.Scalar().Mul(private, c))
+	proof := &VRFProof{Gamma: gamma, Challenge: c, Response: s}
+	output, err := vrfOutput(proof.Gamma)
+	if err != nil {
+		return nil, nil, err
+	}
+	return output, proof, nil
+}
+
+// VRFVerify checks that output is the output of the verifiable random
+// function of the private key of public on msg. It returns nil iff the
+// proof is valid.
+func VRFVerify(suite abstract.Suite, public abstract.Point, msg, output []byte,
+	proof *VRFProof) error {
+	if proof == nil || proof.Gamma == nil || proof.Challenge == nil ||
+		proof.Response == nil {
+		return errors.New("incomplete proof")
+	}
+	h := hashToPoint(suite, msg)
+	// kB = s*B + c*X and kH = s*H + c*Gamma
+	kB := suite.Point().Add(suite.Point().Mul(nil, proof.Response),
+		suite.Point().Mul(public, proof.Challenge))
+	kH := suite.Point().Add(suite.Point().Mul(h, proof.Response),
+		suite.Point().Mul(proof.Gamma, proof.Challenge))
+	c, err := vrfChallenge(suite, public, h, proof.Gamma, kB, kH)
+	if err != nil {
+		return err
+	}
+	if !c.Equal(proof.Challenge) {
+		return errors.New("VRF proof not valid: reconstructed challenge isn't equal to challenge in proof")
+	}
+	expected, err := vrfOutput(proof.Gamma)
+	if err != nil {
+		return err
+	}
+	if string(expected) != string(output) {
+		return errors.New("VRF output doesn't match the proof")
+	}
+	return nil
+}
+
+// hashToPoint maps msg to a point of which nobody knows the discrete
+// logarithm.
+func hashToPoint(suite abstract.Suite, msg []byte) abstract.Point {
+	return HashToPoint(suite, append([]byte("vrf/"), msg...))
+}
+
+// vrfChallenge returns the challenge of the proof over all the points.
+func vrfChallenge(suite abstract.Suite, points ...abstract.Point) (abstract.Scalar, error) {
+	var buf []byte
+	for _, p := range points {
+		b, err := p.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b...)
+	}
+	return suite.Scalar().Pick(suite.Cipher(buf)), nil
+}
+
+// vrfOutput returns the output of the function from Gamma.
+func vrfOutput(gamma abstract.Point) ([]byte, error) {
+	buf, err := gamma.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	out := sha256.Sum256(buf)
+	return out[:], nil
+}
@@ -0,0 +1,44 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+
+	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/crypto.v0/ed25519"
+)
+
+func TestVRF(t *testing.T) {
+	msg := []byte("Hello VRF")
+	suite := ed25519.NewAES128SHA256Ed25519(false)
+	kp := config.NewKeyPair(suite)
+
+	output, proof, err := VRFProve(suite, kp.Secret, msg)
+	if err != nil {
+		t.Fatalf("Couldn't compute VRF of msg: %s: %v", msg, err)
+	}
+	if err = VRFVerify(suite, kp.Public, msg, output, proof); err != nil {
+		t.Fatalf("Couldn't verify VRF proof: \n%+v\nfor msg:'%s'. Error:\n%v", proof, msg, err)
+	}
+
+	// the output is unique: proving again gives the same output
+	output2, _, err := VRFProve(suite, kp.Secret, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, output2) {
+		t.Fatal("Two outputs for the same message")
+	}
+
+	if VRFVerify(suite, kp.Public, []byte("other"), output, proof) == nil {
+		t.Fatal("Proof verified for another message")
+	}
+	other := config.NewKeyPair(suite)
+	if VRFVerify(suite, other.Public, msg, output, proof) == nil {
+		t.Fatal("Proof verified for another key")
+	}
+	output[0] ^= 1
+	if VRFVerify(suite, kp.Public, msg, output, proof) == nil {
+		t.Fatal("Proof verified for another output")
+	}
+}
@@ -0,0 +1,222 @@
+// Package faults injects faults in the messages of the protocols of a
+// simulation, the same way for all of them: some hosts crash at a given
+// round, a share of the messages, or of the messages of a type, is lost, and
+// the messages are delayed as drawn from a distribution. The simulations
+// tell the rounds with StartRound and EndRound, which records in the
+// monitor which rounds experienced faults.
+//
+// The round is the one of the root, so the crashes only start at their
+// round on the hosts running in the process of the root, as in a localhost
+// simulation.
+package faults
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/netem"
+	"github.com/dedis/paper_17_sosp_omniledger/seed"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/simul/monitor"
+)
+
+// Config are the faults of a simulation, embedded in the config of the
+// simulations so that they are read from the TOML file. No faults if empty.
+type Config struct {
+	// CrashShare is the share of the hosts that crash at the start of
+	// round CrashRound: they neither send nor receive any message after.
+	// They are the last hosts of the roster, so the root never crashes.
+	CrashShare float64
+	CrashRound int
+	// DropShare is the share of the messages that are lost, only the ones
+	// of the type named DropType if it isn't empty, e.g. "Prepare".
+	DropShare float64
+	DropType  string
+	// Delay is how the messages are delayed: "constant" by DelayMs,
+	// "uniform" between 0 and twice DelayMs, "exponential" of mean DelayMs
+	// or "normal" of mean DelayMs and standard deviation DelayJitterMs. No
+	// delay if empty.
+	Delay         string
+	DelayMs       float64
+	DelayJitterMs float64
+}
+
+// check returns an error if the shares are not between 0 and 1 or the
+// distribution of the delays is unknown.
+func (c Config) check() error {
+	if c.CrashShare < 0 || c.CrashShare >= 1 {
+		return errors.New("the share of crashed hosts must be in [0, 1)")
+	}
+	if c.DropShare < 0 || c.DropShare > 1 {
+		return errors.New("the share of lost messages must be in [0, 1]")
+	}
+	switch c.Delay {
+	case "", "constant", "uniform", "exponential", "normal":
+		return nil
+	}
+	return errors.New("unknown delay distribution " + c.Delay)
+}
+
+// crashed returns whether the host of the roster index is crashed in the
+// round, out of hosts.
+func (c Config) crashed(index, hosts, round int) bool {
+	if c.CrashShare == 0 || round < c.CrashRound {
+		return false
+	}
+	crashed := int(math.Ceil(c.CrashShare * float64(hosts)))
+	if crashed >= hosts {
+		crashed = hosts - 1
+	}
+	return index >= hosts-crashed
+}
+
+// delay returns how long to hold back a message.
+func (c Config) delay(rng *rand.Rand) time.Duration {
+	var ms float64
+	switch c.Delay {
+	case "constant":
+		ms = c.DelayMs
+	case "uniform":
+		ms = rng.Float64() * 2 * c.DelayMs
+	case "exponential":
+		ms = rng.ExpFloat64() * c.DelayMs
+	case "normal":
+		ms = c.DelayMs + rng.NormFloat64()*c.DelayJitterMs
+	}
+	if ms <= 0 {
+		return 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// Stats are the faults injected during a round.
+type Stats struct {
+	// Crashed is how many hosts are crashed
+	Crashed int
+	// Dropped and Delayed are how many messages were lost and delayed
+	Dropped int
+	Delayed int
+}
+
+// Faulty returns whether any fault was injected.
+func (s Stats) Faulty() bool {
+	return s.Crashed > 0 || s.Dropped > 0 || s.Delayed > 0
+}
+
+// injector are the faults of the nodes of this process, see Use.
+var injector struct {
+	sync.Mutex
+	config Config
+	rand   *rand.Rand
+	round  int
+	stats  Stats
+}
+
+// Use makes SendTo inject the faults of the config in the messages of the
+// protocols of this process, drawn from the "faults" stream of package seed.
+// The simulations call it on every node, before the rounds start.
+func Use(c Config) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	injector.Lock()
+	defer injector.Unlock()
+	injector.config = c
+	injector.rand = seed.Rand("faults")
+	injector.round = 0
+	injector.stats = Stats{}
+	return nil
+}
+
+// StartRound starts counting the faults of the round. Calling it again for
+// the same round goes on counting.
+func StartRound(round int) {
+	injector.Lock()
+	defer injector.Unlock()
+	if round != injector.round {
+		injector.round = round
+		injector.stats = Stats{}
+	}
+}
+
+// EndRound returns the faults injected since the start of the round, and
+// records them in the monitor: "faults_round" is 1 for the rounds that
+// experienced faults and 0 for the others.
+func EndRound(hosts int) Stats {
+	injector.Lock()
+	c, round, stats := injector.config, injector.round, injector.stats
+	injector.Unlock()
+	for i := 0; i < hosts; i++ {
+		if c.crashed(i, hosts, round) {
+			stats.Crashed++
+		}
+	}
+	faulty := 0.0
+	if stats.Faulty() {
+		faulty = 1
+		log.Lvl1("Round", round, "experienced faults:", stats.Crashed,
+			"crashed hosts,", stats.Dropped, "lost and", stats.Delayed,
+			"delayed messages")
+	}
+	monitor.RecordSingleMeasure("faults_round", faulty)
+	monitor.RecordSingleMeasure("faults_crashed", float64(stats.Crashed))
+	monitor.RecordSingleMeasure("faults_dropped", float64(stats.Dropped))
+	monitor.RecordSingleMeasure("faults_delayed", float64(stats.Delayed))
+	return stats
+}
+
+// SendTo sends the message of the protocol instance to the tree node with
+// netem.SendTo, unless one of both hosts crashed or the message is lost, and
+// after its delay.
+func SendTo(tni *onet.TreeNodeInstance, to *onet.TreeNode, msg interface{}) error {
+	injector.Lock()
+	c := injector.config
+	if c == (Config{}) {
+		injector.Unlock()
+		return netem.SendTo(tni, to, msg)
+	}
+	hosts := len(tni.Roster().List)
+	if c.crashed(tni.TreeNode().RosterIndex, hosts, injector.round) ||
+		c.crashed(to.RosterIndex, hosts, injector.round) {
+		injector.Unlock()
+		return nil
+	}
+	if c.DropShare > 0 && (c.DropType == "" || c.DropType == typeName(msg)) &&
+		injector.rand.Float64() < c.DropShare {
+		injector.stats.Dropped++
+		injector.Unlock()
+		return nil
+	}
+	delay := c.delay(injector.rand)
+	if delay > 0 {
+		injector.stats.Delayed++
+	}
+	injector.Unlock()
+	if delay == 0 {
+		return netem.SendTo(tni, to, msg)
+	}
+	time.AfterFunc(delay, func() {
+		if err := netem.SendTo(tni, to, msg); err != nil {
+			log.Error("Couldn't send delayed message:", err)
+		}
+	})
+	return nil
+}
+
+// typeName returns the name of the type of the message, without the
+// package nor the pointer.
+func typeName(msg interface{}) string {
+	t := reflect.TypeOf(msg)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return ""
+	}
+	return t.Name()
+}
@@ -0,0 +1,75 @@
+package faults
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+type Prepare struct{}
+
+func TestConfigCheck(t *testing.T) {
+	require.Nil(t, Config{}.check())
+	require.Nil(t, Config{CrashShare: 0.3, DropShare: 1, Delay: "normal"}.check())
+	require.NotNil(t, Config{CrashShare: 1}.check())
+	require.NotNil(t, Config{DropShare: -0.1}.check())
+	require.NotNil(t, Config{Delay: "pareto"}.check())
+	require.NotNil(t, Use(Config{Delay: "pareto"}))
+}
+
+func TestConfigCrashed(t *testing.T) {
+	c := Config{CrashShare: 0.25, CrashRound: 2}
+	for i := 0; i < 10; i++ {
+		require.False(t, c.crashed(i, 10, 1))
+	}
+	// a quarter of 10 hosts are the last 3 ones
+	for i := 0; i < 10; i++ {
+		require.Equal(t, i >= 7, c.crashed(i, 10, 2))
+	}
+	// the root never crashes
+	c.CrashShare = 0.9
+	require.False(t, c.crashed(0, 2, 2))
+	require.True(t, c.crashed(1, 2, 2))
+}
+
+func TestConfigDelay(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	require.Equal(t, time.Duration(0), Config{}.delay(rng))
+	require.Equal(t, 20*time.Millisecond,
+		Config{Delay: "constant", DelayMs: 20}.delay(rng))
+	c := Config{Delay: "normal", DelayMs: 20, DelayJitterMs: 5}
+	var total time.Duration
+	for i := 0; i < 1000; i++ {
+		d := c.delay(rng)
+		require.True(t, d >= 0)
+		total += d
+	}
+	require.InDelta(t, 20, total.Seconds(), 1)
+}
+
+func TestTypeName(t *testing.T) {
+	require.Equal(t, "Prepare", typeName(&Prepare{}))
+	require.Equal(t, "Prepare", typeName(Prepare{}))
+	require.Equal(t, "", typeName(nil))
+}
+
+func TestRounds(t *testing.T) {
+	require.Nil(t, Use(Config{CrashShare: 0.5, CrashRound: 1}))
+	defer Use(Config{})
+	StartRound(0)
+	require.False(t, EndRound(4).Faulty())
+	StartRound(1)
+	injector.stats.Dropped++
+	// starting the same round again goes on counting
+	StartRound(1)
+	stats := EndRound(4)
+	require.Equal(t, Stats{Crashed: 2, Dropped: 1}, stats)
+	require.True(t, stats.Faulty())
+}
@@ -5,8 +5,13 @@ go 1.18
 require (
 	github.com/BurntSushi/toml v1.4.0
 	github.com/btcsuite/btcd v0.24.2
+	github.com/btcsuite/btcd/btcec/v2 v2.1.3
+	github.com/btcsuite/btcd/btcutil v1.1.5
 	github.com/dedis/cothority v0.0.0-20170425083425-dcd3940bdb13
+	github.com/golang/snappy v0.0.4
 	github.com/stretchr/testify v1.10.0
+	go.etcd.io/bbolt v1.3.8
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	gopkg.in/dedis/cothority.v1 v1.0.0-20180112132810-9daa49171eb7
 	gopkg.in/dedis/crypto.v0 v0.0.0-20170824083343-8f53a63e87fd
 	gopkg.in/dedis/onet.v1 v1.0.0-20180206090940-2ca76e69d0fc
@@ -14,8 +19,6 @@ require (
 
 require (
 	github.com/bford/golang-x-crypto v0.0.0-20160518072526-27db609c9d03 // indirect
-	github.com/btcsuite/btcd/btcec/v2 v2.1.3 // indirect
-	github.com/btcsuite/btcd/btcutil v1.1.5 // indirect
 	github.com/btcsuite/btcd/chaincfg/chainhash v1.1.0 // indirect
 	github.com/btcsuite/btclog v0.0.0-20170628155309-84c8d2346e9f // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
@@ -30,10 +33,12 @@ require (
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/shirou/gopsutil v3.21.11+incompatible // indirect
 	github.com/yusufpapurcu/wmi v1.2.4 // indirect
-	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9 // indirect
-	golang.org/x/sys v0.0.0-20200814200057-3d37ad5750ed // indirect
+	golang.org/x/sys v0.10.0 // indirect
 	gopkg.in/satori/go.uuid.v1 v1.2.0 // indirect
 	gopkg.in/tylerb/graceful.v1 v1.2.15 // indirect
 	gopkg.in/urfave/cli.v1 v1.20.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+// crypto.v0 is forked in third_party/crypto.v0, see its README
+replace gopkg.in/dedis/crypto.v0 => ./third_party/crypto.v0
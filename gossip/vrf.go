@@ -30,10 +30,16 @@ func Verify(suite abstract.Suite, public abstract.Point, msg, sum []byte, proof
 	return true
 }
 
-// H1
+// H1, with the hash of the curve if it has one
 func hashToPoint(suite abstract.Suite, msg []byte) abstract.Point {
+	p := suite.Point()
+	if h, ok := p.(interface {
+		HashToPoint([]byte) abstract.Point
+	}); ok {
+		return h.HashToPoint(msg)
+	}
 	cipher := suite.Cipher(msg)
-	p, _ := suite.Point().Pick(nil, cipher)
+	p, _ = p.Pick(nil, cipher)
 	return p
 }
 
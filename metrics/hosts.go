@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// hosts are the registries of the hosts of this process and the endpoints
+// serving them, by their address.
+var hosts = struct {
+	sync.Mutex
+	registries map[network.ServerIdentityID]*Registry
+	servers    map[string]*http.Server
+}{
+	registries: make(map[network.ServerIdentityID]*Registry),
+	servers:    make(map[string]*http.Server),
+}
+
+// Host returns the registry of the host.
+func Host(si *network.ServerIdentity) *Registry {
+	hosts.Lock()
+	defer hosts.Unlock()
+	return host(si)
+}
+
+// host returns the registry of the host, created if it is new. The caller
+// must hold the lock.
+func host(si *network.ServerIdentity) *Registry {
+	r := hosts.registries[si.ID]
+	if r == nil {
+		r = NewRegistry()
+		hosts.registries[si.ID] = r
+	}
+	return r
+}
+
+// Serve serves the metrics of the host on http://addr/metrics, with the
+// signature operations of the whole process. Serving an address twice
+// keeps the first endpoint.
+func Serve(si *network.ServerIdentity, addr string) error {
+	hosts.Lock()
+	defer hosts.Unlock()
+	if hosts.servers[addr] != nil {
+		return nil
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	r := host(si)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if _, err := processMetrics().WriteTo(w); err != nil {
+			return
+		}
+		r.WriteTo(w)
+	})
+	server := &http.Server{Handler: mux}
+	hosts.servers[addr] = server
+	go func() {
+		if err := server.Serve(l); err != http.ErrServerClosed {
+			log.Error("Couldn't serve the metrics on", addr, err)
+		}
+	}()
+	log.Lvl2("Serving the metrics of", si.Address, "on", addr)
+	return nil
+}
+
+// ServeHost serves the metrics of the host of the simulation on the port
+// plus its index in the roster, at the host of its address.
+func ServeHost(sc *onet.SimulationConfig, port int) error {
+	si := sc.Server.ServerIdentity
+	index, _ := sc.Roster.Search(si.ID)
+	if index < 0 {
+		index = 0
+	}
+	return Serve(si, net.JoinHostPort(si.Address.Host(),
+		strconv.Itoa(port+index)))
+}
+
+// Close stops the endpoints and forgets the metrics of the hosts.
+func Close() {
+	hosts.Lock()
+	defer hosts.Unlock()
+	for addr, server := range hosts.servers {
+		server.Close()
+		delete(hosts.servers, addr)
+	}
+	hosts.registries = make(map[network.ServerIdentityID]*Registry)
+}
+
+// enabled returns whether the metrics of any host are served, else the
+// messages are not counted.
+func enabled() bool {
+	hosts.Lock()
+	defer hosts.Unlock()
+	return len(hosts.servers) > 0
+}
+
+// processMetrics returns the metrics shared by the hosts of this process.
+func processMetrics() *Registry {
+	r := NewRegistry()
+	ops := crypto.CountedOps()
+	help := "Schnorr and collective signatures made and verified by the process."
+	r.Add("signature_ops_total", help, float64(ops.Signed), "op", "sign")
+	r.Add("signature_ops_total", help, float64(ops.Verified), "op", "verify")
+	return r
+}
+
+// SendTo sends the message of the protocol instance to the tree node. If
+// the metrics are served, it counts the message and its bytes by type as
+// sent by our host and received by the host of the tree node, and if
+// AccountPhases is on, it accounts its bytes to its phase.
+func SendTo(tni *onet.TreeNodeInstance, to *onet.TreeNode, msg interface{}) error {
+	if err := tni.SendTo(to, msg); err != nil {
+		return err
+	}
+	phase := phaseOf(msg)
+	served := enabled()
+	if phase == "" && !served {
+		return nil
+	}
+	size := 0
+	if buf, err := network.Marshal(msg); err == nil {
+		size = len(buf)
+	}
+	if phase != "" {
+		account(phase, tni.ServerIdentity().ID, to.ServerIdentity.ID, size)
+	}
+	if !served {
+		return nil
+	}
+	kind := typeName(msg)
+	sent := Host(tni.ServerIdentity())
+	sent.Add("messages_sent_total", "Messages sent by the protocols.", 1,
+		"type", kind)
+	sent.Add("bytes_sent_total", "Bytes of the messages sent by the protocols.",
+		float64(size), "type", kind)
+	received := Host(to.ServerIdentity)
+	received.Add("messages_received_total", "Messages received by the protocols.",
+		1, "type", kind)
+	received.Add("bytes_received_total",
+		"Bytes of the messages received by the protocols.", float64(size),
+		"type", kind)
+	return nil
+}
+
+// typeName returns the name of the type of the message, without the
+// package nor the pointer.
+func typeName(msg interface{}) string {
+	t := reflect.TypeOf(msg)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return ""
+	}
+	return t.Name()
+}
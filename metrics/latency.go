@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/dedis/onet.v1/simul/monitor"
+)
+
+// Percentiles are the percentiles of the latencies recorded in the monitor.
+var Percentiles = []float64{50, 90, 99}
+
+// Latencies is the distribution of latencies, e.g. of the confirmation of
+// every transaction, to report the tail an average hides. The zero value
+// holds no latencies.
+type Latencies struct {
+	sync.Mutex
+	samples []time.Duration
+	sorted  bool
+}
+
+// Add adds the latencies.
+func (l *Latencies) Add(latencies ...time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	l.samples = append(l.samples, latencies...)
+	l.sorted = false
+}
+
+// Len returns the number of latencies.
+func (l *Latencies) Len() int {
+	l.Lock()
+	defer l.Unlock()
+	return len(l.samples)
+}
+
+// Percentile returns the latency p percent of the latencies are at most,
+// by the nearest rank, 0 if there are none.
+func (l *Latencies) Percentile(p float64) time.Duration {
+	l.Lock()
+	defer l.Unlock()
+	if len(l.samples) == 0 {
+		return 0
+	}
+	l.sort()
+	i := int(math.Ceil(p/100*float64(len(l.samples)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(l.samples) {
+		i = len(l.samples) - 1
+	}
+	return l.samples[i]
+}
+
+// Buckets returns the number of latencies at most every bound of Buckets,
+// in seconds.
+func (l *Latencies) Buckets() []uint64 {
+	l.Lock()
+	defer l.Unlock()
+	l.sort()
+	counts := make([]uint64, len(Buckets))
+	for i, bound := range Buckets {
+		counts[i] = uint64(sort.Search(len(l.samples), func(j int) bool {
+			return l.samples[j].Seconds() > bound
+		}))
+	}
+	return counts
+}
+
+// sort sorts the latencies. The caller must hold the lock.
+func (l *Latencies) sort() {
+	if l.sorted {
+		return
+	}
+	sort.Slice(l.samples, func(i, j int) bool {
+		return l.samples[i] < l.samples[j]
+	})
+	l.sorted = true
+}
+
+// Record records the distribution in the monitor: "<name>_p50", "_p90" and
+// "_p99" in seconds, "<name>_count" and "<name>_le_<bound>" for the number
+// of latencies at most every bound of Buckets. It records nothing if there
+// are no latencies.
+func (l *Latencies) Record(name string) {
+	if l.Len() == 0 {
+		return
+	}
+	for _, p := range Percentiles {
+		monitor.RecordSingleMeasure(fmt.Sprintf("%s_p%g", name, p),
+			l.Percentile(p).Seconds())
+	}
+	monitor.RecordSingleMeasure(name+"_count", float64(l.Len()))
+	for i, count := range l.Buckets() {
+		monitor.RecordSingleMeasure(name+"_le_"+formatValue(Buckets[i]),
+			float64(count))
+	}
+}
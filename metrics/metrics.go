@@ -0,0 +1,218 @@
+// Package metrics exports counters, gauges and histograms of every host of a
+// simulation in the text format of Prometheus, on a /metrics endpoint per
+// host, so that long runs can be followed live, e.g. with Grafana. The
+// measures of the onet monitor are still recorded as before.
+//
+// It also accounts the bytes of the messages of the protocols to the phases
+// of their rounds, announce, prepare, commit and proof exchange, and records
+// them in the monitor at the end of every round, see AccountPhases, and
+// records the percentiles and buckets of distributions of latencies, see
+// Latencies.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Buckets are the upper bounds of the buckets of the histograms, in seconds
+// for the latencies.
+var Buckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5,
+	5, 10, 30, 60}
+
+// The kinds of the metrics, as given in their TYPE line.
+const (
+	counter   = "counter"
+	gauge     = "gauge"
+	histogram = "histogram"
+)
+
+// Registry holds the metrics of a host. Every metric has a name, a help
+// text and series of values by labels, given as pairs of a key and a value.
+type Registry struct {
+	sync.Mutex
+	families map[string]*family
+}
+
+// family are the series of a metric by their labels.
+type family struct {
+	kind   string
+	help   string
+	series map[string]*series
+}
+
+// series is the value of a counter or a gauge, or the buckets of a
+// histogram.
+type series struct {
+	value   float64
+	buckets []uint64
+	count   uint64
+}
+
+// NewRegistry returns a registry without metrics.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// Add adds v to the counter name.
+func (r *Registry) Add(name, help string, v float64, labels ...string) {
+	r.Lock()
+	defer r.Unlock()
+	r.series(name, help, counter, labels).value += v
+}
+
+// Set sets the gauge name to v.
+func (r *Registry) Set(name, help string, v float64, labels ...string) {
+	r.Lock()
+	defer r.Unlock()
+	r.series(name, help, gauge, labels).value = v
+}
+
+// Observe adds v to the histogram name.
+func (r *Registry) Observe(name, help string, v float64, labels ...string) {
+	r.Lock()
+	defer r.Unlock()
+	s := r.series(name, help, histogram, labels)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(Buckets))
+	}
+	for i, bound := range Buckets {
+		if v <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.value += v
+	s.count++
+}
+
+// series returns the series of the labels of the metric, created if it is
+// new. The caller must hold the lock.
+func (r *Registry) series(name, help, kind string, labels []string) *series {
+	f := r.families[name]
+	if f == nil {
+		f = &family{kind: kind, help: help, series: make(map[string]*series)}
+		r.families[name] = f
+	}
+	key := formatLabels(labels)
+	s := f.series[key]
+	if s == nil {
+		s = &series{}
+		f.series[key] = s
+	}
+	return s
+}
+
+// WriteTo writes the metrics in the text format of Prometheus, sorted by
+// name and labels.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.Lock()
+	defer r.Unlock()
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := r.families[name]
+		fmt.Fprintf(cw, "# HELP %s %s\n", name, escapeHelp(f.help))
+		fmt.Fprintf(cw, "# TYPE %s %s\n", name, f.kind)
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := f.series[key]
+			if f.kind != histogram {
+				fmt.Fprintf(cw, "%s%s %s\n", name, braces(key), formatValue(s.value))
+				continue
+			}
+			for i, bound := range Buckets {
+				fmt.Fprintf(cw, "%s_bucket%s %d\n", name,
+					braces(withLabel(key, "le", formatValue(bound))), s.buckets[i])
+			}
+			fmt.Fprintf(cw, "%s_bucket%s %d\n", name,
+				braces(withLabel(key, "le", "+Inf")), s.count)
+			fmt.Fprintf(cw, "%s_sum%s %s\n", name, braces(key), formatValue(s.value))
+			fmt.Fprintf(cw, "%s_count%s %d\n", name, braces(key), s.count)
+		}
+	}
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, cw.w.Flush()
+}
+
+// countingWriter counts the bytes written and keeps the first error.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels returns the labels given as pairs of a key and a value as
+// `key="value"`, separated by commas. A key without a value gets an empty
+// one.
+func formatLabels(labels []string) string {
+	pairs := make([]string, 0, (len(labels)+1)/2)
+	for i := 0; i < len(labels); i += 2 {
+		value := ""
+		if i+1 < len(labels) {
+			value = labels[i+1]
+		}
+		pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(value)+`"`)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// withLabel appends the label to the formatted labels.
+func withLabel(formatted, key, value string) string {
+	label := formatLabels([]string{key, value})
+	if formatted == "" {
+		return label
+	}
+	return formatted + "," + label
+}
+
+// braces returns the formatted labels in braces, nothing if there are none.
+func braces(formatted string) string {
+	if formatted == "" {
+		return ""
+	}
+	return "{" + formatted + "}"
+}
+
+// escapeHelp escapes the backslashes and the new lines of a help text.
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+// formatValue returns v as Prometheus parses it.
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+type Prepare struct{}
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+	r.Add("messages_sent_total", "Messages sent.", 1, "type", "Prepare")
+	r.Add("messages_sent_total", "Messages sent.", 2, "type", "Prepare")
+	r.Add("messages_sent_total", "Messages sent.", 1, "type", `Co"mmit`)
+	r.Set("mempool_depth", "Pending transactions.", 7)
+	r.Set("mempool_depth", "Pending transactions.", 3)
+	r.Observe("block_commit_seconds", "Commit latency.", 0.2)
+	r.Observe("block_commit_seconds", "Commit latency.", 100)
+
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	require.Nil(t, err)
+	require.Equal(t, int64(buf.Len()), n)
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE block_commit_seconds histogram",
+		`block_commit_seconds_bucket{le="0.1"} 0`,
+		`block_commit_seconds_bucket{le="0.25"} 1`,
+		`block_commit_seconds_bucket{le="60"} 1`,
+		`block_commit_seconds_bucket{le="+Inf"} 2`,
+		"block_commit_seconds_sum 100.2",
+		"block_commit_seconds_count 2",
+		"# HELP mempool_depth Pending transactions.",
+		"# TYPE mempool_depth gauge",
+		"mempool_depth 3",
+		"# TYPE messages_sent_total counter",
+		`messages_sent_total{type="Co\"mmit"} 1`,
+		`messages_sent_total{type="Prepare"} 3`,
+	} {
+		require.Contains(t, out, line+"\n")
+	}
+	// sorted by name
+	require.True(t, strings.Index(out, "block_commit") < strings.Index(out, "mempool"))
+	require.True(t, strings.Index(out, "mempool") < strings.Index(out, "messages"))
+}
+
+func TestFormatLabels(t *testing.T) {
+	require.Equal(t, "", formatLabels(nil))
+	require.Equal(t, `op="sign",type=""`, formatLabels([]string{"op", "sign", "type"}))
+	require.Equal(t, `a="x\\y\n"`, formatLabels([]string{"a", "x\\y\n"}))
+	require.Equal(t, `le="+Inf"`, withLabel("", "le", "+Inf"))
+	require.Equal(t, `a="b",le="1"`, withLabel(`a="b"`, "le", "1"))
+}
+
+func TestTypeName(t *testing.T) {
+	require.Equal(t, "Prepare", typeName(&Prepare{}))
+	require.Equal(t, "Prepare", typeName(Prepare{}))
+	require.Equal(t, "", typeName(nil))
+}
+
+func TestServe(t *testing.T) {
+	defer Close()
+	require.False(t, enabled())
+	si := newIdentity(0)
+	require.Nil(t, Serve(si, "127.0.0.1:27934"))
+	// serving the same address again keeps the endpoint
+	require.Nil(t, Serve(si, "127.0.0.1:27934"))
+	require.True(t, enabled())
+	Host(si).Set("mempool_depth", "Pending transactions.", 5)
+
+	resp, err := http.Get("http://127.0.0.1:27934/metrics")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+	require.Contains(t, string(body), "mempool_depth 5\n")
+	require.Contains(t, string(body), `signature_ops_total{op="verify"}`)
+
+	Close()
+	require.False(t, enabled())
+	_, err = http.Get("http://127.0.0.1:27934/metrics")
+	require.NotNil(t, err)
+}
+
+type Commit struct{}
+
+func (Commit) MetricsPhase() string { return PhaseCommit }
+
+func TestAccountPhases(t *testing.T) {
+	root, other, third := newIdentity(0), newIdentity(1), newIdentity(2)
+
+	require.Equal(t, "", phaseOf(&Commit{}))
+	AccountPhases(true)
+	defer AccountPhases(false)
+	require.Equal(t, PhaseCommit, phaseOf(&Commit{}))
+	require.Equal(t, "", phaseOf(&Prepare{}))
+
+	StartRound(0)
+	account(PhaseAnnounce, root.ID, other.ID, 100)
+	account(PhaseAnnounce, root.ID, third.ID, 100)
+	account(PhaseCommit, other.ID, third.ID, 10)
+	bw := EndRound(root)
+	require.Equal(t, uint64(200), bw.Total[PhaseAnnounce])
+	require.Equal(t, uint64(200), bw.Root[PhaseAnnounce])
+	require.Equal(t, uint64(200), bw.Host[PhaseAnnounce])
+	require.Equal(t, uint64(10), bw.Total[PhaseCommit])
+	require.Equal(t, uint64(0), bw.Root[PhaseCommit])
+	require.Equal(t, uint64(10), bw.Host[PhaseCommit])
+
+	// a new round starts from scratch, but the same one goes on
+	StartRound(1)
+	account(PhaseCommit, other.ID, third.ID, 5)
+	StartRound(1)
+	account(PhaseCommit, third.ID, other.ID, 5)
+	bw = EndRound(root)
+	require.Equal(t, uint64(0), bw.Total[PhaseAnnounce])
+	require.Equal(t, uint64(10), bw.Total[PhaseCommit])
+	require.Equal(t, uint64(10), bw.Host[PhaseCommit])
+}
+
+// newIdentity returns the identity of the host i.
+func newIdentity(i int) *network.ServerIdentity {
+	public := network.Suite.Point().Mul(nil, network.Suite.Scalar().SetInt64(int64(i+1)))
+	return network.NewServerIdentity(public, network.NewAddress(network.PlainTCP,
+		fmt.Sprintf("127.0.0.1:%d", 2000+2*i)))
+}
+
+func TestLatencies(t *testing.T) {
+	l := &Latencies{}
+	require.Equal(t, time.Duration(0), l.Percentile(50))
+	for i := 100; i > 0; i-- {
+		l.Add(time.Duration(i) * 10 * time.Millisecond)
+	}
+	require.Equal(t, 100, l.Len())
+	require.Equal(t, 500*time.Millisecond, l.Percentile(50))
+	require.Equal(t, 900*time.Millisecond, l.Percentile(90))
+	require.Equal(t, 990*time.Millisecond, l.Percentile(99))
+	require.Equal(t, 10*time.Millisecond, l.Percentile(0))
+	require.Equal(t, time.Second, l.Percentile(100))
+
+	buckets := l.Buckets()
+	require.Equal(t, len(Buckets), len(buckets))
+	for i, bound := range Buckets {
+		// the latencies are 10ms to 1s in steps of 10ms
+		expected := uint64(math.Min(100, math.Floor(bound*100+1e-9)))
+		require.Equal(t, expected, buckets[i], "bucket %v", bound)
+	}
+	l.Add(time.Minute)
+	require.Equal(t, time.Minute, l.Percentile(100))
+	require.Equal(t, uint64(100), l.Buckets()[len(Buckets)-2])
+}
@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"sync"
+
+	"gopkg.in/dedis/onet.v1/network"
+	"gopkg.in/dedis/onet.v1/simul/monitor"
+)
+
+// The phases of the protocols the bytes of their messages are accounted to,
+// see Phased.
+const (
+	// PhaseAnnounce disseminates the block, or the announcement of a
+	// round, and collects the commitments
+	PhaseAnnounce = "announce"
+	// PhasePrepare agrees on the block
+	PhasePrepare = "prepare"
+	// PhaseCommit commits the block agreed on
+	PhaseCommit = "commit"
+	// PhaseProof exchanges the proofs of the cross-shard transactions
+	PhaseProof = "proof"
+)
+
+// Phased is a message of a phase of a protocol. SendTo accounts its bytes to
+// its phase if AccountPhases is on, the other messages are not accounted.
+type Phased interface {
+	MetricsPhase() string
+}
+
+// Bandwidth are the bytes of the messages of a round by phase.
+type Bandwidth struct {
+	// Total are the bytes sent by all hosts
+	Total map[string]uint64
+	// Root are the bytes sent and received by the root
+	Root map[string]uint64
+	// Host are the most bytes sent and received by one host
+	Host map[string]uint64
+}
+
+// accounting are the bytes of the phases of the current round, sent and
+// received by every host of this process, see AccountPhases.
+var accounting struct {
+	sync.Mutex
+	on    bool
+	round int
+	total map[string]uint64
+	hosts map[network.ServerIdentityID]map[string]uint64
+	// seen are the phases of all rounds, recorded in every round
+	seen map[string]bool
+}
+
+// AccountPhases makes SendTo account the bytes of the phased messages to
+// their phase, or stops it. As it marshals the messages once more, it is
+// off unless a simulation asks for it, on every node before the rounds
+// start.
+func AccountPhases(on bool) {
+	accounting.Lock()
+	defer accounting.Unlock()
+	accounting.on = on
+	accounting.round = 0
+	accounting.seen = make(map[string]bool)
+	resetAccounting()
+}
+
+// resetAccounting forgets the bytes of the round. The caller must hold the
+// lock.
+func resetAccounting() {
+	accounting.total = make(map[string]uint64)
+	accounting.hosts = make(map[network.ServerIdentityID]map[string]uint64)
+}
+
+// StartRound starts accounting the bytes of the round. Calling it again for
+// the same round goes on accounting.
+func StartRound(round int) {
+	accounting.Lock()
+	defer accounting.Unlock()
+	if round != accounting.round {
+		accounting.round = round
+		resetAccounting()
+	}
+}
+
+// EndRound returns the bytes of the phases since the start of the round,
+// and records them in the monitor if AccountPhases is on: "bw_<phase>" for
+// the bytes sent by all hosts, "bw_<phase>_root" for the ones sent and
+// received by the root and "bw_<phase>_host" for the most of any host.
+// Every phase seen in a round is recorded in the following ones, even
+// without traffic.
+func EndRound(root *network.ServerIdentity) Bandwidth {
+	accounting.Lock()
+	defer accounting.Unlock()
+	bw := Bandwidth{
+		Total: make(map[string]uint64),
+		Root:  make(map[string]uint64),
+		Host:  make(map[string]uint64),
+	}
+	for phase, bytes := range accounting.total {
+		bw.Total[phase] = bytes
+	}
+	for id, phases := range accounting.hosts {
+		for phase, bytes := range phases {
+			if id.Equal(root.ID) {
+				bw.Root[phase] = bytes
+			}
+			if bytes > bw.Host[phase] {
+				bw.Host[phase] = bytes
+			}
+		}
+	}
+	if !accounting.on {
+		return bw
+	}
+	for phase := range accounting.seen {
+		monitor.RecordSingleMeasure("bw_"+phase, float64(bw.Total[phase]))
+		monitor.RecordSingleMeasure("bw_"+phase+"_root", float64(bw.Root[phase]))
+		monitor.RecordSingleMeasure("bw_"+phase+"_host", float64(bw.Host[phase]))
+	}
+	return bw
+}
+
+// phaseOf returns the phase the bytes of the message are accounted to, ""
+// if they are not.
+func phaseOf(msg interface{}) string {
+	p, ok := msg.(Phased)
+	if !ok {
+		return ""
+	}
+	accounting.Lock()
+	defer accounting.Unlock()
+	if !accounting.on {
+		return ""
+	}
+	return p.MetricsPhase()
+}
+
+// account adds the bytes of a message of the phase from a host to another.
+func account(phase string, from, to network.ServerIdentityID, size int) {
+	accounting.Lock()
+	defer accounting.Unlock()
+	accounting.seen[phase] = true
+	accounting.total[phase] += uint64(size)
+	for _, id := range []network.ServerIdentityID{from, to} {
+		phases := accounting.hosts[id]
+		if phases == nil {
+			phases = make(map[string]uint64)
+			accounting.hosts[id] = phases
+		}
+		phases[phase] += uint64(size)
+	}
+}
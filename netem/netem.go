@@ -0,0 +1,304 @@
+// Package netem emulates a wide area network between the nodes of a
+// simulation running on one machine, as the kernel shapes the links on
+// DeterLab or mininet: every message is held back for the transmission time
+// at the bandwidth of its link, behind the messages sent on the link before
+// it, and for the one-way latency of the link with some jitter. The links
+// are given by a Topology of regions, e.g. the round-trip times between
+// continents.
+package netem
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/dedis/paper_17_sosp_omniledger/metrics"
+	"github.com/dedis/paper_17_sosp_omniledger/seed"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/app"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// linkQueue is how many messages a link holds back before Send waits.
+const linkQueue = 4096
+
+// Topology places the nodes in regions and gives the links between them.
+// The matrices are indexed by the regions of the sender and of the
+// recipient.
+type Topology struct {
+	// Regions are the names of the regions
+	Regions []string
+	// RTTMs are the round-trip times between the regions, in milliseconds
+	RTTMs [][]float64
+	// JitterMs are the standard deviations of the one-way latencies, in
+	// milliseconds, none if empty
+	JitterMs [][]float64
+	// BandwidthMbps are the bandwidths of the links, unlimited if empty or
+	// 0
+	BandwidthMbps [][]float64
+	// Nodes are the regions of the nodes by their index in the roster,
+	// else the nodes are spread over the regions in turn
+	Nodes []int
+}
+
+// LoadTopology reads the topology of a TOML file, such as continents.toml.
+func LoadTopology(path string) (*Topology, error) {
+	t := &Topology{}
+	if _, err := toml.DecodeFile(path, t); err != nil {
+		return nil, err
+	}
+	if err := t.check(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return t, nil
+}
+
+// check returns an error if the matrices are not of the size of the
+// regions, or the nodes in unknown regions.
+func (t *Topology) check() error {
+	n := len(t.Regions)
+	if n == 0 {
+		return errors.New("no regions")
+	}
+	for name, m := range map[string][][]float64{"RTTMs": t.RTTMs,
+		"JitterMs": t.JitterMs, "BandwidthMbps": t.BandwidthMbps} {
+		if len(m) == 0 && name != "RTTMs" {
+			continue
+		}
+		if len(m) != n {
+			return fmt.Errorf("%s has %d rows for %d regions", name, len(m), n)
+		}
+		for i, row := range m {
+			if len(row) != n {
+				return fmt.Errorf("%s has %d columns in row %d for %d regions",
+					name, len(row), i, n)
+			}
+			for _, v := range row {
+				if v < 0 {
+					return fmt.Errorf("%s has a negative value", name)
+				}
+			}
+		}
+	}
+	for i, r := range t.Nodes {
+		if r < 0 || r >= n {
+			return fmt.Errorf("node %d is in unknown region %d", i, r)
+		}
+	}
+	return nil
+}
+
+// region returns the region of the node.
+func (t *Topology) region(node int) int {
+	if node < len(t.Nodes) {
+		return t.Nodes[node]
+	}
+	return node % len(t.Regions)
+}
+
+// Emulator holds back the messages between the nodes as the links of its
+// topology would.
+type Emulator struct {
+	topology *Topology
+
+	mutex  sync.Mutex
+	rand   *rand.Rand
+	links  map[[2]int]*link
+	closed bool
+}
+
+// link is the link from a node to another.
+type link struct {
+	// busy is until when the link transmits the messages sent before, and
+	// last when the last of them arrives
+	busy  time.Time
+	last  time.Time
+	queue chan delivery
+}
+
+// delivery is a message held back until at.
+type delivery struct {
+	at   time.Time
+	send func() error
+}
+
+// New returns an emulator of the topology, drawing the jitter with the
+// seed.
+func New(t *Topology, seed int64) (*Emulator, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	return &Emulator{
+		topology: t,
+		rand:     rand.New(rand.NewSource(seed)),
+		links:    make(map[[2]int]*link),
+	}, nil
+}
+
+// Arrival returns when a message of size bytes sent now by the node from
+// arrives at the node to, and takes the link for its transmission. The
+// messages of a link arrive in the order they have been sent.
+func (e *Emulator) Arrival(from, to, size int) time.Time {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	at, _ := e.schedule(from, to, size)
+	return at
+}
+
+// schedule returns the arrival of the message and its link. The caller must
+// hold the mutex.
+func (e *Emulator) schedule(from, to, size int) (time.Time, *link) {
+	now := time.Now()
+	key := [2]int{from, to}
+	l := e.links[key]
+	if l == nil {
+		l = &link{}
+		e.links[key] = l
+	}
+	if from == to {
+		return now, l
+	}
+	t := e.topology
+	src, dst := t.region(from), t.region(to)
+	start := now
+	if l.busy.After(start) {
+		start = l.busy
+	}
+	l.busy = start
+	if len(t.BandwidthMbps) > 0 && t.BandwidthMbps[src][dst] > 0 {
+		l.busy = start.Add(time.Duration(float64(size*8) /
+			(t.BandwidthMbps[src][dst] * 1e6) * float64(time.Second)))
+	}
+	latency := t.RTTMs[src][dst] / 2
+	if len(t.JitterMs) > 0 {
+		latency += e.rand.NormFloat64() * t.JitterMs[src][dst]
+	}
+	if latency < 0 {
+		latency = 0
+	}
+	at := l.busy.Add(time.Duration(latency * float64(time.Millisecond)))
+	if at.Before(l.last) {
+		at = l.last
+	}
+	l.last = at
+	return at, l
+}
+
+// Send calls send once a message of size bytes from the node from would
+// arrive at the node to, in the background. The messages of a link are
+// sent in order. Once the emulator is closed, send is called right away.
+func (e *Emulator) Send(from, to, size int, send func() error) {
+	e.mutex.Lock()
+	if e.closed {
+		e.mutex.Unlock()
+		if err := send(); err != nil {
+			log.Error("Couldn't send emulated message:", err)
+		}
+		return
+	}
+	defer e.mutex.Unlock()
+	at, l := e.schedule(from, to, size)
+	if l.queue == nil {
+		l.queue = make(chan delivery, linkQueue)
+		go deliver(l.queue)
+	}
+	l.queue <- delivery{at: at, send: send}
+}
+
+// Close stops the links once they sent the messages held back.
+func (e *Emulator) Close() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.closed = true
+	for _, l := range e.links {
+		if l.queue != nil {
+			close(l.queue)
+			l.queue = nil
+		}
+	}
+}
+
+// deliver sends the messages of a link once they arrive.
+func deliver(queue <-chan delivery) {
+	for d := range queue {
+		time.Sleep(time.Until(d.at))
+		if err := d.send(); err != nil {
+			log.Error("Couldn't send emulated message:", err)
+		}
+	}
+}
+
+// emulator is the emulator of the nodes of this process, see Use, and path
+// the file of its topology, see UseTopology.
+var emulator struct {
+	sync.Mutex
+	*Emulator
+	path string
+}
+
+// Use makes SendTo hold back the messages of the protocols of this process
+// with the emulator, or send them right away if it is nil.
+func Use(e *Emulator) {
+	emulator.Lock()
+	defer emulator.Unlock()
+	if emulator.Emulator != nil {
+		emulator.Close()
+	}
+	emulator.Emulator, emulator.path = e, ""
+}
+
+// Deploy copies the topology file to the directory the simulation runs in,
+// see onet.Simulation.Setup, where the nodes find it under its base name.
+func Deploy(dir, path string) error {
+	return app.Copy(dir, path)
+}
+
+// UseTopology makes SendTo emulate the topology of the file, with the jitter
+// drawn from the "netem" stream of package seed. The
+// simulations call it on every node, but as the nodes of a machine share
+// the process, the file is only loaded by the first one.
+func UseTopology(path string) error {
+	emulator.Lock()
+	defer emulator.Unlock()
+	if emulator.Emulator != nil && emulator.path == path {
+		return nil
+	}
+	t, err := LoadTopology(path)
+	if err != nil {
+		return err
+	}
+	e, err := New(t, seed.Int64("netem"))
+	if err != nil {
+		return err
+	}
+	if emulator.Emulator != nil {
+		emulator.Close()
+	}
+	emulator.Emulator, emulator.path = e, path
+	log.Lvl2("Emulating the links between", t.Regions)
+	return nil
+}
+
+// SendTo sends the message of the protocol instance to the tree node, held
+// back as on the link between both nodes if an emulator is in Use. The
+// message is counted in the metrics once it is sent, see metrics.SendTo.
+func SendTo(tni *onet.TreeNodeInstance, to *onet.TreeNode, msg interface{}) error {
+	emulator.Lock()
+	e := emulator.Emulator
+	emulator.Unlock()
+	if e == nil {
+		return metrics.SendTo(tni, to, msg)
+	}
+	buf, err := network.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	e.Send(tni.TreeNode().RosterIndex, to.RosterIndex, len(buf), func() error {
+		return metrics.SendTo(tni, to, msg)
+	})
+	return nil
+}
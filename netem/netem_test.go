@@ -0,0 +1,76 @@
+package netem
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+func TestLoadTopology(t *testing.T) {
+	topology, err := LoadTopology("continents.toml")
+	require.Nil(t, err)
+	require.Equal(t, 3, len(topology.Regions))
+	require.Equal(t, 1, topology.region(4))
+
+	dir, err := ioutil.TempDir("", "netem")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bad.toml")
+	require.Nil(t, ioutil.WriteFile(path, []byte(`Regions = ["a", "b"]
+RTTMs = [[0.0, 1.0]]`), 0644))
+	_, err = LoadTopology(path)
+	require.NotNil(t, err)
+}
+
+func TestEmulatorArrival(t *testing.T) {
+	e, err := New(&Topology{
+		Regions:       []string{"a", "b"},
+		RTTMs:         [][]float64{{0, 100}, {100, 0}},
+		BandwidthMbps: [][]float64{{0, 8}, {8, 0}},
+	}, 1)
+	require.Nil(t, err)
+	start := time.Now()
+	// 10kB take 10ms at 8Mbps, then 50ms of latency
+	first := e.Arrival(0, 1, 10000).Sub(start)
+	require.InDelta(t, 60, first.Seconds()*1000, 5)
+	// the second message waits for the first one to be sent
+	second := e.Arrival(0, 1, 10000).Sub(start)
+	require.InDelta(t, 70, second.Seconds()*1000, 5)
+	// the other direction and the nodes of the same region are free
+	back := e.Arrival(1, 0, 10000).Sub(start)
+	require.InDelta(t, 60, back.Seconds()*1000, 5)
+	require.InDelta(t, 0, e.Arrival(0, 2, 10000).Sub(start).Seconds()*1000, 5)
+}
+
+func TestEmulatorSend(t *testing.T) {
+	e, err := New(&Topology{
+		Regions:  []string{"a", "b"},
+		RTTMs:    [][]float64{{0, 40}, {40, 0}},
+		JitterMs: [][]float64{{0, 10}, {10, 0}},
+	}, 1)
+	require.Nil(t, err)
+	defer e.Close()
+	start := time.Now()
+	received := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		i := i
+		e.Send(0, 1, 100, func() error {
+			received <- i
+			return nil
+		})
+	}
+	// the jitter doesn't reorder the messages of a link
+	for i := 0; i < 10; i++ {
+		require.Equal(t, i, <-received)
+	}
+	require.True(t, time.Since(start) >= 10*time.Millisecond)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol"
+)
+
+// faultyHosts is the number of byzantine nodes showing faultType, one of
+// byzcoin.FaultWithhold, which puts an exception instead of its signatures,
+// and byzcoin.FaultEquivocate, which signs another block. They are the last
+// ones of the roster, so the root always stays honest. Both are set by the
+// simulation on every node.
+var faultyHosts = 0
+var faultType = ""
+
+// useFaulty checks and sets the byzantine nodes of the simulation.
+func useFaulty(hosts int, kind string) error {
+	switch kind {
+	case "", byzcoin.FaultWithhold, byzcoin.FaultEquivocate:
+	default:
+		return errors.New("unknown fault type " + kind)
+	}
+	if hosts < 0 || hosts > 0 && kind == "" {
+		return errors.New("faulty hosts need a fault type")
+	}
+	faultyHosts, faultType = hosts, kind
+	return nil
+}
+
+// fault returns the byzantine behaviour of this node, or "" if it is honest.
+func (nt *Ntree) fault() string {
+	if nt.IsRoot() || nt.TreeNode().RosterIndex < len(nt.Roster().List)-faultyHosts {
+		return ""
+	}
+	return faultType
+}
+
+// signed returns what this node signs instead of the message read by r: the
+// message of another block if it equivocates.
+func (nt *Ntree) signed(r io.Reader) io.Reader {
+	if nt.fault() == byzcoin.FaultEquivocate {
+		return io.MultiReader(strings.NewReader("equivocate"), r)
+	}
+	return r
+}
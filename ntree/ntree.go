@@ -2,16 +2,29 @@ package main
 
 import (
 	"encoding/json"
-	"math"
+	"errors"
+	"fmt"
+	"io"
+	"time"
 
 	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol"
 	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
 	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
 	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+	"github.com/dedis/paper_17_sosp_omniledger/faults"
+	"github.com/dedis/paper_17_sosp_omniledger/metrics"
+	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/log"
 )
 
+// catchUpTimeout is how long a node waits for the block signatures of its
+// children before it sends the block again to the ones that didn't answer, as
+// they might have missed its announcement. It does so catchUpRetries times at
+// most.
+var catchUpTimeout = 2 * time.Second
+var catchUpRetries = 3
+
 // Ntree is a basic implementation of a byzcoin consensus protocol using a tree
 // and each verifiers will have independent signatures. The messages are then
 // bigger and the verification time is also longer.
@@ -19,6 +32,16 @@ type Ntree struct {
 	*onet.TreeNodeInstance
 	// the block to sign
 	block *blockchain.TrBlock
+	// packed is the block as announced by the root, forwarded as is
+	packed *blockchain.PackedBlock
+	// compression is how the root compresses the block it announces
+	compression blockchain.Compression
+	// pull makes the root announce the header of the block only, see SetPull
+	pull bool
+	// announced is the header announcement of the block while we gather its
+	// transactions in pulled
+	announced *HeaderAnnounce
+	pulled    map[string]blkparser.Tx
 	// channel to notify the end of the verification of a block
 	verifyBlockChan chan bool
 
@@ -53,6 +76,49 @@ type Ntree struct {
 		RoundSignatureResponse
 	}
 
+	blockRequestChan chan struct {
+		*onet.TreeNode
+		BlockRequest
+	}
+
+	blockReplyChan chan struct {
+		*onet.TreeNode
+		BlockReply
+	}
+
+	headerAnnounceChan chan struct {
+		*onet.TreeNode
+		HeaderAnnounce
+	}
+
+	txRequestChan chan struct {
+		*onet.TreeNode
+		TxRequest
+	}
+
+	txReplyChan chan struct {
+		*onet.TreeNode
+		TxReply
+	}
+
+	// set to true once we asked our parent for the block we missed
+	blockRequested bool
+	// children that asked us for the block before we had it ourselves
+	blockRequesters []*onet.TreeNode
+	// children that asked us for transactions before we had the block
+	txRequests []txRequest
+	// messages received before we knew the block, replayed once it arrives
+	pendingBlockSigs   []*NaiveBlockSignature
+	pendingSigRequests []*RoundSignatureRequest
+	// children whose block signature we received
+	signedChildren map[onet.TreeNodeID]bool
+	// catchUpChan is notified when the catch-up timeout of the children
+	// expires, catchUps counts how many times it did
+	catchUpChan chan bool
+	catchUps    int
+	// closing is closed by Shutdown to stop listen
+	closing chan bool
+
 	onDoneCallback func(*NtreeSignature)
 }
 
@@ -64,6 +130,9 @@ func NewNtreeProtocol(node *onet.TreeNodeInstance) (*Ntree, error) {
 		verifySignatureRequestChan: make(chan bool),
 		tempBlockSig:               new(NaiveBlockSignature),
 		tempSignatureResponse:      &RoundSignatureResponse{new(NaiveBlockSignature)},
+		signedChildren:             make(map[onet.TreeNodeID]bool),
+		catchUpChan:                make(chan bool, 1),
+		closing:                    make(chan bool),
 	}
 
 	if err := node.RegisterChannel(&nt.announceChan); err != nil {
@@ -78,6 +147,21 @@ func NewNtreeProtocol(node *onet.TreeNodeInstance) (*Ntree, error) {
 	if err := node.RegisterChannel(&nt.roundSignatureResponseChan); err != nil {
 		return nt, err
 	}
+	if err := node.RegisterChannel(&nt.blockRequestChan); err != nil {
+		return nt, err
+	}
+	if err := node.RegisterChannel(&nt.blockReplyChan); err != nil {
+		return nt, err
+	}
+	if err := node.RegisterChannel(&nt.headerAnnounceChan); err != nil {
+		return nt, err
+	}
+	if err := node.RegisterChannel(&nt.txRequestChan); err != nil {
+		return nt, err
+	}
+	if err := node.RegisterChannel(&nt.txReplyChan); err != nil {
+		return nt, err
+	}
 
 	go nt.listen()
 	return nt, nil
@@ -92,15 +176,38 @@ func NewNTreeRootProtocol(node *onet.TreeNodeInstance, transactions []blkparser.
 	return nt, err
 }
 
+// SendTo shadows the one of the TreeNodeInstance to send the message with
+// the faults of the simulation, if any, see faults.
+func (nt *Ntree) SendTo(to *onet.TreeNode, msg interface{}) error {
+	return faults.SendTo(nt.TreeNodeInstance, to, msg)
+}
+
 // Start announces the new block to sign
 func (nt *Ntree) Start() error {
 	log.Lvl3(nt.Name(), "Start()")
+	if nt.pull {
+		go byzcoin.VerifyBlock(nt.block, "", "", nt.verifyBlockChan)
+		announce := newHeaderAnnounce(nt.block)
+		for _, tn := range nt.Children() {
+			if err := nt.SendTo(tn, announce); err != nil {
+				return err
+			}
+		}
+		nt.watchChildren()
+		return nil
+	}
+	packed, err := nt.compression.Pack(nt.block)
+	if err != nil {
+		return err
+	}
+	nt.packed = packed
 	go byzcoin.VerifyBlock(nt.block, "", "", nt.verifyBlockChan)
 	for _, tn := range nt.Children() {
-		if err := nt.SendTo(tn, &BlockAnnounce{nt.block}); err != nil {
+		if err := nt.SendTo(tn, &BlockAnnounce{packed}); err != nil {
 			return err
 		}
 	}
+	nt.watchChildren()
 	return nil
 }
 
@@ -111,14 +218,31 @@ func (nt *Ntree) Dispatch() error {
 	return nil
 }
 
+// Shutdown stops listening to the messages once the instance is done.
+func (nt *Ntree) Shutdown() error {
+	close(nt.closing)
+	return nil
+}
+
 // listen will select on the differents channels
 func (nt *Ntree) listen() {
 	for {
 		select {
 		// Dispatch the block through the whole tree
 		case msg := <-nt.announceChan:
+			if nt.block != nil {
+				// we already fetched the block from our parent
+				log.Lvl3(nt.Name(), "Ignoring late Block announcement")
+				continue
+			}
 			log.Lvl3(nt.Name(), "Received Block announcement")
-			nt.block = msg.BlockAnnounce.Block
+			block, err := msg.BlockAnnounce.Block.Unpack()
+			if err != nil {
+				log.Error(nt.Name(), "couldn't unpack block:", err)
+				continue
+			}
+			nt.block = block
+			nt.packed = msg.BlockAnnounce.Block
 			// verify the block
 			go byzcoin.VerifyBlock(nt.block, "", "", nt.verifyBlockChan)
 			if nt.IsLeaf() {
@@ -133,35 +257,207 @@ func (nt *Ntree) listen() {
 						err)
 				}
 			}
+			nt.watchChildren()
 			// generate your own signature / exception and pass that up to the
 			// root
 		case msg := <-nt.blockSignatureChan:
+			nt.signedChildren[msg.TreeNode.ID] = true
+			if nt.block == nil {
+				nt.pendingBlockSigs = append(nt.pendingBlockSigs, &msg.NaiveBlockSignature)
+				nt.requestBlock()
+				continue
+			}
 			nt.handleBlockSignature(&msg.NaiveBlockSignature)
 			// Dispatch the signature + expcetion made before through the whole
 			// tree
 		case msg := <-nt.roundSignatureRequestChan:
-			log.Lvl3(nt.Name(), " Signature Request Received")
-			go nt.verifySignatureRequest(&msg.RoundSignatureRequest)
-
-			if nt.IsLeaf() {
-				nt.startSignatureResponse()
+			if nt.block == nil {
+				nt.pendingSigRequests = append(nt.pendingSigRequests, &msg.RoundSignatureRequest)
+				nt.requestBlock()
 				continue
 			}
-
-			for _, tn := range nt.Children() {
-				err := nt.SendTo(tn, &msg.RoundSignatureRequest)
-				if err != nil {
-					log.Error(nt.Name(), "couldn't sent to",
-						tn.Name(), err)
-				}
-			}
+			nt.handleRoundSignatureRequest(&msg.RoundSignatureRequest)
 			// Decide if we want to sign this or not
 		case msg := <-nt.roundSignatureResponseChan:
 			nt.handleRoundSignatureResponse(&msg.RoundSignatureResponse)
+		case msg := <-nt.blockRequestChan:
+			nt.handleBlockRequest(msg.TreeNode)
+		case msg := <-nt.blockReplyChan:
+			nt.handleBlockReply(&msg.BlockReply)
+		case msg := <-nt.headerAnnounceChan:
+			nt.handleHeaderAnnounce(&msg.HeaderAnnounce)
+		case msg := <-nt.txRequestChan:
+			nt.handleTxRequest(msg.TreeNode, &msg.TxRequest)
+		case msg := <-nt.txReplyChan:
+			nt.handleTxReply(&msg.TxReply)
+		case <-nt.catchUpChan:
+			nt.catchUpChildren()
+		case <-nt.closing:
+			return
+		}
+	}
+}
+
+// handleRoundSignatureRequest verifies the signature request and passes it
+// down the tree.
+func (nt *Ntree) handleRoundSignatureRequest(msg *RoundSignatureRequest) {
+	log.Lvl3(nt.Name(), " Signature Request Received")
+	go nt.verifySignatureRequest(msg)
+
+	if nt.IsLeaf() {
+		nt.startSignatureResponse()
+		return
+	}
+
+	for _, tn := range nt.Children() {
+		err := nt.SendTo(tn, msg)
+		if err != nil {
+			log.Error(nt.Name(), "couldn't sent to",
+				tn.Name(), err)
 		}
 	}
 }
 
+// requestBlock asks the parent for the block of this round. It is used by
+// nodes that missed the BlockAnnounce (e.g. because they restarted) and learn
+// about the round through the messages of their children or parent. Nodes
+// that receive no message at all get the block from the catch-up of their
+// parent, see catchUpChildren.
+func (nt *Ntree) requestBlock() {
+	if nt.blockRequested || nt.IsRoot() {
+		return
+	}
+	nt.blockRequested = true
+	log.Lvl2(nt.Name(), "Missed the block announcement: requesting it from", nt.Parent().Name())
+	if err := nt.SendTo(nt.Parent(), &BlockRequest{}); err != nil {
+		log.Error(nt.Name(), "couldn't request block from", nt.Parent().Name(), err)
+	}
+}
+
+// handleBlockRequest sends the block back to the child asking for it. If we
+// don't have it either, we ask our own parent and answer once it arrives.
+func (nt *Ntree) handleBlockRequest(tn *onet.TreeNode) {
+	if nt.block == nil {
+		nt.blockRequesters = append(nt.blockRequesters, tn)
+		nt.requestBlock()
+		return
+	}
+	packed, err := nt.packedBlock()
+	if err != nil {
+		log.Error(nt.Name(), "couldn't pack block:", err)
+		return
+	}
+	log.Lvl3(nt.Name(), "Sending block to late joiner", tn.Name())
+	if err := nt.SendTo(tn, &BlockReply{packed}); err != nil {
+		log.Error(nt.Name(), "couldn't send block to", tn.Name(), err)
+	}
+}
+
+// packedBlock returns the block as it is sent to the late joiners, packing it
+// if it was announced in pull mode.
+func (nt *Ntree) packedBlock() (*blockchain.PackedBlock, error) {
+	if nt.packed == nil {
+		packed, err := blockchain.Compression{}.Pack(nt.block)
+		if err != nil {
+			return nil, err
+		}
+		nt.packed = packed
+	}
+	return nt.packed, nil
+}
+
+// watchChildren starts the catch-up timeout of the children once we sent them
+// the block.
+func (nt *Ntree) watchChildren() {
+	time.AfterFunc(catchUpTimeout, func() {
+		select {
+		case nt.catchUpChan <- true:
+		default:
+		}
+	})
+}
+
+// catchUpChildren sends the block to the children whose block signature we
+// are still waiting for: a child that missed the announcement doesn't know
+// about the round, and neither does its subtree.
+func (nt *Ntree) catchUpChildren() {
+	if nt.tempBlockSigReceived >= len(nt.Children()) {
+		return
+	}
+	packed, err := nt.packedBlock()
+	if err != nil {
+		log.Error(nt.Name(), "couldn't pack block:", err)
+		return
+	}
+	for _, tn := range nt.Children() {
+		if nt.signedChildren[tn.ID] {
+			continue
+		}
+		log.Lvl2(nt.Name(), "No block signature from", tn.Name(), ": sending the block again")
+		if err := nt.SendTo(tn, &BlockReply{packed}); err != nil {
+			log.Error(nt.Name(), "couldn't send block to", tn.Name(), err)
+		}
+	}
+	nt.catchUps++
+	if nt.catchUps < catchUpRetries {
+		nt.watchChildren()
+	}
+}
+
+// handleBlockReply stores the fetched block, starts its verification and
+// replays all messages that arrived before we knew the block. Then it goes on
+// like with a BlockAnnounce, as our subtree missed it too.
+func (nt *Ntree) handleBlockReply(msg *BlockReply) {
+	if nt.block != nil {
+		return
+	}
+	block, err := msg.Block.Unpack()
+	if err != nil {
+		log.Error(nt.Name(), "couldn't unpack block:", err)
+		return
+	}
+	log.Lvl2(nt.Name(), "Received missed block: rejoining the round")
+	nt.block = block
+	nt.packed = msg.Block
+	nt.announced = nil
+	nt.pulled = nil
+	go byzcoin.VerifyBlock(nt.block, "", "", nt.verifyBlockChan)
+	nt.replayPending()
+	if nt.IsLeaf() {
+		nt.startBlockSignature()
+		return
+	}
+	for _, tn := range nt.Children() {
+		if err := nt.SendTo(tn, &BlockAnnounce{msg.Block}); err != nil {
+			log.Error(nt.Name(), "couldn't send to", tn.Name(), err)
+		}
+	}
+	nt.watchChildren()
+}
+
+// replayPending handles all messages that arrived before we knew the block.
+func (nt *Ntree) replayPending() {
+	for _, tn := range nt.blockRequesters {
+		nt.handleBlockRequest(tn)
+	}
+	nt.blockRequesters = nil
+	for _, r := range nt.txRequests {
+		nt.handleTxRequest(r.tn, r.req)
+	}
+	nt.txRequests = nil
+
+	sigs := nt.pendingBlockSigs
+	nt.pendingBlockSigs = nil
+	for _, sig := range sigs {
+		nt.handleBlockSignature(sig)
+	}
+	reqs := nt.pendingSigRequests
+	nt.pendingSigRequests = nil
+	for _, req := range reqs {
+		nt.handleRoundSignatureRequest(req)
+	}
+}
+
 // startBlockSignature will  send the first signature up the tree.
 func (nt *Ntree) startBlockSignature() {
 	log.Lvl3(nt.Name(), "Starting Block Signature Phase")
@@ -176,19 +472,17 @@ func (nt *Ntree) startBlockSignature() {
 func (nt *Ntree) computeBlockSignature() {
 	// wait the end of verification of the block
 	ok := <-nt.verifyBlockChan
-	//marshal the blck
-	marshalled, err := json.Marshal(nt.block)
-	if err != nil {
-		log.Error(err)
-		return
-	}
 
-	// if stg is wrong, we put exceptions
-	if !ok {
+	// if stg is wrong, or we withhold our signature, we put exceptions
+	if !ok || nt.fault() == byzcoin.FaultWithhold {
 		nt.tempBlockSig.Exceptions = append(nt.tempBlockSig.Exceptions, Exception{nt.TreeNode().ID})
-	} else { // we put signature
-		schnorr, _ := crypto.SignSchnorr(nt.Suite(), nt.Private(), marshalled)
-		nt.tempBlockSig.Sigs = append(nt.tempBlockSig.Sigs, schnorr)
+	} else { // we put signature, hashing the block as it is marshalled
+		schnorr, err := nt.sign(jsonReader(nt.block))
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		nt.tempBlockSig.add(nt.TreeNode().RosterIndex, schnorr)
 	}
 	log.Lvl3(nt.Name(), "Block Signature Computed")
 }
@@ -196,8 +490,7 @@ func (nt *Ntree) computeBlockSignature() {
 // handleBlockSignature will look if the block is valid. If it is, we sign it.
 // if it is not, we don't sign it and we put up an exception.
 func (nt *Ntree) handleBlockSignature(msg *NaiveBlockSignature) {
-	nt.tempBlockSig.Sigs = append(nt.tempBlockSig.Sigs, msg.Sigs...)
-	nt.tempBlockSig.Exceptions = append(nt.tempBlockSig.Exceptions, msg.Exceptions...)
+	nt.tempBlockSig.merge(msg)
 	nt.tempBlockSigReceived++
 	// not enough signatures for the moment
 	log.Lvl3(nt.Name(), "Handle Block Signature(", nt.tempBlockSigReceived, "/", len(nt.Children()), ")")
@@ -207,7 +500,7 @@ func (nt *Ntree) handleBlockSignature(msg *NaiveBlockSignature) {
 	nt.computeBlockSignature()
 	// if we are root => going further in the protocol
 	if nt.IsRoot() {
-		nt.startSignatureRequest(msg)
+		nt.startSignatureRequest(nt.tempBlockSig)
 		return
 	}
 	// send msg up the tree
@@ -234,28 +527,52 @@ func (nt *Ntree) startSignatureRequest(msg *NaiveBlockSignature) {
 // Go routine that will do the verification of the signature request in
 // parrallele
 func (nt *Ntree) verifySignatureRequest(msg *RoundSignatureRequest) {
-	// verification if we have too much exceptions
-	threshold := int(math.Ceil(float64(len(nt.Tree().List())) / 3.0))
-	if len(msg.Exceptions) > threshold {
-		nt.verifySignatureRequestChan <- false
-	}
-
-	// verification of all the signatures
-	var goodSig int
-	marshalled, _ := json.Marshal(nt.block)
-	for _, sig := range msg.Sigs {
-		if err := crypto.VerifySchnorr(nt.Suite(), nt.Public(), marshalled, sig); err == nil {
-			goodSig++
-		}
+	err := verifySignatures(nt.Suite(), nt.Roster().Publics(),
+		msg.NaiveBlockSignature, jsonReader(nt.block))
+	if err != nil {
+		log.Lvl2(nt.Name(), "Refusing the block signature:", err)
 	}
+	nt.verifySignatureRequestChan <- err == nil
+}
 
-	log.Lvl3(nt.Name(), "Verification of signatures =>", goodSig, "/", len(msg.Sigs), ")")
-	// enough good signatures ?
-	if goodSig <= 2*threshold {
-		nt.verifySignatureRequestChan <- false
+// verifySignatures checks that the signatures are valid signatures of the
+// message read by r by enough distinct members of the roster: at most
+// MaxExceptions exceptions, and more good signatures than twice the
+// exceptions we accept. The signatures are verified in batch, then one by
+// one to count the good ones if one is wrong.
+func verifySignatures(suite abstract.Suite, publics []abstract.Point,
+	sig *NaiveBlockSignature, r io.Reader) error {
+	maxExceptions := blockchain.MaxExceptions(len(publics))
+	if len(sig.Exceptions) > maxExceptions {
+		return fmt.Errorf("%d exceptions out of %d allowed",
+			len(sig.Exceptions), maxExceptions)
 	}
-
-	nt.verifySignatureRequestChan <- true
+	if len(sig.Signers) != len(sig.Sigs) {
+		return errors.New("signatures without their signers")
+	}
+	policy := crypto.NewSignaturePolicy(suite, publics, 2*maxExceptions+1)
+	digest, err := crypto.SchnorrDigest(suite, r)
+	if err != nil {
+		return err
+	}
+	pubs := make([]abstract.Point, len(sig.Sigs))
+	msgs := make([][]byte, len(sig.Sigs))
+	for i, index := range sig.Signers {
+		if index < 0 || int(index) >= len(publics) {
+			return fmt.Errorf("unknown signer %d", index)
+		}
+		pubs[i] = publics[index]
+		msgs[i] = digest
+	}
+	batch := crypto.VerifySchnorrBatch(suite, pubs, msgs, sig.Sigs) == nil
+	signers := crypto.NewSignerMask(len(publics))
+	for i, index := range sig.Signers {
+		if batch || crypto.VerifySchnorrBatch(suite, pubs[i:i+1], msgs[i:i+1], sig.Sigs[i:i+1]) == nil {
+			signers.Set(int(index))
+		}
+	}
+	log.Lvl3("Verification of signatures =>", signers.Count(), "/", len(sig.Sigs))
+	return policy.CheckMask(signers)
 }
 
 // Start the last phase : send up the final signature
@@ -272,22 +589,18 @@ func (nt *Ntree) startSignatureResponse() {
 func (nt *Ntree) computeSignatureResponse() {
 	// wait for the verification to be done
 	ok := <-nt.verifySignatureRequestChan
-	if !ok {
+	if !ok || nt.fault() == byzcoin.FaultWithhold {
 		nt.tempSignatureResponse.Exceptions = append(nt.tempSignatureResponse.Exceptions, Exception{nt.TreeNode().ID})
 	} else {
 		// compute the message out of the previous signature
 		// marshal only the header here (so signature between the two phases are
 		// garanteed to be different)
-		marshalled, err := json.Marshal(nt.block.Header)
+		sig, err := nt.sign(jsonReader(nt.block.Header))
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		sig, err := crypto.SignSchnorr(nt.Suite(), nt.Private(), marshalled)
-		if err != nil {
-			return
-		}
-		nt.tempSignatureResponse.Sigs = append(nt.tempSignatureResponse.Sigs, sig)
+		nt.tempSignatureResponse.add(nt.TreeNode().RosterIndex, sig)
 	}
 }
 
@@ -295,8 +608,7 @@ func (nt *Ntree) computeSignatureResponse() {
 // the root
 func (nt *Ntree) handleRoundSignatureResponse(msg *RoundSignatureResponse) {
 	// do we have received it all
-	nt.tempSignatureResponse.Sigs = append(nt.tempSignatureResponse.Sigs, msg.Sigs...)
-	nt.tempSignatureResponse.Exceptions = append(nt.tempSignatureResponse.Exceptions, msg.Exceptions...)
+	nt.tempSignatureResponse.merge(msg.NaiveBlockSignature)
 	nt.tempSignatureResponseReceived++
 	log.Lvl3(nt.Name(), "Handle Round Signature Response(", nt.tempSignatureResponseReceived, "/", len(nt.Children()))
 	if nt.tempSignatureResponseReceived < len(nt.Children()) {
@@ -312,11 +624,17 @@ func (nt *Ntree) handleRoundSignatureResponse(msg *RoundSignatureResponse) {
 		}
 		return
 	}
-	if err := nt.SendTo(nt.Parent(), msg); err != nil {
+	if err := nt.SendTo(nt.Parent(), nt.tempSignatureResponse); err != nil {
 		log.Error(nt.Name(), "couldn't send to", nt.Name(), err)
 	}
 }
 
+// SetCompression makes the root compress the block it announces. It has to
+// be called before Start.
+func (nt *Ntree) SetCompression(c blockchain.Compression) {
+	nt.compression = c
+}
+
 // RegisterOnDone is the callback that will be executed when the final signature
 // is done.
 func (nt *Ntree) RegisterOnDone(fn func(*NtreeSignature)) {
@@ -325,15 +643,59 @@ func (nt *Ntree) RegisterOnDone(fn func(*NtreeSignature)) {
 
 // BlockAnnounce is used to signal the block to the whole tree.
 type BlockAnnounce struct {
-	Block *blockchain.TrBlock
+	Block *blockchain.PackedBlock
+}
+
+// BlockRequest is sent by a node that missed the BlockAnnounce to its parent
+// to fetch the block of the current round.
+type BlockRequest struct{}
+
+// BlockReply is the answer to a BlockRequest and contains the announced block.
+type BlockReply struct {
+	Block *blockchain.PackedBlock
+}
+
+// sign signs the stream as verifySignatures checks it, keeping the
+// commitment for the signatures to be verified in batch.
+func (nt *Ntree) sign(r io.Reader) (crypto.BatchSchnorrSig, error) {
+	digest, err := crypto.SchnorrDigest(nt.Suite(), nt.signed(r))
+	if err != nil {
+		return crypto.BatchSchnorrSig{}, err
+	}
+	return crypto.SignSchnorrBatch(nt.Suite(), nt.Private(), digest, crypto.DeterministicNonce)
+}
+
+// jsonReader returns a reader of the JSON encoding of v, written into a pipe
+// as it is read, for the signatures to hash it without holding a copy.
+func jsonReader(v interface{}) io.Reader {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(json.NewEncoder(w).Encode(v))
+	}()
+	return r
 }
 
 // NaiveBlockSignature contains the signatures of a block that goes up the tree using this message
 type NaiveBlockSignature struct {
-	Sigs       []crypto.SchnorrSig
+	Sigs []crypto.BatchSchnorrSig
+	// Signers are the roster indices of the signers of Sigs, in order
+	Signers    []int32
 	Exceptions []Exception
 }
 
+// add adds the signature of the member of the roster at index.
+func (s *NaiveBlockSignature) add(index int, sig crypto.BatchSchnorrSig) {
+	s.Sigs = append(s.Sigs, sig)
+	s.Signers = append(s.Signers, int32(index))
+}
+
+// merge adds the signatures and the exceptions of other.
+func (s *NaiveBlockSignature) merge(other *NaiveBlockSignature) {
+	s.Sigs = append(s.Sigs, other.Sigs...)
+	s.Signers = append(s.Signers, other.Signers...)
+	s.Exceptions = append(s.Exceptions, other.Exceptions...)
+}
+
 // Exception is  just representing the notion that a peers does not accept to
 // sign something. It justs passes its TreeNodeId inside. No need for public key
 // or whatever because each signatures is independent.
@@ -352,6 +714,28 @@ type RoundSignatureResponse struct {
 	*NaiveBlockSignature
 }
 
+// The messages of the block are accounted to the announce phase of metrics,
+// the signatures going up the tree to the prepare phase and the final
+// signatures to the commit phase.
+
+// MetricsPhase implements metrics.Phased.
+func (BlockAnnounce) MetricsPhase() string { return metrics.PhaseAnnounce }
+
+// MetricsPhase implements metrics.Phased.
+func (BlockRequest) MetricsPhase() string { return metrics.PhaseAnnounce }
+
+// MetricsPhase implements metrics.Phased.
+func (BlockReply) MetricsPhase() string { return metrics.PhaseAnnounce }
+
+// MetricsPhase implements metrics.Phased.
+func (NaiveBlockSignature) MetricsPhase() string { return metrics.PhasePrepare }
+
+// MetricsPhase implements metrics.Phased.
+func (RoundSignatureRequest) MetricsPhase() string { return metrics.PhaseCommit }
+
+// MetricsPhase implements metrics.Phased.
+func (RoundSignatureResponse) MetricsPhase() string { return metrics.PhaseCommit }
+
 // NtreeSignature is the signature that we give back to the simulation or control
 type NtreeSignature struct {
 	Block *blockchain.TrBlock
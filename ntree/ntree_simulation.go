@@ -1,9 +1,17 @@
 package main
 
 import (
+	"time"
+
 	"github.com/BurntSushi/toml"
 	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol"
 	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/dedis/paper_17_sosp_omniledger/faults"
+	"github.com/dedis/paper_17_sosp_omniledger/metrics"
+	"github.com/dedis/paper_17_sosp_omniledger/profile"
+	"github.com/dedis/paper_17_sosp_omniledger/seed"
+	"github.com/dedis/paper_17_sosp_omniledger/soak"
+	"github.com/dedis/paper_17_sosp_omniledger/suites"
 	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/log"
 	"gopkg.in/dedis/onet.v1/simul/monitor"
@@ -20,6 +28,12 @@ type Simulation struct {
 	onet.SimulationBFTree
 	// your simulation specific fields:
 	byzcoin.SimulationConfig
+	// PullBlocks makes the root announce the header of the blocks only, the
+	// nodes pulling the transactions missing from their mempool
+	PullBlocks bool
+	// PoolShare is the share of the transactions of a block that every node
+	// already holds in its mempool in pull mode, as if gossiped beforehand
+	PoolShare float64
 }
 
 // NewSimulation returns a new Ntree simulation
@@ -39,8 +53,13 @@ func (e *Simulation) Setup(dir string, hosts []string) (*onet.SimulationConfig,
 		log.Fatal("Couldn't get block:", err)
 	}
 
+	if err := suites.Setup(dir, e.Suite); err != nil {
+		return nil, err
+	}
 	sc := &onet.SimulationConfig{}
 	e.CreateRoster(sc, hosts, 2000)
+	seed.Use(e.GlobalSeed)
+	seed.Keys(sc)
 	err = e.CreateTree(sc)
 	if err != nil {
 		return nil, err
@@ -48,22 +67,59 @@ func (e *Simulation) Setup(dir string, hosts []string) (*onet.SimulationConfig,
 	return sc, nil
 }
 
+// Node implements onet.Simulation interface. It seeds the random streams and
+// makes the nodes run the checks of the config on the blocks, inject the
+// faults, show the byzantine behaviour of the faulty nodes, serve their
+// metrics and account the bytes of the phases.
+func (e *Simulation) Node(sc *onet.SimulationConfig) error {
+	seed.Use(e.GlobalSeed)
+	if err := byzcoin.UseChecks(e.Checks...); err != nil {
+		return err
+	}
+	if err := faults.Use(e.Config); err != nil {
+		return err
+	}
+	if err := useFaulty(e.FaultyHosts, e.FaultType); err != nil {
+		return err
+	}
+	metrics.AccountPhases(e.PhaseBandwidth)
+	if e.MetricsPort > 0 {
+		if err := metrics.ServeHost(sc, e.MetricsPort); err != nil {
+			return err
+		}
+	}
+	return e.SimulationBFTree.Node(sc)
+}
+
 // Run implements onet.Simulation interface
 func (e *Simulation) Run(sdaConf *onet.SimulationConfig) error {
 	log.Lvl2("Naive Tree Simulation starting with: Rounds=", e.Rounds)
+	profile.Use(e.Profile)
+	defer profile.EndRound()
+	soak.Use(e.Soak)
 	server := NewNtreeServer(e.Blocksize)
+	server.UseMaxBlockBytes(e.MaxBlockBytes)
+	exporter := blockchain.NewChainExporter()
+	// the rounds share a client, so that they don't send the same
+	// transactions again
+	client := byzcoin.NewClient(server)
+	defer client.Close()
+	host := metrics.Host(sdaConf.Server.ServerIdentity)
 	for round := 0; round < e.Rounds; round++ {
-		client := byzcoin.NewClient(server)
 		err := client.StartClientSimulation(blockchain.GetBlockDir(), e.Blocksize)
 		if err != nil {
 			log.Error("ClientSimulation:", err)
 		}
 
 		log.Lvl1("Starting round", round)
+		faults.StartRound(round)
+		metrics.StartRound(round)
+		profile.StartRound(sdaConf.Server.ServerIdentity, round)
 		// create an empty node
 		node := sdaConf.Overlay.NewTreeNodeInstanceFromProtoName(sdaConf.Tree, "ByzCoinNtree")
 		// instantiate a byzcoin protocol
 		rComplete := monitor.NewTimeMeasure("round")
+		start := time.Now()
 		pi, err := server.Instantiate(node)
 		if err != nil {
 			return err
@@ -71,10 +127,30 @@ func (e *Simulation) Run(sdaConf *onet.SimulationConfig) error {
 		sdaConf.Overlay.RegisterProtocolInstance(pi)
 
 		nt := pi.(*Ntree)
+		nt.SetCompression(blockchain.Compression{
+			Codec: e.Compression,
+			Level: e.CompressionLevel,
+		})
+		if e.PullBlocks {
+			nt.SetPull(true)
+			e.fillMempools(sdaConf, nt.block)
+		}
 		// Register when the protocol is finished (all the nodes have finished)
 		done := make(chan bool)
 		nt.RegisterOnDone(func(sig *NtreeSignature) {
 			rComplete.Record()
+			rejected := 0.0
+			if err := verifySignatures(node.Suite(), sdaConf.Roster.Publics(),
+				sig.NaiveBlockSignature, jsonReader(sig.Block.Header)); err != nil {
+				log.Error("Round", round, "failed:", err)
+				rejected = 1
+			} else {
+				host.Observe("block_commit_seconds",
+					"Time from the start of a round to the signature of its block.",
+					time.Since(start).Seconds())
+			}
+			monitor.RecordSingleMeasure("rejected", rejected)
+			exporter.Add(sig.Block, time.Now(), signers(sdaConf, sig.Exceptions))
 			log.Lvl3("Done")
 			done <- true
 		})
@@ -87,7 +163,51 @@ func (e *Simulation) Run(sdaConf *onet.SimulationConfig) error {
 		// wait for the end
 		<-done
 		log.Lvl3("Round", round, "finished")
-
+		faults.EndRound(len(sdaConf.Roster.List))
+		metrics.EndRound(sdaConf.Server.ServerIdentity)
+		profile.EndRound()
+		host.Set("mempool_depth", "Transactions waiting in the mempool of the root.",
+			float64(server.Mempool().Len()))
+		soak.EndRound(round)
+	}
+	if err := soak.Check(); err != nil {
+		return err
+	}
+	if e.ChainExport != "" {
+		return exporter.Export(e.ChainExport)
 	}
 	return nil
 }
+
+// signers returns which members of the roster are not in the exceptions.
+func signers(sdaConf *onet.SimulationConfig, exceptions []Exception) []bool {
+	signers := make([]bool, len(sdaConf.Roster.List))
+	for i := range signers {
+		signers[i] = true
+	}
+	for _, ex := range exceptions {
+		if tn := sdaConf.Tree.Search(ex.ID); tn != nil {
+			signers[tn.RosterIndex] = false
+		}
+	}
+	return signers
+}
+
+// fillMempools gives every node but the root a mempool holding PoolShare of
+// the transactions of the block, drawn from the stream of the block.
+func (e *Simulation) fillMempools(sdaConf *onet.SimulationConfig, b *blockchain.TrBlock) {
+	root := sdaConf.Tree.Root.ServerIdentity.ID
+	rng := seed.Rand("mempools/" + b.HeaderHash)
+	for _, si := range sdaConf.Roster.List {
+		if si.ID == root {
+			continue
+		}
+		pool := blockchain.NewMempool(0, 0)
+		for _, tx := range b.Txs {
+			if rng.Float64() < e.PoolShare {
+				pool.AddTransaction(blockchain.NewBitcoinTx(tx))
+			}
+		}
+		RegisterMempool(si.ID, pool)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol"
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"github.com/dedis/paper_17_sosp_omniledger/faults"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+// TestNtreeMissedAnnounce drops all the announcements of the block, so that
+// every subtree below the root only gets it from the catch-up of its parent,
+// and checks that the signatures of all nodes still arrive.
+func TestNtreeMissedAnnounce(t *testing.T) {
+	defer func(timeout time.Duration) { catchUpTimeout = timeout }(catchUpTimeout)
+	catchUpTimeout = 100 * time.Millisecond
+	require.Nil(t, faults.Use(faults.Config{DropShare: 1, DropType: "BlockAnnounce"}))
+	defer faults.Use(faults.Config{})
+
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	hosts := 15
+	_, _, tree := local.GenBigTree(hosts, hosts, 2, true)
+	pi, err := local.CreateProtocol("ByzCoinNtree", tree)
+	require.Nil(t, err)
+	nt := pi.(*Ntree)
+	nt.block, err = byzcoin.GetBlock([]blkparser.Tx{{Hash: fmt.Sprintf("%064x", 1)}}, "", "")
+	require.Nil(t, err)
+	done := make(chan *NtreeSignature, 1)
+	nt.RegisterOnDone(func(sig *NtreeSignature) { done <- sig })
+	require.Nil(t, nt.Start())
+
+	select {
+	case sig := <-done:
+		require.Equal(t, hosts, len(sig.Sigs))
+		require.Empty(t, sig.Exceptions)
+		require.Nil(t, verifySignatures(nt.Suite(), nt.Roster().Publics(),
+			sig.NaiveBlockSignature, jsonReader(sig.Block.Header)))
+	case <-time.After(5 * time.Second):
+		t.Fatal("the signatures of the subtrees that missed the announcement didn't arrive")
+	}
+}
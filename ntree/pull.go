@@ -0,0 +1,198 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol"
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"github.com/dedis/paper_17_sosp_omniledger/metrics"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+	"gopkg.in/dedis/onet.v1/simul/monitor"
+)
+
+// mempools are the pools of the nodes of this process, by server identity,
+// in which they look for the transactions of the blocks announced in pull
+// mode.
+var mempools = struct {
+	sync.Mutex
+	pools map[network.ServerIdentityID]*blockchain.Mempool
+}{pools: make(map[network.ServerIdentityID]*blockchain.Mempool)}
+
+// RegisterMempool makes the node of the server identity take the
+// transactions of the blocks announced in pull mode from the pool, and only
+// pull the others from its parent. A nil pool unregisters it.
+func RegisterMempool(id network.ServerIdentityID, pool *blockchain.Mempool) {
+	mempools.Lock()
+	defer mempools.Unlock()
+	if pool == nil {
+		delete(mempools.pools, id)
+		return
+	}
+	mempools.pools[id] = pool
+}
+
+// getMempool returns the pool of the node of the server identity, nil if it
+// has none.
+func getMempool(id network.ServerIdentityID) *blockchain.Mempool {
+	mempools.Lock()
+	defer mempools.Unlock()
+	return mempools.pools[id]
+}
+
+// HeaderAnnounce is sent down the tree instead of BlockAnnounce in pull mode:
+// it only holds the header of the block and the hashes of its transactions.
+type HeaderAnnounce struct {
+	Header     *blockchain.Header
+	HeaderHash string
+	TxIDs      []string
+}
+
+// TxRequest is sent by a node to its parent for the transactions of the
+// announced block it doesn't have.
+type TxRequest struct {
+	TxIDs []string
+}
+
+// TxReply is the answer to a TxRequest and contains the transactions asked.
+type TxReply struct {
+	Txs []blkparser.Tx
+}
+
+// MetricsPhase implements metrics.Phased.
+func (HeaderAnnounce) MetricsPhase() string { return metrics.PhaseAnnounce }
+
+// MetricsPhase implements metrics.Phased.
+func (TxRequest) MetricsPhase() string { return metrics.PhaseAnnounce }
+
+// MetricsPhase implements metrics.Phased.
+func (TxReply) MetricsPhase() string { return metrics.PhaseAnnounce }
+
+// txRequest is a TxRequest received before we had the block.
+type txRequest struct {
+	tn  *onet.TreeNode
+	req *TxRequest
+}
+
+// newHeaderAnnounce returns the announcement of the block in pull mode.
+func newHeaderAnnounce(b *blockchain.TrBlock) *HeaderAnnounce {
+	ids := make([]string, len(b.Txs))
+	for i, tx := range b.Txs {
+		ids[i] = tx.Hash
+	}
+	return &HeaderAnnounce{
+		Header:     b.Header,
+		HeaderHash: b.HeaderHash,
+		TxIDs:      ids,
+	}
+}
+
+// SetPull makes the root announce only the header and the transaction hashes
+// of the block, each node taking the transactions from its mempool, see
+// RegisterMempool, and pulling the missing ones from its parent. It has to be
+// called before Start.
+func (nt *Ntree) SetPull(pull bool) {
+	nt.pull = pull
+}
+
+// handleHeaderAnnounce takes the transactions of the announced block from the
+// mempool, and asks the parent for the missing ones.
+func (nt *Ntree) handleHeaderAnnounce(msg *HeaderAnnounce) {
+	if nt.block != nil || nt.announced != nil {
+		log.Lvl3(nt.Name(), "Ignoring late Header announcement")
+		return
+	}
+	log.Lvl3(nt.Name(), "Received Header announcement")
+	nt.announced = msg
+	nt.pulled = make(map[string]blkparser.Tx)
+	pool := getMempool(nt.ServerIdentity().ID)
+	var missing []string
+	for _, id := range msg.TxIDs {
+		if pool != nil {
+			if tx, ok := pool.Get(id); ok {
+				nt.pulled[id] = blockchain.ToTx(tx)
+				continue
+			}
+		}
+		missing = append(missing, id)
+	}
+	monitor.RecordSingleMeasure("pulled_txs", float64(len(missing)))
+	if len(missing) == 0 {
+		nt.rebuildBlock()
+		return
+	}
+	log.Lvl3(nt.Name(), "Pulling", len(missing), "/", len(msg.TxIDs), "transactions")
+	if err := nt.SendTo(nt.Parent(), &TxRequest{missing}); err != nil {
+		log.Error(nt.Name(), "couldn't request transactions from", nt.Parent().Name(), err)
+	}
+}
+
+// handleTxRequest sends the transactions asked back to the child. If we don't
+// have the block yet, we answer once we have it.
+func (nt *Ntree) handleTxRequest(tn *onet.TreeNode, msg *TxRequest) {
+	if nt.block == nil {
+		nt.txRequests = append(nt.txRequests, txRequest{tn, msg})
+		if nt.announced == nil {
+			nt.requestBlock()
+		}
+		return
+	}
+	txs := make(map[string]blkparser.Tx, len(nt.block.Txs))
+	for _, tx := range nt.block.Txs {
+		txs[tx.Hash] = tx
+	}
+	reply := &TxReply{Txs: make([]blkparser.Tx, 0, len(msg.TxIDs))}
+	for _, id := range msg.TxIDs {
+		if tx, ok := txs[id]; ok {
+			reply.Txs = append(reply.Txs, tx)
+		}
+	}
+	if err := nt.SendTo(tn, reply); err != nil {
+		log.Error(nt.Name(), "couldn't send transactions to", tn.Name(), err)
+	}
+}
+
+// handleTxReply adds the pulled transactions and rebuilds the block.
+func (nt *Ntree) handleTxReply(msg *TxReply) {
+	if nt.block != nil || nt.announced == nil {
+		return
+	}
+	for _, tx := range msg.Txs {
+		nt.pulled[tx.Hash] = tx
+	}
+	nt.rebuildBlock()
+}
+
+// rebuildBlock makes the announced block out of the transactions we have,
+// then goes on like with a BlockAnnounce. A block whose transactions don't
+// match its header fails the verification.
+func (nt *Ntree) rebuildBlock() {
+	msg := nt.announced
+	txs := make([]blkparser.Tx, len(msg.TxIDs))
+	for i, id := range msg.TxIDs {
+		tx, ok := nt.pulled[id]
+		if !ok {
+			log.Error(nt.Name(), "parent didn't send transaction", id)
+			return
+		}
+		txs[i] = tx
+	}
+	nt.block = blockchain.NewTrBlock(blockchain.NewTransactionList(txs, len(txs)), msg.Header)
+	nt.announced = nil
+	nt.pulled = nil
+	go byzcoin.VerifyBlock(nt.block, "", "", nt.verifyBlockChan)
+
+	nt.replayPending()
+	if nt.IsLeaf() {
+		nt.startBlockSignature()
+		return
+	}
+	for _, tn := range nt.Children() {
+		if err := nt.SendTo(tn, msg); err != nil {
+			log.Error(nt.Name(), "couldn't send to", tn.Name(), err)
+		}
+	}
+	nt.watchChildren()
+}
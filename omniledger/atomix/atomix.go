@@ -0,0 +1,340 @@
+package atomix
+
+/*
+Atomix is the client-driven protocol of OmniLedger to commit transactions
+spending the outputs of more than one shard atomically. It runs in two
+phases:
+
+ 1. Initialize/Lock: the client sends the transaction to every input shard.
+    Each shard locks the inputs it holds and returns a proof-of-acceptance,
+    or returns a proof-of-rejection if an input is spent or already locked.
+    The shards decide on blocks of transactions, and a proof is the Merkle
+    path of the decision in a block whose header is signed by the shard.
+ 2. Unlock: if all input shards accepted, the client sends the proofs to the
+    input and output shards, which spend the inputs and create the outputs
+    (Unlock-to-Commit). Otherwise it sends the proof-of-rejection to the
+    input shards, which unlock the inputs again (Unlock-to-Abort).
+
+If the client crashes between both phases, the inputs stay locked. After a
+timeout, anybody can complete the transaction with Client.Reclaim: it asks
+the input shards for their decisions again, and the shards that didn't
+accept the transaction yet reject it for good, so that it is either
+committed or aborted everywhere.
+
+The shards don't talk to each other: the proofs are signed by 2f+1 members of
+a shard, so every shard can verify them on its own. The members can also
+gossip the signed headers of their blocks to the other shards with
+GossipRound: the proofs on a header a shard already knows are checked with
+their Merkle path only, see Headers.
+*/
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/metrics"
+	"gopkg.in/dedis/crypto.v0/sign"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// Name is the name the protocol has to be registered with, see NewProtocol.
+const Name = "Atomix"
+
+// Timeout is how long the members wait for a request and the root for the
+// replies of the members.
+var Timeout = 10 * time.Second
+
+// Protocol runs one phase of Atomix on the members of one shard: the root
+// passes the request of the client to all members, which handle it on their
+// state of the shard and reply.
+type Protocol struct {
+	*onet.TreeNodeInstance
+	shard *Shard
+
+	// LockRequest is the request of the first phase, set on the root
+	LockRequest *LockRequest
+	// UnlockRequest is the request of the second phase, set on the root
+	UnlockRequest *UnlockRequest
+
+	lockChan        chan lockChan
+	lockReplyChan   chan lockReplyChan
+	unlockChan      chan unlockChan
+	unlockReplyChan chan unlockReplyChan
+
+	// votes holds the signatures of the members on the header of their block
+	// of decisions, by hash of the header and index of the member
+	votes    map[string]map[int][]byte
+	unlocked map[bool]int
+	errors   int
+	received int
+	finished bool
+
+	// onProofs is called on the root with the proofs of the first phase
+	onProofs func([]Proof, error)
+	// onUnlock is called on the root with the result of the second phase
+	onUnlock func(bool, error)
+	// thresholdSign signs the headers with the distributed key of the
+	// shard, if set
+	thresholdSign func([]byte) ([]byte, error)
+}
+
+// NewProtocol returns a new Atomix instance working on the state of the
+// shard. It has to be registered by the members of the shards, for example:
+//
+//	onet.GlobalProtocolRegister(atomix.Name, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+//		return atomix.NewProtocol(n, shard)
+//	})
+func NewProtocol(n *onet.TreeNodeInstance, shard *Shard) (*Protocol, error) {
+	p := &Protocol{
+		TreeNodeInstance: n,
+		shard:            shard,
+		votes:            make(map[string]map[int][]byte),
+		unlocked:         make(map[bool]int),
+	}
+	err := p.RegisterChannels(&p.lockChan, &p.lockReplyChan, &p.unlockChan,
+		&p.unlockReplyChan)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// RegisterOnProofs sets the function called on the root with the proofs of
+// the shard at the end of the first phase, one for every transaction of the
+// block.
+func (p *Protocol) RegisterOnProofs(fn func([]Proof, error)) {
+	p.onProofs = fn
+}
+
+// RegisterOnUnlock sets the function called on the root with the result of
+// the second phase.
+func (p *Protocol) RegisterOnUnlock(fn func(bool, error)) {
+	p.onUnlock = fn
+}
+
+// RegisterThresholdSign sets the function the root uses to sign the header
+// of the block of decisions with the distributed key of the shard, e.g. by
+// running a shardkey.Sign protocol. The proofs then hold the signature, so
+// that the shards knowing the key can check them without the roster.
+func (p *Protocol) RegisterThresholdSign(fn func(msg []byte) ([]byte, error)) {
+	p.thresholdSign = fn
+}
+
+// SendToParent shadows the one of the TreeNodeInstance to account the bytes
+// of the reply to the proof phase, see metrics.SendTo.
+func (p *Protocol) SendToParent(msg interface{}) error {
+	if p.IsRoot() {
+		return nil
+	}
+	return metrics.SendTo(p.TreeNodeInstance, p.Parent(), msg)
+}
+
+// SendToChildren shadows the one of the TreeNodeInstance to account the
+// bytes of the request to the proof phase, see metrics.SendTo. It stops at
+// the first child it can't send to.
+func (p *Protocol) SendToChildren(msg interface{}) error {
+	for _, tn := range p.Children() {
+		if err := metrics.SendTo(p.TreeNodeInstance, tn, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start sends the request to the members and handles it on the root.
+func (p *Protocol) Start() error {
+	switch {
+	case p.LockRequest != nil:
+		if len(p.LockRequest.Txs) == 0 {
+			return errors.New("no transaction to lock")
+		}
+		if err := p.SendToChildren(p.LockRequest); err != nil {
+			return err
+		}
+		p.lockReplyChan <- lockReplyChan{p.TreeNode(), *p.handleLock(p.LockRequest)}
+	case p.UnlockRequest != nil:
+		if err := p.SendToChildren(p.UnlockRequest); err != nil {
+			return err
+		}
+		p.unlockReplyChan <- unlockReplyChan{p.TreeNode(),
+			*p.handleUnlock(p.UnlockRequest)}
+	default:
+		return errors.New("no request to send")
+	}
+	return nil
+}
+
+// Dispatch handles the request on the members and collects the replies on
+// the root.
+func (p *Protocol) Dispatch() error {
+	defer p.Done()
+	timeout := time.After(Timeout)
+	if !p.IsRoot() {
+		var reply interface{}
+		select {
+		case msg := <-p.lockChan:
+			reply = p.handleLock(&msg.LockRequest)
+		case msg := <-p.unlockChan:
+			reply = p.handleUnlock(&msg.UnlockRequest)
+		case <-timeout:
+			return errors.New("didn't get a request")
+		}
+		return p.SendToParent(reply)
+	}
+	for !p.finished {
+		select {
+		case msg := <-p.lockReplyChan:
+			p.collectLock(msg.TreeNode, &msg.LockReply)
+		case msg := <-p.unlockReplyChan:
+			p.collectUnlock(&msg.UnlockReply)
+		case <-timeout:
+			p.finish(errors.New("timeout while waiting for the shard"))
+		}
+	}
+	return nil
+}
+
+// handleLock locks the inputs on our state of the shard and signs the
+// header of the block of our decisions, or of the opposite ones if we lie.
+func (p *Protocol) handleLock(req *LockRequest) *LockReply {
+	accepts := make([]bool, len(req.Txs))
+	for i := range req.Txs {
+		if req.Timeout {
+			accepts[i] = p.shard.HandleTimeout(&req.Txs[i])
+		} else {
+			accepts[i] = p.shard.HandleLock(&req.Txs[i])
+		}
+	}
+	if p.shard.lies() {
+		for i := range accepts {
+			accepts[i] = !accepts[i]
+		}
+	}
+	header, _ := newBlock(p.shard.ID, req.Txs, accepts)
+	sig, err := sign.Schnorr(p.Suite(), p.Private(), header.Hash())
+	if err != nil {
+		log.Error(p.Name(), "couldn't sign:", err)
+		sig = []byte{}
+	}
+	return &LockReply{Accepts: accepts, Sig: sig}
+}
+
+// handleUnlock commits or aborts the transaction on our state of the shard,
+// and tells the opposite if we lie.
+func (p *Protocol) handleUnlock(req *UnlockRequest) *UnlockReply {
+	committed, err := p.shard.HandleUnlock(&req.Tx, req.Proofs)
+	if err != nil {
+		log.Lvl2(p.Name(), "couldn't unlock:", err)
+		return &UnlockReply{Err: err.Error()}
+	}
+	if p.shard.lies() {
+		committed = !committed
+	}
+	return &UnlockReply{Committed: committed}
+}
+
+// collectLock adds the decisions of a member. Once 2f+1 members signed the
+// same block of decisions, the proofs are passed to the client.
+func (p *Protocol) collectLock(tn *onet.TreeNode, reply *LockReply) {
+	p.received++
+	n := len(p.Roster().List)
+	txs := p.LockRequest.Txs
+	if len(reply.Accepts) != len(txs) {
+		log.Lvl2(p.Name(), "wrong number of decisions from", tn.ServerIdentity)
+		p.checkDisagreement(n)
+		return
+	}
+	header, paths := newBlock(p.shard.ID, txs, reply.Accepts)
+	msg := header.Hash()
+	if err := sign.VerifySchnorr(p.Suite(), tn.ServerIdentity.Public, msg,
+		reply.Sig); err != nil {
+		log.Lvl2(p.Name(), "invalid signature of", tn.ServerIdentity)
+		p.checkDisagreement(n)
+		return
+	}
+	index, _ := p.Roster().Search(tn.ServerIdentity.ID)
+	key := string(msg)
+	if p.votes[key] == nil {
+		p.votes[key] = make(map[int][]byte)
+	}
+	p.votes[key][index] = reply.Sig
+	if len(p.votes[key]) < threshold(n) {
+		p.checkDisagreement(n)
+		return
+	}
+
+	var sigs []Signature
+	for index, sig := range p.votes[key] {
+		sigs = append(sigs, Signature{Index: index, Sig: sig})
+	}
+	var shardSig []byte
+	if p.thresholdSign != nil {
+		var err error
+		if shardSig, err = p.thresholdSign(msg); err != nil {
+			log.Error(p.Name(), "couldn't sign with the key of the shard:", err)
+		}
+	}
+	proofs := make([]Proof, len(txs))
+	for i := range txs {
+		proofs[i] = Proof{
+			TxHash:     txs[i].Hash(),
+			Shard:      p.shard.ID,
+			Accept:     reply.Accepts[i],
+			Header:     *header,
+			Index:      i,
+			Path:       paths[i],
+			Signatures: sigs,
+			ShardSig:   shardSig,
+		}
+	}
+	if h := p.shard.Headers(); h != nil {
+		_, err := h.Add(&SignedHeader{Header: *header, Signatures: sigs})
+		if err != nil {
+			log.Error(p.Name(), "couldn't retain header:", err)
+		}
+	}
+	p.finished = true
+	if p.onProofs != nil {
+		p.onProofs(proofs, nil)
+	}
+}
+
+// checkDisagreement stops the protocol once all members replied without 2f+1
+// of them agreeing on the same decisions.
+func (p *Protocol) checkDisagreement(n int) {
+	if p.received == n {
+		p.finish(errors.New("the shard didn't agree"))
+	}
+}
+
+// collectUnlock adds the result of a member. Once 2f+1 members agree, the
+// result is passed to the client.
+func (p *Protocol) collectUnlock(reply *UnlockReply) {
+	n := len(p.Roster().List)
+	if reply.Err != "" {
+		p.errors++
+		if p.errors > n-threshold(n) {
+			p.finish(errors.New(reply.Err))
+		}
+		return
+	}
+	p.unlocked[reply.Committed]++
+	if p.unlocked[reply.Committed] >= threshold(n) {
+		p.finished = true
+		if p.onUnlock != nil {
+			p.onUnlock(reply.Committed, nil)
+		}
+	}
+}
+
+// finish stops the protocol with an error passed to the client.
+func (p *Protocol) finish(err error) {
+	p.finished = true
+	if p.onProofs != nil {
+		p.onProofs(nil, err)
+	}
+	if p.onUnlock != nil {
+		p.onUnlock(false, err)
+	}
+}
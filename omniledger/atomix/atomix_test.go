@@ -0,0 +1,658 @@
+package atomix
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/eddsa"
+	"gopkg.in/dedis/crypto.v0/random"
+	"gopkg.in/dedis/crypto.v0/sign"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// shards holds the state of the shard of every server of the test.
+var shards = make(map[network.ServerIdentityID]*Shard)
+
+// shardSigners sign the headers of the shards with a key of the shard, if
+// set.
+var shardSigners = make(map[int]func([]byte) ([]byte, error))
+
+func TestMain(m *testing.M) {
+	onet.GlobalProtocolRegister(Name, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		shard := shards[n.ServerIdentity().ID]
+		p, err := NewProtocol(n, shard)
+		if err == nil && shardSigners[shard.ID] != nil {
+			p.RegisterThresholdSign(shardSigners[shard.ID])
+		}
+		return p, err
+	})
+	onet.GlobalProtocolRegister(GossipName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return NewGossip(n, shards[n.ServerIdentity().ID].Headers())
+	})
+	log.MainTest(m)
+}
+
+// setup creates nbr shards of four members each, holding the outputs "a"
+// and "b" in shard 0 and "c" in shard 1.
+func setup(local *onet.LocalTest, nbr int) *Client {
+	servers := local.GenServers(4 * nbr)
+	var rosters []*onet.Roster
+	for i := 0; i < nbr; i++ {
+		rosters = append(rosters, local.GenRosterFromHost(servers[4*i:4*i+4]...))
+	}
+	utxos := []map[string]int64{{"a": 10, "b": 5}, {"c": 20}}
+	for i, roster := range rosters {
+		for _, si := range roster.List {
+			state := map[string]int64{}
+			if i < len(utxos) {
+				state = utxos[i]
+			}
+			shards[si.ID] = NewShard(i, rosters, state)
+		}
+	}
+	return NewClient(rosters, local.CreateProtocol)
+}
+
+// agree asserts that at least 2f+1 members of the shard fulfill f. The
+// client only waits for them, so the others might not be done yet.
+func agree(t *testing.T, c *Client, shard int, f func(*Shard) bool) {
+	count := 0
+	for _, si := range c.Rosters[shard].List {
+		if f(shards[si.ID]) {
+			count++
+		}
+	}
+	assert.True(t, count >= threshold(len(c.Rosters[shard].List)))
+}
+
+// unspent returns a function checking that the output holds value.
+func unspent(id string, value int64) func(*Shard) bool {
+	return func(s *Shard) bool {
+		v, ok := s.Unspent(id)
+		return ok && v == value
+	}
+}
+
+// spent returns a function checking that the output doesn't exist.
+func spent(id string) func(*Shard) bool {
+	return func(s *Shard) bool {
+		_, ok := s.Unspent(id)
+		return !ok
+	}
+}
+
+func TestCommit(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	c := setup(local, 3)
+
+	tx := &Transaction{
+		Inputs:  []Input{{0, "a", 10}, {1, "c", 20}},
+		Outputs: []Output{{2, "d", 25}, {0, "e", 5}},
+	}
+	proofs, err := c.Lock(tx)
+	require.Nil(t, err)
+	committed, err := c.Unlock(tx, proofs)
+	require.Nil(t, err)
+	require.True(t, committed)
+	agree(t, c, 0, spent("a"))
+	agree(t, c, 0, unspent("e", 5))
+	agree(t, c, 1, spent("c"))
+	agree(t, c, 2, unspent("d", 25))
+
+	// unlocking twice is fine, but the inputs can't be locked again
+	committed, err = c.Unlock(tx, proofs)
+	require.Nil(t, err)
+	require.True(t, committed)
+	_, err = c.Unlock(tx, nil)
+	require.NotNil(t, err)
+	rejected, err := c.Lock(tx)
+	require.Nil(t, err)
+	for _, proof := range rejected {
+		assert.False(t, proof.Accept)
+	}
+	// the rejection doesn't abort the committed transaction
+	committed, err = c.Unlock(tx, rejected)
+	require.Nil(t, err)
+	require.False(t, committed)
+	committed, err = c.Unlock(tx, proofs)
+	require.Nil(t, err)
+	require.True(t, committed)
+	agree(t, c, 1, spent("c"))
+	agree(t, c, 2, unspent("d", 25))
+	// nor can another transaction spend the inputs
+	other := &Transaction{Inputs: []Input{{0, "a", 10}}}
+	proofs, err = c.Lock(other)
+	require.Nil(t, err)
+	assert.False(t, proofs[0].Accept)
+}
+
+func TestProofBandwidth(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	c := setup(local, 2)
+	metrics.AccountPhases(true)
+	defer metrics.AccountPhases(false)
+
+	tx := &Transaction{
+		Inputs:  []Input{{0, "a", 10}, {1, "c", 20}},
+		Outputs: []Output{{1, "d", 30}},
+	}
+	proofs, err := c.Lock(tx)
+	require.Nil(t, err)
+	committed, err := c.Unlock(tx, proofs)
+	require.Nil(t, err)
+	require.True(t, committed)
+	bw := metrics.EndRound(c.Rosters[0].List[0])
+	require.Equal(t, 1, len(bw.Total))
+	require.True(t, bw.Total[metrics.PhaseProof] > 0)
+	require.True(t, bw.Root[metrics.PhaseProof] > 0)
+	require.True(t, bw.Host[metrics.PhaseProof] >= bw.Root[metrics.PhaseProof])
+}
+
+func TestAbort(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	c := setup(local, 2)
+
+	// "x" doesn't exist in shard 1, so shard 1 rejects
+	tx := &Transaction{
+		Inputs:  []Input{{0, "a", 10}, {1, "x", 20}},
+		Outputs: []Output{{1, "y", 30}},
+	}
+	proofs, err := c.Lock(tx)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(proofs))
+	assert.True(t, proofs[0].Accept)
+	assert.False(t, proofs[1].Accept)
+	agree(t, c, 0, func(s *Shard) bool { return s.Locked("a") })
+
+	// while "a" is locked, nobody else can spend it
+	other := &Transaction{Inputs: []Input{{0, "a", 10}}}
+	otherProofs, err := c.Lock(other)
+	require.Nil(t, err)
+	assert.False(t, otherProofs[0].Accept)
+
+	committed, err := c.Unlock(tx, proofs)
+	require.Nil(t, err)
+	require.False(t, committed)
+	agree(t, c, 0, func(s *Shard) bool { return !s.Locked("a") })
+	agree(t, c, 0, unspent("a", 10))
+	agree(t, c, 1, spent("y"))
+
+	// the rejection of the other transaction is final, but "a" can be
+	// spent again
+	committed, err = c.Submit(other)
+	require.Nil(t, err)
+	require.False(t, committed)
+	again := &Transaction{Inputs: []Input{{0, "a", 10}}, Outputs: []Output{{0, "z", 10}}}
+	committed, err = c.Submit(again)
+	require.Nil(t, err)
+	require.True(t, committed)
+}
+
+func TestStaleRejection(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	c := setup(local, 2)
+
+	// "a" is locked by a transaction that shard 1 will reject
+	blocker := &Transaction{Inputs: []Input{{0, "a", 10}, {1, "x", 1}}}
+	blockerProofs, err := c.Lock(blocker)
+	require.Nil(t, err)
+	tx := &Transaction{
+		Inputs:  []Input{{0, "a", 10}, {1, "c", 20}},
+		Outputs: []Output{{1, "d", 30}},
+	}
+	stale, err := c.Lock(tx)
+	require.Nil(t, err)
+	assert.False(t, stale[0].Accept)
+	assert.True(t, stale[1].Accept)
+	committed, err := c.Unlock(blocker, blockerProofs)
+	require.Nil(t, err)
+	require.False(t, committed)
+
+	// once "a" is unlocked, shard 0 still rejects the transaction, so
+	// the proof of rejection kept by the client can't abort it after it
+	// has been accepted
+	proofs, err := c.Lock(tx)
+	require.Nil(t, err)
+	assert.False(t, proofs[0].Accept)
+	committed, err = c.Unlock(tx, stale)
+	require.Nil(t, err)
+	require.False(t, committed)
+	agree(t, c, 1, unspent("c", 20))
+	agree(t, c, 1, func(s *Shard) bool { return !s.Locked("c") })
+
+	// nor unlock the inputs of a committed transaction: shard 0 commits,
+	// then shard 1 is made to reject
+	tx = &Transaction{
+		Inputs:  []Input{{0, "b", 5}, {1, "c", 20}},
+		Outputs: []Output{{1, "e", 25}},
+	}
+	accepted, err := c.Lock(tx)
+	require.Nil(t, err)
+	committed, err = c.UnlockShard(0, tx, accepted)
+	require.Nil(t, err)
+	require.True(t, committed)
+	for _, si := range c.Rosters[1].List {
+		s := shards[si.ID]
+		s.mutex.Lock()
+		s.aborted[fmt.Sprintf("%x", tx.Hash())] = true
+		s.mutex.Unlock()
+	}
+	// shard 0 rejects it too, "b" being spent, without aborting it
+	rejected, err := c.Lock(tx)
+	require.Nil(t, err)
+	require.False(t, rejected[0].Accept)
+	require.False(t, rejected[1].Accept)
+	committed, err = c.UnlockShard(0, tx, rejected)
+	require.Nil(t, err)
+	require.False(t, committed)
+	committed, err = c.UnlockShard(0, tx, accepted)
+	require.Nil(t, err)
+	require.True(t, committed)
+	agree(t, c, 0, spent("b"))
+	agree(t, c, 0, func(s *Shard) bool { return !s.Locked("b") })
+}
+
+func TestForgedProof(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	c := setup(local, 2)
+
+	tx := &Transaction{
+		Inputs:  []Input{{0, "a", 10}, {1, "c", 20}},
+		Outputs: []Output{{1, "d", 30}},
+	}
+	proofs, err := c.Lock(tx)
+	require.Nil(t, err)
+	for _, proof := range proofs {
+		require.Nil(t, VerifyProof(c.Rosters[proof.Shard], &proof))
+	}
+
+	// a proof of shard 0 doesn't verify for shard 1
+	assert.NotNil(t, VerifyProof(c.Rosters[1], &proofs[0]))
+	// turning a proof-of-acceptance into a proof-of-rejection
+	forged := proofs[1]
+	forged.Accept = false
+	assert.NotNil(t, VerifyProof(c.Rosters[1], &forged))
+	// not enough signatures
+	forged = proofs[1]
+	sig := proofs[1].Signatures[0]
+	forged.Signatures = []Signature{sig}
+	assert.NotNil(t, VerifyProof(c.Rosters[1], &forged))
+	// the same signature thrice
+	forged.Signatures = []Signature{sig, sig, sig}
+	assert.NotNil(t, VerifyProof(c.Rosters[1], &forged))
+
+	_, err = c.Unlock(tx, []Proof{proofs[0], forged})
+	assert.NotNil(t, err)
+	committed, err := c.Unlock(tx, proofs)
+	require.Nil(t, err)
+	require.True(t, committed)
+}
+
+func TestShardKey(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	c := setup(local, 2)
+	// the signatures of a distributed key are EdDSA signatures
+	keys := []*eddsa.EdDSA{eddsa.NewEdDSA(random.Stream),
+		eddsa.NewEdDSA(random.Stream)}
+	publics := []abstract.Point{keys[0].Public, keys[1].Public}
+	for i, key := range keys {
+		shardSigners[i] = key.Sign
+	}
+	defer func() {
+		shardSigners = make(map[int]func([]byte) ([]byte, error))
+	}()
+	for _, roster := range c.Rosters {
+		for _, si := range roster.List {
+			shards[si.ID].UseKeys(publics)
+		}
+	}
+
+	tx := &Transaction{
+		Inputs:  []Input{{0, "a", 10}, {1, "c", 20}},
+		Outputs: []Output{{1, "d", 30}},
+	}
+	proofs, err := c.Lock(tx)
+	require.Nil(t, err)
+	for _, proof := range proofs {
+		require.Nil(t, VerifyShardProof(publics[proof.Shard], &proof))
+	}
+	assert.NotNil(t, VerifyShardProof(publics[1], &proofs[0]))
+	forged := proofs[1]
+	forged.Accept = false
+	assert.NotNil(t, VerifyShardProof(publics[1], &forged))
+	forged = proofs[1]
+	forged.ShardSig = nil
+	assert.NotNil(t, VerifyShardProof(publics[1], &forged))
+
+	// the shards knowing the keys don't need the signatures of the members
+	for i := range proofs {
+		proofs[i].Signatures = nil
+	}
+	committed, err := c.Unlock(tx, proofs)
+	require.Nil(t, err)
+	require.True(t, committed)
+	agree(t, c, 1, unspent("d", 30))
+}
+
+func TestGossip(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	c := setup(local, 3)
+	for _, roster := range c.Rosters {
+		for _, si := range roster.List {
+			shards[si.ID].UseHeaders(NewHeaders(c.Rosters, 0))
+		}
+	}
+
+	tx := &Transaction{
+		Inputs:  []Input{{0, "a", 10}, {1, "c", 20}},
+		Outputs: []Output{{2, "d", 30}},
+	}
+	proofs, err := c.Lock(tx)
+	require.Nil(t, err)
+	root := c.Rosters[0].List[0]
+	headers := shards[root.ID].Headers()
+	require.True(t, headers.Known(proofs[0].Header.Hash()))
+	member := shards[c.Rosters[2].List[1].ID].Headers()
+	require.False(t, member.Known(proofs[0].Header.Hash()))
+
+	// all eleven other members learn the header of shard 0
+	count, err := GossipRound(local.CreateProtocol, root, headers, 4,
+		rand.New(rand.NewSource(1)))
+	require.Nil(t, err)
+	assert.Equal(t, 11, count)
+	assert.Equal(t, 1, len(member.Latest(0)))
+
+	// the proof on a known header is checked without its signatures
+	stripped := proofs[0]
+	stripped.Signatures = nil
+	require.Nil(t, member.VerifyProof(&stripped))
+	forged := stripped
+	forged.Accept = false
+	assert.NotNil(t, member.VerifyProof(&forged))
+	unknown := proofs[1]
+	unknown.Signatures = nil
+	assert.NotNil(t, member.VerifyProof(&unknown))
+
+	committed, err := c.Unlock(tx, []Proof{stripped, proofs[1]})
+	require.Nil(t, err)
+	require.True(t, committed)
+	agree(t, c, 2, unspent("d", 30))
+}
+
+func TestLockBlock(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	c := setup(local, 1)
+
+	// the second transaction double spends "a" within the block
+	txs := []Transaction{
+		{Inputs: []Input{{0, "a", 10}}, Outputs: []Output{{0, "x", 10}}},
+		{Inputs: []Input{{0, "a", 10}}, Outputs: []Output{{0, "y", 10}}},
+		{Inputs: []Input{{0, "b", 5}}, Outputs: []Output{{0, "z", 5}}},
+	}
+	proofs, err := c.LockBlock(0, txs)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(proofs))
+	for i, accept := range []bool{true, false, true} {
+		assert.Equal(t, accept, proofs[i].Accept)
+		assert.True(t, proofs[i].For(txs[i].Hash()))
+		assert.Equal(t, proofs[0].Header, proofs[i].Header)
+		require.Nil(t, VerifyProof(c.Rosters[0], &proofs[i]))
+	}
+
+	// the path of another decision of the block doesn't fit
+	forged := proofs[0]
+	forged.Path = proofs[2].Path
+	assert.NotNil(t, VerifyProof(c.Rosters[0], &forged))
+	// nor does another header
+	forged = proofs[0]
+	forged.Header.Root = proofs[2].Path[0]
+	assert.NotNil(t, VerifyProof(c.Rosters[0], &forged))
+
+	committed, err := c.Unlock(&txs[0], proofs[0:1])
+	require.Nil(t, err)
+	require.True(t, committed)
+	agree(t, c, 0, unspent("x", 10))
+	agree(t, c, 0, spent("y"))
+	_, err = c.LockBlock(0, nil)
+	assert.NotNil(t, err)
+}
+
+func TestReclaimCommit(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	c := setup(local, 3)
+
+	// the client crashes after locking the inputs on both shards
+	tx := &Transaction{
+		Inputs:  []Input{{0, "a", 10}, {1, "c", 20}},
+		Outputs: []Output{{2, "d", 30}},
+	}
+	_, err := c.Lock(tx)
+	require.Nil(t, err)
+	agree(t, c, 0, func(s *Shard) bool { return s.Locked("a") })
+	member := shards[c.Rosters[0].List[0].ID]
+	assert.Equal(t, 0, len(member.Pending(time.Hour)))
+	require.Equal(t, 1, len(member.Pending(0)))
+
+	// a member of the shard completes it
+	other := NewClient(c.Rosters, local.CreateProtocol)
+	committed, aborted, err := other.ReclaimPending(member, 0)
+	require.Nil(t, err)
+	assert.Equal(t, 1, committed)
+	assert.Equal(t, 0, aborted)
+	agree(t, c, 0, spent("a"))
+	agree(t, c, 1, spent("c"))
+	agree(t, c, 2, unspent("d", 30))
+	assert.Equal(t, 0, len(member.Pending(0)))
+
+	// reclaiming again gives the same result
+	committed2, err := other.Reclaim(tx)
+	require.Nil(t, err)
+	assert.True(t, committed2)
+}
+
+func TestReclaimAbort(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	c := setup(local, 2)
+
+	// the client crashes after locking the input of shard 0 only
+	tx := &Transaction{
+		Inputs:  []Input{{0, "a", 10}, {1, "c", 20}},
+		Outputs: []Output{{1, "d", 30}},
+	}
+	_, err := c.LockBlock(0, []Transaction{*tx})
+	require.Nil(t, err)
+	agree(t, c, 0, func(s *Shard) bool { return s.Locked("a") })
+
+	committed, err := NewClient(c.Rosters, local.CreateProtocol).Reclaim(tx)
+	require.Nil(t, err)
+	require.False(t, committed)
+	for shard, id := range []string{"a", "c"} {
+		agree(t, c, shard, func(s *Shard) bool { return !s.Locked(id) })
+	}
+	agree(t, c, 0, unspent("a", 10))
+	agree(t, c, 1, unspent("c", 20))
+	agree(t, c, 1, spent("d"))
+
+	// shard 1 rejected the transaction for good, even if the client
+	// comes back
+	committed, err = c.Submit(tx)
+	require.Nil(t, err)
+	require.False(t, committed)
+	agree(t, c, 0, func(s *Shard) bool { return !s.Locked("a") })
+
+	// the inputs can be spent by other transactions
+	committed, err = c.Submit(&Transaction{
+		Inputs:  []Input{{0, "a", 10}, {1, "c", 20}},
+		Outputs: []Output{{1, "e", 30}},
+	})
+	require.Nil(t, err)
+	require.True(t, committed)
+}
+
+func TestFaultyClient(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	c := setup(local, 2)
+	member := shards[c.Rosters[0].List[0].ID]
+	released := make(chan time.Duration, 2)
+	member.RegisterOnRelease(func(tx *Transaction, locked time.Duration) {
+		released <- locked
+	})
+
+	// the client crashes after locking
+	f := NewFaultyClient(c, 1, Crash, 0)
+	tx := &Transaction{
+		Inputs:  []Input{{0, "a", 10}, {1, "c", 20}},
+		Outputs: []Output{{1, "d", 30}},
+	}
+	_, err := f.Submit(tx)
+	require.Equal(t, ErrCrashed, err)
+	agree(t, c, 0, func(s *Shard) bool { return s.Locked("a") })
+	assert.Equal(t, 0, len(f.Crashed(time.Hour)))
+	crashed := f.Crashed(0)
+	require.Equal(t, 1, len(crashed))
+	assert.Equal(t, 0, len(f.Crashed(0)))
+
+	time.Sleep(10 * time.Millisecond)
+	committed, err := NewClient(c.Rosters, local.CreateProtocol).Reclaim(&crashed[0])
+	require.Nil(t, err)
+	require.True(t, committed)
+	agree(t, c, 1, unspent("d", 30))
+	assert.True(t, <-released >= 10*time.Millisecond)
+
+	// the client stalls before unlocking
+	f.Mode = Stall
+	f.StallTime = 10 * time.Millisecond
+	committed, err = f.Submit(&Transaction{
+		Inputs:  []Input{{0, "b", 5}},
+		Outputs: []Output{{0, "e", 5}},
+	})
+	require.Nil(t, err)
+	require.True(t, committed)
+	assert.Equal(t, 0, len(f.Crashed(0)))
+	assert.True(t, <-released >= 10*time.Millisecond)
+}
+
+func TestLyingMembers(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	c := setup(local, 2)
+	liar := c.Rosters[0].List[3]
+	shards[liar.ID].Lie()
+
+	// the honest members outvote one liar, whose signature is left out
+	tx := &Transaction{
+		Inputs:  []Input{{0, "a", 10}, {1, "c", 20}},
+		Outputs: []Output{{1, "d", 30}},
+	}
+	proofs, err := c.Lock(tx)
+	require.Nil(t, err)
+	require.True(t, proofs[0].Accept)
+	for _, sig := range proofs[0].Signatures {
+		assert.NotEqual(t, 3, sig.Index)
+	}
+
+	// the signature of the liar on the opposite decision doesn't make a
+	// proof-of-rejection
+	header, paths := newBlock(0, []Transaction{*tx}, []bool{false})
+	sig, err := sign.Schnorr(network.Suite, local.GetPrivate(local.Servers[liar.ID]),
+		header.Hash())
+	require.Nil(t, err)
+	forged := proofs[0]
+	forged.Accept = false
+	forged.Header = *header
+	forged.Path = paths[0]
+	forged.Signatures = []Signature{{Index: 3, Sig: sig}}
+	assert.NotNil(t, VerifyProof(c.Rosters[0], &forged))
+	_, err = c.Unlock(tx, []Proof{forged, proofs[1]})
+	assert.NotNil(t, err)
+
+	// nor does its claim that the transaction aborted
+	committed, err := c.Unlock(tx, proofs)
+	require.Nil(t, err)
+	require.True(t, committed)
+	agree(t, c, 1, unspent("d", 30))
+
+	// with more than f liars, the shard doesn't agree
+	shards[c.Rosters[0].List[2].ID].Lie()
+	_, err = c.Lock(&Transaction{
+		Inputs:  []Input{{0, "b", 5}},
+		Outputs: []Output{{0, "e", 5}},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestRetry(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	c := setup(local, 2)
+
+	// the first request to every shard fails before reaching it
+	var mutex sync.Mutex
+	reached := make(map[onet.RosterID]bool)
+	c = NewClient(c.Rosters, func(name string, tree *onet.Tree) (onet.ProtocolInstance, error) {
+		mutex.Lock()
+		fail := !reached[tree.Roster.ID]
+		reached[tree.Roster.ID] = true
+		mutex.Unlock()
+		if fail {
+			return nil, errors.New("connection lost")
+		}
+		return local.CreateProtocol(name, tree)
+	})
+	tx := &Transaction{
+		Inputs:  []Input{{0, "a", 10}},
+		Outputs: []Output{{1, "d", 10}},
+	}
+	_, err := c.Submit(tx)
+	require.NotNil(t, err)
+
+	c.Retries = 1
+	committed, err := c.Submit(tx)
+	require.Nil(t, err)
+	require.True(t, committed)
+	agree(t, c, 0, spent("a"))
+	agree(t, c, 1, unspent("d", 10))
+}
+
+func TestVerifyTransaction(t *testing.T) {
+	assert.NotNil(t, (&Transaction{}).Verify())
+	assert.NotNil(t, (&Transaction{
+		Inputs: []Input{{0, "a", 1}, {0, "a", 1}},
+	}).Verify())
+	assert.NotNil(t, (&Transaction{
+		Inputs:  []Input{{0, "a", 1}},
+		Outputs: []Output{{0, "b", 2}},
+	}).Verify())
+	assert.NotNil(t, (&Transaction{
+		Inputs:  []Input{{0, "a", 1}},
+		Outputs: []Output{{0, "b", -1}},
+	}).Verify())
+	tx := &Transaction{
+		Inputs:  []Input{{2, "a", 1}, {0, "b", 1}, {2, "c", 1}},
+		Outputs: []Output{{1, "d", 2}, {1, "e", 1}},
+	}
+	assert.Nil(t, tx.Verify())
+	assert.Equal(t, []int{0, 2}, tx.InputShards())
+	assert.Equal(t, []int{1}, tx.OutputShards())
+}
@@ -0,0 +1,216 @@
+package atomix
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// CreateFunc creates a protocol instance on the root of the tree without
+// starting it, like onet.Overlay.CreateProtocol.
+type CreateFunc func(name string, t *onet.Tree) (onet.ProtocolInstance, error)
+
+// Client drives the transactions through Atomix. The first member of every
+// shard runs the protocol on behalf of the client.
+type Client struct {
+	// Rosters are the rosters of the shards, indexed by the shard ID
+	Rosters []*onet.Roster
+	// Retries is how many times a request failing on a shard is sent
+	// again. Both phases can safely be repeated.
+	Retries int
+	create  CreateFunc
+}
+
+// NewClient returns a client for the shards, using create to run the
+// protocols.
+func NewClient(rosters []*onet.Roster, create CreateFunc) *Client {
+	return &Client{Rosters: rosters, create: create}
+}
+
+// Submit runs both phases of Atomix for the transaction. It returns whether
+// the transaction has been committed or aborted.
+func (c *Client) Submit(tx *Transaction) (bool, error) {
+	proofs, err := c.Lock(tx)
+	if err != nil {
+		return false, err
+	}
+	return c.Unlock(tx, proofs)
+}
+
+// Lock sends the transaction to all input shards in parallel and returns
+// their proofs-of-acceptance or proofs-of-rejection.
+func (c *Client) Lock(tx *Transaction) ([]Proof, error) {
+	shards := tx.InputShards()
+	proofs := make([]Proof, len(shards))
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		go func(i, shard int) {
+			defer wg.Done()
+			var block []Proof
+			block, errs[i] = c.LockBlock(shard, []Transaction{*tx})
+			if errs[i] == nil {
+				proofs[i] = block[0]
+			}
+		}(i, shard)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %v", shards[i], err)
+		}
+	}
+	return proofs, nil
+}
+
+// LockBlock sends a block of transactions to the input shard, which decides
+// on them in order. It returns the proof of the shard for every transaction.
+func (c *Client) LockBlock(shard int, txs []Transaction) ([]Proof, error) {
+	return c.lockBlock(shard, &LockRequest{Txs: txs})
+}
+
+// Reclaim completes a transaction whose client crashed or timed out between
+// both phases, leaving inputs locked. It can be run by anybody, e.g. by a
+// member of a shard finding the transaction with Shard.Pending: the input
+// shards give their decisions again, rejecting the transaction for good if
+// they didn't accept it yet, and the proofs are sent to the shards as in
+// Unlock. It returns whether the transaction has been committed.
+func (c *Client) Reclaim(tx *Transaction) (bool, error) {
+	shards := tx.InputShards()
+	proofs := make([]Proof, len(shards))
+	for i, shard := range shards {
+		block, err := c.lockBlock(shard, &LockRequest{
+			Txs:     []Transaction{*tx},
+			Timeout: true,
+		})
+		if err != nil {
+			return false, fmt.Errorf("shard %d: %v", shard, err)
+		}
+		proofs[i] = block[0]
+	}
+	return c.Unlock(tx, proofs)
+}
+
+// ReclaimPending reclaims the transactions that have been holding locks on
+// the shard for longer than timeout. It returns how many of them have been
+// committed and aborted.
+func (c *Client) ReclaimPending(s *Shard, timeout time.Duration) (int, int, error) {
+	committed, aborted := 0, 0
+	for _, tx := range s.Pending(timeout) {
+		ok, err := c.Reclaim(&tx)
+		if err != nil {
+			return committed, aborted, err
+		}
+		if ok {
+			committed++
+		} else {
+			aborted++
+		}
+	}
+	return committed, aborted, nil
+}
+
+// lockBlock sends the lock request to the input shard.
+func (c *Client) lockBlock(shard int, req *LockRequest) ([]Proof, error) {
+	if len(req.Txs) == 0 {
+		return nil, errors.New("no transaction to lock")
+	}
+	var proofs []Proof
+	err := c.run(shard, func(p *Protocol, done chan error) {
+		p.LockRequest = req
+		p.RegisterOnProofs(func(pr []Proof, err error) {
+			proofs = pr
+			done <- err
+		})
+	})
+	return proofs, err
+}
+
+// Unlock sends the proofs to the shards: to the input and output shards if
+// all input shards accepted the transaction, else only to the input shards.
+// It returns whether the transaction has been committed.
+func (c *Client) Unlock(tx *Transaction, proofs []Proof) (bool, error) {
+	commit := true
+	for _, proof := range proofs {
+		commit = commit && proof.Accept
+	}
+	shards := tx.InputShards()
+	if commit {
+		shards = unique(append(shards, tx.OutputShards()...))
+	}
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		go func(i, shard int) {
+			defer wg.Done()
+			var committed bool
+			committed, errs[i] = c.UnlockShard(shard, tx, proofs)
+			if errs[i] == nil && committed != commit {
+				errs[i] = errors.New("shard didn't follow the proofs")
+			}
+		}(i, shard)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return false, fmt.Errorf("shard %d: %v", shards[i], err)
+		}
+	}
+	log.Lvl3("Transaction committed:", commit)
+	return commit, nil
+}
+
+// UnlockShard sends the proofs to one input or output shard and returns
+// whether the shard committed the transaction.
+func (c *Client) UnlockShard(shard int, tx *Transaction, proofs []Proof) (bool, error) {
+	var commit bool
+	err := c.run(shard, func(p *Protocol, done chan error) {
+		p.UnlockRequest = &UnlockRequest{Tx: *tx, Proofs: proofs}
+		p.RegisterOnUnlock(func(committed bool, err error) {
+			commit = committed
+			done <- err
+		})
+	})
+	return commit, err
+}
+
+// run runs the request on the shard, and again up to Retries times if it
+// fails.
+func (c *Client) run(shard int, setup func(*Protocol, chan error)) error {
+	if shard < 0 || shard >= len(c.Rosters) {
+		return errors.New("unknown shard")
+	}
+	err := c.runOnce(shard, setup)
+	for retry := 0; err != nil && retry < c.Retries; retry++ {
+		log.Lvl2("Retrying on shard", shard, "after:", err)
+		err = c.runOnce(shard, setup)
+	}
+	return err
+}
+
+// runOnce creates the protocol on the shard, lets setup set the request and
+// waits for the result.
+func (c *Client) runOnce(shard int, setup func(*Protocol, chan error)) error {
+	roster := c.Rosters[shard]
+	tree := roster.GenerateNaryTree(len(roster.List))
+	pi, err := c.create(Name, tree)
+	if err != nil {
+		return err
+	}
+	p, ok := pi.(*Protocol)
+	if !ok {
+		return errors.New("protocol " + Name + " is not Atomix")
+	}
+	done := make(chan error, 1)
+	setup(p, done)
+	if err := p.Start(); err != nil {
+		return err
+	}
+	return <-done
+}
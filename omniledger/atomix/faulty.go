@@ -0,0 +1,123 @@
+package atomix
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// FailureMode is how a FaultyClient fails between both phases of Atomix.
+type FailureMode int
+
+const (
+	// Crash leaves the transaction after the lock phase, as a client
+	// crashing would: its inputs stay locked until it is reclaimed.
+	Crash FailureMode = iota
+	// Stall waits before the unlock phase, as a slow or partitioned client
+	// would.
+	Stall
+)
+
+// ErrCrashed is returned by FaultyClient.Submit for the transactions it left
+// locked.
+var ErrCrashed = errors.New("client crashed between lock and unlock")
+
+// FaultyClient is a client failing between the lock and the unlock phases of
+// a fraction of its transactions, to measure how long their inputs stay
+// locked and to test their reclamation, see Client.Reclaim.
+type FaultyClient struct {
+	*Client
+	// Rate is the fraction of the transactions the client fails on
+	Rate float64
+	// Mode is how it fails
+	Mode FailureMode
+	// StallTime is how long a stalled transaction waits before unlocking
+	StallTime time.Duration
+
+	mutex sync.Mutex
+	rand  *rand.Rand
+	// crashed are the transactions left locked, with the time they have
+	// been locked
+	crashed []crashedTx
+}
+
+// crashedTx is a transaction a FaultyClient left locked.
+type crashedTx struct {
+	tx    Transaction
+	since time.Time
+}
+
+// NewFaultyClient returns a client failing as mode on a fraction rate of the
+// transactions, chosen with the seed.
+func NewFaultyClient(c *Client, rate float64, mode FailureMode, seed int64) *FaultyClient {
+	return &FaultyClient{
+		Client: c,
+		Rate:   rate,
+		Mode:   mode,
+		rand:   rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Submit runs both phases of Atomix for the transaction, failing in between
+// at the rate of the client. A crashed transaction returns ErrCrashed and can
+// be found with Crashed; a stalled one completes after StallTime.
+func (f *FaultyClient) Submit(tx *Transaction) (bool, error) {
+	proofs, err := f.Lock(tx)
+	if err != nil {
+		return false, err
+	}
+	f.mutex.Lock()
+	fail := f.rand.Float64() < f.Rate
+	if fail && f.Mode == Crash {
+		f.crashed = append(f.crashed, crashedTx{tx: *tx, since: time.Now()})
+	}
+	f.mutex.Unlock()
+	if fail {
+		if f.Mode == Crash {
+			log.Lvl3("Crashing after locking", tx.Hash())
+			return false, ErrCrashed
+		}
+		log.Lvl3("Stalling after locking", tx.Hash())
+		time.Sleep(f.StallTime)
+	}
+	return f.Unlock(tx, proofs)
+}
+
+// Crashed removes and returns the transactions the client left locked for
+// longer than timeout.
+func (f *FaultyClient) Crashed(timeout time.Duration) []Transaction {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var txs []Transaction
+	kept := f.crashed[:0]
+	for _, c := range f.crashed {
+		if time.Since(c.since) >= timeout {
+			txs = append(txs, c.tx)
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	f.crashed = kept
+	return txs
+}
+
+// Lie makes the member a byzantine one lying in the proofs of its shard: it
+// signs the opposite of its decisions in the lock phase, and claims the
+// opposite outcome in the unlock phase. As long as at most f members of a
+// shard lie, the 2f+1 honest ones still agree and the proofs only hold their
+// signatures, else the shard doesn't agree and no proof is given.
+func (s *Shard) Lie() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lying = true
+}
+
+// lies returns whether the member lies about its decisions.
+func (s *Shard) lies() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lying
+}
@@ -0,0 +1,306 @@
+package atomix
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func init() {
+	for _, i := range []interface{}{
+		HeaderGossip{},
+		HeaderGossipReply{},
+	} {
+		network.RegisterMessage(i)
+	}
+}
+
+// GossipName is the name of the protocol spreading the signed headers of
+// the shards, see NewGossip.
+const GossipName = "AtomixGossip"
+
+// SignedHeader is the header of a block of decisions of a shard with the
+// signatures of 2f+1 members.
+type SignedHeader struct {
+	Header     BlockHeader
+	Signatures []Signature
+}
+
+// Verify checks that 2f+1 distinct members of the roster of the shard
+// signed the header.
+func (h *SignedHeader) Verify(roster *onet.Roster) error {
+	return verifyHeader(roster, &h.Header, h.Signatures)
+}
+
+// Headers holds the signed headers of the blocks of all shards a member
+// learned, from its own shard or through the gossip between the shards.
+// The signatures of a header are verified once: the proofs on a known
+// header only need their Merkle path to be checked, without the signatures
+// nor the roster of the other shard.
+type Headers struct {
+	// Rosters are the rosters of all shards
+	Rosters []*onet.Roster
+	// Keep is the number of latest headers retained of every shard, 0 to
+	// retain all of them
+	Keep int
+
+	mutex   sync.Mutex
+	headers map[string]*SignedHeader
+	// order holds the hashes of the retained headers of every shard, the
+	// latest last
+	order map[int][]string
+}
+
+// NewHeaders returns an empty store retaining keep headers of every shard,
+// or all of them if keep is 0.
+func NewHeaders(rosters []*onet.Roster, keep int) *Headers {
+	return &Headers{
+		Rosters: rosters,
+		Keep:    keep,
+		headers: make(map[string]*SignedHeader),
+		order:   make(map[int][]string),
+	}
+}
+
+// Add verifies the header and retains it. It returns whether the header
+// was new.
+func (h *Headers) Add(sh *SignedHeader) (bool, error) {
+	shard := sh.Header.Shard
+	if shard < 0 || shard >= len(h.Rosters) {
+		return false, fmt.Errorf("header of unknown shard %d", shard)
+	}
+	key := string(sh.Header.Hash())
+	if h.Known(sh.Header.Hash()) {
+		return false, nil
+	}
+	if err := sh.Verify(h.Rosters[shard]); err != nil {
+		return false, err
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.headers[key] != nil {
+		return false, nil
+	}
+	h.headers[key] = sh
+	h.order[shard] = append(h.order[shard], key)
+	if h.Keep > 0 && len(h.order[shard]) > h.Keep {
+		delete(h.headers, h.order[shard][0])
+		h.order[shard] = h.order[shard][1:]
+	}
+	return true, nil
+}
+
+// Known returns whether the header with the hash is retained.
+func (h *Headers) Known(hash []byte) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.headers[string(hash)] != nil
+}
+
+// Latest returns the retained headers of the shard in the order they were
+// learned, the latest last.
+func (h *Headers) Latest(shard int) []SignedHeader {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	var ret []SignedHeader
+	for _, key := range h.order[shard] {
+		ret = append(ret, *h.headers[key])
+	}
+	return ret
+}
+
+// All returns the retained headers of all shards.
+func (h *Headers) All() []SignedHeader {
+	var ret []SignedHeader
+	for shard := range h.Rosters {
+		ret = append(ret, h.Latest(shard)...)
+	}
+	return ret
+}
+
+// VerifyProof checks the proof locally if its header is known, else checks
+// its signatures with the roster of its shard and retains its header.
+func (h *Headers) VerifyProof(proof *Proof) error {
+	if err := proof.verifyPath(); err != nil {
+		return err
+	}
+	if h.Known(proof.Header.Hash()) {
+		return nil
+	}
+	_, err := h.Add(&SignedHeader{
+		Header:     proof.Header,
+		Signatures: proof.Signatures,
+	})
+	return err
+}
+
+// HeaderGossip holds the headers a member sends to members of the shards.
+type HeaderGossip struct {
+	Headers []SignedHeader
+}
+
+// HeaderGossipReply tells how many of the headers were new to the member.
+type HeaderGossipReply struct {
+	New int
+}
+
+type headerGossipChan struct {
+	*onet.TreeNode
+	HeaderGossip
+}
+
+type headerGossipReplyChan struct {
+	*onet.TreeNode
+	HeaderGossipReply
+}
+
+// Gossip runs one round of the gossip between the shards: the root sends
+// the headers it knows to its children, members of any shard, which keep
+// the valid ones and pass them on in their own rounds.
+type Gossip struct {
+	*onet.TreeNodeInstance
+
+	headers    *Headers
+	gossipChan chan headerGossipChan
+	replyChan  chan headerGossipReplyChan
+	onDone     func(int, error)
+	newHeaders int
+}
+
+// NewGossip returns a new instance using the headers of the member. It has
+// to be registered by the members of the shards, for example:
+//
+//	onet.GlobalProtocolRegister(atomix.GossipName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+//		return atomix.NewGossip(n, headers)
+//	})
+func NewGossip(n *onet.TreeNodeInstance, headers *Headers) (*Gossip, error) {
+	if headers == nil {
+		return nil, errors.New("no headers to gossip")
+	}
+	g := &Gossip{
+		TreeNodeInstance: n,
+		headers:          headers,
+	}
+	if err := g.RegisterChannels(&g.gossipChan, &g.replyChan); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// RegisterOnDone sets the function called on the root with the number of
+// headers that were new to the children, once all of them replied.
+func (g *Gossip) RegisterOnDone(fn func(int, error)) {
+	g.onDone = fn
+}
+
+// Start sends the headers to the children.
+func (g *Gossip) Start() error {
+	if len(g.Children()) == 0 {
+		return errors.New("nobody to gossip with")
+	}
+	return g.SendToChildren(&HeaderGossip{Headers: g.headers.All()})
+}
+
+// Dispatch keeps the new headers on the children and collects the replies
+// on the root, one from every child.
+func (g *Gossip) Dispatch() error {
+	defer g.Done()
+	timeout := time.After(Timeout)
+	if !g.IsRoot() {
+		select {
+		case msg := <-g.gossipChan:
+			return g.SendToParent(&HeaderGossipReply{New: g.add(msg.Headers)})
+		case <-timeout:
+			return errors.New("didn't get the headers")
+		}
+	}
+	for repliesLeft := len(g.Children()); repliesLeft > 0; {
+		select {
+		case msg := <-g.replyChan:
+			g.newHeaders += msg.New
+			repliesLeft--
+		case <-timeout:
+			g.finish(errors.New("timeout while waiting for the members"))
+			return nil
+		}
+	}
+	g.finish(nil)
+	return nil
+}
+
+// add retains the valid headers and returns how many were new.
+func (g *Gossip) add(headers []SignedHeader) int {
+	count := 0
+	for i := range headers {
+		added, err := g.headers.Add(&headers[i])
+		if err != nil {
+			log.Lvl2(g.Name(), "drops header:", err)
+			continue
+		}
+		if added {
+			count++
+		}
+	}
+	return count
+}
+
+// finish passes the result to the caller.
+func (g *Gossip) finish(err error) {
+	if g.onDone != nil {
+		g.onDone(g.newHeaders, err)
+	}
+}
+
+// GossipRound sends the headers known to self to fanout members, chosen
+// with rnd, of every shard. It returns how many headers were new to them.
+func GossipRound(create CreateFunc, self *network.ServerIdentity, h *Headers,
+	fanout int, rnd *rand.Rand) (int, error) {
+	list := []*network.ServerIdentity{self}
+	chosen := map[network.ServerIdentityID]bool{self.ID: true}
+	for _, roster := range h.Rosters {
+		var others []*network.ServerIdentity
+		for _, si := range roster.List {
+			if !chosen[si.ID] {
+				others = append(others, si)
+			}
+		}
+		for i, j := range rnd.Perm(len(others)) {
+			if i == fanout {
+				break
+			}
+			list = append(list, others[j])
+			chosen[others[j].ID] = true
+		}
+	}
+	if len(list) == 1 {
+		return 0, errors.New("nobody to gossip with")
+	}
+	roster := onet.NewRoster(list)
+	pi, err := create(GossipName, roster.GenerateNaryTree(len(list)))
+	if err != nil {
+		return 0, err
+	}
+	g, ok := pi.(*Gossip)
+	if !ok {
+		return 0, errors.New("protocol " + GossipName + " is not gossip")
+	}
+	type result struct {
+		count int
+		err   error
+	}
+	done := make(chan result, 1)
+	g.RegisterOnDone(func(count int, err error) {
+		done <- result{count, err}
+	})
+	if err := g.Start(); err != nil {
+		return 0, err
+	}
+	res := <-done
+	return res.count, res.err
+}
@@ -0,0 +1,86 @@
+package atomix
+
+import (
+	"github.com/dedis/paper_17_sosp_omniledger/metrics"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func init() {
+	for _, i := range []interface{}{
+		LockRequest{},
+		LockReply{},
+		UnlockRequest{},
+		UnlockReply{},
+	} {
+		network.RegisterMessage(i)
+	}
+}
+
+// LockRequest is sent by the client to an input shard in the first phase:
+// for every transaction of the block, in order, the shard locks the inputs it
+// holds, or rejects it. If Timeout is set, the client of the transactions
+// timed out and the shard only accepts the ones it already holds the locks
+// of, see Shard.HandleTimeout.
+type LockRequest struct {
+	Txs     []Transaction
+	Timeout bool
+}
+
+// LockReply holds the decisions of one member of an input shard.
+type LockReply struct {
+	Accepts []bool
+	// Sig is the signature of the member on the header of the block of its
+	// decisions
+	Sig []byte
+}
+
+// UnlockRequest is sent by the client in the second phase. If Proofs hold
+// a proof-of-acceptance of every input shard, the transaction is committed.
+// If one of them is a proof-of-rejection, it is aborted.
+type UnlockRequest struct {
+	Tx     Transaction
+	Proofs []Proof
+}
+
+// UnlockReply tells whether a member of the shard committed or aborted the
+// transaction. Err is set if the member couldn't handle the request.
+type UnlockReply struct {
+	Committed bool
+	Err       string
+}
+
+// The requests and replies of both phases of Atomix exchange the proofs of
+// the shards and are accounted to the proof phase of metrics.
+
+// MetricsPhase implements metrics.Phased.
+func (LockRequest) MetricsPhase() string { return metrics.PhaseProof }
+
+// MetricsPhase implements metrics.Phased.
+func (LockReply) MetricsPhase() string { return metrics.PhaseProof }
+
+// MetricsPhase implements metrics.Phased.
+func (UnlockRequest) MetricsPhase() string { return metrics.PhaseProof }
+
+// MetricsPhase implements metrics.Phased.
+func (UnlockReply) MetricsPhase() string { return metrics.PhaseProof }
+
+type lockChan struct {
+	*onet.TreeNode
+	LockRequest
+}
+
+type lockReplyChan struct {
+	*onet.TreeNode
+	LockReply
+}
+
+type unlockChan struct {
+	*onet.TreeNode
+	UnlockRequest
+}
+
+type unlockReplyChan struct {
+	*onet.TreeNode
+	UnlockReply
+}
@@ -0,0 +1,151 @@
+package atomix
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/shardkey"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/sign"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// Proof is the proof-of-acceptance or proof-of-rejection of a transaction by
+// an input shard. The shard decides on a block of transactions at once and
+// signs the header of the block, which commits to the decisions with their
+// Merkle root. The proof holds the header, its collective signature and the
+// Merkle path of the decision, so it can be checked by anybody knowing the
+// roster of the shard. If the shard has a distributed key, the header is also
+// signed with it, and the proof can be checked with the public key of the
+// shard only, see VerifyShardProof.
+type Proof struct {
+	TxHash []byte
+	Shard  int
+	Accept bool
+	// Header is the header of the block holding the decision
+	Header BlockHeader
+	// Index is the position of the decision in the block. The Merkle path
+	// doesn't depend on it, so it only tells where to look for the
+	// transaction.
+	Index int
+	// Path is the Merkle path from the decision to the root of Header
+	Path crypto.Proof
+	// Signatures are the signatures of 2f+1 members on the hash of Header
+	Signatures []Signature
+	// ShardSig is the threshold signature of the shard on the hash of
+	// Header, if any
+	ShardSig []byte `protobuf:"opt"`
+}
+
+// BlockHeader is the header of a block of decisions of a shard.
+type BlockHeader struct {
+	Shard int
+	// Root is the Merkle root of the decisions of the block, in order
+	Root []byte
+}
+
+// Hash returns the hash signed by the members of the shard.
+func (h *BlockHeader) Hash() []byte {
+	d := sha256.Sum256([]byte(fmt.Sprintf("atomix-block/%d/%x", h.Shard,
+		h.Root)))
+	return d[:]
+}
+
+// Signature is the signature of one member of the shard.
+type Signature struct {
+	// Index is the index of the member in the roster of the shard
+	Index int
+	Sig   []byte
+}
+
+// For returns whether the proof is about the transaction with the hash.
+func (p *Proof) For(txHash []byte) bool {
+	return bytes.Equal(p.TxHash, txHash)
+}
+
+// decision returns the leaf of the decision of the shard in the Merkle tree
+// of a block.
+func decision(txHash []byte, shard int, accept bool) crypto.HashID {
+	d := sha256.Sum256([]byte(fmt.Sprintf("atomix/%x/%d/%t", txHash, shard,
+		accept)))
+	return d[:]
+}
+
+// newBlock returns the header of the block of the decisions of the shard on
+// the transactions, and the Merkle path of every decision.
+func newBlock(shard int, txs []Transaction, accepts []bool) (*BlockHeader, []crypto.Proof) {
+	leaves := make([]crypto.HashID, len(txs))
+	for i := range txs {
+		leaves[i] = decision(txs[i].Hash(), shard, accepts[i])
+	}
+	root, paths := crypto.ProofTree(sha256.New, leaves)
+	return &BlockHeader{Shard: shard, Root: []byte(root)}, paths
+}
+
+// threshold returns the number of members that have to agree in a shard of
+// n members: 2f+1.
+func threshold(n int) int {
+	return 2*((n-1)/3) + 1
+}
+
+// VerifyProof checks that the decision is in the block of the proof, and that
+// the header of the block holds valid signatures of 2f+1 distinct members of
+// the roster of the shard.
+func VerifyProof(roster *onet.Roster, proof *Proof) error {
+	if err := proof.verifyPath(); err != nil {
+		return err
+	}
+	return verifyHeader(roster, &proof.Header, proof.Signatures)
+}
+
+// VerifyShardProof checks that the decision is in the block of the proof,
+// and that the header of the block is signed with the distributed key of the
+// shard.
+func VerifyShardProof(public abstract.Point, proof *Proof) error {
+	if len(proof.ShardSig) == 0 {
+		return errors.New("no signature of the shard")
+	}
+	if err := proof.verifyPath(); err != nil {
+		return err
+	}
+	return shardkey.Verify(public, proof.Header.Hash(), proof.ShardSig)
+}
+
+// verifyPath checks that the decision is in the block of the proof.
+func (p *Proof) verifyPath() error {
+	if p.Header.Shard != p.Shard {
+		return errors.New("header of another shard")
+	}
+	leaf := decision(p.TxHash, p.Shard, p.Accept)
+	if !p.Path.Check(sha256.New, p.Header.Root, leaf) {
+		return errors.New("decision not in the block")
+	}
+	return nil
+}
+
+// verifyHeader checks that the signatures are valid signatures of 2f+1
+// distinct members of the roster on the header.
+func verifyHeader(roster *onet.Roster, header *BlockHeader, sigs []Signature) error {
+	n := len(roster.List)
+	policy := crypto.NewSignaturePolicy(network.Suite, roster.Publics(), threshold(n))
+	msg := header.Hash()
+	signers := crypto.NewSignerMask(n)
+	for _, s := range sigs {
+		if s.Index < 0 || s.Index >= n {
+			return fmt.Errorf("unknown member %d", s.Index)
+		}
+		if signers.Signed(s.Index) {
+			return fmt.Errorf("member %d signed twice", s.Index)
+		}
+		public := roster.List[s.Index].Public
+		if err := sign.VerifySchnorr(network.Suite, public, msg, s.Sig); err != nil {
+			return fmt.Errorf("invalid signature of member %d: %v", s.Index, err)
+		}
+		signers.Set(s.Index)
+	}
+	return policy.CheckMask(signers)
+}
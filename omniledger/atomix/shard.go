@@ -0,0 +1,473 @@
+package atomix
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// Input is an unspent output spent by a transaction. It is held by the shard
+// Shard.
+type Input struct {
+	Shard int
+	ID    string
+	Value int64
+}
+
+// Output is a new unspent output created by a transaction in the shard Shard.
+type Output struct {
+	Shard int
+	ID    string
+	Value int64
+}
+
+// Transaction spends inputs of one or more shards and creates outputs in
+// one or more shards.
+type Transaction struct {
+	Inputs  []Input
+	Outputs []Output
+}
+
+// Hash returns the hash identifying the transaction.
+func (tx *Transaction) Hash() []byte {
+	h := sha256.New()
+	for _, in := range tx.Inputs {
+		fmt.Fprintf(h, "in/%d/%s/%d;", in.Shard, in.ID, in.Value)
+	}
+	for _, out := range tx.Outputs {
+		fmt.Fprintf(h, "out/%d/%s/%d;", out.Shard, out.ID, out.Value)
+	}
+	return h.Sum(nil)
+}
+
+// Verify checks that the transaction is well-formed: it spends every input
+// once and doesn't create more than it spends.
+func (tx *Transaction) Verify() error {
+	if len(tx.Inputs) == 0 {
+		return errors.New("no inputs")
+	}
+	seen := make(map[string]bool)
+	var in, out int64
+	for _, i := range tx.Inputs {
+		if seen[i.ID] {
+			return fmt.Errorf("input %s spent twice", i.ID)
+		}
+		seen[i.ID] = true
+		in += i.Value
+	}
+	for _, o := range tx.Outputs {
+		if o.Value < 0 {
+			return fmt.Errorf("negative output %s", o.ID)
+		}
+		out += o.Value
+	}
+	if out > in {
+		return errors.New("outputs are bigger than inputs")
+	}
+	return nil
+}
+
+// InputShards returns the sorted list of the shards holding the inputs.
+func (tx *Transaction) InputShards() []int {
+	var shards []int
+	for _, in := range tx.Inputs {
+		shards = append(shards, in.Shard)
+	}
+	return unique(shards)
+}
+
+// OutputShards returns the sorted list of the shards receiving the outputs.
+func (tx *Transaction) OutputShards() []int {
+	var shards []int
+	for _, out := range tx.Outputs {
+		shards = append(shards, out.Shard)
+	}
+	return unique(shards)
+}
+
+// unique sorts the shards and removes the duplicates.
+func unique(shards []int) []int {
+	sort.Ints(shards)
+	var ret []int
+	for i, s := range shards {
+		if i == 0 || s != shards[i-1] {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// Shard is the state one member keeps of its shard: the unspent outputs and
+// the ones locked by a transaction in the first phase of Atomix.
+type Shard struct {
+	// ID is the index of the shard in Rosters
+	ID int
+	// Rosters are the rosters of all shards, used to verify the proofs
+	Rosters []*onet.Roster
+
+	mutex sync.Mutex
+	// utxos holds the value of the unspent outputs
+	utxos map[string]int64
+	// locked holds the transaction that locked an unspent output
+	locked map[string]string
+	// pending holds the transactions that locked outputs, until they are
+	// unlocked
+	pending map[string]*pendingTx
+	// committed are the transactions whose outputs have been created
+	committed map[string]bool
+	// aborted are the transactions rejected for good after their client
+	// timed out
+	aborted map[string]bool
+	// onCommit is called with every transaction the first time it commits
+	onCommit func(*Transaction)
+	// onRelease is called with every transaction whose locks are released
+	onRelease func(*Transaction, time.Duration)
+	// headers are the known headers of the shards, if any
+	headers *Headers
+	// keys are the distributed public keys of the shards, if any
+	keys []abstract.Point
+	// lying makes the member lie about its decisions, see Lie
+	lying bool
+}
+
+// pendingTx is a transaction holding locks, with the time it locked them.
+type pendingTx struct {
+	tx    Transaction
+	since time.Time
+}
+
+// NewShard returns the state of shard id, holding the given unspent outputs.
+func NewShard(id int, rosters []*onet.Roster, utxos map[string]int64) *Shard {
+	s := &Shard{
+		ID:        id,
+		Rosters:   rosters,
+		utxos:     make(map[string]int64),
+		locked:    make(map[string]string),
+		pending:   make(map[string]*pendingTx),
+		committed: make(map[string]bool),
+		aborted:   make(map[string]bool),
+	}
+	for id, value := range utxos {
+		s.utxos[id] = value
+	}
+	return s
+}
+
+// RegisterOnCommit sets the function called with every transaction the
+// first time the shard commits it, e.g. to add it to the ledger. It is
+// called while the state is locked, so it must not call the shard.
+func (s *Shard) RegisterOnCommit(fn func(*Transaction)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onCommit = fn
+}
+
+// RegisterOnRelease sets the function called with every transaction that
+// held locks once it releases them, committed or aborted, with how long it
+// held them. It is called while the state is locked, so it must not call
+// the shard.
+func (s *Shard) RegisterOnRelease(fn func(*Transaction, time.Duration)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onRelease = fn
+}
+
+// UseHeaders lets the shard check the proofs against the known headers of
+// the shards, and retain the headers of its own blocks of decisions for the
+// gossip between the shards.
+func (s *Shard) UseHeaders(h *Headers) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.headers = h
+}
+
+// UseKeys lets the shard check the proofs signed by their shard with its
+// distributed key, the i-th key being the one of shard i. The proofs without
+// such a signature are still checked with the rosters.
+func (s *Shard) UseKeys(keys []abstract.Point) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.keys = keys
+}
+
+// Headers returns the headers used by the shard, or nil.
+func (s *Shard) Headers() *Headers {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.headers
+}
+
+// Unspent returns the value of the output and whether it is still unspent.
+func (s *Shard) Unspent(id string) (int64, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	value, ok := s.utxos[id]
+	return value, ok
+}
+
+// UTXOs returns a copy of the unspent outputs, e.g. to create the state
+// block of the shard.
+func (s *Shard) UTXOs() map[string]int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	utxos := make(map[string]int64)
+	for id, value := range s.utxos {
+		utxos[id] = value
+	}
+	return utxos
+}
+
+// Locked returns whether the output is locked by a transaction.
+func (s *Shard) Locked(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.locked[id]
+	return ok
+}
+
+// Pending returns the transactions that have been holding locks for longer
+// than timeout, e.g. because their client crashed before unlocking them.
+// They can be completed with Client.Reclaim.
+func (s *Shard) Pending(timeout time.Duration) []Transaction {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var ids []string
+	for id, p := range s.pending {
+		if time.Since(p.since) >= timeout {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	txs := make([]Transaction, len(ids))
+	for i, id := range ids {
+		txs[i] = s.pending[id].tx
+	}
+	return txs
+}
+
+// HandleLock is the first phase on an input shard: if all the inputs of the
+// transaction held by the shard are unspent and not locked by another
+// transaction, they are locked and the transaction is accepted. Otherwise
+// nothing is locked and the transaction is rejected for good, as on a
+// timeout, so that a proof-of-rejection holds for any later lock attempt.
+// Locking the same transaction twice accepts it again. Locking a committed
+// transaction rejects it, its inputs being spent, but doesn't abort it.
+func (s *Shard) HandleLock(tx *Transaction) bool {
+	if err := tx.Verify(); err != nil {
+		log.Lvl2("Rejecting invalid transaction:", err)
+		return false
+	}
+	txID := fmt.Sprintf("%x", tx.Hash())
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.committed[txID] {
+		log.Lvl2("Shard", s.ID, "rejects committed", txID)
+		return false
+	}
+	if s.aborted[txID] {
+		log.Lvl2("Shard", s.ID, "rejects aborted", txID)
+		return false
+	}
+	var inputs []string
+	for _, in := range tx.Inputs {
+		if in.Shard != s.ID {
+			continue
+		}
+		value, ok := s.utxos[in.ID]
+		if !ok || value != in.Value {
+			return s.reject(txID, "unknown or spent input", in.ID)
+		}
+		if locker, ok := s.locked[in.ID]; ok && locker != txID {
+			return s.reject(txID, "input", in.ID, "locked by", locker)
+		}
+		inputs = append(inputs, in.ID)
+	}
+	if len(inputs) == 0 {
+		return s.reject(txID, "no input held by the shard")
+	}
+	for _, id := range inputs {
+		s.locked[id] = txID
+	}
+	if s.pending[txID] == nil {
+		s.pending[txID] = &pendingTx{tx: *tx, since: time.Now()}
+	}
+	return true
+}
+
+// HandleTimeout is the first phase on an input shard for a transaction
+// whose client timed out. The transaction is accepted if the shard already
+// committed it or holds all its inputs locked for it. Otherwise it is
+// rejected for good, so that the other input shards can safely unlock the
+// inputs they locked for it: the shard will never accept it anymore.
+func (s *Shard) HandleTimeout(tx *Transaction) bool {
+	if err := tx.Verify(); err != nil {
+		log.Lvl2("Rejecting invalid transaction:", err)
+		return false
+	}
+	txID := fmt.Sprintf("%x", tx.Hash())
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.committed[txID] {
+		return true
+	}
+	if !s.aborted[txID] && s.holdsLocks(tx, txID) {
+		return true
+	}
+	return s.reject(txID, "timed out")
+}
+
+// reject aborts the transaction for good, logging why, and returns false.
+// The caller must hold the mutex.
+func (s *Shard) reject(txID string, why ...interface{}) bool {
+	log.Lvl2(append([]interface{}{"Shard", s.ID, "rejects", txID, ":"}, why...)...)
+	s.aborted[txID] = true
+	return false
+}
+
+// holdsLocks returns whether the shard holds inputs of the transaction and
+// all of them are locked by it. The caller must hold the mutex.
+func (s *Shard) holdsLocks(tx *Transaction, txID string) bool {
+	held := false
+	for _, in := range tx.Inputs {
+		if in.Shard != s.ID {
+			continue
+		}
+		if s.locked[in.ID] != txID {
+			return false
+		}
+		held = true
+	}
+	return held
+}
+
+// HandleUnlock is the second phase: if the proofs show that all input shards
+// accepted the transaction, its inputs are spent and its outputs created.
+// If one input shard rejected it, it is aborted for good and the inputs
+// locked by it are unlocked, unless the shard committed it already, which
+// the rejection can't undo. It returns whether the request committed the
+// transaction. Handling the same request twice gives the same result.
+func (s *Shard) HandleUnlock(tx *Transaction, proofs []Proof) (bool, error) {
+	if err := tx.Verify(); err != nil {
+		return false, err
+	}
+	hash := tx.Hash()
+	accepted := make(map[int]bool)
+	rejected := false
+	for i := range proofs {
+		proof := &proofs[i]
+		if proof.Shard < 0 || proof.Shard >= len(s.Rosters) {
+			return false, fmt.Errorf("proof of unknown shard %d", proof.Shard)
+		}
+		if !proof.For(hash) {
+			return false, errors.New("proof of another transaction")
+		}
+		if err := s.verifyProof(proof); err != nil {
+			return false, fmt.Errorf("invalid proof of shard %d: %v",
+				proof.Shard, err)
+		}
+		if proof.Accept {
+			accepted[proof.Shard] = true
+		} else {
+			rejected = true
+		}
+	}
+	commit := true
+	for _, shard := range tx.InputShards() {
+		commit = commit && accepted[shard]
+	}
+	if !commit && !rejected {
+		return false, errors.New("missing proofs of the input shards")
+	}
+
+	txID := fmt.Sprintf("%x", hash)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !commit {
+		if s.committed[txID] {
+			log.Lvl2("Shard", s.ID, "keeps committed", txID)
+			return false, nil
+		}
+		s.aborted[txID] = true
+		for _, in := range tx.Inputs {
+			if in.Shard == s.ID && s.locked[in.ID] == txID {
+				delete(s.locked, in.ID)
+			}
+		}
+		s.release(txID)
+		log.Lvl3("Shard", s.ID, "aborted", txID)
+		return false, nil
+	}
+	if s.committed[txID] {
+		return true, nil
+	}
+	if s.aborted[txID] {
+		return false, errors.New("transaction has been aborted")
+	}
+	for _, in := range tx.Inputs {
+		if in.Shard == s.ID && s.locked[in.ID] != txID {
+			return false, fmt.Errorf("input %s is not locked by the transaction",
+				in.ID)
+		}
+	}
+	s.committed[txID] = true
+	s.release(txID)
+	for _, in := range tx.Inputs {
+		if in.Shard == s.ID {
+			delete(s.utxos, in.ID)
+			delete(s.locked, in.ID)
+		}
+	}
+	for _, out := range tx.Outputs {
+		if out.Shard == s.ID {
+			s.utxos[out.ID] = out.Value
+		}
+	}
+	if s.onCommit != nil {
+		s.onCommit(tx)
+	}
+	log.Lvl3("Shard", s.ID, "committed", txID)
+	return true, nil
+}
+
+// release forgets the pending transaction, if it is, and calls onRelease.
+// The state must be locked.
+func (s *Shard) release(txID string) {
+	p := s.pending[txID]
+	if p == nil {
+		return
+	}
+	delete(s.pending, txID)
+	if s.onRelease != nil {
+		s.onRelease(&p.tx, time.Since(p.since))
+	}
+}
+
+// verifyProof checks the proof with the key of its shard if it is signed
+// with it, else against the known headers, if any, or else with the roster
+// of its shard.
+func (s *Shard) verifyProof(proof *Proof) error {
+	if key := s.key(proof.Shard); key != nil && len(proof.ShardSig) > 0 {
+		return VerifyShardProof(key, proof)
+	}
+	if h := s.Headers(); h != nil {
+		return h.VerifyProof(proof)
+	}
+	return VerifyProof(s.Rosters[proof.Shard], proof)
+}
+
+// key returns the distributed key of the shard, or nil.
+func (s *Shard) key(shard int) abstract.Point {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if shard < 0 || shard >= len(s.keys) {
+		return nil
+	}
+	return s.keys[shard]
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/dedis/paper_17_sosp_omniledger/metrics"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/safety"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/workload"
+	"github.com/dedis/paper_17_sosp_omniledger/profile"
+	"github.com/dedis/paper_17_sosp_omniledger/seed"
+	"github.com/dedis/paper_17_sosp_omniledger/soak"
+	"github.com/dedis/paper_17_sosp_omniledger/suites"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+	"gopkg.in/dedis/onet.v1/simul/monitor"
+)
+
+func init() {
+	onet.SimulationRegister("OmniAtomix", NewAtomixSimulation)
+	onet.GlobalProtocolRegister(atomix.Name, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		s := atomixShards.get(n.Roster(), n.ServerIdentity())
+		if s == nil {
+			return nil, errors.New("not a member of the shard")
+		}
+		return atomix.NewProtocol(n, s)
+	})
+}
+
+// atomixShards holds the state of the shards of the servers of this process.
+var atomixShards = &shardStates{states: make(map[string]*atomix.Shard)}
+
+// shardStates maps a shard roster and one of its members to the state of
+// the member. Rosters are identified by their aggregate key, which is the
+// same on all servers.
+type shardStates struct {
+	sync.Mutex
+	states map[string]*atomix.Shard
+}
+
+func (s *shardStates) key(roster *onet.Roster, si *network.ServerIdentity) string {
+	return roster.Aggregate.String() + "/" + si.ID.String()
+}
+
+func (s *shardStates) set(roster *onet.Roster, si *network.ServerIdentity, state *atomix.Shard) {
+	s.Lock()
+	defer s.Unlock()
+	s.states[s.key(roster, si)] = state
+}
+
+func (s *shardStates) get(roster *onet.Roster, si *network.ServerIdentity) *atomix.Shard {
+	s.Lock()
+	defer s.Unlock()
+	return s.states[s.key(roster, si)]
+}
+
+// AtomixSimulation submits generated transactions to the shards with
+// Atomix. Every round, Txs transactions are submitted concurrently, of which
+// a fraction CrossShard spends the outputs of InputShards shards. The first
+// member of every shard measures how long the inputs stay locked as
+// "locked".
+type AtomixSimulation struct {
+	onet.SimulationBFTree
+	// Shards is the number of shards the hosts are split into
+	Shards int
+	// Txs is the number of transactions of a round
+	Txs int
+	// Outputs is the number of unspent outputs of every shard at the start
+	Outputs int
+	// CrossShard is the fraction of cross-shard transactions
+	CrossShard float64
+	// InputShards is the number of input shards of a cross-shard
+	// transaction
+	InputShards int
+	// FailRate is the fraction of the transactions whose client fails
+	// between the lock and the unlock phases, as FailMode: "crash" leaves
+	// them locked until they are reclaimed ReclaimMs later, at the end of
+	// their round, "stall" unlocks them after StallMs.
+	FailRate  float64
+	FailMode  string
+	StallMs   int
+	ReclaimMs int
+	// FaultyHosts is the number of members of every shard that lie in its
+	// proofs, see atomix.Shard.Lie. They are the last ones of the roster of
+	// their shard, so its root stays honest.
+	FaultyHosts int
+	// PhaseBandwidth records the bytes of the proofs exchanged in every
+	// round in the monitor, see metrics.EndRound.
+	PhaseBandwidth bool
+	// GlobalSeed seeds the random streams of the simulation, so that a run
+	// can be reproduced, see package seed. The streams are seeded from the
+	// clock if it is 0.
+	GlobalSeed int64
+	// Suite is the cipher suite of the keys and the signatures, "ed25519"
+	// if empty, see package suites.
+	Suite string
+	// Profile writes a CPU profile of every round and a heap profile after
+	// it to the profiles directory of the simulation, see package profile.
+	Profile bool
+	// Soak samples the memory and the goroutines every soak.Every rounds
+	// and fails the run if they grow without bound, see package soak. Rounds
+	// should then be in the thousands.
+	Soak bool
+	// Config rejects the shard counts that give unsafe shards
+	safety.Config
+}
+
+// NewAtomixSimulation is used internally to register the simulation (see
+// the init() function above).
+func NewAtomixSimulation(config string) (onet.Simulation, error) {
+	as := &AtomixSimulation{
+		Shards:      2,
+		Txs:         10,
+		Outputs:     1000,
+		CrossShard:  0.5,
+		InputShards: 2,
+	}
+	_, err := toml.Decode(config, as)
+	if err != nil {
+		return nil, err
+	}
+	return as, nil
+}
+
+// Setup implements onet.Simulation.
+func (a *AtomixSimulation) Setup(dir string, hosts []string) (
+	*onet.SimulationConfig, error) {
+	if err := suites.Setup(dir, a.Suite); err != nil {
+		return nil, err
+	}
+	sc := &onet.SimulationConfig{}
+	a.CreateRoster(sc, hosts, 2000)
+	seed.Use(a.GlobalSeed)
+	seed.Keys(sc)
+	if err := a.Check(len(sc.Roster.List), a.Shards); err != nil {
+		return nil, err
+	}
+	if a.FaultyHosts < 0 || a.FaultyHosts > (len(sc.Roster.List)-1)/a.Shards {
+		return nil, errors.New("FaultyHosts must leave an honest root in every shard")
+	}
+	err := a.CreateTree(sc)
+	if err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// Node implements onet.Simulation. Every server seeds the random streams and
+// starts the shards it is a member of with the outputs of the workload, lying
+// in their proofs if it is one of their faulty members, and accounts the
+// bytes of the proofs.
+func (a *AtomixSimulation) Node(config *onet.SimulationConfig) error {
+	seed.Use(a.GlobalSeed)
+	gen, err := a.workload()
+	if err != nil {
+		return err
+	}
+	rosters, err := a.rosters(config.Roster)
+	if err != nil {
+		return err
+	}
+	si := config.Server.ServerIdentity
+	for shard, roster := range rosters {
+		if i, _ := roster.Search(si.ID); i >= 0 {
+			state := atomix.NewShard(shard, rosters, gen.Genesis(shard))
+			if i == 0 {
+				state.RegisterOnRelease(func(tx *atomix.Transaction, locked time.Duration) {
+					monitor.RecordSingleMeasure("locked", locked.Seconds())
+				})
+			}
+			if i >= len(roster.List)-a.FaultyHosts {
+				log.Lvl2(si.Address, "lies in the proofs of shard", shard)
+				state.Lie()
+			}
+			atomixShards.set(roster, si, state)
+		}
+	}
+	metrics.AccountPhases(a.PhaseBandwidth)
+	return a.SimulationBFTree.Node(config)
+}
+
+// Run implements onet.Simulation.
+func (a *AtomixSimulation) Run(config *onet.SimulationConfig) error {
+	gen, err := a.workload()
+	if err != nil {
+		return err
+	}
+	rosters, err := a.rosters(config.Roster)
+	if err != nil {
+		return err
+	}
+	client := atomix.NewClient(rosters, func(name string, t *onet.Tree) (onet.ProtocolInstance, error) {
+		return config.Overlay.CreateProtocol(name, t, onet.NilServiceID)
+	})
+	faulty, err := a.faultyClient(client)
+	if err != nil {
+		return err
+	}
+	log.Lvl1("Running", a.Rounds, "rounds of", a.Txs, "transactions on",
+		a.Shards, "shards")
+	profile.Use(a.Profile)
+	defer profile.EndRound()
+	soak.Use(a.Soak)
+	for r := 0; r < a.Rounds; r++ {
+		txs, err := gen.Block(a.Txs)
+		if err != nil {
+			return err
+		}
+		cross := 0
+		for i := range txs {
+			if len(txs[i].InputShards()) > 1 {
+				cross++
+			}
+		}
+		start := time.Now()
+		metrics.StartRound(r)
+		profile.StartRound(config.Server.ServerIdentity, r)
+		round := monitor.NewTimeMeasure("round")
+		committed := make([]bool, len(txs))
+		errs := make([]error, len(txs))
+		var wg sync.WaitGroup
+		for i := range txs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				committed[i], errs[i] = faulty.Submit(&txs[i])
+			}(i)
+		}
+		wg.Wait()
+		round.Record()
+		metrics.EndRound(config.Server.ServerIdentity)
+		profile.EndRound()
+		crashed := 0
+		for i, err := range errs {
+			if err == atomix.ErrCrashed {
+				crashed++
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if !committed[i] {
+				return errors.New("generated transaction has been aborted")
+			}
+		}
+		monitor.RecordSingleMeasure("tps",
+			float64(len(txs)-crashed)/time.Since(start).Seconds())
+		monitor.RecordSingleMeasure("cross_shard", float64(cross))
+		log.Lvl2("Round", r, "committed", len(txs)-crashed, "transactions,",
+			cross, "cross-shard")
+		if crashed > 0 {
+			monitor.RecordSingleMeasure("crashed", float64(crashed))
+			if err := a.reclaim(client, faulty); err != nil {
+				return err
+			}
+		}
+		soak.EndRound(r)
+	}
+	return soak.Check()
+}
+
+// faultyClient returns the client failing as configured, which doesn't
+// fail if FailRate is 0.
+func (a *AtomixSimulation) faultyClient(client *atomix.Client) (*atomix.FaultyClient, error) {
+	var mode atomix.FailureMode
+	switch a.FailMode {
+	case "", "crash":
+		mode = atomix.Crash
+	case "stall":
+		mode = atomix.Stall
+	default:
+		return nil, errors.New("unknown failure mode " + a.FailMode)
+	}
+	faulty := atomix.NewFaultyClient(client, a.FailRate, mode,
+		seed.Int64("atomix_failures"))
+	faulty.StallTime = time.Duration(a.StallMs) * time.Millisecond
+	return faulty, nil
+}
+
+// reclaim waits until the transactions left locked by the crashes of the
+// round have been so for ReclaimMs and completes them, as any member of
+// their shards could. They must commit, since all their input shards
+// accepted them, and the next rounds may spend their outputs.
+func (a *AtomixSimulation) reclaim(client *atomix.Client, faulty *atomix.FaultyClient) error {
+	time.Sleep(time.Duration(a.ReclaimMs) * time.Millisecond)
+	txs := faulty.Crashed(0)
+	reclaim := monitor.NewTimeMeasure("reclaim")
+	errs := make([]error, len(txs))
+	var wg sync.WaitGroup
+	for i := range txs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			committed, err := client.Reclaim(&txs[i])
+			if err == nil && !committed {
+				err = errors.New("reclaimed transaction has been aborted")
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+	reclaim.Record()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	log.Lvl2("Reclaimed", len(txs), "transactions")
+	return nil
+}
+
+// workload returns the generator of the transactions, the same on all
+// servers.
+func (a *AtomixSimulation) workload() (*workload.Generator, error) {
+	return workload.New(workload.Config{
+		Shards:      a.Shards,
+		Outputs:     a.Outputs,
+		CrossShard:  a.CrossShard,
+		InputShards: a.InputShards,
+		TxOutputs:   1,
+		Seed:        a.GlobalSeed,
+	})
+}
+
+// rosters splits the hosts into the shards.
+func (a *AtomixSimulation) rosters(roster *onet.Roster) ([]*onet.Roster, error) {
+	return shardRosters(roster, a.Shards)
+}
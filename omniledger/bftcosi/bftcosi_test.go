@@ -241,6 +241,10 @@ func runProtocolOnceGo(nbrHosts int, name string, refuseCount int,
 			return fmt.Errorf("%s: Shouldn't have succeeded for %d hosts, but signed for count: %d",
 				root.Name(), nbrHosts, refuseCount)
 		}
+		if !succeed && sig.Refused(nbrHosts) == 0 {
+			return fmt.Errorf("%s: the mask of the signature holds no refusal",
+				root.Name())
+		}
 	case <-time.After(wait):
 		log.Lvl1("Going to break because of timeout")
 		return errors.New("Waited " + wait.String() + " for BFTCoSi to finish ...")
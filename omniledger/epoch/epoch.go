@@ -0,0 +1,231 @@
+// Package epoch assigns the validators to the shards and changes the
+// assignment at the end of every epoch. To keep the shards live while they
+// are reconfigured, only a bounded fraction of every shard is swapped per
+// epoch, as in OmniLedger.
+package epoch
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// Assignment is the membership of the shards during one epoch.
+type Assignment struct {
+	Epoch int
+	// Shards holds the roster indexes of the members of every shard
+	Shards [][]int
+}
+
+// ShardOf returns the shard of the validator with the roster index, or -1.
+func (a *Assignment) ShardOf(index int) int {
+	for s, members := range a.Shards {
+		for _, m := range members {
+			if m == index {
+				return s
+			}
+		}
+	}
+	return -1
+}
+
+// Hash returns a hash of the assignment, e.g. for the shards to sign it.
+func (a *Assignment) Hash() []byte {
+	h := sha256.New()
+	binary.Write(h, binary.LittleEndian, int64(a.Epoch))
+	for _, members := range a.Shards {
+		binary.Write(h, binary.LittleEndian, int64(len(members)))
+		for _, m := range members {
+			binary.Write(h, binary.LittleEndian, int64(m))
+		}
+	}
+	return h.Sum(nil)
+}
+
+// Churn returns, for every shard, how many members of next were not in the
+// shard in prev. Both assignments must be of the same roster.
+func Churn(prev, next *Assignment) []int {
+	churn := make([]int, len(next.Shards))
+	for s, members := range next.Shards {
+		old := make(map[int]bool)
+		if s < len(prev.Shards) {
+			for _, m := range prev.Shards[s] {
+				old[m] = true
+			}
+		}
+		for _, m := range members {
+			if !old[m] {
+				churn[s]++
+			}
+		}
+	}
+	return churn
+}
+
+// Manager keeps the assignment of the validators of the roster to the shards
+// and changes it at every epoch boundary.
+type Manager struct {
+	roster *onet.Roster
+	// Length is the number of blocks of an epoch
+	Length int
+	// MaxChurn is the fraction of every shard that can be swapped per epoch
+	MaxChurn float64
+	current  *Assignment
+}
+
+// NewManager returns a manager splitting the roster into the given number
+// of shards, using seed for the first assignment. Every epoch lasts length
+// blocks.
+func NewManager(roster *onet.Roster, shards, length int, maxChurn float64,
+	seed []byte) (*Manager, error) {
+	n := len(roster.List)
+	if shards <= 0 || shards > n {
+		return nil, errors.New("need between one shard and one shard per validator")
+	}
+	if length <= 0 {
+		return nil, errors.New("epochs need at least one block")
+	}
+	if maxChurn < 0 || maxChurn > 1 {
+		return nil, errors.New("churn must be a fraction")
+	}
+	a := &Assignment{Shards: make([][]int, shards)}
+	for i, index := range newRand(seed).Perm(n) {
+		a.Shards[i%shards] = append(a.Shards[i%shards], index)
+	}
+	return &Manager{
+		roster:   roster,
+		Length:   length,
+		MaxChurn: maxChurn,
+		current:  a,
+	}, nil
+}
+
+// Current returns the assignment of the current epoch.
+func (m *Manager) Current() *Assignment {
+	return m.current
+}
+
+// IsBoundary returns true if the block is the first block of a new epoch.
+func (m *Manager) IsBoundary(block int) bool {
+	return block > 0 && block%m.Length == 0
+}
+
+// EpochOf returns the epoch the block belongs to.
+func (m *Manager) EpochOf(block int) int {
+	return block / m.Length
+}
+
+// Roster returns the roster of the shard in the current epoch.
+func (m *Manager) Roster(shard int) *onet.Roster {
+	var list []*network.ServerIdentity
+	for _, index := range m.current.Shards[shard] {
+		list = append(list, m.roster.List[index])
+	}
+	return onet.NewRoster(list)
+}
+
+// Next moves to the next epoch. Instead of re-assigning all validators from
+// the fresh randomness, it takes at most MaxChurn of the members out of
+// every shard and distributes them randomly over the freed places, so that
+// every shard keeps a majority of its members. It returns the new
+// assignment.
+func (m *Manager) Next(randomness []byte) *Assignment {
+	return m.NextRoster(randomness, m.roster)
+}
+
+// NextRoster moves to the next epoch like Next, with the validators of the
+// roster, e.g. the latest roster of the identity chain. Validators are
+// matched by address, so that they keep their shard across a key rotation.
+// The places of the validators that left are freed in addition to the
+// swapped ones, and the validators that joined are distributed with the
+// swapped ones. The places of the swapped validators are filled first, so
+// that without enough validators joining, the places of the ones that left
+// stay free. Any validator left over goes to the smallest shards.
+func (m *Manager) NextRoster(randomness []byte, roster *onet.Roster) *Assignment {
+	indexes := make(map[network.Address]int)
+	for i, si := range roster.List {
+		indexes[si.Address] = i
+	}
+	r := newRand(randomness)
+	next := &Assignment{
+		Epoch:  m.current.Epoch + 1,
+		Shards: make([][]int, len(m.current.Shards)),
+	}
+	type slot struct{ shard, pos int }
+	var pool []int
+	var slots, freed []slot
+	assigned := make(map[int]bool)
+	for s, old := range m.current.Shards {
+		var members []int
+		left := 0
+		for _, index := range old {
+			if i, ok := indexes[m.roster.List[index].Address]; ok {
+				members = append(members, i)
+				assigned[i] = true
+			} else {
+				left++
+			}
+		}
+		next.Shards[s] = members
+		swap := int(m.MaxChurn*float64(len(old))) - left
+		if swap < 0 {
+			swap = 0
+		}
+		for _, pos := range r.Perm(len(members))[:swap] {
+			pool = append(pool, members[pos])
+			slots = append(slots, slot{s, pos})
+		}
+		for i := 0; i < left; i++ {
+			freed = append(freed, slot{s, -1})
+		}
+	}
+	slots = append(slots, freed...)
+	for i := range roster.List {
+		if !assigned[i] {
+			pool = append(pool, i)
+		}
+	}
+	for i, p := range r.Perm(len(pool)) {
+		if i >= len(slots) {
+			s := smallest(next.Shards)
+			next.Shards[s] = append(next.Shards[s], pool[p])
+		} else if slots[i].pos < 0 {
+			next.Shards[slots[i].shard] = append(next.Shards[slots[i].shard],
+				pool[p])
+		} else {
+			next.Shards[slots[i].shard][slots[i].pos] = pool[p]
+		}
+	}
+	if roster == m.roster {
+		log.Lvl2("Epoch", next.Epoch, "swaps", len(pool), "validators, churn",
+			Churn(m.current, next))
+	} else {
+		log.Lvl2("Epoch", next.Epoch, "swaps", len(pool),
+			"validators on a new roster of", len(roster.List))
+	}
+	m.roster = roster
+	m.current = next
+	return next
+}
+
+// smallest returns the shard with the fewest members.
+func smallest(shards [][]int) int {
+	min := 0
+	for s := range shards {
+		if len(shards[s]) < len(shards[min]) {
+			min = s
+		}
+	}
+	return min
+}
+
+// newRand returns a random source derived from the randomness.
+func newRand(randomness []byte) *rand.Rand {
+	h := sha256.Sum256(randomness)
+	return rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(h[:8]))))
+}
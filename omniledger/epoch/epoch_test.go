@@ -0,0 +1,127 @@
+package epoch
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+// checkAssignment makes sure every validator is in exactly one shard.
+func checkAssignment(t *testing.T, a *Assignment, n int) {
+	seen := make(map[int]bool)
+	for _, members := range a.Shards {
+		for _, m := range members {
+			require.False(t, seen[m], "validator in two shards")
+			seen[m] = true
+		}
+	}
+	require.Equal(t, n, len(seen))
+}
+
+func TestBoundedChurn(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(30, false)
+
+	m, err := NewManager(roster, 3, 10, 1./3, []byte("seed"))
+	require.Nil(t, err)
+	checkAssignment(t, m.Current(), 30)
+	first := m.Current()
+	moved := false
+	for epoch := 1; epoch <= 10; epoch++ {
+		prev := m.Current()
+		next := m.Next([]byte(fmt.Sprintf("randomness %d", epoch)))
+		assert.Equal(t, epoch, next.Epoch)
+		checkAssignment(t, next, 30)
+		for s, churn := range Churn(prev, next) {
+			assert.Equal(t, len(prev.Shards[s]), len(next.Shards[s]))
+			assert.True(t, churn <= len(prev.Shards[s])/3)
+		}
+		for _, churn := range Churn(first, next) {
+			moved = moved || churn > 0
+		}
+	}
+	assert.True(t, moved, "nobody changed shards in 10 epochs")
+	assert.Equal(t, 10, len(m.Roster(0).List))
+}
+
+func TestDeterministic(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(12, false)
+
+	m1, err := NewManager(roster, 4, 5, 0.5, []byte("seed"))
+	require.Nil(t, err)
+	m2, err := NewManager(roster, 4, 5, 0.5, []byte("seed"))
+	require.Nil(t, err)
+	assert.Equal(t, m1.Current().Hash(), m2.Current().Hash())
+	assert.Equal(t, m1.Next([]byte("r")).Hash(), m2.Next([]byte("r")).Hash())
+
+	assert.False(t, m1.IsBoundary(0))
+	assert.False(t, m1.IsBoundary(4))
+	assert.True(t, m1.IsBoundary(5))
+	assert.Equal(t, 2, m1.EpochOf(10))
+
+	_, err = NewManager(roster, 13, 5, 0.5, nil)
+	assert.NotNil(t, err)
+	_, err = NewManager(roster, 4, 0, 0.5, nil)
+	assert.NotNil(t, err)
+	_, err = NewManager(roster, 4, 5, 1.5, nil)
+	assert.NotNil(t, err)
+}
+
+func TestNextRoster(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, all, _ := local.GenTree(14, false)
+	roster := onet.NewRoster(all.List[:12])
+
+	m, err := NewManager(roster, 3, 10, 0, []byte("seed"))
+	require.Nil(t, err)
+	first := m.Current()
+	// 0 and 1 leave, 12 and 13 join, 2 rotates its key
+	rotated := network.NewServerIdentity(config.NewKeyPair(network.Suite).Public,
+		roster.List[2].Address)
+	list := []*network.ServerIdentity{rotated}
+	list = append(list, roster.List[3:]...)
+	list = append(list, all.List[12:]...)
+	next := m.NextRoster([]byte("randomness"), onet.NewRoster(list))
+	checkAssignment(t, next, 12)
+
+	// nobody is swapped, the joining validators take the freed places
+	for s := range first.Shards {
+		assert.Equal(t, len(first.Shards[s]), len(next.Shards[s]))
+		for _, index := range first.Shards[s] {
+			if index >= 2 {
+				assert.Equal(t, s, next.ShardOf(index-2))
+			}
+		}
+	}
+	assert.Equal(t, first.ShardOf(2), next.ShardOf(0))
+	assert.NotEqual(t, -1, next.ShardOf(10))
+	assert.NotEqual(t, -1, next.ShardOf(11))
+
+	// a joining validator without a free place goes to the smallest shard
+	m, err = NewManager(roster, 3, 10, 0, []byte("seed"))
+	require.Nil(t, err)
+	next = m.NextRoster([]byte("randomness"), onet.NewRoster(all.List[:13]))
+	checkAssignment(t, next, 13)
+	assert.Equal(t, 5, len(next.Shards[next.ShardOf(12)]))
+
+	// without anybody joining, the swapped validators don't stay in their
+	// shard in addition to their new one
+	m, err = NewManager(roster, 3, 10, 0.5, []byte("seed"))
+	require.Nil(t, err)
+	next = m.NextRoster([]byte("randomness"), onet.NewRoster(roster.List[1:]))
+	checkAssignment(t, next, 11)
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/BurntSushi/toml"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/bftcosi"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/epoch"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/identity"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/randhound"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/safety"
+	"github.com/dedis/paper_17_sosp_omniledger/seed"
+	"github.com/dedis/paper_17_sosp_omniledger/suites"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+	"gopkg.in/dedis/onet.v1/simul/monitor"
+)
+
+// shardCoSi is the protocol the shards use to sign their blocks in the
+// epoch simulation.
+const shardCoSi = "OmniShardCoSi"
+
+func init() {
+	onet.SimulationRegister("OmniEpoch", NewEpochSimulation)
+	onet.GlobalProtocolRegister(shardCoSi, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return bftcosi.NewBFTCoSiProtocol(n, func(msg, data []byte) bool {
+			return true
+		})
+	})
+}
+
+// EpochSimulation runs the shards over several epochs: every round, all
+// shards sign a block, and at every epoch boundary the validators are
+// re-assigned with bounded churn. The membership is kept in an identity
+// chain: at every epoch boundary, hosts join from the standby hosts and
+// members leave to them, see JoinRate and LeaveRate. The shards sign a state
+// block at the end of every epoch, and the validators new to a shard catch
+// up with its state from the members of the previous epoch.
+type EpochSimulation struct {
+	onet.SimulationBFTree
+	// Shards is the number of shards the hosts are split into
+	Shards int
+	// EpochLength is the number of blocks of an epoch
+	EpochLength int
+	// MaxChurn is the fraction of a shard swapped at every epoch
+	MaxChurn float64
+	// Standby is the number of hosts that are not members at the start
+	Standby int
+	// JoinRate and LeaveRate are the mean numbers of hosts joining from the
+	// standby hosts and members leaving to them at every epoch boundary,
+	// drawn from Poisson distributions. The root never leaves, and fewer
+	// members leave if a shard would keep less than two thirds of its
+	// members, or too few would be left for safe shards. If both are
+	// 0, one standby host joins and the oldest member leaves at every epoch
+	// boundary, as long as there are standby hosts.
+	JoinRate  float64
+	LeaveRate float64
+	// GlobalSeed seeds the random streams of the simulation, so that a run
+	// can be reproduced, see package seed. The streams are seeded from the
+	// clock if it is 0.
+	GlobalSeed int64
+	// Suite is the cipher suite of the keys and the signatures, "ed25519"
+	// if empty, see package suites.
+	Suite string
+	// Config rejects the shard counts that give unsafe shards
+	safety.Config
+}
+
+// NewEpochSimulation is used internally to register the simulation (see the
+// init() function above).
+func NewEpochSimulation(config string) (onet.Simulation, error) {
+	es := &EpochSimulation{
+		Shards:      1,
+		EpochLength: 10,
+		MaxChurn:    1. / 3,
+	}
+	_, err := toml.Decode(config, es)
+	if err != nil {
+		return nil, err
+	}
+	return es, nil
+}
+
+// Setup implements onet.Simulation.
+func (e *EpochSimulation) Setup(dir string, hosts []string) (
+	*onet.SimulationConfig, error) {
+	if err := suites.Setup(dir, e.Suite); err != nil {
+		return nil, err
+	}
+	sc := &onet.SimulationConfig{}
+	e.CreateRoster(sc, hosts, 2000)
+	seed.Use(e.GlobalSeed)
+	seed.Keys(sc)
+	if err := e.Check(len(sc.Roster.List)-e.Standby, e.Shards); err != nil {
+		return nil, err
+	}
+	err := e.CreateTree(sc)
+	if err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// Node implements onet.Simulation. It seeds the random streams and remembers
+// the overlay of every host of this process for the catch-up of the new
+// members.
+func (e *EpochSimulation) Node(config *onet.SimulationConfig) error {
+	seed.Use(e.GlobalSeed)
+	epochHosts.addOverlay(config)
+	return e.SimulationBFTree.Node(config)
+}
+
+// Run implements onet.Simulation. The rounds are the blocks, so the
+// simulation runs over Rounds/EpochLength epochs.
+func (e *EpochSimulation) Run(config *onet.SimulationConfig) error {
+	n := len(config.Roster.List) - e.Standby
+	if e.Standby < 0 || n < e.Shards {
+		return errors.New("not enough members for the shards")
+	}
+	if e.JoinRate < 0 || e.LeaveRate < 0 {
+		return errors.New("negative churn rate")
+	}
+	genesis := identity.NewGenesis(onet.NewRoster(config.Roster.List[:n]))
+	sig, err := e.sign(config, genesis.Roster(), genesis.Hash())
+	if err != nil {
+		return err
+	}
+	genesis.Signature = sig
+	members, err := identity.NewChain(genesis)
+	if err != nil {
+		return err
+	}
+	manager, err := epoch.NewManager(members.Roster(), e.Shards, e.EpochLength,
+		e.MaxChurn, config.Roster.ID[:])
+	if err != nil {
+		return err
+	}
+	log.Lvl1("Running", e.Rounds, "blocks on", e.Shards, "shards with epochs of",
+		e.EpochLength, "blocks")
+	// the state of the shards is only kept if the new members can catch up
+	catchUp := epochHosts.local(config.Roster)
+	if catchUp {
+		epochHosts.start(config, manager)
+	} else {
+		log.Lvl1("Not all hosts run with the root, the new members won't catch up")
+	}
+	standby := append([]*network.ServerIdentity{}, config.Roster.List[n:]...)
+	rng := seed.Rand("churn")
+	for block := 0; block < e.Rounds; block++ {
+		if manager.IsBoundary(block) {
+			change := monitor.NewTimeMeasure("epoch_change")
+			if catchUp {
+				err := epochHosts.closeEpoch(manager.EpochOf(block) - 1)
+				if err != nil {
+					return err
+				}
+			}
+			randomness, err := e.randomness(config, manager.EpochOf(block))
+			if err != nil {
+				return err
+			}
+			standby, err = e.churn(config, manager, members, standby, rng)
+			if err != nil {
+				return err
+			}
+			prev := manager.Current()
+			next := manager.NextRoster(randomness, members.Roster())
+			churn := 0
+			for _, c := range epoch.Churn(prev, next) {
+				churn += c
+			}
+			monitor.RecordSingleMeasure("churn", float64(churn))
+			if catchUp {
+				caughtUp, err := epochHosts.catchUp(manager, members,
+					genesis.Hash())
+				if err != nil {
+					return err
+				}
+				monitor.RecordSingleMeasure("caught_up", float64(caughtUp))
+			}
+			// the new shards sign their assignment before going on
+			if err := e.signShards(config, manager, next.Hash()); err != nil {
+				return err
+			}
+			change.Record()
+		}
+		round := monitor.NewTimeMeasure("round")
+		msg := []byte(fmt.Sprintf("block %d", block))
+		if err := e.signShards(config, manager, msg); err != nil {
+			return err
+		}
+		round.Record()
+		if catchUp {
+			for s := 0; s < e.Shards; s++ {
+				epochHosts.addOutput(s, block)
+			}
+		}
+		log.Lvl2("Block", block, "of epoch", manager.EpochOf(block), "signed")
+	}
+	return nil
+}
+
+// churn draws the hosts joining from the standby hosts and the members
+// leaving the shards of the manager at an epoch boundary, records the change
+// in the identity chain, and returns the standby hosts after it.
+func (e *EpochSimulation) churn(config *onet.SimulationConfig,
+	manager *epoch.Manager, members *identity.Chain,
+	standby []*network.ServerIdentity, rng *rand.Rand) ([]*network.ServerIdentity, error) {
+	root := config.Server.ServerIdentity
+	latest := members.Latest().Members
+	var joining, leaving []*network.ServerIdentity
+	if e.JoinRate == 0 && e.LeaveRate == 0 {
+		if len(standby) == 0 {
+			return standby, nil
+		}
+		for _, si := range latest {
+			if !si.ID.Equal(root.ID) {
+				leaving = []*network.ServerIdentity{si}
+				break
+			}
+		}
+		if leaving == nil {
+			return nil, errors.New("no member can leave")
+		}
+		if err := e.changeMembers(config, members, standby[:1], leaving); err != nil {
+			return nil, err
+		}
+		return standby[1:], nil
+	}
+
+	joins := poisson(rng, e.JoinRate)
+	if joins > len(standby) {
+		joins = len(standby)
+	}
+	joining = standby[:joins]
+	// every shard keeps at least two thirds of its members
+	shardOf := make(map[network.ServerIdentityID]int)
+	budget := make([]int, e.Shards)
+	for s := range budget {
+		shard := manager.Roster(s).List
+		for _, si := range shard {
+			shardOf[si.ID] = s
+		}
+		budget[s] = len(shard) - (2*len(shard)+2)/3
+	}
+	draw := poisson(rng, e.LeaveRate)
+	left := len(latest) + joins
+	for _, i := range rng.Perm(len(latest)) {
+		si := latest[i]
+		s, ok := shardOf[si.ID]
+		if len(leaving) == draw || left-len(leaving)-1 < e.Shards ||
+			e.Check(left-len(leaving)-1, e.Shards) != nil {
+			break
+		}
+		if si.ID.Equal(root.ID) || !ok || budget[s] == 0 {
+			continue
+		}
+		budget[s]--
+		leaving = append(leaving, si)
+	}
+	leaves := len(leaving)
+	monitor.RecordSingleMeasure("joined", float64(joins))
+	monitor.RecordSingleMeasure("left", float64(leaves))
+	monitor.RecordSingleMeasure("members", float64(left-leaves))
+	if joins == 0 && leaves == 0 {
+		return standby, nil
+	}
+	if err := e.changeMembers(config, members, joining, leaving); err != nil {
+		return nil, err
+	}
+	// the hosts that left may join again later
+	return append(append([]*network.ServerIdentity{}, standby[joins:]...),
+		leaving...), nil
+}
+
+// changeMembers records in the identity chain that the hosts join and leave.
+// The block is signed by the members before the change.
+func (e *EpochSimulation) changeMembers(config *onet.SimulationConfig,
+	members *identity.Chain, joining, leaving []*network.ServerIdentity) error {
+	rec := monitor.NewTimeMeasure("identity")
+	defer rec.Record()
+	latest := members.Latest()
+	var changes []identity.Change
+	for _, si := range joining {
+		changes = append(changes, identity.Change{Type: identity.Join, Member: si})
+	}
+	for _, si := range leaving {
+		changes = append(changes, identity.Change{Type: identity.Leave, Member: si})
+	}
+	b, err := identity.NewBlock(latest, changes)
+	if err != nil {
+		return err
+	}
+	sig, err := e.sign(config, latest.Roster(), b.Hash())
+	if err != nil {
+		return err
+	}
+	b.Signature = sig
+	log.Lvl2("Identity block", b.Index, ":", len(joining), "join,",
+		len(leaving), "leave")
+	return members.Append(b)
+}
+
+// poisson draws from a Poisson distribution of the mean.
+func poisson(rng *rand.Rand, mean float64) int {
+	limit := math.Exp(-mean)
+	k := 0
+	for p := rng.Float64(); p > limit; p *= rng.Float64() {
+		k++
+	}
+	return k
+}
+
+// randomness elects the leader of RandHound for the epoch and runs it on the
+// whole roster, then checks the election and the transcript before the value
+// is used.
+func (e *EpochSimulation) randomness(config *onet.SimulationConfig,
+	ep int) ([]byte, error) {
+	rh := monitor.NewTimeMeasure("randhound")
+	defer rh.Record()
+	root := config.Server.ServerIdentity
+	tree := config.Roster.GenerateNaryTreeWithRoot(len(config.Roster.List)-1,
+		root)
+	pi, err := config.Overlay.CreateProtocol(randhound.ElectionName, tree,
+		onet.NilServiceID)
+	if err != nil {
+		return nil, err
+	}
+	election := pi.(*randhound.Election)
+	election.Session = []byte(fmt.Sprintf("epoch %d", ep))
+	type result struct {
+		result *randhound.Result
+		err    error
+	}
+	done := make(chan result, 1)
+	election.RegisterOnDone(func(r *randhound.Result, err error) {
+		done <- result{r, err}
+	})
+	if err := election.Start(); err != nil {
+		return nil, err
+	}
+	res := <-done
+	if res.err != nil {
+		return nil, res.err
+	}
+	log.Lvl2("Epoch", ep, "randomness led by node", res.result.Leader)
+	return randhound.VerifyResult(config.Roster, election.Session, res.result)
+}
+
+// signShards lets all shards of the current epoch sign msg in parallel.
+func (e *EpochSimulation) signShards(config *onet.SimulationConfig,
+	manager *epoch.Manager, msg []byte) error {
+	return runShards(e.Shards, func(shard int) error {
+		_, err := e.sign(config, manager.Roster(shard), msg)
+		return err
+	})
+}
+
+// sign runs a BFTCoSi round on the roster and returns the signature.
+func (e *EpochSimulation) sign(config *onet.SimulationConfig,
+	roster *onet.Roster, msg []byte) (*bftcosi.BFTSignature, error) {
+	return cosign(config, e.BF, roster, msg, nil)
+}
+
+// cosign runs a BFTCoSi round on the roster with a tree of branching factor
+// bf, passing data along msg, and returns the signature. The simulation runs
+// on the root of the whole roster, so it leads the round of every shard.
+func cosign(config *onet.SimulationConfig, bf int, roster *onet.Roster,
+	msg, data []byte) (*bftcosi.BFTSignature, error) {
+	root := config.Server.ServerIdentity
+	list := []*network.ServerIdentity{root}
+	for _, si := range roster.List {
+		if !si.ID.Equal(root.ID) {
+			list = append(list, si)
+		}
+	}
+	tree := onet.NewRoster(list).GenerateNaryTreeWithRoot(bf, root)
+	pi, err := config.Overlay.CreateProtocol(shardCoSi, tree, onet.NilServiceID)
+	if err != nil {
+		return nil, err
+	}
+	bft := pi.(*bftcosi.ProtocolBFTCoSi)
+	bft.Msg = msg
+	if data != nil {
+		bft.Data = data
+	}
+	done := make(chan *bftcosi.BFTSignature, 1)
+	bft.RegisterOnSignatureDone(func(sig *bftcosi.BFTSignature) {
+		done <- sig
+	})
+	if err := bft.Start(); err != nil {
+		return nil, err
+	}
+	sig := <-done
+	if err := sig.Verify(network.Suite, tree.Roster.Publics()); err != nil {
+		return nil, errors.New("invalid signature: " + err.Error())
+	}
+	return sig, nil
+}
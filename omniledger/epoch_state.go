@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/epoch"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/identity"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/state"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+	"gopkg.in/dedis/onet.v1/simul/monitor"
+)
+
+func init() {
+	onet.GlobalProtocolRegister(state.BootstrapName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return state.NewBootstrap(n, epochHosts.ledger(n.ServerIdentity().ID,
+			n.IsRoot()), epochHosts.roster)
+	})
+}
+
+// epochHosts are the hosts of the epoch simulation running in this process:
+// their overlays, given by Node, and the ledgers of the state of their
+// shards. The root keeps the ledgers of all hosts, so the new members only
+// catch up when all hosts share its process, as in a localhost simulation.
+var epochHosts = &shardLedgers{
+	overlays: make(map[network.ServerIdentityID]*onet.Overlay),
+}
+
+// shardLedgers keeps the ledger of every member and the rosters of the
+// shards in every epoch.
+type shardLedgers struct {
+	sync.Mutex
+	overlays map[network.ServerIdentityID]*onet.Overlay
+	ledgers  map[network.ServerIdentityID]*state.Ledger
+	// pending are the empty ledgers of the validators catching up with a
+	// new shard, which keep serving their old shard until all caught up
+	pending map[network.ServerIdentityID]*state.Ledger
+	// rosters are the rosters of the shards by epoch
+	rosters [][]*onet.Roster
+	// outputs are the outputs of every shard, one per signed block
+	outputs []map[string]int64
+	// keys are the private keys of the hosts by their public key, as the
+	// addresses of the simulation config don't match the ones of the
+	// roster on localhost
+	keys map[string]abstract.Scalar
+}
+
+// addOverlay remembers the overlay of a host of this process.
+func (l *shardLedgers) addOverlay(config *onet.SimulationConfig) {
+	l.Lock()
+	defer l.Unlock()
+	l.overlays[config.Server.ServerIdentity.ID] = config.Overlay
+}
+
+// local returns whether all hosts of the roster run in this process.
+func (l *shardLedgers) local(roster *onet.Roster) bool {
+	l.Lock()
+	defer l.Unlock()
+	for _, si := range roster.List {
+		if l.overlays[si.ID] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// start gives an empty ledger of its shard to every validator of the first
+// assignment.
+func (l *shardLedgers) start(config *onet.SimulationConfig, manager *epoch.Manager) {
+	l.Lock()
+	defer l.Unlock()
+	l.ledgers = make(map[network.ServerIdentityID]*state.Ledger)
+	l.rosters = nil
+	l.outputs = nil
+	l.keys = make(map[string]abstract.Scalar)
+	for _, private := range config.PrivateKeys {
+		public := network.Suite.Point().Mul(nil, private)
+		l.keys[public.String()] = private
+	}
+	for s := range manager.Current().Shards {
+		for _, si := range manager.Roster(s).List {
+			l.ledgers[si.ID] = state.NewLedger(s, 0)
+		}
+		l.outputs = append(l.outputs, make(map[string]int64))
+	}
+	l.addRosters(manager)
+}
+
+// addRosters remembers the rosters of the shards of the current epoch. The
+// caller must hold the lock.
+func (l *shardLedgers) addRosters(manager *epoch.Manager) {
+	var rosters []*onet.Roster
+	for s := range manager.Current().Shards {
+		rosters = append(rosters, manager.Roster(s))
+	}
+	l.rosters = append(l.rosters, rosters)
+}
+
+// ledger returns the ledger of the host, or on the root of a Bootstrap the
+// empty ledger it catches up with.
+func (l *shardLedgers) ledger(id network.ServerIdentityID, root bool) *state.Ledger {
+	l.Lock()
+	defer l.Unlock()
+	if root {
+		return l.pending[id]
+	}
+	return l.ledgers[id]
+}
+
+// roster returns the roster of the shard in the epoch, see state.RosterFunc.
+func (l *shardLedgers) roster(shard, ep int) (*onet.Roster, error) {
+	l.Lock()
+	defer l.Unlock()
+	if ep < 0 || ep >= len(l.rosters) || shard < 0 || shard >= len(l.rosters[ep]) {
+		return nil, fmt.Errorf("unknown shard %d in epoch %d", shard, ep)
+	}
+	return l.rosters[ep][shard], nil
+}
+
+// addOutput adds the output of the block signed by the shard.
+func (l *shardLedgers) addOutput(shard, block int) {
+	l.Lock()
+	defer l.Unlock()
+	l.outputs[shard][fmt.Sprintf("block %d", block)] = 1
+}
+
+// closeEpoch signs the state block of every shard at the end of the epoch
+// with the keys of its members, which append it to their ledgers.
+func (l *shardLedgers) closeEpoch(ep int) error {
+	l.Lock()
+	defer l.Unlock()
+	for s, roster := range l.rosters[ep] {
+		var prev *state.Block
+		if ledger := l.ledgers[roster.List[0].ID]; ledger != nil {
+			prev = ledger.Chain.Latest()
+		}
+		b, err := state.NewBlock(s, ep, prev, l.outputs[s])
+		if err != nil {
+			return err
+		}
+		for i, si := range roster.List {
+			private, ok := l.keys[si.Public.String()]
+			if !ok {
+				return errors.New("no private key of " + si.Address.String())
+			}
+			if err := b.Sign(network.Suite, i, private); err != nil {
+				return err
+			}
+		}
+		for _, si := range roster.List {
+			if ledger := l.ledgers[si.ID]; ledger != nil {
+				if err := ledger.AppendState(b, roster); err != nil {
+					return fmt.Errorf("shard %d: %v", s, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// catchUp remembers the rosters of the new assignment, and lets every
+// validator new to its shard catch up with the members of the shard in the
+// previous epoch, checking the chain of its state blocks. The validators
+// that joined first audit the identity chain. It returns how many
+// validators caught up.
+func (l *shardLedgers) catchUp(manager *epoch.Manager,
+	members *identity.Chain, genesis []byte) (int, error) {
+	l.Lock()
+	l.addRosters(manager)
+	ep := len(l.rosters) - 1
+	type newcomer struct {
+		si    *network.ServerIdentity
+		shard int
+	}
+	var newcomers []newcomer
+	l.pending = make(map[network.ServerIdentityID]*state.Ledger)
+	for s, roster := range l.rosters[ep] {
+		for _, si := range roster.List {
+			if ledger := l.ledgers[si.ID]; ledger == nil || ledger.Chain.Shard != s {
+				newcomers = append(newcomers, newcomer{si, s})
+				l.pending[si.ID] = state.NewLedger(s, 0)
+			}
+		}
+	}
+	l.Unlock()
+	if len(newcomers) == 0 {
+		return 0, nil
+	}
+
+	rec := monitor.NewTimeMeasure("catchup")
+	defer rec.Record()
+	for _, n := range newcomers {
+		l.Lock()
+		overlay, fresh := l.overlays[n.si.ID], l.pending[n.si.ID]
+		joined := l.ledgers[n.si.ID] == nil
+		l.Unlock()
+		if joined {
+			roster, err := identity.Audit(genesis, members.Blocks())
+			if err != nil {
+				return 0, err
+			}
+			if !contains(roster, n.si) {
+				return 0, fmt.Errorf("%s is not in the identity chain", n.si.Address)
+			}
+		}
+		previous, err := l.roster(n.shard, ep-1)
+		if err != nil {
+			return 0, err
+		}
+		create := func(name string, t *onet.Tree) (onet.ProtocolInstance, error) {
+			return overlay.CreateProtocol(name, t, onet.NilServiceID)
+		}
+		if _, err := state.CatchUpWith(create, n.si, previous, fresh); err != nil {
+			return 0, fmt.Errorf("%s couldn't catch up with shard %d: %v",
+				n.si.Address, n.shard, err)
+		}
+		log.Lvl2(n.si.Address, "caught up with shard", n.shard, "in epoch", ep)
+	}
+	l.Lock()
+	defer l.Unlock()
+	for id, ledger := range l.pending {
+		l.ledgers[id] = ledger
+	}
+	l.pending = nil
+	// the validators that left drop their ledger, so that they catch up
+	// again if they join later
+	current := make(map[network.ServerIdentityID]bool)
+	for _, roster := range l.rosters[ep] {
+		for _, si := range roster.List {
+			current[si.ID] = true
+		}
+	}
+	for id := range l.ledgers {
+		if !current[id] {
+			delete(l.ledgers, id)
+		}
+	}
+	return len(newcomers), nil
+}
+
+// contains returns whether the host is in the roster.
+func contains(roster *onet.Roster, si *network.ServerIdentity) bool {
+	for _, m := range roster.List {
+		if m.ID.Equal(si.ID) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/service"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/state"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// Gateway serves the OmniLedger service of the shards over HTTP with JSON:
+//
+//	POST /tx           submits the transaction of the body
+//	GET  /block/{hash} returns the transaction block with the hex hash
+//	GET  /proof/{tx}   returns the receipt of the transaction with the hex
+//	                   hash
+//	GET  /status       returns the latest state block of every shard
+//
+// Errors are returned as {"error": "..."} with the status code.
+type Gateway struct {
+	client  *service.Client
+	rosters []*onet.Roster
+	mux     *http.ServeMux
+}
+
+// TxReply is the reply to POST /tx.
+type TxReply struct {
+	// Hash is the hex of the hash of the transaction
+	Hash      string
+	Committed bool
+	Receipt   *service.Receipt `json:",omitempty"`
+}
+
+// BlockReply is the reply to GET /block.
+type BlockReply struct {
+	Hash  string
+	Index int
+	Block *state.TxBlock
+}
+
+// ShardStatus is the status of a shard in the reply to GET /status.
+type ShardStatus struct {
+	Shard   int
+	Members int
+	// Epoch and Hash are of the latest state block of the shard
+	Epoch int
+	Hash  string
+	// Error is why the shard couldn't be reached, if it couldn't
+	Error string `json:",omitempty"`
+}
+
+// errorReply is the body of the replies to failed requests.
+type errorReply struct {
+	Error string `json:"error"`
+}
+
+// NewGateway returns a gateway sending the requests with the client to the
+// shards of the rosters.
+func NewGateway(client *service.Client, rosters []*onet.Roster) *Gateway {
+	g := &Gateway{client: client, rosters: rosters, mux: http.NewServeMux()}
+	g.mux.HandleFunc("/tx", g.postTx)
+	g.mux.HandleFunc("/block/", g.getBlock)
+	g.mux.HandleFunc("/proof/", g.getProof)
+	g.mux.HandleFunc("/status", g.getStatus)
+	return g
+}
+
+// ServeHTTP implements http.Handler.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+// postTx submits the transaction to the first member of its first input
+// shard and waits for it to be committed or aborted.
+func (g *Gateway) postTx(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+		return
+	}
+	tx := &atomix.Transaction{}
+	if err := json.NewDecoder(r.Body).Decode(tx); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := tx.Verify(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	shard := tx.InputShards()[0]
+	if shard < 0 || shard >= len(g.rosters) {
+		writeError(w, http.StatusBadRequest, errors.New("unknown shard"))
+		return
+	}
+	receipt, cerr := g.client.SubmitTransaction(g.rosters[shard].List[0], tx)
+	if cerr != nil {
+		writeClientError(w, cerr)
+		return
+	}
+	writeJSON(w, &TxReply{
+		Hash:      hex.EncodeToString(tx.Hash()),
+		Committed: receipt != nil,
+		Receipt:   receipt,
+	})
+}
+
+// getBlock asks every shard for the block until one knows it.
+func (g *Gateway) getBlock(w http.ResponseWriter, r *http.Request) {
+	hash, ok := hashParam(w, r, "/block/")
+	if !ok {
+		return
+	}
+	for shard, roster := range g.rosters {
+		block, index, cerr := g.client.FindBlock(roster.List[0], shard, hash)
+		if cerr == nil {
+			writeJSON(w, &BlockReply{Hash: hex.EncodeToString(hash),
+				Index: index, Block: block})
+			return
+		}
+		log.Lvl3("shard", shard, "doesn't have block", hash, ":", cerr)
+	}
+	writeError(w, http.StatusNotFound, errors.New("unknown block"))
+}
+
+// getProof asks every shard for the receipt until one knows it.
+func (g *Gateway) getProof(w http.ResponseWriter, r *http.Request) {
+	hash, ok := hashParam(w, r, "/proof/")
+	if !ok {
+		return
+	}
+	for shard, roster := range g.rosters {
+		receipt, cerr := g.client.GetReceipt(roster.List[0], shard, hash)
+		if cerr == nil {
+			writeJSON(w, receipt)
+			return
+		}
+		log.Lvl3("shard", shard, "doesn't have receipt", hash, ":", cerr)
+	}
+	writeError(w, http.StatusNotFound, errors.New("unknown transaction"))
+}
+
+// getStatus returns the latest state block of every shard, from its first
+// member.
+func (g *Gateway) getStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("use GET"))
+		return
+	}
+	status := make([]ShardStatus, len(g.rosters))
+	for shard, roster := range g.rosters {
+		status[shard] = ShardStatus{Shard: shard, Members: len(roster.List)}
+		header, cerr := g.client.GetLatestStateBlock(roster.List[0], shard)
+		if cerr != nil {
+			status[shard].Error = cerr.Error()
+			continue
+		}
+		status[shard].Epoch = header.Epoch
+		status[shard].Hash = hex.EncodeToString(header.Hash())
+	}
+	writeJSON(w, status)
+}
+
+// hashParam returns the hex hash following the prefix of the path of a GET
+// request, or writes the error.
+func hashParam(w http.ResponseWriter, r *http.Request, prefix string) ([]byte, bool) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("use GET"))
+		return nil, false
+	}
+	hash, err := hex.DecodeString(strings.TrimPrefix(r.URL.Path, prefix))
+	if err != nil || len(hash) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("need a hex hash"))
+		return nil, false
+	}
+	return hash, true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("couldn't write reply:", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(&errorReply{err.Error()}); err != nil {
+		log.Error("couldn't write reply:", err)
+	}
+}
+
+// writeClientError writes the error of the service with the status code
+// matching its error code.
+func writeClientError(w http.ResponseWriter, cerr onet.ClientError) {
+	code := http.StatusBadGateway
+	switch cerr.ErrorCode() {
+	case service.ErrorParameterWrong:
+		code = http.StatusBadRequest
+	case service.ErrorBlockNotFound:
+		code = http.StatusNotFound
+	}
+	writeError(w, code, cerr)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/service"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+// setup starts two shards of four members, holding the output "a" in shard
+// 0 and "b" in shard 1, and returns a gateway to them.
+func setup(t *testing.T, l *onet.LocalTest) (*httptest.Server, []*onet.Roster) {
+	servers := l.GenServers(8)
+	utxos := []map[string]int64{{"a": 10}, {"b": 20}}
+	var rosters []*onet.Roster
+	var genesis []*state.Block
+	for shard := 0; shard < 2; shard++ {
+		members := servers[4*shard : 4*shard+4]
+		rosters = append(rosters, l.GenRosterFromHost(members...))
+		b, err := state.NewBlock(shard, 0, nil, utxos[shard])
+		require.Nil(t, err)
+		for i, server := range members {
+			require.Nil(t, b.Sign(network.Suite, i, l.GetPrivate(server)))
+		}
+		genesis = append(genesis, b)
+	}
+	c := service.NewClient()
+	require.Nil(t, c.Setup(rosters, genesis, 1))
+	return httptest.NewServer(NewGateway(c, rosters)), rosters
+}
+
+// get decodes the JSON reply to GET path into v and returns the status code.
+func get(t *testing.T, ts *httptest.Server, path string, v interface{}) int {
+	resp, err := http.Get(ts.URL + path)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(v))
+	return resp.StatusCode
+}
+
+func TestGateway(t *testing.T) {
+	l := onet.NewTCPTest()
+	defer l.CloseAll()
+	ts, rosters := setup(t, l)
+	defer ts.Close()
+
+	tx := &atomix.Transaction{
+		Inputs:  []atomix.Input{{Shard: 0, ID: "a", Value: 10}},
+		Outputs: []atomix.Output{{Shard: 1, ID: "c", Value: 10}},
+	}
+	body, err := json.Marshal(tx)
+	require.Nil(t, err)
+	resp, err := http.Post(ts.URL+"/tx", "application/json",
+		bytes.NewReader(body))
+	require.Nil(t, err)
+	reply := &TxReply{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(reply))
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.True(t, reply.Committed)
+	assert.Equal(t, hex.EncodeToString(tx.Hash()), reply.Hash)
+	assert.Nil(t, service.VerifyReceipt(rosters, tx, reply.Receipt))
+
+	receipt := &service.Receipt{}
+	assert.Equal(t, http.StatusOK, get(t, ts, "/proof/"+reply.Hash, receipt))
+	assert.Nil(t, service.VerifyReceipt(rosters, tx, receipt))
+	expected := &state.TxBlock{Shard: 1, Txs: []atomix.Transaction{*tx}}
+	block := &BlockReply{}
+	assert.Equal(t, http.StatusOK, get(t, ts,
+		"/block/"+hex.EncodeToString(expected.Hash()), block))
+	assert.Equal(t, expected.Hash(), block.Block.Hash())
+
+	var status []ShardStatus
+	assert.Equal(t, http.StatusOK, get(t, ts, "/status", &status))
+	require.Equal(t, 2, len(status))
+	assert.Equal(t, 4, status[1].Members)
+	assert.Empty(t, status[1].Error)
+
+	var e errorReply
+	assert.Equal(t, http.StatusNotFound, get(t, ts, "/proof/"+block.Hash, &e))
+	assert.Equal(t, http.StatusBadRequest, get(t, ts, "/block/xyz", &e))
+	// "a" is spent
+	resp, err = http.Post(ts.URL+"/tx", "application/json",
+		bytes.NewReader(body))
+	require.Nil(t, err)
+	reply = &TxReply{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(reply))
+	resp.Body.Close()
+	assert.False(t, reply.Committed)
+	assert.Nil(t, reply.Receipt)
+}
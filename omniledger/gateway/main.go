@@ -0,0 +1,43 @@
+// Gateway exposes a running deployment of the OmniLedger service over HTTP
+// with JSON, so that tools not speaking onet, like curl or analysis scripts,
+// can submit transactions and follow the ledger. It takes the group files of
+// the shards, in the order of the shards:
+//
+//	gateway -listen localhost:8080 shard0.toml shard1.toml
+//
+// and talks to the first member of every shard. See Gateway for the
+// endpoints.
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/service"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/app"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func main() {
+	listen := flag.String("listen", "localhost:8080", "address to serve HTTP on")
+	debug := flag.Int("debug", 1, "debug level")
+	flag.Parse()
+	log.SetDebugVisible(*debug)
+	if flag.NArg() == 0 {
+		log.Fatal("usage: gateway [-listen address] shard0.toml shard1.toml ...")
+	}
+	var rosters []*onet.Roster
+	for _, file := range flag.Args() {
+		f, err := os.Open(file)
+		log.ErrFatal(err)
+		roster, err := app.ReadGroupToml(f)
+		f.Close()
+		log.ErrFatal(err, "couldn't read", file)
+		rosters = append(rosters, roster)
+	}
+	log.Lvl1("Serving", len(rosters), "shards on", *listen)
+	log.ErrFatal(http.ListenAndServe(*listen,
+		NewGateway(service.NewClient(), rosters)))
+}
@@ -0,0 +1,311 @@
+// Package identity implements the identity chain of the validators. Every
+// change of the membership - a validator joining, leaving, or rotating its
+// key - is recorded in a block collectively signed by the members before the
+// change. The assignment of the validators to the shards at every epoch is
+// then done on the roster of the latest block, which anybody can audit by
+// following the chain from its first block.
+package identity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/bftcosi"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func init() {
+	network.RegisterMessage(Block{})
+}
+
+// ChangeType is the kind of change of the membership.
+type ChangeType int
+
+const (
+	// Join adds a new validator.
+	Join ChangeType = iota
+	// Leave removes a validator.
+	Leave
+	// Rotate replaces the key of a validator, which keeps its address.
+	Rotate
+)
+
+// String returns the name of the change.
+func (c ChangeType) String() string {
+	switch c {
+	case Join:
+		return "join"
+	case Leave:
+		return "leave"
+	case Rotate:
+		return "rotate"
+	}
+	return "unknown"
+}
+
+// Change is one change of the membership.
+type Change struct {
+	Type ChangeType
+	// Member is the joining or leaving validator, or the rotating validator
+	// with its new key. Validators are identified by their address.
+	Member *network.ServerIdentity
+}
+
+// Block is a block of the identity chain. It holds the members after the
+// changes, and is signed by the members before them.
+type Block struct {
+	// Index is the number of blocks before this one
+	Index int
+	// Previous is the hash of the previous block, empty for the first one
+	Previous []byte
+	// Members are the validators after the changes, in roster order
+	Members []*network.ServerIdentity
+	// Changes are the changes since the previous block, applied in order
+	Changes []Change
+	// Signature is the collective signature on Hash of the members of the
+	// previous block, or of Members for the first block
+	Signature *bftcosi.BFTSignature
+}
+
+// NewGenesis returns the unsigned first block of the chain with the members
+// of the roster.
+func NewGenesis(roster *onet.Roster) *Block {
+	return &Block{
+		Previous: []byte{},
+		Members:  append([]*network.ServerIdentity{}, roster.List...),
+	}
+}
+
+// NewBlock returns the unsigned block applying the changes to the members of
+// prev.
+func NewBlock(prev *Block, changes []Change) (*Block, error) {
+	if len(changes) == 0 {
+		return nil, errors.New("no change")
+	}
+	members, err := apply(prev.Members, changes)
+	if err != nil {
+		return nil, err
+	}
+	return &Block{
+		Index:    prev.Index + 1,
+		Previous: prev.Hash(),
+		Members:  members,
+		Changes:  changes,
+	}, nil
+}
+
+// Hash returns the hash signed by the members.
+func (b *Block) Hash() []byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "identity/%d/%x", b.Index, b.Previous)
+	for _, si := range b.Members {
+		writeMember(h, si)
+	}
+	h.Write([]byte("changes"))
+	for _, c := range b.Changes {
+		fmt.Fprintf(h, "/%d", c.Type)
+		writeMember(h, c.Member)
+	}
+	return h.Sum(nil)
+}
+
+// Roster returns the roster of the members.
+func (b *Block) Roster() *onet.Roster {
+	return onet.NewRoster(b.Members)
+}
+
+// Verify checks that the block follows prev, which is nil for the first
+// block, with its changes, and that it is signed by the members of prev.
+func (b *Block) Verify(prev *Block) error {
+	if err := b.check(prev); err != nil {
+		return err
+	}
+	signers := b.Members
+	if prev != nil {
+		signers = prev.Members
+	}
+	return verifySignature(signers, b.Hash(), b.Signature)
+}
+
+// check checks everything but the signature of the block.
+func (b *Block) check(prev *Block) error {
+	if len(b.Members) == 0 {
+		return errors.New("no member")
+	}
+	if prev == nil {
+		if b.Index != 0 || len(b.Previous) != 0 || len(b.Changes) != 0 {
+			return errors.New("not a first block")
+		}
+	} else {
+		if b.Index != prev.Index+1 {
+			return fmt.Errorf("block %d doesn't follow block %d", b.Index,
+				prev.Index)
+		}
+		if !bytes.Equal(b.Previous, prev.Hash()) {
+			return errors.New("doesn't point to the previous block")
+		}
+		members, err := apply(prev.Members, b.Changes)
+		if err != nil {
+			return err
+		}
+		if !sameMembers(members, b.Members) {
+			return errors.New("members don't match the changes")
+		}
+	}
+	return nil
+}
+
+// Chain is the identity chain as kept by a validator.
+type Chain struct {
+	mutex  sync.Mutex
+	blocks []*Block
+}
+
+// NewChain returns a chain starting with the signed first block.
+func NewChain(genesis *Block) (*Chain, error) {
+	if err := genesis.Verify(nil); err != nil {
+		return nil, err
+	}
+	return &Chain{blocks: []*Block{genesis}}, nil
+}
+
+// Append verifies the block against the latest one and appends it.
+func (c *Chain) Append(b *Block) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := b.Verify(c.blocks[len(c.blocks)-1]); err != nil {
+		return err
+	}
+	c.blocks = append(c.blocks, b)
+	return nil
+}
+
+// Check checks that the unsigned block can follow the latest one, e.g.
+// before signing it.
+func (c *Chain) Check(b *Block) error {
+	return b.check(c.Latest())
+}
+
+// Latest returns the latest block.
+func (c *Chain) Latest() *Block {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.blocks[len(c.blocks)-1]
+}
+
+// Roster returns the roster of the current members.
+func (c *Chain) Roster() *onet.Roster {
+	return c.Latest().Roster()
+}
+
+// Blocks returns all blocks of the chain, e.g. to audit it with Audit.
+func (c *Chain) Blocks() []*Block {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]*Block{}, c.blocks...)
+}
+
+// Audit checks the blocks from the first one, whose hash is genesis, and
+// returns the roster of the latest one.
+func Audit(genesis []byte, blocks []*Block) (*onet.Roster, error) {
+	if len(blocks) == 0 {
+		return nil, errors.New("no block")
+	}
+	if !bytes.Equal(blocks[0].Hash(), genesis) {
+		return nil, errors.New("unknown first block")
+	}
+	var prev *Block
+	for _, b := range blocks {
+		if err := b.Verify(prev); err != nil {
+			return nil, fmt.Errorf("block %d: %v", b.Index, err)
+		}
+		prev = b
+	}
+	return prev.Roster(), nil
+}
+
+// apply returns the members after the changes.
+func apply(members []*network.ServerIdentity, changes []Change) ([]*network.ServerIdentity, error) {
+	next := append([]*network.ServerIdentity{}, members...)
+	for _, c := range changes {
+		if c.Member == nil {
+			return nil, fmt.Errorf("%s without a member", c.Type)
+		}
+		pos := -1
+		for i, si := range next {
+			if si.Address == c.Member.Address {
+				pos = i
+			}
+		}
+		switch c.Type {
+		case Join:
+			if pos >= 0 {
+				return nil, fmt.Errorf("%s is already a member", c.Member.Address)
+			}
+			next = append(next, c.Member)
+		case Leave:
+			if pos < 0 || !next[pos].Public.Equal(c.Member.Public) {
+				return nil, fmt.Errorf("%s is not a member", c.Member.Address)
+			}
+			next = append(next[:pos], next[pos+1:]...)
+		case Rotate:
+			if pos < 0 {
+				return nil, fmt.Errorf("%s is not a member", c.Member.Address)
+			}
+			if next[pos].Public.Equal(c.Member.Public) {
+				return nil, fmt.Errorf("%s keeps its key", c.Member.Address)
+			}
+			next[pos] = c.Member
+		default:
+			return nil, fmt.Errorf("unknown change %d", c.Type)
+		}
+	}
+	if len(next) == 0 {
+		return nil, errors.New("no member left")
+	}
+	return next, nil
+}
+
+// sameMembers returns whether both lists hold the same members in the same
+// order.
+func sameMembers(a, b []*network.ServerIdentity) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Address != b[i].Address || !a[i].Public.Equal(b[i].Public) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeMember writes the address and the key of the member to the hash.
+func writeMember(w io.Writer, si *network.ServerIdentity) {
+	fmt.Fprintf(w, "/%s/", si.Address)
+	if si.Public != nil {
+		public, _ := si.Public.MarshalBinary()
+		w.Write(public)
+	}
+}
+
+// verifySignature checks that sig is a signature of the members on msg with
+// no more exceptions than BFTCoSi allows.
+func verifySignature(members []*network.ServerIdentity, msg []byte, sig *bftcosi.BFTSignature) error {
+	if sig == nil {
+		return errors.New("not signed")
+	}
+	if !bytes.Equal(sig.Msg, msg) {
+		return errors.New("signature of another message")
+	}
+	n := len(members)
+	if sig.Refused(n) > n-(n+1)*2/3 {
+		return errors.New("too many exceptions")
+	}
+	return sig.Verify(network.Suite, onet.NewRoster(members).Publics())
+}
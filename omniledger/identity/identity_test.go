@@ -0,0 +1,106 @@
+package identity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// chains holds the chain of every server of the tests, nil until the
+// server has one.
+var chains = make(map[network.ServerIdentityID]*Chain)
+
+func TestMain(m *testing.M) {
+	onet.GlobalProtocolRegister(SignName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return NewSignProtocol(n, chains[n.ServerIdentity().ID])
+	})
+	log.MainTest(m)
+}
+
+func TestChain(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	servers := local.GenServers(6)
+	roster := local.GenRosterFromHost(servers[:4]...)
+
+	genesis := NewGenesis(roster)
+	require.Nil(t, Sign(local.CreateProtocol, nil, genesis))
+	for _, si := range roster.List {
+		chain, err := NewChain(genesis)
+		require.Nil(t, err)
+		chains[si.ID] = chain
+	}
+	chain := chains[roster.List[0].ID]
+
+	// 4 joins, 0 leaves
+	joining := servers[4].ServerIdentity
+	b, err := NewBlock(genesis, []Change{
+		{Type: Join, Member: joining},
+		{Type: Leave, Member: roster.List[0]},
+	})
+	require.Nil(t, err)
+	require.Nil(t, Sign(local.CreateProtocol, genesis, b))
+	for _, si := range roster.List {
+		require.Nil(t, chains[si.ID].Append(b))
+	}
+	assert.Equal(t, 4, len(chain.Roster().List))
+	assert.Equal(t, joining.Address, chain.Roster().List[3].Address)
+
+	// 1 rotates its key, the members sign with their old keys
+	rotated := network.NewServerIdentity(config.NewKeyPair(network.Suite).Public,
+		roster.List[1].Address)
+	b, err = NewBlock(b, []Change{{Type: Rotate, Member: rotated}})
+	require.Nil(t, err)
+	require.Nil(t, Sign(local.CreateProtocol, chain.Latest(), b))
+	require.Nil(t, chain.Append(b))
+	assert.True(t, chain.Roster().List[0].Public.Equal(rotated.Public))
+
+	// anybody can audit the chain
+	audited, err := Audit(genesis.Hash(), chain.Blocks())
+	require.Nil(t, err)
+	assert.Equal(t, chain.Roster().List, audited.List)
+	_, err = Audit(b.Hash(), chain.Blocks())
+	assert.NotNil(t, err)
+	forged := *b
+	forged.Members = roster.List
+	_, err = Audit(genesis.Hash(), append(chain.Blocks()[:2], &forged))
+	assert.NotNil(t, err)
+}
+
+func TestRefuse(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	servers := local.GenServers(5)
+	roster := local.GenRosterFromHost(servers[:4]...)
+	genesis := NewGenesis(roster)
+	require.Nil(t, Sign(local.CreateProtocol, nil, genesis))
+	chain, err := NewChain(genesis)
+	require.Nil(t, err)
+
+	outsider := servers[4].ServerIdentity
+	_, err = NewBlock(genesis, nil)
+	assert.NotNil(t, err)
+	_, err = NewBlock(genesis, []Change{{Type: Join, Member: roster.List[1]}})
+	assert.NotNil(t, err)
+	_, err = NewBlock(genesis, []Change{{Type: Leave, Member: outsider}})
+	assert.NotNil(t, err)
+	_, err = NewBlock(genesis, []Change{{Type: Rotate, Member: roster.List[1]}})
+	assert.NotNil(t, err)
+
+	// members that don't match the changes
+	b, err := NewBlock(genesis, []Change{{Type: Join, Member: outsider}})
+	require.Nil(t, err)
+	b.Members = b.Members[1:]
+	assert.NotNil(t, chain.Check(b))
+
+	// a block signed by its new members instead of the old ones
+	b, err = NewBlock(genesis, []Change{{Type: Leave, Member: roster.List[0]}})
+	require.Nil(t, err)
+	assert.NotNil(t, Sign(local.CreateProtocol, nil, b))
+	assert.NotNil(t, chain.Append(b))
+}
@@ -0,0 +1,108 @@
+package identity
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/bftcosi"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// SignName is the name of the protocol signing the blocks of the identity
+// chain. It has to be registered by the validators with their Chain, for
+// example:
+//
+//	onet.GlobalProtocolRegister(identity.SignName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+//		return identity.NewSignProtocol(n, chain)
+//	})
+const SignName = "IdentitySign"
+
+// CreateFunc creates a protocol instance on the root of the tree without
+// starting it, like onet.Overlay.CreateProtocol.
+type CreateFunc func(name string, t *onet.Tree) (onet.ProtocolInstance, error)
+
+// SignProtocol lets the members sign a block of the identity chain with
+// BFTCoSi. The members only sign if the block follows the latest block of
+// their chain with valid changes.
+type SignProtocol struct {
+	*bftcosi.ProtocolBFTCoSi
+	// Block is the block to sign, set on the root
+	Block *Block
+}
+
+// NewSignProtocol returns a new instance checking the blocks against the
+// chain. If chain is nil, the validator has no chain yet and only signs a
+// first block.
+func NewSignProtocol(n *onet.TreeNodeInstance, chain *Chain) (*SignProtocol, error) {
+	bft, err := bftcosi.NewBFTCoSiProtocol(n, func(msg, data []byte) bool {
+		_, m, err := network.Unmarshal(data)
+		if err != nil {
+			return false
+		}
+		b, ok := m.(*Block)
+		if !ok || !bytes.Equal(b.Hash(), msg) {
+			return false
+		}
+		if chain == nil {
+			err = b.check(nil)
+		} else {
+			err = chain.Check(b)
+		}
+		if err != nil {
+			log.Lvl2(n.Name(), "refuses identity block:", err)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SignProtocol{ProtocolBFTCoSi: bft}, nil
+}
+
+// Start signs the block.
+func (p *SignProtocol) Start() error {
+	if p.Block == nil {
+		return errors.New("no block to sign")
+	}
+	data, err := network.Marshal(p.Block)
+	if err != nil {
+		return err
+	}
+	p.Msg = p.Block.Hash()
+	p.Data = data
+	return p.ProtocolBFTCoSi.Start()
+}
+
+// Sign lets the signers of the block, the members of prev or of the block
+// itself if it is the first one, sign it, and sets its signature.
+func Sign(create CreateFunc, prev, b *Block) error {
+	signers := b.Roster()
+	if prev != nil {
+		signers = prev.Roster()
+	}
+	pi, err := create(SignName, signers.GenerateNaryTree(len(signers.List)))
+	if err != nil {
+		return err
+	}
+	p, ok := pi.(*SignProtocol)
+	if !ok {
+		return errors.New("protocol " + SignName + " is not sign")
+	}
+	p.Block = b
+	done := make(chan *bftcosi.BFTSignature, 1)
+	p.RegisterOnSignatureDone(func(sig *bftcosi.BFTSignature) {
+		done <- sig
+	})
+	if err := p.Start(); err != nil {
+		return err
+	}
+	sig := <-done
+	if sig == nil {
+		return errors.New("no signature")
+	}
+	b.Signature = sig
+	return b.Verify(prev)
+}
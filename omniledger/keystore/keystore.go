@@ -0,0 +1,150 @@
+// Package keystore keeps the long-term key pair of a validator on disk,
+// encrypted with a passphrase, and rotates it: the validator signs a
+// Transition to its new key with both keys, which becomes the Rotate change
+// of the identity chain.
+package keystore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/crypto.v0/random"
+)
+
+// Iterations is the number of iterations of PBKDF2 deriving the encryption
+// key from the passphrase, making each guess of the passphrase costly.
+const Iterations = 1 << 16
+
+// file is the content of a key file. The byte fields are hex encoded.
+type file struct {
+	// Public is the public key, also authenticated by the encryption
+	Public string
+	// Iterations is the number of iterations of PBKDF2 for the key
+	Iterations int
+	// Salt is the salt of PBKDF2
+	Salt string
+	// Nonce is the nonce of AES-GCM
+	Nonce string
+	// Secret is the private key encrypted with AES-GCM
+	Secret string
+}
+
+// Save writes the key pair to path, encrypting the private key with the
+// passphrase. The file is only readable by its owner.
+func Save(path string, kp *config.KeyPair, passphrase []byte) error {
+	public, err := kp.Public.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	secret, err := kp.Secret.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	salt := random.Bytes(16, random.Stream)
+	aead, err := newAEAD(passphrase, salt, Iterations)
+	if err != nil {
+		return err
+	}
+	nonce := random.Bytes(aead.NonceSize(), random.Stream)
+	f := &file{
+		Public:     hex.EncodeToString(public),
+		Iterations: Iterations,
+		Salt:       hex.EncodeToString(salt),
+		Nonce:      hex.EncodeToString(nonce),
+		Secret:     hex.EncodeToString(aead.Seal(nil, nonce, secret, public)),
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(f); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0600)
+}
+
+// Load reads the key pair of the suite from path, decrypting the private key
+// with the passphrase. It fails if the passphrase is wrong or the file was
+// modified.
+func Load(path string, suite abstract.Suite, passphrase []byte) (*config.KeyPair, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &file{}
+	if _, err := toml.Decode(string(buf), f); err != nil {
+		return nil, err
+	}
+	var public, salt, nonce, sealed []byte
+	for _, field := range []struct {
+		dst *[]byte
+		src string
+	}{{&public, f.Public}, {&salt, f.Salt}, {&nonce, f.Nonce},
+		{&sealed, f.Secret}} {
+		if *field.dst, err = hex.DecodeString(field.src); err != nil {
+			return nil, err
+		}
+	}
+	if f.Iterations < 1 {
+		return nil, errors.New("invalid number of iterations")
+	}
+	aead, err := newAEAD(passphrase, salt, f.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+	secret, err := aead.Open(nil, nonce, sealed, public)
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted key file")
+	}
+	kp := &config.KeyPair{Suite: suite, Public: suite.Point(), Secret: suite.Scalar()}
+	if err := kp.Public.UnmarshalBinary(public); err != nil {
+		return nil, err
+	}
+	if err := kp.Secret.UnmarshalBinary(secret); err != nil {
+		return nil, err
+	}
+	if !suite.Point().Mul(nil, kp.Secret).Equal(kp.Public) {
+		return nil, errors.New("private key doesn't match the public key")
+	}
+	return kp, nil
+}
+
+// newAEAD returns AES-256-GCM with the key derived from the passphrase.
+func newAEAD(passphrase, salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2(passphrase, salt, iterations))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2 returns the 32 bytes key of PBKDF2 with HMAC-SHA256 (RFC 8018), of
+// which a single block is needed.
+func pbkdf2(passphrase, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, passphrase)
+	prf.Write(salt)
+	var index [4]byte
+	binary.BigEndian.PutUint32(index[:], 1)
+	prf.Write(index[:])
+	u := prf.Sum(nil)
+	key := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
@@ -0,0 +1,129 @@
+package keystore
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/identity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "key.toml")
+
+	kp := config.NewKeyPair(network.Suite)
+	require.Nil(t, Save(path, kp, []byte("passphrase")))
+	info, err := os.Stat(path)
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	buf, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	secret, _ := kp.Secret.MarshalBinary()
+	assert.False(t, strings.Contains(string(buf), hex.EncodeToString(secret)))
+
+	loaded, err := Load(path, network.Suite, []byte("passphrase"))
+	require.Nil(t, err)
+	assert.True(t, loaded.Public.Equal(kp.Public))
+	assert.True(t, loaded.Secret.Equal(kp.Secret))
+
+	_, err = Load(path, network.Suite, []byte("wrong"))
+	assert.NotNil(t, err)
+	_, err = Load(filepath.Join(dir, "none"), network.Suite, []byte("passphrase"))
+	assert.NotNil(t, err)
+
+	// another public key in the file doesn't decrypt
+	other := config.NewKeyPair(network.Suite)
+	public, _ := kp.Public.MarshalBinary()
+	otherPublic, _ := other.Public.MarshalBinary()
+	forged := strings.Replace(string(buf), hex.EncodeToString(public),
+		hex.EncodeToString(otherPublic), 1)
+	require.Nil(t, ioutil.WriteFile(path, []byte(forged), 0600))
+	_, err = Load(path, network.Suite, []byte("passphrase"))
+	assert.NotNil(t, err)
+}
+
+func TestPBKDF2(t *testing.T) {
+	// PBKDF2-HMAC-SHA256 test vectors
+	for _, v := range []struct {
+		iterations int
+		key        string
+	}{
+		{1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+	} {
+		key := pbkdf2([]byte("password"), []byte("salt"), v.iterations)
+		assert.Equal(t, v.key, hex.EncodeToString(key))
+	}
+}
+
+func TestRotate(t *testing.T) {
+	kp := config.NewKeyPair(network.Suite)
+	address := network.NewLocalAddress("127.0.0.1:2000")
+	next, tr, err := Rotate(kp, address)
+	require.Nil(t, err)
+	assert.False(t, next.Public.Equal(kp.Public))
+	assert.True(t, tr.New.Equal(next.Public))
+	require.Nil(t, tr.Verify(network.Suite))
+
+	// the statement can't be changed
+	forged := *tr
+	forged.Address = network.NewLocalAddress("127.0.0.1:2002")
+	assert.NotNil(t, forged.Verify(network.Suite))
+	// nor the new key replaced by one without its signature
+	forged = *tr
+	forged.New = config.NewKeyPair(network.Suite).Public
+	assert.NotNil(t, forged.Verify(network.Suite))
+	// nor the old one by another validator's
+	_, other, err := Rotate(config.NewKeyPair(network.Suite), address)
+	require.Nil(t, err)
+	forged = *tr
+	forged.OldSig = other.OldSig
+	assert.NotNil(t, forged.Verify(network.Suite))
+}
+
+func TestRotateIdentity(t *testing.T) {
+	var members []*network.ServerIdentity
+	var keys []*config.KeyPair
+	for i := 0; i < 4; i++ {
+		kp := config.NewKeyPair(network.Suite)
+		keys = append(keys, kp)
+		members = append(members, network.NewServerIdentity(kp.Public,
+			network.NewLocalAddress(fmt.Sprintf("127.0.0.1:%d", 2000+i))))
+	}
+	genesis := identity.NewGenesis(onet.NewRoster(members))
+
+	_, tr, err := Rotate(keys[2], members[2].Address)
+	require.Nil(t, err)
+	require.Nil(t, tr.VerifyChange(network.Suite, genesis))
+	b, err := identity.NewBlock(genesis, []identity.Change{tr.Change()})
+	require.Nil(t, err)
+	assert.True(t, b.Members[2].Public.Equal(tr.New))
+	assert.Equal(t, members[2].Address, b.Members[2].Address)
+
+	// the rotation of a key the member doesn't have
+	_, tr, err = Rotate(keys[1], members[2].Address)
+	require.Nil(t, err)
+	assert.NotNil(t, tr.VerifyChange(network.Suite, genesis))
+	// of somebody who isn't a member
+	_, tr, err = Rotate(keys[1], network.NewLocalAddress("127.0.0.1:9"))
+	require.Nil(t, err)
+	assert.NotNil(t, tr.VerifyChange(network.Suite, genesis))
+}
@@ -0,0 +1,110 @@
+package keystore
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/identity"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func init() {
+	network.RegisterMessage(Transition{})
+}
+
+// Transition is the statement of a validator that it replaces its key Old
+// by New. It is signed by the old key, so that only the validator can
+// rotate it, and by the new key, so that nobody can claim a key it doesn't
+// hold.
+type Transition struct {
+	// Address is the address of the validator, which it keeps
+	Address network.Address
+	Old     abstract.Point
+	New     abstract.Point
+	// OldSig and NewSig are the signatures of both keys on the statement
+	OldSig crypto.SchnorrSig
+	NewSig crypto.SchnorrSig
+}
+
+// Rotate returns a new key pair to replace kp of the validator at the
+// address, with the signed transition to it.
+func Rotate(kp *config.KeyPair, address network.Address) (*config.KeyPair, *Transition, error) {
+	next := config.NewKeyPair(kp.Suite)
+	t := &Transition{Address: address, Old: kp.Public, New: next.Public}
+	msg, err := t.statement()
+	if err != nil {
+		return nil, nil, err
+	}
+	if t.OldSig, err = crypto.SignSchnorr(kp.Suite, kp.Secret, msg); err != nil {
+		return nil, nil, err
+	}
+	if t.NewSig, err = crypto.SignSchnorr(kp.Suite, next.Secret, msg); err != nil {
+		return nil, nil, err
+	}
+	return next, t, nil
+}
+
+// Verify checks that the transition is signed by both keys.
+func (t *Transition) Verify(suite abstract.Suite) error {
+	if t.Old == nil || t.New == nil {
+		return errors.New("transition without keys")
+	}
+	if t.Old.Equal(t.New) {
+		return errors.New("transition to the same key")
+	}
+	msg, err := t.statement()
+	if err != nil {
+		return err
+	}
+	if err := crypto.VerifySchnorr(suite, t.Old, msg, t.OldSig); err != nil {
+		return fmt.Errorf("signature of the old key: %v", err)
+	}
+	if err := crypto.VerifySchnorr(suite, t.New, msg, t.NewSig); err != nil {
+		return fmt.Errorf("signature of the new key: %v", err)
+	}
+	return nil
+}
+
+// Change returns the change of the identity chain rotating the key of the
+// validator.
+func (t *Transition) Change() identity.Change {
+	return identity.Change{
+		Type:   identity.Rotate,
+		Member: network.NewServerIdentity(t.New, t.Address),
+	}
+}
+
+// VerifyChange checks that the rotation of the member of the identity block
+// prev is the one of the transition.
+func (t *Transition) VerifyChange(suite abstract.Suite, prev *identity.Block) error {
+	if err := t.Verify(suite); err != nil {
+		return err
+	}
+	for _, si := range prev.Members {
+		if si.Address == t.Address {
+			if !si.Public.Equal(t.Old) {
+				return errors.New("transition from another key than the member's")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not a member", t.Address)
+}
+
+// statement returns the message signed by both keys.
+func (t *Transition) statement() ([]byte, error) {
+	old, err := t.Old.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	next, err := t.New.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	msg := []byte(fmt.Sprintf("keystore/rotate/%s/", t.Address))
+	msg = append(msg, old...)
+	return append(msg, next...), nil
+}
@@ -0,0 +1,266 @@
+package randhound
+
+/*
+Election elects the leader of a RandHound session with a verifiable random
+function, so that the beacon doesn't depend on a fixed leader:
+
+ 1. The coordinator sends Elect with the session. Every node answers with its
+    ticket, the output of its VRF on the session.
+ 2. The coordinator asks the node with the lowest valid ticket to Lead. The
+    candidate checks it has been elected, runs RandHound as the leader and
+    replies with the transcript.
+
+If the candidate fails or doesn't answer in time, the coordinator falls back
+to the next lowest ticket, up to the f+1 lowest ones, so at least one of them
+is honest. The coordinator can't choose the leader: the tickets are fixed by
+the keys of the nodes and the session, and VerifyResult checks that the leader
+is one of the f+1 lowest tickets.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// ElectionName is the name of the election protocol.
+const ElectionName = "RandHoundElection"
+
+func init() {
+	onet.GlobalProtocolRegister(ElectionName, NewElection)
+}
+
+// Result is the outcome of an election: the elected leader, the tickets it
+// has been elected with and the transcript of the session it led.
+type Result struct {
+	Leader     int
+	Tickets    []Ticket
+	Transcript *Transcript
+}
+
+// VerifyResult checks the election and the transcript of the result for
+// the session and returns the random value.
+func VerifyResult(roster *onet.Roster, session []byte, r *Result) ([]byte, error) {
+	if err := VerifyElection(roster, session, r.Tickets, r.Leader); err != nil {
+		return nil, err
+	}
+	if r.Transcript == nil || string(r.Transcript.Session) != string(session) {
+		return nil, errors.New("transcript of another session")
+	}
+	return Verify(roster, r.Transcript)
+}
+
+// Election is one election, run on a flat tree rooted at the coordinator.
+type Election struct {
+	*onet.TreeNodeInstance
+	// Session identifies the session, e.g. the epoch. It is set on the root.
+	Session []byte
+
+	index int
+
+	electChan       chan electChan
+	ticketReplyChan chan ticketReplyChan
+	leadChan        chan leadChan
+	leadReplyChan   chan leadReplyChan
+	closeChan       chan closeChan
+
+	// tickets are the valid tickets the coordinator received
+	tickets []Ticket
+
+	onDone func(*Result, error)
+}
+
+// NewElection returns a new election.
+func NewElection(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	e := &Election{TreeNodeInstance: n}
+	e.index, _ = n.Roster().Search(n.ServerIdentity().ID)
+	err := e.RegisterChannels(&e.electChan, &e.ticketReplyChan, &e.leadChan,
+		&e.leadReplyChan, &e.closeChan)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// RegisterOnDone sets the function called on the coordinator with the
+// result of the election.
+func (e *Election) RegisterOnDone(fn func(*Result, error)) {
+	e.onDone = fn
+}
+
+// Start sends Elect to all nodes and computes the ticket of the
+// coordinator.
+func (e *Election) Start() error {
+	if e.Session == nil {
+		return errors.New("no session")
+	}
+	if err := e.SendToChildren(&Elect{Session: e.Session}); err != nil {
+		return err
+	}
+	t, err := NewTicket(e.Suite(), e.Private(), e.index, e.Session)
+	if err != nil {
+		return err
+	}
+	e.ticketReplyChan <- ticketReplyChan{e.TreeNode(), TicketReply{*t}}
+	return nil
+}
+
+// Dispatch answers the coordinator on the nodes and runs the election on
+// the coordinator.
+func (e *Election) Dispatch() error {
+	defer e.Done()
+	if !e.IsRoot() {
+		return e.dispatchNode()
+	}
+	n := len(e.Roster().List)
+	timeout := time.After(Timeout)
+collect:
+	for len(e.tickets) < n {
+		select {
+		case msg := <-e.ticketReplyChan:
+			if err := VerifyTicket(e.Roster(), e.Session, &msg.Ticket); err != nil {
+				log.Lvl2(e.Name(), "Ignoring", err)
+				continue
+			}
+			e.tickets = append(e.tickets, msg.Ticket)
+		case <-timeout:
+			break collect
+		}
+	}
+	result, err := e.elect()
+	if errClose := e.SendToChildren(&Close{}); errClose != nil {
+		log.Lvl2(e.Name(), "Couldn't close the election:", errClose)
+	}
+	if e.onDone != nil {
+		e.onDone(result, err)
+	}
+	return err
+}
+
+// dispatchNode sends the ticket of the node and leads RandHound whenever
+// the coordinator asks for it, until the election is closed.
+func (e *Election) dispatchNode() error {
+	select {
+	case msg := <-e.electChan:
+		t, err := NewTicket(e.Suite(), e.Private(), e.index, msg.Session)
+		if err != nil {
+			return err
+		}
+		if err := e.SendToParent(&TicketReply{*t}); err != nil {
+			return err
+		}
+	case <-time.After(Timeout):
+		return errors.New("didn't get elect")
+	}
+	for {
+		select {
+		case msg := <-e.leadChan:
+			reply := &LeadReply{}
+			t, err := e.lead(msg.Session, msg.Tickets)
+			if err != nil {
+				reply.Err = err.Error()
+			} else {
+				reply.Transcript = t
+			}
+			if err := e.SendToParent(reply); err != nil {
+				return err
+			}
+		case <-e.closeChan:
+			return nil
+		case <-time.After(3 * Timeout):
+			return errors.New("election not closed")
+		}
+	}
+}
+
+// elect asks the candidates to lead, from the lowest ticket on, until one
+// of them returns a valid transcript.
+func (e *Election) elect() (*Result, error) {
+	n := len(e.Roster().List)
+	candidates := Candidates(e.Roster(), e.Session, e.tickets)
+	if len(candidates) < n-threshold(n)+1 {
+		return nil, fmt.Errorf("only %d valid tickets", len(candidates))
+	}
+	for _, leader := range candidates[:threshold(n)] {
+		result := &Result{Leader: leader, Tickets: e.tickets}
+		t, err := e.ask(leader)
+		if err == nil {
+			result.Transcript = t
+			_, err = VerifyResult(e.Roster(), e.Session, result)
+		}
+		if err == nil {
+			return result, nil
+		}
+		log.Lvl2(e.Name(), "Falling back from node", leader, ":", err)
+	}
+	return nil, errors.New("no candidate could lead")
+}
+
+// ask asks the candidate to lead and waits for its transcript.
+func (e *Election) ask(leader int) (*Transcript, error) {
+	if leader == e.index {
+		return e.lead(e.Session, e.tickets)
+	}
+	var node *onet.TreeNode
+	for _, c := range e.Children() {
+		if c.ServerIdentity.ID.Equal(e.Roster().List[leader].ID) {
+			node = c
+		}
+	}
+	if node == nil {
+		return nil, fmt.Errorf("node %d not in the tree", leader)
+	}
+	if err := e.SendTo(node, &Lead{Session: e.Session, Tickets: e.tickets}); err != nil {
+		return nil, err
+	}
+	timeout := time.After(2 * Timeout)
+	for {
+		select {
+		case msg := <-e.leadReplyChan:
+			// a late reply of a previous candidate
+			if !msg.TreeNode.ID.Equal(node.ID) {
+				continue
+			}
+			if msg.Err != "" {
+				return nil, errors.New(msg.Err)
+			}
+			return msg.Transcript, nil
+		case <-timeout:
+			return nil, errors.New("timeout")
+		}
+	}
+}
+
+// lead checks that the node has been elected for the session and runs
+// RandHound with the node as the leader.
+func (e *Election) lead(session []byte, tickets []Ticket) (*Transcript, error) {
+	if err := VerifyElection(e.Roster(), session, tickets, e.index); err != nil {
+		return nil, err
+	}
+	// the tree keeps the order of the roster, as the transcript refers to the
+	// nodes by their index in it
+	tree := e.Roster().GenerateNaryTreeWithRootOnCurrent(len(e.Roster().List)-1,
+		e.ServerIdentity())
+	pi, err := e.CreateProtocol(Name, tree)
+	if err != nil {
+		return nil, err
+	}
+	rh := pi.(*RandHound)
+	rh.Session = session
+	type result struct {
+		transcript *Transcript
+		err        error
+	}
+	done := make(chan result, 1)
+	rh.RegisterOnDone(func(_ []byte, t *Transcript, err error) {
+		done <- result{t, err}
+	})
+	if err := rh.Start(); err != nil {
+		return nil, err
+	}
+	res := <-done
+	return res.transcript, res.err
+}
@@ -0,0 +1,93 @@
+package randhound
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// elect runs an election on the roster and returns its result.
+func elect(t *testing.T, local *onet.LocalTest, roster *onet.Roster,
+	session string) *Result {
+	tree := roster.GenerateNaryTree(len(roster.List) - 1)
+	pi, err := local.CreateProtocol(ElectionName, tree)
+	require.Nil(t, err)
+	e := pi.(*Election)
+	e.Session = []byte(session)
+	type result struct {
+		r   *Result
+		err error
+	}
+	done := make(chan result, 1)
+	e.RegisterOnDone(func(r *Result, err error) {
+		done <- result{r, err}
+	})
+	require.Nil(t, e.Start())
+	res := <-done
+	require.Nil(t, res.err)
+	return res.r
+}
+
+func TestElection(t *testing.T) {
+	for _, n := range []int{1, 4, 7} {
+		local := onet.NewLocalTest()
+		_, roster, _ := local.GenTree(n, false)
+		session := []byte("epoch 1")
+		result := elect(t, local, roster, string(session))
+		assert.Equal(t, n, len(result.Tickets))
+		// all nodes are honest, so the lowest ticket leads
+		assert.Equal(t, Candidates(roster, session, result.Tickets)[0],
+			result.Leader)
+		random, err := VerifyResult(roster, session, result)
+		require.Nil(t, err)
+		assert.Equal(t, 32, len(random))
+
+		_, err = VerifyResult(roster, []byte("epoch 2"), result)
+		assert.NotNil(t, err)
+		local.CloseAll()
+	}
+}
+
+func TestVerifyElection(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	servers, roster, _ := local.GenTree(7, false)
+	session := []byte("epoch 1")
+	var tickets []Ticket
+	for i, s := range servers {
+		private := local.GetPrivate(s)
+		ticket, err := NewTicket(network.Suite, private, i, session)
+		require.Nil(t, err)
+		require.Nil(t, VerifyTicket(roster, session, ticket))
+		tickets = append(tickets, *ticket)
+	}
+	candidates := Candidates(roster, session, tickets)
+	require.Equal(t, 7, len(candidates))
+
+	// only the f+1 lowest tickets may lead
+	for i, leader := range candidates {
+		err := VerifyElection(roster, session, tickets, leader)
+		if i < threshold(7) {
+			assert.Nil(t, err)
+		} else {
+			assert.NotNil(t, err)
+		}
+	}
+
+	// a ticket of another node is ignored
+	forged := append([]Ticket{}, tickets...)
+	forged[0].Index = 1
+	assert.Equal(t, 6, len(Candidates(roster, session, forged)))
+
+	// the same ticket twice doesn't count twice
+	forged = append([]Ticket{}, tickets[:4]...)
+	forged = append(forged, tickets[0])
+	assert.NotNil(t, VerifyElection(roster, session, forged, candidates[0]))
+
+	// the tickets are bound to the session
+	assert.NotNil(t, VerifyElection(roster, []byte("epoch 2"), tickets,
+		candidates[0]))
+}
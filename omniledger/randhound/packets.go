@@ -0,0 +1,137 @@
+package randhound
+
+import (
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func init() {
+	for _, i := range []interface{}{
+		Init{},
+		DealReply{},
+		Reveal{},
+		DecReply{},
+		Transcript{},
+		Elect{},
+		TicketReply{},
+		Lead{},
+		LeadReply{},
+		Close{},
+	} {
+		network.RegisterMessage(i)
+	}
+}
+
+// Init is sent by the leader to start a new session: every node answers
+// with a deal.
+type Init struct {
+	Session []byte
+}
+
+// DealReply holds the deal of a node for the session.
+type DealReply struct {
+	Deal SignedDeal
+}
+
+// SignedDeal is a deal signed by its dealer, bound to the session so that
+// it can't be reused in another one.
+type SignedDeal struct {
+	// Dealer is the index of the dealer in the roster
+	Dealer int
+	Deal   crypto.PVSSDeal
+	// Sig is the signature of the dealer on dealDigest
+	Sig []byte
+}
+
+// Reveal is sent by the leader once it chose the deals: every node decrypts
+// its shares of them.
+type Reveal struct {
+	Session []byte
+	Deals   []SignedDeal
+}
+
+// DecReply holds the shares of the chosen deals a node decrypted.
+type DecReply struct {
+	Shares []DecShare
+}
+
+// DecShare is the share of a deal decrypted by a trustee.
+type DecShare struct {
+	// Dealer is the index of the dealer of the share
+	Dealer int
+	crypto.PVSSDecShare
+}
+
+// Elect is sent by the coordinator of an election: every node answers with
+// its ticket for the session.
+type Elect struct {
+	Session []byte
+}
+
+// TicketReply holds the ticket of a node.
+type TicketReply struct {
+	Ticket Ticket
+}
+
+// Lead asks a candidate to lead RandHound for the session. The tickets let
+// the candidate check it has been elected.
+type Lead struct {
+	Session []byte
+	Tickets []Ticket
+}
+
+// LeadReply holds the transcript of the session the candidate led, or why
+// it failed.
+type LeadReply struct {
+	Transcript *Transcript
+	Err        string
+}
+
+// Close ends the election on all nodes.
+type Close struct{}
+
+type initChan struct {
+	*onet.TreeNode
+	Init
+}
+
+type dealReplyChan struct {
+	*onet.TreeNode
+	DealReply
+}
+
+type revealChan struct {
+	*onet.TreeNode
+	Reveal
+}
+
+type decReplyChan struct {
+	*onet.TreeNode
+	DecReply
+}
+
+type electChan struct {
+	*onet.TreeNode
+	Elect
+}
+
+type ticketReplyChan struct {
+	*onet.TreeNode
+	TicketReply
+}
+
+type leadChan struct {
+	*onet.TreeNode
+	Lead
+}
+
+type leadReplyChan struct {
+	*onet.TreeNode
+	LeadReply
+}
+
+type closeChan struct {
+	*onet.TreeNode
+	Close
+}
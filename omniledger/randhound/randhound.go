@@ -0,0 +1,277 @@
+package randhound
+
+/*
+RandHound is a leader-driven protocol producing a random value that is
+bias-resistant and publicly verifiable, used to assign the validators to the
+shards at every epoch. It runs in two rounds over a flat tree:
+
+ 1. The leader sends Init with the session. Every node shares a random secret
+    among all nodes with PVSS and signs its deal.
+ 2. Once the leader has 2f+1 valid deals, it chooses them and sends them in
+    Reveal. Every node decrypts its shares of the chosen deals.
+
+The leader recovers the secrets of the chosen deals from f+1 decrypted shares
+each and hashes their sum. As the leader chooses the deals before anybody can
+decrypt them, and at least one of them is honest, it can't bias the result.
+The transcript of the session lets everybody check the value with Verify.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+	"github.com/dedis/paper_17_sosp_omniledger/seed"
+	"gopkg.in/dedis/crypto.v0/sign"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// Name is the name of the protocol.
+const Name = "RandHound"
+
+func init() {
+	onet.GlobalProtocolRegister(Name, NewRandHound)
+}
+
+// Timeout is how long the nodes wait for the messages of the leader and the
+// leader for the replies of the nodes.
+var Timeout = 20 * time.Second
+
+// RandHound is one session of the protocol.
+type RandHound struct {
+	*onet.TreeNodeInstance
+	// Session identifies the session, e.g. the epoch. It is set on the root.
+	Session []byte
+
+	index int
+
+	initChan      chan initChan
+	dealReplyChan chan dealReplyChan
+	revealChan    chan revealChan
+	decReplyChan  chan decReplyChan
+
+	// deals are the valid deals the leader received
+	deals []SignedDeal
+	// chosen is the set of deals the leader revealed, nil before
+	chosen []SignedDeal
+	// shares are the valid decrypted shares of the chosen deals
+	shares []DecShare
+	// recovered counts the valid shares of every chosen dealer
+	recovered map[int]int
+
+	onDone func([]byte, *Transcript, error)
+}
+
+// NewRandHound returns a new session of the protocol.
+func NewRandHound(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	rh := &RandHound{
+		TreeNodeInstance: n,
+		recovered:        make(map[int]int),
+	}
+	rh.index, _ = n.Roster().Search(n.ServerIdentity().ID)
+	err := rh.RegisterChannels(&rh.initChan, &rh.dealReplyChan, &rh.revealChan,
+		&rh.decReplyChan)
+	if err != nil {
+		return nil, err
+	}
+	return rh, nil
+}
+
+// RegisterOnDone sets the function called on the leader with the random
+// value and the transcript of the session.
+func (rh *RandHound) RegisterOnDone(fn func([]byte, *Transcript, error)) {
+	rh.onDone = fn
+}
+
+// Start sends Init to all nodes and deals on the leader.
+func (rh *RandHound) Start() error {
+	if rh.Session == nil {
+		return errors.New("no session")
+	}
+	if err := rh.SendToChildren(&Init{Session: rh.Session}); err != nil {
+		return err
+	}
+	deal, err := rh.deal(rh.Session)
+	if err != nil {
+		return err
+	}
+	rh.dealReplyChan <- dealReplyChan{rh.TreeNode(), DealReply{*deal}}
+	return nil
+}
+
+// Dispatch runs the two rounds on the nodes and collects the replies on the
+// leader.
+func (rh *RandHound) Dispatch() error {
+	defer rh.Done()
+	timeout := time.After(Timeout)
+	if !rh.IsRoot() {
+		var session []byte
+		select {
+		case msg := <-rh.initChan:
+			session = msg.Session
+			deal, err := rh.deal(session)
+			if err != nil {
+				return err
+			}
+			if err := rh.SendToParent(&DealReply{*deal}); err != nil {
+				return err
+			}
+		case <-timeout:
+			return errors.New("didn't get init")
+		}
+		select {
+		case msg := <-rh.revealChan:
+			// we decrypt only once per session, for the deals of this session
+			reply, err := rh.decrypt(session, msg.Deals)
+			if err != nil {
+				return err
+			}
+			return rh.SendToParent(reply)
+		case <-timeout:
+			return errors.New("didn't get reveal")
+		}
+	}
+	for {
+		select {
+		case msg := <-rh.dealReplyChan:
+			if err := rh.collectDeal(&msg.Deal); err != nil {
+				return rh.finish(nil, err)
+			}
+		case msg := <-rh.decReplyChan:
+			if rh.collectShares(msg.Shares) {
+				return rh.finish(rh.transcript(), nil)
+			}
+		case <-timeout:
+			return rh.finish(nil, errors.New("timeout"))
+		}
+	}
+}
+
+// deal shares a new secret among all nodes and signs it for the session. The
+// secret is picked from the stream of package seed of the session and of our
+// index, so that a seeded simulation gets the same randomness.
+func (rh *RandHound) deal(session []byte) (*SignedDeal, error) {
+	stream := seed.Stream(fmt.Sprintf("randhound/%x/%d", session, rh.index))
+	d, err := crypto.NewPVSSDeal(rh.Suite(), rh.Suite().Scalar().Pick(stream),
+		rh.Roster().Publics(), threshold(len(rh.Roster().List)))
+	if err != nil {
+		return nil, err
+	}
+	msg, err := dealDigest(session, rh.index, d)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := sign.Schnorr(rh.Suite(), rh.Private(), msg)
+	if err != nil {
+		return nil, err
+	}
+	return &SignedDeal{Dealer: rh.index, Deal: *d, Sig: sig}, nil
+}
+
+// decrypt checks our shares of the chosen deals and decrypts them. The
+// other shares are checked by the leader and by whoever verifies the
+// transcript.
+func (rh *RandHound) decrypt(session []byte, deals []SignedDeal) (*DecReply, error) {
+	reply := &DecReply{}
+	for i := range deals {
+		sd := &deals[i]
+		err := verifyDealer(rh.Roster(), session, sd)
+		if err == nil {
+			err = crypto.VerifyPVSSEncShare(rh.Suite(), rh.Public(), rh.index,
+				&sd.Deal)
+		}
+		if err != nil {
+			log.Lvl2(rh.Name(), "Not decrypting invalid deal:", err)
+			continue
+		}
+		ds, err := crypto.PVSSDecrypt(rh.Suite(), rh.Private(), rh.index, &sd.Deal)
+		if err != nil {
+			return nil, err
+		}
+		reply.Shares = append(reply.Shares, DecShare{Dealer: sd.Dealer,
+			PVSSDecShare: *ds})
+	}
+	return reply, nil
+}
+
+// collectDeal adds the deal. Once there are 2f+1 valid ones, they are
+// chosen and revealed.
+func (rh *RandHound) collectDeal(sd *SignedDeal) error {
+	if rh.chosen != nil {
+		return nil
+	}
+	if err := verifySignedDeal(rh.Roster(), rh.Session, sd); err != nil {
+		log.Lvl2(rh.Name(), "Ignoring invalid deal:", err)
+		return nil
+	}
+	rh.deals = append(rh.deals, *sd)
+	n := len(rh.Roster().List)
+	if len(rh.deals) < n-threshold(n)+1 {
+		return nil
+	}
+	rh.chosen = rh.deals
+	log.Lvl3(rh.Name(), "Revealing", len(rh.chosen), "deals")
+	if err := rh.SendToChildren(&Reveal{Session: rh.Session,
+		Deals: rh.chosen}); err != nil {
+		return err
+	}
+	reply, err := rh.decrypt(rh.Session, rh.chosen)
+	if err != nil {
+		return err
+	}
+	rh.decReplyChan <- decReplyChan{rh.TreeNode(), *reply}
+	return nil
+}
+
+// collectShares adds the valid decrypted shares and returns true once every
+// chosen deal can be recovered.
+func (rh *RandHound) collectShares(shares []DecShare) bool {
+	deals := make(map[int]*SignedDeal)
+	for i := range rh.chosen {
+		deals[rh.chosen[i].Dealer] = &rh.chosen[i]
+	}
+	for i := range shares {
+		ds := &shares[i]
+		sd := deals[ds.Dealer]
+		if sd == nil || ds.Trustee < 0 || ds.Trustee >= len(rh.Roster().List) {
+			continue
+		}
+		if err := crypto.VerifyPVSSDecShare(rh.Suite(),
+			rh.Roster().List[ds.Trustee].Public, &sd.Deal,
+			&ds.PVSSDecShare); err != nil {
+			log.Lvl2(rh.Name(), "Ignoring invalid share of", ds.Trustee)
+			continue
+		}
+		rh.shares = append(rh.shares, *ds)
+		rh.recovered[ds.Dealer]++
+	}
+	for dealer := range deals {
+		if rh.recovered[dealer] < threshold(len(rh.Roster().List)) {
+			return false
+		}
+	}
+	return true
+}
+
+// transcript returns the transcript of the session.
+func (rh *RandHound) transcript() *Transcript {
+	return &Transcript{
+		Session: rh.Session,
+		Deals:   rh.chosen,
+		Shares:  rh.shares,
+	}
+}
+
+// finish computes the random value from the transcript and passes it on.
+func (rh *RandHound) finish(t *Transcript, err error) error {
+	var random []byte
+	if err == nil {
+		random, err = Verify(rh.Roster(), t)
+	}
+	if rh.onDone != nil {
+		rh.onDone(random, t, err)
+	}
+	return err
+}
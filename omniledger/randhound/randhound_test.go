@@ -0,0 +1,103 @@
+package randhound
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+// run runs a session on the roster and returns its result.
+func run(t *testing.T, local *onet.LocalTest, roster *onet.Roster,
+	session string) ([]byte, *Transcript) {
+	tree := roster.GenerateNaryTree(len(roster.List) - 1)
+	pi, err := local.CreateProtocol(Name, tree)
+	require.Nil(t, err)
+	rh := pi.(*RandHound)
+	rh.Session = []byte(session)
+	type result struct {
+		random []byte
+		t      *Transcript
+		err    error
+	}
+	done := make(chan result, 1)
+	rh.RegisterOnDone(func(random []byte, t *Transcript, err error) {
+		done <- result{random, t, err}
+	})
+	require.Nil(t, rh.Start())
+	res := <-done
+	require.Nil(t, res.err)
+	return res.random, res.t
+}
+
+func TestRandHound(t *testing.T) {
+	for _, n := range []int{1, 4, 7} {
+		local := onet.NewLocalTest()
+		_, roster, _ := local.GenTree(n, false)
+		random, transcript := run(t, local, roster, "epoch 1")
+		assert.Equal(t, 32, len(random))
+		assert.True(t, len(transcript.Deals) >= 2*((n-1)/3)+1)
+		verified, err := Verify(roster, transcript)
+		require.Nil(t, err)
+		assert.Equal(t, random, verified)
+
+		other, _ := run(t, local, roster, "epoch 1")
+		assert.NotEqual(t, random, other)
+		local.CloseAll()
+	}
+}
+
+func TestVerifyTranscript(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(7, false)
+	_, transcript := run(t, local, roster, "epoch 2")
+
+	// the deals are bound to the session
+	forged := *transcript
+	forged.Session = []byte("epoch 3")
+	_, err := Verify(roster, &forged)
+	assert.NotNil(t, err)
+
+	// not enough deals to be sure one is honest
+	forged = *transcript
+	forged.Deals = forged.Deals[:threshold(7)-1]
+	_, err = Verify(roster, &forged)
+	assert.NotNil(t, err)
+
+	// the same deal twice
+	forged = *transcript
+	forged.Deals = []SignedDeal{transcript.Deals[0], transcript.Deals[0],
+		transcript.Deals[0]}
+	_, err = Verify(roster, &forged)
+	assert.NotNil(t, err)
+
+	// wrong shares are ignored, so there are not enough left
+	forged = *transcript
+	forged.Shares = nil
+	for _, ds := range transcript.Shares {
+		ds.Share = network.Suite.Point().Base()
+		forged.Shares = append(forged.Shares, ds)
+	}
+	_, err = Verify(roster, &forged)
+	assert.NotNil(t, err)
+
+	// any threshold of shares recovers the same value
+	random, err := Verify(roster, transcript)
+	require.Nil(t, err)
+	forged = *transcript
+	forged.Shares = nil
+	for i := len(transcript.Shares) - 1; i >= 0; i-- {
+		forged.Shares = append(forged.Shares, transcript.Shares[i])
+	}
+	reversed, err := Verify(roster, &forged)
+	require.Nil(t, err)
+	assert.Equal(t, random, reversed)
+}
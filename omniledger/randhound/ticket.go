@@ -0,0 +1,93 @@
+package randhound
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// Ticket is the output of the VRF of a node on the session of an election,
+// with its proof. The node with the lowest valid ticket leads RandHound.
+type Ticket struct {
+	// Index is the index of the node in the roster
+	Index  int
+	Output []byte
+	Proof  crypto.VRFProof
+}
+
+// ticketMsg returns what the nodes compute their VRF on for the session.
+func ticketMsg(session []byte) []byte {
+	return append([]byte("election/"), session...)
+}
+
+// NewTicket returns the ticket of the node at index for the session.
+func NewTicket(suite abstract.Suite, private abstract.Scalar, index int,
+	session []byte) (*Ticket, error) {
+	output, proof, err := crypto.VRFProve(suite, private, ticketMsg(session))
+	if err != nil {
+		return nil, err
+	}
+	return &Ticket{Index: index, Output: output, Proof: *proof}, nil
+}
+
+// VerifyTicket checks that the ticket has been computed by its node for the
+// session.
+func VerifyTicket(roster *onet.Roster, session []byte, t *Ticket) error {
+	if t.Index < 0 || t.Index >= len(roster.List) {
+		return fmt.Errorf("unknown node %d", t.Index)
+	}
+	if err := crypto.VRFVerify(network.Suite, roster.List[t.Index].Public,
+		ticketMsg(session), t.Output, &t.Proof); err != nil {
+		return fmt.Errorf("invalid ticket of node %d: %v", t.Index, err)
+	}
+	return nil
+}
+
+// Candidates returns the nodes with a valid ticket, from the lowest ticket
+// to the highest. Invalid and duplicate tickets are ignored.
+func Candidates(roster *onet.Roster, session []byte, tickets []Ticket) []int {
+	var valid []*Ticket
+	seen := make(map[int]bool)
+	for i := range tickets {
+		t := &tickets[i]
+		if seen[t.Index] || VerifyTicket(roster, session, t) != nil {
+			continue
+		}
+		seen[t.Index] = true
+		valid = append(valid, t)
+	}
+	sort.Slice(valid, func(i, j int) bool {
+		return bytes.Compare(valid[i].Output, valid[j].Output) < 0
+	})
+	candidates := make([]int, len(valid))
+	for i, t := range valid {
+		candidates[i] = t.Index
+	}
+	return candidates
+}
+
+// VerifyElection checks that leader was allowed to lead the session: the
+// tickets must come from at least 2f+1 nodes, and as the coordinator only
+// falls back to the next candidate when the current one fails, the leader
+// must be one of the f+1 lowest tickets.
+func VerifyElection(roster *onet.Roster, session []byte, tickets []Ticket,
+	leader int) error {
+	n := len(roster.List)
+	candidates := Candidates(roster, session, tickets)
+	if len(candidates) < n-threshold(n)+1 {
+		return errors.New("not enough valid tickets")
+	}
+	for i := 0; i < threshold(n); i++ {
+		if candidates[i] == leader {
+			return nil
+		}
+	}
+	return fmt.Errorf("node %d is not one of the %d lowest tickets", leader,
+		threshold(n))
+}
@@ -0,0 +1,126 @@
+package randhound
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/sign"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// Transcript holds everything needed to recompute and check the randomness
+// of a session: the deals chosen by the leader and the decrypted shares.
+type Transcript struct {
+	Session []byte
+	Deals   []SignedDeal
+	Shares  []DecShare
+}
+
+// threshold returns the number of shares needed to recover a secret with n
+// trustees: f+1, so that the f faulty nodes can't recover it alone.
+func threshold(n int) int {
+	return (n-1)/3 + 1
+}
+
+// dealDigest returns what the dealer signs for its deal.
+func dealDigest(session []byte, dealer int, d *crypto.PVSSDeal) ([]byte, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "randhound/%x/%d", session, dealer)
+	for _, points := range [][]abstract.Point{d.Commits, d.EncShares} {
+		for _, p := range points {
+			if _, err := p.MarshalTo(h); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return h.Sum(nil), nil
+}
+
+// verifySignedDeal checks the signature and the shares of the deal.
+func verifySignedDeal(roster *onet.Roster, session []byte, sd *SignedDeal) error {
+	if err := verifyDealer(roster, session, sd); err != nil {
+		return err
+	}
+	return crypto.VerifyPVSSDeal(network.Suite, roster.Publics(),
+		threshold(len(roster.List)), &sd.Deal)
+}
+
+// verifyDealer checks that the deal is signed by its dealer for the session.
+func verifyDealer(roster *onet.Roster, session []byte, sd *SignedDeal) error {
+	if sd.Dealer < 0 || sd.Dealer >= len(roster.List) {
+		return fmt.Errorf("unknown dealer %d", sd.Dealer)
+	}
+	msg, err := dealDigest(session, sd.Dealer, &sd.Deal)
+	if err != nil {
+		return err
+	}
+	if err := sign.VerifySchnorr(network.Suite, roster.List[sd.Dealer].Public,
+		msg, sd.Sig); err != nil {
+		return fmt.Errorf("invalid signature of dealer %d", sd.Dealer)
+	}
+	if len(sd.Deal.Commits) != threshold(len(roster.List)) {
+		return errors.New("wrong number of commitments")
+	}
+	return nil
+}
+
+// Verify checks the transcript against the roster and returns the random
+// value. The transcript must hold the deals of at least f+1 distinct
+// dealers, so that at least one of them is honest and unknown to the
+// leader when it chose them.
+func Verify(roster *onet.Roster, t *Transcript) ([]byte, error) {
+	suite := network.Suite
+	n := len(roster.List)
+	if len(t.Deals) < threshold(n) {
+		return nil, errors.New("not enough deals")
+	}
+	deals := make(map[int]*SignedDeal)
+	for i := range t.Deals {
+		sd := &t.Deals[i]
+		if deals[sd.Dealer] != nil {
+			return nil, fmt.Errorf("dealer %d dealt twice", sd.Dealer)
+		}
+		if err := verifySignedDeal(roster, t.Session, sd); err != nil {
+			return nil, err
+		}
+		deals[sd.Dealer] = sd
+	}
+
+	// the valid shares of every dealer, one per trustee
+	shares := make(map[int][]crypto.PVSSDecShare)
+	seen := make(map[[2]int]bool)
+	for i := range t.Shares {
+		ds := &t.Shares[i]
+		sd := deals[ds.Dealer]
+		if sd == nil || ds.Trustee < 0 || ds.Trustee >= n ||
+			seen[[2]int{ds.Dealer, ds.Trustee}] {
+			continue
+		}
+		if err := crypto.VerifyPVSSDecShare(suite, roster.List[ds.Trustee].Public,
+			&sd.Deal, &ds.PVSSDecShare); err != nil {
+			continue
+		}
+		seen[[2]int{ds.Dealer, ds.Trustee}] = true
+		shares[ds.Dealer] = append(shares[ds.Dealer], ds.PVSSDecShare)
+	}
+
+	sum := suite.Point().Null()
+	for _, sd := range t.Deals {
+		secret, err := crypto.RecoverPVSSSecret(suite, shares[sd.Dealer],
+			threshold(n))
+		if err != nil {
+			return nil, fmt.Errorf("dealer %d: %v", sd.Dealer, err)
+		}
+		sum.Add(sum, secret)
+	}
+	h := sha256.New()
+	h.Write(t.Session)
+	if _, err := sum.MarshalTo(h); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,116 @@
+// Package safety computes how large the shards have to be so that, with
+// the validators assigned at random, no shard is controlled by the
+// adversary but with a negligible probability. As in OmniLedger, a shard
+// of m validators sampled from n, of which a fraction is malicious, fails
+// if at least a third of its members are malicious; the number of
+// malicious members follows the hypergeometric distribution.
+package safety
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// DefaultSecurity is the security parameter used when none is given: the
+// shards fail with a probability of at most 2^-20, as in the paper.
+const DefaultSecurity = 20
+
+// Config is the safety part of the configuration of the shards.
+type Config struct {
+	// Adversary is the fraction of malicious validators, 0 to skip the
+	// checks
+	Adversary float64
+	// Security is such that a shard fails with a probability of at most
+	// 2^-Security, DefaultSecurity if 0
+	Security int
+}
+
+// Failure returns the highest failure probability of a shard the
+// configuration allows.
+func (c Config) Failure() float64 {
+	security := c.Security
+	if security == 0 {
+		security = DefaultSecurity
+	}
+	return math.Pow(2, -float64(security))
+}
+
+// Check returns an error if splitting the nodes into the given number of
+// shards gives shards failing too often. The shards are at least
+// nodes/shards large.
+func (c Config) Check(nodes, shards int) error {
+	if c.Adversary == 0 {
+		return nil
+	}
+	if shards <= 0 || shards > nodes {
+		return errors.New("need between one shard and one shard per node")
+	}
+	size := nodes / shards
+	p, err := FailureProbability(nodes, c.Adversary, size)
+	if err != nil {
+		return err
+	}
+	if p > c.Failure() {
+		return fmt.Errorf("shards of %d of %d nodes fail with probability "+
+			"%.3g, more than %.3g", size, nodes, p, c.Failure())
+	}
+	return nil
+}
+
+// FailureProbability returns the probability that a shard of size members
+// sampled from nodes, of which the fraction adversary is malicious, holds
+// at least a third of malicious members.
+func FailureProbability(nodes int, adversary float64, size int) (float64, error) {
+	if adversary < 0 || adversary >= 1 {
+		return 0, errors.New("the adversary must be a fraction")
+	}
+	if size <= 0 || size > nodes {
+		return 0, errors.New("the shard must hold between one and all nodes")
+	}
+	bad := int(adversary * float64(nodes))
+	total := lnChoose(nodes, size)
+	p := 0.
+	for k := size / 3; k <= size && k <= bad; k++ {
+		if size-k > nodes-bad {
+			continue
+		}
+		p += math.Exp(lnChoose(bad, k) + lnChoose(nodes-bad, size-k) - total)
+	}
+	return math.Min(p, 1), nil
+}
+
+// MinShardSize returns the smallest shard sampled from nodes, of which the
+// fraction adversary is malicious, that fails with a probability of at most
+// failure.
+func MinShardSize(nodes int, adversary, failure float64) (int, error) {
+	for size := 1; size <= nodes; size++ {
+		p, err := FailureProbability(nodes, adversary, size)
+		if err != nil {
+			return 0, err
+		}
+		if p <= failure {
+			return size, nil
+		}
+	}
+	return 0, fmt.Errorf("no shard of %d nodes fails with probability "+
+		"at most %.3g", nodes, failure)
+}
+
+// MaxShards returns the largest number of shards the nodes can be split
+// into with every shard failing with a probability of at most failure.
+func MaxShards(nodes int, adversary, failure float64) (int, error) {
+	size, err := MinShardSize(nodes, adversary, failure)
+	if err != nil {
+		return 0, err
+	}
+	return nodes / size, nil
+}
+
+// lnChoose returns the natural logarithm of n choose k.
+func lnChoose(n, k int) float64 {
+	a, _ := math.Lgamma(float64(n + 1))
+	b, _ := math.Lgamma(float64(k + 1))
+	c, _ := math.Lgamma(float64(n - k + 1))
+	return a - b - c
+}
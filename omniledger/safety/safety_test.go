@@ -0,0 +1,63 @@
+package safety
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailureProbability(t *testing.T) {
+	// fewer than three members fail with any malicious node
+	p, err := FailureProbability(100, 0.1, 2)
+	require.Nil(t, err)
+	assert.InDelta(t, 1., p, 1e-9)
+	// all nodes hold less than a third of malicious ones
+	p, err = FailureProbability(100, 0.25, 100)
+	require.Nil(t, err)
+	assert.Equal(t, 0., p)
+
+	small, err := FailureProbability(1000, 0.25, 50)
+	require.Nil(t, err)
+	large, err := FailureProbability(1000, 0.25, 300)
+	require.Nil(t, err)
+	assert.True(t, large < small)
+
+	_, err = FailureProbability(100, 1, 10)
+	assert.NotNil(t, err)
+	_, err = FailureProbability(100, 0.25, 101)
+	assert.NotNil(t, err)
+}
+
+func TestMinShardSize(t *testing.T) {
+	failure := Config{}.Failure()
+	size, err := MinShardSize(1800, 0.25, failure)
+	require.Nil(t, err)
+	p, err := FailureProbability(1800, 0.25, size)
+	require.Nil(t, err)
+	assert.True(t, p <= failure)
+	p, err = FailureProbability(1800, 0.25, size-1)
+	require.Nil(t, err)
+	assert.True(t, p > failure)
+
+	// a weaker adversary allows smaller shards
+	weaker, err := MinShardSize(1800, 0.125, failure)
+	require.Nil(t, err)
+	assert.True(t, weaker < size)
+	shards, err := MaxShards(1800, 0.125, failure)
+	require.Nil(t, err)
+	assert.Equal(t, 1800/weaker, shards)
+
+	_, err = MinShardSize(1800, 1./3, failure)
+	assert.NotNil(t, err)
+}
+
+func TestCheck(t *testing.T) {
+	assert.Nil(t, Config{}.Check(10, 5))
+	c := Config{Adversary: 0.25, Security: 10}
+	shards, err := MaxShards(1000, c.Adversary, c.Failure())
+	require.Nil(t, err)
+	assert.Nil(t, c.Check(1000, shards))
+	assert.NotNil(t, c.Check(1000, shards+1))
+	assert.NotNil(t, c.Check(1000, 0))
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/state"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+const (
+	// ErrorParameterWrong indicates that a given parameter is out of bounds.
+	ErrorParameterWrong = 4200 + iota
+	// ErrorSetup indicates that the service is not set up, or already is.
+	ErrorSetup
+	// ErrorShard indicates that the server is not the right member of the
+	// shard for the request.
+	ErrorShard
+	// ErrorVerification indicates that a state block could not be verified.
+	ErrorVerification
+	// ErrorAtomix indicates that a phase of Atomix failed.
+	ErrorAtomix
+	// ErrorBlockNotFound indicates that the requested block or output is
+	// not known, or has been pruned.
+	ErrorBlockNotFound
+)
+
+// Client is a structure to communicate with the OmniLedger service from the
+// outside.
+type Client struct {
+	*onet.Client
+}
+
+// NewClient instantiates a new client.
+func NewClient() *Client {
+	return &Client{Client: onet.NewClient(ServiceName)}
+}
+
+// Setup sends the rosters of the shards with their signed first state
+// blocks to every member, starting the ledgers.
+func (c *Client) Setup(rosters []*onet.Roster, genesis []*state.Block,
+	blockSize int) onet.ClientError {
+	req := &Setup{Rosters: rosters, Genesis: genesis, BlockSize: blockSize}
+	for _, roster := range rosters {
+		for _, si := range roster.List {
+			if cerr := c.SendProtobuf(si, req, &SetupReply{}); cerr != nil {
+				return cerr
+			}
+		}
+	}
+	return nil
+}
+
+// SubmitTransaction asks the server to run the transaction through Atomix
+// and returns its receipt once it has been committed, or nil if it has been
+// aborted. The receipt is not checked, see VerifyReceipt.
+func (c *Client) SubmitTransaction(si *network.ServerIdentity,
+	tx *atomix.Transaction) (*Receipt, onet.ClientError) {
+	reply := &SubmitTransactionReply{}
+	cerr := c.SendProtobuf(si, &SubmitTransaction{Tx: *tx}, reply)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if !reply.Committed {
+		return nil, nil
+	}
+	if reply.Receipt == nil {
+		return nil, onet.NewClientErrorCode(ErrorAtomix,
+			"committed transaction without receipt")
+	}
+	return reply.Receipt, nil
+}
+
+// VerifyReceipt checks that the receipt proves the commit of the
+// transaction, knowing only the rosters of the shards: every input shard
+// must have accepted the transaction in a block signed by 2f+1 of its
+// members.
+func VerifyReceipt(rosters []*onet.Roster, tx *atomix.Transaction,
+	receipt *Receipt) error {
+	txHash := tx.Hash()
+	if !bytes.Equal(receipt.TxHash, txHash) {
+		return errors.New("receipt of another transaction")
+	}
+	shards := tx.InputShards()
+	if len(receipt.Proofs) != len(shards) {
+		return fmt.Errorf("%d proofs for %d input shards",
+			len(receipt.Proofs), len(shards))
+	}
+	for i, shard := range shards {
+		proof := &receipt.Proofs[i]
+		if proof.Shard != shard || !proof.For(txHash) {
+			return fmt.Errorf("wrong proof for shard %d", shard)
+		}
+		if !proof.Accept {
+			return fmt.Errorf("shard %d rejected the transaction", shard)
+		}
+		if shard < 0 || shard >= len(rosters) {
+			return fmt.Errorf("unknown shard %d", shard)
+		}
+		if err := atomix.VerifyProof(rosters[shard], proof); err != nil {
+			return fmt.Errorf("shard %d: %v", shard, err)
+		}
+	}
+	return nil
+}
+
+// GetBlock returns the transaction block index of the epoch from a member
+// of the shard.
+func (c *Client) GetBlock(si *network.ServerIdentity, shard, epoch,
+	index int) (*state.TxBlock, onet.ClientError) {
+	reply := &GetBlockReply{}
+	cerr := c.SendProtobuf(si, &GetBlock{Shard: shard, Epoch: epoch,
+		Index: index}, reply)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return reply.Block, nil
+}
+
+// FindBlock returns the transaction block with the hash from a member of
+// the shard, with its index in its epoch.
+func (c *Client) FindBlock(si *network.ServerIdentity, shard int,
+	hash []byte) (*state.TxBlock, int, onet.ClientError) {
+	reply := &FindBlockReply{}
+	cerr := c.SendProtobuf(si, &FindBlock{Shard: shard, Hash: hash}, reply)
+	if cerr != nil {
+		return nil, 0, cerr
+	}
+	return reply.Block, reply.Index, nil
+}
+
+// GetReceipt returns the receipt of a transaction committed by the shard
+// from one of its members. The receipt is not checked, see VerifyReceipt.
+func (c *Client) GetReceipt(si *network.ServerIdentity, shard int,
+	txHash []byte) (*Receipt, onet.ClientError) {
+	reply := &GetReceiptReply{}
+	cerr := c.SendProtobuf(si, &GetReceipt{Shard: shard, TxHash: txHash},
+		reply)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return reply.Receipt, nil
+}
+
+// GetProof returns the output id of the state block of the epoch from a
+// member of the shard, with the header of the block, once it checked the
+// Merkle path from the output to the root of the header. The header itself
+// has to be checked against the chain of state blocks, e.g. with
+// state.CatchUp.
+func (c *Client) GetProof(si *network.ServerIdentity, shard, epoch int,
+	id string) (*GetProofReply, onet.ClientError) {
+	reply := &GetProofReply{}
+	cerr := c.SendProtobuf(si, &GetProof{Shard: shard, Epoch: epoch, ID: id},
+		reply)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if reply.Header == nil || reply.UTXO == nil || reply.UTXO.ID != id {
+		return nil, onet.NewClientErrorCode(ErrorVerification,
+			"incomplete proof")
+	}
+	if err := state.VerifyUTXO(reply.Header.Root, reply.UTXO,
+		reply.Proof); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorVerification, err.Error())
+	}
+	return reply, nil
+}
+
+// GetLatestStateBlock returns the header of the latest state block of the
+// shard from one of its members.
+func (c *Client) GetLatestStateBlock(si *network.ServerIdentity,
+	shard int) (*state.Block, onet.ClientError) {
+	reply := &GetLatestStateBlockReply{}
+	cerr := c.SendProtobuf(si, &GetLatestStateBlock{Shard: shard}, reply)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return reply.Header, nil
+}
+
+// subscribeWait is how long a poll of a subscription waits on the member
+// for new blocks.
+var subscribeWait = 10 * time.Second
+
+// Subscription follows the transaction blocks committed by a shard. As
+// onet.v1 has no streaming connections, it keeps a connection to a member
+// of the shard open and polls it, every poll waiting on the member until
+// new blocks are committed.
+type Subscription struct {
+	// Updates gets the blocks in order. It is closed once the subscription
+	// stops, after Stop or an error.
+	Updates <-chan *BlockUpdate
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	mutex    sync.Mutex
+	err      onet.ClientError
+}
+
+// Subscribe follows the blocks committed by the shard from the first one
+// after Setup, as kept by the member si. If full is set, the updates hold
+// all the transactions of the blocks, else only the ones spending or
+// creating one of the outputs ids.
+func (c *Client) Subscribe(si *network.ServerIdentity, shard int, full bool,
+	ids ...string) *Subscription {
+	updates := make(chan *BlockUpdate, 16)
+	sub := &Subscription{Updates: updates, stop: make(chan struct{})}
+	req := &Subscribe{Shard: shard, Full: full, IDs: ids,
+		TimeoutMs: uint64(subscribeWait / time.Millisecond)}
+	go func() {
+		defer close(updates)
+		// a client of its own, as a poll blocks its client until it returns
+		client := onet.NewClientKeep(ServiceName)
+		defer client.Close()
+		for {
+			reply := &SubscribeReply{}
+			if cerr := client.SendProtobuf(si, req, reply); cerr != nil {
+				sub.mutex.Lock()
+				sub.err = cerr
+				sub.mutex.Unlock()
+				return
+			}
+			for i := range reply.Blocks {
+				select {
+				case updates <- &reply.Blocks[i]:
+				case <-sub.stop:
+					return
+				}
+			}
+			select {
+			case <-sub.stop:
+				return
+			default:
+			}
+			req.Next = reply.Next
+		}
+	}()
+	return sub
+}
+
+// Stop ends the subscription. Updates is closed once the current poll
+// returns.
+func (s *Subscription) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// Err returns the error that stopped the subscription, if any.
+func (s *Subscription) Err() onet.ClientError {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err
+}
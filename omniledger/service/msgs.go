@@ -0,0 +1,213 @@
+package service
+
+import (
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/state"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func init() {
+	for _, m := range []interface{}{
+		// - API calls
+		&Setup{},
+		&SetupReply{},
+		&SubmitTransaction{},
+		&SubmitTransactionReply{},
+		&GetBlock{},
+		&GetBlockReply{},
+		&GetProof{},
+		&GetProofReply{},
+		&GetLatestStateBlock{},
+		&GetLatestStateBlockReply{},
+		&Subscribe{},
+		&SubscribeReply{},
+		&FindBlock{},
+		&FindBlockReply{},
+		&GetReceipt{},
+		&GetReceiptReply{},
+		// - Internal calls
+		// Run a phase of Atomix on the first member of a shard
+		&LockTransactions{},
+		&LockTransactionsReply{},
+		&UnlockTransaction{},
+		&UnlockTransactionReply{},
+	} {
+		network.RegisterMessage(m)
+	}
+}
+
+// This file holds all messages that can be sent to the OmniLedger service,
+// both from the outside and between instances of this service
+
+// External calls
+
+// Setup - sent to every member of the shards to start the ledger with the
+// signed first state block of every shard, holding its outputs.
+type Setup struct {
+	Rosters []*onet.Roster
+	Genesis []*state.Block
+	// BlockSize is the number of committed transactions of a transaction
+	// block
+	BlockSize int
+}
+
+// SetupReply - the shard the server is a member of, -1 if none.
+type SetupReply struct {
+	Shard int
+}
+
+// SubmitTransaction - asks the server to run the transaction through Atomix
+// on its input and output shards.
+type SubmitTransaction struct {
+	Tx atomix.Transaction
+}
+
+// SubmitTransactionReply - whether the transaction has been committed or
+// aborted, with the receipt of a committed transaction.
+type SubmitTransactionReply struct {
+	Committed bool
+	Receipt   *Receipt `protobuf:"opt"`
+}
+
+// Receipt proves that a transaction has been committed: it holds the
+// proof-of-acceptance of every input shard, each with the header of the
+// block of the shard, the index of the transaction in it, the Merkle path of
+// the decision and the signatures of 2f+1 members. It is checked with
+// VerifyReceipt.
+type Receipt struct {
+	TxHash []byte
+	Proofs []atomix.Proof
+}
+
+// GetBlock - requests the transaction block Index of the epoch from a
+// member of the shard.
+type GetBlock struct {
+	Shard int
+	Epoch int
+	Index int
+}
+
+// GetBlockReply - returns the transaction block.
+type GetBlockReply struct {
+	Block *state.TxBlock
+}
+
+// GetProof - requests the proof that the output ID is in the state block of
+// the epoch from a member of the shard.
+type GetProof struct {
+	Shard int
+	Epoch int
+	ID    string
+}
+
+// GetProofReply - returns the output with the header of the state block
+// and the Merkle path from the output to its root.
+type GetProofReply struct {
+	Header *state.Block
+	UTXO   *state.UTXO
+	Proof  crypto.Proof
+}
+
+// GetLatestStateBlock - requests the header of the latest state block of
+// the shard from one of its members.
+type GetLatestStateBlock struct {
+	Shard int
+}
+
+// GetLatestStateBlockReply - returns the header of the latest state block.
+type GetLatestStateBlockReply struct {
+	Header *state.Block
+}
+
+// Subscribe - waits for the transaction blocks committed by the shard from
+// the sequence number Next on, counting from the first block after Setup.
+type Subscribe struct {
+	Shard int
+	Next  int
+	// Full asks for all the transactions of the blocks
+	Full bool
+	// IDs asks for the transactions spending or creating one of these
+	// outputs, if Full is not set
+	IDs []string
+	// TimeoutMs is how long to wait if there is no new block yet
+	TimeoutMs uint64
+}
+
+// SubscribeReply - returns the new blocks, which are none if the timeout
+// expired. Next is the sequence number to ask for next.
+type SubscribeReply struct {
+	Blocks []BlockUpdate
+	Next   int
+}
+
+// BlockUpdate is a transaction block committed by a shard, as sent to the
+// subscribers.
+type BlockUpdate struct {
+	// Seq is the sequence number of the block. The member only keeps the
+	// latest blocks for the subscribers, so a subscriber falling too far
+	// behind sees a gap.
+	Seq   int
+	Shard int
+	Epoch int
+	Hash  []byte
+	// Size is the number of transactions of the block
+	Size int
+	// Txs are all the transactions of the block, or the ones matching the
+	// IDs of the subscription
+	Txs []atomix.Transaction
+}
+
+// FindBlock - requests the transaction block with the hash from a member
+// of the shard.
+type FindBlock struct {
+	Shard int
+	Hash  []byte
+}
+
+// FindBlockReply - returns the block with its index in its epoch.
+type FindBlockReply struct {
+	Block *state.TxBlock
+	Index int
+}
+
+// GetReceipt - requests the receipt of a committed transaction from a
+// member of one of its shards, which asks the first member of the shard.
+type GetReceipt struct {
+	Shard  int
+	TxHash []byte
+}
+
+// GetReceiptReply - returns the receipt of the transaction.
+type GetReceiptReply struct {
+	Receipt *Receipt
+}
+
+// Internal calls
+
+// LockTransactions - asks the first member of an input shard to run the
+// first phase of Atomix on the transactions.
+type LockTransactions struct {
+	Shard int
+	Txs   []atomix.Transaction
+}
+
+// LockTransactionsReply - returns the proofs of the shard.
+type LockTransactionsReply struct {
+	Proofs []atomix.Proof
+}
+
+// UnlockTransaction - asks the first member of a shard to run the second
+// phase of Atomix on the transaction.
+type UnlockTransaction struct {
+	Shard  int
+	Tx     atomix.Transaction
+	Proofs []atomix.Proof
+}
+
+// UnlockTransactionReply - returns whether the shard committed the
+// transaction.
+type UnlockTransactionReply struct {
+	Committed bool
+}
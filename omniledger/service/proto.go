@@ -0,0 +1,76 @@
+package service
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/state"
+	"github.com/dedis/protobuf"
+)
+
+// apiCalls are the calls of the external API, as the name of the rpc and
+// its request, whose reply is the message of the same name with Reply
+// appended.
+var apiCalls = []struct {
+	rpc     string
+	request string
+}{
+	{"SubmitTx", "SubmitTransaction"},
+	{"GetProof", "GetProof"},
+	{"Subscribe", "Subscribe"},
+	{"GetBlock", "GetBlock"},
+	{"FindBlock", "FindBlock"},
+	{"GetReceipt", "GetReceipt"},
+	{"GetLatestStateBlock", "GetLatestStateBlock"},
+}
+
+// apiMessages are the messages of the external API and the types they
+// hold. atomix.Signature is left out as it is encoded as state.Signature.
+var apiMessages = []interface{}{
+	&SubmitTransaction{}, &SubmitTransactionReply{}, &Receipt{},
+	&GetProof{}, &GetProofReply{},
+	&Subscribe{}, &SubscribeReply{}, &BlockUpdate{},
+	&GetBlock{}, &GetBlockReply{},
+	&FindBlock{}, &FindBlockReply{},
+	&GetReceipt{}, &GetReceiptReply{},
+	&GetLatestStateBlock{}, &GetLatestStateBlockReply{},
+	&atomix.Transaction{}, &atomix.Input{}, &atomix.Output{},
+	&atomix.Proof{}, &atomix.BlockHeader{},
+	&state.Block{}, &state.Signature{}, &state.UTXO{}, &state.TxBlock{},
+}
+
+// protoHeader starts the definitions written by WriteProto.
+const protoHeader = `// Code generated by service.WriteProto. DO NOT EDIT.
+
+syntax = "proto2";
+
+package omniledger;
+
+// OmniLedger is the external API of the service, for clients in any
+// language. The servers serve it over WebSocket on the port following their
+// own: a call sends the encoding of its request as a binary message to
+// ws://host:port+1/OmniLedger/<request>, e.g. /OmniLedger/SubmitTransaction,
+// and gets the encoding of the reply back. An error closes the connection
+// with the error code and message of the service.
+service OmniLedger {
+`
+
+// WriteProto writes the protobuf definitions of the external API of the
+// service, generated from the messages so that they always match their
+// encoding, to omniledger.proto with "go test -run TestProto -proto".
+func WriteProto(w io.Writer) error {
+	if _, err := io.WriteString(w, protoHeader); err != nil {
+		return err
+	}
+	for _, c := range apiCalls {
+		if _, err := fmt.Fprintf(w, "  rpc %s(%s) returns (%sReply);\n",
+			c.rpc, c.request, c.request); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(w, "}\n"); err != nil {
+		return err
+	}
+	return protobuf.GenerateProtobufDefinition(w, apiMessages, nil, nil)
+}
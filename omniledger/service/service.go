@@ -0,0 +1,548 @@
+// Package service wraps the shards of OmniLedger into an onet service, so
+// that clients can submit transactions and query the blocks and the state
+// of the shards over the network.
+//
+// Every member of a shard keeps the ledger of its shard, started by Setup
+// with the signed first state block. Transactions are run through Atomix by
+// the server they are submitted to, which asks the first member of every
+// input and output shard to run a phase of the protocol in its shard. The
+// transactions committed by a shard are gathered into transaction blocks of
+// the current epoch, and the outputs of the state blocks can be proven to
+// light clients with GetProof. External tools can follow the blocks
+// committed by a shard with a Subscription.
+package service
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/state"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// ServiceName can be used to refer to the name of this service
+const ServiceName = "OmniLedger"
+
+// feedSize is the number of the latest transaction blocks kept for the
+// subscribers.
+const feedSize = 1000
+
+func init() {
+	omniledgerSID, _ = onet.RegisterNewService(ServiceName, newService)
+}
+
+// Only used in tests
+var omniledgerSID onet.ServiceID
+
+// Service holds the ledger of the shard this server is a member of.
+type Service struct {
+	*onet.ServiceProcessor
+
+	mutex sync.Mutex
+	// rosters are the rosters of the shards, nil before Setup
+	rosters []*onet.Roster
+	// shardID is the index of our shard, -1 if we are not a member of one
+	shardID int
+	shard   *atomix.Shard
+	ledger  *state.Ledger
+	// blockSize is the number of transactions of a transaction block
+	blockSize int
+	// committed are the transactions committed since the last transaction
+	// block
+	committed []atomix.Transaction
+	// feed are the latest transaction blocks of our shard, for the
+	// subscribers, the first one having the sequence number feedStart
+	feed      []*state.TxBlock
+	feedStart int
+	// feedCond is signaled with mutex when a block is added to feed
+	feedCond *sync.Cond
+	// receipts are the receipts of the transactions committed by our
+	// shard, if we are its first member, by the hex of their hash
+	receipts map[string]*Receipt
+}
+
+// Setup starts the ledger of our shard with its first state block. It can
+// only be called once.
+func (s *Service) Setup(req *Setup) (*SetupReply, onet.ClientError) {
+	if len(req.Rosters) == 0 || len(req.Rosters) != len(req.Genesis) {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"need the first state block of every shard")
+	}
+	if req.BlockSize <= 0 {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"need a positive block size")
+	}
+	s.mutex.Lock()
+	done := s.rosters != nil
+	s.mutex.Unlock()
+	if done {
+		return nil, onet.NewClientErrorCode(ErrorSetup, "already set up")
+	}
+	shardID := -1
+	for i, roster := range req.Rosters {
+		if j, _ := roster.Search(s.ServerIdentity().ID); j >= 0 && shardID < 0 {
+			shardID = i
+		}
+	}
+	var shard *atomix.Shard
+	var ledger *state.Ledger
+	if shardID >= 0 {
+		genesis := req.Genesis[shardID]
+		if genesis == nil || genesis.Shard != shardID {
+			return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+				"first state block of another shard")
+		}
+		ledger = state.NewLedger(shardID, 0)
+		if err := ledger.AppendState(genesis, req.Rosters[shardID]); err != nil {
+			return nil, onet.NewClientErrorCode(ErrorVerification, err.Error())
+		}
+		shard = atomix.NewShard(shardID, req.Rosters, genesis.Map())
+		shard.RegisterOnCommit(s.onCommit)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.rosters != nil {
+		return nil, onet.NewClientErrorCode(ErrorSetup, "already set up")
+	}
+	s.rosters = req.Rosters
+	s.shardID = shardID
+	s.shard = shard
+	s.ledger = ledger
+	s.blockSize = req.BlockSize
+	log.Lvl3(s.ServerIdentity(), "is a member of shard", shardID)
+	return &SetupReply{Shard: shardID}, nil
+}
+
+// SubmitTransaction runs both phases of Atomix for the transaction: it asks
+// the input shards to lock its inputs, and then sends their proofs to the
+// input and output shards. It returns whether the transaction has been
+// committed, and if so the proofs of the input shards as receipt.
+func (s *Service) SubmitTransaction(req *SubmitTransaction) (*SubmitTransactionReply, onet.ClientError) {
+	tx := &req.Tx
+	if err := tx.Verify(); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong, err.Error())
+	}
+	rosters, cerr := s.getRosters()
+	if cerr != nil {
+		return nil, cerr
+	}
+	for _, shard := range append(tx.InputShards(), tx.OutputShards()...) {
+		if shard < 0 || shard >= len(rosters) {
+			return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+				fmt.Sprintf("unknown shard %d", shard))
+		}
+	}
+
+	inputs := tx.InputShards()
+	proofs := make([]atomix.Proof, len(inputs))
+	errs := make([]error, len(inputs))
+	var wg sync.WaitGroup
+	for i, shard := range inputs {
+		wg.Add(1)
+		go func(i, shard int) {
+			defer wg.Done()
+			reply := &LockTransactionsReply{}
+			errs[i] = s.forward(rosters[shard], &LockTransactions{
+				Shard: shard,
+				Txs:   []atomix.Transaction{*tx},
+			}, reply)
+			if errs[i] == nil && len(reply.Proofs) != 1 {
+				errs[i] = errors.New("wrong number of proofs")
+			}
+			if errs[i] == nil {
+				proofs[i] = reply.Proofs[0]
+			}
+		}(i, shard)
+	}
+	wg.Wait()
+	if err := firstError(inputs, errs); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorAtomix, err.Error())
+	}
+
+	commit := true
+	for _, proof := range proofs {
+		commit = commit && proof.Accept
+	}
+	shards := append([]int{}, inputs...)
+	if commit {
+		shards = append(shards, tx.OutputShards()...)
+	}
+	shards = unique(shards)
+	errs = make([]error, len(shards))
+	for i, shard := range shards {
+		wg.Add(1)
+		go func(i, shard int) {
+			defer wg.Done()
+			reply := &UnlockTransactionReply{}
+			errs[i] = s.forward(rosters[shard], &UnlockTransaction{
+				Shard:  shard,
+				Tx:     *tx,
+				Proofs: proofs,
+			}, reply)
+			if errs[i] == nil && reply.Committed != commit {
+				errs[i] = errors.New("shard didn't follow the proofs")
+			}
+		}(i, shard)
+	}
+	wg.Wait()
+	if err := firstError(shards, errs); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorAtomix, err.Error())
+	}
+	reply := &SubmitTransactionReply{Committed: commit}
+	if commit {
+		reply.Receipt = &Receipt{TxHash: tx.Hash(), Proofs: proofs}
+	}
+	return reply, nil
+}
+
+// LockTransactions runs the first phase of Atomix in our shard, of which we
+// have to be the first member.
+func (s *Service) LockTransactions(req *LockTransactions) (*LockTransactionsReply, onet.ClientError) {
+	client, cerr := s.leaderClient(req.Shard)
+	if cerr != nil {
+		return nil, cerr
+	}
+	proofs, err := client.LockBlock(req.Shard, req.Txs)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorAtomix, err.Error())
+	}
+	return &LockTransactionsReply{Proofs: proofs}, nil
+}
+
+// UnlockTransaction runs the second phase of Atomix in our shard, of which
+// we have to be the first member.
+func (s *Service) UnlockTransaction(req *UnlockTransaction) (*UnlockTransactionReply, onet.ClientError) {
+	client, cerr := s.leaderClient(req.Shard)
+	if cerr != nil {
+		return nil, cerr
+	}
+	committed, err := client.UnlockShard(req.Shard, &req.Tx, req.Proofs)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorAtomix, err.Error())
+	}
+	if committed {
+		txHash := req.Tx.Hash()
+		s.mutex.Lock()
+		s.receipts[hex.EncodeToString(txHash)] = &Receipt{TxHash: txHash,
+			Proofs: req.Proofs}
+		s.mutex.Unlock()
+	}
+	return &UnlockTransactionReply{Committed: committed}, nil
+}
+
+// GetBlock returns a transaction block of our shard.
+func (s *Service) GetBlock(req *GetBlock) (*GetBlockReply, onet.ClientError) {
+	ledger, cerr := s.getLedger(req.Shard)
+	if cerr != nil {
+		return nil, cerr
+	}
+	blocks, err := ledger.TxBlocks(req.Epoch)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound, err.Error())
+	}
+	if req.Index < 0 || req.Index >= len(blocks) {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			fmt.Sprintf("no block %d in epoch %d", req.Index, req.Epoch))
+	}
+	return &GetBlockReply{Block: blocks[req.Index]}, nil
+}
+
+// FindBlock returns the transaction block of our shard with the hash, if
+// its epoch is retained.
+func (s *Service) FindBlock(req *FindBlock) (*FindBlockReply, onet.ClientError) {
+	ledger, cerr := s.getLedger(req.Shard)
+	if cerr != nil {
+		return nil, cerr
+	}
+	for _, epoch := range ledger.Retained() {
+		blocks, err := ledger.TxBlocks(epoch)
+		if err != nil {
+			continue
+		}
+		for i, block := range blocks {
+			if bytes.Equal(block.Hash(), req.Hash) {
+				return &FindBlockReply{Block: block, Index: i}, nil
+			}
+		}
+	}
+	return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+		fmt.Sprintf("no block %x", req.Hash))
+}
+
+// GetReceipt returns the receipt of a transaction committed by the shard,
+// as kept by its first member.
+func (s *Service) GetReceipt(req *GetReceipt) (*GetReceiptReply, onet.ClientError) {
+	rosters, cerr := s.getRosters()
+	if cerr != nil {
+		return nil, cerr
+	}
+	if req.Shard < 0 || req.Shard >= len(rosters) {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			fmt.Sprintf("unknown shard %d", req.Shard))
+	}
+	leader := rosters[req.Shard].List[0]
+	if !leader.ID.Equal(s.ServerIdentity().ID) {
+		reply := &GetReceiptReply{}
+		cerr := onet.NewClient(ServiceName).SendProtobuf(leader, req, reply)
+		return reply, cerr
+	}
+	s.mutex.Lock()
+	receipt := s.receipts[hex.EncodeToString(req.TxHash)]
+	s.mutex.Unlock()
+	if receipt == nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			fmt.Sprintf("no receipt of %x", req.TxHash))
+	}
+	return &GetReceiptReply{Receipt: receipt}, nil
+}
+
+// GetProof returns the proof that an output is in a state block of our
+// shard.
+func (s *Service) GetProof(req *GetProof) (*GetProofReply, onet.ClientError) {
+	ledger, cerr := s.getLedger(req.Shard)
+	if cerr != nil {
+		return nil, cerr
+	}
+	header, utxo, proof, err := ledger.Prove(req.Epoch, req.ID)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound, err.Error())
+	}
+	return &GetProofReply{Header: header, UTXO: utxo, Proof: proof}, nil
+}
+
+// GetLatestStateBlock returns the header of the latest state block of our
+// shard.
+func (s *Service) GetLatestStateBlock(req *GetLatestStateBlock) (*GetLatestStateBlockReply, onet.ClientError) {
+	ledger, cerr := s.getLedger(req.Shard)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return &GetLatestStateBlockReply{Header: ledger.Chain.Latest().Header()}, nil
+}
+
+// Subscribe returns the transaction blocks committed by our shard from the
+// sequence number req.Next on, waiting for the next one until the timeout if
+// there is none yet.
+func (s *Service) Subscribe(req *Subscribe) (*SubscribeReply, onet.ClientError) {
+	if _, cerr := s.getLedger(req.Shard); cerr != nil {
+		return nil, cerr
+	}
+	if req.Next < 0 {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"negative sequence number")
+	}
+	timeout := time.Duration(req.TimeoutMs) * time.Millisecond
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.feedCond.Broadcast()
+	})
+	defer timer.Stop()
+	ids := make(map[string]bool)
+	for _, id := range req.IDs {
+		ids[id] = true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for s.feedStart+len(s.feed) <= req.Next && time.Now().Before(deadline) {
+		s.feedCond.Wait()
+	}
+	next := req.Next
+	if next < s.feedStart {
+		next = s.feedStart
+	}
+	reply := &SubscribeReply{}
+	for ; next < s.feedStart+len(s.feed); next++ {
+		block := s.feed[next-s.feedStart]
+		update := BlockUpdate{
+			Seq:   next,
+			Shard: block.Shard,
+			Epoch: block.Epoch,
+			Hash:  block.Hash(),
+			Size:  len(block.Txs),
+		}
+		for _, tx := range block.Txs {
+			if req.Full || touches(&tx, ids) {
+				update.Txs = append(update.Txs, tx)
+			}
+		}
+		reply.Blocks = append(reply.Blocks, update)
+	}
+	reply.Next = next
+	return reply, nil
+}
+
+// onCommit gathers the transactions committed by our shard into
+// transaction blocks of the current epoch.
+func (s *Service) onCommit(tx *atomix.Transaction) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.committed = append(s.committed, *tx)
+	if len(s.committed) < s.blockSize {
+		return
+	}
+	block := &state.TxBlock{
+		Shard: s.shardID,
+		Epoch: s.ledger.Chain.Latest().Epoch,
+		Txs:   s.committed,
+	}
+	s.committed = nil
+	if err := s.ledger.AppendTxs(block); err != nil {
+		log.Error(s.ServerIdentity(), "couldn't add block:", err)
+		return
+	}
+	s.feed = append(s.feed, block)
+	if len(s.feed) > feedSize {
+		s.feedStart += len(s.feed) - feedSize
+		s.feed = s.feed[len(s.feed)-feedSize:]
+	}
+	s.feedCond.Broadcast()
+}
+
+// forward sends the request to the first member of the shard, or handles it
+// directly if it is us.
+func (s *Service) forward(roster *onet.Roster, req, reply network.Message) error {
+	leader := roster.List[0]
+	if !leader.ID.Equal(s.ServerIdentity().ID) {
+		cerr := onet.NewClient(ServiceName).SendProtobuf(leader, req, reply)
+		if cerr != nil {
+			return cerr
+		}
+		return nil
+	}
+	var cerr onet.ClientError
+	switch r := req.(type) {
+	case *LockTransactions:
+		var lr *LockTransactionsReply
+		if lr, cerr = s.LockTransactions(r); cerr == nil {
+			*reply.(*LockTransactionsReply) = *lr
+		}
+	case *UnlockTransaction:
+		var ur *UnlockTransactionReply
+		if ur, cerr = s.UnlockTransaction(r); cerr == nil {
+			*reply.(*UnlockTransactionReply) = *ur
+		}
+	default:
+		return errors.New("cannot forward this request")
+	}
+	if cerr != nil {
+		return cerr
+	}
+	return nil
+}
+
+// leaderClient returns an Atomix client running the protocols from us, if
+// we are the first member of the shard.
+func (s *Service) leaderClient(shard int) (*atomix.Client, onet.ClientError) {
+	rosters, cerr := s.getRosters()
+	if cerr != nil {
+		return nil, cerr
+	}
+	if shard < 0 || shard >= len(rosters) ||
+		!rosters[shard].List[0].ID.Equal(s.ServerIdentity().ID) {
+		return nil, onet.NewClientErrorCode(ErrorShard,
+			fmt.Sprintf("not the first member of shard %d", shard))
+	}
+	return atomix.NewClient(rosters, s.CreateProtocol), nil
+}
+
+// getRosters returns the rosters of the shards once set up.
+func (s *Service) getRosters() ([]*onet.Roster, onet.ClientError) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.rosters == nil {
+		return nil, onet.NewClientErrorCode(ErrorSetup, "not set up yet")
+	}
+	return s.rosters, nil
+}
+
+// getLedger returns the ledger of the shard, if we are one of its members.
+func (s *Service) getLedger(shard int) (*state.Ledger, onet.ClientError) {
+	if _, cerr := s.getRosters(); cerr != nil {
+		return nil, cerr
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ledger == nil || s.shardID != shard {
+		return nil, onet.NewClientErrorCode(ErrorShard,
+			fmt.Sprintf("not a member of shard %d", shard))
+	}
+	return s.ledger, nil
+}
+
+// newProtocol runs Atomix with the state of our shard.
+func (s *Service) newProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	s.mutex.Lock()
+	shard := s.shard
+	s.mutex.Unlock()
+	if shard == nil {
+		return nil, errors.New("not a member of a shard")
+	}
+	return atomix.NewProtocol(n, shard)
+}
+
+// firstError returns the first error with the shard it happened in.
+func firstError(shards []int, errs []error) error {
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("shard %d: %v", shards[i], err)
+		}
+	}
+	return nil
+}
+
+// touches returns whether the transaction spends or creates one of the
+// outputs.
+func touches(tx *atomix.Transaction, ids map[string]bool) bool {
+	for _, in := range tx.Inputs {
+		if ids[in.ID] {
+			return true
+		}
+	}
+	for _, out := range tx.Outputs {
+		if ids[out.ID] {
+			return true
+		}
+	}
+	return false
+}
+
+// unique returns the shards without duplicates, in order.
+func unique(shards []int) []int {
+	seen := make(map[int]bool)
+	var ret []int
+	for _, s := range shards {
+		if !seen[s] {
+			seen[s] = true
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+func newService(c *onet.Context) onet.Service {
+	s := &Service{
+		ServiceProcessor: onet.NewServiceProcessor(c),
+		shardID:          -1,
+		receipts:         make(map[string]*Receipt),
+	}
+	s.feedCond = sync.NewCond(&s.mutex)
+	log.ErrFatal(s.RegisterHandlers(s.Setup, s.SubmitTransaction,
+		s.GetBlock, s.GetProof, s.GetLatestStateBlock, s.Subscribe,
+		s.FindBlock, s.GetReceipt,
+		s.LockTransactions, s.UnlockTransaction))
+	if _, err := s.ProtocolRegister(atomix.Name, s.newProtocol); err != nil {
+		log.ErrFatal(err)
+	}
+	return s
+}
@@ -0,0 +1,260 @@
+package service
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+var writeProto = flag.Bool("proto", false, "rewrite omniledger.proto")
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+// setup starts two shards of four members, holding the outputs "a" and "b"
+// in shard 0 and "c" in shard 1, with blocks of one transaction.
+func setup(t *testing.T, l *onet.LocalTest) (*Client, []*onet.Roster, []*state.Block) {
+	servers := l.GenServers(8)
+	utxos := []map[string]int64{{"a": 10, "b": 5}, {"c": 20}}
+	var rosters []*onet.Roster
+	var genesis []*state.Block
+	for shard := 0; shard < 2; shard++ {
+		members := servers[4*shard : 4*shard+4]
+		rosters = append(rosters, l.GenRosterFromHost(members...))
+		b, err := state.NewBlock(shard, 0, nil, utxos[shard])
+		require.Nil(t, err)
+		for i, server := range members {
+			require.Nil(t, b.Sign(network.Suite, i, l.GetPrivate(server)))
+		}
+		genesis = append(genesis, b)
+	}
+	c := NewClient()
+	c.Client = l.NewClient(ServiceName)
+	require.Nil(t, c.Setup(rosters, genesis, 1))
+	return c, rosters, genesis
+}
+
+func TestSetup(t *testing.T) {
+	l := onet.NewTCPTest()
+	defer l.CloseAll()
+	c, rosters, genesis := setup(t, l)
+
+	assert.NotNil(t, c.Setup(rosters, genesis, 1))
+	header, cerr := c.GetLatestStateBlock(rosters[1].List[2], 1)
+	require.Nil(t, cerr)
+	assert.Equal(t, genesis[1].Hash(), header.Hash())
+	assert.Empty(t, header.UTXOs)
+	// not a member of the shard
+	_, cerr = c.GetLatestStateBlock(rosters[1].List[2], 0)
+	assert.NotNil(t, cerr)
+}
+
+func TestSubmitTransaction(t *testing.T) {
+	l := onet.NewTCPTest()
+	defer l.CloseAll()
+	c, rosters, _ := setup(t, l)
+
+	tx := &atomix.Transaction{
+		Inputs: []atomix.Input{
+			{Shard: 0, ID: "a", Value: 10},
+			{Shard: 1, ID: "c", Value: 20},
+		},
+		Outputs: []atomix.Output{
+			{Shard: 0, ID: "d", Value: 25},
+			{Shard: 1, ID: "e", Value: 5},
+		},
+	}
+	// submitted to a member that is not the first one of its shard
+	receipt, cerr := c.SubmitTransaction(rosters[1].List[3], tx)
+	require.Nil(t, cerr)
+	require.NotNil(t, receipt)
+	for shard, roster := range rosters {
+		block, cerr := c.GetBlock(roster.List[0], shard, 0, 0)
+		require.Nil(t, cerr)
+		require.Equal(t, 1, len(block.Txs))
+		assert.Equal(t, tx.Hash(), block.Txs[0].Hash())
+	}
+	_, cerr = c.GetBlock(rosters[0].List[0], 0, 0, 1)
+	assert.NotNil(t, cerr)
+
+	// "a" is spent
+	double := &atomix.Transaction{
+		Inputs:  []atomix.Input{{Shard: 0, ID: "a", Value: 10}},
+		Outputs: []atomix.Output{{Shard: 0, ID: "f", Value: 10}},
+	}
+	receipt, cerr = c.SubmitTransaction(rosters[0].List[1], double)
+	require.Nil(t, cerr)
+	assert.Nil(t, receipt)
+}
+
+func TestVerifyReceipt(t *testing.T) {
+	l := onet.NewTCPTest()
+	defer l.CloseAll()
+	c, rosters, _ := setup(t, l)
+
+	tx := &atomix.Transaction{
+		Inputs: []atomix.Input{
+			{Shard: 0, ID: "b", Value: 5},
+			{Shard: 1, ID: "c", Value: 20},
+		},
+		Outputs: []atomix.Output{{Shard: 1, ID: "d", Value: 25}},
+	}
+	receipt, cerr := c.SubmitTransaction(rosters[0].List[2], tx)
+	require.Nil(t, cerr)
+	require.NotNil(t, receipt)
+	require.Nil(t, VerifyReceipt(rosters, tx, receipt))
+	for shard, proof := range receipt.Proofs {
+		assert.Equal(t, shard, proof.Shard)
+		assert.Equal(t, 0, proof.Index)
+	}
+
+	other := &atomix.Transaction{
+		Inputs:  []atomix.Input{{Shard: 0, ID: "a", Value: 10}},
+		Outputs: []atomix.Output{{Shard: 0, ID: "e", Value: 10}},
+	}
+	assert.NotNil(t, VerifyReceipt(rosters, other, receipt))
+	// the proof of a shard is missing
+	missing := &Receipt{TxHash: receipt.TxHash, Proofs: receipt.Proofs[:1]}
+	assert.NotNil(t, VerifyReceipt(rosters, tx, missing))
+	// checked against the roster of another shard
+	swapped := []*onet.Roster{rosters[1], rosters[0]}
+	assert.NotNil(t, VerifyReceipt(swapped, tx, receipt))
+	// too few signatures
+	proofs := append([]atomix.Proof{}, receipt.Proofs...)
+	proofs[0].Signatures = proofs[0].Signatures[:1]
+	forged := &Receipt{TxHash: receipt.TxHash, Proofs: proofs}
+	assert.NotNil(t, VerifyReceipt(rosters, tx, forged))
+}
+
+func TestFindBlock(t *testing.T) {
+	l := onet.NewTCPTest()
+	defer l.CloseAll()
+	c, rosters, _ := setup(t, l)
+
+	tx := &atomix.Transaction{
+		Inputs:  []atomix.Input{{Shard: 0, ID: "a", Value: 10}},
+		Outputs: []atomix.Output{{Shard: 1, ID: "d", Value: 10}},
+	}
+	_, cerr := c.SubmitTransaction(rosters[0].List[0], tx)
+	require.Nil(t, cerr)
+	block, cerr := c.GetBlock(rosters[1].List[0], 1, 0, 0)
+	require.Nil(t, cerr)
+
+	found, index, cerr := c.FindBlock(rosters[1].List[3], 1, block.Hash())
+	require.Nil(t, cerr)
+	assert.Equal(t, 0, index)
+	assert.Equal(t, block.Hash(), found.Hash())
+	_, _, cerr = c.FindBlock(rosters[0].List[3], 0, block.Hash())
+	assert.NotNil(t, cerr)
+
+	// kept by the first member of the input and the output shard
+	for shard, roster := range rosters {
+		receipt, cerr := c.GetReceipt(roster.List[2], shard, tx.Hash())
+		require.Nil(t, cerr)
+		assert.Nil(t, VerifyReceipt(rosters, tx, receipt))
+	}
+	_, cerr = c.GetReceipt(rosters[0].List[2], 0, block.Hash())
+	assert.NotNil(t, cerr)
+}
+
+func TestGetProof(t *testing.T) {
+	l := onet.NewTCPTest()
+	defer l.CloseAll()
+	c, rosters, genesis := setup(t, l)
+
+	reply, cerr := c.GetProof(rosters[0].List[1], 0, 0, "b")
+	require.Nil(t, cerr)
+	assert.Equal(t, int64(5), reply.UTXO.Value)
+	assert.Equal(t, genesis[0].Hash(), reply.Header.Hash())
+	_, cerr = c.GetProof(rosters[0].List[1], 0, 0, "c")
+	assert.NotNil(t, cerr)
+	_, cerr = c.GetProof(rosters[0].List[1], 0, 1, "b")
+	assert.NotNil(t, cerr)
+}
+
+func TestSubscribe(t *testing.T) {
+	l := onet.NewTCPTest()
+	defer l.CloseAll()
+	c, rosters, _ := setup(t, l)
+	// short polls, so that the subscriptions end before the servers
+	defer func(wait time.Duration) { subscribeWait = wait }(subscribeWait)
+	subscribeWait = 100 * time.Millisecond
+
+	full := c.Subscribe(rosters[1].List[1], 1, true)
+	filtered := c.Subscribe(rosters[0].List[2], 0, false, "b")
+	txs := []*atomix.Transaction{{
+		Inputs:  []atomix.Input{{Shard: 0, ID: "a", Value: 10}},
+		Outputs: []atomix.Output{{Shard: 1, ID: "d", Value: 10}},
+	}, {
+		Inputs:  []atomix.Input{{Shard: 0, ID: "b", Value: 5}},
+		Outputs: []atomix.Output{{Shard: 0, ID: "e", Value: 5}},
+	}}
+	for _, tx := range txs {
+		receipt, cerr := c.SubmitTransaction(rosters[0].List[0], tx)
+		require.Nil(t, cerr)
+		require.NotNil(t, receipt)
+	}
+
+	next := func(sub *Subscription) *BlockUpdate {
+		select {
+		case u, ok := <-sub.Updates:
+			require.True(t, ok, "subscription stopped: %v", sub.Err())
+			return u
+		case <-time.After(5 * time.Second):
+			t.Fatal("no update")
+		}
+		return nil
+	}
+	u := next(full)
+	assert.Equal(t, 0, u.Seq)
+	assert.Equal(t, 1, u.Shard)
+	require.Equal(t, 1, len(u.Txs))
+	assert.Equal(t, txs[0].Hash(), u.Txs[0].Hash())
+	block, cerr := c.GetBlock(rosters[1].List[0], 1, 0, 0)
+	require.Nil(t, cerr)
+	assert.Equal(t, block.Hash(), u.Hash)
+
+	// both blocks of shard 0, only the second one matching the filter
+	u = next(filtered)
+	assert.Equal(t, 1, u.Size)
+	assert.Empty(t, u.Txs)
+	u = next(filtered)
+	assert.Equal(t, 1, u.Seq)
+	require.Equal(t, 1, len(u.Txs))
+	assert.Equal(t, txs[1].Hash(), u.Txs[0].Hash())
+	for _, sub := range []*Subscription{full, filtered} {
+		sub.Stop()
+		for range sub.Updates {
+		}
+		assert.Nil(t, sub.Err())
+	}
+
+	// not a member of the shard
+	other := c.Subscribe(rosters[0].List[2], 1, true)
+	_, ok := <-other.Updates
+	assert.False(t, ok)
+	assert.NotNil(t, other.Err())
+}
+
+func TestProto(t *testing.T) {
+	var buf bytes.Buffer
+	require.Nil(t, WriteProto(&buf))
+	if *writeProto {
+		require.Nil(t, ioutil.WriteFile("omniledger.proto", buf.Bytes(), 0644))
+	}
+	current, err := ioutil.ReadFile("omniledger.proto")
+	require.Nil(t, err)
+	assert.Equal(t, buf.String(), string(current),
+		"omniledger.proto is outdated, run go test -run TestProto -proto")
+}
@@ -0,0 +1,272 @@
+package shardkey
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/dedis/crypto.v0/random"
+	"gopkg.in/dedis/crypto.v0/share/dkg"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// DKG creates the distributed key of a shard among all the members of the
+// tree, which has to hold every member of the roster once.
+type DKG struct {
+	*generator
+	start  chan bool
+	onDone func(*dkg.DistKeyShare, error)
+}
+
+// NewDKG returns a new DKG instance passing the share of the member to
+// onDone at the end. It has to be registered by the members of the shard,
+// for example:
+//
+//	onet.GlobalProtocolRegister(shardkey.DKGName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+//		return shardkey.NewDKG(n, func(s *dkg.DistKeyShare, err error) {
+//			shares[n.ServerIdentity().ID] = s
+//		})
+//	})
+func NewDKG(n *onet.TreeNodeInstance, onDone func(*dkg.DistKeyShare, error)) (*DKG, error) {
+	g, err := newGenerator(n)
+	if err != nil {
+		return nil, err
+	}
+	return &DKG{generator: g, start: make(chan bool, 1), onDone: onDone}, nil
+}
+
+// Start starts the key generation on the root. The other members start when
+// they get the deal of the root.
+func (d *DKG) Start() error {
+	d.start <- true
+	return nil
+}
+
+// Dispatch runs the key generation and passes the result on.
+func (d *DKG) Dispatch() error {
+	defer d.Done()
+	if d.IsRoot() {
+		<-d.start
+	}
+	share, err := d.generate()
+	if err != nil {
+		log.Lvl2(d.Name(), "couldn't create the key:", err)
+	}
+	if d.onDone != nil {
+		d.onDone(share, err)
+	}
+	return err
+}
+
+// generator runs a distributed key generation among all the members of the
+// tree, for the protocols needing a new distributed key.
+type generator struct {
+	*onet.TreeNodeInstance
+
+	dealChan          chan dealChan
+	responseChan      chan responseChan
+	justificationChan chan justificationChan
+	secretCommitsChan chan secretCommitsChan
+	complaintChan     chan complaintChan
+	reconstructChan   chan reconstructChan
+
+	dkg *dkg.DistKeyGenerator
+	// dealt holds the dealers whose deal has been processed
+	dealt map[uint32]bool
+	// responses counts the processed responses of the other members
+	responses int
+	// pending are the responses and justifications waiting for the deal
+	// they are about
+	pending []interface{}
+}
+
+func newGenerator(n *onet.TreeNodeInstance) (*generator, error) {
+	g := &generator{
+		TreeNodeInstance: n,
+		dealt:            make(map[uint32]bool),
+	}
+	err := n.RegisterChannels(&g.dealChan, &g.responseChan,
+		&g.justificationChan, &g.secretCommitsChan, &g.complaintChan,
+		&g.reconstructChan)
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// generate runs both rounds of the key generation and returns the share of
+// the member.
+func (g *generator) generate() (*dkg.DistKeyShare, error) {
+	n := len(g.Roster().List)
+	d, err := dkg.NewDistKeyGenerator(g.Suite(), g.Private(),
+		g.Roster().Publics(), random.Stream, Threshold(n))
+	if err != nil {
+		return nil, err
+	}
+	g.dkg = d
+	deals, err := d.Deals()
+	if err != nil {
+		return nil, err
+	}
+	g.dealt[uint32(d.Index())] = true
+	for i, deal := range deals {
+		tn := g.member(i)
+		if tn == nil {
+			return nil, fmt.Errorf("member %d is not in the tree", i)
+		}
+		if err := g.SendTo(tn, &Deal{*deal}); err != nil {
+			return nil, err
+		}
+	}
+	if err := g.deal(); err != nil {
+		return nil, err
+	}
+	if err := g.commit(); err != nil {
+		return nil, err
+	}
+	return d.DistKeyShare()
+}
+
+// member returns the tree node of the member with the index in the roster.
+func (g *generator) member(i int) *onet.TreeNode {
+	for _, tn := range g.List() {
+		if tn.RosterIndex == i {
+			return tn
+		}
+	}
+	return nil
+}
+
+// deal is the first round: it processes the deals and the responses until
+// all members got the deals of the others and all their responses are in.
+// On a timeout, it goes on if enough deals are certified.
+func (g *generator) deal() error {
+	n := len(g.Roster().List)
+	timeout := time.After(Timeout)
+	for len(g.dealt) < n || g.responses < (n-1)*(n-1) {
+		select {
+		case msg := <-g.dealChan:
+			if err := g.handleDeal(&msg.Deal.Deal); err != nil {
+				return err
+			}
+		case msg := <-g.responseChan:
+			if err := g.handleResponse(&msg.Response.Response); err != nil {
+				return err
+			}
+		case msg := <-g.justificationChan:
+			g.handleJustification(&msg.Justification.Justification)
+		case <-timeout:
+			if !g.dkg.Certified() {
+				return errors.New("timeout before the deals were certified")
+			}
+			log.Lvl2(g.Name(), "going on with", len(g.dkg.QUAL()),
+				"certified deals")
+			return nil
+		}
+	}
+	return nil
+}
+
+// handleDeal processes the deal, broadcasts our response to it and then
+// processes the messages that were waiting for it.
+func (g *generator) handleDeal(deal *dkg.Deal) error {
+	resp, err := g.dkg.ProcessDeal(deal)
+	if err != nil {
+		log.Lvl2(g.Name(), "invalid deal of", deal.Index, ":", err)
+		return nil
+	}
+	g.dealt[deal.Index] = true
+	if err := g.Broadcast(&Response{*resp}); err != nil {
+		return err
+	}
+	pending := g.pending
+	g.pending = nil
+	for _, msg := range pending {
+		switch msg := msg.(type) {
+		case *dkg.Response:
+			if err := g.handleResponse(msg); err != nil {
+				return err
+			}
+		case *dkg.Justification:
+			g.handleJustification(msg)
+		}
+	}
+	return nil
+}
+
+// handleResponse processes the response, and broadcasts a justification if
+// it is a complaint about our deal.
+func (g *generator) handleResponse(resp *dkg.Response) error {
+	if !g.dealt[resp.Index] {
+		g.pending = append(g.pending, resp)
+		return nil
+	}
+	g.responses++
+	j, err := g.dkg.ProcessResponse(resp)
+	if err != nil {
+		log.Lvl2(g.Name(), "invalid response:", err)
+		return nil
+	}
+	if j == nil {
+		return nil
+	}
+	return g.Broadcast(&Justification{*j})
+}
+
+// handleJustification processes the justification of a dealer.
+func (g *generator) handleJustification(j *dkg.Justification) {
+	if !g.dealt[j.Index] {
+		g.pending = append(g.pending, j)
+		return
+	}
+	if err := g.dkg.ProcessJustification(j); err != nil {
+		log.Lvl2(g.Name(), "invalid justification:", err)
+	}
+}
+
+// commit is the second round: it reveals the commitments to our secret if
+// our deal is certified, and processes the ones of the others and the
+// complaints about them until the commitments of all qualified dealers are
+// known. A member that is done doesn't answer the complaints anymore, the
+// others are enough to recover the secrets.
+func (g *generator) commit() error {
+	if sc, err := g.dkg.SecretCommits(); err != nil {
+		log.Lvl2(g.Name(), "not revealing the commitments:", err)
+	} else if err := g.Broadcast(&SecretCommits{*sc}); err != nil {
+		return err
+	}
+	timeout := time.After(Timeout)
+	for !g.dkg.Finished() {
+		select {
+		case msg := <-g.secretCommitsChan:
+			cc, err := g.dkg.ProcessSecretCommits(&msg.SecretCommits.SecretCommits)
+			if err != nil {
+				log.Lvl2(g.Name(), "invalid commitments:", err)
+				continue
+			}
+			if cc != nil {
+				if err := g.Broadcast(&Complaint{*cc}); err != nil {
+					return err
+				}
+			}
+		case msg := <-g.complaintChan:
+			rc, err := g.dkg.ProcessComplaintCommits(&msg.Complaint.Complaint)
+			if err != nil {
+				log.Lvl2(g.Name(), "invalid complaint:", err)
+				continue
+			}
+			if err := g.Broadcast(&Reconstruct{*rc}); err != nil {
+				return err
+			}
+		case msg := <-g.reconstructChan:
+			err := g.dkg.ProcessReconstructCommits(&msg.Reconstruct.Reconstruct)
+			if err != nil {
+				log.Lvl2(g.Name(), "invalid reconstruction:", err)
+			}
+		case <-timeout:
+			return errors.New("timeout before getting all the commitments")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package shardkey
+
+import (
+	"gopkg.in/dedis/crypto.v0/share/dkg"
+	"gopkg.in/dedis/crypto.v0/share/dss"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func init() {
+	for _, i := range []interface{}{
+		Deal{},
+		Response{},
+		Justification{},
+		SecretCommits{},
+		Complaint{},
+		Reconstruct{},
+		SignRequest{},
+		PartialSig{},
+	} {
+		network.RegisterMessage(i)
+	}
+}
+
+// Deal is the encrypted deal of a member for the member it is sent to.
+type Deal struct {
+	Deal dkg.Deal
+}
+
+// Response is the response of a member to the deal of another member,
+// broadcast to all members.
+type Response struct {
+	Response dkg.Response
+}
+
+// Justification is broadcast by a dealer to answer a complaint about its
+// deal.
+type Justification struct {
+	Justification dkg.Justification
+}
+
+// SecretCommits holds the commitments to the secret of a dealer whose deal
+// is certified, broadcast to all members.
+type SecretCommits struct {
+	SecretCommits dkg.SecretCommits
+}
+
+// Complaint is broadcast by a member whose share doesn't match the
+// commitments of its dealer.
+type Complaint struct {
+	Complaint dkg.ComplaintCommits
+}
+
+// Reconstruct reveals the share of a member from a dealer that got a
+// complaint, so that the secret of the dealer can be recovered.
+type Reconstruct struct {
+	Reconstruct dkg.ReconstructCommits
+}
+
+// SignRequest is sent by the root to start signing the message.
+type SignRequest struct {
+	Msg []byte
+}
+
+// PartialSig is the partial signature of a member, sent to the root.
+type PartialSig struct {
+	Partial dss.PartialSig
+}
+
+type dealChan struct {
+	*onet.TreeNode
+	Deal
+}
+
+type responseChan struct {
+	*onet.TreeNode
+	Response
+}
+
+type justificationChan struct {
+	*onet.TreeNode
+	Justification
+}
+
+type secretCommitsChan struct {
+	*onet.TreeNode
+	SecretCommits
+}
+
+type complaintChan struct {
+	*onet.TreeNode
+	Complaint
+}
+
+type reconstructChan struct {
+	*onet.TreeNode
+	Reconstruct
+}
+
+type signRequestChan struct {
+	*onet.TreeNode
+	SignRequest
+}
+
+type partialSigChan struct {
+	*onet.TreeNode
+	PartialSig
+}
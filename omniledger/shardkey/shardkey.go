@@ -0,0 +1,59 @@
+package shardkey
+
+/*
+Shardkey gives every shard a single public key and lets it sign with it
+without any member ever knowing the private key, so that the other shards
+can verify its signatures without knowing who is in the shard.
+
+The key is created once per shard with the DKG protocol, a Pedersen
+distributed key generation over a flat tree where every member deals a
+secret to all the others:
+
+ 1. Every member sends its encrypted deals and broadcasts its responses to
+    the deals it gets.
+ 2. Once all the responses are in, the members whose deal is certified
+    reveal the commitments of their secret. Complaints about invalid ones
+    are broadcast and the secrets of their dealers recovered from the
+    shares.
+
+Every member ends up with the same public key and its own share of the
+private key. The Sign protocol then produces a t-of-n Schnorr signature on
+a message: the members run another DKG for the nonce, every member sends a
+partial signature to the root, and the root combines t of them. The
+signature is an EdDSA signature on the public key of the shard, see Verify.
+*/
+
+import (
+	"time"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/share/dss"
+	"gopkg.in/dedis/crypto.v0/share/vss"
+)
+
+// DKGName is the name the DKG protocol has to be registered with, see
+// NewDKG.
+const DKGName = "ShardKeyDKG"
+
+// SignName is the name the Sign protocol has to be registered with, see
+// NewSign.
+const SignName = "ShardKeySign"
+
+// Timeout is how long the members wait for the messages of a round.
+var Timeout = 20 * time.Second
+
+// Threshold returns the number of members out of n needed to sign: 2f+1,
+// but at least a majority, below which the DKG is not secure.
+func Threshold(n int) int {
+	t := 2*((n-1)/3) + 1
+	if min := vss.MinimumT(n); t < min {
+		t = min
+	}
+	return t
+}
+
+// Verify returns nil iff sig is the signature of the message by the shard
+// with the public key.
+func Verify(public abstract.Point, msg, sig []byte) error {
+	return dss.Verify(public, msg, sig)
+}
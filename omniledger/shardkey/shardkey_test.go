@@ -0,0 +1,137 @@
+package shardkey
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/share/dkg"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// shares holds the share of the distributed key of every server of the
+// test, once the DKG is done on it.
+var shares = struct {
+	sync.Mutex
+	m    map[network.ServerIdentityID]*dkg.DistKeyShare
+	done chan error
+}{m: make(map[network.ServerIdentityID]*dkg.DistKeyShare), done: make(chan error, 100)}
+
+// signDone gets a value every time a member is done with a Sign instance.
+var signDone = make(chan bool, 100)
+
+func TestMain(m *testing.M) {
+	onet.GlobalProtocolRegister(DKGName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return NewDKG(n, func(s *dkg.DistKeyShare, err error) {
+			shares.Lock()
+			shares.m[n.ServerIdentity().ID] = s
+			shares.Unlock()
+			shares.done <- err
+		})
+	})
+	onet.GlobalProtocolRegister(SignName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		shares.Lock()
+		defer shares.Unlock()
+		n.OnDoneCallback(func() bool {
+			signDone <- true
+			return true
+		})
+		return NewSign(n, shares.m[n.ServerIdentity().ID])
+	})
+	log.MainTest(m)
+}
+
+// generate runs the DKG on the roster and returns the shares of all members.
+func generate(t *testing.T, local *onet.LocalTest, roster *onet.Roster) []*dkg.DistKeyShare {
+	tree := roster.GenerateNaryTree(len(roster.List) - 1)
+	pi, err := local.CreateProtocol(DKGName, tree)
+	require.Nil(t, err)
+	require.Nil(t, pi.Start())
+	for range roster.List {
+		select {
+		case err := <-shares.done:
+			require.Nil(t, err)
+		case <-time.After(Timeout):
+			t.Fatal("DKG didn't finish")
+		}
+	}
+	shares.Lock()
+	defer shares.Unlock()
+	var ret []*dkg.DistKeyShare
+	for _, si := range roster.List {
+		ret = append(ret, shares.m[si.ID])
+	}
+	return ret
+}
+
+// sign signs the message with the distributed key of the roster.
+func sign(t *testing.T, local *onet.LocalTest, roster *onet.Roster, msg []byte) []byte {
+	tree := roster.GenerateNaryTree(len(roster.List) - 1)
+	pi, err := local.CreateProtocol(SignName, tree)
+	require.Nil(t, err)
+	s := pi.(*Sign)
+	s.Msg = msg
+	type result struct {
+		sig []byte
+		err error
+	}
+	done := make(chan result, 1)
+	s.RegisterOnSignature(func(sig []byte, err error) {
+		done <- result{sig, err}
+	})
+	require.Nil(t, s.Start())
+	res := <-done
+	require.Nil(t, res.err)
+	// the root doesn't wait for all the partial signatures
+	for range roster.List {
+		<-signDone
+	}
+	return res.sig
+}
+
+func TestThreshold(t *testing.T) {
+	assert.Equal(t, 2, Threshold(3))
+	assert.Equal(t, 3, Threshold(4))
+	assert.Equal(t, 5, Threshold(7))
+	assert.Equal(t, 5, Threshold(8))
+}
+
+func TestDKG(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(5, false)
+	keys := generate(t, local, roster)
+	public := keys[0].Public()
+	indices := make(map[int]bool)
+	for _, k := range keys {
+		require.NotNil(t, k)
+		assert.True(t, public.Equal(k.Public()))
+		indices[k.Share.I] = true
+	}
+	assert.Equal(t, len(keys), len(indices))
+}
+
+func TestSign(t *testing.T) {
+	for _, n := range []int{3, 4, 7} {
+		local := onet.NewLocalTest()
+		_, roster, _ := local.GenTree(n, false)
+		keys := generate(t, local, roster)
+		public := keys[0].Public()
+
+		msg := []byte("block header")
+		sig := sign(t, local, roster, msg)
+		require.Nil(t, Verify(public, msg, sig))
+		assert.NotNil(t, Verify(public, []byte("another header"), sig))
+		assert.NotNil(t, Verify(roster.Aggregate, msg, sig))
+
+		// a new nonce gives a new signature
+		other := sign(t, local, roster, msg)
+		require.Nil(t, Verify(public, msg, other))
+		assert.NotEqual(t, sig, other)
+		local.CloseAll()
+	}
+}
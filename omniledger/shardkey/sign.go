@@ -0,0 +1,127 @@
+package shardkey
+
+import (
+	"errors"
+	"time"
+
+	"gopkg.in/dedis/crypto.v0/share/dkg"
+	"gopkg.in/dedis/crypto.v0/share/dss"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// Sign signs a message with the distributed key of the shard. The members
+// create a distributed nonce, and the root combines the partial signatures
+// of Threshold of them.
+type Sign struct {
+	*generator
+	// Msg is the message to sign, set on the root
+	Msg []byte
+
+	long            *dkg.DistKeyShare
+	signRequestChan chan signRequestChan
+	partialSigChan  chan partialSigChan
+	start           chan bool
+	onSignature     func([]byte, error)
+}
+
+// NewSign returns a new Sign instance using the share of the distributed
+// key of the member. It has to be registered by the members of the shard,
+// for example:
+//
+//	onet.GlobalProtocolRegister(shardkey.SignName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+//		return shardkey.NewSign(n, shares[n.ServerIdentity().ID])
+//	})
+func NewSign(n *onet.TreeNodeInstance, long *dkg.DistKeyShare) (*Sign, error) {
+	if long == nil {
+		return nil, errors.New("no share of the distributed key")
+	}
+	g, err := newGenerator(n)
+	if err != nil {
+		return nil, err
+	}
+	s := &Sign{
+		generator: g,
+		long:      long,
+		start:     make(chan bool, 1),
+	}
+	if err := n.RegisterChannels(&s.signRequestChan, &s.partialSigChan); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// RegisterOnSignature sets the function called on the root with the
+// signature, or the reason there is none.
+func (s *Sign) RegisterOnSignature(fn func([]byte, error)) {
+	s.onSignature = fn
+}
+
+// Start sends the message to the members and starts creating the nonce.
+func (s *Sign) Start() error {
+	if len(s.Msg) == 0 {
+		return errors.New("no message to sign")
+	}
+	if err := s.Broadcast(&SignRequest{Msg: s.Msg}); err != nil {
+		return err
+	}
+	s.start <- true
+	return nil
+}
+
+// Dispatch creates the nonce, then sends the partial signature of the
+// member to the root, which combines them.
+func (s *Sign) Dispatch() error {
+	defer s.Done()
+	if s.IsRoot() {
+		<-s.start
+	}
+	nonce, err := s.generate()
+	if err != nil {
+		return s.finish(nil, err)
+	}
+	if !s.IsRoot() {
+		select {
+		case msg := <-s.signRequestChan:
+			s.Msg = msg.Msg
+		case <-time.After(Timeout):
+			return errors.New("didn't get the message")
+		}
+	}
+	n := len(s.Roster().List)
+	d, err := dss.NewDSS(s.Suite(), s.Private(), s.Roster().Publics(), s.long,
+		nonce, s.Msg, Threshold(n))
+	if err != nil {
+		return s.finish(nil, err)
+	}
+	ps, err := d.PartialSig()
+	if err != nil {
+		return s.finish(nil, err)
+	}
+	if !s.IsRoot() {
+		return s.SendTo(s.Root(), &PartialSig{*ps})
+	}
+	timeout := time.After(Timeout)
+	for !d.EnoughPartialSig() {
+		select {
+		case msg := <-s.partialSigChan:
+			if err := d.ProcessPartialSig(&msg.Partial); err != nil {
+				log.Lvl2(s.Name(), "invalid partial signature:", err)
+			}
+		case <-timeout:
+			return s.finish(nil, errors.New("timeout while waiting for the partial signatures"))
+		}
+	}
+	return s.finish(d.Signature())
+}
+
+// finish passes the signature on if we are the root.
+func (s *Sign) finish(sig []byte, err error) error {
+	if !s.IsRoot() {
+		return err
+	}
+	if s.onSignature != nil {
+		s.onSignature(sig, err)
+	}
+	return err
+}
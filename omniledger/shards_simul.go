@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/safety"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/workload"
+	"github.com/dedis/paper_17_sosp_omniledger/profile"
+	"github.com/dedis/paper_17_sosp_omniledger/seed"
+	"github.com/dedis/paper_17_sosp_omniledger/soak"
+	"github.com/dedis/paper_17_sosp_omniledger/suites"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+	"gopkg.in/dedis/onet.v1/simul/monitor"
+)
+
+func init() {
+	onet.SimulationRegister("OmniShards", NewShardsSimulation)
+	network.RegisterMessage(ShardBlock{})
+}
+
+// ShardBlock is the block of transactions a shard signs in the shards
+// simulation.
+type ShardBlock struct {
+	Shard int
+	Txs   []atomix.Transaction
+}
+
+// Hash returns the hash signed by the shard.
+func (b *ShardBlock) Hash() []byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "shard/%d", b.Shard)
+	for i := range b.Txs {
+		h.Write(b.Txs[i].Hash())
+	}
+	return h.Sum(nil)
+}
+
+// ShardsSimulation measures how the throughput scales out with the number
+// of shards. The hosts are split into disjoint shards, and every round all
+// shards concurrently sign a block of BlockSize transactions of their own.
+// The throughput of every shard and of all shards together is recorded, so
+// one run over a growing number of shards gives the total throughput by
+// number of shards.
+type ShardsSimulation struct {
+	onet.SimulationBFTree
+	// Shards is the number of shards the hosts are split into
+	Shards int
+	// BlockSize is the number of transactions of the block of a shard
+	BlockSize int
+	// GlobalSeed seeds the random streams of the simulation, so that a run
+	// can be reproduced, see package seed. The streams are seeded from the
+	// clock if it is 0.
+	GlobalSeed int64
+	// Suite is the cipher suite of the keys and the signatures, "ed25519"
+	// if empty, see package suites.
+	Suite string
+	// Profile writes a CPU profile of every round and a heap profile after
+	// it to the profiles directory of the simulation, see package profile.
+	Profile bool
+	// Soak samples the memory and the goroutines every soak.Every rounds
+	// and fails the run if they grow without bound, see package soak. Rounds
+	// should then be in the thousands.
+	Soak bool
+	// Config rejects the shard counts that give unsafe shards
+	safety.Config
+}
+
+// NewShardsSimulation is used internally to register the simulation (see
+// the init() function above).
+func NewShardsSimulation(config string) (onet.Simulation, error) {
+	ss := &ShardsSimulation{
+		Shards:    1,
+		BlockSize: 100,
+	}
+	_, err := toml.Decode(config, ss)
+	if err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// Setup implements onet.Simulation.
+func (s *ShardsSimulation) Setup(dir string, hosts []string) (
+	*onet.SimulationConfig, error) {
+	if err := suites.Setup(dir, s.Suite); err != nil {
+		return nil, err
+	}
+	sc := &onet.SimulationConfig{}
+	s.CreateRoster(sc, hosts, 2000)
+	seed.Use(s.GlobalSeed)
+	seed.Keys(sc)
+	if err := s.Check(len(sc.Roster.List), s.Shards); err != nil {
+		return nil, err
+	}
+	err := s.CreateTree(sc)
+	if err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// Run implements onet.Simulation.
+func (s *ShardsSimulation) Run(config *onet.SimulationConfig) error {
+	rosters, err := shardRosters(config.Roster, s.Shards)
+	if err != nil {
+		return err
+	}
+	// every transaction spends one output and creates one, so the
+	// outputs never run out
+	gen, err := workload.New(workload.Config{
+		Shards:  s.Shards,
+		Outputs: s.BlockSize,
+		Seed:    s.GlobalSeed,
+	})
+	if err != nil {
+		return err
+	}
+	log.Lvl1("Running", s.Rounds, "rounds on", s.Shards, "shards with blocks of",
+		s.BlockSize, "transactions")
+	profile.Use(s.Profile)
+	defer profile.EndRound()
+	soak.Use(s.Soak)
+	for r := 0; r < s.Rounds; r++ {
+		blocks, err := s.blocks(gen)
+		if err != nil {
+			return err
+		}
+		start := time.Now()
+		profile.StartRound(config.Server.ServerIdentity, r)
+		round := monitor.NewTimeMeasure("round")
+		err = runShards(s.Shards, func(shard int) error {
+			shardStart := time.Now()
+			data, err := network.Marshal(blocks[shard])
+			if err != nil {
+				return err
+			}
+			_, err = cosign(config, s.BF, rosters[shard], blocks[shard].Hash(),
+				data)
+			if err != nil {
+				return err
+			}
+			monitor.RecordSingleMeasure("shard_tps",
+				float64(len(blocks[shard].Txs))/time.Since(shardStart).Seconds())
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		round.Record()
+		profile.EndRound()
+		monitor.RecordSingleMeasure("tps",
+			float64(s.Shards*s.BlockSize)/time.Since(start).Seconds())
+		log.Lvl2("Round", r, "signed by", s.Shards, "shards")
+		soak.EndRound(r)
+	}
+	return soak.Check()
+}
+
+// blocks returns the next block of every shard.
+func (s *ShardsSimulation) blocks(gen *workload.Generator) ([]*ShardBlock, error) {
+	blocks := make([]*ShardBlock, s.Shards)
+	for i := range blocks {
+		txs, err := gen.ShardBlock(i, s.BlockSize)
+		if err != nil {
+			return nil, err
+		}
+		blocks[i] = &ShardBlock{Shard: i, Txs: txs}
+	}
+	return blocks, nil
+}
+
+// shardRosters splits the hosts but the root into disjoint shards. The root
+// of the simulation leads all shards, so it is also the first member of
+// every shard.
+func shardRosters(roster *onet.Roster, shards int) ([]*onet.Roster, error) {
+	if shards <= 0 || len(roster.List)-1 < shards {
+		return nil, errors.New("need at least one host per shard besides the root")
+	}
+	lists := make([][]*network.ServerIdentity, shards)
+	for i := range lists {
+		lists[i] = []*network.ServerIdentity{roster.List[0]}
+	}
+	for i, si := range roster.List[1:] {
+		lists[i%shards] = append(lists[i%shards], si)
+	}
+	rosters := make([]*onet.Roster, shards)
+	for i, list := range lists {
+		rosters[i] = onet.NewRoster(list)
+	}
+	return rosters, nil
+}
+
+// runShards runs f for all shards concurrently and returns the first error.
+func runShards(shards int, f func(shard int) error) error {
+	errs := make([]error, shards)
+	var wg sync.WaitGroup
+	for shard := 0; shard < shards; shard++ {
+		wg.Add(1)
+		go func(shard int) {
+			defer wg.Done()
+			errs[shard] = f(shard)
+		}(shard)
+	}
+	wg.Wait()
+	for shard, err := range errs {
+		if err != nil {
+			return fmt.Errorf("shard %d: %v", shard, err)
+		}
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/BurntSushi/toml"
 	"github.com/dedis/paper_17_sosp_omniledger/omniledger/skipchain"
+	"github.com/dedis/paper_17_sosp_omniledger/suites"
 	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/app"
 	"gopkg.in/dedis/onet.v1/log"
@@ -289,5 +290,7 @@ type OmniBlockTrans struct {
 }
 
 func main() {
+	// the suite of the keys of the simulation file, see package suites
+	log.ErrFatal(suites.Load())
 	simul.Start()
 }
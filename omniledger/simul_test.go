@@ -14,3 +14,7 @@ func TestMain(m *testing.M) {
 func TestSimulation(t *testing.T) {
 	simul.Start("simple.toml")
 }
+
+func TestEpochSimulation(t *testing.T) {
+	simul.Start("epoch.toml")
+}
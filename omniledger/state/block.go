@@ -0,0 +1,175 @@
+// Package state implements the state blocks of the shards. At every epoch
+// boundary, the validators of a shard sign a state block that commits to the
+// unspent outputs of the shard with a Merkle root and points to the previous
+// state block. A new validator only needs the chain of state blocks and the
+// outputs of the latest one to catch up, instead of replaying all the
+// transactions of the shard since its start.
+package state
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/sign"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func init() {
+	network.RegisterMessage(Block{})
+}
+
+// UTXO is an unspent output of the shard.
+type UTXO struct {
+	ID    string
+	Value int64
+}
+
+// Signature is the signature of the state block by the member Index of the
+// roster of the shard.
+type Signature struct {
+	Index int
+	Sig   []byte
+}
+
+// Block is a state block of a shard. The header is everything but the
+// outputs and the signatures: the outputs are committed to by Root, so a
+// block can be passed around without them.
+type Block struct {
+	// Shard is the index of the shard
+	Shard int
+	// Epoch is the epoch the state block starts
+	Epoch int
+	// Previous is the hash of the previous state block of the shard, empty
+	// for the first one
+	Previous []byte
+	// Root is the Merkle root of the outputs
+	Root []byte
+	// UTXOs are the unspent outputs, sorted by ID. They can be left out.
+	UTXOs []UTXO
+	// Signatures are from at least 2f+1 members of the shard in the epoch
+	Signatures []Signature
+}
+
+// NewBlock returns the unsigned state block of the shard for the epoch with
+// the given unspent outputs. prev is the previous state block of the shard,
+// nil for the first one.
+func NewBlock(shard, epoch int, prev *Block, utxos map[string]int64) (*Block, error) {
+	b := &Block{
+		Shard:    shard,
+		Epoch:    epoch,
+		Previous: []byte{},
+	}
+	if prev != nil {
+		if prev.Shard != shard {
+			return nil, errors.New("previous block of another shard")
+		}
+		if prev.Epoch >= epoch {
+			return nil, errors.New("previous block is not older")
+		}
+		b.Previous = prev.Hash()
+	}
+	for id, value := range utxos {
+		b.UTXOs = append(b.UTXOs, UTXO{ID: id, Value: value})
+	}
+	sort.Slice(b.UTXOs, func(i, j int) bool {
+		return b.UTXOs[i].ID < b.UTXOs[j].ID
+	})
+	b.Root = Root(b.UTXOs)
+	return b, nil
+}
+
+// Hash returns the hash of the header, which identifies the block and is
+// signed by the members of the shard.
+func (b *Block) Hash() []byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "state/%d/%d/%x/%x", b.Shard, b.Epoch, b.Previous, b.Root)
+	return h.Sum(nil)
+}
+
+// Header returns the block without the outputs.
+func (b *Block) Header() *Block {
+	header := *b
+	header.UTXOs = nil
+	return &header
+}
+
+// Map returns the outputs of the block.
+func (b *Block) Map() map[string]int64 {
+	utxos := make(map[string]int64)
+	for _, u := range b.UTXOs {
+		utxos[u.ID] = u.Value
+	}
+	return utxos
+}
+
+// Sign adds the signature of the member index of the shard.
+func (b *Block) Sign(suite abstract.Suite, index int, private abstract.Scalar) error {
+	sig, err := sign.Schnorr(suite, private, b.Hash())
+	if err != nil {
+		return err
+	}
+	b.Signatures = append(b.Signatures, Signature{Index: index, Sig: sig})
+	return nil
+}
+
+// threshold returns the number of signatures needed with n members: 2f+1.
+func threshold(n int) int {
+	return n - (n-1)/3
+}
+
+// Verify checks that the block is signed by 2f+1 members of the roster of
+// the shard, that it follows prev, which is nil for the first block, and
+// that its outputs, if any, match the Merkle root.
+func (b *Block) Verify(roster *onet.Roster, prev *Block) error {
+	if prev == nil {
+		if len(b.Previous) != 0 {
+			return errors.New("first block points to a previous one")
+		}
+	} else {
+		if prev.Shard != b.Shard {
+			return errors.New("previous block of another shard")
+		}
+		if prev.Epoch >= b.Epoch {
+			return errors.New("previous block is not older")
+		}
+		if !bytes.Equal(b.Previous, prev.Hash()) {
+			return errors.New("doesn't point to the previous block")
+		}
+	}
+	if len(b.UTXOs) > 0 && !bytes.Equal(Root(b.UTXOs), b.Root) {
+		return errors.New("outputs don't match the root")
+	}
+	for i := 1; i < len(b.UTXOs); i++ {
+		if b.UTXOs[i-1].ID >= b.UTXOs[i].ID {
+			return errors.New("outputs are not sorted")
+		}
+	}
+	return b.VerifySignatures(roster)
+}
+
+// VerifySignatures checks that 2f+1 distinct members of the roster signed
+// the block, without checking its outputs nor its place in the chain.
+func (b *Block) VerifySignatures(roster *onet.Roster) error {
+	hash := b.Hash()
+	signers := make(map[int]bool)
+	for _, s := range b.Signatures {
+		if s.Index < 0 || s.Index >= len(roster.List) || signers[s.Index] {
+			continue
+		}
+		if sign.VerifySchnorr(network.Suite, roster.List[s.Index].Public,
+			hash, s.Sig) != nil {
+			continue
+		}
+		signers[s.Index] = true
+	}
+	if len(signers) < threshold(len(roster.List)) {
+		return fmt.Errorf("only %d valid signatures out of %d needed",
+			len(signers), threshold(len(roster.List)))
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func init() {
+	for _, i := range []interface{}{
+		StateRequest{},
+		StateReply{},
+	} {
+		network.RegisterMessage(i)
+	}
+}
+
+// BootstrapName is the name of the protocol a new member of a shard uses to
+// catch up with a member of the shard. It has to be registered by the
+// validators with their Ledger and the RosterFunc of the shards, for
+// example:
+//
+//	onet.GlobalProtocolRegister(state.BootstrapName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+//		return state.NewBootstrap(n, ledger, rosters)
+//	})
+const BootstrapName = "StateBootstrap"
+
+// BootstrapTimeout is how long a new member waits for the state of the
+// shard.
+var BootstrapTimeout = 10 * time.Second
+
+// CreateFunc creates a protocol instance on the tree, for example
+// onet.LocalTest.CreateProtocol.
+type CreateFunc func(name string, t *onet.Tree) (onet.ProtocolInstance, error)
+
+// StateRequest asks a member for the state of the shard.
+type StateRequest struct {
+	Shard int
+}
+
+// StateReply holds the headers of all state blocks of the shard and the
+// latest block with its outputs.
+type StateReply struct {
+	Headers []*Block
+	Latest  *Block
+	Err     string
+}
+
+type stateRequestChan struct {
+	*onet.TreeNode
+	StateRequest
+}
+
+type stateReplyChan struct {
+	*onet.TreeNode
+	StateReply
+}
+
+// Bootstrap runs between a new member of a shard, the root, and a member of
+// the shard, its only child. The member sends the headers of the state
+// blocks back to the first one and the outputs of the latest one. The new
+// member checks the signatures of the chain of headers, the outputs against
+// the root of the latest block, and then starts its ledger with them.
+type Bootstrap struct {
+	*onet.TreeNodeInstance
+
+	ledger      *Ledger
+	rosters     RosterFunc
+	requestChan chan stateRequestChan
+	replyChan   chan stateReplyChan
+	onDone      func(map[string]int64, error)
+}
+
+// NewBootstrap returns a new instance serving, or filling on the root, the
+// ledger. The rosters are used by the root to check the state blocks.
+func NewBootstrap(n *onet.TreeNodeInstance, l *Ledger, rosters RosterFunc) (*Bootstrap, error) {
+	b := &Bootstrap{
+		TreeNodeInstance: n,
+		ledger:           l,
+		rosters:          rosters,
+	}
+	if err := b.RegisterChannels(&b.requestChan, &b.replyChan); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// RegisterOnDone sets the function called on the root with the outputs of
+// the latest state block, or the reason the catch up failed.
+func (b *Bootstrap) RegisterOnDone(fn func(map[string]int64, error)) {
+	b.onDone = fn
+}
+
+// Start asks the member for the state of the shard.
+func (b *Bootstrap) Start() error {
+	if len(b.Children()) != 1 {
+		return errors.New("need exactly one member to catch up with")
+	}
+	if b.ledger == nil || b.ledger.Chain.Latest() != nil {
+		return errors.New("need an empty ledger to catch up")
+	}
+	return b.SendToChildren(&StateRequest{Shard: b.ledger.Chain.Shard})
+}
+
+// Dispatch answers the request on the member, and checks the state of the
+// shard on the root.
+func (b *Bootstrap) Dispatch() error {
+	defer b.Done()
+	timeout := time.After(BootstrapTimeout)
+	if !b.IsRoot() {
+		select {
+		case msg := <-b.requestChan:
+			return b.SendToParent(b.reply(msg.Shard))
+		case <-timeout:
+			return errors.New("didn't get the request")
+		}
+	}
+	var utxos map[string]int64
+	var err error
+	select {
+	case msg := <-b.replyChan:
+		if msg.Err != "" {
+			err = errors.New(msg.Err)
+		} else {
+			utxos, err = b.ledger.Bootstrap(msg.Headers, msg.Latest, b.rosters)
+		}
+	case <-timeout:
+		err = errors.New("didn't get the state")
+	}
+	if err != nil {
+		log.Lvl2(b.Name(), "couldn't catch up:", err)
+	}
+	if b.onDone != nil {
+		b.onDone(utxos, err)
+	}
+	return err
+}
+
+// reply returns the state the member knows of the shard.
+func (b *Bootstrap) reply(shard int) *StateReply {
+	if b.ledger == nil || b.ledger.Chain.Shard != shard {
+		return &StateReply{Err: fmt.Sprintf("not a member of shard %d", shard)}
+	}
+	latest := b.ledger.Chain.Latest()
+	if latest == nil {
+		return &StateReply{Err: "no state block yet"}
+	}
+	headers, err := b.ledger.Chain.Since(nil)
+	if err != nil {
+		return &StateReply{Err: err.Error()}
+	}
+	return &StateReply{Headers: headers, Latest: latest}
+}
+
+// Bootstrap starts an empty ledger with the headers of the state blocks
+// from the first one of the shard and the latest block with its outputs,
+// once they have been checked with CatchUp. It returns the outputs of the
+// latest block.
+func (l *Ledger) Bootstrap(headers []*Block, latest *Block,
+	rosters RosterFunc) (map[string]int64, error) {
+	if latest == nil {
+		return nil, errors.New("no latest block")
+	}
+	utxos, err := CatchUp(nil, headers, latest, rosters)
+	if err != nil {
+		return nil, err
+	}
+	if latest.Shard != l.Chain.Shard {
+		return nil, fmt.Errorf("state of shard %d", latest.Shard)
+	}
+	blocks := make([]*Block, len(headers))
+	for i, h := range headers {
+		blocks[i] = h.Header()
+	}
+	blocks[len(blocks)-1] = latest
+	l.Chain.mutex.Lock()
+	defer l.Chain.mutex.Unlock()
+	if len(l.Chain.blocks) > 0 {
+		return nil, errors.New("ledger is not empty")
+	}
+	l.Chain.blocks = blocks
+	l.mutex.Lock()
+	l.states[latest.Epoch] = latest
+	l.mutex.Unlock()
+	return utxos, nil
+}
+
+// CatchUpWith lets the new member self of the shard catch up with the
+// members of the roster, trying them in turn until one of them gives a
+// valid state. l is the ledger the protocol is registered with on self. It
+// returns the outputs of the latest state block, which are also in l.
+func CatchUpWith(create CreateFunc, self *network.ServerIdentity,
+	members *onet.Roster, l *Ledger) (map[string]int64, error) {
+	if l.Chain.Latest() != nil {
+		return nil, errors.New("need an empty ledger to catch up")
+	}
+	err := errors.New("no member to catch up with")
+	for _, si := range members.List {
+		if si.ID.Equal(self.ID) {
+			continue
+		}
+		var utxos map[string]int64
+		utxos, err = catchUpWith(create, self, si)
+		if err == nil {
+			return utxos, nil
+		}
+		log.Lvl2("Couldn't catch up with", si, ":", err)
+	}
+	return nil, err
+}
+
+// catchUpWith runs the Bootstrap protocol with the member.
+func catchUpWith(create CreateFunc, self,
+	member *network.ServerIdentity) (map[string]int64, error) {
+	roster := onet.NewRoster([]*network.ServerIdentity{self, member})
+	pi, err := create(BootstrapName, roster.GenerateNaryTree(1))
+	if err != nil {
+		return nil, err
+	}
+	p, ok := pi.(*Bootstrap)
+	if !ok {
+		return nil, errors.New("protocol " + BootstrapName + " is not bootstrap")
+	}
+	type result struct {
+		utxos map[string]int64
+		err   error
+	}
+	done := make(chan result, 1)
+	p.RegisterOnDone(func(utxos map[string]int64, err error) {
+		done <- result{utxos, err}
+	})
+	if err := p.Start(); err != nil {
+		return nil, err
+	}
+	res := <-done
+	return res.utxos, res.err
+}
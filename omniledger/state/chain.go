@@ -0,0 +1,141 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+	"gopkg.in/dedis/onet.v1"
+)
+
+// RosterFunc returns the roster of the shard in the epoch, which signs the
+// state block of the epoch.
+type RosterFunc func(shard, epoch int) (*onet.Roster, error)
+
+// Chain is the chain of state blocks of a shard kept by its validators. Only
+// the latest block holds its outputs, the older ones are kept as headers.
+type Chain struct {
+	// Shard is the index of the shard
+	Shard int
+
+	mutex  sync.Mutex
+	blocks []*Block
+}
+
+// NewChain returns an empty chain for the shard.
+func NewChain(shard int) *Chain {
+	return &Chain{Shard: shard}
+}
+
+// Append verifies the block against the latest one and appends it.
+func (c *Chain) Append(b *Block, roster *onet.Roster) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if b.Shard != c.Shard {
+		return fmt.Errorf("block of shard %d", b.Shard)
+	}
+	var prev *Block
+	if len(c.blocks) > 0 {
+		prev = c.blocks[len(c.blocks)-1]
+	}
+	if err := b.Verify(roster, prev); err != nil {
+		return err
+	}
+	if prev != nil {
+		c.blocks[len(c.blocks)-1] = prev.Header()
+	}
+	c.blocks = append(c.blocks, b)
+	return nil
+}
+
+// Latest returns the latest state block with its outputs, or nil if there
+// is none yet.
+func (c *Chain) Latest() *Block {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.blocks) == 0 {
+		return nil
+	}
+	return c.blocks[len(c.blocks)-1]
+}
+
+// Since returns the headers of the blocks after the block with the given
+// hash, or of all blocks if hash is empty. A new validator trusting that
+// block passes them to CatchUp with the latest block.
+func (c *Chain) Since(hash []byte) ([]*Block, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	start := 0
+	if len(hash) > 0 {
+		start = -1
+		for i, b := range c.blocks {
+			if bytes.Equal(b.Hash(), hash) {
+				start = i + 1
+			}
+		}
+		if start < 0 {
+			return nil, errors.New("unknown block")
+		}
+	}
+	var headers []*Block
+	for _, b := range c.blocks[start:] {
+		headers = append(headers, b.Header())
+	}
+	return headers, nil
+}
+
+// CatchUp checks the headers of the state blocks following trusted, which
+// is nil to start from the first block of the shard, and returns the outputs
+// of latest, the block of the last header. Every block must be signed by the
+// roster of the shard in its epoch.
+func CatchUp(trusted *Block, headers []*Block, latest *Block,
+	rosters RosterFunc) (map[string]int64, error) {
+	if len(headers) == 0 {
+		return nil, errors.New("no state block")
+	}
+	prev := trusted
+	for _, b := range headers {
+		roster, err := rosters(b.Shard, b.Epoch)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.Verify(roster, prev); err != nil {
+			return nil, fmt.Errorf("state block of epoch %d: %v", b.Epoch, err)
+		}
+		prev = b
+	}
+	if !bytes.Equal(latest.Hash(), prev.Hash()) {
+		return nil, errors.New("latest block is not the last one")
+	}
+	if !bytes.Equal(Root(latest.UTXOs), latest.Root) {
+		return nil, errors.New("outputs don't match the root")
+	}
+	return latest.Map(), nil
+}
+
+// Replay applies the transactions committed since the state block to its
+// outputs, so that a new validator gets the current state of the shard.
+func Replay(shard int, utxos map[string]int64, txs []*atomix.Transaction) error {
+	for _, tx := range txs {
+		if err := tx.Verify(); err != nil {
+			return err
+		}
+		for _, in := range tx.Inputs {
+			if in.Shard != shard {
+				continue
+			}
+			if value, ok := utxos[in.ID]; !ok || value != in.Value {
+				return fmt.Errorf("input %s is unknown or spent", in.ID)
+			}
+			delete(utxos, in.ID)
+		}
+		for _, out := range tx.Outputs {
+			if out.Shard == shard {
+				utxos[out.ID] = out.Value
+			}
+		}
+	}
+	return nil
+}
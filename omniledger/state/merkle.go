@@ -0,0 +1,52 @@
+package state
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+)
+
+// leaf returns the hash of the output in the Merkle tree.
+func leaf(u UTXO) crypto.HashID {
+	h := sha256.New()
+	fmt.Fprintf(h, "utxo/%s/%d", u.ID, u.Value)
+	return h.Sum(nil)
+}
+
+// Root returns the Merkle root of the outputs, empty if there are none.
+func Root(utxos []UTXO) []byte {
+	root, _ := tree(utxos)
+	return root
+}
+
+// tree returns the Merkle root of the outputs and the proof of every output.
+func tree(utxos []UTXO) ([]byte, []crypto.Proof) {
+	leaves := make([]crypto.HashID, len(utxos))
+	for i, u := range utxos {
+		leaves[i] = leaf(u)
+	}
+	root, proofs := crypto.ProofTree(sha256.New, leaves)
+	return []byte(root), proofs
+}
+
+// Prove returns the proof that the output id is in the block, so that it
+// can be checked against the header alone. The block must hold its outputs.
+func (b *Block) Prove(id string) (*UTXO, crypto.Proof, error) {
+	_, proofs := tree(b.UTXOs)
+	for i, u := range b.UTXOs {
+		if u.ID == id {
+			return &u, proofs[i], nil
+		}
+	}
+	return nil, nil, errors.New("unknown output " + id)
+}
+
+// VerifyUTXO checks that the output is in the state committed to by root.
+func VerifyUTXO(root []byte, u *UTXO, proof crypto.Proof) error {
+	if !proof.Check(sha256.New, root, leaf(*u)) {
+		return errors.New("output not in the state")
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package state
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+	"gopkg.in/dedis/onet.v1"
+)
+
+// TxBlock is a block of transactions committed by a shard during an epoch,
+// applied on top of the state block of the epoch.
+type TxBlock struct {
+	// Shard is the index of the shard
+	Shard int
+	// Epoch is the epoch the block is committed in
+	Epoch int
+	Txs   []atomix.Transaction
+}
+
+// Hash returns the hash of the block.
+func (b *TxBlock) Hash() []byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "txblock/%d/%d", b.Shard, b.Epoch)
+	for i := range b.Txs {
+		h.Write(b.Txs[i].Hash())
+	}
+	return h.Sum(nil)
+}
+
+// Ledger is the ledger a validator keeps of its shard: the chain of state
+// blocks and the transaction blocks of every epoch.
+//
+// In pruning mode, once the state block of an epoch is signed, the
+// transaction blocks and the outputs of the state blocks older than Keep
+// epochs are deleted. The signed state blocks act as checkpoint
+// certificates: the chain of their headers is always retained, so the
+// ledger can still be verified from the first block, and inclusion proofs
+// can be answered for the retained epochs.
+type Ledger struct {
+	// Chain holds the headers of all state blocks
+	Chain *Chain
+	// Keep is the number of epochs retained in pruning mode, 0 to keep
+	// everything
+	Keep int
+
+	mutex sync.Mutex
+	// states are the state blocks of the retained epochs, with their
+	// outputs
+	states map[int]*Block
+	// txs are the transaction blocks of the retained epochs
+	txs map[int][]*TxBlock
+}
+
+// NewLedger returns an empty ledger for the shard, retaining keep epochs,
+// or everything if keep is 0.
+func NewLedger(shard, keep int) *Ledger {
+	return &Ledger{
+		Chain:  NewChain(shard),
+		Keep:   keep,
+		states: make(map[int]*Block),
+		txs:    make(map[int][]*TxBlock),
+	}
+}
+
+// AppendState appends the signed state block of a new epoch to the chain.
+// In pruning mode, the epochs that are now too old are pruned.
+func (l *Ledger) AppendState(b *Block, roster *onet.Roster) error {
+	if len(b.UTXOs) == 0 && len(b.Root) > 0 {
+		return errors.New("state block without its outputs")
+	}
+	if err := l.Chain.Append(b, roster); err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	l.states[b.Epoch] = b
+	l.mutex.Unlock()
+	if l.Keep > 0 {
+		l.Prune(l.Keep)
+	}
+	return nil
+}
+
+// AppendTxs adds a transaction block of the epoch of the latest state
+// block.
+func (l *Ledger) AppendTxs(b *TxBlock) error {
+	latest := l.Chain.Latest()
+	if latest == nil {
+		return errors.New("no state block yet")
+	}
+	if b.Shard != l.Chain.Shard {
+		return fmt.Errorf("block of shard %d", b.Shard)
+	}
+	if b.Epoch != latest.Epoch {
+		return fmt.Errorf("block of epoch %d while in epoch %d", b.Epoch,
+			latest.Epoch)
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.txs[b.Epoch] = append(l.txs[b.Epoch], b)
+	return nil
+}
+
+// Prune deletes the transaction blocks and the outputs of the state blocks
+// of all but the keep latest epochs. The headers of the state blocks are
+// kept by the chain.
+func (l *Ledger) Prune(keep int) {
+	latest := l.Chain.Latest()
+	if latest == nil || keep <= 0 {
+		return
+	}
+	oldest := latest.Epoch - keep + 1
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for epoch := range l.states {
+		if epoch < oldest {
+			delete(l.states, epoch)
+		}
+	}
+	for epoch := range l.txs {
+		if epoch < oldest {
+			delete(l.txs, epoch)
+		}
+	}
+}
+
+// Retained returns the epochs of the retained state blocks, in order.
+func (l *Ledger) Retained() []int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.retained()
+}
+
+// TxBlocks returns the transaction blocks of the epoch, or an error if the
+// epoch has been pruned.
+func (l *Ledger) TxBlocks(epoch int) ([]*TxBlock, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, ok := l.states[epoch]; !ok {
+		return nil, fmt.Errorf("epoch %d is not retained", epoch)
+	}
+	return l.txs[epoch], nil
+}
+
+// Prove returns the proof that the output id is in the state block of the
+// epoch, together with the header of the block to check it against.
+func (l *Ledger) Prove(epoch int, id string) (*Block, *UTXO, crypto.Proof, error) {
+	l.mutex.Lock()
+	b, ok := l.states[epoch]
+	l.mutex.Unlock()
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("epoch %d is not retained", epoch)
+	}
+	u, proof, err := b.Prove(id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return b.Header(), u, proof, nil
+}
+
+// Check verifies that the ledger is consistent after pruning: the chain of
+// headers is signed from the first state block on, every retained state
+// block matches its header and can prove all of its outputs, and the
+// retained transaction blocks lead from one retained state to the next.
+func (l *Ledger) Check(rosters RosterFunc) error {
+	headers, err := l.Chain.Since(nil)
+	if err != nil {
+		return err
+	}
+	if _, err := CatchUp(nil, headers, l.Chain.Latest(), rosters); err != nil {
+		return err
+	}
+	byEpoch := make(map[int]*Block)
+	for _, h := range headers {
+		byEpoch[h.Epoch] = h
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	epochs := l.retained()
+	if len(epochs) == 0 {
+		return errors.New("no retained state block")
+	}
+	if latest := headers[len(headers)-1].Epoch; epochs[len(epochs)-1] != latest {
+		return fmt.Errorf("latest epoch %d is not retained", latest)
+	}
+	for epoch := range l.txs {
+		if _, ok := l.states[epoch]; !ok {
+			return fmt.Errorf("transaction blocks of pruned epoch %d", epoch)
+		}
+	}
+	for i, epoch := range epochs {
+		b := l.states[epoch]
+		header, ok := byEpoch[epoch]
+		if !ok || !bytes.Equal(header.Hash(), b.Hash()) {
+			return fmt.Errorf("state block of epoch %d is not in the chain",
+				epoch)
+		}
+		root, proofs := tree(b.UTXOs)
+		if !bytes.Equal(root, header.Root) {
+			return fmt.Errorf("outputs of epoch %d don't match the root", epoch)
+		}
+		for j := range b.UTXOs {
+			if err := VerifyUTXO(header.Root, &b.UTXOs[j], proofs[j]); err != nil {
+				return fmt.Errorf("output %s of epoch %d: %v", b.UTXOs[j].ID,
+					epoch, err)
+			}
+		}
+		if i == len(epochs)-1 {
+			break
+		}
+		utxos := b.Map()
+		for _, tb := range l.txs[epoch] {
+			txs := make([]*atomix.Transaction, len(tb.Txs))
+			for k := range tb.Txs {
+				txs[k] = &tb.Txs[k]
+			}
+			if err := Replay(l.Chain.Shard, utxos, txs); err != nil {
+				return fmt.Errorf("transactions of epoch %d: %v", epoch, err)
+			}
+		}
+		if !reflect.DeepEqual(utxos, l.states[epochs[i+1]].Map()) {
+			return fmt.Errorf("transactions of epoch %d don't lead to epoch %d",
+				epoch, epochs[i+1])
+		}
+	}
+	return nil
+}
+
+// retained returns the epochs of the retained state blocks, in order. The
+// caller must hold the mutex.
+func (l *Ledger) retained() []int {
+	var epochs []int
+	for epoch := range l.states {
+		epochs = append(epochs, epoch)
+	}
+	sort.Ints(epochs)
+	return epochs
+}
@@ -0,0 +1,320 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// ledgers holds the ledger of every server of the bootstrap tests, and
+// rosters the roster of the shards in every epoch.
+var (
+	ledgers = make(map[network.ServerIdentityID]*Ledger)
+	rosters RosterFunc
+)
+
+func TestMain(m *testing.M) {
+	onet.GlobalProtocolRegister(BootstrapName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return NewBootstrap(n, ledgers[n.ServerIdentity().ID], rosters)
+	})
+	log.MainTest(m)
+}
+
+// signers holds a roster per epoch and the servers to sign with.
+type signers struct {
+	local   *onet.LocalTest
+	servers [][]*onet.Server
+	rosters []*onet.Roster
+}
+
+func newSigners(local *onet.LocalTest, epochs, n int) *signers {
+	s := &signers{local: local}
+	all := local.GenServers(epochs * n)
+	for i := 0; i < epochs; i++ {
+		servers := all[i*n : (i+1)*n]
+		s.servers = append(s.servers, servers)
+		s.rosters = append(s.rosters, local.GenRosterFromHost(servers...))
+	}
+	return s
+}
+
+// sign signs the block with the first count members of its epoch.
+func (s *signers) sign(t *testing.T, b *Block, count int) {
+	for i, server := range s.servers[b.Epoch][:count] {
+		require.Nil(t, b.Sign(network.Suite, i, s.local.GetPrivate(server)))
+	}
+}
+
+func (s *signers) roster(shard, epoch int) (*onet.Roster, error) {
+	if epoch < 0 || epoch >= len(s.rosters) {
+		return nil, errors.New("unknown epoch")
+	}
+	return s.rosters[epoch], nil
+}
+
+func TestBlock(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	s := newSigners(local, 2, 4)
+
+	first, err := NewBlock(1, 0, nil, map[string]int64{"b": 2, "a": 1})
+	require.Nil(t, err)
+	assert.Equal(t, "a", first.UTXOs[0].ID)
+	s.sign(t, first, 2)
+	assert.NotNil(t, first.Verify(s.rosters[0], nil))
+	s.sign(t, first, 3)
+	require.Nil(t, first.Verify(s.rosters[0], nil))
+	// signed by the roster of another epoch
+	assert.NotNil(t, first.Verify(s.rosters[1], nil))
+
+	second, err := NewBlock(1, 1, first, map[string]int64{"c": 3})
+	require.Nil(t, err)
+	s.sign(t, second, 3)
+	require.Nil(t, second.Verify(s.rosters[1], first))
+	assert.NotNil(t, second.Verify(s.rosters[1], nil))
+	require.Nil(t, second.Header().Verify(s.rosters[1], first.Header()))
+
+	// the outputs are bound to the root
+	forged := *second
+	forged.UTXOs = []UTXO{{ID: "c", Value: 4}}
+	assert.NotNil(t, forged.Verify(s.rosters[1], first))
+	forged = *second
+	forged.Root = first.Root
+	assert.NotNil(t, forged.Verify(s.rosters[1], first))
+
+	_, err = NewBlock(2, 2, first, nil)
+	assert.NotNil(t, err)
+	_, err = NewBlock(1, 0, first, nil)
+	assert.NotNil(t, err)
+}
+
+func TestProve(t *testing.T) {
+	utxos := make(map[string]int64)
+	for i := 0; i < 5; i++ {
+		utxos[string('a'+rune(i))] = int64(i)
+	}
+	b, err := NewBlock(0, 0, nil, utxos)
+	require.Nil(t, err)
+	for id := range utxos {
+		u, proof, err := b.Prove(id)
+		require.Nil(t, err)
+		require.Nil(t, VerifyUTXO(b.Header().Root, u, proof))
+		u.Value++
+		assert.NotNil(t, VerifyUTXO(b.Root, u, proof))
+	}
+	_, _, err = b.Prove("z")
+	assert.NotNil(t, err)
+}
+
+func TestCatchUp(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	s := newSigners(local, 3, 4)
+
+	chain := NewChain(0)
+	shard := atomix.NewShard(0, nil, map[string]int64{"a": 10})
+	txs := []*atomix.Transaction{
+		{
+			Inputs:  []atomix.Input{{Shard: 0, ID: "a", Value: 10}},
+			Outputs: []atomix.Output{{Shard: 0, ID: "b", Value: 6}, {Shard: 1, ID: "c", Value: 4}},
+		},
+		{
+			Inputs:  []atomix.Input{{Shard: 0, ID: "b", Value: 6}},
+			Outputs: []atomix.Output{{Shard: 0, ID: "d", Value: 6}},
+		},
+	}
+	utxos := shard.UTXOs()
+	for epoch := 0; epoch < 3; epoch++ {
+		b, err := NewBlock(0, epoch, chain.Latest(), utxos)
+		require.Nil(t, err)
+		s.sign(t, b, 3)
+		require.Nil(t, chain.Append(b, s.rosters[epoch]))
+		if epoch < len(txs) {
+			require.Nil(t, Replay(0, utxos, txs[epoch:epoch+1]))
+		}
+	}
+	assert.Equal(t, map[string]int64{"d": 6}, utxos)
+
+	// from the first block
+	headers, err := chain.Since(nil)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(headers))
+	latest := chain.Latest()
+	state, err := CatchUp(nil, headers, latest, s.roster)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]int64{"d": 6}, state)
+
+	// from a trusted block
+	headers, err = chain.Since(headers[0].Hash())
+	require.Nil(t, err)
+	require.Equal(t, 2, len(headers))
+	all, _ := chain.Since(nil)
+	_, err = CatchUp(all[0], headers, latest, s.roster)
+	require.Nil(t, err)
+
+	// the trusted block must be the one before the headers
+	_, err = CatchUp(all[1], headers, latest, s.roster)
+	assert.NotNil(t, err)
+	// the latest block must be the last one
+	previous := *headers[0]
+	_, err = CatchUp(all[0], headers, &previous, s.roster)
+	assert.NotNil(t, err)
+	// the outputs must match the root
+	forged := *latest
+	forged.UTXOs = []UTXO{{ID: "d", Value: 7}}
+	_, err = CatchUp(all[0], headers, &forged, s.roster)
+	assert.NotNil(t, err)
+
+	// replaying a spent input fails
+	assert.NotNil(t, Replay(0, state, txs[1:]))
+
+	_, err = chain.Since([]byte("unknown"))
+	assert.NotNil(t, err)
+}
+
+func TestLedgerPrune(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	s := newSigners(local, 4, 4)
+
+	ledger := NewLedger(0, 2)
+	utxos := map[string]int64{"a0": 10}
+	for epoch := 0; epoch < 4; epoch++ {
+		b, err := NewBlock(0, epoch, ledger.Chain.Latest(), utxos)
+		require.Nil(t, err)
+		s.sign(t, b, 3)
+		require.Nil(t, ledger.AppendState(b, s.rosters[epoch]))
+		if epoch == 3 {
+			break
+		}
+		in, out := fmt.Sprintf("a%d", epoch), fmt.Sprintf("a%d", epoch+1)
+		tb := &TxBlock{Shard: 0, Epoch: epoch, Txs: []atomix.Transaction{{
+			Inputs:  []atomix.Input{{Shard: 0, ID: in, Value: 10}},
+			Outputs: []atomix.Output{{Shard: 0, ID: out, Value: 10}},
+		}}}
+		require.Nil(t, ledger.AppendTxs(tb))
+		require.Nil(t, Replay(0, utxos, []*atomix.Transaction{&tb.Txs[0]}))
+	}
+	assert.Equal(t, []int{2, 3}, ledger.Retained())
+	require.Nil(t, ledger.Check(s.roster))
+
+	// the headers are all retained
+	headers, err := ledger.Chain.Since(nil)
+	require.Nil(t, err)
+	assert.Equal(t, 4, len(headers))
+
+	// pruned epochs can't answer anymore
+	_, err = ledger.TxBlocks(1)
+	assert.NotNil(t, err)
+	_, _, _, err = ledger.Prove(1, "a1")
+	assert.NotNil(t, err)
+
+	// retained epochs still can
+	txs, err := ledger.TxBlocks(2)
+	require.Nil(t, err)
+	assert.Equal(t, 1, len(txs))
+	header, u, proof, err := ledger.Prove(2, "a2")
+	require.Nil(t, err)
+	assert.Nil(t, header.UTXOs)
+	assert.Equal(t, headers[2].Hash(), header.Hash())
+	assert.Nil(t, VerifyUTXO(header.Root, u, proof))
+
+	// pruning further keeps the ledger consistent
+	ledger.Prune(1)
+	assert.Equal(t, []int{3}, ledger.Retained())
+	require.Nil(t, ledger.Check(s.roster))
+
+	// a transaction block of the wrong epoch is refused
+	assert.NotNil(t, ledger.AppendTxs(&TxBlock{Shard: 0, Epoch: 2}))
+	assert.NotNil(t, ledger.AppendTxs(&TxBlock{Shard: 1, Epoch: 3}))
+}
+
+func TestLedgerCheck(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	s := newSigners(local, 2, 4)
+
+	ledger := NewLedger(0, 0)
+	first, err := NewBlock(0, 0, nil, map[string]int64{"a": 10})
+	require.Nil(t, err)
+	s.sign(t, first, 3)
+	require.Nil(t, ledger.AppendState(first, s.rosters[0]))
+	second, err := NewBlock(0, 1, first, map[string]int64{"b": 10})
+	require.Nil(t, err)
+	s.sign(t, second, 3)
+	require.Nil(t, ledger.AppendState(second, s.rosters[1]))
+
+	// no transaction leads from "a" to "b"
+	assert.NotNil(t, ledger.Check(s.roster))
+
+	ledger = NewLedger(0, 0)
+	require.Nil(t, ledger.AppendState(first, s.rosters[0]))
+	require.Nil(t, ledger.AppendTxs(&TxBlock{Shard: 0, Epoch: 0, Txs: []atomix.Transaction{{
+		Inputs:  []atomix.Input{{Shard: 0, ID: "a", Value: 10}},
+		Outputs: []atomix.Output{{Shard: 0, ID: "b", Value: 10}},
+	}}}))
+	require.Nil(t, ledger.AppendState(second, s.rosters[1]))
+	require.Nil(t, ledger.Check(s.roster))
+	assert.Equal(t, []int{0, 1}, ledger.Retained())
+
+	// a state block must come with its outputs
+	assert.NotNil(t, NewLedger(0, 0).AppendState(first.Header(), s.rosters[0]))
+}
+
+func TestBootstrap(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	// the servers of the fourth epoch are new to the shard
+	s := newSigners(local, 4, 4)
+	rosters = s.roster
+
+	// the members of the last epoch keep a pruned ledger
+	member := NewLedger(0, 1)
+	utxos := map[string]int64{"a": 10}
+	for epoch := 0; epoch < 3; epoch++ {
+		b, err := NewBlock(0, epoch, member.Chain.Latest(), utxos)
+		require.Nil(t, err)
+		s.sign(t, b, 3)
+		require.Nil(t, member.AppendState(b, s.rosters[epoch]))
+	}
+	members := s.servers[2]
+	// the first member only knows another shard
+	ledgers[members[0].ServerIdentity.ID] = NewLedger(1, 0)
+	for _, server := range members[1:] {
+		ledgers[server.ServerIdentity.ID] = member
+	}
+
+	server := s.servers[3][0]
+	fresh := NewLedger(0, 1)
+	ledgers[server.ServerIdentity.ID] = fresh
+	state, err := CatchUpWith(local.CreateProtocol, server.ServerIdentity,
+		s.rosters[2], fresh)
+	require.Nil(t, err)
+	assert.Equal(t, utxos, state)
+	assert.Equal(t, member.Chain.Latest().Hash(), fresh.Chain.Latest().Hash())
+	require.Nil(t, fresh.Check(s.roster))
+	headers, err := fresh.Chain.Since(nil)
+	require.Nil(t, err)
+	assert.Equal(t, 3, len(headers))
+
+	// the new member takes part in the current epoch
+	assert.NotNil(t, fresh.AppendTxs(&TxBlock{Shard: 0, Epoch: 3}))
+	require.Nil(t, fresh.AppendTxs(&TxBlock{Shard: 0, Epoch: 2}))
+
+	// a ledger can only be filled once
+	_, err = CatchUpWith(local.CreateProtocol, server.ServerIdentity,
+		s.rosters[2], fresh)
+	assert.NotNil(t, err)
+	// a forged state is refused
+	forged := *member.Chain.Latest()
+	forged.UTXOs = []UTXO{{ID: "a", Value: 11}}
+	_, err = NewLedger(0, 0).Bootstrap(headers, &forged, s.roster)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,146 @@
+// Package tbv implements the trust-but-verify validation of the blocks of a
+// shard. The validators of the shard are split into small groups and a core:
+//
+//   - a group quickly signs optimistic blocks of transactions, checked only
+//     against what the group has seen. A client that trusts the group can
+//     go on as soon as it has the signature of the group.
+//   - the core later validates the optimistic blocks of all groups again, in
+//     order, against the final state and signs a final block. The
+//     transactions that turn out to be invalid, e.g. because two groups
+//     accepted a double spend, are rejected in the final block, and the
+//     validators roll back their optimistic state. They keep a
+//     compensation record of every reverted transaction, with the
+//     transaction it conflicted with, for the clients to query.
+//
+// A client chooses between the latency of the optimistic blocks and the
+// finality of the final blocks with the Level it waits for.
+package tbv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/bftcosi"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// Level is how far a transaction has been confirmed.
+type Level int
+
+const (
+	// Unknown transactions haven't been signed by any group.
+	Unknown Level = iota
+	// Optimistic transactions have been signed by a group, but might still
+	// be rejected by the core.
+	Optimistic
+	// Final transactions have been validated by the core.
+	Final
+	// Rejected transactions have been found invalid by the core.
+	Rejected
+)
+
+// String returns the name of the level.
+func (l Level) String() string {
+	switch l {
+	case Optimistic:
+		return "optimistic"
+	case Final:
+		return "final"
+	case Rejected:
+		return "rejected"
+	}
+	return "unknown"
+}
+
+// OptimisticBlock is a block of transactions signed by a group.
+type OptimisticBlock struct {
+	// Group is the index of the group
+	Group int
+	Txs   []atomix.Transaction
+	// Signature is the collective signature of the group on Hash
+	Signature *bftcosi.BFTSignature
+}
+
+// Hash returns the hash signed by the group.
+func (b *OptimisticBlock) Hash() []byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "optimistic/%d", b.Group)
+	for i := range b.Txs {
+		h.Write(b.Txs[i].Hash())
+	}
+	return h.Sum(nil)
+}
+
+// Verify checks the signature of the group.
+func (b *OptimisticBlock) Verify(groups []*onet.Roster) error {
+	if b.Group < 0 || b.Group >= len(groups) {
+		return fmt.Errorf("unknown group %d", b.Group)
+	}
+	return verifySignature(groups[b.Group], b.Hash(), b.Signature)
+}
+
+// FinalBlock is a block signed by the core that finalizes optimistic blocks
+// in order.
+type FinalBlock struct {
+	// Height is the number of final blocks before this one
+	Height int
+	// Previous is the hash of the previous final block, empty for the first
+	Previous []byte
+	// Blocks are the hashes of the optimistic blocks, in the order the
+	// transactions are applied
+	Blocks [][]byte
+	// Rejected are the hashes of the invalid transactions of the blocks
+	Rejected [][]byte
+	// Signature is the collective signature of the core on Hash
+	Signature *bftcosi.BFTSignature
+}
+
+// Hash returns the hash signed by the core.
+func (b *FinalBlock) Hash() []byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "final/%d/%x", b.Height, b.Previous)
+	for _, hash := range b.Blocks {
+		h.Write(hash)
+	}
+	h.Write([]byte("rejected"))
+	for _, hash := range b.Rejected {
+		h.Write(hash)
+	}
+	return h.Sum(nil)
+}
+
+// Verify checks the signature of the core.
+func (b *FinalBlock) Verify(core *onet.Roster) error {
+	return verifySignature(core, b.Hash(), b.Signature)
+}
+
+// Level returns the level of the transaction of one of the blocks of the
+// final block: either Final or Rejected.
+func (b *FinalBlock) Level(txHash []byte) Level {
+	for _, hash := range b.Rejected {
+		if bytes.Equal(hash, txHash) {
+			return Rejected
+		}
+	}
+	return Final
+}
+
+// verifySignature checks that sig is a signature of the roster on msg with
+// no more exceptions than BFTCoSi allows.
+func verifySignature(roster *onet.Roster, msg []byte, sig *bftcosi.BFTSignature) error {
+	if sig == nil {
+		return errors.New("not signed")
+	}
+	if !bytes.Equal(sig.Msg, msg) {
+		return errors.New("signature of another message")
+	}
+	n := len(roster.List)
+	if sig.Refused(n) > n-(n+1)*2/3 {
+		return errors.New("too many exceptions")
+	}
+	return sig.Verify(network.Suite, roster.Publics())
+}
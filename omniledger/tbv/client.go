@@ -0,0 +1,163 @@
+package tbv
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/bftcosi"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// CreateFunc creates a protocol instance on the root of the tree without
+// starting it, like onet.Overlay.CreateProtocol.
+type CreateFunc func(name string, t *onet.Tree) (onet.ProtocolInstance, error)
+
+// Client drives the blocks of a shard through both levels. The first member
+// of a roster runs the protocols on behalf of the client.
+type Client struct {
+	// Groups are the rosters of the groups signing optimistic blocks
+	Groups []*onet.Roster
+	// Core is the roster signing the final blocks
+	Core   *onet.Roster
+	create CreateFunc
+}
+
+// NewClient returns a client for the groups and the core, using create to
+// run the protocols.
+func NewClient(groups []*onet.Roster, core *onet.Roster, create CreateFunc) *Client {
+	return &Client{Groups: groups, Core: core, create: create}
+}
+
+// Submit sends the transaction to the group and waits until it reaches the
+// level: Optimistic returns as soon as the group signed it, Final also waits
+// for the core to finalize it and returns either Final or Rejected.
+func (c *Client) Submit(group int, tx *atomix.Transaction, level Level) (Level, error) {
+	if _, err := c.Propose(group, []atomix.Transaction{*tx}); err != nil {
+		return Unknown, err
+	}
+	if level == Optimistic {
+		return Optimistic, nil
+	}
+	fb, err := c.Finalize()
+	if err != nil {
+		return Optimistic, err
+	}
+	return fb.Level(tx.Hash()), nil
+}
+
+// Propose lets the group sign a block of the transactions and announces it
+// to the group and the core.
+func (c *Client) Propose(group int, txs []atomix.Transaction) (*OptimisticBlock, error) {
+	if group < 0 || group >= len(c.Groups) {
+		return nil, errors.New("unknown group")
+	}
+	roster := c.Groups[group]
+	b := &OptimisticBlock{Group: group, Txs: txs}
+	sig, err := c.sign(OptimisticName, roster, func(pi onet.ProtocolInstance) error {
+		p, ok := pi.(*OptimisticProtocol)
+		if !ok {
+			return errors.New("protocol " + OptimisticName + " is not optimistic")
+		}
+		p.Block = b
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.Signature = sig
+	if err := b.Verify(c.Groups); err != nil {
+		return nil, fmt.Errorf("group %d didn't sign: %v", group, err)
+	}
+	err = c.announce(&Announce{Optimistic: b}, union(roster, c.Core))
+	return b, err
+}
+
+// Finalize lets the core sign the final block of its pending optimistic
+// blocks and announces it to the core and all groups.
+func (c *Client) Finalize() (*FinalBlock, error) {
+	var final *FinalProtocol
+	sig, err := c.sign(FinalName, c.Core, func(pi onet.ProtocolInstance) error {
+		p, ok := pi.(*FinalProtocol)
+		if !ok {
+			return errors.New("protocol " + FinalName + " is not final")
+		}
+		final = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	fb := final.Block
+	fb.Signature = sig
+	if err := fb.Verify(c.Core); err != nil {
+		return nil, fmt.Errorf("core didn't sign: %v", err)
+	}
+	log.Lvl3("Finalized", len(fb.Blocks), "blocks, rejecting", len(fb.Rejected),
+		"transactions")
+	rosters := append([]*onet.Roster{c.Core}, c.Groups...)
+	err = c.announce(&Announce{Final: fb, Blocks: final.Blocks}, union(rosters...))
+	return fb, err
+}
+
+// sign runs the BFTCoSi protocol on the roster and returns its signature.
+func (c *Client) sign(name string, roster *onet.Roster,
+	setup func(onet.ProtocolInstance) error) (*bftcosi.BFTSignature, error) {
+	pi, err := c.create(name, roster.GenerateNaryTree(len(roster.List)))
+	if err != nil {
+		return nil, err
+	}
+	if err := setup(pi); err != nil {
+		return nil, err
+	}
+	bft := pi.(interface {
+		RegisterOnSignatureDone(func(*bftcosi.BFTSignature))
+	})
+	done := make(chan *bftcosi.BFTSignature, 1)
+	bft.RegisterOnSignatureDone(func(sig *bftcosi.BFTSignature) {
+		done <- sig
+	})
+	if err := pi.Start(); err != nil {
+		return nil, err
+	}
+	return <-done, nil
+}
+
+// announce sends the signed block to the validators of the roster.
+func (c *Client) announce(a *Announce, roster *onet.Roster) error {
+	pi, err := c.create(CommitName, roster.GenerateNaryTree(len(roster.List)))
+	if err != nil {
+		return err
+	}
+	p, ok := pi.(*Commit)
+	if !ok {
+		return errors.New("protocol " + CommitName + " is not commit")
+	}
+	p.Announce = a
+	done := make(chan error, 1)
+	p.RegisterOnDone(func(applied int, err error) {
+		log.Lvl3("Block applied by", applied, "validators")
+		done <- err
+	})
+	if err := p.Start(); err != nil {
+		return err
+	}
+	return <-done
+}
+
+// union returns the roster of all members of the rosters, in order.
+func union(rosters ...*onet.Roster) *onet.Roster {
+	var list []*network.ServerIdentity
+	seen := make(map[network.ServerIdentityID]bool)
+	for _, r := range rosters {
+		for _, si := range r.List {
+			if !seen[si.ID] {
+				seen[si.ID] = true
+				list = append(list, si)
+			}
+		}
+	}
+	return onet.NewRoster(list)
+}
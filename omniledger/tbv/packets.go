@@ -0,0 +1,47 @@
+package tbv
+
+import (
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func init() {
+	for _, i := range []interface{}{
+		OptimisticBlock{},
+		FinalRound{},
+		Announce{},
+		Ack{},
+	} {
+		network.RegisterMessage(i)
+	}
+}
+
+// FinalRound is passed along the final block to the core, so that the
+// members can check it even if they missed some optimistic blocks.
+type FinalRound struct {
+	Block  FinalBlock
+	Blocks []OptimisticBlock
+}
+
+// Announce is sent to the validators once a block has been signed. It holds
+// either an optimistic block, or a final block with its optimistic blocks.
+type Announce struct {
+	Optimistic *OptimisticBlock
+	Final      *FinalBlock
+	Blocks     []OptimisticBlock
+}
+
+// Ack is the answer of a validator to Announce.
+type Ack struct {
+	Err string
+}
+
+type announceChan struct {
+	*onet.TreeNode
+	Announce
+}
+
+type ackChan struct {
+	*onet.TreeNode
+	Ack
+}
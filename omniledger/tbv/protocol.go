@@ -0,0 +1,218 @@
+package tbv
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/bftcosi"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// The names of the protocols. They have to be registered by the validators
+// with their Validator, for example:
+//
+//	onet.GlobalProtocolRegister(tbv.OptimisticName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+//		return tbv.NewOptimisticProtocol(n, validator)
+//	})
+const (
+	OptimisticName = "TBVOptimistic"
+	FinalName      = "TBVFinal"
+	CommitName     = "TBVCommit"
+)
+
+// Timeout is how long the root of Commit waits for the acknowledgements.
+var Timeout = 10 * time.Second
+
+// OptimisticProtocol lets a group sign an optimistic block with BFTCoSi.
+// The members only sign if the block applies on their optimistic state.
+type OptimisticProtocol struct {
+	*bftcosi.ProtocolBFTCoSi
+	// Block is the block to sign, set on the root
+	Block *OptimisticBlock
+}
+
+// NewOptimisticProtocol returns a new instance checking the blocks with
+// the validator.
+func NewOptimisticProtocol(n *onet.TreeNodeInstance, v *Validator) (*OptimisticProtocol, error) {
+	bft, err := bftcosi.NewBFTCoSiProtocol(n, func(msg, data []byte) bool {
+		_, m, err := network.Unmarshal(data)
+		if err != nil {
+			return false
+		}
+		b, ok := m.(*OptimisticBlock)
+		if !ok || !bytes.Equal(b.Hash(), msg) {
+			return false
+		}
+		if err := v.VerifyOptimistic(b); err != nil {
+			log.Lvl2(n.Name(), "refuses optimistic block:", err)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &OptimisticProtocol{ProtocolBFTCoSi: bft}, nil
+}
+
+// Start signs the block.
+func (p *OptimisticProtocol) Start() error {
+	if p.Block == nil {
+		return errors.New("no block to sign")
+	}
+	data, err := network.Marshal(p.Block)
+	if err != nil {
+		return err
+	}
+	p.Msg = p.Block.Hash()
+	p.Data = data
+	return p.ProtocolBFTCoSi.Start()
+}
+
+// FinalProtocol lets the core sign the next final block with BFTCoSi. The
+// root proposes the final block of its pending blocks, and the members only
+// sign if they reject the same transactions.
+type FinalProtocol struct {
+	*bftcosi.ProtocolBFTCoSi
+	// Block and Blocks are the final block and its optimistic blocks, set
+	// by Start on the root
+	Block  *FinalBlock
+	Blocks []OptimisticBlock
+
+	validator *Validator
+}
+
+// NewFinalProtocol returns a new instance checking the blocks with the
+// validator.
+func NewFinalProtocol(n *onet.TreeNodeInstance, v *Validator) (*FinalProtocol, error) {
+	bft, err := bftcosi.NewBFTCoSiProtocol(n, func(msg, data []byte) bool {
+		_, m, err := network.Unmarshal(data)
+		if err != nil {
+			return false
+		}
+		round, ok := m.(*FinalRound)
+		if !ok || !bytes.Equal(round.Block.Hash(), msg) {
+			return false
+		}
+		if err := v.VerifyFinal(&round.Block, round.Blocks); err != nil {
+			log.Lvl2(n.Name(), "refuses final block:", err)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &FinalProtocol{ProtocolBFTCoSi: bft, validator: v}, nil
+}
+
+// Start proposes the final block of the pending blocks of the root.
+func (p *FinalProtocol) Start() error {
+	p.Block, p.Blocks = p.validator.NextFinal()
+	if len(p.Blocks) == 0 {
+		return errors.New("nothing to finalize")
+	}
+	data, err := network.Marshal(&FinalRound{Block: *p.Block, Blocks: p.Blocks})
+	if err != nil {
+		return err
+	}
+	p.Msg = p.Block.Hash()
+	p.Data = data
+	return p.ProtocolBFTCoSi.Start()
+}
+
+// Commit announces a signed block to the validators, which apply it to
+// their state.
+type Commit struct {
+	*onet.TreeNodeInstance
+	// Announce is the signed block, set on the root
+	Announce *Announce
+
+	validator    *Validator
+	announceChan chan announceChan
+	ackChan      chan ackChan
+	onDone       func(int, error)
+}
+
+// NewCommit returns a new instance applying the blocks to the validator.
+func NewCommit(n *onet.TreeNodeInstance, v *Validator) (*Commit, error) {
+	c := &Commit{TreeNodeInstance: n, validator: v}
+	if err := c.RegisterChannels(&c.announceChan, &c.ackChan); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// RegisterOnDone sets the function called on the root with the number of
+// validators that applied the block, and the error of the root.
+func (c *Commit) RegisterOnDone(fn func(int, error)) {
+	c.onDone = fn
+}
+
+// Start sends the block to the validators and applies it on the root.
+func (c *Commit) Start() error {
+	if c.Announce == nil {
+		return errors.New("no block to announce")
+	}
+	if err := c.SendToChildren(c.Announce); err != nil {
+		return err
+	}
+	c.ackChan <- ackChan{c.TreeNode(), *c.apply(c.Announce)}
+	return nil
+}
+
+// Dispatch applies the block on the validators and collects the
+// acknowledgements on the root.
+func (c *Commit) Dispatch() error {
+	defer c.Done()
+	timeout := time.After(Timeout)
+	if !c.IsRoot() {
+		select {
+		case msg := <-c.announceChan:
+			return c.SendToParent(c.apply(&msg.Announce))
+		case <-timeout:
+			return errors.New("didn't get the block")
+		}
+	}
+	var err error
+	applied := 0
+	for received := 0; received < len(c.Roster().List); received++ {
+		select {
+		case msg := <-c.ackChan:
+			switch {
+			case msg.Err == "":
+				applied++
+			case msg.TreeNode.ID.Equal(c.TreeNode().ID):
+				err = errors.New(msg.Err)
+			default:
+				log.Lvl2(c.Name(), msg.ServerIdentity, "couldn't apply:", msg.Err)
+			}
+		case <-timeout:
+			received = len(c.Roster().List)
+		}
+	}
+	if c.onDone != nil {
+		c.onDone(applied, err)
+	}
+	return err
+}
+
+// apply applies the block to the validator.
+func (c *Commit) apply(a *Announce) *Ack {
+	var err error
+	switch {
+	case a.Optimistic != nil:
+		err = c.validator.CommitOptimistic(a.Optimistic)
+	case a.Final != nil:
+		err = c.validator.CommitFinal(a.Final, a.Blocks)
+	default:
+		err = errors.New("empty announce")
+	}
+	if err != nil {
+		return &Ack{Err: err.Error()}
+	}
+	return &Ack{}
+}
@@ -0,0 +1,194 @@
+package tbv
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+)
+
+// Conflict is a transaction of an optimistic block that doesn't apply on
+// the final chain.
+type Conflict struct {
+	// TxHash is the hash of the transaction
+	TxHash []byte
+	// Block is the hash of the optimistic block holding the transaction,
+	// signed by Group
+	Block []byte
+	Group int
+	// Spender is the hash of the transaction that spent one of its inputs
+	// first, if any
+	Spender []byte
+	// Depends is the hash of the conflicting transaction that created one
+	// of its inputs, if any
+	Depends []byte
+	// Reason is why the transaction doesn't apply
+	Reason string
+}
+
+// Compensation is the record of an optimistic transaction reverted by a
+// final block. Clients who went on after the optimistic signature of the
+// group use it to find what they lost, and the group that signed the
+// conflicting block is accountable for it.
+type Compensation struct {
+	Conflict
+	// Tx is the reverted transaction
+	Tx atomix.Transaction
+	// Height is the height of the final block that rejected it
+	Height int
+	// Lost are the outputs of the shard the transaction created in the
+	// optimistic state only
+	Lost []atomix.Output
+}
+
+// Conflicts returns the transactions of the pending optimistic blocks that
+// conflict with the final chain or with an earlier pending block. They will
+// be rejected by the next final block.
+func (v *Validator) Conflicts() []Conflict {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	blocks := make([]OptimisticBlock, len(v.pending))
+	for i, b := range v.pending {
+		blocks[i] = *b
+	}
+	return v.reconcile(blocks)
+}
+
+// Compensation returns the record of the transaction if a final block
+// reverted it.
+func (v *Validator) Compensation(txHash []byte) (*Compensation, bool) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	c, ok := v.compensations[string(txHash)]
+	return c, ok
+}
+
+// Compensations returns the records of the transactions reverted by the
+// final block of the height, in the order of the block.
+func (v *Validator) Compensations(height int) []*Compensation {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	var ret []*Compensation
+	for _, c := range v.reverted[height] {
+		ret = append(ret, v.compensations[c])
+	}
+	return ret
+}
+
+// RegisterOnCompensation sets the function called with the record of every
+// transaction reverted by a final block, once the block is committed.
+func (v *Validator) RegisterOnCompensation(fn func(*Compensation)) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.onCompensation = fn
+}
+
+// reconcile replays the blocks in order on the final state and returns the
+// transactions that don't apply, with the transactions they conflict with.
+// The caller must hold the mutex.
+func (v *Validator) reconcile(blocks []OptimisticBlock) []Conflict {
+	utxos := copyUTXOs(v.final)
+	spent := make(map[string][]byte)
+	// created holds the conflicting transaction that created an output
+	created := make(map[string][]byte)
+	var conflicts []Conflict
+	for i := range blocks {
+		for j := range blocks[i].Txs {
+			tx := &blocks[i].Txs[j]
+			hash := tx.Hash()
+			err := v.apply(utxos, tx)
+			if err == nil {
+				for _, in := range tx.Inputs {
+					spent[in.ID] = hash
+				}
+				continue
+			}
+			c := Conflict{
+				TxHash: hash,
+				Block:  blocks[i].Hash(),
+				Group:  blocks[i].Group,
+				Reason: err.Error(),
+			}
+			for _, in := range tx.Inputs {
+				if c.Spender == nil {
+					c.Spender = v.spender(spent, in.ID)
+				}
+				if c.Depends == nil {
+					c.Depends = created[in.ID]
+				}
+			}
+			for _, out := range tx.Outputs {
+				created[out.ID] = hash
+			}
+			conflicts = append(conflicts, c)
+		}
+	}
+	return conflicts
+}
+
+// spender returns the transaction that spent the output, either on the
+// final chain or in spent. The caller must hold the mutex.
+func (v *Validator) spender(spent map[string][]byte, id string) []byte {
+	if hash, ok := spent[id]; ok {
+		return hash
+	}
+	return v.spent[id]
+}
+
+// compensate records the transactions of the blocks rejected by the final
+// block of the height, given the conflicts found before committing it. It
+// returns the new records. The caller must hold the mutex.
+func (v *Validator) compensate(height int, fb *FinalBlock,
+	blocks []OptimisticBlock, conflicts []Conflict) []*Compensation {
+	var ret []*Compensation
+	for i := range blocks {
+		for j := range blocks[i].Txs {
+			tx := &blocks[i].Txs[j]
+			hash := tx.Hash()
+			if fb.Level(hash) != Rejected {
+				continue
+			}
+			c := &Compensation{
+				Conflict: Conflict{
+					TxHash: hash,
+					Block:  blocks[i].Hash(),
+					Group:  blocks[i].Group,
+					Reason: "rejected by the core",
+				},
+				Tx:     *tx,
+				Height: height,
+			}
+			for _, conflict := range conflicts {
+				if bytes.Equal(conflict.TxHash, hash) {
+					c.Conflict = conflict
+				}
+			}
+			for _, out := range tx.Outputs {
+				if out.Shard == v.Shard {
+					c.Lost = append(c.Lost, out)
+				}
+			}
+			key := string(hash)
+			if _, ok := v.compensations[key]; ok {
+				continue
+			}
+			v.compensations[key] = c
+			v.reverted[height] = append(v.reverted[height], key)
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// String returns a short description of the conflict.
+func (c *Conflict) String() string {
+	switch {
+	case c.Spender != nil:
+		return fmt.Sprintf("%x of group %d: input spent by %x", c.TxHash,
+			c.Group, c.Spender)
+	case c.Depends != nil:
+		return fmt.Sprintf("%x of group %d: depends on reverted %x", c.TxHash,
+			c.Group, c.Depends)
+	}
+	return fmt.Sprintf("%x of group %d: %s", c.TxHash, c.Group, c.Reason)
+}
@@ -0,0 +1,205 @@
+package tbv
+
+import (
+	"testing"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// validators holds the validator of every server of the tests.
+var validators = make(map[network.ServerIdentityID]*Validator)
+
+func TestMain(m *testing.M) {
+	onet.GlobalProtocolRegister(OptimisticName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return NewOptimisticProtocol(n, validators[n.ServerIdentity().ID])
+	})
+	onet.GlobalProtocolRegister(FinalName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return NewFinalProtocol(n, validators[n.ServerIdentity().ID])
+	})
+	onet.GlobalProtocolRegister(CommitName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return NewCommit(n, validators[n.ServerIdentity().ID])
+	})
+	log.MainTest(m)
+}
+
+// setup creates two groups and a core of four members each, starting with
+// the outputs "a" and "b".
+func setup(local *onet.LocalTest) *Client {
+	servers := local.GenServers(12)
+	groups := []*onet.Roster{
+		local.GenRosterFromHost(servers[0:4]...),
+		local.GenRosterFromHost(servers[4:8]...),
+	}
+	core := local.GenRosterFromHost(servers[8:12]...)
+	for _, s := range servers {
+		validators[s.ServerIdentity.ID] = NewValidator(0, groups, core,
+			map[string]int64{"a": 10, "b": 5})
+	}
+	return NewClient(groups, core, local.CreateProtocol)
+}
+
+// pay returns a transaction spending the output id of value to a new
+// output to.
+func pay(id string, value int64, to string) *atomix.Transaction {
+	return &atomix.Transaction{
+		Inputs:  []atomix.Input{{Shard: 0, ID: id, Value: value}},
+		Outputs: []atomix.Output{{Shard: 0, ID: to, Value: value}},
+	}
+}
+
+// status asserts that all members of the roster see the transaction at
+// the level.
+func status(t *testing.T, roster *onet.Roster, tx *atomix.Transaction, level Level) {
+	for _, si := range roster.List {
+		assert.Equal(t, level, validators[si.ID].Status(tx.Hash()),
+			"%s at %s", si, level)
+	}
+}
+
+func TestTwoLevels(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	c := setup(local)
+
+	tx := pay("a", 10, "c")
+	level, err := c.Submit(0, tx, Optimistic)
+	require.Nil(t, err)
+	assert.Equal(t, Optimistic, level)
+	status(t, c.Groups[0], tx, Optimistic)
+	status(t, c.Core, tx, Optimistic)
+	status(t, c.Groups[1], tx, Unknown)
+	for _, si := range c.Groups[0].List {
+		_, ok := validators[si.ID].Unspent("c", Optimistic)
+		assert.True(t, ok)
+		_, ok = validators[si.ID].Unspent("c", Final)
+		assert.False(t, ok)
+	}
+
+	fb, err := c.Finalize()
+	require.Nil(t, err)
+	assert.Equal(t, 1, len(fb.Blocks))
+	assert.Equal(t, Final, fb.Level(tx.Hash()))
+	for _, roster := range append(c.Groups, c.Core) {
+		status(t, roster, tx, Final)
+		for _, si := range roster.List {
+			_, ok := validators[si.ID].Unspent("c", Final)
+			assert.True(t, ok)
+		}
+	}
+
+	// nothing left to finalize
+	_, err = c.Finalize()
+	assert.NotNil(t, err)
+
+	tx = pay("b", 5, "d")
+	level, err = c.Submit(1, tx, Final)
+	require.Nil(t, err)
+	assert.Equal(t, Final, level)
+}
+
+func TestRollback(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	c := setup(local)
+
+	// both groups accept a double spend of "a", as none sees the other
+	first := pay("a", 10, "x")
+	second := pay("a", 10, "y")
+	dependent := pay("y", 10, "z")
+	_, err := c.Propose(0, []atomix.Transaction{*first})
+	require.Nil(t, err)
+	_, err = c.Propose(1, []atomix.Transaction{*second, *dependent})
+	require.Nil(t, err)
+	status(t, c.Groups[1], dependent, Optimistic)
+
+	fb, err := c.Finalize()
+	require.Nil(t, err)
+	assert.Equal(t, 2, len(fb.Blocks))
+	assert.Equal(t, Final, fb.Level(first.Hash()))
+	assert.Equal(t, Rejected, fb.Level(second.Hash()))
+	assert.Equal(t, Rejected, fb.Level(dependent.Hash()))
+
+	// the second group rolled back its optimistic state
+	status(t, c.Groups[1], second, Rejected)
+	status(t, c.Groups[1], dependent, Rejected)
+	for _, si := range c.Groups[1].List {
+		v := validators[si.ID]
+		_, ok := v.Unspent("x", Optimistic)
+		assert.True(t, ok)
+		_, ok = v.Unspent("y", Optimistic)
+		assert.False(t, ok)
+		_, ok = v.Unspent("z", Optimistic)
+		assert.False(t, ok)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	c := setup(local)
+
+	first := pay("a", 10, "x")
+	second := pay("a", 10, "y")
+	dependent := pay("y", 10, "z")
+	_, err := c.Propose(0, []atomix.Transaction{*first})
+	require.Nil(t, err)
+	_, err = c.Propose(1, []atomix.Transaction{*second, *dependent})
+	require.Nil(t, err)
+
+	core := validators[c.Core.List[0].ID]
+	conflicts := core.Conflicts()
+	require.Equal(t, 2, len(conflicts))
+	assert.Equal(t, second.Hash(), conflicts[0].TxHash)
+	assert.Equal(t, 1, conflicts[0].Group)
+	assert.Equal(t, first.Hash(), conflicts[0].Spender)
+	assert.Equal(t, dependent.Hash(), conflicts[1].TxHash)
+	assert.Equal(t, second.Hash(), conflicts[1].Depends)
+	emitted := make(chan *Compensation, 2)
+	core.RegisterOnCompensation(func(c *Compensation) {
+		emitted <- c
+	})
+
+	_, err = c.Finalize()
+	require.Nil(t, err)
+	assert.Empty(t, core.Conflicts())
+	for _, si := range c.Groups[1].List {
+		v := validators[si.ID]
+		_, ok := v.Compensation(first.Hash())
+		assert.False(t, ok)
+		comp, ok := v.Compensation(second.Hash())
+		require.True(t, ok)
+		assert.Equal(t, 0, comp.Height)
+		assert.Equal(t, first.Hash(), comp.Spender)
+		assert.Equal(t, []atomix.Output{{Shard: 0, ID: "y", Value: 10}},
+			comp.Lost)
+		comps := v.Compensations(0)
+		require.Equal(t, 2, len(comps))
+		assert.Equal(t, dependent.Hash(), comps[1].TxHash)
+		assert.Empty(t, v.Compensations(1))
+	}
+	assert.Equal(t, second.Hash(), (<-emitted).TxHash)
+	assert.Equal(t, dependent.Hash(), (<-emitted).TxHash)
+}
+
+func TestRefuse(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	c := setup(local)
+
+	_, err := c.Submit(0, pay("a", 10, "c"), Optimistic)
+	require.Nil(t, err)
+	// the group already spent "a" optimistically
+	level, err := c.Submit(0, pay("a", 10, "d"), Optimistic)
+	assert.NotNil(t, err)
+	assert.Equal(t, Unknown, level)
+	// the value must match
+	_, err = c.Submit(1, pay("b", 6, "d"), Optimistic)
+	assert.NotNil(t, err)
+	_, err = c.Propose(2, nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,319 @@
+package tbv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// Validator is the state one validator keeps of its shard: the final state
+// and, on top of it, the optimistic state of the blocks not finalized yet.
+type Validator struct {
+	// Shard is the index of the shard
+	Shard int
+	// Groups are the rosters of the groups signing optimistic blocks
+	Groups []*onet.Roster
+	// Core is the roster signing the final blocks
+	Core *onet.Roster
+
+	mutex sync.Mutex
+	// final holds the unspent outputs after the final blocks
+	final map[string]int64
+	// optimistic holds the unspent outputs after the pending blocks
+	optimistic map[string]int64
+	// pending are the optimistic blocks not finalized yet, in the order
+	// they have been committed
+	pending []*OptimisticBlock
+	// height and last describe the latest final block
+	height int
+	last   []byte
+	// status holds the level of the transactions, by hash
+	status map[string]Level
+	// spent holds the final transaction that spent an output
+	spent map[string][]byte
+	// compensations are the records of the reverted transactions, by hash
+	compensations map[string]*Compensation
+	// reverted are the hashes of the transactions reverted by every final
+	// block
+	reverted       map[int][]string
+	onCompensation func(*Compensation)
+}
+
+// NewValidator returns a validator of the shard starting with the given
+// unspent outputs.
+func NewValidator(shard int, groups []*onet.Roster, core *onet.Roster,
+	utxos map[string]int64) *Validator {
+	v := &Validator{
+		Shard:         shard,
+		Groups:        groups,
+		Core:          core,
+		final:         make(map[string]int64),
+		last:          []byte{},
+		status:        make(map[string]Level),
+		spent:         make(map[string][]byte),
+		compensations: make(map[string]*Compensation),
+		reverted:      make(map[int][]string),
+	}
+	for id, value := range utxos {
+		v.final[id] = value
+	}
+	v.optimistic = copyUTXOs(v.final)
+	return v
+}
+
+// Status returns the level of the transaction.
+func (v *Validator) Status(txHash []byte) Level {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.status[string(txHash)]
+}
+
+// Unspent returns the value of the output and whether it is unspent in the
+// state of the level, either Optimistic or Final.
+func (v *Validator) Unspent(id string, level Level) (int64, bool) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	utxos := v.optimistic
+	if level == Final {
+		utxos = v.final
+	}
+	value, ok := utxos[id]
+	return value, ok
+}
+
+// VerifyOptimistic checks that all transactions of the block apply in order
+// on the optimistic state.
+func (v *Validator) VerifyOptimistic(b *OptimisticBlock) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	utxos := copyUTXOs(v.optimistic)
+	for i := range b.Txs {
+		if err := v.apply(utxos, &b.Txs[i]); err != nil {
+			return fmt.Errorf("transaction %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// CommitOptimistic adds the signed block to the pending ones.
+func (v *Validator) CommitOptimistic(b *OptimisticBlock) error {
+	if err := b.Verify(v.Groups); err != nil {
+		return err
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	hash := b.Hash()
+	for _, p := range v.pending {
+		if bytes.Equal(p.Hash(), hash) {
+			return nil
+		}
+	}
+	v.pending = append(v.pending, b)
+	for i := range b.Txs {
+		if v.status[string(b.Txs[i].Hash())] == Unknown {
+			v.status[string(b.Txs[i].Hash())] = Optimistic
+		}
+	}
+	v.rebuild()
+	return nil
+}
+
+// NextFinal returns the unsigned final block of the pending blocks and the
+// blocks themselves.
+func (v *Validator) NextFinal() (*FinalBlock, []OptimisticBlock) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	fb := &FinalBlock{
+		Height:   v.height,
+		Previous: v.last,
+		Rejected: [][]byte{},
+	}
+	var blocks []OptimisticBlock
+	for _, b := range v.pending {
+		fb.Blocks = append(fb.Blocks, b.Hash())
+		blocks = append(blocks, *b)
+	}
+	fb.Rejected = v.rejected(blocks)
+	return fb, blocks
+}
+
+// VerifyFinal checks that the final block follows the latest one and
+// finalizes the blocks, rejecting exactly the invalid transactions.
+func (v *Validator) VerifyFinal(fb *FinalBlock, blocks []OptimisticBlock) error {
+	if err := v.verifyBlocks(fb, blocks); err != nil {
+		return err
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if fb.Height != v.height || !bytes.Equal(fb.Previous, v.last) {
+		return errors.New("doesn't follow the latest final block")
+	}
+	rejected := v.rejected(blocks)
+	if len(rejected) != len(fb.Rejected) {
+		return fmt.Errorf("rejects %d transactions instead of %d",
+			len(fb.Rejected), len(rejected))
+	}
+	for i := range rejected {
+		if !bytes.Equal(rejected[i], fb.Rejected[i]) {
+			return errors.New("rejects a valid transaction")
+		}
+	}
+	return nil
+}
+
+// CommitFinal applies the signed final block to the final state and rolls
+// back the optimistic state: the rejected transactions and the ones
+// depending on them are dropped from it, and a compensation record is
+// kept for each of them.
+func (v *Validator) CommitFinal(fb *FinalBlock, blocks []OptimisticBlock) error {
+	if err := fb.Verify(v.Core); err != nil {
+		return err
+	}
+	if err := v.verifyBlocks(fb, blocks); err != nil {
+		return err
+	}
+	// the records are emitted once the mutex is released
+	var records []*Compensation
+	var onCompensation func(*Compensation)
+	defer func() {
+		for _, c := range records {
+			onCompensation(c)
+		}
+	}()
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if fb.Height < v.height {
+		return nil
+	}
+	if fb.Height != v.height || !bytes.Equal(fb.Previous, v.last) {
+		return errors.New("missing final blocks")
+	}
+	conflicts := v.reconcile(blocks)
+	rejected := make(map[string]bool)
+	for _, hash := range fb.Rejected {
+		rejected[string(hash)] = true
+	}
+	finalized := make(map[string]bool)
+	for i := range blocks {
+		finalized[string(blocks[i].Hash())] = true
+		for j := range blocks[i].Txs {
+			tx := &blocks[i].Txs[j]
+			hash := string(tx.Hash())
+			if rejected[hash] {
+				v.status[hash] = Rejected
+				continue
+			}
+			if err := v.apply(v.final, tx); err != nil {
+				// the block is signed by the core, so it can't happen
+				// with 2f+1 honest members
+				log.Error("Final transaction doesn't apply:", err)
+				continue
+			}
+			v.status[hash] = Final
+			for _, in := range tx.Inputs {
+				v.spent[in.ID] = tx.Hash()
+			}
+		}
+	}
+	compensations := v.compensate(fb.Height, fb, blocks, conflicts)
+	if v.onCompensation != nil {
+		records, onCompensation = compensations, v.onCompensation
+	}
+	var pending []*OptimisticBlock
+	for _, b := range v.pending {
+		if !finalized[string(b.Hash())] {
+			pending = append(pending, b)
+		}
+	}
+	v.pending = pending
+	v.height++
+	v.last = fb.Hash()
+	v.rebuild()
+	return nil
+}
+
+// verifyBlocks checks that the blocks are the signed optimistic blocks of
+// the final block.
+func (v *Validator) verifyBlocks(fb *FinalBlock, blocks []OptimisticBlock) error {
+	if len(blocks) != len(fb.Blocks) {
+		return errors.New("wrong number of optimistic blocks")
+	}
+	if len(blocks) == 0 {
+		return errors.New("no optimistic block")
+	}
+	for i := range blocks {
+		if !bytes.Equal(blocks[i].Hash(), fb.Blocks[i]) {
+			return fmt.Errorf("optimistic block %d is not in the final block", i)
+		}
+		if err := blocks[i].Verify(v.Groups); err != nil {
+			return fmt.Errorf("optimistic block %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// rejected returns the hashes of the transactions of the blocks that don't
+// apply in order on the final state.
+func (v *Validator) rejected(blocks []OptimisticBlock) [][]byte {
+	rejected := [][]byte{}
+	for _, c := range v.reconcile(blocks) {
+		log.Lvl2("Shard", v.Shard, "rejects", c.String())
+		rejected = append(rejected, c.TxHash)
+	}
+	return rejected
+}
+
+// rebuild computes the optimistic state again from the final state and the
+// pending blocks. The transactions that don't apply anymore are skipped:
+// they will be rejected by the core.
+func (v *Validator) rebuild() {
+	v.optimistic = copyUTXOs(v.final)
+	for _, b := range v.pending {
+		for i := range b.Txs {
+			if err := v.apply(v.optimistic, &b.Txs[i]); err != nil {
+				log.Lvl3("Shard", v.Shard, "skips optimistic transaction:", err)
+			}
+		}
+	}
+}
+
+// apply spends the inputs and creates the outputs of the transaction in
+// utxos, or returns an error and leaves utxos untouched. All the inputs
+// must be held by the shard, the cross-shard transactions go through
+// Atomix.
+func (v *Validator) apply(utxos map[string]int64, tx *atomix.Transaction) error {
+	if err := tx.Verify(); err != nil {
+		return err
+	}
+	for _, in := range tx.Inputs {
+		if in.Shard != v.Shard {
+			return fmt.Errorf("input %s of shard %d", in.ID, in.Shard)
+		}
+		if value, ok := utxos[in.ID]; !ok || value != in.Value {
+			return fmt.Errorf("input %s is unknown or spent", in.ID)
+		}
+	}
+	for _, in := range tx.Inputs {
+		delete(utxos, in.ID)
+	}
+	for _, out := range tx.Outputs {
+		if out.Shard == v.Shard {
+			utxos[out.ID] = out.Value
+		}
+	}
+	return nil
+}
+
+// copyUTXOs returns a copy of the outputs.
+func copyUTXOs(utxos map[string]int64) map[string]int64 {
+	c := make(map[string]int64)
+	for id, value := range utxos {
+		c[id] = value
+	}
+	return c
+}
@@ -0,0 +1,160 @@
+// Package workload generates synthetic transactions for the simulations of
+// the shards, instead of replaying the blocks of Bitcoin. A configurable
+// fraction of the transactions spends the outputs of more than one shard, so
+// that the overhead of Atomix can be measured.
+package workload
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"github.com/dedis/paper_17_sosp_omniledger/omniledger/atomix"
+)
+
+// Config describes the workload.
+type Config struct {
+	// Shards is the number of shards
+	Shards int
+	// Outputs is the number of unspent outputs of every shard at the start
+	Outputs int
+	// CrossShard is the fraction of transactions spending inputs of more
+	// than one shard
+	CrossShard float64
+	// InputShards is the number of input shards of a cross-shard
+	// transaction, e.g. 2 or 3
+	InputShards int
+	// TxOutputs is the number of outputs of every transaction
+	TxOutputs int
+	// Seed makes the workload reproducible
+	Seed int64
+}
+
+// Generator generates transactions spending the outputs of the shards. It
+// assumes that all the transactions it generated are committed, so the
+// outputs of a block can only be spent from the next block on.
+type Generator struct {
+	Config
+	rand *rand.Rand
+	// unspent are the outputs of every shard that can be spent
+	unspent [][]atomix.Output
+	genesis []map[string]int64
+	count   int
+}
+
+// New returns a generator for the workload.
+func New(c Config) (*Generator, error) {
+	if c.Shards <= 0 || c.Outputs <= 0 {
+		return nil, errors.New("need shards with outputs")
+	}
+	if c.CrossShard < 0 || c.CrossShard > 1 {
+		return nil, errors.New("cross-shard transactions must be a fraction")
+	}
+	if c.CrossShard > 0 && (c.InputShards < 2 || c.InputShards > c.Shards) {
+		return nil, errors.New("cross-shard transactions need between two " +
+			"input shards and all shards")
+	}
+	if c.TxOutputs <= 0 {
+		c.TxOutputs = 1
+	}
+	g := &Generator{
+		Config:  c,
+		rand:    rand.New(rand.NewSource(c.Seed)),
+		unspent: make([][]atomix.Output, c.Shards),
+		genesis: make([]map[string]int64, c.Shards),
+	}
+	for s := range g.unspent {
+		g.genesis[s] = make(map[string]int64)
+		for i := 0; i < c.Outputs; i++ {
+			out := g.newOutput(s, int64(1000+g.rand.Intn(1000)))
+			g.unspent[s] = append(g.unspent[s], out)
+			g.genesis[s][out.ID] = out.Value
+		}
+	}
+	return g, nil
+}
+
+// Genesis returns the unspent outputs of the shard at the start.
+func (g *Generator) Genesis(shard int) map[string]int64 {
+	utxos := make(map[string]int64)
+	for id, value := range g.genesis[shard] {
+		utxos[id] = value
+	}
+	return utxos
+}
+
+// Block returns n transactions spending distinct outputs, so they can be
+// submitted concurrently.
+func (g *Generator) Block(n int) ([]atomix.Transaction, error) {
+	return g.block(n, func() []int {
+		if g.rand.Float64() < g.CrossShard {
+			return g.rand.Perm(g.Shards)[:g.InputShards]
+		}
+		return []int{g.rand.Intn(g.Shards)}
+	})
+}
+
+// ShardBlock returns n transactions within the shard, spending distinct
+// outputs.
+func (g *Generator) ShardBlock(shard, n int) ([]atomix.Transaction, error) {
+	if shard < 0 || shard >= g.Shards {
+		return nil, fmt.Errorf("unknown shard %d", shard)
+	}
+	return g.block(n, func() []int {
+		return []int{shard}
+	})
+}
+
+// block returns n transactions spending the outputs of the shards returned
+// by inputShards.
+func (g *Generator) block(n int, inputShards func() []int) ([]atomix.Transaction, error) {
+	txs := make([]atomix.Transaction, 0, n)
+	var created []atomix.Output
+	for len(txs) < n {
+		inputs := inputShards()
+		tx := atomix.Transaction{}
+		var total int64
+		for _, s := range inputs {
+			if len(g.unspent[s]) == 0 {
+				return nil, fmt.Errorf("no output left in shard %d", s)
+			}
+			i := g.rand.Intn(len(g.unspent[s]))
+			out := g.unspent[s][i]
+			g.unspent[s][i] = g.unspent[s][len(g.unspent[s])-1]
+			g.unspent[s] = g.unspent[s][:len(g.unspent[s])-1]
+			tx.Inputs = append(tx.Inputs, atomix.Input{
+				Shard: s,
+				ID:    out.ID,
+				Value: out.Value,
+			})
+			total += out.Value
+		}
+		for i := 0; i < g.TxOutputs; i++ {
+			// a transaction within one shard stays in it
+			shard := inputs[0]
+			if len(inputs) > 1 {
+				shard = inputs[g.rand.Intn(len(inputs))]
+			}
+			value := total / int64(g.TxOutputs-i)
+			total -= value
+			out := g.newOutput(shard, value)
+			tx.Outputs = append(tx.Outputs, out)
+			created = append(created, out)
+		}
+		txs = append(txs, tx)
+	}
+	for _, out := range created {
+		g.unspent[out.Shard] = append(g.unspent[out.Shard], out)
+	}
+	return txs, nil
+}
+
+// newOutput returns a new output of the shard.
+func (g *Generator) newOutput(shard int, value int64) atomix.Output {
+	g.count++
+	return atomix.Output{
+		Shard: shard,
+		ID:    fmt.Sprintf("s%d-%d", shard, g.count),
+		Value: value,
+	}
+}
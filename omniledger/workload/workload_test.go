@@ -0,0 +1,107 @@
+package workload
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+func TestGenerator(t *testing.T) {
+	g, err := New(Config{Shards: 4, Outputs: 100, CrossShard: 0.3,
+		InputShards: 3, TxOutputs: 2, Seed: 1})
+	require.Nil(t, err)
+	utxos := make([]map[string]int64, 4)
+	for s := range utxos {
+		utxos[s] = g.Genesis(s)
+		assert.Equal(t, 100, len(utxos[s]))
+	}
+
+	cross := 0
+	for block := 0; block < 10; block++ {
+		txs, err := g.Block(50)
+		require.Nil(t, err)
+		require.Equal(t, 50, len(txs))
+		spent := make(map[string]bool)
+		for _, tx := range txs {
+			require.Nil(t, tx.Verify())
+			shards := tx.InputShards()
+			if len(shards) > 1 {
+				cross++
+				assert.Equal(t, 3, len(shards))
+			}
+			for _, in := range tx.Inputs {
+				require.False(t, spent[in.ID], "input spent twice in a block")
+				spent[in.ID] = true
+				value, ok := utxos[in.Shard][in.ID]
+				require.True(t, ok, "unknown input")
+				assert.Equal(t, value, in.Value)
+				delete(utxos[in.Shard], in.ID)
+			}
+			require.Equal(t, 2, len(tx.Outputs))
+			for _, out := range tx.Outputs {
+				assert.Contains(t, shards, out.Shard)
+				utxos[out.Shard][out.ID] = out.Value
+			}
+		}
+	}
+	assert.InDelta(t, 0.3, float64(cross)/500, 0.07)
+
+	// the same seed gives the same workload
+	g1, err := New(Config{Shards: 2, Outputs: 10, CrossShard: 0.5,
+		InputShards: 2, Seed: 2})
+	require.Nil(t, err)
+	g2, err := New(Config{Shards: 2, Outputs: 10, CrossShard: 0.5,
+		InputShards: 2, Seed: 2})
+	require.Nil(t, err)
+	assert.Equal(t, g1.Genesis(1), g2.Genesis(1))
+	txs1, err := g1.Block(5)
+	require.Nil(t, err)
+	txs2, err := g2.Block(5)
+	require.Nil(t, err)
+	assert.Equal(t, txs1, txs2)
+
+	// the outputs run out if they are spent faster than created
+	g, err = New(Config{Shards: 2, Outputs: 3, CrossShard: 1, InputShards: 2})
+	require.Nil(t, err)
+	_, err = g.Block(4)
+	assert.NotNil(t, err)
+}
+
+func TestShardBlock(t *testing.T) {
+	g, err := New(Config{Shards: 3, Outputs: 10})
+	require.Nil(t, err)
+	for round := 0; round < 5; round++ {
+		txs, err := g.ShardBlock(1, 10)
+		require.Nil(t, err)
+		require.Equal(t, 10, len(txs))
+		for _, tx := range txs {
+			assert.Equal(t, []int{1}, tx.InputShards())
+			assert.Equal(t, []int{1}, tx.OutputShards())
+		}
+	}
+	_, err = g.ShardBlock(1, 11)
+	assert.NotNil(t, err)
+	_, err = g.ShardBlock(3, 1)
+	assert.NotNil(t, err)
+}
+
+func TestConfig(t *testing.T) {
+	for _, c := range []Config{
+		{Shards: 0, Outputs: 1},
+		{Shards: 1, Outputs: 0},
+		{Shards: 2, Outputs: 1, CrossShard: 1.5, InputShards: 2},
+		{Shards: 2, Outputs: 1, CrossShard: 0.5, InputShards: 1},
+		{Shards: 2, Outputs: 1, CrossShard: 0.5, InputShards: 3},
+	} {
+		_, err := New(c)
+		assert.NotNil(t, err, "%+v", c)
+	}
+	_, err := New(Config{Shards: 1, Outputs: 1})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/dedis/crypto.v0/sign"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// The ways the replicas authenticate their pre-prepares, prepares and
+// new-views. The commits and the view-changes are always signed, see
+// phaseAuth.
+const (
+	// authNone sends the messages without authenticator, as the original
+	// simulation did.
+	authNone = "none"
+	// authSchnorr signs every message with the key of the replica.
+	authSchnorr = "schnorr"
+	// authMAC adds a vector of HMACs to every message, one for every
+	// replica, using the session keys of the replica pairs.
+	authMAC = "mac"
+)
+
+// authMode is the authentication used by all replicas. It is set by the
+// simulation on every node.
+var authMode = authNone
+
+// phaseAuth returns the authentication of the messages of the phase. The
+// commits are always signed, so that 2f+1 of them make a certificate anybody
+// can check, and so are the view-changes, which the new primary relays in
+// its new-view message.
+func phaseAuth(phase int) string {
+	if phase == phaseCommit || phase == phaseViewChange {
+		return authSchnorr
+	}
+	return authMode
+}
+
+// phasePrePrepare, phaseViewChange and phaseNewView are used to authenticate
+// the messages of the primaries and of the view changes, next to the phases
+// of the votes.
+const (
+	phasePrePrepare = -1
+	phaseViewChange = -2
+	phaseNewView    = -3
+)
+
+// setupSessionKeys derives a session key with every other replica by a
+// Diffie-Hellman exchange of the keys in the roster.
+func (p *Protocol) setupSessionKeys() error {
+	p.sessionKeys = make([][]byte, len(p.nodeList))
+	for i, tn := range p.nodeList {
+		if i == p.index {
+			continue
+		}
+		shared := p.suite.Point().Mul(tn.ServerIdentity.Public, p.Private())
+		buf, err := shared.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		key := sha256.Sum256(buf)
+		p.sessionKeys[i] = key[:]
+	}
+	return nil
+}
+
+// digest returns what is authenticated for a message of the given phase.
+func digest(phase, view, seq int, hash string) []byte {
+	d := sha256.Sum256([]byte(fmt.Sprintf("%d/%d/%d/%s", phase, view, seq, hash)))
+	return d[:]
+}
+
+// authenticate returns the authenticator of this replica for the message:
+// empty, a Schnorr signature or the vector of the MACs for all replicas. It
+// is never nil, as the network can't encode nil slices.
+func (p *Protocol) authenticate(phase, view, seq int, hash string) []byte {
+	mode := phaseAuth(phase)
+	if mode == authNone {
+		return []byte{}
+	}
+	defer p.measureAuth(time.Now())
+	msg := digest(phase, view, seq, hash)
+	switch mode {
+	case authSchnorr:
+		sig, err := sign.Schnorr(p.suite, p.Private(), msg)
+		if err != nil {
+			log.Error(p.Name(), "couldn't sign:", err)
+			return []byte{}
+		}
+		return sig
+	case authMAC:
+		var auth []byte
+		for i := range p.nodeList {
+			if i == p.index {
+				auth = append(auth, make([]byte, sha256.Size)...)
+				continue
+			}
+			auth = append(auth, mac(p.sessionKeys[i], msg)...)
+		}
+		return auth
+	}
+	log.Error(p.Name(), "Unknown authentication", mode)
+	return []byte{}
+}
+
+// verifyAuth checks the authenticator of sender for the message. For the MAC
+// vectors, only our entry is checked.
+func (p *Protocol) verifyAuth(sender, phase, view, seq int, hash string, auth []byte) bool {
+	mode := phaseAuth(phase)
+	if mode == authNone || sender == p.index {
+		return true
+	}
+	defer p.measureAuth(time.Now())
+	msg := digest(phase, view, seq, hash)
+	switch mode {
+	case authSchnorr:
+		public := p.nodeList[sender].ServerIdentity.Public
+		return sign.VerifySchnorr(p.suite, public, msg, auth) == nil
+	case authMAC:
+		start := p.index * sha256.Size
+		if len(auth) < start+sha256.Size {
+			return false
+		}
+		return hmac.Equal(auth[start:start+sha256.Size],
+			mac(p.sessionKeys[sender], msg))
+	}
+	return false
+}
+
+// verifyCertAuth checks the authenticator of sender for a message relayed
+// by another replica, e.g. a prepare of a prepared certificate. Unlike
+// verifyAuth, it also checks our own authenticators: the signatures with our
+// key, the MAC vectors by computing them again.
+func (p *Protocol) verifyCertAuth(sender, phase, view, seq int, hash string, auth []byte) bool {
+	if sender != p.index {
+		return p.verifyAuth(sender, phase, view, seq, hash, auth)
+	}
+	switch phaseAuth(phase) {
+	case authNone:
+		return true
+	case authSchnorr:
+		defer p.measureAuth(time.Now())
+		return sign.VerifySchnorr(p.suite, p.Public(), digest(phase, view, seq, hash),
+			auth) == nil
+	case authMAC:
+		return hmac.Equal(auth, p.authenticate(phase, view, seq, hash))
+	}
+	return false
+}
+
+// measureAuth adds the time since start to the time spent authenticating.
+func (p *Protocol) measureAuth(start time.Time) {
+	atomic.AddInt64(&p.authTime, int64(time.Since(start)))
+}
+
+// AuthTime returns the total time this replica spent creating and verifying
+// authenticators.
+func (p *Protocol) AuthTime() time.Duration {
+	return time.Duration(atomic.LoadInt64(&p.authTime))
+}
+
+// mac returns the HMAC of msg with the session key.
+func mac(key, msg []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(msg)
+	return h.Sum(nil)
+}
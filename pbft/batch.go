@@ -0,0 +1,137 @@
+package main
+
+import (
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// maxReadyBlocks is how many cut blocks may wait for the primary before the
+// batcher stops accepting transactions.
+const maxReadyBlocks = 2
+
+// batch is a block cut by the batcher together with the arrival times of its
+// transactions, so the simulation can measure the latency of every
+// transaction including the time it waited for the block to be full.
+type batch struct {
+	trBlock  *blockchain.TrBlock
+	arrivals []time.Time
+}
+
+// batcher is used by the primary to accumulate the transactions of the
+// clients. A block is cut either when blockSize transactions are pending,
+// when the next transaction doesn't fit in maxBytes bytes, or when timeout
+// passed since the first pending transaction arrived.
+type batcher struct {
+	blockSize int
+	// maxBytes is the most bytes of transactions of a block, 0 for no
+	// limit. If it is set, a blockSize of 0 means no limit.
+	maxBytes int
+	timeout  time.Duration
+
+	transactionChan chan blkparser.Tx
+	batchChan       chan *batch
+	stopChan        chan bool
+}
+
+// newBatcher returns a batcher cutting blocks of blockSize transactions and
+// at most maxBytes bytes. A timeout of 0 means to always wait for full
+// blocks.
+func newBatcher(blockSize, maxBytes int, timeout time.Duration) *batcher {
+	b := &batcher{
+		blockSize:       blockSize,
+		maxBytes:        maxBytes,
+		timeout:         timeout,
+		transactionChan: make(chan blkparser.Tx),
+		batchChan:       make(chan *batch),
+		stopChan:        make(chan bool),
+	}
+	go b.listen()
+	return b
+}
+
+// AddTransaction adds a new client transaction to the pending ones.
+func (b *batcher) AddTransaction(tr blkparser.Tx) {
+	select {
+	case b.transactionChan <- tr:
+	case <-b.stopChan:
+	}
+}
+
+// nextBatch waits for the next block to propose.
+func (b *batcher) nextBatch() *batch {
+	return <-b.batchChan
+}
+
+// stop stops the batcher and all clients waiting in AddTransaction.
+func (b *batcher) stop() {
+	close(b.stopChan)
+}
+
+func (b *batcher) listen() {
+	var pending []blkparser.Tx
+	// size is the number of bytes of the pending transactions
+	var size int
+	// arrivals are the arrival times of the pending transactions
+	var arrivals []time.Time
+	var timeout <-chan time.Time
+	// blocks that are cut but not yet taken by the primary
+	var ready []*batch
+	// the blocks are chained, so that two blocks with the same transactions
+	// still have different hashes
+	parent := ""
+	cut := func(reason string) {
+		log.Lvl3("Cutting block of", len(pending), "transactions:", reason)
+		trlist := blockchain.NewTransactionList(pending, len(pending))
+		header := blockchain.NewHeader(trlist, parent, "")
+		trBlock := blockchain.NewTrBlock(trlist, header)
+		parent = trBlock.HeaderHash
+		ready = append(ready, &batch{
+			trBlock:  trBlock,
+			arrivals: arrivals,
+		})
+		pending = nil
+		arrivals = nil
+		size = 0
+		timeout = nil
+	}
+	for {
+		var out chan *batch
+		var next *batch
+		if len(ready) > 0 {
+			out = b.batchChan
+			next = ready[0]
+		}
+		in := b.transactionChan
+		if len(ready) >= maxReadyBlocks {
+			in = nil
+		}
+		select {
+		case tr := <-in:
+			if b.maxBytes > 0 && len(pending) > 0 && size+int(tr.Size) > b.maxBytes {
+				cut("block bytes full")
+			}
+			if len(pending) == 0 && b.timeout > 0 {
+				timeout = time.After(b.timeout)
+			}
+			pending = append(pending, tr)
+			arrivals = append(arrivals, time.Now())
+			size += int(tr.Size)
+			full := len(pending) >= b.blockSize
+			if b.maxBytes > 0 {
+				full = (b.blockSize > 0 && full) || size >= b.maxBytes
+			}
+			if full {
+				cut("block full")
+			}
+		case <-timeout:
+			cut("batch timeout")
+		case out <- next:
+			ready = ready[1:]
+		case <-b.stopChan:
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTx returns a transaction of its own.
+func testTx(i int) blkparser.Tx {
+	return blkparser.Tx{Hash: fmt.Sprintf("%064x", i+1)}
+}
+
+func TestBatcherBlockSize(t *testing.T) {
+	b := newBatcher(3, 0, 0)
+	defer b.stop()
+	go func() {
+		for i := 0; i < 6; i++ {
+			b.AddTransaction(testTx(i))
+		}
+	}()
+	first := b.nextBatch()
+	second := b.nextBatch()
+	for i, bt := range []*batch{first, second} {
+		require.Equal(t, 3, len(bt.trBlock.Txs))
+		assert.Equal(t, 3, len(bt.arrivals))
+		for j, tx := range bt.trBlock.Txs {
+			assert.Equal(t, testTx(3*i+j).Hash, tx.Hash)
+		}
+		assert.True(t, verifyBlock(bt.trBlock, bt.trBlock.Header.Parent, ""))
+	}
+	// the blocks are chained
+	assert.Equal(t, "", first.trBlock.Header.Parent)
+	assert.Equal(t, first.trBlock.HeaderHash, second.trBlock.Header.Parent)
+}
+
+func TestBatcherTimeout(t *testing.T) {
+	timeout := 50 * time.Millisecond
+	b := newBatcher(3, 0, timeout)
+	defer b.stop()
+	start := time.Now()
+	go func() {
+		for i := 0; i < 4; i++ {
+			b.AddTransaction(testTx(i))
+		}
+	}()
+	require.Equal(t, 3, len(b.nextBatch().trBlock.Txs))
+	// the last transaction is cut alone once the timeout passed
+	bt := b.nextBatch()
+	require.Equal(t, 1, len(bt.trBlock.Txs))
+	assert.True(t, time.Since(start) >= timeout)
+	assert.True(t, time.Since(bt.arrivals[0]) >= timeout)
+
+	// without a timeout, the batcher waits for full blocks
+	b = newBatcher(3, 0, 0)
+	defer b.stop()
+	b.AddTransaction(testTx(0))
+	select {
+	case <-b.batchChan:
+		t.Fatal("cut a block that isn't full")
+	case <-time.After(2 * timeout):
+	}
+}
+
+func TestBatcherMaxBytes(t *testing.T) {
+	sized := func(i int, size uint32) blkparser.Tx {
+		tx := testTx(i)
+		tx.Size = size
+		return tx
+	}
+	b := newBatcher(0, 250, 0)
+	defer b.stop()
+	go func() {
+		for i := 0; i < 3; i++ {
+			b.AddTransaction(sized(i, 100))
+		}
+		// a transaction larger than a block gets a block of its own
+		b.AddTransaction(sized(3, 300))
+		b.AddTransaction(sized(4, 50))
+		b.AddTransaction(sized(5, 200))
+	}()
+	for _, n := range []int{2, 1, 1, 2} {
+		assert.Equal(t, n, len(b.nextBatch().trBlock.Txs))
+	}
+
+	// the blocks are also cut at blockSize transactions
+	b = newBatcher(2, 1000, 0)
+	defer b.stop()
+	go func() {
+		for i := 0; i < 2; i++ {
+			b.AddTransaction(sized(i, 100))
+		}
+	}()
+	assert.Equal(t, 2, len(b.nextBatch().trBlock.Txs))
+}
+
+func TestBatcherStop(t *testing.T) {
+	b := newBatcher(1, 0, 0)
+	// the primary doesn't take the blocks, so the clients block
+	added := make(chan bool)
+	go func() {
+		for i := 0; i < maxReadyBlocks+1; i++ {
+			b.AddTransaction(testTx(i))
+		}
+		added <- true
+	}()
+	select {
+	case <-added:
+		t.Fatal("accepted more transactions than ready blocks")
+	case <-time.After(50 * time.Millisecond):
+	}
+	b.stop()
+	<-added
+}
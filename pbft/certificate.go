@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dedis/paper_17_sosp_omniledger/crypto"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/onet.v1"
+)
+
+// CommitCertificate proves that a block has been committed: it holds the
+// signed commits of 2f+1 replicas for the block in the same view and with the
+// same sequence number. It can be checked by anybody knowing the roster.
+type CommitCertificate struct {
+	HeaderHash string
+	View       int
+	Seq        int
+	Signatures []CommitSignature
+}
+
+// CommitSignature is the signed commit of one replica.
+type CommitSignature struct {
+	// Index is the index of the replica in the roster
+	Index int
+	Sig   []byte
+}
+
+// certificate returns the certificate of the committed instance, made of the
+// signatures of the commits matching the block.
+func (p *Protocol) certificate(inst *instance) CommitCertificate {
+	hash := headerHash(inst.trBlock)
+	cert := CommitCertificate{
+		HeaderHash: hash,
+		View:       p.view,
+		Seq:        inst.seq,
+	}
+	for _, i := range senders(inst.commits, hash) {
+		sig, ok := inst.commitSigs[i]
+		if !ok {
+			continue
+		}
+		idx, _ := p.Roster().Search(p.nodeList[i].ServerIdentity.ID)
+		cert.Signatures = append(cert.Signatures, CommitSignature{
+			Index: idx,
+			Sig:   sig,
+		})
+	}
+	return cert
+}
+
+// VerifyCommitCertificate checks that the certificate holds valid commit
+// signatures of 2f+1 distinct replicas of the roster, made with the suite of
+// the keys of the roster.
+func VerifyCommitCertificate(suite abstract.Suite, roster *onet.Roster, cert *CommitCertificate) error {
+	n := len(roster.List)
+	threshold := 2*((n-1)/3) + 1
+	msg := digest(phaseCommit, cert.View, cert.Seq, cert.HeaderHash)
+	signers := make(map[int]bool)
+	pubs := make([]abstract.Point, len(cert.Signatures))
+	msgs := make([][]byte, len(cert.Signatures))
+	sigs := make([][]byte, len(cert.Signatures))
+	for i, s := range cert.Signatures {
+		if s.Index < 0 || s.Index >= n {
+			return fmt.Errorf("unknown replica %d", s.Index)
+		}
+		if signers[s.Index] {
+			return fmt.Errorf("replica %d signed twice", s.Index)
+		}
+		pubs[i] = roster.List[s.Index].Public
+		msgs[i] = msg
+		sigs[i] = s.Sig
+		signers[s.Index] = true
+	}
+	if len(signers) < threshold {
+		return errors.New("not enough signatures")
+	}
+	if crypto.VerifySignSchnorrBatch(suite, pubs, msgs, sigs) == nil {
+		return nil
+	}
+	// find the wrong signature, checked as in the batch
+	for i, s := range cert.Signatures {
+		if err := crypto.VerifySignSchnorrBatch(suite, pubs[i:i+1], msgs[i:i+1], sigs[i:i+1]); err != nil {
+			return fmt.Errorf("invalid signature of replica %d: %v", s.Index, err)
+		}
+	}
+	return errors.New("invalid signatures")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"time"
+
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// replyCollector is used by the client to wait for the replies of the
+// replicas. A block is only accepted once f+1 replicas replied with the same
+// result, as at least one of them is honest.
+type replyCollector struct {
+	f int
+	// replies holds the replicas that replied, per block hash
+	replies map[string]map[int]bool
+	// proposed holds the time the client sent the block
+	proposed map[string]time.Time
+	// accepted are the blocks that got f+1 matching replies
+	accepted map[string]bool
+}
+
+// newReplyCollector returns a collector waiting for f+1 matching replies.
+func newReplyCollector(f int) *replyCollector {
+	return &replyCollector{
+		f:        f,
+		replies:  make(map[string]map[int]bool),
+		proposed: make(map[string]time.Time),
+		accepted: make(map[string]bool),
+	}
+}
+
+// sent remembers when the client sent the block.
+func (rc *replyCollector) sent(hash string) {
+	if _, ok := rc.proposed[hash]; !ok {
+		rc.proposed[hash] = time.Now()
+	}
+}
+
+// add stores the reply of the replica. It returns true and the latency the
+// client saw if the block got its f+1-th matching reply.
+func (rc *replyCollector) add(replica int, r *Reply) (time.Duration, bool) {
+	start, ok := rc.proposed[r.HeaderHash]
+	if !ok || rc.accepted[r.HeaderHash] {
+		// a block we didn't send or already accepted
+		return 0, false
+	}
+	if rc.replies[r.HeaderHash] == nil {
+		rc.replies[r.HeaderHash] = make(map[int]bool)
+	}
+	rc.replies[r.HeaderHash][replica] = true
+	if len(rc.replies[r.HeaderHash]) < rc.f+1 {
+		return 0, false
+	}
+	log.Lvl3("Got", rc.f+1, "matching replies for", r.HeaderHash)
+	rc.accepted[r.HeaderHash] = true
+	delete(rc.replies, r.HeaderHash)
+	delete(rc.proposed, r.HeaderHash)
+	return time.Since(start), true
+}
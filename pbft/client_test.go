@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/dedis/onet.v1"
+)
+
+func TestReplyCollector(t *testing.T) {
+	rc := newReplyCollector(2)
+	hash := testBlock(0).HeaderHash
+	rc.sent(hash)
+	time.Sleep(10 * time.Millisecond)
+	// sending the block again doesn't restart its clock
+	rc.sent(hash)
+	reply := &Reply{Seq: 1, HeaderHash: hash}
+
+	_, ok := rc.add(0, reply)
+	assert.False(t, ok)
+	// the same replica replying twice counts once
+	_, ok = rc.add(0, reply)
+	assert.False(t, ok)
+	_, ok = rc.add(1, reply)
+	assert.False(t, ok)
+	latency, ok := rc.add(3, reply)
+	assert.True(t, ok)
+	assert.True(t, latency >= 10*time.Millisecond)
+	// the replies after the f+1-th are ignored
+	_, ok = rc.add(2, reply)
+	assert.False(t, ok)
+
+	// a block the client didn't send
+	other := &Reply{Seq: 2, HeaderHash: testBlock(1).HeaderHash}
+	for i := 0; i < 3; i++ {
+		_, ok = rc.add(i, other)
+		assert.False(t, ok)
+	}
+	assert.Empty(t, rc.replies)
+	assert.Empty(t, rc.proposed)
+}
+
+func TestReplies(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	p, _ := testRun(t, local, 7, 5, nil)
+	checkLog(t, p, 5)
+	// wait for the late replies before looking at the client
+	time.Sleep(100 * time.Millisecond)
+	testStop(p)
+	<-p.doneChan
+	for r := 0; r < 5; r++ {
+		assert.True(t, p.replies.accepted[testBlock(r).HeaderHash], "block %d", r)
+	}
+	assert.Empty(t, p.replies.proposed)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"time"
+
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// treeDissemination makes the replicas send their prepares and commits over
+// the tree instead of to everybody: the votes are aggregated on the way up to
+// the root, which sends them down the tree again. It is set by the simulation
+// on every node.
+var treeDissemination = false
+
+// aggregationTimeout is how long a node waits for the votes of its subtree
+// before passing on the votes it has.
+var aggregationTimeout = 100 * time.Millisecond
+
+// The phases of the votes sent over the tree.
+const (
+	phasePrepare = iota
+	phaseCommit
+)
+
+// aggKey identifies the votes aggregated by a node.
+type aggKey struct {
+	phase int
+	view  int
+	seq   int
+}
+
+// aggregation holds the votes of the subtree of a node for one phase of an
+// instance.
+type aggregation struct {
+	// votes holds the votes indexed by the sender
+	votes map[int]Vote
+	// sent are the senders whose votes have been passed on
+	sent  map[int]bool
+	timer *time.Timer
+}
+
+// sendVote sends our prepare or commit to the other replicas, either to all
+// of them or over the tree. It returns the authenticator of the vote.
+func (p *Protocol) sendVote(phase int, view, seq int, hash string) []byte {
+	auth := p.authenticate(phase, view, seq, hash)
+	if p.recovering {
+		return auth
+	}
+	if !treeDissemination {
+		var msg interface{} = &Prepare{HeaderHash: hash, View: view, Seq: seq,
+			Auth: auth}
+		if phase == phaseCommit {
+			msg = &Commit{HeaderHash: hash, View: view, Seq: seq, Auth: auth}
+		}
+		p.broadcast(func(tn *onet.TreeNode) {
+			if err := p.sendTo(tn, msg); err != nil {
+				log.Error(p.Name(), "Error while broadcasting vote =>", err)
+			}
+		})
+		return auth
+	}
+	p.aggregate(aggKey{phase, view, seq}, []Vote{{Sender: p.index,
+		HeaderHash: hash, Auth: auth}})
+	return auth
+}
+
+// handleVotes is called when votes arrive over the tree. Votes coming up
+// are aggregated and passed on to the parent, votes coming down are passed
+// on to the children. In both cases we count them.
+func (p *Protocol) handleVotes(tn *onet.TreeNode, v *Votes) {
+	if v.Down {
+		p.sendVotes(p.Children(), v)
+	} else {
+		p.aggregate(aggKey{v.Phase, v.View, v.Seq}, v.Votes)
+	}
+	for _, vote := range v.Votes {
+		if vote.Sender < 0 || vote.Sender >= len(p.nodeList) ||
+			vote.Sender == p.index {
+			continue
+		}
+		sender := p.nodeList[vote.Sender]
+		switch v.Phase {
+		case phasePrepare:
+			p.handlePrepare(prepareChan{sender, Prepare{
+				HeaderHash: vote.HeaderHash, View: v.View, Seq: v.Seq,
+				Auth: vote.Auth}})
+		case phaseCommit:
+			p.handleCommit(commitChan{sender, Commit{
+				HeaderHash: vote.HeaderHash, View: v.View, Seq: v.Seq,
+				Auth: vote.Auth}})
+		}
+	}
+}
+
+// aggregate adds the votes to the ones of our subtree. Once all the nodes of
+// the subtree voted, or after aggregationTimeout, the votes are passed on.
+func (p *Protocol) aggregate(key aggKey, votes []Vote) {
+	if key.view < p.view {
+		return
+	}
+	agg, ok := p.aggregations[key]
+	if !ok {
+		agg = &aggregation{
+			votes: make(map[int]Vote),
+			sent:  make(map[int]bool),
+		}
+		p.aggregations[key] = agg
+		agg.timer = time.AfterFunc(aggregationTimeout, func() {
+			select {
+			case p.flushChan <- key:
+			case <-p.doneChan:
+			}
+		})
+	}
+	for _, vote := range votes {
+		agg.votes[vote.Sender] = vote
+	}
+	// the primary doesn't send a prepare
+	expected := p.subtreeSize(p.TreeNode())
+	if key.phase == phasePrepare && p.inSubtree(p.TreeNode(), p.primary(key.view)) {
+		expected--
+	}
+	if len(agg.votes) >= expected || len(agg.sent) > 0 {
+		p.flush(key)
+	}
+}
+
+// flush passes on the votes of the subtree that haven't been sent yet: to
+// the parent, or if we are the root, down the tree.
+func (p *Protocol) flush(key aggKey) {
+	agg, ok := p.aggregations[key]
+	if !ok || p.crashed {
+		return
+	}
+	v := &Votes{Phase: key.phase, View: key.view, Seq: key.seq}
+	for sender, vote := range agg.votes {
+		if !agg.sent[sender] {
+			agg.sent[sender] = true
+			v.Votes = append(v.Votes, vote)
+		}
+	}
+	if len(v.Votes) == 0 {
+		return
+	}
+	if p.IsRoot() {
+		v.Down = true
+		p.sendVotes(p.Children(), v)
+		return
+	}
+	p.sendVotes([]*onet.TreeNode{p.Parent()}, v)
+}
+
+// sendVotes sends the votes to all given nodes.
+func (p *Protocol) sendVotes(nodes []*onet.TreeNode, v *Votes) {
+	for _, tn := range nodes {
+		go func(tn *onet.TreeNode) {
+			if err := p.sendTo(tn, v); err != nil {
+				log.Error(p.Name(), "Error while sending votes =>", err)
+			}
+		}(tn)
+	}
+}
+
+// dropAggregations stops the aggregations of the views before view.
+func (p *Protocol) dropAggregations(view int) {
+	for key, agg := range p.aggregations {
+		if key.view < view {
+			agg.timer.Stop()
+			delete(p.aggregations, key)
+		}
+	}
+}
+
+// stopAggregations stops all aggregations.
+func (p *Protocol) stopAggregations() {
+	for key, agg := range p.aggregations {
+		agg.timer.Stop()
+		delete(p.aggregations, key)
+	}
+}
+
+// subtreeSize returns the number of nodes in the subtree of tn, including tn.
+func (p *Protocol) subtreeSize(tn *onet.TreeNode) int {
+	size := 1
+	for _, c := range tn.Children {
+		size += p.subtreeSize(c)
+	}
+	return size
+}
+
+// inSubtree returns true if node is part of the subtree of tn.
+func (p *Protocol) inSubtree(tn, node *onet.TreeNode) bool {
+	if tn.ID.Equal(node.ID) {
+		return true
+	}
+	for _, c := range tn.Children {
+		if p.inSubtree(c, node) {
+			return true
+		}
+	}
+	return false
+}
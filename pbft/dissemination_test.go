@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/dedis/onet.v1"
+)
+
+func TestSubtree(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	p := testReplicas(t, local, 7)[0]
+	root := p.TreeNode()
+	assert.Equal(t, 7, p.subtreeSize(root))
+	child := root.Children[0]
+	assert.Equal(t, 3, p.subtreeSize(child))
+	leaf := child.Children[0]
+	assert.Equal(t, 1, p.subtreeSize(leaf))
+	assert.True(t, p.inSubtree(root, leaf))
+	assert.True(t, p.inSubtree(child, leaf))
+	assert.True(t, p.inSubtree(leaf, leaf))
+	assert.False(t, p.inSubtree(child, root))
+	assert.False(t, p.inSubtree(root.Children[1], leaf))
+}
+
+func TestTreeDissemination(t *testing.T) {
+	defer func() { treeDissemination = false }()
+	treeDissemination = true
+	for _, n := range []int{7, 15} {
+		local := onet.NewLocalTest()
+		p, _ := testRun(t, local, n, 5, nil)
+		checkLog(t, p, 5)
+		testStop(p)
+		local.CloseAll()
+	}
+}
+
+func TestTreeDisseminationFaults(t *testing.T) {
+	defer func(d time.Duration) { viewChangeTimeout = d }(viewChangeTimeout)
+	defer func() { treeDissemination, faultyHosts, faultType = false, 0, faultSilent }()
+	viewChangeTimeout = 500 * time.Millisecond
+	treeDissemination = true
+	// the votes of the honest replicas get through the faulty ones
+	for _, ft := range []string{faultEquivocate, faultSilent} {
+		faultyHosts = 2
+		faultType = ft
+		local := onet.NewLocalTest()
+		p, _ := testRun(t, local, 7, 4, nil)
+		checkLog(t, p, 4)
+		testStop(p)
+		local.CloseAll()
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"time"
+
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// The byzantine behaviours a faulty replica can show.
+const (
+	// faultEquivocate sends prepares and commits with a wrong header hash
+	// to half of the replicas.
+	faultEquivocate = "equivocate"
+	// faultSilent doesn't answer any message.
+	faultSilent = "silent"
+	// faultDelay delays every message by faultDelayTime.
+	faultDelay = "delay"
+	// faultWithhold takes part in the protocol but never votes: it doesn't
+	// send its prepares and commits, and leaves its vote out of the votes
+	// it passes on over the tree.
+	faultWithhold = "withhold"
+)
+
+// faultyHosts is the number of replicas showing faultType. They are the last
+// ones of the list, so the root always stays honest. Both are set by the
+// simulation on every node.
+var faultyHosts = 0
+var faultType = faultSilent
+
+// faultDelayTime is how long a replica with faultDelay holds back its
+// messages.
+var faultDelayTime = time.Second
+
+// faulty returns true if this replica is one of the faulty ones.
+func (p *Protocol) faulty() bool {
+	return p.index >= len(p.nodeList)-faultyHosts
+}
+
+// sendTo sends the message to the replica, applying the byzantine behaviour
+// of this replica if it is faulty.
+func (p *Protocol) sendTo(tn *onet.TreeNode, msg interface{}) error {
+	if !p.faulty() {
+		return p.SendTo(tn, msg)
+	}
+	switch faultType {
+	case faultSilent:
+		return nil
+	case faultDelay:
+		go func() {
+			time.Sleep(faultDelayTime)
+			if err := p.SendTo(tn, msg); err != nil {
+				log.Error(p.Name(), "couldn't send delayed message:", err)
+			}
+		}()
+		return nil
+	case faultEquivocate:
+		if p.nodeIndex(tn)%2 == 1 {
+			msg = p.equivocate(msg)
+		}
+	case faultWithhold:
+		if msg = p.withhold(msg); msg == nil {
+			return nil
+		}
+	default:
+		log.Error(p.Name(), "Unknown fault type", faultType)
+	}
+	return p.SendTo(tn, msg)
+}
+
+// equivocate returns a copy of the prepare or commit message voting for
+// another block, with a valid authenticator. For the votes sent over the
+// tree, only our own vote is changed. The replies to the client claim another
+// block has been executed. All other messages are returned unchanged.
+func (p *Protocol) equivocate(msg interface{}) interface{} {
+	switch m := msg.(type) {
+	case *Prepare:
+		bad := *m
+		bad.HeaderHash = "equivocate-" + m.HeaderHash
+		bad.Auth = p.authenticate(phasePrepare, m.View, m.Seq, bad.HeaderHash)
+		return &bad
+	case *Commit:
+		bad := *m
+		bad.HeaderHash = "equivocate-" + m.HeaderHash
+		bad.Auth = p.authenticate(phaseCommit, m.View, m.Seq, bad.HeaderHash)
+		return &bad
+	case *Reply:
+		bad := *m
+		bad.HeaderHash = "equivocate-" + m.HeaderHash
+		return &bad
+	case *Votes:
+		bad := *m
+		bad.Votes = make([]Vote, len(m.Votes))
+		for i, v := range m.Votes {
+			if v.Sender == p.index {
+				v.HeaderHash = "equivocate-" + v.HeaderHash
+				v.Auth = p.authenticate(m.Phase, m.View, m.Seq, v.HeaderHash)
+			}
+			bad.Votes[i] = v
+		}
+		return &bad
+	}
+	return msg
+}
+
+// withhold returns the message without our vote: nil for our prepares and
+// commits, and a copy of the votes passed on over the tree without ours. All
+// other messages are returned unchanged.
+func (p *Protocol) withhold(msg interface{}) interface{} {
+	switch m := msg.(type) {
+	case *Prepare, *Commit:
+		return nil
+	case *Votes:
+		bad := *m
+		bad.Votes = nil
+		for _, v := range m.Votes {
+			if v.Sender != p.index {
+				bad.Votes = append(bad.Votes, v)
+			}
+		}
+		return &bad
+	}
+	return msg
+}
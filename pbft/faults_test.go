@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/dedis/onet.v1"
+)
+
+func TestFaults(t *testing.T) {
+	defer func(d time.Duration) { viewChangeTimeout = d }(viewChangeTimeout)
+	defer func(d time.Duration) { faultDelayTime = d }(faultDelayTime)
+	defer func() { faultyHosts, faultType = 0, faultSilent }()
+	viewChangeTimeout = 500 * time.Millisecond
+	faultDelayTime = 100 * time.Millisecond
+	// the 2 faulty replicas of 7 can't keep the others from committing the
+	// blocks of the honest primary
+	for _, ft := range []string{faultEquivocate, faultSilent, faultDelay} {
+		faultyHosts = 2
+		faultType = ft
+		local := onet.NewLocalTest()
+		p, _ := testRun(t, local, 7, 4, nil)
+		checkLog(t, p, 4)
+		testStop(p)
+		local.CloseAll()
+	}
+}
+
+func TestEquivocate(t *testing.T) {
+	defer func() { authMode, faultyHosts = authNone, 0 }()
+	authMode = authSchnorr
+	faultyHosts = 1
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	ps := testReplicas(t, local, 4)
+	for _, p := range ps {
+		assert.Equal(t, p.index == 3, p.faulty())
+	}
+
+	hash := headerHash(testBlock(0))
+	prep := &Prepare{HeaderHash: hash, View: 1, Seq: 2,
+		Auth: ps[3].authenticate(phasePrepare, 1, 2, hash)}
+	bad := ps[3].equivocate(prep).(*Prepare)
+	assert.Equal(t, hash, prep.HeaderHash)
+	assert.NotEqual(t, hash, bad.HeaderHash)
+	// the other replicas can't tell it from an honest prepare
+	assert.True(t, ps[0].verifyAuth(3, phasePrepare, 1, 2, bad.HeaderHash, bad.Auth))
+	com := &Commit{HeaderHash: hash, View: 1, Seq: 2}
+	badCom := ps[3].equivocate(com).(*Commit)
+	assert.NotEqual(t, hash, badCom.HeaderHash)
+	assert.True(t, ps[0].verifyAuth(3, phaseCommit, 1, 2, badCom.HeaderHash, badCom.Auth))
+	// the blocks are passed on as they are
+	prePrep := &PrePrepare{TrBlock: testBlock(0)}
+	assert.Equal(t, prePrep, ps[3].equivocate(prePrep))
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/dedis/paper_17_sosp_omniledger/metrics"
 	"gopkg.in/dedis/onet.v1"
 )
 
@@ -10,7 +11,14 @@ import (
 
 // PrePrepare message
 type PrePrepare struct {
-	*blockchain.TrBlock
+	TrBlock *blockchain.TrBlock
+	View    int
+	Seq     int
+	// Auth is the authenticator of the primary
+	Auth []byte
+	// Packed is the compressed block, sent instead of TrBlock if the
+	// primary compresses the blocks
+	Packed *blockchain.PackedBlock
 }
 
 type prePrepareChan struct {
@@ -21,6 +29,9 @@ type prePrepareChan struct {
 // Prepare is the prepare packet
 type Prepare struct {
 	HeaderHash string
+	View       int
+	Seq        int
+	Auth       []byte
 }
 
 type prepareChan struct {
@@ -31,6 +42,9 @@ type prepareChan struct {
 // Commit is the commit packet in the protocol
 type Commit struct {
 	HeaderHash string
+	View       int
+	Seq        int
+	Auth       []byte
 }
 
 type commitChan struct {
@@ -38,6 +52,163 @@ type commitChan struct {
 	Commit
 }
 
+// Vote is the prepare or commit of one replica, sent over the tree.
+type Vote struct {
+	Sender     int
+	HeaderHash string
+	Auth       []byte
+}
+
+// Votes holds the prepares or commits aggregated by a subtree. On the way up
+// they are aggregated by every node, on the way down they are passed on as
+// they are.
+type Votes struct {
+	Phase int
+	View  int
+	Seq   int
+	Down  bool
+	Votes []Vote
+}
+
+// The pre-prepares are accounted to the announce phase of metrics, the
+// prepares and the commits to their phase.
+
+// MetricsPhase implements metrics.Phased.
+func (PrePrepare) MetricsPhase() string { return metrics.PhaseAnnounce }
+
+// MetricsPhase implements metrics.Phased.
+func (Prepare) MetricsPhase() string { return metrics.PhasePrepare }
+
+// MetricsPhase implements metrics.Phased.
+func (Commit) MetricsPhase() string { return metrics.PhaseCommit }
+
+// MetricsPhase implements metrics.Phased.
+func (v Votes) MetricsPhase() string {
+	if v.Phase == phaseCommit {
+		return metrics.PhaseCommit
+	}
+	return metrics.PhasePrepare
+}
+
+type votesChan struct {
+	*onet.TreeNode
+	Votes
+}
+
+// PreparedCert proves that a block has been prepared for a sequence number
+// in a view: it holds the block and the indices of the backups that sent a
+// matching prepare, with the authenticators of their prepares.
+type PreparedCert struct {
+	View    int
+	Seq     int
+	TrBlock *blockchain.TrBlock
+	// Prepares are int32 as the network can't decode slices of int
+	Prepares []int32
+	// Auths holds the authenticator of every prepare of Prepares
+	Auths [][]byte
+}
+
+// ViewChange is broadcast by a replica that suspects the primary and wants to
+// move to View.
+type ViewChange struct {
+	View int
+	// Replica is the index of the sender
+	Replica int
+	// LastCommitted is the highest sequence number committed by the replica
+	LastCommitted int
+	// Prepared holds the certificates of the blocks this replica prepared.
+	Prepared []PreparedCert
+	// PrePrepared holds the blocks this replica was working on without
+	// preparing them, with the authenticators of the primaries that
+	// pre-prepared them. As we don't simulate clients, they replace the
+	// client retransmitting its request to the new primary.
+	PrePrepared []PrePrepare
+	// Auth is the signature of the replica, see viewChangeHash. It is
+	// always a signature, as the new-view messages relay the view-changes
+	// to the other replicas.
+	Auth []byte
+}
+
+type viewChangeChan struct {
+	*onet.TreeNode
+	ViewChange
+}
+
+// NewView is sent by the primary of the new view. It contains the 2f+1
+// view-change messages it collected and the pre-prepares of the re-proposed
+// blocks.
+type NewView struct {
+	View        int
+	ViewChanges []ViewChange
+	PrePrepares []PrePrepare
+	// Auth is the authenticator of the primary, see newViewHash
+	Auth []byte
+}
+
+type newViewChan struct {
+	*onet.TreeNode
+	NewView
+}
+
+// Request is sent by the root to the primary with a block to order.
+type Request struct {
+	*blockchain.TrBlock
+}
+
+type requestChan struct {
+	*onet.TreeNode
+	Request
+}
+
+// Committed is sent by the primary to the root once a block is committed.
+type Committed struct {
+	Seq        int
+	View       int
+	HeaderHash string
+	// Certificate proves to anybody that the block is committed
+	Certificate CommitCertificate
+}
+
+type committedChan struct {
+	*onet.TreeNode
+	Committed
+}
+
+// Restart is sent by the root to the replicas the simulation restarts.
+type Restart struct{}
+
+type restartChan struct {
+	*onet.TreeNode
+	Restart
+}
+
+// Recovered is sent back to the root by a restarted replica once it replayed
+// its write-ahead log.
+type Recovered struct {
+	Replica int
+	View    int
+	// Log holds the header hashes of the recovered committed blocks
+	Log []string
+}
+
+type recoveredChan struct {
+	*onet.TreeNode
+	Recovered
+}
+
+// Reply is sent by every replica to the client, which is the root, once it
+// executed a block.
+type Reply struct {
+	Seq        int
+	View       int
+	HeaderHash string
+}
+
+type replyChan struct {
+	*onet.TreeNode
+	Reply
+}
+
 // Finish is just to tell the others node that the protocol is finished
 type Finish struct {
 	Done string
@@ -3,20 +3,45 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/dedis/paper_17_sosp_omniledger/faults"
 	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/simul/monitor"
 )
 
 const (
 	notFound = -1
 )
 
+// viewChangeTimeout is the time a replica waits for a block to be committed
+// before it suspects the primary and asks for a view change. It is set by the
+// simulation on every node.
+var viewChangeTimeout = 10 * time.Second
+
+// rotateLeader makes the replicas move to the next view every time they
+// committed all the blocks they know of, so that the primary changes every
+// round. It is set by the simulation on every node.
+var rotateLeader = false
+
+// windowSize is the number of blocks the primary may have in flight: it only
+// pre-prepares sequence numbers between the low watermark, which is the last
+// committed one, and the high watermark, which is windowSize above. The
+// backups only accept those of their own watermarks, see inWindow. It is set
+// by the simulation on every node.
+var windowSize = 1
+
+// compression is how the primary compresses the blocks it pre-prepares. It is
+// set by the simulation on every node.
+var compression blockchain.Compression
+
 // Protocol implements onet.Protocol
 // we do basically the same as in http://www.pmg.lcs.mit.edu/papers/osdi99.pdf
 // with the following diffs:
@@ -24,6 +49,11 @@ const (
 // instead of MACs we just send around the hash of the block
 // this will make the protocol faster, but the network latency will overweigh
 // this skipped computation anyways
+// there is no checkpointing, so all instances are kept in memory
+//
+// The protocol lives for the whole simulation: the root hands the blocks to
+// sign to Propose, the primary of the current view orders them with sequence
+// numbers, and every replica keeps the log of the committed blocks.
 type Protocol struct {
 	// the node we are represented-in
 	*onet.TreeNodeInstance
@@ -36,27 +66,130 @@ type Protocol struct {
 	// our index in the entitylist
 	index int
 
-	// we do not care for servers or clients (just store one block here)
-	trBlock *blockchain.TrBlock
+	// f is the number of faulty replicas we tolerate
+	f int
+	// threshold is the 2f+1 quorum needed to commit and to change views
+	threshold int
+	// view is the current view. The primary of a view is
+	// nodeList[view % len(nodeList)].
+	view int
+	// viewChanging is true between sending a view-change and accepting the
+	// new-view message
+	viewChanging bool
+	// instances holds the state of every sequence number we know of
+	instances map[int]*instance
+	// nextSeq is the sequence number the primary gives to the next block
+	nextSeq int
+	// lastCommitted is the highest sequence number such that all blocks up
+	// to it are committed and appended to the log
+	lastCommitted int
+	// committed is the ordered log of committed blocks
+	committed     []*blockchain.TrBlock
+	committedLock sync.Mutex
+	// missed is the number of sequence numbers we skipped because we
+	// didn't see them committed before a view change
+	missed int
+	// committedHashes holds the hashes of the committed blocks, so that a
+	// block ordered twice is only executed once
+	committedHashes map[string]bool
+	// outstanding are the blocks proposed on the root and not committed
+	// yet, in the order they were proposed
+	outstanding []*blockchain.TrBlock
+	// lastCommits holds the notification of every committed block, to
+	// answer the client if it sends the block again
+	lastCommits map[string]*Committed
+	// replies collects the replies of the replicas on the root, which is
+	// the client
+	replies *replyCollector
+	// prepares and commits of views we didn't enter yet
+	futurePrepares []prepareChan
+	futureCommits  []commitChan
+	// pre-prepares up to a window above our high watermark, from a primary
+	// that committed more blocks than we did, by sequence number
+	futurePrePrepares map[int]prePrepareChan
+	// requests received while changing the view or while the window was
+	// full
+	pendingRequests []*blockchain.TrBlock
 
-	prepMsgCount   int
-	commitMsgCount int
-	threshold      int
 	// channels:
 	prePrepareChan chan prePrepareChan
 	prepareChan    chan prepareChan
 	commitChan     chan commitChan
+	viewChangeChan chan viewChangeChan
+	newViewChan    chan newViewChan
+	requestChan    chan requestChan
+	committedChan  chan committedChan
+	restartChan    chan restartChan
+	recoveredChan  chan recoveredChan
+	votesChan      chan votesChan
+	replyChan      chan replyChan
+	proposeChan    chan *blockchain.TrBlock
 
-	onDoneCB func()
-
-	state int
+	// onCommitCB is called on the root every time the primary commits a
+	// block.
+	onCommitCB func(c *Committed)
+	// onRecoveredCB is called on the root every time a replica restarted
+	// and recovered from its log.
+	onRecoveredCB func(r *Recovered)
 
-	tempPrepareMsg []*Prepare
-	tempCommitMsg  []*Commit
+	// timeoutChan receives the view for which the view change timer fired
+	timeoutChan chan int
+	timer       *time.Timer
+	// viewChanges stores the view-change messages received per view and
+	// per replica
+	viewChanges map[int]map[int]*ViewChange
+	// newViewSent is the last view for which we sent a new-view message
+	newViewSent int
+	// rotationStart is when we started the last view change to rotate the
+	// leader
+	rotationStart time.Time
+	// aggregations holds the votes of our subtree when the votes are sent
+	// over the tree
+	aggregations map[aggKey]*aggregation
+	// flushChan receives the aggregations whose timeout fired
+	flushChan chan aggKey
+	// doneChan is closed once Dispatch returned, so that the timers
+	// firing late don't block
+	doneChan chan bool
+	// killPrimary makes the primary crash in the middle of the next
+	// pre-prepare broadcast. Used by the simulation to exercise the view
+	// change.
+	killPrimary bool
+	// crashed is set once we simulate a crashed node. A crashed root still
+	// passes the requests and notifications of the simulation.
+	crashed bool
+	// wal is the write-ahead log of the messages that changed our state
+	wal *os.File
+	// recovering is true while we replay the write-ahead log
+	recovering bool
+	// sessionKeys are the keys shared with every replica for the MACs
+	sessionKeys [][]byte
+	// authTime is the time in nanoseconds spent on authenticators
+	authTime int64
 
 	finishChan chan finishChan
 }
 
+// instance is the state of the protocol for one sequence number.
+type instance struct {
+	seq int
+	// the block of this instance. A nil block is a null request.
+	trBlock *blockchain.TrBlock
+	state   int
+	// view and authenticator of the pre-prepare of the block
+	view int
+	auth []byte
+	// header hashes of the prepares and commits, indexed by sender
+	prepares map[int]string
+	commits  map[int]string
+	// authenticators of the prepares and signatures of the commits,
+	// indexed by sender
+	prepareAuths map[int][]byte
+	commitSigs   map[int][]byte
+	// prepared is set once the instance is prepared in the current view
+	prepared *PreparedCert
+}
+
 const (
 	statePrePrepare = iota
 	statePrepare
@@ -67,9 +200,9 @@ const (
 // NewProtocol returns a new pbft protocol
 func NewProtocol(n *onet.TreeNodeInstance) (*Protocol, error) {
 	pbft := new(Protocol)
-	pbft.state = statePrePrepare
 	tree := n.Tree()
 	pbft.TreeNodeInstance = n
+	pbft.suite = n.Suite()
 	pbft.nodeList = tree.List()
 	idx := notFound
 	for i, tn := range pbft.nodeList {
@@ -81,10 +214,27 @@ func NewProtocol(n *onet.TreeNodeInstance) (*Protocol, error) {
 		panic(fmt.Sprintf("Could not find ourselves %+v in the list of nodes %+v", n, pbft.nodeList))
 	}
 	pbft.index = idx
-	// 2/3 * #participants == threshold FIXME the threshold is actually XXX
-	pbft.threshold = int(math.Ceil(float64(len(pbft.nodeList)) * 2.0 / 3.0))
-	pbft.prepMsgCount = 0
-	pbft.commitMsgCount = 0
+	// n = 3f + 1 and we need 2f + 1 to agree
+	pbft.f = (len(pbft.nodeList) - 1) / 3
+	pbft.threshold = 2*pbft.f + 1
+	if authMode == authMAC {
+		var setup *monitor.TimeMeasure
+		if n.IsRoot() {
+			setup = monitor.NewTimeMeasure("session_setup")
+		}
+		if err := pbft.setupSessionKeys(); err != nil {
+			return pbft, err
+		}
+		if setup != nil {
+			setup.Record()
+		}
+	}
+	pbft.reset()
+	pbft.timeoutChan = make(chan int, 1)
+	pbft.flushChan = make(chan aggKey)
+	pbft.doneChan = make(chan bool)
+	pbft.replies = newReplyCollector(pbft.f)
+	pbft.proposeChan = make(chan *blockchain.TrBlock)
 
 	if err := n.RegisterChannel(&pbft.prePrepareChan); err != nil {
 		return pbft, err
@@ -95,166 +245,620 @@ func NewProtocol(n *onet.TreeNodeInstance) (*Protocol, error) {
 	if err := n.RegisterChannel(&pbft.commitChan); err != nil {
 		return pbft, err
 	}
+	if err := n.RegisterChannel(&pbft.viewChangeChan); err != nil {
+		return pbft, err
+	}
+	if err := n.RegisterChannel(&pbft.newViewChan); err != nil {
+		return pbft, err
+	}
+	if err := n.RegisterChannel(&pbft.requestChan); err != nil {
+		return pbft, err
+	}
+	if err := n.RegisterChannel(&pbft.committedChan); err != nil {
+		return pbft, err
+	}
+	if err := n.RegisterChannel(&pbft.restartChan); err != nil {
+		return pbft, err
+	}
+	if err := n.RegisterChannel(&pbft.recoveredChan); err != nil {
+		return pbft, err
+	}
+	if err := n.RegisterChannel(&pbft.votesChan); err != nil {
+		return pbft, err
+	}
+	if err := n.RegisterChannel(&pbft.replyChan); err != nil {
+		return pbft, err
+	}
 	if err := n.RegisterChannel(&pbft.finishChan); err != nil {
 		return pbft, err
 	}
 
+	if err := pbft.Recover(); err != nil {
+		return pbft, err
+	}
+	if err := pbft.openWAL(); err != nil {
+		return pbft, err
+	}
 	return pbft, nil
 }
 
+// reset sets the replica to the state of a fresh start.
+func (p *Protocol) reset() {
+	p.view = 0
+	p.viewChanging = false
+	p.instances = make(map[int]*instance)
+	p.nextSeq = 1
+	p.lastCommitted = 0
+	p.missed = 0
+	p.committedLock.Lock()
+	p.committed = nil
+	p.committedLock.Unlock()
+	p.committedHashes = make(map[string]bool)
+	p.lastCommits = make(map[string]*Committed)
+	p.futurePrepares = nil
+	p.futureCommits = nil
+	p.futurePrePrepares = make(map[int]prePrepareChan)
+	p.pendingRequests = nil
+	p.viewChanges = make(map[int]map[int]*ViewChange)
+	p.newViewSent = 0
+	p.stopAggregations()
+	p.aggregations = make(map[aggKey]*aggregation)
+}
+
+// SendTo shadows the one of the TreeNodeInstance to send the message
+// through the emulated network of the simulation, if any, see netem, with
+// the faults of the simulation.
+func (p *Protocol) SendTo(to *onet.TreeNode, msg interface{}) error {
+	return faults.SendTo(p.TreeNodeInstance, to, msg)
+}
+
 // Dispatch implements onet.Protocol (and listens on all message channels)
 func (p *Protocol) Dispatch() error {
 	for {
 		select {
 		case msg := <-p.prePrepareChan:
-			p.handlePrePrepare(&msg.PrePrepare)
+			if !p.crashed {
+				p.handlePrePrepare(msg.TreeNode, &msg.PrePrepare)
+			}
 		case msg := <-p.prepareChan:
-			p.handlePrepare(&msg.Prepare)
+			if !p.crashed {
+				p.handlePrepare(msg)
+			}
 		case msg := <-p.commitChan:
-			p.handleCommit(&msg.Commit)
+			if !p.crashed {
+				p.handleCommit(msg)
+			}
+		case msg := <-p.viewChangeChan:
+			if !p.crashed {
+				p.handleViewChange(msg.TreeNode, &msg.ViewChange)
+			}
+		case msg := <-p.newViewChan:
+			if !p.crashed {
+				p.handleNewView(msg.TreeNode, &msg.NewView)
+			}
+		case msg := <-p.votesChan:
+			if !p.crashed {
+				p.handleVotes(msg.TreeNode, &msg.Votes)
+			}
+		case key := <-p.flushChan:
+			p.flush(key)
+		case view := <-p.timeoutChan:
+			if !p.crashed {
+				p.handleTimeout(view)
+			}
+		case msg := <-p.requestChan:
+			p.handleRequest(msg.TrBlock)
+		case <-p.restartChan:
+			if !p.crashed {
+				p.restart()
+			}
+		case msg := <-p.recoveredChan:
+			if p.onRecoveredCB != nil {
+				p.onRecoveredCB(&msg.Recovered)
+			}
+		case msg := <-p.committedChan:
+			p.handleCommitted(&msg.Committed)
+		case msg := <-p.replyChan:
+			p.handleReply(msg.TreeNode, &msg.Reply)
+		case block := <-p.proposeChan:
+			p.outstanding = append(p.outstanding, block)
+			p.replies.sent(block.HeaderHash)
+			p.handleRequest(block)
 		case <-p.finishChan:
 			log.Lvl3(p.Name(), "Got Done Message ! FINISH")
+			p.stopTimer()
+			p.stopAggregations()
+			p.closeWAL()
+			close(p.doneChan)
 			p.Done()
 			return nil
 		}
 	}
 }
 
-// Start implements the ProtocolInstance interface of onet.
+// Start implements the ProtocolInstance interface of onet. The blocks are
+// given to the protocol with Propose.
 func (p *Protocol) Start() error {
-	return p.PrePrepare()
+	if !p.IsRoot() {
+		return errors.New("only the root can start the protocol")
+	}
+	return nil
+}
+
+// Propose is called by the simulation on the root to order a new block. If
+// the root is not the primary, it forwards the block to the primary.
+func (p *Protocol) Propose(block *blockchain.TrBlock) {
+	p.proposeChan <- block
+}
+
+// Stop is called by the root at the end of the simulation to stop all
+// replicas.
+func (p *Protocol) Stop() {
+	p.finish()
+}
+
+// RestartReplicas is called by the simulation on the root to restart the
+// first n replicas following the root. They forget their state and recover
+// it from their write-ahead log.
+func (p *Protocol) RestartReplicas(n int) error {
+	if n >= len(p.nodeList) {
+		return errors.New("can't restart more replicas than there are")
+	}
+	for i := 1; i <= n; i++ {
+		if err := p.SendTo(p.nodeList[i], &Restart{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CommittedBlocks returns the log of the blocks committed by this replica,
+// ordered by sequence number.
+func (p *Protocol) CommittedBlocks() []*blockchain.TrBlock {
+	p.committedLock.Lock()
+	defer p.committedLock.Unlock()
+	return append([]*blockchain.TrBlock{}, p.committed...)
+}
+
+// handleRequest orders the block if we are the primary, else it passes the
+// block on to the primary.
+func (p *Protocol) handleRequest(block *blockchain.TrBlock) {
+	if p.crashed && !p.IsRoot() {
+		log.Lvl3(p.Name(), "Crashed: dropping request")
+		return
+	}
+	if p.crashed && p.index == p.primaryIndex(p.view) {
+		// keep it until we know the new primary
+		p.pendingRequests = append(p.pendingRequests, block)
+		return
+	}
+	if p.crashed || p.index != p.primaryIndex(p.view) {
+		log.Lvl3(p.Name(), "Forwarding request to", p.primary(p.view).Name())
+		if err := p.SendTo(p.primary(p.view), &Request{block}); err != nil {
+			log.Error(p.Name(), "couldn't forward request:", err)
+		}
+		return
+	}
+	if c, ok := p.lastCommits[block.HeaderHash]; ok {
+		// the client didn't get our notification, as it changed the
+		// view before
+		log.Lvl3(p.Name(), "Block already committed: notifying again")
+		p.notifyRoot(c)
+		return
+	}
+	if p.knows(block.HeaderHash) {
+		log.Lvl3(p.Name(), "Dropping request for a block we already have")
+		return
+	}
+	p.pendingRequests = append(p.pendingRequests, block)
+	p.proposePending()
+}
+
+// knows returns true if the block is committed, waiting to be pre-prepared
+// or in one of the instances not committed yet.
+func (p *Protocol) knows(hash string) bool {
+	if p.committedHashes[hash] {
+		return true
+	}
+	for _, block := range p.pendingRequests {
+		if block.HeaderHash == hash {
+			return true
+		}
+	}
+	for seq, inst := range p.instances {
+		if seq > p.lastCommitted && headerHash(inst.trBlock) == hash {
+			return true
+		}
+	}
+	return false
 }
 
-// PrePrepare intializes a full run of the protocol.
-func (p *Protocol) PrePrepare() error {
+// resubmit is called on the root when it learns of a new view. Like a client
+// of PBFT retransmitting its requests, it sends all the blocks that are not
+// committed yet to the new primary, which drops the ones it already has.
+func (p *Protocol) resubmit() {
+	p.pendingRequests = nil
+	for _, block := range p.outstanding {
+		p.handleRequest(block)
+	}
+}
+
+// proposePending pre-prepares the waiting requests as long as the high
+// watermark allows it.
+func (p *Protocol) proposePending() {
+	for len(p.pendingRequests) > 0 && !p.viewChanging && !p.crashed &&
+		p.index == p.primaryIndex(p.view) && p.nextSeq <= p.highWatermark() {
+		block := p.pendingRequests[0]
+		p.pendingRequests = p.pendingRequests[1:]
+		if err := p.PrePrepare(block); err != nil {
+			log.Error(p.Name(), "couldn't pre-prepare:", err)
+		}
+	}
+	if len(p.pendingRequests) > 0 {
+		log.Lvl3(p.Name(), "Window full:", len(p.pendingRequests),
+			"requests waiting")
+	}
+}
+
+// forwardPending passes the requests we couldn't pre-prepare on to the
+// primary of the current view.
+func (p *Protocol) forwardPending() {
+	requests := p.pendingRequests
+	p.pendingRequests = nil
+	for _, block := range requests {
+		p.handleRequest(block)
+	}
+}
+
+// highWatermark returns the highest sequence number the primary may give to
+// a block.
+func (p *Protocol) highWatermark() int {
+	return p.lastCommitted + windowSize
+}
+
+// replayFuturePrePrepares handles the pre-prepares that were stored because
+// they were above our high watermark.
+func (p *Protocol) replayFuturePrePrepares() {
+	future := p.futurePrePrepares
+	p.futurePrePrepares = make(map[int]prePrepareChan)
+	for seq := p.lastCommitted + 1; seq <= p.highWatermark(); seq++ {
+		if msg, ok := future[seq]; ok {
+			delete(future, seq)
+			p.handlePrePrepare(msg.TreeNode, &msg.PrePrepare)
+		}
+	}
+	for seq, msg := range future {
+		if seq > p.highWatermark() {
+			p.futurePrePrepares[seq] = msg
+		}
+	}
+}
+
+// inWindow tells whether a backup accepts a pre-prepare for seq: above the
+// low watermark and at most at the high watermark, unless the new-view of
+// the view re-proposed it.
+func (p *Protocol) inWindow(seq int) bool {
+	return seq > p.lastCommitted && (seq <= p.highWatermark() || seq < p.nextSeq)
+}
+
+// PrePrepare gives the next sequence number to the block and broadcasts it to
+// the backups.
+func (p *Protocol) PrePrepare(block *blockchain.TrBlock) error {
 	// pre-prepare: broadcast the block
 	var err error
-	log.Lvl2(p.Name(), "Broadcast PrePrepare")
-	prep := &PrePrepare{p.trBlock}
+	seq := p.nextSeq
+	p.nextSeq++
+	log.Lvl2(p.Name(), "Broadcast PrePrepare for seq", seq)
+	// number of blocks in flight, including this one
+	monitor.RecordSingleMeasure("pipeline_depth", float64(seq-p.lastCommitted))
+	auth := p.authenticate(phasePrePrepare, p.view, seq, headerHash(block))
+	p.logEntry(&walEntry{Type: walPrePrepare, View: p.view, Seq: seq,
+		TrBlock: block, Auth: auth})
+	inst := p.instance(seq)
+	inst.trBlock = block
+	inst.view, inst.auth = p.view, auth
+	inst.state = statePrepare
+	prep := &PrePrepare{TrBlock: block, View: p.view, Seq: seq, Auth: auth}
+	if block != nil && compression.Codec != blockchain.NoCompression {
+		packed, err := compression.Pack(block)
+		if err != nil {
+			return err
+		}
+		prep.TrBlock, prep.Packed = nil, packed
+	}
+	if p.killPrimary {
+		// only reach f replicas, not enough to prepare the block, and
+		// stop answering afterwards
+		log.Lvl1(p.Name(), "Primary crashes during PrePrepare")
+		p.killPrimary = false
+		p.crashed = true
+		p.stopTimer()
+		for i := 1; i <= p.f; i++ {
+			tn := p.nodeList[(p.index+i)%len(p.nodeList)]
+			if tempErr := p.sendTo(tn, prep); tempErr != nil {
+				err = tempErr
+			}
+		}
+		return err
+	}
 	p.broadcast(func(tn *onet.TreeNode) {
-		tempErr := p.SendTo(tn, prep)
+		tempErr := p.sendTo(tn, prep)
 		if tempErr != nil {
 			err = tempErr
 		}
-		p.state = statePrepare
 	})
 	log.Lvl3(p.Name(), "Broadcast PrePrepare DONE")
+	p.updateTimer()
 	return err
 }
 
-// handlePrePrepare receive preprepare messages and go to Prepare if it received
-// enough.
-func (p *Protocol) handlePrePrepare(prePre *PrePrepare) {
-	if p.state != statePrePrepare {
+// handlePrePrepare receives the block of an instance, verifies it and
+// broadcasts the prepare message.
+func (p *Protocol) handlePrePrepare(tn *onet.TreeNode, prePre *PrePrepare) {
+	if p.viewChanging || prePre.View != p.view || prePre.Seq <= 0 {
+		return
+	}
+	if prePre.Packed != nil {
+		block, err := prePre.Packed.Unpack()
+		if err != nil {
+			log.Lvl2(p.Name(), "Dropping PrePrepare with invalid block:", err)
+			return
+		}
+		prePre.TrBlock, prePre.Packed = block, nil
+	}
+	if tn != nil && !tn.ID.Equal(p.primary(p.view).ID) {
+		log.Lvl2(p.Name(), "Dropping PrePrepare not sent by the primary")
+		return
+	}
+	if tn != nil && !p.inWindow(prePre.Seq) {
+		if prePre.Seq > p.highWatermark() && prePre.Seq <= p.highWatermark()+windowSize {
+			// we didn't commit all the blocks the primary committed
+			p.futurePrePrepares[prePre.Seq] = prePrepareChan{tn, *prePre}
+			return
+		}
+		// else a faulty primary could exhaust the sequence numbers
+		log.Lvl2(p.Name(), "Dropping PrePrepare for seq", prePre.Seq,
+			"outside of the watermarks", p.lastCommitted, "and", p.highWatermark())
+		return
+	}
+	if tn != nil && !p.verifyAuth(p.primaryIndex(p.view), phasePrePrepare,
+		prePre.View, prePre.Seq, headerHash(prePre.TrBlock), prePre.Auth) {
+		log.Lvl2(p.Name(), "Dropping PrePrepare with invalid authenticator")
+		return
+	}
+	inst := p.instance(prePre.Seq)
+	if inst.state != statePrePrepare {
 		//log.Lvl3(p.Name(), "DROP preprepare packet : Already broadcasted prepare")
 		return
 	}
 	// prepare: verify the structure of the block and broadcast
 	// prepare msg (with header hash of the block)
 	log.Lvl3(p.Name(), "handlePrePrepare() BROADCASTING PREPARE msg")
-	var err error
-	if verifyBlock(prePre.TrBlock, "", "") {
-		// STATE TRANSITION PREPREPARE => PREPARE
-		p.state = statePrepare
-		prep := &Prepare{prePre.TrBlock.HeaderHash}
-		p.broadcast(func(tn *onet.TreeNode) {
-			//log.Print(p.Name(), "Sending PREPARE to", tn.Name(), "msg", prep)
-			tempErr := p.SendTo(tn, prep)
-			if tempErr != nil {
-				err = tempErr
-				log.Error(p.Name(), "Error broadcasting PREPARE =>", err)
-			}
-		})
-		// Already insert the previously received messages !
-		go func() {
-			for _, msg := range p.tempPrepareMsg {
-				p.prepareChan <- prepareChan{nil, *msg}
-			}
-			p.tempPrepareMsg = nil
-		}()
-		log.Lvl3(p.Name(), "handlePrePrepare() BROADCASTING PREPARE msgs DONE")
-	} else {
+	// the blocks can be re-ordered by a view change, so we don't check
+	// that they are chained
+	if prePre.TrBlock != nil && !verifyBlock(prePre.TrBlock, prePre.TrBlock.Header.Parent, "") {
 		log.Lvl3(p.Name(), "Block couldn't be verified")
+		return
 	}
-	if err != nil {
-		log.Error("Error while broadcasting Prepare msg", err)
+	p.logEntry(&walEntry{Type: walPrePrepare, View: p.view, Seq: prePre.Seq,
+		TrBlock: prePre.TrBlock, Auth: prePre.Auth})
+	// STATE TRANSITION PREPREPARE => PREPARE
+	inst.trBlock = prePre.TrBlock
+	inst.view, inst.auth = p.view, prePre.Auth
+	inst.state = statePrepare
+	hash := headerHash(inst.trBlock)
+	auth := p.sendVote(phasePrepare, p.view, inst.seq, hash)
+	log.Lvl3(p.Name(), "handlePrePrepare() BROADCASTING PREPARE msgs DONE")
+	// our own prepare counts, too
+	inst.prepares[p.index] = hash
+	inst.prepareAuths[p.index] = auth
+	p.checkInstance(inst)
+	p.updateTimer()
+}
+
+// handlePrepare stores the prepare message of a backup.
+func (p *Protocol) handlePrepare(msg prepareChan) {
+	pre := &msg.Prepare
+	if pre.View < p.view || pre.Seq <= 0 {
+		return
+	}
+	if p.viewChanging || pre.View > p.view {
+		//log.Lvl3(p.Name(), "STORE prepare packet: wrong view")
+		p.futurePrepares = append(p.futurePrepares, msg)
+		return
+	}
+	sender := p.nodeIndex(msg.TreeNode)
+	if sender == notFound || sender == p.primaryIndex(p.view) {
+		// the primary doesn't send any prepare message
+		return
 	}
+	inst := p.instance(pre.Seq)
+	if hash, ok := inst.prepares[sender]; ok && hash == pre.HeaderHash {
+		// already got it over another path of the tree
+		return
+	}
+	if !p.verifyAuth(sender, phasePrepare, pre.View, pre.Seq, pre.HeaderHash, pre.Auth) {
+		log.Lvl2(p.Name(), "Dropping Prepare with invalid authenticator")
+		return
+	}
+	p.logEntry(&walEntry{Type: walPrepare, View: pre.View, Seq: pre.Seq,
+		Sender: sender, HeaderHash: pre.HeaderHash, Auth: pre.Auth})
+	inst.prepares[sender] = pre.HeaderHash
+	inst.prepareAuths[sender] = pre.Auth
+	p.checkInstance(inst)
+	p.updateTimer()
 }
 
-func (p *Protocol) handlePrepare(pre *Prepare) {
-	if p.state != statePrepare {
-		//log.Lvl3(p.Name(), "STORE prepare packet: wrong state")
-		p.tempPrepareMsg = append(p.tempPrepareMsg, pre)
+// handleCommit stores the commit message of a replica.
+func (p *Protocol) handleCommit(msg commitChan) {
+	com := &msg.Commit
+	if com.View < p.view || com.Seq <= 0 {
 		return
 	}
-	p.prepMsgCount++
-	//log.Lvl3(p.Name(), "Handle Prepare", p.prepMsgCount,
-	//	"msgs and threshold is", p.threshold)
-	var localThreshold = p.threshold
-	// we dont have a "client", the root DONT send any prepare message
-	// so for the rest of the nodes the threshold is less one.
-	if !p.IsRoot() {
-		localThreshold--
+	if p.viewChanging || com.View > p.view {
+		//	log.Lvl3(p.Name(), "STORE handle commit packet")
+		p.futureCommits = append(p.futureCommits, msg)
+		return
 	}
-	if p.prepMsgCount >= localThreshold {
+	sender := p.nodeIndex(msg.TreeNode)
+	if sender == notFound {
+		return
+	}
+	inst := p.instance(com.Seq)
+	if hash, ok := inst.commits[sender]; ok && hash == com.HeaderHash {
+		// already got it over another path of the tree
+		return
+	}
+	if !p.verifyAuth(sender, phaseCommit, com.View, com.Seq, com.HeaderHash, com.Auth) {
+		log.Lvl2(p.Name(), "Dropping Commit with invalid authenticator")
+		return
+	}
+	p.logEntry(&walEntry{Type: walCommit, View: com.View, Seq: com.Seq,
+		Sender: sender, HeaderHash: com.HeaderHash, Auth: com.Auth})
+	inst.commits[sender] = com.HeaderHash
+	inst.commitSigs[sender] = com.Auth
+	p.checkInstance(inst)
+	p.updateTimer()
+}
+
+// checkInstance moves the instance to the next state if enough matching
+// messages arrived: 2f prepares from the backups to prepare it, and 2f+1
+// commits to commit it.
+func (p *Protocol) checkInstance(inst *instance) {
+	hash := headerHash(inst.trBlock)
+	if inst.state == statePrepare && count(inst.prepares, hash) >= 2*p.f {
 		// TRANSITION PREPARE => COMMIT
-		log.Lvl3(p.Name(), "Threshold (", localThreshold, ") reached: broadcast Commit")
-		p.state = stateCommit
-		// reset counter
-		p.prepMsgCount = 0
-		var err error
-		com := &Commit{pre.HeaderHash}
-		p.broadcast(func(tn *onet.TreeNode) {
-			tempErr := p.SendTo(tn, com)
-			if tempErr != nil {
-				log.Error(p.Name(), "Error while broadcasting Commit =>", tempErr)
-				err = tempErr
-			}
-		})
-		// Dispatch already the message we received earlier !
-		go func() {
-			for _, msg := range p.tempCommitMsg {
-				p.commitChan <- commitChan{nil, *msg}
-			}
-			p.tempCommitMsg = nil
-		}()
-		// sends to the channel the already commited messages
-		if err != nil {
-			log.Error("Error while broadcasting Commit msg", err)
+		log.Lvl3(p.Name(), "Threshold (", 2*p.f, ") reached for seq", inst.seq, ": broadcast Commit")
+		inst.state = stateCommit
+		prepares := senders(inst.prepares, hash)
+		inst.prepared = &PreparedCert{
+			View:     p.view,
+			Seq:      inst.seq,
+			TrBlock:  inst.trBlock,
+			Prepares: indices32(prepares),
+		}
+		for _, i := range prepares {
+			inst.prepared.Auths = append(inst.prepared.Auths, inst.prepareAuths[i])
 		}
+		inst.commitSigs[p.index] = p.sendVote(phaseCommit, p.view, inst.seq, hash)
+		inst.commits[p.index] = hash
+	}
+	if inst.state == stateCommit && count(inst.commits, hash) >= p.threshold {
+		log.Lvl3(p.Name(), "Threshold reached for seq", inst.seq, ": We are done... CONSENSUS")
+		inst.state = stateFinished
+		p.execute()
 	}
 }
 
-// handleCommit receives commit messages and signal the end if it received
-// enough of it.
-func (p *Protocol) handleCommit(com *Commit) {
-	if p.state != stateCommit {
-		//	log.Lvl3(p.Name(), "STORE handle commit packet")
-		p.tempCommitMsg = append(p.tempCommitMsg, com)
-		return
+// execute appends all committed blocks to the log, in order of their
+// sequence numbers.
+func (p *Protocol) execute() {
+	progressed := false
+	for {
+		inst, ok := p.instances[p.lastCommitted+1]
+		if !ok || inst.state != stateFinished {
+			break
+		}
+		p.lastCommitted++
+		progressed = true
+		p.committedLock.Lock()
+		executed := inst.trBlock != nil && !p.committedHashes[inst.trBlock.HeaderHash]
+		if executed {
+			p.committed = append(p.committed, inst.trBlock)
+			p.committedHashes[inst.trBlock.HeaderHash] = true
+		}
+		p.committedLock.Unlock()
+		c := &Committed{Seq: inst.seq, View: p.view,
+			HeaderHash:  headerHash(inst.trBlock),
+			Certificate: p.certificate(inst)}
+		if executed {
+			p.lastCommits[c.HeaderHash] = c
+		}
+		if executed && !p.recovering {
+			p.reply(&Reply{Seq: inst.seq, View: p.view,
+				HeaderHash: inst.trBlock.HeaderHash})
+		}
+		if p.index == p.primaryIndex(p.view) && !p.recovering {
+			log.Lvl3(p.Name(), "We are primary and seq", inst.seq, "is committed: return to the simulation.")
+			p.notifyRoot(c)
+		}
+	}
+	if progressed && !p.recovering {
+		// restart the timer if we're still waiting for other blocks
+		p.stopTimer()
+		p.updateTimer()
+		// the window moved
+		p.replayFuturePrePrepares()
+		p.proposePending()
+		if rotateLeader && !p.pending() && !p.viewChanging {
+			log.Lvl3(p.Name(), "Handing over to the next primary")
+			p.rotationStart = time.Now()
+			p.startViewChange(p.view + 1)
+		}
 	}
-	// finish after threshold of Commit msgs
-	p.commitMsgCount++
-	log.Lvl4(p.Name(), "----------------\nWe got", p.commitMsgCount,
-		"COMMIT msgs and threshold is", p.threshold)
+}
+
+// notifyRoot tells the root that a block has been committed.
+func (p *Protocol) notifyRoot(c *Committed) {
 	if p.IsRoot() {
-		log.Lvl4("Leader got ", p.commitMsgCount)
-	}
-	if p.commitMsgCount >= p.threshold {
-		p.state = stateFinished
-		// reset counter
-		p.commitMsgCount = 0
-		log.Lvl3(p.Name(), "Threshold reached: We are done... CONSENSUS")
-		if p.IsRoot() && p.onDoneCB != nil {
-			log.Lvl3(p.Name(), "We are root and threshold reached: return to the simulation.")
-			p.onDoneCB()
-			p.finish()
+		p.handleCommitted(c)
+		return
+	}
+	if err := p.sendTo(p.Root(), c); err != nil {
+		log.Error(p.Name(), "couldn't notify root:", err)
+	}
+}
+
+// handleCommitted is called on the root when the primary committed a block.
+func (p *Protocol) handleCommitted(c *Committed) {
+	if p.crashed && c.View > p.view {
+		// we don't take part in the view changes anymore, but need to
+		// know where to send the requests
+		p.view = c.View
+		p.resubmit()
+	}
+	for i, block := range p.outstanding {
+		if block.HeaderHash == c.HeaderHash {
+			p.outstanding = append(p.outstanding[:i], p.outstanding[i+1:]...)
+			break
 		}
+	}
+	if p.onCommitCB != nil {
+		p.onCommitCB(c)
+	}
+}
+
+// reply tells the client that we executed a block.
+func (p *Protocol) reply(r *Reply) {
+	if p.IsRoot() {
+		p.handleReply(p.TreeNode(), r)
+		return
+	}
+	if err := p.sendTo(p.Root(), r); err != nil {
+		log.Error(p.Name(), "couldn't reply to the client:", err)
+	}
+}
+
+// handleReply is called on the root for every reply of a replica. Once f+1
+// replicas sent the same reply, we record the latency the client saw.
+func (p *Protocol) handleReply(tn *onet.TreeNode, r *Reply) {
+	replica := p.nodeIndex(tn)
+	if replica == notFound {
 		return
 	}
+	if latency, ok := p.replies.add(replica, r); ok {
+		monitor.RecordSingleMeasure("client_latency", latency.Seconds())
+	}
 }
 
-// finish is called by the root to tell everyone the root is done
+// notifyRecovered tells the root the state we recovered after a restart.
+func (p *Protocol) notifyRecovered(r *Recovered) {
+	if err := p.SendTo(p.Root(), r); err != nil {
+		log.Error(p.Name(), "couldn't notify root:", err)
+	}
+}
+
+// finish is called by the root to tell everyone the simulation is done
 func (p *Protocol) finish() {
 	p.broadcast(func(tn *onet.TreeNode) {
 		if err := p.SendTo(tn, &Finish{"Finish"}); err != nil {
@@ -266,10 +870,65 @@ func (p *Protocol) finish() {
 	go func() { p.finishChan <- finishChan{nil, Finish{}} }()
 }
 
+// instance returns the state of the given sequence number, creating it if
+// needed.
+func (p *Protocol) instance(seq int) *instance {
+	inst, ok := p.instances[seq]
+	if !ok {
+		inst = &instance{
+			seq:          seq,
+			state:        statePrePrepare,
+			prepares:     make(map[int]string),
+			commits:      make(map[int]string),
+			prepareAuths: make(map[int][]byte),
+			commitSigs:   make(map[int][]byte),
+		}
+		p.instances[seq] = inst
+	}
+	return inst
+}
+
+// pending returns true if we know of an instance that is not committed yet.
+func (p *Protocol) pending() bool {
+	for seq, inst := range p.instances {
+		if seq > p.lastCommitted && inst.state != stateFinished {
+			return true
+		}
+	}
+	return false
+}
+
+// primaryIndex returns the index of the primary for the given view.
+func (p *Protocol) primaryIndex(view int) int {
+	return view % len(p.nodeList)
+}
+
+// primary returns the TreeNode of the primary for the given view.
+func (p *Protocol) primary(view int) *onet.TreeNode {
+	return p.nodeList[p.primaryIndex(view)]
+}
+
+// nodeIndex returns the index of the TreeNode in our list or notFound.
+func (p *Protocol) nodeIndex(tn *onet.TreeNode) int {
+	if tn == nil {
+		return notFound
+	}
+	for i, n := range p.nodeList {
+		if n.ID.Equal(tn.ID) {
+			return i
+		}
+	}
+	return notFound
+}
+
 // sendCb should contain the real sendTo call and the msg to broadcast
 // example for sendCb:
 // func(tn *onet.TreeNode) { p.SendTo(tn, &registerdMsg )}
 func (p *Protocol) broadcast(sendCb func(*onet.TreeNode)) {
+	if p.recovering {
+		// we already sent everything before the restart
+		return
+	}
 	for i, tn := range p.nodeList {
 		if i == p.index {
 			continue
@@ -278,6 +937,45 @@ func (p *Protocol) broadcast(sendCb func(*onet.TreeNode)) {
 	}
 }
 
+// headerHash returns the hash of the block, or "" for a null request.
+func headerHash(block *blockchain.TrBlock) string {
+	if block == nil {
+		return ""
+	}
+	return block.HeaderHash
+}
+
+// count returns how many senders sent the given hash.
+func count(msgs map[int]string, hash string) int {
+	n := 0
+	for _, h := range msgs {
+		if h == hash {
+			n++
+		}
+	}
+	return n
+}
+
+// senders returns the indices of the senders of the given hash.
+func senders(msgs map[int]string, hash string) []int {
+	var list []int
+	for i, h := range msgs {
+		if h == hash {
+			list = append(list, i)
+		}
+	}
+	return list
+}
+
+// indices32 converts the indices for the network.
+func indices32(indices []int) []int32 {
+	list := make([]int32, len(indices))
+	for i, idx := range indices {
+		list[i] = int32(idx)
+	}
+	return list
+}
+
 // verifyBlock is a simulation of a real block verification algorithm
 // FIXME merge with Nicolas' code (public method in byzcoin)
 func verifyBlock(block *blockchain.TrBlock, lastBlock, lastKeyBlock string) bool {
@@ -285,8 +983,9 @@ func verifyBlock(block *blockchain.TrBlock, lastBlock, lastKeyBlock string) bool
 	//block of 500kB.
 	//To simulate the verification cost of bigger blocks we multiply 174ms
 	//times the size/500*1024
-	b, _ := json.Marshal(block)
-	s := len(b)
+	var size byteCounter
+	json.NewEncoder(&size).Encode(block)
+	s := int(size)
 	var n time.Duration
 	n = time.Duration(s / (500 * 1024))
 	time.Sleep(150 * time.Millisecond * n) //verification of 174ms per 500KB simulated
@@ -297,3 +996,12 @@ func verifyBlock(block *blockchain.TrBlock, lastBlock, lastKeyBlock string) bool
 
 	return verified
 }
+
+// byteCounter is a writer that only counts the bytes written to it, to
+// measure the size of an encoding without keeping it.
+type byteCounter int
+
+func (c *byteCounter) Write(b []byte) (int, error) {
+	*c += byteCounter(len(b))
+	return len(b), nil
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"github.com/dedis/paper_17_sosp_omniledger/suites"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/crypto.v0/sign"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+// testBlock returns a block holding a transaction of its own.
+func testBlock(i int) *blockchain.TrBlock {
+	txs := []blkparser.Tx{{Hash: fmt.Sprintf("%064x", i+1)}}
+	trlist := blockchain.NewTransactionList(txs, len(txs))
+	return blockchain.NewTrBlock(trlist, blockchain.NewHeader(trlist, "", ""))
+}
+
+// testReplicas returns the protocols of all the nodes of a tree of n nodes,
+// without starting them.
+func testReplicas(t *testing.T, local *onet.LocalTest, n int) []*Protocol {
+	_, roster, tree := local.GenTree(n, true)
+	var replicas []*Protocol
+	for _, tn := range tree.List() {
+		local.Overlays[tn.ServerIdentity.ID].RegisterRoster(roster)
+		local.Overlays[tn.ServerIdentity.ID].RegisterTree(tree)
+		tni, err := local.NewTreeNodeInstance(tn, "ByzCoinPBFT")
+		require.Nil(t, err)
+		p, err := NewProtocol(tni)
+		require.Nil(t, err)
+		replicas = append(replicas, p)
+	}
+	return replicas
+}
+
+// testRun creates the protocol on a tree of n nodes and proposes rounds
+// blocks, each once the previous one is committed. It returns the root and
+// the notifications of the commits of the blocks, whose certificates it
+// checks. setup is called on the root before the first block, if not nil.
+func testRun(t *testing.T, local *onet.LocalTest, n, rounds int,
+	setup func(p *Protocol)) (*Protocol, []*Committed) {
+	_, _, tree := local.GenTree(n, true)
+	pi, err := local.CreateProtocol("ByzCoinPBFT", tree)
+	require.Nil(t, err)
+	p := pi.(*Protocol)
+	commits := make(chan *Committed, 100)
+	p.onCommitCB = func(c *Committed) {
+		assert.Nil(t, VerifyCommitCertificate(p.Suite(), tree.Roster, &c.Certificate))
+		commits <- c
+	}
+	if setup != nil {
+		setup(p)
+	}
+	require.Nil(t, p.Start())
+	var committed []*Committed
+	for r := 0; r < rounds; r++ {
+		block := testBlock(r)
+		p.Propose(block)
+		for len(committed) == r {
+			select {
+			case c := <-commits:
+				// the notifications of a view change come again
+				if c.HeaderHash == block.HeaderHash {
+					committed = append(committed, c)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("block", r, "not committed")
+			}
+		}
+	}
+	return p, committed
+}
+
+// testStop stops the replicas of testRun and gives them the time to get the
+// message before the servers are closed.
+func testStop(p *Protocol) {
+	p.Stop()
+	time.Sleep(200 * time.Millisecond)
+}
+
+// checkLog waits for the root to commit the rounds blocks of testRun and
+// checks its log.
+func checkLog(t *testing.T, p *Protocol, rounds int) {
+	var proposed []string
+	for r := 0; r < rounds; r++ {
+		proposed = append(proposed, testBlock(r).HeaderHash)
+	}
+	for i := 0; len(p.CommittedBlocks()) < rounds && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Nil(t, verifyLog(p.CommittedBlocks(), proposed, windowSize == 1, 0))
+}
+
+func TestConsecutiveInstances(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	p, committed := testRun(t, local, 7, 5, nil)
+	defer testStop(p)
+	for i, c := range committed {
+		assert.Equal(t, i+1, c.Seq)
+		assert.Equal(t, 0, c.View)
+	}
+	checkLog(t, p, 5)
+	assert.Equal(t, 5, p.lastCommitted)
+}
+
+func TestViewChange(t *testing.T) {
+	defer func(d time.Duration) { viewChangeTimeout = d }(viewChangeTimeout)
+	viewChangeTimeout = 500 * time.Millisecond
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	// the root is the primary of the view 0 and crashes while it
+	// pre-prepares the first block
+	p, committed := testRun(t, local, 7, 5, func(p *Protocol) { p.killPrimary = true })
+	defer testStop(p)
+	assert.True(t, p.crashed)
+	for _, c := range committed {
+		assert.True(t, c.View > 0, "block committed by the crashed primary")
+	}
+	for i := 1; i < len(committed); i++ {
+		assert.True(t, committed[i].Seq > committed[i-1].Seq)
+	}
+}
+
+func TestRotateLeader(t *testing.T) {
+	defer func() { rotateLeader = false }()
+	rotateLeader = true
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	p, committed := testRun(t, local, 7, 10, nil)
+	defer testStop(p)
+	// every block is committed by another primary than the one before
+	for i := 1; i < len(committed); i++ {
+		assert.True(t, committed[i].View > committed[i-1].View)
+		assert.True(t, committed[i].Seq > committed[i-1].Seq)
+	}
+	checkLog(t, p, 10)
+}
+
+func TestValidPreparedCert(t *testing.T) {
+	defer func() { authMode = authNone }()
+	for _, mode := range []string{authSchnorr, authMAC} {
+		authMode = mode
+		local := onet.NewLocalTest()
+		ps := testReplicas(t, local, 4)
+		block := testBlock(0)
+		hash := headerHash(block)
+		// the backups 1 and 2 prepared the block of the primary 0
+		cert := func() *PreparedCert {
+			return &PreparedCert{View: 0, Seq: 1, TrBlock: block,
+				Prepares: []int32{1, 2},
+				Auths: [][]byte{ps[1].authenticate(phasePrepare, 0, 1, hash),
+					ps[2].authenticate(phasePrepare, 0, 1, hash)}}
+		}
+		for _, p := range ps {
+			assert.True(t, p.validPreparedCert(cert()), "%s: replica %d", mode, p.index)
+		}
+		// prepares without authenticators
+		pc := cert()
+		pc.Auths = pc.Auths[:1]
+		assert.False(t, ps[3].validPreparedCert(pc), mode)
+		// a prepare of another block
+		pc = cert()
+		pc.Auths[1] = ps[2].authenticate(phasePrepare, 0, 1, "other")
+		assert.False(t, ps[3].validPreparedCert(pc), mode)
+		// a prepare authenticated by another replica than its sender
+		pc = cert()
+		pc.Auths[1] = ps[3].authenticate(phasePrepare, 0, 1, hash)
+		assert.False(t, ps[0].validPreparedCert(pc), mode)
+		// our own prepare is checked, too
+		pc = cert()
+		pc.Auths[0] = ps[3].authenticate(phasePrepare, 0, 1, hash)
+		assert.False(t, ps[1].validPreparedCert(pc), mode)
+
+		// the blocks not prepared need the authenticator of their primary
+		vc := &ViewChange{View: 1, Replica: 2, PrePrepared: []PrePrepare{{
+			TrBlock: block, View: 0, Seq: 1,
+			Auth: ps[0].authenticate(phasePrePrepare, 0, 1, hash)}}}
+		vc.Auth = ps[2].authenticate(phaseViewChange, 1, 0, viewChangeHash(vc))
+		assert.True(t, ps[3].validViewChange(vc), mode)
+		vc.PrePrepared[0].Auth = ps[2].authenticate(phasePrePrepare, 0, 1, hash)
+		assert.False(t, ps[3].validViewChange(vc), mode)
+		local.CloseAll()
+	}
+}
+
+func TestViewChangeSignature(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	ps := testReplicas(t, local, 4)
+	// the view-changes of the replicas 1 to 3 for the view 1
+	viewChanges := func() []ViewChange {
+		var vcs []ViewChange
+		for _, p := range ps[1:] {
+			vc := ViewChange{View: 1, Replica: p.index, LastCommitted: 2}
+			vc.Auth = p.authenticate(phaseViewChange, 1, 2, viewChangeHash(&vc))
+			vcs = append(vcs, vc)
+		}
+		return vcs
+	}
+	for _, vc := range viewChanges() {
+		assert.True(t, ps[0].validViewChange(&vc))
+		assert.True(t, ps[vc.Replica].validViewChange(&vc))
+	}
+	vc := viewChanges()[0]
+	vc.LastCommitted = 5
+	assert.False(t, ps[0].validViewChange(&vc), "another last committed block")
+	vc = viewChanges()[0]
+	vc.Replica = 3
+	assert.False(t, ps[0].validViewChange(&vc), "signed by another replica")
+	vc = viewChanges()[0]
+	vc.PrePrepared = []PrePrepare{{TrBlock: testBlock(3), View: 0, Seq: 3,
+		Auth: ps[0].authenticate(phasePrePrepare, 0, 3, headerHash(testBlock(3)))}}
+	assert.False(t, ps[0].validViewChange(&vc), "with another block")
+	vc = viewChanges()[0]
+	vc.Auth = []byte{}
+	assert.False(t, ps[0].validViewChange(&vc), "unsigned")
+
+	// the primary of the view 1 can't make up the view-changes of its
+	// new-view message
+	backup := ps[2]
+	backup.recovering = true
+	defer backup.stopTimer()
+	backup.startViewChange(1)
+	forged := viewChanges()
+	for i := range forged {
+		forged[i].LastCommitted = 4
+	}
+	backup.handleNewView(ps[1].TreeNode(), testNewView(ps[1], forged))
+	assert.True(t, backup.viewChanging)
+	assert.Equal(t, 0, backup.lastCommitted)
+	backup.handleNewView(ps[1].TreeNode(), testNewView(ps[1], viewChanges()))
+	assert.False(t, backup.viewChanging)
+	assert.Equal(t, 2, backup.lastCommitted)
+}
+
+// testNewView returns the new-view of the primary p with the view-changes,
+// re-proposing no block.
+func testNewView(p *Protocol, vcs []ViewChange) *NewView {
+	nv := &NewView{View: vcs[0].View, ViewChanges: vcs, PrePrepares: []PrePrepare{}}
+	nv.Auth = p.authenticate(phaseNewView, nv.View, vcs[0].LastCommitted, newViewHash(nv))
+	return nv
+}
+
+func TestNewViewAuth(t *testing.T) {
+	defer func() { authMode = authNone }()
+	for _, mode := range []string{authSchnorr, authMAC} {
+		authMode = mode
+		local := onet.NewLocalTest()
+		ps := testReplicas(t, local, 4)
+		var vcs []ViewChange
+		for _, p := range ps[1:] {
+			vc := ViewChange{View: 1, Replica: p.index}
+			vc.Auth = p.authenticate(phaseViewChange, 1, 0, viewChangeHash(&vc))
+			vcs = append(vcs, vc)
+		}
+		backup := ps[2]
+		backup.recovering = true
+		backup.startViewChange(1)
+		// made up by another replica than the primary
+		backup.handleNewView(ps[1].TreeNode(), testNewView(ps[3], vcs))
+		assert.True(t, backup.viewChanging, mode)
+		backup.handleNewView(ps[1].TreeNode(), testNewView(ps[1], vcs))
+		assert.False(t, backup.viewChanging, mode)
+		backup.stopTimer()
+		local.CloseAll()
+	}
+}
+
+func TestVerifyCommitCertificate(t *testing.T) {
+	// the keys of the roster are of another suite than the one onet uses
+	// while checking the certificate
+	defer suites.Use("")
+	require.Nil(t, suites.Use("p256"))
+	suite := network.Suite
+	var keys []*config.KeyPair
+	var ids []*network.ServerIdentity
+	for i := 0; i < 4; i++ {
+		kp := config.NewKeyPair(suite)
+		keys = append(keys, kp)
+		ids = append(ids, network.NewServerIdentity(kp.Public,
+			network.NewLocalAddress(fmt.Sprintf("127.0.0.1:%d", 2000+i))))
+	}
+	roster := onet.NewRoster(ids)
+	require.Nil(t, suites.Use(""))
+	cert := &CommitCertificate{HeaderHash: headerHash(testBlock(0)), View: 1, Seq: 2}
+	msg := digest(phaseCommit, cert.View, cert.Seq, cert.HeaderHash)
+	for _, i := range []int{0, 2, 3} {
+		sig, err := sign.Schnorr(suite, keys[i].Secret, msg)
+		require.Nil(t, err)
+		cert.Signatures = append(cert.Signatures, CommitSignature{Index: i, Sig: sig})
+	}
+	require.Nil(t, VerifyCommitCertificate(suite, roster, cert))
+
+	sigs := cert.Signatures
+	cert.Signatures = sigs[:2]
+	assert.NotNil(t, VerifyCommitCertificate(suite, roster, cert), "not enough signatures")
+	cert.Signatures = append([]CommitSignature{}, sigs...)
+	cert.Signatures[1].Index = 1
+	assert.NotNil(t, VerifyCommitCertificate(suite, roster, cert), "signature of another replica")
+	cert.Signatures = append(sigs, sigs[0])
+	assert.NotNil(t, VerifyCommitCertificate(suite, roster, cert), "replica signing twice")
+	cert.Signatures = sigs
+	cert.Seq++
+	assert.NotNil(t, VerifyCommitCertificate(suite, roster, cert), "signatures of another commit")
+}
+
+func TestPrePrepareWatermarks(t *testing.T) {
+	defer func(w int) { windowSize = w }(windowSize)
+	windowSize = 2
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	ps := testReplicas(t, local, 4)
+	backup := ps[3]
+	// the other replicas don't run, so the backup doesn't send its
+	// prepares, as while it replays its log
+	backup.recovering = true
+	defer backup.stopTimer()
+	prePrepare := func(seq int) {
+		backup.handlePrePrepare(ps[0].TreeNode(), &PrePrepare{
+			TrBlock: testBlock(seq), View: 0, Seq: seq, Auth: []byte{}})
+	}
+	prepared := func(seq int) bool {
+		inst, ok := backup.instances[seq]
+		return ok && inst.state == statePrepare
+	}
+
+	// far above the high watermark, the sequence number is dropped
+	prePrepare(5)
+	assert.False(t, prepared(5))
+	assert.Empty(t, backup.futurePrePrepares)
+	// a window above, it waits for the blocks before to be committed
+	prePrepare(3)
+	assert.False(t, prepared(3))
+	assert.Len(t, backup.futurePrePrepares, 1)
+	prePrepare(1)
+	assert.True(t, prepared(1))
+	backup.lastCommitted = 1
+	backup.replayFuturePrePrepares()
+	assert.True(t, prepared(3))
+	assert.Empty(t, backup.futurePrePrepares)
+	// below the low watermark, too
+	prePrepare(1)
+	delete(backup.instances, 1)
+	prePrepare(1)
+	assert.False(t, prepared(1))
+}
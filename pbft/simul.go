@@ -1,9 +1,13 @@
 package main
 
 import (
+	"github.com/dedis/paper_17_sosp_omniledger/suites"
+	"gopkg.in/dedis/onet.v1/log"
 	"gopkg.in/dedis/onet.v1/simul"
 )
 
 func main() {
+	// the suite of the keys of the simulation file, see package suites
+	log.ErrFatal(suites.Load())
 	simul.Start()
 }
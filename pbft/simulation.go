@@ -1,8 +1,21 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
 	"github.com/BurntSushi/toml"
 	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain/blkparser"
+	"github.com/dedis/paper_17_sosp_omniledger/faults"
+	"github.com/dedis/paper_17_sosp_omniledger/metrics"
+	"github.com/dedis/paper_17_sosp_omniledger/netem"
+	"github.com/dedis/paper_17_sosp_omniledger/profile"
+	"github.com/dedis/paper_17_sosp_omniledger/seed"
+	"github.com/dedis/paper_17_sosp_omniledger/soak"
+	"github.com/dedis/paper_17_sosp_omniledger/suites"
 	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/log"
 	"gopkg.in/dedis/onet.v1/simul/monitor"
@@ -22,6 +35,87 @@ type Simulation struct {
 	// pbft simulation specific fields:
 	// Blocksize is the number of transactions in one block:
 	Blocksize int
+	// MaxBlockBytes is the most bytes of transactions in one block, on top
+	// of Blocksize if it isn't 0.
+	MaxBlockBytes int
+	// BatchTimeout is the time in milliseconds the primary waits for a
+	// block to be full before proposing it anyway. 0 waits for full blocks.
+	BatchTimeout int
+	// ClientRate is the number of transactions per second the client sends
+	// to the primary. 0 sends them as fast as possible.
+	ClientRate int
+	// ViewChangeTimeout is the time in milliseconds a replica waits for a
+	// block to be committed before asking for a view change.
+	ViewChangeTimeout int
+	// KillPrimary makes the primary crash in the middle of every round,
+	// so that the replicas have to change the view to commit the block.
+	KillPrimary bool
+	// FaultyHosts is the number of byzantine replicas. The root is never
+	// faulty.
+	FaultyHosts int
+	// FaultType is the behaviour of the faulty replicas: "equivocate"
+	// sends conflicting prepares and commits, "withhold" doesn't vote,
+	// "silent" doesn't send anything and "delay" holds back every message
+	// for FaultDelay.
+	FaultType string
+	// FaultDelay is the delay in milliseconds of the "delay" fault.
+	FaultDelay int
+	// WALDir is the directory of the write-ahead logs of the replicas. If
+	// it is empty, the replicas don't keep a log.
+	WALDir string
+	// TreeDissemination sends the prepares and commits over the tree,
+	// aggregating them on the way, instead of to all replicas.
+	TreeDissemination bool
+	// Authentication is how the replicas authenticate their messages:
+	// "none", "schnorr" signatures or "mac" vectors of HMACs.
+	Authentication string
+	// RotateLeader changes the primary after every block, even if it
+	// didn't fail.
+	RotateLeader bool
+	// Window is the number of blocks the primary may have in flight. The
+	// client proposes a new block as soon as there is room in the window.
+	// Defaults to 1, which runs one block after the other.
+	Window int
+	// RestartHosts is the number of replicas restarted in the middle of
+	// the simulation. They need WALDir to recover their state.
+	RestartHosts int
+	// Compression is the codec the primary pre-prepares the blocks with,
+	// "snappy" or "flate" at CompressionLevel, uncompressed if empty.
+	Compression      string
+	CompressionLevel int
+	// Topology is the TOML file of the links the messages of the replicas
+	// are held back as on, see netem.Topology, none if empty.
+	Topology string
+	// MetricsPort serves the metrics of every replica on its /metrics
+	// endpoint for Prometheus, at MetricsPort plus the index of the replica
+	// in the roster, none if 0, see metrics.ServeHost.
+	MetricsPort int
+	// PhaseBandwidth records the bytes of the announce, prepare and commit
+	// phases of every round in the monitor, see metrics.EndRound.
+	PhaseBandwidth bool
+	// GlobalSeed seeds the random streams of the simulation, so that a run
+	// can be reproduced, see package seed. The streams are seeded from the
+	// clock if it is 0.
+	GlobalSeed int64
+	// Suite is the cipher suite of the keys and the signatures, "ed25519"
+	// if empty, see package suites.
+	Suite string
+	// Profile writes a CPU profile of every round and a heap profile after
+	// it to the profiles directory of the simulation, see package profile.
+	Profile bool
+	// Soak samples the memory and the goroutines every soak.Every rounds
+	// and fails the run if they grow without bound, see package soak. Rounds
+	// should then be in the thousands.
+	Soak bool
+	// Config are the crashes, lost and delayed messages injected in the
+	// protocol on top of the byzantine faults, see faults.Config.
+	faults.Config
+}
+
+// pipelined is a block proposed by the simulation and not yet committed.
+type pipelined struct {
+	batch    *batch
+	proposed time.Time
 }
 
 // NewSimulation returns a pbft simulation
@@ -40,9 +134,19 @@ func (e *Simulation) Setup(dir string, hosts []string) (*onet.SimulationConfig,
 	if err != nil {
 		log.Fatal("Couldn't get block:", err)
 	}
+	if e.Topology != "" {
+		if err := netem.Deploy(dir, e.Topology); err != nil {
+			return nil, err
+		}
+	}
 
+	if err := suites.Setup(dir, e.Suite); err != nil {
+		return nil, err
+	}
 	sc := &onet.SimulationConfig{}
 	e.CreateRoster(sc, hosts, 2000)
+	seed.Use(e.GlobalSeed)
+	seed.Keys(sc)
 	err = e.CreateTree(sc)
 	if err != nil {
 		return nil, err
@@ -50,12 +154,56 @@ func (e *Simulation) Setup(dir string, hosts []string) (*onet.SimulationConfig,
 	return sc, nil
 }
 
+// Node seeds the random streams and sets the view change timeout, the leader
+// rotation, the window, the faults, the directory of the write-ahead log, the
+// dissemination mode, the authentication, the compression, the emulated
+// links, the injected faults, the metrics endpoint and the accounting of the
+// phases on every node before calling the 'Node'-method of the
+// SimulationBFTree.
+func (e *Simulation) Node(sc *onet.SimulationConfig) error {
+	seed.Use(e.GlobalSeed)
+	if e.ViewChangeTimeout > 0 {
+		viewChangeTimeout = time.Millisecond * time.Duration(e.ViewChangeTimeout)
+	}
+	faultyHosts = e.FaultyHosts
+	if e.FaultType != "" {
+		faultType = e.FaultType
+	}
+	if e.FaultDelay > 0 {
+		faultDelayTime = time.Millisecond * time.Duration(e.FaultDelay)
+	}
+	walDir = e.WALDir
+	treeDissemination = e.TreeDissemination
+	if e.Window > 0 {
+		windowSize = e.Window
+	}
+	rotateLeader = e.RotateLeader
+	if e.Authentication != "" {
+		authMode = e.Authentication
+	}
+	compression = blockchain.Compression{
+		Codec: e.Compression,
+		Level: e.CompressionLevel,
+	}
+	if e.Topology != "" {
+		if err := netem.UseTopology(filepath.Base(e.Topology)); err != nil {
+			return err
+		}
+	}
+	if err := faults.Use(e.Config); err != nil {
+		return err
+	}
+	metrics.AccountPhases(e.PhaseBandwidth)
+	if e.MetricsPort > 0 {
+		if err := metrics.ServeHost(sc, e.MetricsPort); err != nil {
+			return err
+		}
+	}
+	return e.SimulationBFTree.Node(sc)
+}
+
 // Run runs the simulation
 func (e *Simulation) Run(sdaConf *onet.SimulationConfig) error {
-	doneChan := make(chan bool)
-	doneCB := func() {
-		doneChan <- true
-	}
 	// FIXME use client instead
 	dir := blockchain.GetBlockDir()
 	parser, err := blockchain.NewParser(dir, magicNum)
@@ -69,10 +217,24 @@ func (e *Simulation) Run(sdaConf *onet.SimulationConfig) error {
 		return err
 	}
 
-	// FIXME c&p from byzcoin.go
-	trlist := blockchain.NewTransactionList(transactions, len(transactions))
-	header := blockchain.NewHeader(trlist, "", "")
-	trblock := blockchain.NewTrBlock(trlist, header)
+	if len(transactions) == 0 {
+		return errors.New("couldn't read any transactions")
+	}
+	if e.RestartHosts > 0 && e.WALDir == "" {
+		return errors.New("restarting hosts needs a WALDir")
+	}
+	if e.KillPrimary && e.TreeDissemination {
+		// the root relays the votes over the tree
+		return errors.New("can't kill the primary with TreeDissemination")
+	}
+	bwName := "bw_broadcast"
+	if e.TreeDissemination {
+		bwName = "bw_tree"
+	}
+	batcher := newBatcher(e.Blocksize, e.MaxBlockBytes,
+		time.Millisecond*time.Duration(e.BatchTimeout))
+	defer batcher.stop()
+	go e.runClient(batcher, transactions)
 
 	// Here we first setup the N^2 connections with a broadcast protocol
 	//pi, err := sdaConf.Overlay.CreateProtocol("Broadcast", sdaConf.Tree)
@@ -92,29 +254,205 @@ func (e *Simulation) Run(sdaConf *onet.SimulationConfig) error {
 	//// wait
 	//<-broadDone
 	log.Lvl3("Simulation can start!")
-	for round := 0; round < e.Rounds; round++ {
-		log.Lvl1("Starting round", round)
-		p, err := sdaConf.Overlay.CreateProtocol("ByzCoinPBFT", sdaConf.Tree, onet.NilServiceID)
-		if err != nil {
-			return err
-		}
-		proto := p.(*Protocol)
-
-		proto.trBlock = trblock
-		proto.onDoneCB = doneCB
+	p, err := sdaConf.Overlay.CreateProtocol("ByzCoinPBFT", sdaConf.Tree, onet.NilServiceID)
+	if err != nil {
+		return err
+	}
+	proto := p.(*Protocol)
+	// the primary notifies us for every committed block, including the
+	// blocks committed again after a view change
+	committedChan := make(chan *Committed, e.Window+16)
+	proto.onCommitCB = func(c *Committed) {
+		committedChan <- c
+	}
+	recoveredChan := make(chan *Recovered, e.RestartHosts)
+	proto.onRecoveredCB = func(r *Recovered) {
+		recoveredChan <- r
+	}
+	proto.killPrimary = e.KillPrimary
+	if err := proto.Start(); err != nil {
+		return err
+	}
 
-		r := monitor.NewTimeMeasure("round_pbft")
-		err = proto.Start()
-		if err != nil {
-			log.Error("Couldn't start PrePrepare")
-			return err
+	var proposed []string
+	// the proposed blocks that are not committed yet
+	inFlight := make(map[string]*pipelined)
+	window := e.Window
+	if window < 1 {
+		window = 1
+	}
+	view := 0
+	restarted := false
+	bw := monitor.NewCounterIOMeasure(bwName, sdaConf.Server)
+	host := metrics.Host(sdaConf.Server.ServerIdentity)
+	// the latencies from the proposal of a block to its commit, and from
+	// the arrival of a transaction at the primary to the commit of its
+	// block, including the time it waited for the block to be cut
+	rounds, txs := &metrics.Latencies{}, &metrics.Latencies{}
+	profile.Use(e.Profile)
+	defer profile.EndRound()
+	soak.Use(e.Soak)
+	authTime := proto.AuthTime()
+	for round := 0; round < e.Rounds; {
+		faults.StartRound(round)
+		metrics.StartRound(round)
+		profile.StartRound(sdaConf.Server.ServerIdentity, round)
+		// fill the window
+		for len(proposed) < e.Rounds && len(inFlight) < window {
+			if e.RestartHosts > 0 && !restarted && len(proposed) == e.Rounds/2 {
+				restarted = true
+				if err := e.restartHosts(proto, recoveredChan); err != nil {
+					return err
+				}
+			}
+			b := batcher.nextBatch()
+			log.Lvl1("Proposing block", len(proposed), "with",
+				len(b.trBlock.Txs), "transactions")
+			monitor.RecordSingleMeasure("block_size", float64(b.trBlock.BlockSize))
+			inFlight[b.trBlock.HeaderHash] = &pipelined{
+				batch:    b,
+				proposed: time.Now(),
+			}
+			proto.Propose(b.trBlock)
+			proposed = append(proposed, b.trBlock.HeaderHash)
 		}
 
 		// wait for finishing pbft:
-		<-doneChan
-		r.Record()
+		c := <-committedChan
+		pl, ok := inFlight[c.HeaderHash]
+		if !ok {
+			continue
+		}
+		delete(inFlight, c.HeaderHash)
+		b := pl.batch
+		committed := time.Now()
+		rounds.Add(committed.Sub(pl.proposed))
+		for _, arrival := range b.arrivals {
+			txs.Add(committed.Sub(arrival))
+		}
+		bw.Record()
+		host.Observe("block_commit_seconds",
+			"Time from the proposal of a block to its commit.",
+			committed.Sub(pl.proposed).Seconds())
+		if err := VerifyCommitCertificate(proto.Suite(), proto.Roster(), &c.Certificate); err != nil {
+			return fmt.Errorf("round %d: invalid certificate: %v", round, err)
+		}
+		// CPU time the root spent on the authenticators since the last
+		// block
+		now := proto.AuthTime()
+		monitor.RecordSingleMeasure("auth_"+authMode, (now - authTime).Seconds())
+		authTime = now
+		// number of view changes this round needed to commit the block
+		viewChanges := 0
+		if c.View > view {
+			viewChanges = c.View - view
+			log.Lvl1("Round", round, "needed", viewChanges, "view changes")
+			view = c.View
+		}
+		monitor.RecordSingleMeasure("round_viewchanges", float64(viewChanges))
+		monitor.RecordSingleMeasure("block_txs", float64(len(b.trBlock.Txs)))
 
 		log.Lvl2("Finished round", round)
+		faults.EndRound(len(sdaConf.Roster.List))
+		metrics.EndRound(sdaConf.Server.ServerIdentity)
+		profile.EndRound()
+		soak.EndRound(round)
+		round++
+	}
+	rounds.Record("round_pbft")
+	txs.Record("tx_latency")
+	proto.Stop()
+	if err := soak.Check(); err != nil {
+		return err
+	}
+	if !proto.crashed {
+		// with more than one block in flight, a view change can re-order
+		// the blocks
+		return verifyLog(proto.CommittedBlocks(), proposed, window == 1,
+			proto.missed)
+	}
+	return nil
+}
+
+// restartHosts restarts RestartHosts replicas and waits for them to recover
+// from their write-ahead logs. It checks that every recovered log is
+// consistent with the log of the root.
+func (e *Simulation) restartHosts(proto *Protocol, recoveredChan chan *Recovered) error {
+	r := monitor.NewTimeMeasure("recovery")
+	if err := proto.RestartReplicas(e.RestartHosts); err != nil {
+		return err
+	}
+	for i := 0; i < e.RestartHosts; i++ {
+		rec := <-recoveredChan
+		log.Lvl1("Replica", rec.Replica, "recovered", len(rec.Log),
+			"blocks in view", rec.View)
+		if err := verifyRecovered(proto.CommittedBlocks(), rec.Log); err != nil {
+			return fmt.Errorf("replica %d: %v", rec.Replica, err)
+		}
 	}
+	r.Record()
 	return nil
 }
+
+// verifyRecovered checks that the recovered log and the committed blocks
+// agree on all the blocks they both have.
+func verifyRecovered(committed []*blockchain.TrBlock, recovered []string) error {
+	for i := 0; i < len(committed) && i < len(recovered); i++ {
+		if committed[i].HeaderHash != recovered[i] {
+			return fmt.Errorf("recovered block %d differs", i)
+		}
+	}
+	return nil
+}
+
+// verifyLog checks that the committed blocks are the proposed ones, each
+// committed once. If ordered is true, they must be in the same order. As
+// there is no state transfer, up to missed blocks may be missing.
+func verifyLog(committed []*blockchain.TrBlock, proposed []string, ordered bool,
+	missed int) error {
+	if len(committed) > len(proposed) || len(committed)+missed < len(proposed) {
+		return fmt.Errorf("committed %d blocks instead of %d",
+			len(committed), len(proposed))
+	}
+	position := make(map[string]int)
+	for i, hash := range proposed {
+		position[hash] = i
+	}
+	last := -1
+	for i, block := range committed {
+		pos, ok := position[block.HeaderHash]
+		if !ok {
+			return fmt.Errorf("block %d is not proposed or committed twice", i)
+		}
+		if ordered && pos < last {
+			return fmt.Errorf("block %d is not in the proposed order", i)
+		}
+		last = pos
+		delete(position, block.HeaderHash)
+	}
+	return nil
+}
+
+// runClient sends the transactions to the primary at ClientRate transactions
+// per second, starting over once all transactions have been sent.
+func (e *Simulation) runClient(b *batcher, transactions []blkparser.Tx) {
+	var tick <-chan time.Time
+	if e.ClientRate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(e.ClientRate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		for _, tr := range transactions {
+			if tick != nil {
+				<-tick
+			}
+			select {
+			case <-b.stopChan:
+				return
+			default:
+			}
+			b.AddTransaction(tr)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/simul/monitor"
+)
+
+// startTimer starts the view change timer for the given view. If it fires
+// before the block is committed, the replica asks for a view change.
+func (p *Protocol) startTimer(view int, timeout time.Duration) {
+	p.stopTimer()
+	p.timer = time.AfterFunc(timeout, func() {
+		select {
+		case p.timeoutChan <- view:
+		default:
+		}
+	})
+}
+
+// stopTimer stops the view change timer, if any.
+func (p *Protocol) stopTimer() {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+}
+
+// updateTimer makes sure the view change timer runs as long as we know of a
+// block that is not committed yet.
+func (p *Protocol) updateTimer() {
+	if p.viewChanging || p.crashed {
+		return
+	}
+	if !p.pending() {
+		p.stopTimer()
+		return
+	}
+	if p.timer == nil {
+		p.startTimer(p.view, viewChangeTimeout)
+	}
+}
+
+// handleTimeout is called when the timer of a view fired. If we are still in
+// this view and waiting for blocks, we move to the next view.
+func (p *Protocol) handleTimeout(view int) {
+	if view != p.view || (!p.viewChanging && !p.pending()) {
+		return
+	}
+	p.timer = nil
+	log.Lvl2(p.Name(), "Timeout in view", view, ": suspecting primary",
+		p.primary(view).Name())
+	p.startViewChange(view + 1)
+}
+
+// startViewChange moves this replica to the given view and broadcasts its
+// view-change message. It stops accepting pre-prepare, prepare and commit
+// messages until the new-view message arrives.
+func (p *Protocol) startViewChange(view int) {
+	if view <= p.view && p.viewChanging {
+		return
+	}
+	log.Lvl2(p.Name(), "Starting view change to view", view)
+	p.view = view
+	p.viewChanging = true
+	vc := &ViewChange{
+		View:          view,
+		Replica:       p.index,
+		LastCommitted: p.lastCommitted,
+	}
+	for _, seq := range p.sortedSeqs() {
+		inst := p.instances[seq]
+		if inst.prepared != nil {
+			vc.Prepared = append(vc.Prepared, *inst.prepared)
+		} else if inst.state > statePrePrepare {
+			vc.PrePrepared = append(vc.PrePrepared, PrePrepare{
+				TrBlock: inst.trBlock,
+				View:    inst.view,
+				Seq:     seq,
+				Auth:    inst.auth,
+			})
+		}
+	}
+	vc.Auth = p.authenticate(phaseViewChange, view, vc.LastCommitted, viewChangeHash(vc))
+	p.broadcast(func(tn *onet.TreeNode) {
+		if err := p.sendTo(tn, vc); err != nil {
+			log.Error(p.Name(), "Error while broadcasting ViewChange =>", err)
+		}
+	})
+	// if the new primary doesn't send the new-view in time, we go on to the
+	// next view, waiting twice as long.
+	p.startTimer(view, 2*viewChangeTimeout)
+	p.handleViewChange(p.TreeNode(), vc)
+}
+
+// handleViewChange stores the view-change messages. If f+1 replicas want to
+// go to a higher view, we join them. The primary of the new view sends the
+// new-view message once it has 2f+1 view-change messages.
+func (p *Protocol) handleViewChange(tn *onet.TreeNode, vc *ViewChange) {
+	sender := p.nodeIndex(tn)
+	if sender == notFound || sender != vc.Replica || vc.View < p.view {
+		return
+	}
+	if !p.validViewChange(vc) {
+		log.Lvl2(p.Name(), "Dropping invalid ViewChange")
+		return
+	}
+	if p.viewChanges[vc.View] == nil {
+		p.viewChanges[vc.View] = make(map[int]*ViewChange)
+	}
+	p.viewChanges[vc.View][sender] = vc
+	received := len(p.viewChanges[vc.View])
+	log.Lvl3(p.Name(), "Got", received, "view-changes for view", vc.View)
+
+	if vc.View > p.view && received > p.f {
+		p.startViewChange(vc.View)
+		return
+	}
+	if p.primaryIndex(vc.View) == p.index && received >= p.threshold &&
+		p.newViewSent < vc.View && p.viewChanging && vc.View == p.view {
+		p.sendNewView(vc.View)
+	}
+}
+
+// sendNewView is called by the primary of the new view. It re-proposes the
+// in-flight blocks and broadcasts the view-change messages as a proof.
+func (p *Protocol) sendNewView(view int) {
+	var vcs []ViewChange
+	for _, vc := range p.viewChanges[view] {
+		vcs = append(vcs, *vc)
+	}
+	minSeq, prePrepares := p.newViewPrePrepares(view, vcs)
+	for i := range prePrepares {
+		pp := &prePrepares[i]
+		pp.Auth = p.authenticate(phasePrePrepare, view, pp.Seq, headerHash(pp.TrBlock))
+	}
+	p.newViewSent = view
+	nv := &NewView{
+		View:        view,
+		ViewChanges: vcs,
+		PrePrepares: prePrepares,
+	}
+	nv.Auth = p.authenticate(phaseNewView, view, minSeq, newViewHash(nv))
+	log.Lvl2(p.Name(), "Sending NewView for view", view, "re-proposing",
+		len(prePrepares), "blocks")
+	monitor.RecordSingleMeasure("viewchange", float64(view))
+	p.broadcast(func(tn *onet.TreeNode) {
+		if err := p.sendTo(tn, nv); err != nil {
+			log.Error(p.Name(), "Error while broadcasting NewView =>", err)
+		}
+	})
+	p.enterView(view, minSeq, len(prePrepares))
+	for _, pp := range prePrepares {
+		p.logEntry(&walEntry{Type: walPrePrepare, View: view, Seq: pp.Seq,
+			TrBlock: pp.TrBlock, Auth: pp.Auth})
+		inst := p.instance(pp.Seq)
+		inst.trBlock = pp.TrBlock
+		inst.view, inst.auth = view, pp.Auth
+		inst.state = statePrepare
+	}
+	p.replayFuture()
+	if p.IsRoot() {
+		p.resubmit()
+	}
+	p.proposePending()
+	p.updateTimer()
+}
+
+// handleNewView checks the new-view message, with the signatures of the
+// view-changes it holds, and re-starts the protocol with the re-proposed
+// blocks.
+func (p *Protocol) handleNewView(tn *onet.TreeNode, nv *NewView) {
+	if nv.View < p.view || (nv.View == p.view && !p.viewChanging) {
+		return
+	}
+	if !tn.ID.Equal(p.primary(nv.View).ID) {
+		log.Lvl2(p.Name(), "Dropping NewView not sent by the primary of view", nv.View)
+		return
+	}
+	replicas := make(map[int]bool)
+	minSeq, prePrepares := p.newViewPrePrepares(nv.View, nv.ViewChanges)
+	if !p.verifyAuth(p.primaryIndex(nv.View), phaseNewView, nv.View, minSeq,
+		newViewHash(nv), nv.Auth) {
+		log.Lvl2(p.Name(), "Dropping NewView with invalid authenticator")
+		return
+	}
+	for i := range nv.ViewChanges {
+		vc := &nv.ViewChanges[i]
+		if vc.View != nv.View {
+			log.Lvl2(p.Name(), "Dropping NewView with view-change for wrong view")
+			return
+		}
+		if !p.validViewChange(vc) {
+			log.Lvl2(p.Name(), "Dropping NewView with invalid view-change")
+			return
+		}
+		replicas[vc.Replica] = true
+	}
+	if len(replicas) < p.threshold {
+		log.Lvl2(p.Name(), "Dropping NewView with only", len(replicas), "view-changes")
+		return
+	}
+	if len(prePrepares) != len(nv.PrePrepares) {
+		log.Lvl2(p.Name(), "Dropping NewView re-proposing the wrong blocks")
+		return
+	}
+	for i, pp := range prePrepares {
+		other := nv.PrePrepares[i]
+		if pp.Seq != other.Seq || pp.View != other.View ||
+			headerHash(pp.TrBlock) != headerHash(other.TrBlock) {
+			log.Lvl2(p.Name(), "Dropping NewView re-proposing the wrong blocks")
+			return
+		}
+	}
+	log.Lvl2(p.Name(), "Accepting NewView for view", nv.View)
+	p.enterView(nv.View, minSeq, len(prePrepares))
+	for i := range nv.PrePrepares {
+		p.handlePrePrepare(tn, &nv.PrePrepares[i])
+	}
+	p.replayFuture()
+	if p.IsRoot() {
+		p.resubmit()
+	} else {
+		p.forwardPending()
+	}
+	p.updateTimer()
+}
+
+// newViewPrePrepares returns the sequence number up to which the blocks are
+// considered stable, and the pre-prepares the new primary has to send for
+// all sequence numbers above. As there is no checkpointing, a sequence
+// number is stable once f+1 replicas committed it.
+func (p *Protocol) newViewPrePrepares(view int, vcs []ViewChange) (int, []PrePrepare) {
+	var lasts []int
+	maxSeq := 0
+	for _, vc := range vcs {
+		lasts = append(lasts, vc.LastCommitted)
+		for _, pc := range vc.Prepared {
+			if pc.Seq > maxSeq {
+				maxSeq = pc.Seq
+			}
+		}
+		for _, pp := range vc.PrePrepared {
+			if pp.Seq > maxSeq {
+				maxSeq = pp.Seq
+			}
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(lasts)))
+	minSeq := 0
+	if len(lasts) > p.f {
+		minSeq = lasts[p.f]
+	}
+	var prePrepares []PrePrepare
+	for seq := minSeq + 1; seq <= maxSeq; seq++ {
+		prePrepares = append(prePrepares, PrePrepare{
+			TrBlock: selectBlock(vcs, seq),
+			View:    view,
+			Seq:     seq,
+			Auth:    []byte{},
+		})
+	}
+	return minSeq, prePrepares
+}
+
+// enterView resets the state of the replica for a fresh run in the given
+// view. All sequence numbers above minSeq are run again with the blocks of
+// the new-view message.
+func (p *Protocol) enterView(view, minSeq, reproposed int) {
+	p.logEntry(&walEntry{Type: walNewView, View: view, Seq: minSeq,
+		Reproposed: reproposed})
+	p.view = view
+	p.viewChanging = false
+	if p.lastCommitted < minSeq {
+		// there is no state transfer, so we'll miss these blocks
+		log.Lvl2(p.Name(), "Missing blocks", p.lastCommitted+1, "to", minSeq)
+		p.missed += minSeq - p.lastCommitted
+		p.lastCommitted = minSeq
+	}
+	for seq, inst := range p.instances {
+		if seq <= minSeq || seq > minSeq+reproposed {
+			// the blocks above are not re-proposed, their sequence
+			// numbers will be given again
+			delete(p.instances, seq)
+			continue
+		}
+		inst.state = statePrePrepare
+		inst.prepares = make(map[int]string)
+		inst.commits = make(map[int]string)
+		inst.prepareAuths = make(map[int][]byte)
+		inst.commitSigs = make(map[int][]byte)
+	}
+	p.nextSeq = minSeq + reproposed + 1
+	p.futurePrePrepares = make(map[int]prePrepareChan)
+	p.dropAggregations(view)
+	if !p.rotationStart.IsZero() {
+		if p.IsRoot() {
+			monitor.RecordSingleMeasure("leader_handoff",
+				time.Since(p.rotationStart).Seconds())
+		}
+		p.rotationStart = time.Time{}
+	}
+	for v := range p.viewChanges {
+		if v <= view {
+			delete(p.viewChanges, v)
+		}
+	}
+	p.stopTimer()
+}
+
+// replayFuture handles the prepares and commits that were stored because
+// they arrived before we entered their view.
+func (p *Protocol) replayFuture() {
+	prepares := p.futurePrepares
+	commits := p.futureCommits
+	p.futurePrepares = nil
+	p.futureCommits = nil
+	for _, msg := range prepares {
+		p.handlePrepare(msg)
+	}
+	for _, msg := range commits {
+		p.handleCommit(msg)
+	}
+}
+
+// validViewChange checks the signature of the view-change by its replica,
+// its prepared certificates, and that the blocks it was still working on
+// were pre-prepared by the primaries of their views.
+func (p *Protocol) validViewChange(vc *ViewChange) bool {
+	if vc.Replica < 0 || vc.Replica >= len(p.nodeList) ||
+		!p.verifyCertAuth(vc.Replica, phaseViewChange, vc.View, vc.LastCommitted,
+			viewChangeHash(vc), vc.Auth) {
+		return false
+	}
+	for i := range vc.Prepared {
+		if !p.validPreparedCert(&vc.Prepared[i]) {
+			return false
+		}
+	}
+	for _, pp := range vc.PrePrepared {
+		if pp.Seq <= 0 || !p.verifyCertAuth(p.primaryIndex(pp.View), phasePrePrepare,
+			pp.View, pp.Seq, headerHash(pp.TrBlock), pp.Auth) {
+			return false
+		}
+	}
+	return true
+}
+
+// viewChangeHash returns what the replica signs of its view-change, next to
+// the view and the last committed sequence number: its index and the blocks
+// of its prepared certificates and pre-prepares.
+func viewChangeHash(vc *ViewChange) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d", vc.Replica)
+	for _, pc := range vc.Prepared {
+		fmt.Fprintf(h, "/prepared/%d/%d/%s", pc.View, pc.Seq, headerHash(pc.TrBlock))
+	}
+	for _, pp := range vc.PrePrepared {
+		fmt.Fprintf(h, "/pre-prepared/%d/%d/%s", pp.View, pp.Seq, headerHash(pp.TrBlock))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// newViewHash returns what the primary authenticates of its new-view, next
+// to the view and the stable sequence number: the view-changes it holds and
+// the blocks it re-proposes.
+func newViewHash(nv *NewView) string {
+	h := sha256.New()
+	for i := range nv.ViewChanges {
+		vc := &nv.ViewChanges[i]
+		fmt.Fprintf(h, "/view-change/%d/%s", vc.LastCommitted, viewChangeHash(vc))
+	}
+	for _, pp := range nv.PrePrepares {
+		fmt.Fprintf(h, "/pre-prepare/%d/%d/%s", pp.View, pp.Seq, headerHash(pp.TrBlock))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// validPreparedCert checks that the certificate holds enough distinct
+// prepares from backups of its view, each with the authenticator of its
+// sender.
+func (p *Protocol) validPreparedCert(pc *PreparedCert) bool {
+	if pc.Seq <= 0 || len(pc.Auths) != len(pc.Prepares) {
+		return false
+	}
+	hash := headerHash(pc.TrBlock)
+	seen := make(map[int]bool)
+	for k, i32 := range pc.Prepares {
+		i := int(i32)
+		if i < 0 || i >= len(p.nodeList) || i == p.primaryIndex(pc.View) {
+			return false
+		}
+		if !p.verifyCertAuth(i, phasePrepare, pc.View, pc.Seq, hash, pc.Auths[k]) {
+			return false
+		}
+		seen[i] = true
+	}
+	return len(seen) >= 2*p.f
+}
+
+// sortedSeqs returns the sequence numbers of all known instances in
+// increasing order.
+func (p *Protocol) sortedSeqs() []int {
+	var seqs []int
+	for seq := range p.instances {
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+	return seqs
+}
+
+// selectBlock returns the block to re-propose for seq in a new view: the
+// block with the prepared certificate of the highest view, or if nobody
+// prepared, the block one of the replicas was still working on. If there is
+// none, it returns nil, which is a null request.
+func selectBlock(vcs []ViewChange, seq int) *blockchain.TrBlock {
+	var best *PreparedCert
+	for i := range vcs {
+		for j := range vcs[i].Prepared {
+			pc := &vcs[i].Prepared[j]
+			if pc.Seq == seq && (best == nil || pc.View > best.View) {
+				best = pc
+			}
+		}
+	}
+	if best != nil {
+		return best.TrBlock
+	}
+	for i := range vcs {
+		for _, pp := range vcs[i].PrePrepared {
+			if pp.Seq == seq && pp.TrBlock != nil {
+				return pp.TrBlock
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dedis/paper_17_sosp_omniledger/byzcoin_lib/protocol/blockchain"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+// walDir is the directory where every replica keeps its write-ahead log. If
+// it is empty, no log is written and the replicas can't recover. It is set by
+// the simulation on every node.
+var walDir = ""
+
+// The types of the entries of the write-ahead log.
+const (
+	walPrePrepare = "preprepare"
+	walPrepare    = "prepare"
+	walCommit     = "commit"
+	walNewView    = "newview"
+)
+
+// walEntry is one line of the write-ahead log. Every message is written
+// before the replica changes its state because of it.
+type walEntry struct {
+	Type string
+	View int
+	Seq  int
+	// Sender is the index of the replica that sent the prepare or commit
+	Sender     int
+	HeaderHash string `json:",omitempty"`
+	// TrBlock is the block of a pre-prepare, nil for a null request
+	TrBlock *blockchain.TrBlock `json:",omitempty"`
+	// Reproposed is the number of blocks re-proposed by a new-view
+	Reproposed int `json:",omitempty"`
+	// Auth is the authenticator of a pre-prepare, prepare or commit, needed
+	// for the prepared and commit certificates
+	Auth []byte `json:",omitempty"`
+}
+
+// walFile returns the name of the write-ahead log of this replica.
+func (p *Protocol) walFile() string {
+	return filepath.Join(walDir, fmt.Sprintf("pbft_%x_%d.wal",
+		p.Roster().ID[:], p.index))
+}
+
+// openWAL opens the write-ahead log of this replica for appending.
+func (p *Protocol) openWAL() error {
+	if walDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(walDir, 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p.walFile(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	p.wal = f
+	return nil
+}
+
+// closeWAL closes the write-ahead log, if any.
+func (p *Protocol) closeWAL() {
+	if p.wal == nil {
+		return
+	}
+	if err := p.wal.Close(); err != nil {
+		log.Error(p.Name(), "couldn't close the log:", err)
+	}
+	p.wal = nil
+}
+
+// logEntry appends the entry to the write-ahead log. As we only simulate the
+// restart of the replica and not of the machine, the entry is not synced to
+// the disk.
+func (p *Protocol) logEntry(e *walEntry) {
+	if p.wal == nil || p.recovering {
+		return
+	}
+	buf, err := json.Marshal(e)
+	if err != nil {
+		log.Error(p.Name(), "couldn't encode log entry:", err)
+		return
+	}
+	if _, err := p.wal.Write(append(buf, '\n')); err != nil {
+		log.Error(p.Name(), "couldn't write log entry:", err)
+	}
+}
+
+// Recover rebuilds the state of the replica by replaying its write-ahead log.
+// While replaying, the replica doesn't send any message. It is called when
+// the protocol is created and when the replica is restarted.
+func (p *Protocol) Recover() error {
+	if walDir == "" {
+		return nil
+	}
+	f, err := os.Open(p.walFile())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	p.recovering = true
+	defer func() { p.recovering = false }()
+	entries := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<30)
+	for scanner.Scan() {
+		e := &walEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			// the last entry might have been written partially
+			log.Lvl2(p.Name(), "Stopping recovery at broken entry:", err)
+			break
+		}
+		p.replay(e)
+		entries++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	log.Lvl2(p.Name(), "Recovered", entries, "log entries: view", p.view,
+		"last committed", p.lastCommitted)
+	return nil
+}
+
+// replay applies one entry of the write-ahead log to the state.
+func (p *Protocol) replay(e *walEntry) {
+	if e.Auth == nil {
+		// empty authenticators are left out of the log, but the network
+		// can't encode nil slices
+		e.Auth = []byte{}
+	}
+	switch e.Type {
+	case walNewView:
+		p.enterView(e.View, e.Seq, e.Reproposed)
+	case walPrePrepare:
+		p.view = e.View
+		inst := p.instance(e.Seq)
+		inst.trBlock = e.TrBlock
+		inst.view, inst.auth = e.View, e.Auth
+		inst.state = statePrepare
+		if e.Seq >= p.nextSeq {
+			p.nextSeq = e.Seq + 1
+		}
+		if p.index != p.primaryIndex(e.View) {
+			hash := headerHash(e.TrBlock)
+			inst.prepares[p.index] = hash
+			inst.prepareAuths[p.index] = p.authenticate(phasePrepare, e.View, e.Seq, hash)
+		}
+		p.checkInstance(inst)
+	case walPrepare:
+		inst := p.instance(e.Seq)
+		inst.prepares[e.Sender] = e.HeaderHash
+		inst.prepareAuths[e.Sender] = e.Auth
+		p.checkInstance(inst)
+	case walCommit:
+		inst := p.instance(e.Seq)
+		inst.commits[e.Sender] = e.HeaderHash
+		inst.commitSigs[e.Sender] = e.Auth
+		p.checkInstance(inst)
+	default:
+		log.Error(p.Name(), "Unknown log entry", e.Type)
+	}
+}
+
+// restart simulates a crash of the replica: it forgets everything it has in
+// memory and recovers from its write-ahead log.
+func (p *Protocol) restart() {
+	log.Lvl1(p.Name(), "Restarting")
+	p.stopTimer()
+	p.reset()
+	if err := p.Recover(); err != nil {
+		log.Error(p.Name(), "couldn't recover:", err)
+	}
+	var hashes []string
+	for _, block := range p.CommittedBlocks() {
+		hashes = append(hashes, block.HeaderHash)
+	}
+	p.notifyRecovered(&Recovered{Replica: p.index, View: p.view, Log: hashes})
+	p.updateTimer()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
+)
+
+func TestRecover(t *testing.T) {
+	defer func() { walDir = "" }()
+	walDir = t.TempDir()
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	ps := testReplicas(t, local, 4)
+	backup := ps[1]
+	require.NotNil(t, backup.wal)
+
+	// the messages the backup got for the first block: the pre-prepare,
+	// the prepare of the replica 2 and the commits of the replicas 0 and 2
+	block := testBlock(0)
+	hash := headerHash(block)
+	backup.logEntry(&walEntry{Type: walPrePrepare, View: 0, Seq: 1, TrBlock: block,
+		Auth: ps[0].authenticate(phasePrePrepare, 0, 1, hash)})
+	backup.logEntry(&walEntry{Type: walPrepare, View: 0, Seq: 1, Sender: 2,
+		HeaderHash: hash, Auth: ps[2].authenticate(phasePrepare, 0, 1, hash)})
+	for _, i := range []int{0, 2} {
+		backup.logEntry(&walEntry{Type: walCommit, View: 0, Seq: 1, Sender: i,
+			HeaderHash: hash, Auth: ps[i].authenticate(phaseCommit, 0, 1, hash)})
+	}
+	// the pre-prepare of the next block in the next view
+	next := testBlock(1)
+	backup.logEntry(&walEntry{Type: walNewView, View: 1, Seq: 1})
+	backup.logEntry(&walEntry{Type: walPrePrepare, View: 1, Seq: 2, TrBlock: next,
+		Auth: ps[1].authenticate(phasePrePrepare, 1, 2, headerHash(next))})
+	// and half an entry, as if it crashed while writing it
+	_, err := backup.wal.Write([]byte(`{"Type":"commit","Vi`))
+	require.Nil(t, err)
+
+	backup.reset()
+	require.Nil(t, backup.Recover())
+	assert.False(t, backup.recovering)
+	assert.Equal(t, 1, backup.view)
+	assert.Equal(t, 1, backup.lastCommitted)
+	assert.Equal(t, 3, backup.nextSeq)
+	committed := backup.CommittedBlocks()
+	require.Equal(t, 1, len(committed))
+	assert.Equal(t, hash, committed[0].HeaderHash)
+	assert.Equal(t, statePrepare, backup.instances[2].state)
+
+	// without a log, there is nothing to recover
+	_, err = os.Stat(ps[2].walFile())
+	require.Nil(t, err)
+	require.Nil(t, os.Remove(ps[2].walFile()))
+	ps[2].reset()
+	require.Nil(t, ps[2].Recover())
+	assert.Equal(t, 0, ps[2].lastCommitted)
+}
+
+func TestRestartReplicas(t *testing.T) {
+	defer func() { walDir = "" }()
+	walDir = t.TempDir()
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	recovered := make(chan *Recovered, 2)
+	p, _ := testRun(t, local, 7, 10, func(p *Protocol) {
+		p.onRecoveredCB = func(r *Recovered) { recovered <- r }
+	})
+	defer testStop(p)
+	checkLog(t, p, 10)
+	assert.NotNil(t, p.RestartReplicas(7))
+	// the replicas 1 and 2 replay the blocks they committed
+	require.Nil(t, p.RestartReplicas(2))
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-recovered:
+			assert.True(t, r.Replica == 1 || r.Replica == 2)
+			assert.NotEmpty(t, r.Log)
+			assert.Nil(t, verifyRecovered(p.CommittedBlocks(), r.Log))
+		case <-time.After(5 * time.Second):
+			t.Fatal("replica didn't recover")
+		}
+	}
+}
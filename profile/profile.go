@@ -0,0 +1,124 @@
+// Package profile captures a CPU profile of every round of a simulation and
+// a heap profile after it, so that the performance regressions, e.g. in the
+// verification of the signatures or the parsing of the blocks, can be
+// diagnosed offline with "go tool pprof". The simulations tell the rounds
+// with StartRound and EndRound.
+//
+// The profiles are the ones of the process of the root, which runs all the
+// hosts in a localhost simulation. They are written to Dir, in the
+// directory the simulation runs in, and named after the host of the root
+// and the round.
+package profile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync"
+
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// Dir is the directory the profiles are written to.
+const Dir = "profiles"
+
+// profiling is the profile of the current round, see Use.
+var profiling struct {
+	sync.Mutex
+	on    bool
+	host  string
+	round int
+	// cpu is the file of the CPU profile of the round, nil if none is
+	// running
+	cpu *os.File
+}
+
+// Use turns the profiles of the rounds on or off. The simulations call it
+// on every node.
+func Use(on bool) {
+	profiling.Lock()
+	defer profiling.Unlock()
+	profiling.on = on
+}
+
+// StartRound starts the CPU profile of the round of the host. Calling it
+// again for the same round goes on with the same profile. A profile that
+// can't be started is logged, the round runs without it.
+func StartRound(si *network.ServerIdentity, round int) {
+	profiling.Lock()
+	defer profiling.Unlock()
+	if !profiling.on {
+		return
+	}
+	if profiling.cpu != nil {
+		if round == profiling.round {
+			return
+		}
+		stop()
+	}
+	profiling.host = hostName(si)
+	profiling.round = round
+	if err := os.MkdirAll(Dir, 0777); err != nil {
+		log.Error("Couldn't create the directory of the profiles:", err)
+		return
+	}
+	f, err := os.Create(fileName("cpu"))
+	if err != nil {
+		log.Error("Couldn't create the CPU profile:", err)
+		return
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		log.Error("Couldn't start the CPU profile:", err)
+		f.Close()
+		return
+	}
+	profiling.cpu = f
+}
+
+// EndRound stops the CPU profile of the round and writes a heap profile.
+func EndRound() {
+	profiling.Lock()
+	defer profiling.Unlock()
+	if profiling.cpu == nil {
+		return
+	}
+	stop()
+	f, err := os.Create(fileName("heap"))
+	if err != nil {
+		log.Error("Couldn't create the heap profile:", err)
+		return
+	}
+	defer f.Close()
+	// collect the garbage so that the profile shows the live objects
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		log.Error("Couldn't write the heap profile:", err)
+	}
+}
+
+// stop stops the CPU profile of the round. The caller must hold the lock.
+func stop() {
+	pprof.StopCPUProfile()
+	if err := profiling.cpu.Close(); err != nil {
+		log.Error("Couldn't write the CPU profile:", err)
+	}
+	profiling.cpu = nil
+	log.Lvl2("Wrote the profile of round", profiling.round, "to", Dir)
+}
+
+// fileName returns the file of the kind of profile of the round. The caller
+// must hold the lock.
+func fileName(kind string) string {
+	return filepath.Join(Dir, fmt.Sprintf("%s_round%03d.%s.pprof",
+		profiling.host, profiling.round, kind))
+}
+
+// hostName returns the address of the host without the characters a file
+// name can't hold.
+func hostName(si *network.ServerIdentity) string {
+	return strings.NewReplacer(":", "_", "/", "_").Replace(si.Address.NetworkAddress())
+}
@@ -0,0 +1,65 @@
+package profile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+func TestRounds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	require.Nil(t, err)
+	require.Nil(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+	si := network.NewServerIdentity(network.Suite.Point().Base(),
+		network.NewTCPAddress("127.0.0.1:2000"))
+
+	// off, nothing is written
+	StartRound(si, 0)
+	EndRound()
+	_, err = os.Stat(Dir)
+	require.True(t, os.IsNotExist(err))
+
+	Use(true)
+	defer Use(false)
+	for round := 0; round < 2; round++ {
+		StartRound(si, round)
+		StartRound(si, round)
+		EndRound()
+	}
+	// a new round stops the profile of the previous one
+	StartRound(si, 2)
+	StartRound(si, 3)
+	EndRound()
+	// without a running profile, nothing is written
+	EndRound()
+
+	files, err := filepath.Glob(filepath.Join(Dir, "*.pprof"))
+	require.Nil(t, err)
+	require.Equal(t, []string{
+		"profiles/127.0.0.1_2000_round000.cpu.pprof",
+		"profiles/127.0.0.1_2000_round000.heap.pprof",
+		"profiles/127.0.0.1_2000_round001.cpu.pprof",
+		"profiles/127.0.0.1_2000_round001.heap.pprof",
+		"profiles/127.0.0.1_2000_round002.cpu.pprof",
+		"profiles/127.0.0.1_2000_round003.cpu.pprof",
+		"profiles/127.0.0.1_2000_round003.heap.pprof",
+	}, files)
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		require.Nil(t, err)
+		require.True(t, fi.Size() > 0, file)
+	}
+}
@@ -0,0 +1,108 @@
+// Package seed derives the random streams of a simulation from its
+// GlobalSeed, so that a run can be reproduced to debug the heisenbugs of the
+// consensus protocols: with the same seed, the hosts get the same keys, the
+// shards the same members, the clients the same transactions and the
+// injected faults hit the same messages. Every stream has a name and its own
+// seed, so that drawing more from one stream doesn't change the others.
+// Without a global seed, the streams are seeded from the clock as before.
+//
+// The nonces of the signatures, the ports of a localhost simulation and the
+// scheduling of the goroutines and of the network are not seeded, so the
+// messages may still arrive in another order.
+package seed
+
+import (
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/random"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// global is the global seed of this process, see Use.
+var global struct {
+	sync.Mutex
+	seed int64
+}
+
+// Use sets the global seed of this process, 0 to seed the streams from the
+// clock. The simulations call it in Setup and on every node.
+func Use(seed int64) {
+	global.Lock()
+	defer global.Unlock()
+	if seed != 0 && seed != global.seed {
+		log.Lvl1("Seeding the random streams with", seed)
+	}
+	global.seed = seed
+}
+
+// Global returns the global seed of this process, 0 if there is none.
+func Global() int64 {
+	global.Lock()
+	defer global.Unlock()
+	return global.seed
+}
+
+// Int64 returns the seed of the named stream, derived from the global seed,
+// or from the clock if there is none.
+func Int64(name string) int64 {
+	g := Global()
+	if g == 0 {
+		return time.Now().UnixNano()
+	}
+	h := derive(g, name)
+	return int64(binary.LittleEndian.Uint64(h[:8]))
+}
+
+// Rand returns a generator of the named stream.
+func Rand(name string) *rand.Rand {
+	return rand.New(rand.NewSource(Int64(name)))
+}
+
+// Stream returns the named stream to pick keys and secrets from, or
+// random.Stream if there is no global seed. The stream is not safe for
+// concurrent use.
+func Stream(name string) cipher.Stream {
+	g := Global()
+	if g == 0 {
+		return random.Stream
+	}
+	h := derive(g, name)
+	return network.Suite.Cipher(h[:])
+}
+
+// derive returns the hash of the global seed and the name of a stream.
+func derive(g int64, name string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(fmt.Sprintf("%d/%s", g, name)))
+}
+
+// Keys gives the hosts of the simulation new key pairs picked from the
+// "keys" stream, keeping their addresses, and the roster an ID derived from
+// the global seed, as it seeds the first assignment of the shards. It keeps
+// the keys of onet if there is no global seed. Setup calls it after
+// CreateRoster, before CreateTree.
+func Keys(sc *onet.SimulationConfig) {
+	if Global() == 0 {
+		return
+	}
+	stream := Stream("keys")
+	list := make([]*network.ServerIdentity, len(sc.Roster.List))
+	sc.PrivateKeys = make(map[network.Address]abstract.Scalar)
+	for i, si := range sc.Roster.List {
+		private := network.Suite.Scalar().Pick(stream)
+		public := network.Suite.Point().Mul(nil, private)
+		list[i] = network.NewServerIdentity(public, si.Address)
+		sc.PrivateKeys[si.Address] = private
+	}
+	sc.Roster = onet.NewRoster(list)
+	h := derive(Global(), "roster")
+	copy(sc.Roster.ID[:], h[:])
+}
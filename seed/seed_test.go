@@ -0,0 +1,62 @@
+package seed
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/random"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+func TestStreams(t *testing.T) {
+	Use(42)
+	defer Use(0)
+	require.Equal(t, int64(42), Global())
+	require.Equal(t, Int64("faults"), Int64("faults"))
+	require.NotEqual(t, Int64("faults"), Int64("netem"))
+	require.Equal(t, Rand("load").Int63(), Rand("load").Int63())
+	require.Equal(t, random.Bytes(16, Stream("keys")), random.Bytes(16, Stream("keys")))
+
+	first := Int64("faults")
+	Use(43)
+	require.NotEqual(t, first, Int64("faults"))
+	Use(0)
+	require.True(t, Stream("keys") == random.Stream)
+}
+
+func TestKeys(t *testing.T) {
+	roster := func() *onet.SimulationConfig {
+		sc := &onet.SimulationConfig{}
+		var list []*network.ServerIdentity
+		for _, addr := range []string{"127.0.0.1:2000", "127.0.0.1:2002"} {
+			list = append(list, network.NewServerIdentity(network.Suite.Point().Base(),
+				network.NewTCPAddress(addr)))
+		}
+		sc.Roster = onet.NewRoster(list)
+		return sc
+	}
+	// without a global seed, the keys of onet are kept
+	sc := roster()
+	Keys(sc)
+	require.Nil(t, sc.PrivateKeys)
+
+	Use(42)
+	defer Use(0)
+	sc1, sc2 := roster(), roster()
+	Keys(sc1)
+	Keys(sc2)
+	require.Equal(t, sc1.Roster.ID, sc2.Roster.ID)
+	for i, si := range sc1.Roster.List {
+		require.True(t, si.Public.Equal(sc2.Roster.List[i].Public))
+		require.Equal(t, sc.Roster.List[i].Address, si.Address)
+		private := sc1.PrivateKeys[si.Address]
+		require.True(t, network.Suite.Point().Mul(nil, private).Equal(si.Public))
+	}
+	require.False(t, sc1.Roster.List[0].Public.Equal(sc1.Roster.List[1].Public))
+}
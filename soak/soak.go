@@ -0,0 +1,137 @@
+// Package soak runs the simulations as soak tests: over thousands of
+// consecutive rounds, it samples the memory in use and the goroutines every
+// Every rounds and fails the run if either grows without bound, as the
+// goroutines of protocol instances that never return do. The simulations
+// tell the rounds with EndRound and check the samples with Check.
+//
+// The samples are the ones of the process of the root, which runs all the
+// hosts in a localhost simulation. They are recorded in the monitor as
+// "soak_heap_mb" and "soak_goroutines".
+package soak
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/simul/monitor"
+)
+
+// Every is the number of rounds between two samples.
+const Every = 100
+
+// MinSamples is the number of samples Check needs to tell a leak.
+const MinSamples = 4
+
+// HeapSlack and GoroutineSlack are how much more memory and goroutines, as
+// a share of the most of the first half of the samples, the second half
+// can hold without counting as a leak.
+var (
+	HeapSlack      = 0.25
+	GoroutineSlack = 0.1
+)
+
+// Sample is the memory in use and the goroutines after a round.
+type Sample struct {
+	Round      int
+	HeapAlloc  uint64
+	Goroutines int
+}
+
+// sampling are the samples of the run, see Use.
+var sampling struct {
+	sync.Mutex
+	on      bool
+	samples []Sample
+}
+
+// Use turns the samples on or off and forgets the previous ones. The
+// simulations call it before their first round.
+func Use(on bool) {
+	sampling.Lock()
+	defer sampling.Unlock()
+	sampling.on = on
+	sampling.samples = nil
+}
+
+// EndRound samples the memory and the goroutines after every Every rounds,
+// counted from 0.
+func EndRound(round int) {
+	sampling.Lock()
+	defer sampling.Unlock()
+	if !sampling.on || (round+1)%Every != 0 {
+		return
+	}
+	// collect the garbage so that the sample holds the live objects
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	s := Sample{Round: round, HeapAlloc: ms.HeapAlloc,
+		Goroutines: runtime.NumGoroutine()}
+	sampling.samples = append(sampling.samples, s)
+	log.Lvl1("Soak: after round", round, "heap", s.HeapAlloc>>20, "MB,",
+		s.Goroutines, "goroutines")
+	monitor.RecordSingleMeasure("soak_heap_mb", float64(s.HeapAlloc)/(1<<20))
+	monitor.RecordSingleMeasure("soak_goroutines", float64(s.Goroutines))
+}
+
+// Samples returns the samples of the run.
+func Samples() []Sample {
+	sampling.Lock()
+	defer sampling.Unlock()
+	return append([]Sample(nil), sampling.samples...)
+}
+
+// Check returns an error if the memory or the goroutines grew without bound
+// during the run, that is if even the least of the second half of the
+// samples is above the most of the first half, give or take the slack. It
+// needs MinSamples samples, so at least MinSamples*Every rounds.
+func Check() error {
+	samples := Samples()
+	if len(samples) < MinSamples {
+		sampling.Lock()
+		on := sampling.on
+		sampling.Unlock()
+		if on {
+			log.Warn("Soak: not enough rounds to check for leaks, need",
+				MinSamples*Every)
+		}
+		return nil
+	}
+	heap := make([]float64, len(samples))
+	goroutines := make([]float64, len(samples))
+	for i, s := range samples {
+		heap[i] = float64(s.HeapAlloc)
+		goroutines[i] = float64(s.Goroutines)
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	if growing(goroutines, GoroutineSlack) {
+		return fmt.Errorf("goroutines leak: %d after round %d, %d after round %d",
+			first.Goroutines, first.Round, last.Goroutines, last.Round)
+	}
+	if growing(heap, HeapSlack) {
+		return fmt.Errorf("memory leak: %d MB after round %d, %d MB after round %d",
+			first.HeapAlloc>>20, first.Round, last.HeapAlloc>>20, last.Round)
+	}
+	return nil
+}
+
+// growing tells whether the least of the second half of the values is above
+// the most of the first half by more than the slack.
+func growing(values []float64, slack float64) bool {
+	half := len(values) / 2
+	most := values[0]
+	for _, v := range values[:half] {
+		if v > most {
+			most = v
+		}
+	}
+	least := values[half]
+	for _, v := range values[half:] {
+		if v < least {
+			least = v
+		}
+	}
+	return least > most*(1+slack)
+}
@@ -0,0 +1,59 @@
+package soak
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+func TestGrowing(t *testing.T) {
+	// bounded, even if it moves up and down
+	assert.False(t, growing([]float64{100, 120, 110, 118, 105, 119}, 0.1))
+	// a step up that stays, within the slack
+	assert.False(t, growing([]float64{100, 100, 108, 109}, 0.1))
+	assert.True(t, growing([]float64{100, 200, 300, 400}, 0.1))
+	// one low sample in the second half is enough
+	assert.False(t, growing([]float64{100, 200, 300, 150, 500}, 0.1))
+}
+
+func TestCheck(t *testing.T) {
+	// off, nothing is sampled
+	for round := 0; round < MinSamples*Every; round++ {
+		EndRound(round)
+	}
+	require.Empty(t, Samples())
+	require.Nil(t, Check())
+
+	Use(true)
+	defer Use(false)
+	for round := 0; round < MinSamples*Every; round++ {
+		EndRound(round)
+	}
+	samples := Samples()
+	require.Equal(t, MinSamples, len(samples))
+	assert.Equal(t, Every-1, samples[0].Round)
+	assert.True(t, samples[0].HeapAlloc > 0)
+	assert.True(t, samples[0].Goroutines > 0)
+	require.Nil(t, Check())
+
+	// goroutines that never return
+	Use(true)
+	stop := make(chan bool)
+	defer close(stop)
+	for round := 0; round < MinSamples*Every; round++ {
+		go func() { <-stop }()
+		EndRound(round)
+	}
+	require.NotNil(t, Check())
+
+	// too few rounds to tell
+	Use(true)
+	EndRound(Every - 1)
+	require.Nil(t, Check())
+}
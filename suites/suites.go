@@ -0,0 +1,130 @@
+// Package suites selects the cipher suite of a simulation by name, so that
+// the cost of the cryptography on different curves can be compared on the
+// same protocol. The protocols take the suite of their node, which is the
+// global suite of the network library of onet, and so does the generation
+// of the keys of the hosts: Use sets it.
+//
+// The simulations call Setup in their Setup, before they create the
+// roster. The platforms running the nodes in other processes, e.g.
+// DeterLab, decode the keys of the roster before the simulation is created
+// there, so Setup also writes the name of the suite to File in the
+// directory of the simulation, and the simulation binaries call Load before
+// they start.
+package suites
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/ed25519"
+	"gopkg.in/dedis/crypto.v0/edwards"
+	"gopkg.in/dedis/crypto.v0/nist"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// Default is the name of the suite of onet, used if none is given.
+const Default = "ed25519"
+
+// File is the file of the directory of a simulation holding the name of
+// its suite, see Save and Load.
+const File = "suite"
+
+// constructors are the suites by name.
+var constructors = map[string]func() abstract.Suite{
+	// the optimized implementation of Ed25519 onet uses
+	"ed25519": func() abstract.Suite { return ed25519.NewAES128SHA256Ed25519(false) },
+	// the generic implementation of Ed25519 over twisted Edwards curves
+	"edwards25519": func() abstract.Suite { return edwards.NewAES128SHA256Ed25519(false) },
+	// the NIST P-256 curve
+	"p256": func() abstract.Suite { return nist.NewAES128SHA256P256() },
+	// the subgroup of quadratic residues modulo a 512-bit prime, for
+	// testing only
+	"qr512": func() abstract.Suite { return nist.NewAES128SHA256QR512() },
+}
+
+// current is the name of the suite in use, see Use.
+var current = struct {
+	sync.Mutex
+	name string
+}{name: Default}
+
+// Names returns the names of the suites, sorted.
+func Names() []string {
+	var names []string
+	for name := range constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New returns a new suite of the name, the default one if it is empty.
+func New(name string) (abstract.Suite, error) {
+	if name == "" {
+		name = Default
+	}
+	c, ok := constructors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown suite %q, not one of %s", name,
+			strings.Join(Names(), ", "))
+	}
+	return c(), nil
+}
+
+// Use makes the suite of the name the one of this process, the default one
+// if it is empty. Using the suite in use again doesn't change it, so that
+// the keys and the points already made stay valid.
+func Use(name string) error {
+	if name == "" {
+		name = Default
+	}
+	current.Lock()
+	defer current.Unlock()
+	if name == current.name {
+		return nil
+	}
+	suite, err := New(name)
+	if err != nil {
+		return err
+	}
+	log.Lvl1("Using the suite", name)
+	network.Suite = suite
+	current.name = name
+	return nil
+}
+
+// Current returns the name of the suite in use.
+func Current() string {
+	current.Lock()
+	defer current.Unlock()
+	return current.name
+}
+
+// Setup uses the suite of the name and writes its name to File in the
+// directory of the simulation.
+func Setup(dir, name string) error {
+	if err := Use(name); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, File), []byte(Current()+"\n"), 0644)
+}
+
+// Load uses the suite named in File of the working directory, if there is
+// one.
+func Load() error {
+	buf, err := ioutil.ReadFile(File)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return Use(strings.TrimSpace(string(buf)))
+}
@@ -0,0 +1,70 @@
+package suites
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/onet.v1/crypto"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+func TestUse(t *testing.T) {
+	defer Use("")
+	require.Equal(t, Default, Current())
+	for _, name := range Names() {
+		require.Nil(t, Use(name))
+		assert.Equal(t, name, Current())
+		// the keys and the signatures follow the suite of the network
+		kp := config.NewKeyPair(network.Suite)
+		sig, err := crypto.SignSchnorr(network.Suite, kp.Secret, []byte("block"))
+		require.Nil(t, err, name)
+		assert.Nil(t, crypto.VerifySchnorr(network.Suite, kp.Public, []byte("block"), sig), name)
+		// and so do the points decoded from the messages, also of the
+		// clones of a key changed afterwards, as in onet's CreateRoster
+		public := kp.Public.Clone()
+		kp.Public.Add(kp.Public, network.Suite.Point().Base())
+		si := network.NewServerIdentity(public, network.NewTCPAddress("127.0.0.1:2000"))
+		buf, err := network.Marshal(si)
+		require.Nil(t, err)
+		_, msg, err := network.Unmarshal(buf)
+		require.Nil(t, err, name)
+		assert.True(t, msg.(*network.ServerIdentity).Public.Equal(public), name)
+	}
+	qr512 := network.Suite
+	require.Nil(t, Use("qr512"))
+	require.Nil(t, Use("qr512"))
+	assert.Equal(t, qr512, network.Suite)
+	assert.NotNil(t, Use("curve448"))
+	assert.NotNil(t, Setup(os.TempDir(), "curve448"))
+	assert.Equal(t, "qr512", Current())
+}
+
+func TestSetupLoad(t *testing.T) {
+	defer Use("")
+	dir, err := ioutil.TempDir("", "suites")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	require.Nil(t, err)
+	require.Nil(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	// no file, the suite stays
+	require.Nil(t, Load())
+	assert.Equal(t, Default, Current())
+
+	require.Nil(t, Setup(dir, "p256"))
+	assert.Equal(t, "p256", Current())
+	require.Nil(t, Use(""))
+	require.Nil(t, Load())
+	assert.Equal(t, "p256", Current())
+}
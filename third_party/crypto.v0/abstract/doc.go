@@ -0,0 +1,5 @@
+/*
+This package defines abstract interfaces for advanced cryptographic primitives.
+Implementations of these interfaces are provided in other packages.
+*/
+package abstract
@@ -0,0 +1,8 @@
+package cipher
+
+import (
+	"crypto/cipher"
+)
+
+type Stream cipher.Stream
+type Block cipher.Block
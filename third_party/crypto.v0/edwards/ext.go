@@ -0,0 +1,554 @@
+package edwards
+
+import (
+	"crypto/cipher"
+	"encoding/hex"
+	"io"
+	"math/big"
+	"sync"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/group"
+	"gopkg.in/dedis/crypto.v0/nist"
+)
+
+type extPoint struct {
+	X, Y, Z, T nist.Int
+	c          *ExtendedCurve
+}
+
+func (P *extPoint) initXY(x, y *big.Int, c abstract.Group) {
+	P.c = c.(*ExtendedCurve)
+	P.X.Init(x, &P.c.P)
+	P.Y.Init(y, &P.c.P)
+	P.Z.Init64(1, &P.c.P)
+	P.T.Mul(&P.X, &P.Y)
+}
+
+func (P *extPoint) getXY() (x, y *nist.Int) {
+	P.normalize()
+	return &P.X, &P.Y
+}
+
+func (P *extPoint) String() string {
+	P.normalize()
+	//return P.c.pointString(&P.X,&P.Y)
+	buf, _ := P.MarshalBinary()
+	return hex.EncodeToString(buf)
+}
+
+func (P *extPoint) MarshalSize() int {
+	return P.c.PointLen()
+}
+
+func (P *extPoint) MarshalBinary() ([]byte, error) {
+	P.normalize()
+	return P.c.encodePoint(&P.X, &P.Y), nil
+}
+
+func (P *extPoint) UnmarshalBinary(b []byte) error {
+	if err := P.c.decodePoint(b, &P.X, &P.Y); err != nil {
+		return err
+	}
+	P.Z.Init64(1, &P.c.P)
+	P.T.Mul(&P.X, &P.Y)
+	return nil
+}
+
+func (P *extPoint) MarshalTo(w io.Writer) (int, error) {
+	return group.PointMarshalTo(P, w)
+}
+
+func (P *extPoint) UnmarshalFrom(r io.Reader) (int, error) {
+	return group.PointUnmarshalFrom(P, r)
+}
+
+func (P *extPoint) HideLen() int {
+	return P.c.hide.HideLen()
+}
+
+func (P *extPoint) HideEncode(rand cipher.Stream) []byte {
+	return P.c.hide.HideEncode(P, rand)
+}
+
+func (P *extPoint) HideDecode(rep []byte) {
+	P.c.hide.HideDecode(P, rep)
+}
+
+// Equality test for two Points on the same curve.
+// We can avoid inversions here because:
+//
+//	(X1/Z1,Y1/Z1) == (X2/Z2,Y2/Z2)
+//		iff
+//	(X1*Z2,Y1*Z2) == (X2*Z1,Y2*Z1)
+//
+func (P1 *extPoint) Equal(CP2 abstract.Point) bool {
+	P2 := CP2.(*extPoint)
+	var t1, t2 nist.Int
+	xeq := t1.Mul(&P1.X, &P2.Z).Equal(t2.Mul(&P2.X, &P1.Z))
+	yeq := t1.Mul(&P1.Y, &P2.Z).Equal(t2.Mul(&P2.Y, &P1.Z))
+	return xeq && yeq
+}
+
+func (P *extPoint) Set(CP2 abstract.Point) abstract.Point {
+	P2 := CP2.(*extPoint)
+	P.c = P2.c
+	P.X.Set(&P2.X)
+	P.Y.Set(&P2.Y)
+	P.Z.Set(&P2.Z)
+	P.T.Set(&P2.T)
+	return P
+}
+
+// Clone copies the coordinates with Set, copying the Ints would share the
+// words of their values.
+func (P *extPoint) Clone() abstract.Point {
+	return new(extPoint).Set(P)
+}
+
+func (P *extPoint) Null() abstract.Point {
+	P.Set(&P.c.null)
+	return P
+}
+
+func (P *extPoint) Base() abstract.Point {
+	P.Set(&P.c.base)
+	return P
+}
+
+func (P *extPoint) PickLen() int {
+	return P.c.pickLen()
+}
+
+// Normalize the point's representation to Z=1.
+func (P *extPoint) normalize() {
+	P.Z.Inv(&P.Z)
+	P.X.Mul(&P.X, &P.Z)
+	P.Y.Mul(&P.Y, &P.Z)
+	P.Z.V.SetInt64(1)
+	P.T.Mul(&P.X, &P.Y)
+}
+
+// Check the validity of the T coordinate
+func (P *extPoint) checkT() {
+	var t1, t2 nist.Int
+	if !t1.Mul(&P.X, &P.Y).Equal(t2.Mul(&P.Z, &P.T)) {
+		panic("oops")
+	}
+}
+
+func (P *extPoint) Pick(data []byte, rand cipher.Stream) (abstract.Point, []byte) {
+	leftover := P.c.pickPoint(P, data, rand)
+	return P, leftover
+}
+
+// Set to the point msg hashes to,
+// of which nobody knows the discrete logarithm.
+func (P *extPoint) HashToPoint(msg []byte) abstract.Point {
+	var Q extPoint
+	P.c.hashToPoint(P, &Q, msg)
+	return P
+}
+
+// Extract embedded data from a point group element
+func (P *extPoint) Data() ([]byte, error) {
+	P.normalize()
+	return P.c.data(&P.X, &P.Y)
+}
+
+// Add two points using optimized extended coordinate addition formulas.
+func (P *extPoint) Add(CP1, CP2 abstract.Point) abstract.Point {
+	P1 := CP1.(*extPoint)
+	P2 := CP2.(*extPoint)
+	X1, Y1, Z1, T1 := &P1.X, &P1.Y, &P1.Z, &P1.T
+	X2, Y2, Z2, T2 := &P2.X, &P2.Y, &P2.Z, &P2.T
+	X3, Y3, Z3, T3 := &P.X, &P.Y, &P.Z, &P.T
+	tmp := acquireTemps()
+	defer extTemps.Put(tmp)
+	A, B, C, D, E, F, G, H := &tmp[0], &tmp[1], &tmp[2], &tmp[3], &tmp[4], &tmp[5], &tmp[6], &tmp[7]
+
+	A.Mul(X1, X2)
+	B.Mul(Y1, Y2)
+	C.Mul(T1, T2).Mul(C, &P.c.d)
+	D.Mul(Z1, Z2)
+	E.Add(X1, Y1).Mul(E, F.Add(X2, Y2)).Sub(E, A).Sub(E, B)
+	F.Sub(D, C)
+	G.Add(D, C)
+	H.Mul(&P.c.a, A).Sub(B, H)
+	X3.Mul(E, F)
+	Y3.Mul(G, H)
+	T3.Mul(E, H)
+	Z3.Mul(F, G)
+	return P
+}
+
+// Subtract points.
+func (P *extPoint) Sub(CP1, CP2 abstract.Point) abstract.Point {
+	P1 := CP1.(*extPoint)
+	P2 := CP2.(*extPoint)
+	X1, Y1, Z1, T1 := &P1.X, &P1.Y, &P1.Z, &P1.T
+	X2, Y2, Z2, T2 := &P2.X, &P2.Y, &P2.Z, &P2.T
+	X3, Y3, Z3, T3 := &P.X, &P.Y, &P.Z, &P.T
+	tmp := acquireTemps()
+	defer extTemps.Put(tmp)
+	A, B, C, D, E, F, G, H := &tmp[0], &tmp[1], &tmp[2], &tmp[3], &tmp[4], &tmp[5], &tmp[6], &tmp[7]
+
+	A.Mul(X1, X2)
+	B.Mul(Y1, Y2)
+	C.Mul(T1, T2).Mul(C, &P.c.d)
+	D.Mul(Z1, Z2)
+	E.Add(X1, Y1).Mul(E, F.Sub(Y2, X2)).Add(E, A).Sub(E, B)
+	F.Add(D, C)
+	G.Sub(D, C)
+	H.Mul(&P.c.a, A).Add(B, H)
+	X3.Mul(E, F)
+	Y3.Mul(G, H)
+	T3.Mul(E, H)
+	Z3.Mul(F, G)
+	return P
+}
+
+// extTemps holds the temporaries of Add, Sub and double, whose big.Int
+// buffers would otherwise be allocated for every operation.
+var extTemps = sync.Pool{New: func() interface{} { return new([8]nist.Int) }}
+
+func acquireTemps() *[8]nist.Int {
+	return extTemps.Get().(*[8]nist.Int)
+}
+
+// Find the negative of point A.
+// For Edwards curves, the negative of (x,y) is (-x,y).
+func (P *extPoint) Neg(CA abstract.Point) abstract.Point {
+	A := CA.(*extPoint)
+	P.c = A.c
+	P.X.Neg(&A.X)
+	P.Y.Set(&A.Y)
+	P.Z.Set(&A.Z)
+	P.T.Neg(&A.T)
+	return P
+}
+
+// Optimized point doubling for use in scalar multiplication.
+// Uses the formulae in section 3.3 of:
+// https://www.iacr.org/archive/asiacrypt2008/53500329/53500329.pdf
+func (P *extPoint) double() {
+	X1, Y1, Z1, T1 := &P.X, &P.Y, &P.Z, &P.T
+	tmp := acquireTemps()
+	defer extTemps.Put(tmp)
+	A, B, C, D, E, F, G, H := &tmp[0], &tmp[1], &tmp[2], &tmp[3], &tmp[4], &tmp[5], &tmp[6], &tmp[7]
+
+	A.Mul(X1, X1)
+	B.Mul(Y1, Y1)
+	C.Mul(Z1, Z1).Add(C, C)
+	D.Mul(&P.c.a, A)
+	E.Add(X1, Y1).Mul(E, E).Sub(E, A).Sub(E, B)
+	G.Add(D, B)
+	F.Sub(G, C)
+	H.Sub(D, B)
+	X1.Mul(E, F)
+	Y1.Mul(G, H)
+	T1.Mul(E, H)
+	Z1.Mul(F, G)
+}
+
+// Multiply point p by scalar s with a Montgomery ladder, see mulLadder,
+// unless the curve has the Vartime option.
+func (P *extPoint) Mul(G abstract.Point, s abstract.Scalar) abstract.Point {
+	v := s.(*nist.Int).V
+	if P.c.vartime {
+		if G == nil {
+			return P.mulVartime(nil, &v)
+		}
+		return P.mulVartime(G.(*extPoint), &v)
+	}
+	if G == nil {
+		G = &P.c.base
+	}
+	return P.mulLadder(G.(*extPoint), &v)
+}
+
+// MulDoubleVartime sets P to a*A + b*B, B being the base point, by double
+// and add and with the table of B, whatever the options of the curve. It
+// runs in variable time, so the scalars must not be secret, e.g. when
+// verifying signatures.
+func (P *extPoint) MulDoubleVartime(a abstract.Scalar, A abstract.Point, b abstract.Scalar) abstract.Point {
+	aA := extPoint{c: P.c}
+	aA.mulVartime(A.(*extPoint), &a.(*nist.Int).V)
+	P.mulVartime(nil, &b.(*nist.Int).V)
+	return P.Add(P, &aA)
+}
+
+// mulVartime sets P to v times G using the repeated doubling method, or v
+// times the base point with the precomputed table if G is nil. Both only add
+// for the bits set, so they take a time depending on v.
+//
+// Currently doesn't implement the optimization of
+// switching between projective and extended coordinates during
+// scalar multiplication.
+//
+func (P *extPoint) mulVartime(G *extPoint, v *big.Int) abstract.Point {
+	if G == nil {
+		if table := P.c.baseTable(); v.BitLen() <= len(table)*baseWindow {
+			return P.mulBase(table, v)
+		}
+		G = &P.c.base
+	}
+	T := P
+	if G == P { // Must use temporary for in-place multiply
+		T = &extPoint{}
+	}
+	T.Set(&P.c.null) // Initialize to identity element (0,1)
+	for i := v.BitLen() - 1; i >= 0; i-- {
+		T.double()
+		if v.Bit(i) != 0 {
+			T.Add(T, G)
+		}
+	}
+	if T != P {
+		P.Set(T)
+	}
+	return P
+}
+
+// mulLadder sets P to v times G with a Montgomery ladder: each bit of v, up
+// to the size of the group order, costs one addition and one doubling whatever
+// its value, and the points to update are swapped in place by cswap instead
+// of being selected by the bits of v. On the curves over 2^255-19 the ladder
+// computes on FieldElements, see mulLadder25519; on the others the big.Int
+// arithmetic underneath isn't constant time itself, so this only removes the
+// dependency on the bits of the scalar.
+func (P *extPoint) mulLadder(G *extPoint, v *big.Int) abstract.Point {
+	if P.c.field != nil {
+		return P.mulLadder25519(G, v)
+	}
+	var R0, R1 extPoint // R1 - R0 = G
+	R0.Set(&P.c.null)
+	R1.Set(G)
+	var swap uint
+	for i := P.c.ladderBits(v) - 1; i >= 0; i-- {
+		b := v.Bit(i)
+		R0.cswap(&R1, swap^b)
+		swap = b
+		R1.Add(&R0, &R1)
+		R0.double()
+	}
+	R0.cswap(&R1, swap)
+	return P.Set(&R0)
+}
+
+// cswap swaps the coordinates of P and Q if b is 1 and keeps them if it is 0,
+// see cswapInts.
+func (P *extPoint) cswap(Q *extPoint, b uint) {
+	cswapInts(len(P.c.P.Bits()), b, [2]*big.Int{&P.X.V, &Q.X.V},
+		[2]*big.Int{&P.Y.V, &Q.Y.V}, [2]*big.Int{&P.Z.V, &Q.Z.V},
+		[2]*big.Int{&P.T.V, &Q.T.V})
+}
+
+// mulLadder25519 is mulLadder on FieldElements, which swaps the points in
+// constant time instead of indexing them by the bits of v.
+func (P *extPoint) mulLadder25519(G *extPoint, v *big.Int) abstract.Point {
+	var R [2]extElems // R[1] - R[0] = G
+	R[0].setPoint(&P.c.null)
+	R[1].setPoint(G)
+	var swap uint
+	for i := P.c.ladderBits(v) - 1; i >= 0; i-- {
+		b := v.Bit(i)
+		R[0].swap(&R[1], swap^b)
+		swap = b
+		R[1].add(&R[0], &R[1], P.c.field)
+		R[0].double(P.c.field)
+	}
+	R[0].swap(&R[1], swap)
+	R[0].point(P)
+	return P
+}
+
+// extElems is an extPoint with FieldElement coordinates.
+type extElems struct {
+	X, Y, Z, T FieldElement
+}
+
+// fieldParams are the parameters of a curve as FieldElements.
+type fieldParams struct {
+	a, d FieldElement
+}
+
+func (E *extElems) setPoint(P *extPoint) {
+	E.X.SetInt(&P.X)
+	E.Y.SetInt(&P.Y)
+	E.Z.SetInt(&P.Z)
+	E.T.SetInt(&P.T)
+}
+
+func (E *extElems) point(P *extPoint) {
+	for _, c := range []struct {
+		dst *nist.Int
+		src *FieldElement
+	}{{&P.X, &E.X}, {&P.Y, &E.Y}, {&P.Z, &E.Z}, {&P.T, &E.T}} {
+		c.dst.M = &P.c.P
+		c.src.Int(c.dst)
+	}
+}
+
+func (E *extElems) swap(F *extElems, b uint) {
+	E.X.Swap(&F.X, b)
+	E.Y.Swap(&F.Y, b)
+	E.Z.Swap(&F.Z, b)
+	E.T.Swap(&F.T, b)
+}
+
+// add is extPoint.Add on FieldElements.
+func (E *extElems) add(E1, E2 *extElems, f *fieldParams) {
+	var A, B, C, D, F, G, H, t FieldElement
+	A.Mul(&E1.X, &E2.X)
+	B.Mul(&E1.Y, &E2.Y)
+	C.Mul(&E1.T, &E2.T).Mul(&C, &f.d)
+	D.Mul(&E1.Z, &E2.Z)
+	var e FieldElement
+	e.Add(&E1.X, &E1.Y).Mul(&e, t.Add(&E2.X, &E2.Y)).Sub(&e, &A).Sub(&e, &B)
+	F.Sub(&D, &C)
+	G.Add(&D, &C)
+	H.Mul(&f.a, &A).Sub(&B, &H)
+	E.X.Mul(&e, &F)
+	E.Y.Mul(&G, &H)
+	E.T.Mul(&e, &H)
+	E.Z.Mul(&F, &G)
+}
+
+// double is extPoint.double on FieldElements.
+func (E *extElems) double(f *fieldParams) {
+	var A, B, C, D, e, F, G, H FieldElement
+	A.Square(&E.X)
+	B.Square(&E.Y)
+	C.Square(&E.Z).Add(&C, &C)
+	D.Mul(&f.a, &A)
+	e.Add(&E.X, &E.Y).Square(&e).Sub(&e, &A).Sub(&e, &B)
+	G.Add(&D, &B)
+	F.Sub(&G, &C)
+	H.Sub(&D, &B)
+	E.X.Mul(&e, &F)
+	E.Y.Mul(&G, &H)
+	E.T.Mul(&e, &H)
+	E.Z.Mul(&F, &G)
+}
+
+// mulBase sets P to v times the base point, adding one point of the table
+// per window of v instead of doubling for every bit.
+func (P *extPoint) mulBase(table [][]extPoint, v *big.Int) abstract.Point {
+	P.Set(&P.c.null)
+	for i := range table {
+		d := 0
+		for j := baseWindow - 1; j >= 0; j-- {
+			d = d<<1 | int(v.Bit(i*baseWindow+j))
+		}
+		if d != 0 {
+			P.Add(P, &table[i][d])
+		}
+	}
+	return P
+}
+
+// baseWindow is the number of bits of the scalar handled by each row of the
+// base point table.
+const baseWindow = 4
+
+// baseTable returns the table of the multiples of the base point, computed
+// on the first call: the i-th row holds d * 2^(baseWindow*i) * B for every
+// window value d, enough rows to cover the scalars of the group.
+func (c *ExtendedCurve) baseTable() [][]extPoint {
+	c.baseOnce.Do(func() {
+		rows := (c.order.V.BitLen() + baseWindow - 1) / baseWindow
+		c.table = make([][]extPoint, rows)
+		var B extPoint
+		B.Set(&c.base)
+		for i := range c.table {
+			row := make([]extPoint, 1<<baseWindow)
+			row[0].Set(&c.null)
+			for d := 1; d < len(row); d++ {
+				row[d].c = c
+				row[d].Add(&row[d-1], &B)
+			}
+			c.table[i] = row
+			for j := 0; j < baseWindow; j++ {
+				B.double()
+			}
+		}
+	})
+	return c.table
+}
+
+// ExtendedCurve implements Twisted Edwards curves
+// using projective coordinate representation (X:Y:Z),
+// satisfying the identities x = X/Z, y = Y/Z.
+// This representation still supports all Twisted Edwards curves
+// and avoids expensive modular inversions on the critical paths.
+// Uses the projective arithmetic formulas in:
+// http://cr.yp.to/newelliptic/newelliptic-20070906.pdf
+//
+
+// ExtendedCurve implements Twisted Edwards curves
+// using the Extended Coordinate representation specified in:
+// Hisil et al, "Twisted Edwards Curves Revisited",
+// http://eprint.iacr.org/2008/522
+//
+// This implementation is designed to work with all Twisted Edwards curves,
+// foregoing the further optimizations that are available for the
+// special case with curve parameter a=-1.
+// We leave the task of hyperoptimization to curve-specific implementations
+// such as the ed25519 package.
+//
+type ExtendedCurve struct {
+	curve          // generic Edwards curve functionality
+	null  extPoint // Constant identity/null point (0,1)
+	base  extPoint // Standard base point
+
+	// Multiples of the base point, see baseTable
+	baseOnce sync.Once
+	table    [][]extPoint
+
+	// vartime makes Mul use mulVartime instead of mulLadder, see Vartime
+	vartime bool
+	// field are the parameters of mulLadder25519, nil on the curves over
+	// another field than 2^255-19
+	field *fieldParams
+}
+
+// Option is an option of ExtendedCurve.Init.
+type Option int
+
+const (
+	// Vartime makes the multiplications of points by scalars double and
+	// add, and those of the base point use a table of its multiples, which
+	// only add for the bits set. They are faster than the default Montgomery
+	// ladder, but take a time depending on the scalar, so this is only for
+	// curves whose scalars are all public. MulDoubleVartime is variable time
+	// on every curve.
+	Vartime Option = iota + 1
+)
+
+// Create a new Point on this curve.
+func (c *ExtendedCurve) Point() abstract.Point {
+	P := new(extPoint)
+	P.c = c
+	//P.Set(&c.null)
+	return P
+}
+
+// Initialize the curve with given parameters and options. The
+// multiplications by scalars run in constant time unless the options have
+// Vartime, on FieldElements for the curves over 2^255-19.
+func (c *ExtendedCurve) Init(p *Param, fullGroup bool, options ...Option) *ExtendedCurve {
+	c.curve.init(c, p, fullGroup, &c.null, &c.base)
+	for _, o := range options {
+		switch o {
+		case Vartime:
+			c.vartime = true
+		}
+	}
+	if !c.vartime && p.P.Cmp(p25519) == 0 {
+		c.field = &fieldParams{}
+		c.field.a.SetInt(&c.a)
+		c.field.d.SetInt(&c.d)
+	}
+	return c
+}
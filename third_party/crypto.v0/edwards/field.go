@@ -0,0 +1,210 @@
+package edwards
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"math/big"
+	"math/bits"
+
+	"gopkg.in/dedis/crypto.v0/nist"
+)
+
+// p25519 is the modulus 2^255-19 of the field of Curve25519.
+var p25519 = new(big.Int).Sub(new(big.Int).Lsh(one, 255), big.NewInt(19))
+
+const mask51 = 1<<51 - 1
+
+// FieldElement is an element of GF(2^255-19) in radix 2^51: the limbs l
+// represent l[0] + 2^51 l[1] + 2^102 l[2] + 2^153 l[3] + 2^204 l[4].
+// Unlike nist.Int, which works on math/big, its operations take the same
+// time and access the same memory whatever the values. The limbs stay below
+// 2^52 after every operation, but the value isn't reduced until Bytes.
+type FieldElement [5]uint64
+
+// SetInt sets v to the value of i, whose modulus must be 2^255-19.
+func (v *FieldElement) SetInt(i *nist.Int) *FieldElement {
+	var b [32]byte
+	buf := i.V.Bytes()
+	for j := range buf {
+		b[len(buf)-1-j] = buf[j]
+	}
+	return v.SetBytes(b[:])
+}
+
+// Int sets i to the value of v, modulo 2^255-19, which is the modulus of i
+// if it has none yet.
+func (v *FieldElement) Int(i *nist.Int) *nist.Int {
+	b := v.Bytes()
+	for j := 0; j < len(b)/2; j++ {
+		b[j], b[len(b)-1-j] = b[len(b)-1-j], b[j]
+	}
+	if i.M == nil {
+		i.M = p25519
+	}
+	i.V.SetBytes(b)
+	return i
+}
+
+// SetBytes sets v to the 32 bytes little-endian b, ignoring its top bit.
+func (v *FieldElement) SetBytes(b []byte) *FieldElement {
+	v[0] = binary.LittleEndian.Uint64(b[0:8]) & mask51
+	v[1] = binary.LittleEndian.Uint64(b[6:14]) >> 3 & mask51
+	v[2] = binary.LittleEndian.Uint64(b[12:20]) >> 6 & mask51
+	v[3] = binary.LittleEndian.Uint64(b[19:27]) >> 1 & mask51
+	v[4] = binary.LittleEndian.Uint64(b[24:32]) >> 12 & mask51
+	return v
+}
+
+// Bytes returns the 32 bytes little-endian encoding of v reduced modulo
+// 2^255-19.
+func (v *FieldElement) Bytes() []byte {
+	t := *v
+	t.reduce()
+	out := make([]byte, 32)
+	var buf [8]byte
+	for i, l := range t {
+		offset := i * 51
+		binary.LittleEndian.PutUint64(buf[:], l<<uint(offset%8))
+		for j, b := range buf {
+			if k := offset/8 + j; k < len(out) {
+				out[k] |= b
+			}
+		}
+	}
+	return out
+}
+
+// Equal tells in constant time whether v and u are the same element.
+func (v *FieldElement) Equal(u *FieldElement) bool {
+	return subtle.ConstantTimeCompare(v.Bytes(), u.Bytes()) == 1
+}
+
+// Zero sets v to 0.
+func (v *FieldElement) Zero() *FieldElement {
+	*v = FieldElement{}
+	return v
+}
+
+// One sets v to 1.
+func (v *FieldElement) One() *FieldElement {
+	*v = FieldElement{1}
+	return v
+}
+
+// Set sets v to u.
+func (v *FieldElement) Set(u *FieldElement) *FieldElement {
+	*v = *u
+	return v
+}
+
+// Add sets v to a + b.
+func (v *FieldElement) Add(a, b *FieldElement) *FieldElement {
+	for i := range v {
+		v[i] = a[i] + b[i]
+	}
+	return v.carry()
+}
+
+// Sub sets v to a - b, adding 2p so that no limb underflows.
+func (v *FieldElement) Sub(a, b *FieldElement) *FieldElement {
+	v[0] = a[0] + 0xFFFFFFFFFFFDA - b[0]
+	for i := 1; i < len(v); i++ {
+		v[i] = a[i] + 0xFFFFFFFFFFFFE - b[i]
+	}
+	return v.carry()
+}
+
+// Neg sets v to -a.
+func (v *FieldElement) Neg(a *FieldElement) *FieldElement {
+	var zero FieldElement
+	return v.Sub(&zero, a)
+}
+
+// Mul sets v to a * b. The products of the limbs beyond 2^255 wrap around
+// multiplied by 19, since 2^255 = 19 modulo p.
+func (v *FieldElement) Mul(a, b *FieldElement) *FieldElement {
+	a0, a1, a2, a3, a4 := a[0], a[1], a[2], a[3], a[4]
+	b0, b1, b2, b3, b4 := b[0], b[1], b[2], b[3], b[4]
+	a1x19, a2x19, a3x19, a4x19 := a1*19, a2*19, a3*19, a4*19
+
+	var r [5]uint128
+	r[0] = mulAdd(a0, b0, a1x19, b4, a2x19, b3, a3x19, b2, a4x19, b1)
+	r[1] = mulAdd(a0, b1, a1, b0, a2x19, b4, a3x19, b3, a4x19, b2)
+	r[2] = mulAdd(a0, b2, a1, b1, a2, b0, a3x19, b4, a4x19, b3)
+	r[3] = mulAdd(a0, b3, a1, b2, a2, b1, a3, b0, a4x19, b4)
+	r[4] = mulAdd(a0, b4, a1, b3, a2, b2, a3, b1, a4, b0)
+
+	// carry the high bits of each 128 bits limb into the next one
+	v[0] = r[0].lo&mask51 + r[4].shr51()*19
+	for i := 1; i < len(v); i++ {
+		v[i] = r[i].lo&mask51 + r[i-1].shr51()
+	}
+	return v.carry()
+}
+
+// Square sets v to a * a.
+func (v *FieldElement) Square(a *FieldElement) *FieldElement {
+	return v.Mul(a, a)
+}
+
+// Swap exchanges v and u if b is 1, and does nothing if it is 0, taking the
+// same time either way.
+func (v *FieldElement) Swap(u *FieldElement, b uint) {
+	m := -uint64(b)
+	for i := range v {
+		t := m & (v[i] ^ u[i])
+		v[i] ^= t
+		u[i] ^= t
+	}
+}
+
+// carry brings the limbs of v back below 2^51, except for the 19 times the
+// carry out of the top limb added to the lowest one.
+func (v *FieldElement) carry() *FieldElement {
+	c0, c1, c2, c3, c4 := v[0]>>51, v[1]>>51, v[2]>>51, v[3]>>51, v[4]>>51
+	v[0] = v[0]&mask51 + c4*19
+	v[1] = v[1]&mask51 + c0
+	v[2] = v[2]&mask51 + c1
+	v[3] = v[3]&mask51 + c2
+	v[4] = v[4]&mask51 + c3
+	return v
+}
+
+// reduce brings v to its canonical value below p.
+func (v *FieldElement) reduce() {
+	v.carry()
+	// v < 2p, so v >= p iff v + 19 >= 2^255, which is the carry out of the
+	// top limb of v + 19
+	c := (v[0] + 19) >> 51
+	for i := 1; i < len(v); i++ {
+		c = (v[i] + c) >> 51
+	}
+	v[0] += 19 * c
+	for i := 0; i < len(v)-1; i++ {
+		v[i+1] += v[i] >> 51
+		v[i] &= mask51
+	}
+	v[4] &= mask51
+}
+
+// uint128 is the result of a product of two limbs.
+type uint128 struct {
+	lo, hi uint64
+}
+
+// mulAdd returns the sum of the products of the pairs of its arguments.
+func mulAdd(ab ...uint64) uint128 {
+	var r uint128
+	for i := 0; i < len(ab); i += 2 {
+		hi, lo := bits.Mul64(ab[i], ab[i+1])
+		var c uint64
+		r.lo, c = bits.Add64(r.lo, lo, 0)
+		r.hi, _ = bits.Add64(r.hi, hi, c)
+	}
+	return r
+}
+
+// shr51 returns r >> 51, which fits on 64 bits for the products of Mul.
+func (r uint128) shr51() uint64 {
+	return r.hi<<(64-51) | r.lo>>51
+}
@@ -0,0 +1,62 @@
+package edwards
+
+import (
+	"crypto/cipher"
+	"crypto/sha256"
+	"hash"
+	"io"
+	"reflect"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/cipher/sha3"
+	"gopkg.in/dedis/crypto.v0/random"
+)
+
+type suiteEd25519 struct {
+	//ed25519.Curve
+	abstract.Group // a *ProjectiveCurve or an *ExtendedCurve
+}
+
+// XXX non-NIST ciphers?
+
+// SHA256 hash function
+func (s *suiteEd25519) Hash() hash.Hash {
+	return sha256.New()
+}
+
+// SHA3/SHAKE128 Sponge Cipher
+func (s *suiteEd25519) Cipher(key []byte, options ...interface{}) abstract.Cipher {
+	return sha3.NewShakeCipher128(key, options...)
+}
+
+func (s *suiteEd25519) Read(r io.Reader, objs ...interface{}) error {
+	return abstract.SuiteRead(s, r, objs)
+}
+
+func (s *suiteEd25519) Write(w io.Writer, objs ...interface{}) error {
+	return abstract.SuiteWrite(s, w, objs)
+}
+
+func (s *suiteEd25519) New(t reflect.Type) interface{} {
+	return abstract.SuiteNew(s, t)
+}
+
+func (s *suiteEd25519) NewKey(rand cipher.Stream) abstract.Scalar {
+	if rand == nil {
+		rand = random.Stream
+	}
+	return s.Scalar().Pick(rand)
+}
+
+// Ciphersuite based on AES-128, SHA-256, and the Ed25519 curve, whose
+// multiplications by scalars run in constant time.
+func NewAES128SHA256Ed25519(fullGroup bool) abstract.Suite {
+	return NewAES128SHA256Ed25519Options(fullGroup)
+}
+
+// NewAES128SHA256Ed25519Options is NewAES128SHA256Ed25519 on an
+// ExtendedCurve with the options, e.g. Vartime for a suite only used with
+// public scalars.
+func NewAES128SHA256Ed25519Options(fullGroup bool, options ...Option) abstract.Suite {
+	return &suiteEd25519{new(ExtendedCurve).Init(Param25519(), fullGroup, options...)}
+}
@@ -0,0 +1,9 @@
+module gopkg.in/dedis/crypto.v0
+
+go 1.12
+
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/dedis/protobuf v0.0.0-20160530135157-2e57622aa24e
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+)